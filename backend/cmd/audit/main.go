@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"harama/internal/auth"
+	"harama/internal/config"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/google/uuid"
+)
+
+const usage = `usage: audit <command> [flags]
+
+commands:
+  verify      -tenant <id>               verify a tenant's audit chain
+  checkpoint  [-tenant <id>]             sign the chain head of one tenant, or all
+  export      -tenant <id> [-out file]   write a tenant's signed checkpoints as JSON
+  keygen                                 print a new AUDIT_SIGNING_KEY`
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	cmd := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	var tenant, out string
+	cmd.StringVar(&tenant, "tenant", "", "Tenant ID")
+	cmd.StringVar(&out, "out", "", "Output file (default stdout)")
+	cmd.Parse(os.Args[2:])
+
+	if os.Args[1] == "keygen" {
+		keygen()
+		return
+	}
+
+	cfg := config.Load()
+	key, err := service.ParseSigningKey(cfg.AuditSigningKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := postgres.Connect(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	audit := service.NewAuditService(postgres.NewAuditRepo(db), key)
+	ctx := context.Background()
+	if tenant != "" {
+		tenantID, err := uuid.Parse(tenant)
+		if err != nil {
+			log.Fatalf("Invalid tenant id: %v", err)
+		}
+		ctx = auth.WithTenantID(ctx, tenantID)
+	}
+
+	switch os.Args[1] {
+	case "verify":
+		requireTenant(tenant)
+		report, err := audit.VerifyChain(ctx)
+		if err != nil {
+			log.Fatalf("Verification failed: %v", err)
+		}
+		writeJSON("", report)
+		if !report.Valid {
+			os.Exit(1)
+		}
+	case "checkpoint":
+		if tenant == "" {
+			made, err := audit.CheckpointAll(ctx)
+			log.Printf("%d checkpoints created", made)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		cp, err := audit.CreateCheckpoint(ctx)
+		if err != nil {
+			log.Fatalf("Checkpoint failed: %v", err)
+		}
+		if cp == nil {
+			log.Println("Audit chain is empty; no checkpoint taken")
+			return
+		}
+		writeJSON("", cp)
+	case "export":
+		requireTenant(tenant)
+		export, err := audit.ExportCheckpoints(ctx)
+		if err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		writeJSON(out, export)
+	default:
+		log.Fatalf("Unknown command %q\n%s", os.Args[1], usage)
+	}
+}
+
+func requireTenant(tenant string) {
+	if tenant == "" {
+		log.Fatalf("-tenant is required\n%s", usage)
+	}
+}
+
+func keygen() {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		log.Fatal(err)
+	}
+	pub := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	fmt.Printf("AUDIT_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(seed))
+	fmt.Printf("# public key %s (key id %s)\n", base64.StdEncoding.EncodeToString(pub), service.KeyID(pub))
+}
+
+func writeJSON(path string, v any) {
+	w := os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatal(err)
+	}
+}
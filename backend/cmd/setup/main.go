@@ -15,8 +15,10 @@ import (
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/driver/pgdriver"
+	"harama/internal/auth"
 	"harama/internal/config"
 	"harama/internal/domain"
+	"harama/internal/repository/postgres"
 )
 
 func main() {
@@ -97,83 +99,100 @@ func seedDemoData(ctx context.Context, db *bun.DB) error {
 		return fmt.Errorf("failed to create tenant: %w", err)
 	}
 
-	// 1. Create Exam
-	exam := &domain.Exam{
-		ID:        uuid.New(),
-		TenantID:  tenantID,
-		Title:     "Physics 101: Midterm",
-		Subject:   "physics",
-		CreatedAt: time.Now(),
-	}
-	if _, err := db.NewInsert().Model(exam).Exec(ctx); err != nil {
-		return err
+	// Make an existing identity provider account the tenant's admin
+	if v := os.Getenv("SEED_ADMIN_USER_ID"); v != "" {
+		adminID, err := uuid.Parse(v)
+		if err != nil {
+			return fmt.Errorf("invalid SEED_ADMIN_USER_ID: %w", err)
+		}
+		admin := &domain.User{ID: adminID, TenantID: tenantID, Role: domain.RoleAdmin}
+		if _, err := db.NewInsert().Model(admin).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to create admin user: %w", err)
+		}
 	}
 
-	// 2. Create Question (Ambiguous Logic)
-	questionID := uuid.New()
-	question := &domain.Question{
-		ID:           questionID,
-		ExamID:       exam.ID,
-		QuestionText: "Explain why a satellite does not fall to the Earth while in orbit.",
-		Points:       10,
-		AnswerType:   domain.AnswerTypeEssay,
-	}
-	if _, err := db.NewInsert().Model(question).Exec(ctx); err != nil {
-		return err
-	}
+	// Everything the tenant owns is written in its scope, as row level
+	// security requires
+	ctx = auth.WithTenantID(ctx, tenantID)
+	return postgres.InTenantTx(ctx, db, func(ctx context.Context, tx bun.IDB) error {
+		// 1. Create Exam
+		exam := &domain.Exam{
+			ID:        uuid.New(),
+			TenantID:  tenantID,
+			Title:     "Physics 101: Midterm",
+			Subject:   "physics",
+			CreatedAt: time.Now(),
+		}
+		if _, err := tx.NewInsert().Model(exam).Exec(ctx); err != nil {
+			return err
+		}
 
-	// 3. Create Rubric
-	rubric := &domain.Rubric{
-		ID:         uuid.New(),
-		QuestionID: questionID,
-		FullCreditCriteria: []domain.Criterion{
-			{ID: "c1", Description: "Mentions gravitational force acts as centripetal force", Points: 5},
-			{ID: "c2", Description: "Mentions high tangential velocity", Points: 3},
-			{ID: "c3", Description: "Explains 'falling around the Earth'", Points: 2},
-		},
-		PartialCreditRules: []domain.PartialCreditRule{},
-		CommonMistakes: []domain.CommonMistake{
-			{ID: "m1", Description: "Says there is no gravity in space", Penalty: 5},
-		},
-	}
-	if _, err := db.NewInsert().Model(rubric).Exec(ctx); err != nil {
-		return err
-	}
+		// 2. Create Question (Ambiguous Logic)
+		questionID := uuid.New()
+		question := &domain.Question{
+			ID:           questionID,
+			ExamID:       exam.ID,
+			QuestionText: "Explain why a satellite does not fall to the Earth while in orbit.",
+			Points:       10,
+			AnswerType:   domain.AnswerTypeEssay,
+		}
+		if _, err := tx.NewInsert().Model(question).Exec(ctx); err != nil {
+			return err
+		}
 
-	// 4. Create Submission (The "High Variance" Student)
-	subID := uuid.New()
-	submission := &domain.Submission{
-		ID:               subID,
-		ExamID:           exam.ID,
-		StudentID:        "student_123",
-		UploadedAt:       time.Now(),
-		ProcessingStatus: domain.StatusPending,
-		OCRResults:       []domain.OCRResult{},
-		TenantID:         tenantID,
-	}
-	if _, err := db.NewInsert().Model(submission).Exec(ctx); err != nil {
-		return err
-	}
+		// 3. Create Rubric
+		rubric := &domain.Rubric{
+			ID:         uuid.New(),
+			QuestionID: questionID,
+			FullCreditCriteria: []domain.Criterion{
+				{ID: "c1", Description: "Mentions gravitational force acts as centripetal force", Points: 5},
+				{ID: "c2", Description: "Mentions high tangential velocity", Points: 3},
+				{ID: "c3", Description: "Explains 'falling around the Earth'", Points: 2},
+			},
+			PartialCreditRules: []domain.PartialCreditRule{},
+			CommonMistakes: []domain.CommonMistake{
+				{ID: "m1", Description: "Says there is no gravity in space", Penalty: 5},
+			},
+		}
+		if _, err := tx.NewInsert().Model(rubric).Exec(ctx); err != nil {
+			return err
+		}
 
-	// 5. Create Answer (Correct logic, terrible formatting)
-	// This should trigger:
-	// - Rubric Enforcer: Low score (missing keywords, messy)
-	// - Reasoning Validator: High score (concept is right)
-	answer := &domain.AnswerSegment{
-		SubmissionID: subID,
-		QuestionID:   questionID,
-		Text:         "its falling but moving sideways fast enough that it misses the ground. like gravity pulls it down but it goes forward so it curves matching the earth.",
-		PageIndices:  []int{1},
-	}
+		// 4. Create Submission (The "High Variance" Student)
+		subID := uuid.New()
+		submission := &domain.Submission{
+			ID:               subID,
+			ExamID:           exam.ID,
+			StudentID:        "student_123",
+			UploadedAt:       time.Now(),
+			ProcessingStatus: domain.StatusPending,
+			OCRResults:       []domain.OCRResult{},
+			TenantID:         tenantID,
+		}
+		if _, err := tx.NewInsert().Model(submission).Exec(ctx); err != nil {
+			return err
+		}
+
+		// 5. Create Answer (Correct logic, terrible formatting)
+		// This should trigger:
+		// - Rubric Enforcer: Low score (missing keywords, messy)
+		// - Reasoning Validator: High score (concept is right)
+		answer := &domain.AnswerSegment{
+			SubmissionID: subID,
+			QuestionID:   questionID,
+			Text:         "its falling but moving sideways fast enough that it misses the ground. like gravity pulls it down but it goes forward so it curves matching the earth.",
+			PageIndices:  []int{1},
+		}
 	
-	// We need to manually insert answer into submissions table jsonb column or a separate table depending on schema.
-	// Based on domain model `Submission` struct has `Answers []AnswerSegment`.
-	// In Postgres repo, we usually store this as a JSONB column on submission or separate table.
-	// Looking at `001_initial_schema.up.sql` (inferred), let's assume `answers` is a JSONB column on `submissions`.
+		// We need to manually insert answer into submissions table jsonb column or a separate table depending on schema.
+		// Based on domain model `Submission` struct has `Answers []AnswerSegment`.
+		// In Postgres repo, we usually store this as a JSONB column on submission or separate table.
+		// Looking at `001_initial_schema.up.sql` (inferred), let's assume `answers` is a JSONB column on `submissions`.
 	
-	submission.Answers = []domain.AnswerSegment{*answer}
-	_, err := db.NewUpdate().Model(submission).Column("answers").WherePK().Exec(ctx)
+		submission.Answers = []domain.AnswerSegment{*answer}
+		_, err := tx.NewUpdate().Model(submission).Column("answers").WherePK().Exec(ctx)
 
-	fmt.Printf("Created Submission ID: %s\n", subID)
-	return err
+		fmt.Printf("Created Submission ID: %s\n", subID)
+		return err
+	})
 }
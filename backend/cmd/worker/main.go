@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"harama/internal/config"
+	"harama/internal/lti"
 	"harama/internal/repository/postgres"
+	"harama/internal/service"
 	"harama/internal/worker"
 )
 
@@ -27,6 +31,38 @@ func main() {
 	pool.Start()
 	log.Println("Worker pool started with 10 workers")
 
+	// Sign each tenant's audit chain head periodically
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	auditKey, err := service.ParseSigningKey(cfg.AuditSigningKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auditKey != nil && cfg.AuditCheckpointInterval > 0 {
+		audit := service.NewAuditService(postgres.NewAuditRepo(db), auditKey)
+		go checkpointAudit(ctx, audit, cfg.AuditCheckpointInterval)
+		log.Printf("Audit checkpoints every %s", cfg.AuditCheckpointInterval)
+	}
+
+	// Drain the webhook outbox and send due deliveries
+	if cfg.WebhookPollInterval > 0 {
+		webhooks := service.NewWebhookService(postgres.NewWebhookRepo(db), postgres.NewAuditRepo(db), cfg.WebhookTimeout, cfg.WebhookAllowPrivate)
+		go deliverWebhooks(ctx, webhooks, cfg.WebhookPollInterval)
+		log.Printf("Webhook deliveries every %s", cfg.WebhookPollInterval)
+	}
+
+	// Publish queued scores to LMS gradebooks
+	if cfg.LTIEnabled() && cfg.LTIPollInterval > 0 {
+		key, err := lti.ReadPrivateKey(cfg.LTIToolKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tool := lti.NewTool(cfg.LTIToolURL, key, cfg.LTITimeout)
+		scores := service.NewLTIService(postgres.NewLTIRepo(db), postgres.NewExamRepo(db), postgres.NewAuditRepo(db), tool)
+		go pushScores(ctx, scores, cfg.LTIPollInterval)
+		log.Printf("LTI score pushes every %s", cfg.LTIPollInterval)
+	}
+
 	// Wait for shutdown signal
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
@@ -36,3 +72,60 @@ func main() {
 	pool.Stop()
 	log.Println("Workers stopped")
 }
+
+func checkpointAudit(ctx context.Context, audit *service.AuditService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if made, err := audit.CheckpointAll(ctx); err != nil {
+			log.Printf("Audit checkpoints: %v", err)
+		} else if made > 0 {
+			log.Printf("Audit checkpoints: %d created", made)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func deliverWebhooks(ctx context.Context, webhooks *service.WebhookService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := webhooks.DispatchEvents(ctx); err != nil {
+			log.Printf("Webhook dispatch: %v", err)
+		}
+		if sent, err := webhooks.DeliverDue(ctx); err != nil {
+			log.Printf("Webhook deliveries: %v", err)
+		} else if sent > 0 {
+			log.Printf("Webhook deliveries: %d attempted", sent)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func pushScores(ctx context.Context, scores *service.LTIService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if pushed, err := scores.PushDue(ctx); err != nil {
+			log.Printf("LTI score pushes: %v", err)
+		} else if pushed > 0 {
+			log.Printf("LTI score pushes: %d attempted", pushed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
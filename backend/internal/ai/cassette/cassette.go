@@ -0,0 +1,127 @@
+// Package cassette records AI and OCR calls to disk and replays them, so a
+// production grade can be reproduced locally, prompt refactors can be
+// regression-tested and integration tests can run without an API key.
+//
+// Each call is keyed by a hash of its kind, request and resolved generation
+// settings. The rendered prompt is stored alongside for inspection but is not
+// part of the key, so a replay can report that a prompt changed instead of
+// simply missing.
+package cassette
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"harama/internal/domain"
+)
+
+// ErrNotRecorded is returned by a replayer for a call missing from the store.
+var ErrNotRecorded = errors.New("cassette: no recording for request")
+
+// Kind names the method a recording belongs to.
+type Kind string
+
+const (
+	KindGrade    Kind = "grade"
+	KindFeedback Kind = "feedback"
+	KindAnalysis Kind = "analysis"
+	KindRefine   Kind = "refine_rubric"
+	KindDraft    Kind = "draft_rubric"
+	KindExtract  Kind = "extract_questions"
+	KindOCR      Kind = "ocr"
+)
+
+// Entry is one recorded call.
+type Entry struct {
+	Key        string                     `json:"key"`
+	Kind       Kind                       `json:"kind"`
+	Request    json.RawMessage            `json:"request"`
+	Settings   *domain.GenerationSettings `json:"settings,omitempty"`
+	Prompt     string                     `json:"prompt,omitempty"`
+	Response   json.RawMessage            `json:"response,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+	RecordedAt time.Time                  `json:"recorded_at"`
+}
+
+// Store persists entries by key.
+type Store interface {
+	Get(key string) (*Entry, error) // ErrNotRecorded when absent
+	Put(entry *Entry) error
+}
+
+// Key hashes what identifies a call. Requests are marshalled as JSON, which
+// is deterministic for structs and sorts map keys.
+func Key(kind Kind, request any, settings *domain.GenerationSettings) (string, json.RawMessage, error) {
+	reqJSON, err := json.Marshal(request)
+	if err != nil {
+		return "", nil, fmt.Errorf("cassette: marshal request: %w", err)
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return "", nil, fmt.Errorf("cassette: marshal settings: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(kind))
+	h.Write([]byte{0})
+	h.Write(reqJSON)
+	h.Write([]byte{0})
+	h.Write(settingsJSON)
+	return string(kind) + "-" + hex.EncodeToString(h.Sum(nil))[:32], reqJSON, nil
+}
+
+// DirStore keeps one indented JSON file per entry in a directory, which
+// diffs well when fixtures are committed.
+type DirStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cassette: create store: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (s *DirStore) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+func (s *DirStore) Get(key string) (*Entry, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w (%s)", ErrNotRecorded, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("cassette: corrupt entry %s: %w", key, err)
+	}
+	return &entry, nil
+}
+
+func (s *DirStore) Put(entry *Entry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Write-then-rename so a concurrent reader never sees half a file
+	tmp := s.path(entry.Key) + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(entry.Key))
+}
@@ -0,0 +1,157 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"harama/internal/ai"
+	"harama/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type scriptedProvider struct {
+	calls int
+}
+
+func (p *scriptedProvider) Grade(ctx context.Context, req ai.GradingRequest) (domain.GradingResult, error) {
+	p.calls++
+	return domain.GradingResult{Score: 7, MaxScore: 10, Reasoning: "graded " + req.EvaluatorID, AIEvaluatorID: req.EvaluatorID}, nil
+}
+
+func (p *scriptedProvider) GenerateFeedback(ctx context.Context, req ai.FeedbackRequest) (string, error) {
+	p.calls++
+	return "", errors.New("upstream down")
+}
+
+func (p *scriptedProvider) AnalyzePatterns(ctx context.Context, req ai.AnalysisRequest) (ai.AnalysisResult, error) {
+	p.calls++
+	return ai.AnalysisResult{Recommendation: "clarify c2"}, nil
+}
+
+func (p *scriptedProvider) RefineRubric(ctx context.Context, req ai.RefineRubricRequest) (domain.Rubric, error) {
+	p.calls++
+	return req.CurrentRubric, nil
+}
+
+func (p *scriptedProvider) DraftRubric(ctx context.Context, req ai.DraftRubricRequest) (domain.Rubric, error) {
+	p.calls++
+	return domain.Rubric{GradingNotes: req.QuestionText}, nil
+}
+
+func (p *scriptedProvider) ExtractQuestions(ctx context.Context, req ai.ExtractQuestionsRequest) ([]ai.ExtractedQuestion, error) {
+	p.calls++
+	return []ai.ExtractedQuestion{{Number: "1", Text: req.Pages[0], Points: 1, Page: 1}}, nil
+}
+
+type scriptedOCR struct{}
+
+func (scriptedOCR) ExtractText(ctx context.Context, fileBytes []byte, mimeType string) (*domain.OCRResult, error) {
+	return &domain.OCRResult{RawText: "x = 4", Confidence: 0.9}, nil
+}
+
+func gradingRequest(evaluator string) ai.GradingRequest {
+	return ai.GradingRequest{
+		Answer:       domain.AnswerSegment{Text: "x = 4"},
+		EvaluatorID:  evaluator,
+		Subject:      "mathematics",
+		QuestionText: "Solve x + 1 = 5",
+	}
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDirStore(t.TempDir())
+	require.NoError(t, err)
+
+	inner := &scriptedProvider{}
+	recorder, err := Record(inner, store, nil)
+	require.NoError(t, err)
+	ocrRecorder, err := RecordOCR(scriptedOCR{}, store, nil)
+	require.NoError(t, err)
+
+	recorded, err := recorder.Grade(ctx, gradingRequest("rubric_enforcer"))
+	require.NoError(t, err)
+	_, err = recorder.GenerateFeedback(ctx, ai.FeedbackRequest{StudentName: "A"})
+	require.Error(t, err, "failures pass through")
+	_, err = ocrRecorder.ExtractText(ctx, []byte("page"), "image/png")
+	require.NoError(t, err)
+
+	replayer, err := NewReplayer(store, nil)
+	require.NoError(t, err)
+
+	replayed, err := replayer.Grade(ctx, gradingRequest("rubric_enforcer"))
+	require.NoError(t, err)
+	assert.Equal(t, recorded, replayed)
+	assert.Equal(t, 2, inner.calls, "replay never reaches the backend")
+
+	ocrResult, err := replayer.ExtractText(ctx, []byte("page"), "image/png")
+	require.NoError(t, err)
+	assert.Equal(t, "x = 4", ocrResult.RawText)
+
+	// A different evaluator resolves different settings, so a different key
+	_, err = replayer.Grade(ctx, gradingRequest("reasoning_validator"))
+	assert.ErrorIs(t, err, ErrNotRecorded)
+	_, err = replayer.ExtractText(ctx, []byte("other page"), "image/png")
+	assert.ErrorIs(t, err, ErrNotRecorded)
+	// Failed calls are not recorded
+	_, err = replayer.GenerateFeedback(ctx, ai.FeedbackRequest{StudentName: "A"})
+	assert.ErrorIs(t, err, ErrNotRecorded)
+}
+
+func TestKey_IncludesSettings(t *testing.T) {
+	temperature := 0.9
+	models, err := ai.NewModelRegistry(map[string]domain.GenerationSettings{"grading": {Model: "other-model"}})
+	require.NoError(t, err)
+	defaults, err := ai.NewModelRegistry(nil)
+	require.NoError(t, err)
+
+	req := gradingRequest("rubric_enforcer")
+	a := defaults.Resolve(ai.TaskGrading, req.EvaluatorID)
+	b := models.Resolve(ai.TaskGrading, req.EvaluatorID)
+	keyA, _, err := Key(KindGrade, req, &a)
+	require.NoError(t, err)
+	keyB, _, err := Key(KindGrade, req, &b)
+	require.NoError(t, err)
+	assert.NotEqual(t, keyA, keyB)
+
+	again, _, err := Key(KindGrade, req, &a)
+	require.NoError(t, err)
+	assert.Equal(t, keyA, again)
+
+	a.Temperature = &temperature
+	keyC, _, err := Key(KindGrade, req, &a)
+	require.NoError(t, err)
+	assert.NotEqual(t, keyA, keyC)
+}
+
+func TestReplayer_StrictPrompts(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDirStore(t.TempDir())
+	require.NoError(t, err)
+
+	recorder, err := Record(&scriptedProvider{}, store, nil)
+	require.NoError(t, err)
+	_, err = recorder.Grade(ctx, gradingRequest("structural_analyzer"))
+	require.NoError(t, err)
+
+	// Simulate a prompt refactor by editing the stored prompt
+	c := recorder.calls.grade(gradingRequest("structural_analyzer"))
+	key, _, err := Key(c.kind, c.request, &c.settings)
+	require.NoError(t, err)
+	entry, err := store.Get(key)
+	require.NoError(t, err)
+	entry.Prompt = "an older prompt"
+	require.NoError(t, store.Put(entry))
+
+	replayer, err := NewReplayer(store, nil)
+	require.NoError(t, err)
+	_, err = replayer.Grade(ctx, gradingRequest("structural_analyzer"))
+	require.NoError(t, err, "lenient replay ignores prompt drift")
+
+	replayer.StrictPrompts = true
+	_, err = replayer.Grade(ctx, gradingRequest("structural_analyzer"))
+	assert.ErrorIs(t, err, ErrPromptChanged)
+}
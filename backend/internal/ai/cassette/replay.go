@@ -0,0 +1,258 @@
+package cassette
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"harama/internal/ai"
+	"harama/internal/ai/prompts"
+	"harama/internal/domain"
+	"harama/internal/pkg/utils"
+)
+
+// ErrPromptChanged is returned by a strict replayer when a recording exists
+// but the prompt rendered today differs from the one that was sent.
+var ErrPromptChanged = errors.New("cassette: prompt differs from recording")
+
+// call is everything that identifies one request.
+type call struct {
+	kind     Kind
+	request  any
+	settings domain.GenerationSettings
+	prompt   string
+}
+
+// ocrRequest stands in for the page image, which is too large to keep in
+// every entry; its digest identifies it.
+type ocrRequest struct {
+	MIMEType string `json:"mime_type"`
+	SHA256   string `json:"sha256"`
+	Size     int    `json:"size"`
+}
+
+type calls struct {
+	models *ai.ModelRegistry
+}
+
+func newCalls(models *ai.ModelRegistry) (calls, error) {
+	if models == nil {
+		var err error
+		if models, err = ai.NewModelRegistry(nil); err != nil {
+			return calls{}, err
+		}
+	}
+	return calls{models: models}, nil
+}
+
+func (c calls) grade(req ai.GradingRequest) call {
+	return call{KindGrade, req, c.models.Resolve(ai.TaskGrading, req.EvaluatorID), prompts.Grading(req)}
+}
+
+func (c calls) feedback(req ai.FeedbackRequest) call {
+	return call{KindFeedback, req, c.models.Resolve(ai.TaskFeedback, ""), prompts.Feedback(req)}
+}
+
+func (c calls) analysis(req ai.AnalysisRequest) call {
+	return call{KindAnalysis, req, c.models.Resolve(ai.TaskAnalysis, ""), prompts.Analysis(req)}
+}
+
+func (c calls) refine(req ai.RefineRubricRequest) call {
+	return call{KindRefine, req, c.models.Resolve(ai.TaskRefinement, ""), prompts.RefineRubric(req)}
+}
+
+func (c calls) draft(req ai.DraftRubricRequest) call {
+	return call{KindDraft, req, c.models.Resolve(ai.TaskDraft, ""), prompts.DraftRubric(req)}
+}
+
+func (c calls) extract(req ai.ExtractQuestionsRequest) call {
+	return call{KindExtract, req, c.models.Resolve(ai.TaskExtraction, ""), prompts.ExtractQuestions(req)}
+}
+
+func (c calls) ocr(fileBytes []byte, mimeType string) call {
+	sum := sha256.Sum256(fileBytes)
+	req := ocrRequest{MIMEType: mimeType, SHA256: hex.EncodeToString(sum[:]), Size: len(fileBytes)}
+	return call{kind: KindOCR, request: req, settings: c.models.Resolve(ai.TaskOCR, "")}
+}
+
+// Recorder passes calls through to a real backend and stores every
+// successful response. Wrap the outermost provider so that retries are not
+// recorded as separate calls. Recordings contain student answers and must be
+// stored with the same care as the database.
+type Recorder struct {
+	provider ai.Provider
+	store    Store
+	calls    calls
+}
+
+var _ ai.Provider = (*Recorder)(nil)
+
+// Record wraps a provider. models must be the registry the provider uses so
+// that entries carry the settings the calls ran with.
+func Record(provider ai.Provider, store Store, models *ai.ModelRegistry) (*Recorder, error) {
+	c, err := newCalls(models)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{provider: provider, store: store, calls: c}, nil
+}
+
+func record[T any](store Store, c call, fn func() (T, error)) (T, error) {
+	res, err := fn()
+	if err != nil {
+		return res, err
+	}
+
+	key, reqJSON, keyErr := Key(c.kind, c.request, &c.settings)
+	if keyErr == nil {
+		var respJSON []byte
+		respJSON, keyErr = json.Marshal(res)
+		if keyErr == nil {
+			keyErr = store.Put(&Entry{
+				Key:        key,
+				Kind:       c.kind,
+				Request:    reqJSON,
+				Settings:   &c.settings,
+				Prompt:     c.prompt,
+				Response:   respJSON,
+				RecordedAt: utils.CurrentTime(),
+			})
+		}
+	}
+	// Recording is a debugging aid and must never fail the call itself
+	if keyErr != nil {
+		log.Printf("failed to record %s call: %v", c.kind, keyErr)
+	}
+	return res, nil
+}
+
+func (r *Recorder) Grade(ctx context.Context, req ai.GradingRequest) (domain.GradingResult, error) {
+	return record(r.store, r.calls.grade(req), func() (domain.GradingResult, error) {
+		return r.provider.Grade(ctx, req)
+	})
+}
+
+func (r *Recorder) GenerateFeedback(ctx context.Context, req ai.FeedbackRequest) (string, error) {
+	return record(r.store, r.calls.feedback(req), func() (string, error) {
+		return r.provider.GenerateFeedback(ctx, req)
+	})
+}
+
+func (r *Recorder) AnalyzePatterns(ctx context.Context, req ai.AnalysisRequest) (ai.AnalysisResult, error) {
+	return record(r.store, r.calls.analysis(req), func() (ai.AnalysisResult, error) {
+		return r.provider.AnalyzePatterns(ctx, req)
+	})
+}
+
+func (r *Recorder) RefineRubric(ctx context.Context, req ai.RefineRubricRequest) (domain.Rubric, error) {
+	return record(r.store, r.calls.refine(req), func() (domain.Rubric, error) {
+		return r.provider.RefineRubric(ctx, req)
+	})
+}
+
+func (r *Recorder) DraftRubric(ctx context.Context, req ai.DraftRubricRequest) (domain.Rubric, error) {
+	return record(r.store, r.calls.draft(req), func() (domain.Rubric, error) {
+		return r.provider.DraftRubric(ctx, req)
+	})
+}
+
+func (r *Recorder) ExtractQuestions(ctx context.Context, req ai.ExtractQuestionsRequest) ([]ai.ExtractedQuestion, error) {
+	return record(r.store, r.calls.extract(req), func() ([]ai.ExtractedQuestion, error) {
+		return r.provider.ExtractQuestions(ctx, req)
+	})
+}
+
+// OCRRecorder is Recorder for OCR backends.
+type OCRRecorder struct {
+	processor ai.OCRProcessor
+	store     Store
+	calls     calls
+}
+
+var _ ai.OCRProcessor = (*OCRRecorder)(nil)
+
+func RecordOCR(processor ai.OCRProcessor, store Store, models *ai.ModelRegistry) (*OCRRecorder, error) {
+	c, err := newCalls(models)
+	if err != nil {
+		return nil, err
+	}
+	return &OCRRecorder{processor: processor, store: store, calls: c}, nil
+}
+
+func (r *OCRRecorder) ExtractText(ctx context.Context, fileBytes []byte, mimeType string) (*domain.OCRResult, error) {
+	return record(r.store, r.calls.ocr(fileBytes, mimeType), func() (*domain.OCRResult, error) {
+		return r.processor.ExtractText(ctx, fileBytes, mimeType)
+	})
+}
+
+// Replayer serves recorded responses and never calls a backend. It is both
+// an ai.Provider and an ai.OCRProcessor.
+type Replayer struct {
+	store Store
+	calls calls
+	// StrictPrompts fails calls whose rendered prompt no longer matches the
+	// recording; use it to catch unintended prompt changes in tests.
+	StrictPrompts bool
+}
+
+var _ ai.Provider = (*Replayer)(nil)
+var _ ai.OCRProcessor = (*Replayer)(nil)
+
+func NewReplayer(store Store, models *ai.ModelRegistry) (*Replayer, error) {
+	c, err := newCalls(models)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{store: store, calls: c}, nil
+}
+
+func replay[T any](r *Replayer, c call) (T, error) {
+	var res T
+	key, _, err := Key(c.kind, c.request, &c.settings)
+	if err != nil {
+		return res, err
+	}
+	entry, err := r.store.Get(key)
+	if err != nil {
+		return res, err
+	}
+	if r.StrictPrompts && entry.Prompt != c.prompt {
+		return res, fmt.Errorf("%w (%s)", ErrPromptChanged, key)
+	}
+	if err := json.Unmarshal(entry.Response, &res); err != nil {
+		return res, fmt.Errorf("cassette: corrupt response in %s: %w", key, err)
+	}
+	return res, nil
+}
+
+func (r *Replayer) Grade(ctx context.Context, req ai.GradingRequest) (domain.GradingResult, error) {
+	return replay[domain.GradingResult](r, r.calls.grade(req))
+}
+
+func (r *Replayer) GenerateFeedback(ctx context.Context, req ai.FeedbackRequest) (string, error) {
+	return replay[string](r, r.calls.feedback(req))
+}
+
+func (r *Replayer) AnalyzePatterns(ctx context.Context, req ai.AnalysisRequest) (ai.AnalysisResult, error) {
+	return replay[ai.AnalysisResult](r, r.calls.analysis(req))
+}
+
+func (r *Replayer) RefineRubric(ctx context.Context, req ai.RefineRubricRequest) (domain.Rubric, error) {
+	return replay[domain.Rubric](r, r.calls.refine(req))
+}
+
+func (r *Replayer) DraftRubric(ctx context.Context, req ai.DraftRubricRequest) (domain.Rubric, error) {
+	return replay[domain.Rubric](r, r.calls.draft(req))
+}
+
+func (r *Replayer) ExtractQuestions(ctx context.Context, req ai.ExtractQuestionsRequest) ([]ai.ExtractedQuestion, error) {
+	return replay[[]ai.ExtractedQuestion](r, r.calls.extract(req))
+}
+
+func (r *Replayer) ExtractText(ctx context.Context, fileBytes []byte, mimeType string) (*domain.OCRResult, error) {
+	return replay[*domain.OCRResult](r, r.calls.ocr(fileBytes, mimeType))
+}
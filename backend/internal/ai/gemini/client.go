@@ -1,69 +1,78 @@
 package gemini
 
 import (
-	"bytes"
 	"context"
-	"embed"
 	"encoding/json"
 	"fmt"
-	"path/filepath"
-	"strings"
-	"text/template"
 
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
 
 	"harama/internal/ai"
+	"harama/internal/ai/prompts"
 	"harama/internal/domain"
 	"harama/internal/grading/profiles"
 )
 
-//go:embed prompts/*.txt
-var promptsFS embed.FS
-
 type Client struct {
-    client *genai.Client
-    model  *genai.GenerativeModel
+	client *genai.Client
+	models *ai.ModelRegistry
 }
 
-func NewClient(apiKey string) (*Client, error) {
-    ctx := context.Background()
-    client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
-    if err != nil {
-        return nil, err
-    }
-    
-    model := client.GenerativeModel("gemini-3-flash-preview")
-    model.SetTemperature(0.2)
-    model.SetTopK(40)
-    model.SetTopP(0.95)
-    
-    return &Client{
-        client: client,
-        model:  model,
-    }, nil
+// NewClient uses models for every call's generation settings; nil means the
+// built-in defaults.
+func NewClient(apiKey string, models *ai.ModelRegistry) (*Client, error) {
+	if models == nil {
+		var err error
+		if models, err = ai.NewModelRegistry(nil); err != nil {
+			return nil, err
+		}
+	}
+	for key, s := range models.Entries() {
+		if _, err := safetySettings(s.Safety); err != nil {
+			return nil, fmt.Errorf("model registry entry %q: %w", key, err)
+		}
+	}
+
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		client: client,
+		models: models,
+	}, nil
 }
 
-func (c *Client) Grade(ctx context.Context, req ai.GradingRequest) (domain.GradingResult, error) {
-	// Load appropriate profile
-	profile, ok := profiles.Evaluators[req.EvaluatorID]
-	if !ok {
-		return domain.GradingResult{}, fmt.Errorf("evaluator profile not found: %s", req.EvaluatorID)
+// generate runs one call with the registry's settings for the task and
+// returns the settings it used alongside the response.
+func (c *Client) generate(ctx context.Context, task ai.Task, evaluatorID string, parts ...genai.Part) (*genai.GenerateContentResponse, domain.GenerationSettings, error) {
+	settings := c.models.Resolve(task, evaluatorID)
+	model, err := NewModel(c.client, settings)
+	if err != nil {
+		return nil, settings, err
 	}
 
-	// Load appropriate prompt template
-	promptTemplate := loadPromptTemplate(req.EvaluatorID)
+	resp, err := model.GenerateContent(ctx, parts...)
+	if err != nil {
+		return nil, settings, ClassifyError(err)
+	}
+	ReportUsage(ctx, settings.Model, resp)
+	return resp, settings, nil
+}
 
-	// Build prompt
-	prompt := buildGradingPrompt(promptTemplate, req.Answer, req.Rubric, req.Subject, req.QuestionText)
+func (c *Client) Grade(ctx context.Context, req ai.GradingRequest) (domain.GradingResult, error) {
+	if _, ok := profiles.Evaluators[req.EvaluatorID]; !ok {
+		return domain.GradingResult{}, fmt.Errorf("evaluator profile not found: %s", req.EvaluatorID)
+	}
 
-	// Create a local model instance to safely set temperature for this specific call
-	model := c.client.GenerativeModel("gemini-3-flash-preview")
-	model.SetTemperature(float32(profile.Temperature))
+	// Build prompt from the evaluator's template
+	prompt := prompts.Grading(req)
 
 	// Prepare parts for multimodal input
 	parts := []genai.Part{genai.Text(prompt)}
-// ... (omitted diagrams part for now, it's already there)
 
 	// Add diagrams if present (Phase 2)
 	for _, diagramURL := range req.Answer.Diagrams {
@@ -75,10 +84,10 @@ func (c *Client) Grade(ctx context.Context, req ai.GradingRequest) (domain.Gradi
 		_ = diagramURL // Use URL to fetch or placeholder
 	}
 
-	// Call Gemini
-	resp, err := c.model.GenerateContent(ctx, parts...)
+	// Call Gemini with the evaluator's own settings
+	resp, settings, err := c.generate(ctx, ai.TaskGrading, req.EvaluatorID, parts...)
 	if err != nil {
-		return domain.GradingResult{}, fmt.Errorf("gemini API error: %w", err)
+		return domain.GradingResult{}, err
 	}
 
 	// Parse structured response
@@ -88,34 +97,16 @@ func (c *Client) Grade(ctx context.Context, req ai.GradingRequest) (domain.Gradi
 	}
 
 	result.AIEvaluatorID = req.EvaluatorID
+	result.Settings = &settings
 	return result, nil
 }
 
 func (c *Client) GenerateFeedback(ctx context.Context, req ai.FeedbackRequest) (string, error) {
-	prompt := fmt.Sprintf(`
-ROLE: Educational feedback specialist
+	prompt := prompts.Feedback(req)
 
-STUDENT: %s
-CURRENT GRADE: %.1f/%.1f
-AI REASONING: %s
-
-HISTORY OF OVERRIDES:
-%v
-
-TASK:
-Generate personalized, encouraging, and actionable feedback for the student.
-Focus on:
-1. What they did well.
-2. Specific areas for improvement based on current mistakes and history.
-3. Actionable next steps.
-
-TONE: Encouraging but honest.
-LENGTH: 3-4 sentences.
-`, req.StudentName, req.Grade.FinalScore, req.Grade.Confidence, req.Grade.Reasoning, req.History)
-
-	resp, err := c.model.GenerateContent(ctx, genai.Text(prompt))
+	resp, _, err := c.generate(ctx, ai.TaskFeedback, "", genai.Text(prompt))
 	if err != nil {
-		return "", fmt.Errorf("gemini API error: %w", err)
+		return "", err
 	}
 
 	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
@@ -131,32 +122,11 @@ LENGTH: 3-4 sentences.
 }
 
 func (c *Client) AnalyzePatterns(ctx context.Context, req ai.AnalysisRequest) (ai.AnalysisResult, error) {
-	prompt := fmt.Sprintf(`
-ROLE: Educational pattern analyst
-
-QUESTION ID: %s
-RUBRIC: %v
-FEEDBACK EVENTS:
-%v
-
-TASK:
-Analyze the feedback events where teachers have overridden AI scores.
-Identify:
-1. Systematic biases (e.g., AI is consistently too strict on units).
-2. Common themes in teacher corrections.
-3. Recommendations for improving the rubric or system prompt.
-
-OUTPUT JSON FORMAT:
-{
-  "patterns": ["pattern 1", "pattern 2"],
-  "common_reasons": ["reason 1", "reason 2"],
-  "recommendation": "detailed recommendation"
-}
-`, req.QuestionID, req.Rubric, req.Events)
+	prompt := prompts.Analysis(req)
 
-	resp, err := c.model.GenerateContent(ctx, genai.Text(prompt))
+	resp, _, err := c.generate(ctx, ai.TaskAnalysis, "", genai.Text(prompt))
 	if err != nil {
-		return ai.AnalysisResult{}, fmt.Errorf("gemini API error: %w", err)
+		return ai.AnalysisResult{}, err
 	}
 
 	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
@@ -181,29 +151,11 @@ OUTPUT JSON FORMAT:
 }
 
 func (c *Client) RefineRubric(ctx context.Context, req ai.RefineRubricRequest) (domain.Rubric, error) {
-	prompt := fmt.Sprintf(`
-ROLE: Rubric Refinement Specialist
+	prompt := prompts.RefineRubric(req)
 
-CURRENT RUBRIC:
-%v
-
-ANALYSIS OF GRADING MISTAKES:
-Patterns: %v
-Recommendations: %s
-
-TASK:
-Update the rubric to address the identified issues.
-- Adjust point weights if criteria are consistently undervalued or overvalued.
-- Clarify descriptions if they are ambiguous.
-- Add or modify partial credit rules if needed.
-
-OUTPUT:
-Return ONLY the updated Rubric JSON.
-`, req.CurrentRubric, req.Analysis.Patterns, req.Analysis.Recommendation)
-
-	resp, err := c.model.GenerateContent(ctx, genai.Text(prompt))
+	resp, _, err := c.generate(ctx, ai.TaskRefinement, "", genai.Text(prompt))
 	if err != nil {
-		return domain.Rubric{}, fmt.Errorf("gemini API error: %w", err)
+		return domain.Rubric{}, err
 	}
 
 	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
@@ -217,9 +169,7 @@ Return ONLY the updated Rubric JSON.
 	}
 
 	// Clean up markdown code blocks if present
-	cleanJSON := strings.TrimPrefix(string(textPart), "```json")
-	cleanJSON = strings.TrimSuffix(cleanJSON, "```")
-	cleanJSON = strings.TrimSpace(cleanJSON)
+	cleanJSON := prompts.CleanJSON(string(textPart))
 
 	var refinedRubric domain.Rubric
 	if err := json.Unmarshal([]byte(cleanJSON), &refinedRubric); err != nil {
@@ -233,58 +183,61 @@ Return ONLY the updated Rubric JSON.
 	return refinedRubric, nil
 }
 
-// Stubs for helper functions
-func loadPromptTemplate(evaluatorID string) string {
-	data, err := promptsFS.ReadFile(filepath.Join("prompts", evaluatorID+".txt"))
+func (c *Client) DraftRubric(ctx context.Context, req ai.DraftRubricRequest) (domain.Rubric, error) {
+	resp, _, err := c.generate(ctx, ai.TaskDraft, "", genai.Text(prompts.DraftRubric(req)))
 	if err != nil {
-		return ""
+		return domain.Rubric{}, err
 	}
-	return string(data)
-}
 
-func buildGradingPrompt(evalTmpl string, answer domain.AnswerSegment, rubric domain.Rubric, subject string, questionText string) string {
-	baseData, err := promptsFS.ReadFile("prompts/base_grading.txt")
-	if err != nil {
-		return ""
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return domain.Rubric{}, fmt.Errorf("empty response from Gemini")
 	}
-
-	subjectProfile, _ := profiles.Subjects[strings.ToLower(subject)]
-
-	// Execute base template
-	type baseVars struct {
-		QuestionText string
-		RubricJSON   string
-		AnswerText   string
-		MaxPoints    int
+	textPart, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	if !ok {
+		return domain.Rubric{}, fmt.Errorf("unexpected response part type")
 	}
 
-	rubricJSON, _ := json.MarshalIndent(rubric, "", "  ")
-
-	baseVarsData := baseVars{
-		QuestionText: questionText,
-		RubricJSON:   string(rubricJSON),
-		AnswerText:   answer.Text,
-		MaxPoints:    10, // Default or from rubric if we added it
+	var draft domain.RubricDocument
+	if err := json.Unmarshal([]byte(prompts.CleanJSON(string(textPart))), &draft); err != nil {
+		return domain.Rubric{}, fmt.Errorf("failed to parse drafted rubric: %w", err)
 	}
+	return *draft.ToRubric(), nil
+}
 
-	tmpl, _ := template.New("base").Parse(string(baseData))
-	var baseBuf bytes.Buffer
-	tmpl.Execute(&baseBuf, baseVarsData)
+func (c *Client) ExtractQuestions(ctx context.Context, req ai.ExtractQuestionsRequest) ([]ai.ExtractedQuestion, error) {
+	resp, _, err := c.generate(ctx, ai.TaskExtraction, "", genai.Text(prompts.ExtractQuestions(req)))
+	if err != nil {
+		return nil, err
+	}
 
-	// Execute evaluator template
-	evalVars := struct {
-		BasePrompt   string
-		SubjectFocus string
-	}{
-		BasePrompt:   baseBuf.String(),
-		SubjectFocus: subjectProfile.PromptBias,
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, fmt.Errorf("empty response from Gemini")
+	}
+	textPart, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response part type")
 	}
 
-	eTmpl, _ := template.New("eval").Parse(evalTmpl)
-	var evalBuf bytes.Buffer
-	eTmpl.Execute(&evalBuf, evalVars)
+	var paper struct {
+		Questions []ai.ExtractedQuestion `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(prompts.CleanJSON(string(textPart))), &paper); err != nil {
+		return nil, fmt.Errorf("failed to parse extracted questions: %w", err)
+	}
+	return paper.Questions, nil
+}
 
-	return evalBuf.String()
+// ReportUsage forwards the response's token counts to any metering decorator.
+func ReportUsage(ctx context.Context, model string, resp *genai.GenerateContentResponse) {
+	if resp == nil || resp.UsageMetadata == nil {
+		return
+	}
+	ai.ReportUsage(ctx, ai.Usage{
+		Provider:     "gemini",
+		Model:        model,
+		InputTokens:  int(resp.UsageMetadata.PromptTokenCount),
+		OutputTokens: int(resp.UsageMetadata.CandidatesTokenCount),
+	})
 }
 
 func parseResponse(resp *genai.GenerateContentResponse, result *domain.GradingResult) error {
@@ -299,9 +252,7 @@ func parseResponse(resp *genai.GenerateContentResponse, result *domain.GradingRe
 	}
 
 	// Clean up markdown code blocks if present
-	cleanJSON := strings.TrimPrefix(string(text), "```json")
-	cleanJSON = strings.TrimSuffix(cleanJSON, "```")
-	cleanJSON = strings.TrimSpace(cleanJSON)
+	cleanJSON := prompts.CleanJSON(string(text))
 
 	return json.Unmarshal([]byte(cleanJSON), result)
 }
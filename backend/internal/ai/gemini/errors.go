@@ -0,0 +1,62 @@
+package gemini
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+
+	"harama/internal/ai"
+)
+
+// ClassifyError turns a genai error into an *ai.APIError carrying the HTTP
+// status and any retry hint, so the resilience chain can tell throttling and
+// outages apart from bad requests.
+func ClassifyError(err error) error {
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("gemini API error: %w", err)
+	}
+
+	result := &ai.APIError{
+		Provider:   "gemini",
+		StatusCode: apiErr.HTTPCode(),
+		Message:    apiErr.Error(),
+	}
+	if result.StatusCode <= 0 {
+		result.StatusCode = httpStatusFromCode(apiErr.GRPCStatus().Code())
+	}
+	if info := apiErr.Details().RetryInfo; info != nil {
+		result.RetryAfter = info.GetRetryDelay().AsDuration()
+	}
+
+	var gErr *googleapi.Error
+	if result.RetryAfter == 0 && errors.As(err, &gErr) {
+		result.RetryAfter = ai.ParseRetryAfter(gErr.Header.Get("Retry-After"))
+	}
+	return result
+}
+
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,64 @@
+package gemini
+
+import (
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+
+	"harama/internal/domain"
+)
+
+var harmCategories = map[string]genai.HarmCategory{
+	"harassment":        genai.HarmCategoryHarassment,
+	"hate_speech":       genai.HarmCategoryHateSpeech,
+	"sexually_explicit": genai.HarmCategorySexuallyExplicit,
+	"dangerous_content": genai.HarmCategoryDangerousContent,
+}
+
+var blockThresholds = map[string]genai.HarmBlockThreshold{
+	"block_none":             genai.HarmBlockNone,
+	"block_only_high":        genai.HarmBlockOnlyHigh,
+	"block_medium_and_above": genai.HarmBlockMediumAndAbove,
+	"block_low_and_above":    genai.HarmBlockLowAndAbove,
+}
+
+// NewModel builds a model handle configured with settings. Handles are cheap
+// and not safe to reconfigure concurrently, so callers make one per call.
+func NewModel(client *genai.Client, settings domain.GenerationSettings) (*genai.GenerativeModel, error) {
+	safety, err := safetySettings(settings.Safety)
+	if err != nil {
+		return nil, err
+	}
+
+	model := client.GenerativeModel(settings.Model)
+	if settings.Temperature != nil {
+		model.SetTemperature(float32(*settings.Temperature))
+	}
+	if settings.TopP != nil {
+		model.SetTopP(float32(*settings.TopP))
+	}
+	if settings.TopK != nil {
+		model.SetTopK(int32(*settings.TopK))
+	}
+	if settings.MaxOutputTokens != nil {
+		model.SetMaxOutputTokens(int32(*settings.MaxOutputTokens))
+	}
+	model.SafetySettings = safety
+	return model, nil
+}
+
+func safetySettings(safety map[string]string) ([]*genai.SafetySetting, error) {
+	var out []*genai.SafetySetting
+	for category, threshold := range safety {
+		c, ok := harmCategories[category]
+		if !ok {
+			return nil, fmt.Errorf("unknown safety category %q", category)
+		}
+		t, ok := blockThresholds[threshold]
+		if !ok {
+			return nil, fmt.Errorf("unknown safety threshold %q for %s", threshold, category)
+		}
+		out = append(out, &genai.SafetySetting{Category: c, Threshold: t})
+	}
+	return out, nil
+}
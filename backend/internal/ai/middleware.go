@@ -0,0 +1,111 @@
+package ai
+
+import (
+	"context"
+
+	"harama/internal/domain"
+)
+
+// CallInfo describes the AI call an interceptor is wrapping.
+type CallInfo struct {
+	Task        Task
+	EvaluatorID string
+}
+
+// Interceptor wraps a single AI or OCR call. It must call next at most once
+// per attempt and may call it with a derived context. Interceptors compose
+// into a chain so the same policies apply to Provider and OCRProcessor alike.
+type Interceptor func(ctx context.Context, info CallInfo, next func(context.Context) error) error
+
+// Chain wraps provider so that every call passes through the interceptors,
+// the first one being outermost.
+func Chain(provider Provider, interceptors ...Interceptor) Provider {
+	if len(interceptors) == 0 {
+		return provider
+	}
+	return &interceptedProvider{inner: provider, run: compose(interceptors)}
+}
+
+// ChainOCR is Chain for OCR backends.
+func ChainOCR(processor OCRProcessor, interceptors ...Interceptor) OCRProcessor {
+	if len(interceptors) == 0 {
+		return processor
+	}
+	return &interceptedOCR{inner: processor, run: compose(interceptors)}
+}
+
+type runner func(ctx context.Context, info CallInfo, call func(context.Context) error) error
+
+func compose(interceptors []Interceptor) runner {
+	return func(ctx context.Context, info CallInfo, call func(context.Context) error) error {
+		next := call
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			ic, inner := interceptors[i], next
+			next = func(ctx context.Context) error { return ic(ctx, info, inner) }
+		}
+		return next(ctx)
+	}
+}
+
+// invoke adapts a typed call to the untyped interceptor chain.
+func invoke[T any](ctx context.Context, run runner, info CallInfo, fn func(context.Context) (T, error)) (T, error) {
+	var res T
+	err := run(ctx, info, func(ctx context.Context) error {
+		var err error
+		res, err = fn(ctx)
+		return err
+	})
+	return res, err
+}
+
+type interceptedProvider struct {
+	inner Provider
+	run   runner
+}
+
+func (p *interceptedProvider) Grade(ctx context.Context, req GradingRequest) (domain.GradingResult, error) {
+	return invoke(ctx, p.run, CallInfo{Task: TaskGrading, EvaluatorID: req.EvaluatorID}, func(ctx context.Context) (domain.GradingResult, error) {
+		return p.inner.Grade(ctx, req)
+	})
+}
+
+func (p *interceptedProvider) GenerateFeedback(ctx context.Context, req FeedbackRequest) (string, error) {
+	return invoke(ctx, p.run, CallInfo{Task: TaskFeedback}, func(ctx context.Context) (string, error) {
+		return p.inner.GenerateFeedback(ctx, req)
+	})
+}
+
+func (p *interceptedProvider) AnalyzePatterns(ctx context.Context, req AnalysisRequest) (AnalysisResult, error) {
+	return invoke(ctx, p.run, CallInfo{Task: TaskAnalysis}, func(ctx context.Context) (AnalysisResult, error) {
+		return p.inner.AnalyzePatterns(ctx, req)
+	})
+}
+
+func (p *interceptedProvider) RefineRubric(ctx context.Context, req RefineRubricRequest) (domain.Rubric, error) {
+	return invoke(ctx, p.run, CallInfo{Task: TaskRefinement}, func(ctx context.Context) (domain.Rubric, error) {
+		return p.inner.RefineRubric(ctx, req)
+	})
+}
+
+func (p *interceptedProvider) DraftRubric(ctx context.Context, req DraftRubricRequest) (domain.Rubric, error) {
+	return invoke(ctx, p.run, CallInfo{Task: TaskDraft}, func(ctx context.Context) (domain.Rubric, error) {
+		return p.inner.DraftRubric(ctx, req)
+	})
+}
+
+func (p *interceptedProvider) ExtractQuestions(ctx context.Context, req ExtractQuestionsRequest) ([]ExtractedQuestion, error) {
+	return invoke(ctx, p.run, CallInfo{Task: TaskExtraction}, func(ctx context.Context) ([]ExtractedQuestion, error) {
+		return p.inner.ExtractQuestions(ctx, req)
+	})
+}
+
+type interceptedOCR struct {
+	inner OCRProcessor
+	run   runner
+}
+
+func (p *interceptedOCR) ExtractText(ctx context.Context, fileBytes []byte, mimeType string) (*domain.OCRResult, error) {
+	return invoke(ctx, p.run, CallInfo{Task: TaskOCR}, func(ctx context.Context) (*domain.OCRResult, error) {
+		return p.inner.ExtractText(ctx, fileBytes, mimeType)
+	})
+}
@@ -0,0 +1,97 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+
+	"harama/internal/domain"
+	"harama/internal/grading/profiles"
+)
+
+// DefaultModel is used for every task the registry has no model for.
+const DefaultModel = "gemini-3-flash-preview"
+
+func ptr[T any](v T) *T { return &v }
+
+// DefaultModels are the built-in registry entries. Configuration is merged
+// over them key by key, so overriding one field keeps the others.
+func DefaultModels() map[string]domain.GenerationSettings {
+	return map[string]domain.GenerationSettings{
+		"default": {
+			Model:       DefaultModel,
+			Temperature: ptr(0.2),
+			TopP:        ptr(0.95),
+			TopK:        ptr(40),
+		},
+		// Low temperature for deterministic transcription
+		string(TaskOCR): {Temperature: ptr(0.1)},
+	}
+}
+
+// ModelRegistry resolves the generation settings for each kind of AI call.
+// Keys are "default", a task name ("grading", "ocr", "feedback", "analysis",
+// "refinement", "draft", "extraction") or "grading.<evaluator_id>". A call's settings are the
+// default entry, overlaid with its task entry, the evaluator profile's
+// temperature and finally its evaluator entry.
+type ModelRegistry struct {
+	entries map[string]domain.GenerationSettings
+}
+
+// NewModelRegistry merges entries over DefaultModels.
+func NewModelRegistry(entries map[string]domain.GenerationSettings) (*ModelRegistry, error) {
+	merged := DefaultModels()
+	for key, s := range entries {
+		if err := validateModelKey(key); err != nil {
+			return nil, err
+		}
+		merged[key] = merged[key].Merge(s)
+	}
+	if merged["default"].Model == "" {
+		return nil, fmt.Errorf("model registry: default entry needs a model")
+	}
+	return &ModelRegistry{entries: merged}, nil
+}
+
+func validateModelKey(key string) error {
+	if key == "default" {
+		return nil
+	}
+	task, evaluator, scoped := strings.Cut(key, ".")
+	switch Task(task) {
+	case TaskGrading:
+		if scoped {
+			if _, ok := profiles.Evaluators[evaluator]; !ok {
+				return fmt.Errorf("model registry: unknown evaluator %q", evaluator)
+			}
+		}
+		return nil
+	case TaskFeedback, TaskAnalysis, TaskRefinement, TaskDraft, TaskExtraction, TaskOCR:
+		if !scoped {
+			return nil
+		}
+	}
+	return fmt.Errorf("model registry: unknown key %q", key)
+}
+
+// Resolve returns the settings for a call. evaluatorID only matters for
+// grading.
+func (r *ModelRegistry) Resolve(task Task, evaluatorID string) domain.GenerationSettings {
+	s := r.entries["default"].Merge(r.entries[string(task)])
+	if task == TaskGrading && evaluatorID != "" {
+		if profile, ok := profiles.Evaluators[evaluatorID]; ok {
+			s.Temperature = ptr(profile.Temperature)
+		}
+		s = s.Merge(r.entries[string(task)+"."+evaluatorID])
+	}
+	return s
+}
+
+// Entries returns a copy of every configured entry, for validation by
+// backends that support vendor-specific fields.
+func (r *ModelRegistry) Entries() map[string]domain.GenerationSettings {
+	out := make(map[string]domain.GenerationSettings, len(r.entries))
+	for k, v := range r.entries {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package ai
+
+import (
+	"testing"
+
+	"harama/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelRegistry_Defaults(t *testing.T) {
+	r, err := NewModelRegistry(nil)
+	require.NoError(t, err)
+
+	feedback := r.Resolve(TaskFeedback, "")
+	assert.Equal(t, DefaultModel, feedback.Model)
+	assert.InDelta(t, 0.2, *feedback.Temperature, 1e-9)
+	assert.Equal(t, 40, *feedback.TopK)
+
+	ocr := r.Resolve(TaskOCR, "")
+	assert.InDelta(t, 0.1, *ocr.Temperature, 1e-9)
+
+	// Each evaluator keeps its profile's temperature
+	assert.InDelta(t, 0.1, *r.Resolve(TaskGrading, "rubric_enforcer").Temperature, 1e-9)
+	assert.InDelta(t, 0.3, *r.Resolve(TaskGrading, "reasoning_validator").Temperature, 1e-9)
+}
+
+func TestModelRegistry_Precedence(t *testing.T) {
+	r, err := NewModelRegistry(map[string]domain.GenerationSettings{
+		"default":                     {Model: "base-model"},
+		"grading":                     {Model: "grading-model", MaxOutputTokens: ptr(2048)},
+		"grading.reasoning_validator": {Temperature: ptr(0.5), Safety: map[string]string{"harassment": "block_none"}},
+	})
+	require.NoError(t, err)
+
+	s := r.Resolve(TaskGrading, "reasoning_validator")
+	assert.Equal(t, "grading-model", s.Model)
+	assert.InDelta(t, 0.5, *s.Temperature, 1e-9)
+	assert.Equal(t, 2048, *s.MaxOutputTokens)
+	assert.InDelta(t, 0.95, *s.TopP, 1e-9, "unset fields keep the built-in default")
+	assert.Equal(t, "block_none", s.Safety["harassment"])
+
+	other := r.Resolve(TaskGrading, "rubric_enforcer")
+	assert.InDelta(t, 0.1, *other.Temperature, 1e-9)
+	assert.Empty(t, other.Safety)
+
+	assert.Equal(t, "base-model", r.Resolve(TaskFeedback, "").Model)
+}
+
+func TestModelRegistry_RejectsUnknownKeys(t *testing.T) {
+	for _, key := range []string{"grading.nobody", "summarise", "ocr.fast"} {
+		_, err := NewModelRegistry(map[string]domain.GenerationSettings{key: {Model: "m"}})
+		assert.Error(t, err, key)
+	}
+}
@@ -0,0 +1,14 @@
+package ai
+
+import (
+	"context"
+
+	"harama/internal/domain"
+)
+
+// OCRProcessor defines the contract for text extraction strategies. It lives
+// here rather than in service so that the same decorators that wrap Provider
+// (metering, resilience, recording) can wrap OCR backends too.
+type OCRProcessor interface {
+	ExtractText(ctx context.Context, fileBytes []byte, mimeType string) (*domain.OCRResult, error)
+}
@@ -0,0 +1,288 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"harama/internal/ai"
+	"harama/internal/ai/prompts"
+	"harama/internal/domain"
+	"harama/internal/grading/profiles"
+)
+
+// Config describes an OpenAI-compatible chat completions endpoint. Local
+// servers such as Ollama (http://localhost:11434/v1) or llama.cpp speak the
+// same protocol and usually need no API key.
+type Config struct {
+	Name       string // backend name reported in usage records; defaults to "openai"
+	BaseURL    string
+	APIKey     string
+	Model      string
+	JSONMode   bool // send response_format=json_object; not every local server supports it
+	HTTPClient *http.Client
+	// Models supplies sampling settings per task. The endpoint's own Model
+	// always wins over the registry's model name, and top-k and safety
+	// settings have no equivalent in this protocol.
+	Models *ai.ModelRegistry
+}
+
+type Client struct {
+	name     string
+	baseURL  string
+	apiKey   string
+	model    string
+	jsonMode bool
+	http     *http.Client
+	models   *ai.ModelRegistry
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("openai: base URL is required")
+	}
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("openai: model is required")
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 120 * time.Second}
+	}
+	name := cfg.Name
+	if name == "" {
+		name = "openai"
+	}
+	models := cfg.Models
+	if models == nil {
+		var err error
+		if models, err = ai.NewModelRegistry(nil); err != nil {
+			return nil, err
+		}
+	}
+	return &Client{
+		name:     name,
+		baseURL:  strings.TrimSuffix(cfg.BaseURL, "/"),
+		apiKey:   cfg.APIKey,
+		model:    cfg.Model,
+		jsonMode: cfg.JSONMode,
+		http:     httpClient,
+		models:   models,
+	}, nil
+}
+
+// Wire types for POST /chat/completions
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type ChatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	Temperature    *float64        `json:"temperature,omitempty"`
+	TopP           *float64        `json:"top_p,omitempty"`
+	MaxTokens      *int            `json:"max_tokens,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+type Choice struct {
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type ChatResponse struct {
+	ID      string   `json:"id"`
+	Model   string   `json:"model"`
+	Choices []Choice `json:"choices"`
+	Usage   Usage    `json:"usage"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+func (c *Client) Grade(ctx context.Context, req ai.GradingRequest) (domain.GradingResult, error) {
+	if _, ok := profiles.Evaluators[req.EvaluatorID]; !ok {
+		return domain.GradingResult{}, fmt.Errorf("evaluator profile not found: %s", req.EvaluatorID)
+	}
+
+	settings := c.settings(ai.TaskGrading, req.EvaluatorID)
+	text, err := c.complete(ctx, []Message{{Role: "user", Content: prompts.Grading(req)}}, settings, true)
+	if err != nil {
+		return domain.GradingResult{}, err
+	}
+
+	var result domain.GradingResult
+	if err := json.Unmarshal([]byte(prompts.CleanJSON(text)), &result); err != nil {
+		return domain.GradingResult{}, fmt.Errorf("failed to parse grading response: %w", err)
+	}
+
+	result.AIEvaluatorID = req.EvaluatorID
+	result.Settings = &settings
+	return result, nil
+}
+
+func (c *Client) GenerateFeedback(ctx context.Context, req ai.FeedbackRequest) (string, error) {
+	return c.complete(ctx, []Message{{Role: "user", Content: prompts.Feedback(req)}}, c.settings(ai.TaskFeedback, ""), false)
+}
+
+func (c *Client) AnalyzePatterns(ctx context.Context, req ai.AnalysisRequest) (ai.AnalysisResult, error) {
+	text, err := c.complete(ctx, []Message{{Role: "user", Content: prompts.Analysis(req)}}, c.settings(ai.TaskAnalysis, ""), true)
+	if err != nil {
+		return ai.AnalysisResult{}, err
+	}
+
+	var analysisResult ai.AnalysisResult
+	if err := json.Unmarshal([]byte(prompts.CleanJSON(text)), &analysisResult); err != nil {
+		return ai.AnalysisResult{Recommendation: text}, nil
+	}
+	return analysisResult, nil
+}
+
+func (c *Client) RefineRubric(ctx context.Context, req ai.RefineRubricRequest) (domain.Rubric, error) {
+	text, err := c.complete(ctx, []Message{{Role: "user", Content: prompts.RefineRubric(req)}}, c.settings(ai.TaskRefinement, ""), true)
+	if err != nil {
+		return domain.Rubric{}, err
+	}
+
+	var refinedRubric domain.Rubric
+	if err := json.Unmarshal([]byte(prompts.CleanJSON(text)), &refinedRubric); err != nil {
+		return domain.Rubric{}, fmt.Errorf("failed to parse refined rubric: %w", err)
+	}
+
+	// Preserve ID and QuestionID from the request as the model might hallucinate or omit them
+	refinedRubric.ID = req.CurrentRubric.ID
+	refinedRubric.QuestionID = req.CurrentRubric.QuestionID
+	return refinedRubric, nil
+}
+
+func (c *Client) DraftRubric(ctx context.Context, req ai.DraftRubricRequest) (domain.Rubric, error) {
+	text, err := c.complete(ctx, []Message{{Role: "user", Content: prompts.DraftRubric(req)}}, c.settings(ai.TaskDraft, ""), true)
+	if err != nil {
+		return domain.Rubric{}, err
+	}
+
+	var draft domain.RubricDocument
+	if err := json.Unmarshal([]byte(prompts.CleanJSON(text)), &draft); err != nil {
+		return domain.Rubric{}, fmt.Errorf("failed to parse drafted rubric: %w", err)
+	}
+	return *draft.ToRubric(), nil
+}
+
+func (c *Client) ExtractQuestions(ctx context.Context, req ai.ExtractQuestionsRequest) ([]ai.ExtractedQuestion, error) {
+	text, err := c.complete(ctx, []Message{{Role: "user", Content: prompts.ExtractQuestions(req)}}, c.settings(ai.TaskExtraction, ""), true)
+	if err != nil {
+		return nil, err
+	}
+
+	var paper struct {
+		Questions []ai.ExtractedQuestion `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(prompts.CleanJSON(text)), &paper); err != nil {
+		return nil, fmt.Errorf("failed to parse extracted questions: %w", err)
+	}
+	return paper.Questions, nil
+}
+
+// settings resolves the registry entry for a call, reduced to what this
+// endpoint actually receives so that results record the truth.
+func (c *Client) settings(task ai.Task, evaluatorID string) domain.GenerationSettings {
+	s := c.models.Resolve(task, evaluatorID)
+	s.Model = c.model
+	s.TopK = nil
+	s.Safety = nil
+	return s
+}
+
+func (c *Client) complete(ctx context.Context, messages []Message, settings domain.GenerationSettings, wantJSON bool) (string, error) {
+	chatReq := ChatRequest{
+		Model:       settings.Model,
+		Messages:    messages,
+		Temperature: settings.Temperature,
+		TopP:        settings.TopP,
+		MaxTokens:   settings.MaxOutputTokens,
+	}
+	if wantJSON && c.jsonMode {
+		chatReq.ResponseFormat = &ResponseFormat{Type: "json_object"}
+	}
+
+	body, err := json.Marshal(chatReq)
+	if err != nil {
+		return "", err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("openai API error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("openai API error: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr errorResponse
+		msg := strings.TrimSpace(string(respBody))
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error.Message != "" {
+			msg = apiErr.Error.Message
+		}
+		return "", &ai.APIError{
+			Provider:   c.name,
+			StatusCode: resp.StatusCode,
+			RetryAfter: ai.ParseRetryAfter(resp.Header.Get("Retry-After")),
+			Message:    msg,
+		}
+	}
+
+	var chatResp ChatResponse
+	if err := json.Unmarshal(respBody, &chatResp); err != nil {
+		return "", fmt.Errorf("openai: failed to decode response: %w", err)
+	}
+	model := chatResp.Model
+	if model == "" {
+		model = c.model
+	}
+	ai.ReportUsage(ctx, ai.Usage{
+		Provider:     c.name,
+		Model:        model,
+		InputTokens:  chatResp.Usage.PromptTokens,
+		OutputTokens: chatResp.Usage.CompletionTokens,
+	})
+
+	if len(chatResp.Choices) == 0 || chatResp.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("empty response from openai")
+	}
+
+	return chatResp.Choices[0].Message.Content, nil
+}
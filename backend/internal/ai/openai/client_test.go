@@ -0,0 +1,148 @@
+package openai_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"harama/internal/ai"
+	"harama/internal/ai/openai"
+	"harama/internal/ai/openai/openaitest"
+	"harama/internal/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Grade(t *testing.T) {
+	srv := openaitest.NewServer("sk-test", func(req openai.ChatRequest) (string, error) {
+		return "```json\n" + `{"score": 7, "max_score": 10, "confidence": 0.8, "reasoning": "ok", "criteria_met": ["c1"]}` + "\n```", nil
+	})
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.Config{BaseURL: srv.BaseURL(), APIKey: "sk-test", Model: "gpt-test", JSONMode: true})
+	require.NoError(t, err)
+
+	result, err := client.Grade(context.Background(), ai.GradingRequest{
+		Answer:       domain.AnswerSegment{Text: "x = 4"},
+		EvaluatorID:  "rubric_enforcer",
+		Subject:      "mathematics",
+		QuestionText: "Solve x + 1 = 5",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, result.Score)
+	assert.Equal(t, []string{"c1"}, result.CriteriaMet)
+	assert.Equal(t, "rubric_enforcer", result.AIEvaluatorID)
+
+	reqs := srv.Requests()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "gpt-test", reqs[0].Model)
+	require.NotNil(t, reqs[0].Temperature)
+	assert.InDelta(t, 0.1, *reqs[0].Temperature, 1e-9)
+	require.NotNil(t, reqs[0].ResponseFormat)
+	assert.Equal(t, "json_object", reqs[0].ResponseFormat.Type)
+	assert.True(t, strings.Contains(reqs[0].Messages[0].Content, "Solve x + 1 = 5"))
+
+	require.NotNil(t, result.Settings)
+	assert.Equal(t, "gpt-test", result.Settings.Model)
+	assert.InDelta(t, 0.1, *result.Settings.Temperature, 1e-9)
+}
+
+func TestClient_AppliesRegistrySettings(t *testing.T) {
+	srv := openaitest.NewServer("", func(req openai.ChatRequest) (string, error) {
+		return `{"score": 5, "max_score": 10}`, nil
+	})
+	defer srv.Close()
+
+	temperature, maxTokens := 0.6, 512
+	models, err := ai.NewModelRegistry(map[string]domain.GenerationSettings{
+		"grading.structural_analyzer": {Model: "ignored-model", Temperature: &temperature, MaxOutputTokens: &maxTokens},
+	})
+	require.NoError(t, err)
+
+	client, err := openai.NewClient(openai.Config{BaseURL: srv.BaseURL(), Model: "local-test", Models: models})
+	require.NoError(t, err)
+
+	result, err := client.Grade(context.Background(), ai.GradingRequest{EvaluatorID: "structural_analyzer"})
+	require.NoError(t, err)
+
+	req := srv.Requests()[0]
+	assert.Equal(t, "local-test", req.Model)
+	assert.InDelta(t, 0.6, *req.Temperature, 1e-9)
+	assert.Equal(t, 512, *req.MaxTokens)
+	require.NotNil(t, req.TopP)
+
+	require.NotNil(t, result.Settings)
+	assert.Equal(t, "local-test", result.Settings.Model)
+	assert.Nil(t, result.Settings.TopK, "top-k is not sent to this backend")
+}
+
+func TestClient_APIError(t *testing.T) {
+	srv := openaitest.NewServer("", func(req openai.ChatRequest) (string, error) {
+		return "", &openaitest.Error{Status: http.StatusTooManyRequests, Message: "slow down"}
+	})
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.Config{BaseURL: srv.BaseURL(), Model: "llama3.1"})
+	require.NoError(t, err)
+
+	_, err = client.GenerateFeedback(context.Background(), ai.FeedbackRequest{StudentName: "Ana"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "slow down")
+}
+
+func TestRouter_FallsBackToSecondBackend(t *testing.T) {
+	down := openaitest.NewServer("", func(req openai.ChatRequest) (string, error) {
+		return "", errors.New("model not loaded")
+	})
+	defer down.Close()
+	up := openaitest.NewServer("", func(req openai.ChatRequest) (string, error) {
+		return "Great work on the derivation.", nil
+	})
+	defer up.Close()
+
+	local, _ := openai.NewClient(openai.Config{BaseURL: down.BaseURL(), Model: "llama3.1"})
+	remote, _ := openai.NewClient(openai.Config{BaseURL: up.BaseURL(), Model: "gpt-test"})
+
+	router, err := ai.NewRouter(map[string]ai.Provider{"local": local, "openai": remote}, ai.Routes{
+		Default: []string{"local", "openai"},
+	})
+	require.NoError(t, err)
+
+	feedback, err := router.GenerateFeedback(context.Background(), ai.FeedbackRequest{StudentName: "Ana"})
+	require.NoError(t, err)
+	assert.Equal(t, "Great work on the derivation.", feedback)
+	assert.Len(t, down.Requests(), 1)
+	assert.Len(t, up.Requests(), 1)
+}
+
+func TestClient_RetriesThrottlingThroughChain(t *testing.T) {
+	attempts := 0
+	srv := openaitest.NewServer("", func(req openai.ChatRequest) (string, error) {
+		attempts++
+		if attempts == 1 {
+			return "", &openaitest.Error{Status: http.StatusTooManyRequests, Message: "rate limited", RetryAfter: "1"}
+		}
+		return "Keep it up.", nil
+	})
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.Config{BaseURL: srv.BaseURL(), Model: "gpt-test"})
+	require.NoError(t, err)
+
+	_, err = client.GenerateFeedback(context.Background(), ai.FeedbackRequest{})
+	var apiErr *ai.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	assert.Equal(t, time.Second, apiErr.RetryAfter)
+
+	attempts = 0
+	provider := ai.Chain(client, ai.Retry(ai.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}))
+	text, err := provider.GenerateFeedback(context.Background(), ai.FeedbackRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "Keep it up.", text)
+	assert.Equal(t, 2, attempts)
+}
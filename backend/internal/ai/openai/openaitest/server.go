@@ -0,0 +1,127 @@
+// Package openaitest provides an in-process HTTP server that speaks the
+// OpenAI chat completions protocol, for exercising openai.Client and anything
+// built on it without a network or an API key.
+package openaitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"harama/internal/ai/openai"
+)
+
+// Responder produces the assistant message for a chat request. Returning an
+// *Error makes the server answer with that HTTP status instead.
+type Responder func(req openai.ChatRequest) (string, error)
+
+// Error is returned by a Responder to simulate an API failure.
+type Error struct {
+	Status     int
+	Message    string
+	RetryAfter string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%d: %s", e.Status, e.Message) }
+
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responder Responder
+	requests  []openai.ChatRequest
+	apiKey    string
+}
+
+// NewServer starts a stub server. If apiKey is non-empty, requests must carry
+// it as a bearer token.
+func NewServer(apiKey string, responder Responder) *Server {
+	s := &Server{responder: responder, apiKey: apiKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.handleChat)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// BaseURL is the value to put in openai.Config.BaseURL.
+func (s *Server) BaseURL() string {
+	return s.URL + "/v1"
+}
+
+// Requests returns every chat request received so far.
+func (s *Server) Requests() []openai.ChatRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]openai.ChatRequest(nil), s.requests...)
+}
+
+// SetResponder swaps the responder for subsequent requests.
+func (s *Server) SetResponder(responder Responder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responder = responder
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	if s.apiKey != "" && r.Header.Get("Authorization") != "Bearer "+s.apiKey {
+		writeError(w, &Error{Status: http.StatusUnauthorized, Message: "invalid api key"})
+		return
+	}
+
+	var req openai.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, &Error{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	responder := s.responder
+	n := len(s.requests)
+	s.mu.Unlock()
+
+	content, err := responder(req)
+	if err != nil {
+		apiErr, ok := err.(*Error)
+		if !ok {
+			apiErr = &Error{Status: http.StatusInternalServerError, Message: err.Error()}
+		}
+		writeError(w, apiErr)
+		return
+	}
+
+	prompt := 0
+	for _, m := range req.Messages {
+		prompt += len(strings.Fields(m.Content))
+	}
+	completion := len(strings.Fields(content))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openai.ChatResponse{
+		ID:    fmt.Sprintf("chatcmpl-%d", n),
+		Model: req.Model,
+		Choices: []openai.Choice{{
+			Message:      openai.Message{Role: "assistant", Content: content},
+			FinishReason: "stop",
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     prompt,
+			CompletionTokens: completion,
+			TotalTokens:      prompt + completion,
+		},
+	})
+}
+
+func writeError(w http.ResponseWriter, e *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	if e.RetryAfter != "" {
+		w.Header().Set("Retry-After", e.RetryAfter)
+	}
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": e.Message, "type": "stub_error"},
+	})
+}
@@ -0,0 +1,260 @@
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"harama/internal/ai"
+	"harama/internal/domain"
+	"harama/internal/grading/profiles"
+)
+
+//go:embed *.txt
+var promptsFS embed.FS
+
+// Grading builds the full grading prompt for one evaluator perspective.
+// Every ai.Provider implementation shares these templates so that switching
+// vendors does not change what the model is asked to do.
+func Grading(req ai.GradingRequest) string {
+	evalTmpl := loadTemplate(req.EvaluatorID)
+
+	baseData, err := promptsFS.ReadFile("base_grading.txt")
+	if err != nil {
+		return ""
+	}
+
+	subjectProfile, _ := profiles.Subjects[strings.ToLower(req.Subject)]
+
+	// Execute base template
+	type baseVars struct {
+		QuestionText string
+		RubricJSON   string
+		AnswerText   string
+		MaxPoints    int
+	}
+
+	rubricJSON, _ := json.MarshalIndent(req.Rubric, "", "  ")
+
+	baseVarsData := baseVars{
+		QuestionText: req.QuestionText,
+		RubricJSON:   string(rubricJSON),
+		AnswerText:   req.Answer.Text,
+		MaxPoints:    10, // Default or from rubric if we added it
+	}
+
+	tmpl, _ := template.New("base").Parse(string(baseData))
+	var baseBuf bytes.Buffer
+	tmpl.Execute(&baseBuf, baseVarsData)
+
+	// Execute evaluator template
+	evalVars := struct {
+		BasePrompt   string
+		SubjectFocus string
+	}{
+		BasePrompt:   baseBuf.String(),
+		SubjectFocus: subjectProfile.PromptBias,
+	}
+
+	eTmpl, _ := template.New("eval").Parse(evalTmpl)
+	var evalBuf bytes.Buffer
+	eTmpl.Execute(&evalBuf, evalVars)
+
+	return evalBuf.String()
+}
+
+func Feedback(req ai.FeedbackRequest) string {
+	return fmt.Sprintf(`
+ROLE: Educational feedback specialist
+
+STUDENT: %s
+CURRENT GRADE: %.1f/%d
+AI REASONING: %s
+
+HISTORY OF OVERRIDES:
+%v
+
+LEARNING PROFILE ACROSS ASSESSMENTS:
+%s
+
+TASK:
+Generate personalized, encouraging, and actionable feedback for the student.
+Focus on:
+1. What they did well.
+2. Specific areas for improvement based on current mistakes and history.
+3. Where the learning profile shows a recurring mistake, a consistently missed criterion or a trend, name the pattern across assessments.
+4. Actionable next steps.
+
+TONE: Encouraging but honest.
+LENGTH: 3-5 sentences.
+`, req.StudentName, req.Grade.FinalScore, req.Grade.MaxScore, req.Grade.Reasoning, req.History, describeProfile(req.Profile))
+}
+
+// describeProfile renders the parts of a student profile that feedback can
+// refer to.
+func describeProfile(p *domain.StudentProfile) string {
+	if p == nil || p.AnswersGraded == 0 {
+		return "No earlier assessments on record."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d exams, %d graded answers, %.1f%% overall.\n", p.ExamsTaken, p.AnswersGraded, p.OverallPercent)
+	for _, t := range p.Subjects {
+		fmt.Fprintf(&b, "- Subject %s: %.1f%%, %s\n", t.Name, t.Percent, t.Trend)
+	}
+	for _, t := range p.Concepts {
+		if t.Trend == domain.TrendInsufficient {
+			continue
+		}
+		fmt.Fprintf(&b, "- Concept %s: %.1f%%, %s\n", t.Name, t.Percent, t.Trend)
+	}
+	for _, m := range p.RecurringMistakes {
+		fmt.Fprintf(&b, "- Recurring mistake (%d times across %d exams): %s\n", m.Count, m.Exams, m.Mistake)
+	}
+	for _, c := range p.MissedCriteria {
+		fmt.Fprintf(&b, "- Often misses (%d of %d): %s\n", c.Missed, c.Attempts, c.Criterion)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func Analysis(req ai.AnalysisRequest) string {
+	return fmt.Sprintf(`
+ROLE: Educational pattern analyst
+
+QUESTION ID: %s
+RUBRIC: %v
+FEEDBACK EVENTS:
+%v
+
+TASK:
+Analyze the feedback events where teachers have overridden AI scores.
+Identify:
+1. Systematic biases (e.g., AI is consistently too strict on units).
+2. Common themes in teacher corrections.
+3. Recommendations for improving the rubric or system prompt.
+
+OUTPUT JSON FORMAT:
+{
+  "patterns": ["pattern 1", "pattern 2"],
+  "common_reasons": ["reason 1", "reason 2"],
+  "recommendation": "detailed recommendation"
+}
+`, req.QuestionID, req.Rubric, req.Events)
+}
+
+func RefineRubric(req ai.RefineRubricRequest) string {
+	return fmt.Sprintf(`
+ROLE: Rubric Refinement Specialist
+
+CURRENT RUBRIC:
+%v
+
+ANALYSIS OF GRADING MISTAKES:
+Patterns: %v
+Recommendations: %s
+
+TASK:
+Update the rubric to address the identified issues.
+- Adjust point weights if criteria are consistently undervalued or overvalued.
+- Clarify descriptions if they are ambiguous.
+- Add or modify partial credit rules if needed.
+
+OUTPUT:
+Return ONLY the updated Rubric JSON.
+`, req.CurrentRubric, req.Analysis.Patterns, req.Analysis.Recommendation)
+}
+
+// DraftRubric asks for a first rubric for a question, in the snake_case
+// form of domain.RubricDocument.
+func DraftRubric(req ai.DraftRubricRequest) string {
+	modelAnswer := req.ModelAnswer
+	if modelAnswer == "" {
+		modelAnswer = "(none given: work out what a full-credit answer must contain)"
+	}
+	return fmt.Sprintf(`
+ROLE: Experienced %s Examiner
+
+QUESTION (%d points, answer type: %s):
+%s
+
+MODEL ANSWER OR MARKING SCHEME:
+%s
+
+TASK:
+Draft a marking rubric for this question.
+- Full credit criteria: the distinct points a complete answer makes. Their points must add up to exactly %d.
+- Partial credit rules: answers that earn some credit without meeting a criterion in full. Name the criteria a rule depends on by ID.
+- Common mistakes students make on this question, with the points each one costs.
+- Key concepts the answer must show an understanding of.
+Give every criterion, rule and mistake a short ID.
+
+OUTPUT JSON FORMAT:
+{
+  "criteria": [{"id": "c1", "description": "...", "points": 2, "required": true, "category": "..."}],
+  "partial_credit": [{"id": "p1", "condition": "...", "points": 1, "description": "...", "dependencies": ["c1"]}],
+  "common_mistakes": [{"id": "m1", "description": "...", "penalty": 1, "category": "..."}],
+  "key_concepts": ["..."],
+  "grading_notes": "..."
+}
+`, subjectOrGeneral(req.Subject), req.Points, req.AnswerType, req.QuestionText, modelAnswer, req.Points)
+}
+
+// ExtractQuestions asks for the questions on a question paper, from the
+// transcribed text of its pages.
+func ExtractQuestions(req ai.ExtractQuestionsRequest) string {
+	var pages strings.Builder
+	for i, text := range req.Pages {
+		fmt.Fprintf(&pages, "--- PAGE %d ---\n%s\n", i+1, text)
+	}
+	return fmt.Sprintf(`
+ROLE: %s Exam Paper Editor
+
+QUESTION PAPER (transcribed page by page):
+%s
+TASK:
+List every question a student must answer on this paper, in the order they appear.
+- number: the question's number exactly as printed, e.g. "3" or "2b". Sub-parts such as (a) and (b) of question 2 are questions of their own, numbered "2a" and "2b".
+- group: the section or part the question is in, e.g. "Section A", or "" if the paper has none.
+- text: the question's full wording, including any stem shared with its sub-parts, without its number or marks.
+- points: the marks printed for the question, or your best estimate if none are printed.
+- answer_type: "mcq" if the student picks from options, "diagram" if they must draw, "essay" for extended writing and "short_answer" otherwise.
+- choices: the options of a multiple choice question, with their letters as IDs.
+- page: the page the question starts on.
+- has_figure: true if the question shows a figure, diagram, graph or picture.
+Instructions to candidates, headers and footers are not questions.
+
+OUTPUT JSON FORMAT:
+{
+  "questions": [
+    {"number": "1a", "group": "", "text": "...", "points": 2, "answer_type": "mcq", "choices": [{"id": "A", "text": "..."}], "page": 1, "has_figure": false}
+  ]
+}
+`, subjectOrGeneral(req.Subject), pages.String())
+}
+
+func subjectOrGeneral(subject string) string {
+	if subject == "" {
+		return "General"
+	}
+	return subject
+}
+
+// CleanJSON strips the markdown code fences models like to wrap JSON in.
+func CleanJSON(text string) string {
+	cleanJSON := strings.TrimSpace(text)
+	cleanJSON = strings.TrimPrefix(cleanJSON, "```json")
+	cleanJSON = strings.TrimPrefix(cleanJSON, "```")
+	cleanJSON = strings.TrimSuffix(cleanJSON, "```")
+	return strings.TrimSpace(cleanJSON)
+}
+
+func loadTemplate(evaluatorID string) string {
+	data, err := promptsFS.ReadFile(evaluatorID + ".txt")
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -11,6 +11,8 @@ type Provider interface {
     GenerateFeedback(ctx context.Context, req FeedbackRequest) (string, error)
     AnalyzePatterns(ctx context.Context, req AnalysisRequest) (AnalysisResult, error)
     RefineRubric(ctx context.Context, req RefineRubricRequest) (domain.Rubric, error)
+    DraftRubric(ctx context.Context, req DraftRubricRequest) (domain.Rubric, error)
+    ExtractQuestions(ctx context.Context, req ExtractQuestionsRequest) ([]ExtractedQuestion, error)
 }
 
 type GradingRequest struct {
@@ -25,6 +27,7 @@ type FeedbackRequest struct {
     Grade    domain.FinalGrade
     History  []domain.FeedbackEvent
     StudentName string
+    Profile  *domain.StudentProfile // learning patterns across earlier assessments, if known
 }
 
 type AnalysisRequest struct {
@@ -43,3 +46,34 @@ type RefineRubricRequest struct {
     CurrentRubric  domain.Rubric
     Analysis       AnalysisResult
 }
+
+// DraftRubricRequest asks for a first rubric for a question. ModelAnswer is
+// the teacher's model answer or marking scheme, if they have one.
+type DraftRubricRequest struct {
+    QuestionText string
+    Points       int
+    Subject      string
+    AnswerType   domain.AnswerType
+    ModelAnswer  string
+}
+
+// ExtractQuestionsRequest asks for the questions on a question paper, from
+// the transcribed text of its pages in order.
+type ExtractQuestionsRequest struct {
+    Pages   []string
+    Subject string
+}
+
+// ExtractedQuestion is a question found on a question paper. Page is the
+// page it starts on, counting from 1, and HasFigure tells whether it shows
+// a figure, diagram or graph.
+type ExtractedQuestion struct {
+    Number     string            `json:"number"`
+    Group      string            `json:"group"`
+    Text       string            `json:"text"`
+    Points     int               `json:"points"`
+    AnswerType domain.AnswerType `json:"answer_type"`
+    Choices    []domain.Choice   `json:"choices,omitempty"`
+    Page       int               `json:"page"`
+    HasFigure  bool              `json:"has_figure"`
+}
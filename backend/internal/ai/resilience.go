@@ -0,0 +1,278 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// APIError is a failed upstream call, normalised across vendors so retry and
+// circuit-breaking policies don't need to know who returned it.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	RetryAfter time.Duration // zero when the upstream gave no hint
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s API error (%d): %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// Transient reports whether the same call may succeed if repeated.
+func (e *APIError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode >= 500
+}
+
+// IsTransient reports whether err is worth retrying: throttling, upstream 5xx,
+// per-attempt timeouts and dropped connections.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Transient()
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func retryAfter(err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	return 0
+}
+
+// RateLimit makes every call wait for a token from a process-wide bucket.
+// Share one limiter between all chains that draw on the same quota.
+func RateLimit(limiter *rate.Limiter) Interceptor {
+	return func(ctx context.Context, info CallInfo, next func(context.Context) error) error {
+		if err := limiter.Wait(ctx); err != nil {
+			return fmt.Errorf("ai rate limiter: %w", err)
+		}
+		return next(ctx)
+	}
+}
+
+// NewLimiter sizes a token bucket from a requests-per-minute quota.
+func NewLimiter(requestsPerMinute, burst int) *rate.Limiter {
+	if requestsPerMinute <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(float64(requestsPerMinute)/60), burst)
+}
+
+// RetryPolicy controls Retry. Delays use full jitter: a random wait between
+// zero and BaseDelay*2^attempt, capped at MaxDelay. A Retry-After hint from
+// the upstream takes precedence.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	if hint := retryAfter(err); hint > 0 {
+		return min(hint, p.MaxDelay)
+	}
+	ceiling := p.BaseDelay << attempt
+	if ceiling <= 0 || ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// Retry repeats transient failures with jittered exponential backoff.
+func Retry(policy RetryPolicy) Interceptor {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return func(ctx context.Context, info CallInfo, next func(context.Context) error) error {
+		var err error
+		for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+			err = next(ctx)
+			if err == nil || !IsTransient(err) || ctx.Err() != nil {
+				return err
+			}
+			if attempt == policy.MaxAttempts-1 {
+				break
+			}
+
+			wait := policy.delay(attempt, err)
+			log.Printf("ai %s call failed (attempt %d/%d), retrying in %s: %v", info.Task, attempt+1, policy.MaxAttempts, wait, err)
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		return err
+	}
+}
+
+// Timeout gives each attempt its own deadline.
+func Timeout(d time.Duration) Interceptor {
+	return func(ctx context.Context, info CallInfo, next func(context.Context) error) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return next(ctx)
+	}
+}
+
+// ErrCircuitOpen is returned without calling the provider while the breaker
+// is open. Callers should pause work and come back after RetryAfter.
+var ErrCircuitOpen = errors.New("ai provider unavailable: circuit open")
+
+// CircuitOpenError carries when the breaker will next let a call through.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v; retry in %s", ErrCircuitOpen, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Is(target error) bool { return target == ErrCircuitOpen }
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreaker opens after Threshold consecutive transient failures and
+// rejects calls for Cooldown. After that a single probe call is let through;
+// its outcome closes or re-opens the circuit.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown, now: time.Now}
+}
+
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		elapsed := b.now().Sub(b.openedAt)
+		if elapsed < b.Cooldown {
+			return &CircuitOpenError{RetryAfter: b.Cooldown - elapsed}
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// A probe is already in flight
+		return &CircuitOpenError{RetryAfter: b.Cooldown}
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !IsTransient(err) {
+		// Success, or a failure that says nothing about provider health
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.Threshold {
+		if b.state != breakerOpen {
+			log.Printf("ai circuit breaker open for %s after %d failures: %v", b.Cooldown, b.failures, err)
+		}
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// Intercept is the breaker's Interceptor.
+func (b *CircuitBreaker) Intercept(ctx context.Context, info CallInfo, next func(context.Context) error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := next(ctx)
+	if ctx.Err() != nil {
+		b.abandon()
+		return err
+	}
+	b.record(err)
+	return err
+}
+
+// abandon handles a call the caller gave up on; it says nothing about the
+// provider, but a half-open probe must not leave the breaker stuck.
+func (b *CircuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+		b.openedAt = b.now().Add(-b.Cooldown)
+	}
+}
+
+// Pausable reports whether err means the work should be parked and resumed
+// later rather than marked failed.
+func Pausable(err error) bool {
+	return errors.Is(err, ErrBudgetExceeded) || errors.Is(err, ErrCircuitOpen)
+}
+
+// ParseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func ParseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func failing(errs ...error) (func(context.Context) error, *int) {
+	calls := 0
+	return func(ctx context.Context) error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}, &calls
+}
+
+var throttled = &APIError{Provider: "gemini", StatusCode: http.StatusTooManyRequests, Message: "quota"}
+
+func TestRetry_RetriesTransientErrors(t *testing.T) {
+	retry := Retry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+	next, calls := failing(throttled, &APIError{StatusCode: 503})
+
+	err := retry(context.Background(), CallInfo{Task: TaskGrading}, next)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestRetry_StopsOnPermanentError(t *testing.T) {
+	retry := Retry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+	badRequest := &APIError{StatusCode: http.StatusBadRequest}
+	next, calls := failing(badRequest, badRequest)
+
+	err := retry(context.Background(), CallInfo{}, next)
+	assert.ErrorIs(t, err, badRequest)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestRetryPolicy_HonoursRetryAfter(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Minute}
+	assert.Equal(t, 7*time.Second, p.delay(0, &APIError{StatusCode: 429, RetryAfter: 7 * time.Second}))
+	assert.LessOrEqual(t, p.delay(2, throttled), 4*time.Millisecond)
+	assert.Equal(t, time.Minute, p.delay(0, &APIError{StatusCode: 429, RetryAfter: time.Hour}))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	down := func(ctx context.Context) error { return throttled }
+	up := func(ctx context.Context) error { return nil }
+	ctx := context.Background()
+
+	assert.Error(t, b.Intercept(ctx, CallInfo{}, down))
+	assert.Error(t, b.Intercept(ctx, CallInfo{}, down))
+
+	// Open: calls are rejected without reaching the provider
+	called := false
+	err := b.Intercept(ctx, CallInfo{}, func(ctx context.Context) error { called = true; return nil })
+	var open *CircuitOpenError
+	require.ErrorAs(t, err, &open)
+	assert.True(t, Pausable(err))
+	assert.False(t, called)
+	assert.Equal(t, time.Minute, open.RetryAfter)
+
+	// After the cooldown a failed probe re-opens it
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, b.Intercept(ctx, CallInfo{}, down), throttled)
+	assert.ErrorIs(t, b.Intercept(ctx, CallInfo{}, up), ErrCircuitOpen)
+
+	// A successful probe closes it
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.Intercept(ctx, CallInfo{}, up))
+	assert.NoError(t, b.Intercept(ctx, CallInfo{}, up))
+}
+
+func TestCircuitBreaker_IgnoresPermanentErrors(t *testing.T) {
+	b := NewCircuitBreaker(1, time.Minute)
+	bad := errors.New("unparseable response")
+	assert.ErrorIs(t, b.Intercept(context.Background(), CallInfo{}, func(ctx context.Context) error { return bad }), bad)
+	assert.NoError(t, b.Intercept(context.Background(), CallInfo{}, func(ctx context.Context) error { return nil }))
+}
+
+func TestTimeout(t *testing.T) {
+	timeout := Timeout(10 * time.Millisecond)
+	err := timeout(context.Background(), CallInfo{}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, IsTransient(err))
+}
+
+func TestChain_Order(t *testing.T) {
+	var order []string
+	mark := func(name string) Interceptor {
+		return func(ctx context.Context, info CallInfo, next func(context.Context) error) error {
+			order = append(order, name+":"+string(info.Task))
+			return next(ctx)
+		}
+	}
+	p := Chain(&namedProvider{name: "gemini"}, mark("outer"), mark("inner"))
+	_, err := p.GenerateFeedback(context.Background(), FeedbackRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"outer:feedback", "inner:feedback"}, order)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 30*time.Second, ParseRetryAfter("30"))
+	assert.Zero(t, ParseRetryAfter(""))
+	assert.Zero(t, ParseRetryAfter("soon"))
+}
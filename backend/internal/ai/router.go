@@ -0,0 +1,197 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// Task identifies the kind of work an AI call performs. Routing, model
+// selection and accounting are all keyed on it.
+type Task string
+
+const (
+	TaskGrading    Task = "grading"
+	TaskFeedback   Task = "feedback"
+	TaskAnalysis   Task = "analysis"
+	TaskRefinement Task = "refinement"
+	TaskDraft      Task = "draft"
+	TaskExtraction Task = "extraction"
+	TaskOCR        Task = "ocr"
+)
+
+// Routes decides which backends serve a call. Each entry is an ordered list of
+// backend names; the first one is tried first and the rest are fallbacks.
+// The most specific match wins: tenant, then evaluator, then task, then Default.
+type Routes struct {
+	Default     []string
+	ByTask      map[Task][]string
+	ByEvaluator map[string][]string
+	ByTenant    map[uuid.UUID][]string
+}
+
+// Router is an ai.Provider that dispatches each call to one of several named
+// backends and falls back down the list when a backend fails.
+type Router struct {
+	backends map[string]Provider
+	routes   Routes
+}
+
+func NewRouter(backends map[string]Provider, routes Routes) (*Router, error) {
+	if len(routes.Default) == 0 {
+		return nil, errors.New("ai router: no default route configured")
+	}
+	check := func(names []string) error {
+		for _, name := range names {
+			if _, ok := backends[name]; !ok {
+				return fmt.Errorf("ai router: unknown backend %q", name)
+			}
+		}
+		return nil
+	}
+	all := [][]string{routes.Default}
+	for _, names := range routes.ByTask {
+		all = append(all, names)
+	}
+	for _, names := range routes.ByEvaluator {
+		all = append(all, names)
+	}
+	for _, names := range routes.ByTenant {
+		all = append(all, names)
+	}
+	for _, names := range all {
+		if err := check(names); err != nil {
+			return nil, err
+		}
+	}
+	return &Router{backends: backends, routes: routes}, nil
+}
+
+// Resolve returns the ordered backend names for a call.
+func (r *Router) Resolve(ctx context.Context, task Task, evaluatorID string) []string {
+	if tenantID, err := auth.GetTenantID(ctx); err == nil {
+		if names, ok := r.routes.ByTenant[tenantID]; ok && len(names) > 0 {
+			return names
+		}
+	}
+	if evaluatorID != "" {
+		if names, ok := r.routes.ByEvaluator[evaluatorID]; ok && len(names) > 0 {
+			return names
+		}
+	}
+	if names, ok := r.routes.ByTask[task]; ok && len(names) > 0 {
+		return names
+	}
+	return r.routes.Default
+}
+
+// dispatch runs fn against each routed backend in order until one succeeds.
+func dispatch[T any](ctx context.Context, r *Router, task Task, evaluatorID string, fn func(Provider) (T, error)) (T, error) {
+	var zero T
+	var errs []error
+	for _, name := range r.Resolve(ctx, task, evaluatorID) {
+		res, err := fn(r.backends[name])
+		if err == nil {
+			return res, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		// A cancelled caller won't be helped by trying another backend
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return zero, fmt.Errorf("all ai backends failed: %w", errors.Join(errs...))
+}
+
+func (r *Router) Grade(ctx context.Context, req GradingRequest) (domain.GradingResult, error) {
+	return dispatch(ctx, r, TaskGrading, req.EvaluatorID, func(p Provider) (domain.GradingResult, error) {
+		return p.Grade(ctx, req)
+	})
+}
+
+func (r *Router) GenerateFeedback(ctx context.Context, req FeedbackRequest) (string, error) {
+	return dispatch(ctx, r, TaskFeedback, "", func(p Provider) (string, error) {
+		return p.GenerateFeedback(ctx, req)
+	})
+}
+
+func (r *Router) AnalyzePatterns(ctx context.Context, req AnalysisRequest) (AnalysisResult, error) {
+	return dispatch(ctx, r, TaskAnalysis, "", func(p Provider) (AnalysisResult, error) {
+		return p.AnalyzePatterns(ctx, req)
+	})
+}
+
+func (r *Router) RefineRubric(ctx context.Context, req RefineRubricRequest) (domain.Rubric, error) {
+	return dispatch(ctx, r, TaskRefinement, "", func(p Provider) (domain.Rubric, error) {
+		return p.RefineRubric(ctx, req)
+	})
+}
+
+func (r *Router) DraftRubric(ctx context.Context, req DraftRubricRequest) (domain.Rubric, error) {
+	return dispatch(ctx, r, TaskDraft, "", func(p Provider) (domain.Rubric, error) {
+		return p.DraftRubric(ctx, req)
+	})
+}
+
+func (r *Router) ExtractQuestions(ctx context.Context, req ExtractQuestionsRequest) ([]ExtractedQuestion, error) {
+	return dispatch(ctx, r, TaskExtraction, "", func(p Provider) ([]ExtractedQuestion, error) {
+		return p.ExtractQuestions(ctx, req)
+	})
+}
+
+// ParseRoutes reads a route spec of semicolon-separated rules, each of the
+// form "<selector>=<backend>[,<backend>...]". Selectors are "default",
+// "task:<task>", "evaluator:<id>" and "tenant:<uuid>". For example:
+//
+//	default=gemini,openai;task:feedback=local;evaluator:rubric_enforcer=openai
+func ParseRoutes(spec string) (Routes, error) {
+	routes := Routes{
+		ByTask:      map[Task][]string{},
+		ByEvaluator: map[string][]string{},
+		ByTenant:    map[uuid.UUID][]string{},
+	}
+	for _, rule := range strings.Split(spec, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		selector, list, ok := strings.Cut(rule, "=")
+		if !ok {
+			return Routes{}, fmt.Errorf("invalid route %q: expected selector=backends", rule)
+		}
+		var names []string
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return Routes{}, fmt.Errorf("invalid route %q: no backends", rule)
+		}
+
+		kind, value, _ := strings.Cut(strings.TrimSpace(selector), ":")
+		switch kind {
+		case "default":
+			routes.Default = names
+		case "task":
+			routes.ByTask[Task(value)] = names
+		case "evaluator":
+			routes.ByEvaluator[value] = names
+		case "tenant":
+			tenantID, err := uuid.Parse(value)
+			if err != nil {
+				return Routes{}, fmt.Errorf("invalid route %q: %w", rule, err)
+			}
+			routes.ByTenant[tenantID] = names
+		default:
+			return Routes{}, fmt.Errorf("invalid route %q: unknown selector %q", rule, kind)
+		}
+	}
+	return routes, nil
+}
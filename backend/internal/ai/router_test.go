@@ -0,0 +1,119 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type namedProvider struct {
+	name  string
+	fail  bool
+	calls int
+}
+
+func (p *namedProvider) Grade(ctx context.Context, req GradingRequest) (domain.GradingResult, error) {
+	p.calls++
+	if p.fail {
+		return domain.GradingResult{}, errors.New(p.name + " down")
+	}
+	return domain.GradingResult{AIEvaluatorID: p.name}, nil
+}
+
+func (p *namedProvider) GenerateFeedback(ctx context.Context, req FeedbackRequest) (string, error) {
+	p.calls++
+	if p.fail {
+		return "", errors.New(p.name + " down")
+	}
+	return p.name, nil
+}
+
+func (p *namedProvider) AnalyzePatterns(ctx context.Context, req AnalysisRequest) (AnalysisResult, error) {
+	return AnalysisResult{Recommendation: p.name}, nil
+}
+
+func (p *namedProvider) RefineRubric(ctx context.Context, req RefineRubricRequest) (domain.Rubric, error) {
+	return domain.Rubric{}, nil
+}
+
+func (p *namedProvider) DraftRubric(ctx context.Context, req DraftRubricRequest) (domain.Rubric, error) {
+	return domain.Rubric{}, nil
+}
+
+func (p *namedProvider) ExtractQuestions(ctx context.Context, req ExtractQuestionsRequest) ([]ExtractedQuestion, error) {
+	return nil, nil
+}
+
+func TestParseRoutes(t *testing.T) {
+	tenantID := uuid.New()
+	routes, err := ParseRoutes("default=gemini,openai; task:feedback=local ;evaluator:rubric_enforcer=openai;tenant:" + tenantID.String() + "=local,gemini")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"gemini", "openai"}, routes.Default)
+	assert.Equal(t, []string{"local"}, routes.ByTask[TaskFeedback])
+	assert.Equal(t, []string{"openai"}, routes.ByEvaluator["rubric_enforcer"])
+	assert.Equal(t, []string{"local", "gemini"}, routes.ByTenant[tenantID])
+
+	_, err = ParseRoutes("region:eu=gemini")
+	assert.Error(t, err)
+	_, err = ParseRoutes("tenant:not-a-uuid=gemini")
+	assert.Error(t, err)
+}
+
+func TestRouter_Resolve(t *testing.T) {
+	tenantID := uuid.New()
+	backends := map[string]Provider{
+		"gemini": &namedProvider{name: "gemini"},
+		"openai": &namedProvider{name: "openai"},
+		"local":  &namedProvider{name: "local"},
+	}
+	r, err := NewRouter(backends, Routes{
+		Default:     []string{"gemini"},
+		ByTask:      map[Task][]string{TaskFeedback: {"local"}},
+		ByEvaluator: map[string][]string{"rubric_enforcer": {"openai"}},
+		ByTenant:    map[uuid.UUID][]string{tenantID: {"local"}},
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	assert.Equal(t, []string{"gemini"}, r.Resolve(ctx, TaskGrading, "structural_analyzer"))
+	assert.Equal(t, []string{"openai"}, r.Resolve(ctx, TaskGrading, "rubric_enforcer"))
+	assert.Equal(t, []string{"local"}, r.Resolve(ctx, TaskFeedback, ""))
+	assert.Equal(t, []string{"local"}, r.Resolve(auth.WithTenantID(ctx, tenantID), TaskGrading, "rubric_enforcer"))
+
+	res, err := r.Grade(ctx, GradingRequest{EvaluatorID: "rubric_enforcer"})
+	require.NoError(t, err)
+	assert.Equal(t, "openai", res.AIEvaluatorID)
+}
+
+func TestRouter_Fallback(t *testing.T) {
+	primary := &namedProvider{name: "gemini", fail: true}
+	secondary := &namedProvider{name: "openai"}
+	r, err := NewRouter(map[string]Provider{"gemini": primary, "openai": secondary}, Routes{Default: []string{"gemini", "openai"}})
+	require.NoError(t, err)
+
+	text, err := r.GenerateFeedback(context.Background(), FeedbackRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "openai", text)
+	assert.Equal(t, 1, primary.calls)
+
+	secondary.fail = true
+	_, err = r.GenerateFeedback(context.Background(), FeedbackRequest{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gemini down")
+	assert.Contains(t, err.Error(), "openai down")
+}
+
+func TestNewRouter_UnknownBackend(t *testing.T) {
+	_, err := NewRouter(map[string]Provider{"gemini": &namedProvider{}}, Routes{Default: []string{"claude"}})
+	assert.Error(t, err)
+	_, err = NewRouter(map[string]Provider{"gemini": &namedProvider{}}, Routes{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,178 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// ErrBudgetExceeded is returned instead of calling the backend once a tenant
+// with a hard-stop budget has spent its monthly allowance. Callers should
+// pause the work rather than fail it.
+var ErrBudgetExceeded = errors.New("ai budget exceeded for tenant")
+
+// Usage is what a backend reports about one upstream call, taken from the
+// response's usage metadata.
+type Usage struct {
+	Provider     string
+	Model        string
+	InputTokens  int
+	OutputTokens int
+}
+
+type usageCollectorKey struct{}
+
+type usageCollector struct {
+	mu      sync.Mutex
+	entries []Usage
+}
+
+// ReportUsage is called by backends after every upstream response. It is a
+// no-op unless the call is running under a metering decorator.
+func ReportUsage(ctx context.Context, u Usage) {
+	c, ok := ctx.Value(usageCollectorKey{}).(*usageCollector)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	c.entries = append(c.entries, u)
+	c.mu.Unlock()
+}
+
+// Attribution ties AI spend to the work it was done for. Tenant falls back to
+// the request's tenant when unset.
+type Attribution struct {
+	TenantID     uuid.UUID
+	ExamID       *uuid.UUID
+	SubmissionID *uuid.UUID
+}
+
+type attributionKey struct{}
+
+func WithAttribution(ctx context.Context, a Attribution) context.Context {
+	return context.WithValue(ctx, attributionKey{}, a)
+}
+
+func AttributionFrom(ctx context.Context) Attribution {
+	a, _ := ctx.Value(attributionKey{}).(Attribution)
+	if a.TenantID == uuid.Nil {
+		if tenantID, err := auth.GetTenantID(ctx); err == nil {
+			a.TenantID = tenantID
+		}
+	}
+	return a
+}
+
+// ModelPrice is the list price of a model in USD per million tokens.
+type ModelPrice struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
+
+// Pricing maps model names, or model name prefixes, to prices.
+type Pricing map[string]ModelPrice
+
+// DefaultPricing covers the models we ship configuration for. Local models
+// are free and simply absent.
+var DefaultPricing = Pricing{
+	"gemini-3-flash":   {InputPerMillion: 0.50, OutputPerMillion: 3.00},
+	"gemini-2.5-pro":   {InputPerMillion: 1.25, OutputPerMillion: 10.00},
+	"gemini-2.5-flash": {InputPerMillion: 0.30, OutputPerMillion: 2.50},
+	"gpt-4o-mini":      {InputPerMillion: 0.15, OutputPerMillion: 0.60},
+	"gpt-4o":           {InputPerMillion: 2.50, OutputPerMillion: 10.00},
+}
+
+// Cost estimates the cost of a call. The longest matching prefix wins so that
+// "gpt-4o-mini" is not priced as "gpt-4o".
+func (p Pricing) Cost(model string, inputTokens, outputTokens int) float64 {
+	var best string
+	for name := range p {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return 0
+	}
+	price := p[best]
+	return (float64(inputTokens)*price.InputPerMillion + float64(outputTokens)*price.OutputPerMillion) / 1_000_000
+}
+
+// UsageRecorder persists metered calls.
+type UsageRecorder interface {
+	RecordUsage(ctx context.Context, usage *domain.AIUsage) error
+}
+
+// BudgetChecker returns ErrBudgetExceeded when a tenant may not spend more.
+type BudgetChecker interface {
+	CheckBudget(ctx context.Context, tenantID uuid.UUID) error
+}
+
+// Meter records every call with its tokens, latency and estimated cost, and
+// refuses calls for tenants that are over a hard budget.
+type Meter struct {
+	recorder UsageRecorder
+	budget   BudgetChecker
+	pricing  Pricing
+}
+
+func NewMeter(recorder UsageRecorder, budget BudgetChecker, pricing Pricing) *Meter {
+	if pricing == nil {
+		pricing = DefaultPricing
+	}
+	return &Meter{recorder: recorder, budget: budget, pricing: pricing}
+}
+
+// Intercept meters one logical call. Fallbacks inside a router can produce
+// several upstream calls; each is recorded separately.
+func (m *Meter) Intercept(ctx context.Context, info CallInfo, next func(context.Context) error) error {
+	attr := AttributionFrom(ctx)
+
+	if m.budget != nil && attr.TenantID != uuid.Nil {
+		if err := m.budget.CheckBudget(ctx, attr.TenantID); err != nil {
+			return err
+		}
+	}
+
+	collector := &usageCollector{}
+	start := time.Now()
+	err := next(context.WithValue(ctx, usageCollectorKey{}, collector))
+	latency := time.Since(start).Milliseconds()
+
+	entries := collector.entries
+	if len(entries) == 0 {
+		// The backend reported nothing (e.g. the call failed before a response);
+		// still record the attempt so latency and failures are visible
+		entries = []Usage{{}}
+	}
+	for i, u := range entries {
+		record := &domain.AIUsage{
+			TenantID:     attr.TenantID,
+			ExamID:       attr.ExamID,
+			SubmissionID: attr.SubmissionID,
+			Task:         string(info.Task),
+			EvaluatorID:  info.EvaluatorID,
+			Provider:     u.Provider,
+			Model:        u.Model,
+			InputTokens:  u.InputTokens,
+			OutputTokens: u.OutputTokens,
+			LatencyMs:    latency,
+			CostUSD:      m.pricing.Cost(u.Model, u.InputTokens, u.OutputTokens),
+			// Only the last upstream call can have produced the result
+			Success: err == nil && i == len(entries)-1,
+		}
+		// Accounting must never fail the grading it accounts for
+		if recErr := m.recorder.RecordUsage(context.WithoutCancel(ctx), record); recErr != nil {
+			log.Printf("failed to record ai usage: %v", recErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memRecorder struct {
+	records []*domain.AIUsage
+}
+
+func (m *memRecorder) RecordUsage(ctx context.Context, usage *domain.AIUsage) error {
+	m.records = append(m.records, usage)
+	return nil
+}
+
+type fixedBudget struct{ err error }
+
+func (b fixedBudget) CheckBudget(ctx context.Context, tenantID uuid.UUID) error { return b.err }
+
+type reportingProvider struct {
+	namedProvider
+}
+
+func (p *reportingProvider) Grade(ctx context.Context, req GradingRequest) (domain.GradingResult, error) {
+	ReportUsage(ctx, Usage{Provider: "openai", Model: "gpt-4o-mini", InputTokens: 1_000_000, OutputTokens: 500_000})
+	return p.namedProvider.Grade(ctx, req)
+}
+
+func TestPricing_Cost(t *testing.T) {
+	p := Pricing{
+		"gpt-4o":      {InputPerMillion: 2.50, OutputPerMillion: 10},
+		"gpt-4o-mini": {InputPerMillion: 0.15, OutputPerMillion: 0.60},
+	}
+	assert.InDelta(t, 0.45, p.Cost("gpt-4o-mini-2024-07-18", 1_000_000, 500_000), 1e-9)
+	assert.InDelta(t, 7.50, p.Cost("gpt-4o", 1_000_000, 500_000), 1e-9)
+	assert.Zero(t, p.Cost("llama3.1", 1_000_000, 500_000))
+}
+
+func TestMeter_RecordsAttributedUsage(t *testing.T) {
+	rec := &memRecorder{}
+	meter := NewMeter(rec, fixedBudget{}, nil)
+	provider := Chain(&reportingProvider{namedProvider{name: "openai"}}, meter.Intercept)
+
+	tenantID, examID, subID := uuid.New(), uuid.New(), uuid.New()
+	ctx := WithAttribution(context.Background(), Attribution{TenantID: tenantID, ExamID: &examID, SubmissionID: &subID})
+
+	_, err := provider.Grade(ctx, GradingRequest{EvaluatorID: "rubric_enforcer"})
+	require.NoError(t, err)
+
+	require.Len(t, rec.records, 1)
+	u := rec.records[0]
+	assert.Equal(t, tenantID, u.TenantID)
+	assert.Equal(t, examID, *u.ExamID)
+	assert.Equal(t, subID, *u.SubmissionID)
+	assert.Equal(t, "grading", u.Task)
+	assert.Equal(t, "rubric_enforcer", u.EvaluatorID)
+	assert.Equal(t, 1_000_000, u.InputTokens)
+	assert.InDelta(t, 0.45, u.CostUSD, 1e-9)
+	assert.True(t, u.Success)
+}
+
+func TestMeter_HardBudgetStopsCall(t *testing.T) {
+	rec := &memRecorder{}
+	inner := &namedProvider{name: "gemini"}
+	meter := NewMeter(rec, fixedBudget{err: ErrBudgetExceeded}, nil)
+
+	ctx := WithAttribution(context.Background(), Attribution{TenantID: uuid.New()})
+	_, err := Chain(inner, meter.Intercept).GenerateFeedback(ctx, FeedbackRequest{})
+
+	assert.True(t, errors.Is(err, ErrBudgetExceeded))
+	assert.Zero(t, inner.calls)
+	assert.Empty(t, rec.records)
+}
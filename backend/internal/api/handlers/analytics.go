@@ -2,7 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 
 	"harama/internal/auth"
 	"harama/internal/service"
@@ -37,7 +41,7 @@ func (h *AnalyticsHandler) GetGradingTrends(w http.ResponseWriter, r *http.Reque
 		examIDPtr = &examID
 	}
 
-	trends, err := h.service.GetGradingTrends(r.Context(), tenantID, examIDPtr)
+	trends, err := h.service.GetGradingTrends(r.Context(), tenantID, examIDPtr, r.URL.Query().Get("group_by"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -47,6 +51,9 @@ func (h *AnalyticsHandler) GetGradingTrends(w http.ResponseWriter, r *http.Reque
 	json.NewEncoder(w).Encode(trends)
 }
 
+// ExportGrades downloads an exam's grades as csv (the default), xlsx, json
+// or jsonl. A strict export that would leave anything out fails with 422;
+// otherwise the number of warnings is in X-Export-Warnings.
 func (h *AnalyticsHandler) ExportGrades(w http.ResponseWriter, r *http.Request) {
 	examIDStr := chi.URLParam(r, "id")
 	examID, err := uuid.Parse(examIDStr)
@@ -56,23 +63,59 @@ func (h *AnalyticsHandler) ExportGrades(w http.ResponseWriter, r *http.Request)
 	}
 
 	var req struct {
-		Format string `json:"format"`
+		Format          string   `json:"format"`
+		GroupBy         string   `json:"group_by"`
+		Columns         []string `json:"columns"`
+		QuestionHeaders string   `json:"question_headers"`
+		Strict          bool     `json:"strict"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		// Default to CSV if body is empty or invalid?
-		req.Format = "csv"
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if req.Format == "" {
-		req.Format = "csv"
+
+	export, err := h.service.ExportGrades(r.Context(), examID, service.GradeExportOptions{
+		Format:          req.Format,
+		GroupBy:         req.GroupBy,
+		Columns:         req.Columns,
+		QuestionHeaders: req.QuestionHeaders,
+		Strict:          req.Strict,
+	})
+	switch {
+	case errors.Is(err, service.ErrUnsupportedFormat), errors.Is(err, service.ErrInvalidExportOption):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrExportIncomplete):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), statusFor(err))
+		return
 	}
 
-	data, contentType, err := h.service.ExportGrades(r.Context(), examID, req.Format)
+	w.Header().Set("Content-Type", export.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.Filename))
+	w.Header().Set("X-Export-Warnings", strconv.Itoa(len(export.Warnings)))
+	w.Write(export.Data)
+}
+
+// ItemAnalysis reports how well an exam's questions measured its students:
+// facility, discrimination, point-biserial and criterion hit rates per
+// question, Cronbach's alpha for the exam, and flags for questions worth
+// reviewing.
+func (h *AnalyticsHandler) ItemAnalysis(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Disposition", "attachment; filename=grades.csv")
-	w.Write(data)
-}
\ No newline at end of file
+	report, err := h.service.ItemAnalysis(r.Context(), examID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type APIKeyHandler struct {
+	service *service.APIKeyService
+}
+
+func NewAPIKeyHandler(s *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{service: s}
+}
+
+// CreateKey issues an API key. The response is the only time the key itself
+// is returned.
+func (h *APIKeyHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var key domain.APIKey
+	if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key.TenantID = tenantID
+
+	secret, err := h.service.CreateKey(r.Context(), &key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		domain.APIKey
+		Key string `json:"key"`
+	}{key, secret})
+}
+
+func (h *APIKeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := h.service.ListKeys(r.Context(), tenantID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (h *APIKeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	keyID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid API key id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.RevokeKey(r.Context(), tenantID, keyID); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,11 +2,18 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"harama/internal/auth"
+	"harama/internal/repository/postgres"
 	"harama/internal/service"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 type AuditHandler struct {
@@ -35,3 +42,169 @@ func (h *AuditHandler) GetLogs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(logs)
 }
+
+// Search lists the tenant's audit entries, newest first, filtered by
+// ?actor_id=, ?actor_type=, ?event_type=, ?entity_type=, ?entity_id= and a
+// ?from= / ?to= RFC 3339 time range. ?cursor= is the next_cursor of the
+// previous page and ?limit= the page size.
+func (h *AuditHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.GetTenantID(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := auditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if v := q.Get("cursor"); v != "" {
+		if filter.After, err = strconv.ParseInt(v, 10, 64); err != nil || filter.After <= 0 {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := h.service.SearchLogs(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// Export downloads every audit entry matching the Search filters, oldest
+// first, as ?format=jsonl (the default) or csv.
+func (h *AuditHandler) Export(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "jsonl"
+	}
+	contentType, ok := service.AuditExportFormats[format]
+	if !ok {
+		http.Error(w, "format must be jsonl or csv", http.StatusBadRequest)
+		return
+	}
+	filter, err := auditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit_log_%s.%s"`, tenantID, format))
+	if err := h.service.ExportLogs(r.Context(), filter, format, w); err != nil {
+		// Once rows have gone out this only appends to a truncated body
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// auditFilter reads the filters Search and Export share from the query.
+func auditFilter(r *http.Request) (postgres.AuditFilter, error) {
+	q := r.URL.Query()
+	f := postgres.AuditFilter{
+		ActorType:  q.Get("actor_type"),
+		EventType:  q.Get("event_type"),
+		EntityType: q.Get("entity_type"),
+	}
+	for name, dst := range map[string]**uuid.UUID{"actor_id": &f.ActorID, "entity_id": &f.EntityID} {
+		if v := q.Get(name); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s", name)
+			}
+			*dst = &id
+		}
+	}
+	for name, dst := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s: want an RFC 3339 time", name)
+			}
+			*dst = &t
+		}
+	}
+	return f, nil
+}
+
+// VerifyChain checks the tenant's audit chain. A broken chain is still a
+// 200; the report says where it breaks.
+func (h *AuditHandler) VerifyChain(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.GetTenantID(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	report, err := h.service.VerifyChain(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// CreateCheckpoint signs the head of the tenant's chain now, rather than
+// waiting for the worker's next round.
+func (h *AuditHandler) CreateCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.GetTenantID(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	cp, err := h.service.CreateCheckpoint(r.Context())
+	switch {
+	case errors.Is(err, service.ErrNoSigningKey):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, service.ErrChainBroken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case cp == nil:
+		http.Error(w, "audit chain is empty", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(cp)
+}
+
+// ExportCheckpoints returns the tenant's signed checkpoints as a download.
+func (h *AuditHandler) ExportCheckpoints(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	export, err := h.service.ExportCheckpoints(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit_checkpoints_%s.json"`, tenantID))
+	json.NewEncoder(w).Encode(export)
+}
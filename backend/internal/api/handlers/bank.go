@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type BankHandler struct {
+	service *service.BankService
+}
+
+func NewBankHandler(s *service.BankService) *BankHandler {
+	return &BankHandler{service: s}
+}
+
+// CreateItem adds a question with its rubric and tags to the question
+// bank. An item with mistakes is a 422 listing every one of them.
+func (h *BankHandler) CreateItem(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var item domain.BankItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item.TenantID = tenantID
+
+	if err := h.service.CreateItem(r.Context(), &item); err != nil {
+		writeBankError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+// SaveQuestion adds a question of an exam to the question bank, tagged
+// with the body's topic, learning_objective, difficulty and grade_level.
+func (h *BankHandler) SaveQuestion(w http.ResponseWriter, r *http.Request) {
+	questionID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid question id", http.StatusBadRequest)
+		return
+	}
+
+	var tags domain.BankItem
+	if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item, err := h.service.SaveQuestion(r.Context(), questionID, &tags)
+	if err != nil {
+		writeBankError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+// SearchItems lists the question bank, each item with its statistics,
+// filtered by ?q= words of its text, topic or learning objective, and by
+// ?topic=, ?learning_objective=, ?difficulty=, ?grade_level= and
+// ?answer_type=. ?limit= and ?offset= page through it.
+func (h *BankHandler) SearchItems(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.GetTenantID(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := postgres.BankFilter{
+		Query:             q.Get("q"),
+		Topic:             q.Get("topic"),
+		LearningObjective: q.Get("learning_objective"),
+		Difficulty:        domain.Difficulty(q.Get("difficulty")),
+		GradeLevel:        q.Get("grade_level"),
+		AnswerType:        domain.AnswerType(q.Get("answer_type")),
+	}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	items, err := h.service.SearchItems(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func (h *BankHandler) GetItem(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid bank item id", http.StatusBadRequest)
+		return
+	}
+
+	item, err := h.service.GetItem(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// UpdateItem replaces a bank item's question, rubric and tags. The
+// response tells which linked questions were updated to match and how many
+// were detached for having been graded.
+func (h *BankHandler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid bank item id", http.StatusBadRequest)
+		return
+	}
+
+	var edit domain.BankItem
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	update, err := h.service.UpdateItem(r.Context(), id, &edit)
+	if err != nil {
+		writeBankError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(update)
+}
+
+func (h *BankHandler) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid bank item id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeleteItem(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddToExam adds bank items to an exam as questions, from a body of
+// {"use": "reference"|"copy", "items": [{"bank_item_id", "number",
+// "group"}]}. Placements that can't be made are a 422 listing every one of
+// them, and nothing is added.
+func (h *BankHandler) AddToExam(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Use   string                  `json:"use"`
+		Items []service.BankPlacement `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	questions, err := h.service.AddToExam(r.Context(), examID, req.Use, req.Items)
+	if err != nil {
+		writeBankError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(questions)
+}
+
+// writeBankError reports a failed change to the question bank: an item or
+// placement with mistakes is a 422, a question already in the bank a 409.
+func writeBankError(w http.ResponseWriter, err error) {
+	var (
+		itemErr *service.BankItemError
+		defErr  *service.ExamDefinitionError
+	)
+	switch {
+	case errors.As(err, &itemErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  itemErr.Error(),
+			"errors": itemErr.Errors,
+		})
+	case errors.As(err, &defErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  defErr.Error(),
+			"errors": defErr.Errors,
+		})
+	case errors.Is(err, service.ErrInvalidBankUse):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrAlreadyInBank):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), statusFor(err))
+	}
+}
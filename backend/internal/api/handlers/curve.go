@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"harama/internal/domain"
+	"harama/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// activeCurve names the active version of an exam's curve in routes.
+const activeCurve = "active"
+
+type CurveHandler struct {
+	service *service.CurveService
+}
+
+func NewCurveHandler(s *service.CurveService) *CurveHandler {
+	return &CurveHandler{service: s}
+}
+
+// curveRequest is a curve policy, and for one being applied a note saying
+// why.
+type curveRequest struct {
+	domain.CurvePolicy
+	Note string `json:"note"`
+}
+
+// Distribution reports how an exam's totals, curved totals and question
+// scores are spread, with ?bins= histogram bins (10 by default).
+func (h *CurveHandler) Distribution(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+	bins, ok := histogramBins(w, r)
+	if !ok {
+		return
+	}
+
+	dist, err := h.service.Distribution(r.Context(), examID, bins)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dist)
+}
+
+// PreviewCurve shows what a curve policy, the body, would do to an exam's
+// totals without applying it.
+func (h *CurveHandler) PreviewCurve(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+	bins, ok := histogramBins(w, r)
+	if !ok {
+		return
+	}
+
+	var req curveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	preview, err := h.service.Preview(r.Context(), examID, req.CurvePolicy, bins)
+	if err != nil {
+		writeCurveError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// ApplyCurve applies a curve policy, with an optional note, to an exam's
+// totals as the next version of its curve.
+func (h *CurveHandler) ApplyCurve(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	var req curveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	curve, err := h.service.Apply(r.Context(), examID, req.CurvePolicy, req.Note)
+	if err != nil {
+		writeCurveError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(curve)
+}
+
+func (h *CurveHandler) ListCurves(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	curves, err := h.service.ListCurves(r.Context(), examID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(curves)
+}
+
+// GetCurve returns a version of an exam's curve with the totals it gave,
+// or with "active" as the version the curve applied now.
+func (h *CurveHandler) GetCurve(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	var curve *domain.GradeCurve
+	if v := chi.URLParam(r, "version"); v == activeCurve {
+		curve, err = h.service.ActiveCurve(r.Context(), examID)
+	} else {
+		version, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			http.Error(w, "invalid curve version", http.StatusBadRequest)
+			return
+		}
+		curve, err = h.service.GetCurve(r.Context(), examID, version)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(curve)
+}
+
+// RemoveCurve leaves an exam's totals uncurved, keeping the curve on
+// record.
+func (h *CurveHandler) RemoveCurve(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.RemoveCurve(r.Context(), examID); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// histogramBins reads ?bins=, writing a 400 if it is out of range.
+func histogramBins(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("bins")
+	if v == "" {
+		return service.DefaultHistogramBins, true
+	}
+	bins, err := strconv.Atoi(v)
+	if err != nil || bins < 1 || bins > service.MaxHistogramBins {
+		http.Error(w, "invalid bins", http.StatusBadRequest)
+		return 0, false
+	}
+	return bins, true
+}
+
+func writeCurveError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrInvalidCurvePolicy) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), statusFor(err))
+}
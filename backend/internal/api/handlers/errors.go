@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"harama/internal/auth"
+)
+
+// statusFor maps a service error to a response status: authorization
+// failures are 403, records missing or owned by another tenant 404, anything
+// else an internal error.
+func statusFor(err error) int {
+	if errors.Is(err, auth.ErrForbidden) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
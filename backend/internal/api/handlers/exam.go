@@ -2,6 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 
 	"harama/internal/auth"
@@ -12,6 +16,10 @@ import (
 	"github.com/google/uuid"
 )
 
+// maxExamDefinitionSize bounds an imported exam definition, which may be a
+// QTI package with images
+const maxExamDefinitionSize = 20 << 20
+
 type ExamHandler struct {
 	service *service.ExamService
 }
@@ -63,6 +71,34 @@ func (h *ExamHandler) GetExam(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(exam)
 }
 
+// CloneExam copies an exam, its questions and rubrics, as a new exam to
+// use as a template. The body may give the copy a {"title"}.
+func (h *ExamHandler) CloneExam(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Title string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exam, err := h.service.CloneExam(r.Context(), id, req.Title)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(exam)
+}
+
 func (h *ExamHandler) AddQuestion(w http.ResponseWriter, r *http.Request) {
 	examIDStr := chi.URLParam(r, "id")
 	examID, err := uuid.Parse(examIDStr)
@@ -78,7 +114,7 @@ func (h *ExamHandler) AddQuestion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.AddQuestion(r.Context(), examID, &question); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusFor(err))
 		return
 	}
 
@@ -102,7 +138,7 @@ func (h *ExamHandler) SetRubric(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.SetRubric(r.Context(), questionID, &rubric); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusFor(err))
 		return
 	}
 
@@ -126,3 +162,84 @@ func (h *ExamHandler) ListExams(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(exams)
 }
+
+// ExportDefinition downloads an exam's complete definition as a JSON (the
+// default) or YAML exam document, or a QTI 2.1 package: ?format=json|yaml|qti.
+func (h *ExamHandler) ExportDefinition(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	export, err := h.service.ExportDefinition(r.Context(), id, r.URL.Query().Get("format"))
+	if errors.Is(err, service.ErrUnsupportedFormat) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.Filename))
+	w.Write(export.Data)
+}
+
+// ImportDefinition creates an exam from a definition in the request body.
+// Its format is ?format=json|yaml|qti or else told by the Content-Type. A
+// definition with mistakes is a 422 listing every one of them, and creates
+// nothing.
+func (h *ExamHandler) ImportDefinition(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = definitionFormat(r.Header.Get("Content-Type"))
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxExamDefinitionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exam, err := h.service.ImportDefinition(r.Context(), tenantID, format, data)
+	var defErr *service.ExamDefinitionError
+	switch {
+	case errors.As(err, &defErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  defErr.Error(),
+			"errors": defErr.Errors,
+		})
+		return
+	case errors.Is(err, service.ErrUnsupportedFormat), errors.Is(err, service.ErrInvalidExamDefinition):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(exam)
+}
+
+// definitionFormat is the format of an exam definition sent with a content
+// type, JSON unless it says otherwise.
+func definitionFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return "yaml"
+	case "application/zip", "application/xml", "text/xml":
+		return "qti"
+	}
+	return "json"
+}
@@ -11,11 +11,12 @@ import (
 )
 
 type FeedbackHandler struct {
-	service *service.FeedbackService
+	service       *service.FeedbackService
+	rosterService *service.RosterService
 }
 
-func NewFeedbackHandler(s *service.FeedbackService) *FeedbackHandler {
-	return &FeedbackHandler{service: s}
+func NewFeedbackHandler(s *service.FeedbackService, roster *service.RosterService) *FeedbackHandler {
+	return &FeedbackHandler{service: s, rosterService: roster}
 }
 
 func (h *FeedbackHandler) CaptureOverride(w http.ResponseWriter, r *http.Request) {
@@ -38,6 +39,11 @@ func (h *FeedbackHandler) CaptureOverride(w http.ResponseWriter, r *http.Request
 		score = body.NewScore
 	}
 
+	if err := h.rosterService.AuthorizeGradeChange(r.Context(), subID); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
 	err := h.service.CaptureOverrideFeedback(r.Context(), subID, questionID, score, body.Reason)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -54,7 +60,7 @@ func (h *FeedbackHandler) GetStudentFeedback(w http.ResponseWriter, r *http.Requ
 
 	feedback, err := h.service.GenerateStudentFeedback(r.Context(), subID, questionID, studentName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusFor(err))
 		return
 	}
 
@@ -66,7 +72,7 @@ func (h *FeedbackHandler) AnalyzePatterns(w http.ResponseWriter, r *http.Request
 
 	result, err := h.service.AnalyzeQuestionPatterns(r.Context(), questionID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusFor(err))
 		return
 	}
 
@@ -78,7 +84,7 @@ func (h *FeedbackHandler) AdaptRubric(w http.ResponseWriter, r *http.Request) {
 
 	err := h.service.AdaptRubric(r.Context(), questionID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusFor(err))
 		return
 	}
 
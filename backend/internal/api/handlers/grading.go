@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"harama/internal/auth"
 	"harama/internal/service"
 
 	"github.com/go-chi/chi/v5"
@@ -29,10 +30,74 @@ func (h *GradingHandler) GetGrades(w http.ResponseWriter, r *http.Request) {
 	
 	grades, err := h.service.GetGrades(r.Context(), subID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusFor(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(grades)
 }
+
+// GetLineage returns a grade with the audit entries behind it, oldest first.
+func (h *GradingHandler) GetLineage(w http.ResponseWriter, r *http.Request) {
+	gradeID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid grade id", http.StatusBadRequest)
+		return
+	}
+
+	lineage, err := h.service.GetGradeLineage(r.Context(), gradeID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lineage)
+}
+
+// ListEscalations returns the tenant's escalated grades, optionally filtered
+// by ?status= (pending or resolved).
+func (h *GradingHandler) ListEscalations(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	escalations, err := h.service.ListEscalations(r.Context(), tenantID, r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(escalations)
+}
+
+// ResolveEscalation settles an escalated grade with the reviewer's score.
+func (h *GradingHandler) ResolveEscalation(w http.ResponseWriter, r *http.Request) {
+	escalationID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid escalation id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Score  float64 `json:"score"`
+		Reason string  `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	grade, err := h.service.ResolveEscalation(r.Context(), escalationID, body.Score, body.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grade)
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/lti"
+	"harama/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type LTIHandler struct {
+	service  *service.LTIService
+	tool     *lti.Tool
+	redirect string
+}
+
+// NewLTIHandler serves the tool's LTI endpoints; completed launches are
+// redirected to redirect.
+func NewLTIHandler(s *service.LTIService, tool *lti.Tool, redirect string) *LTIHandler {
+	return &LTIHandler{service: s, tool: tool, redirect: redirect}
+}
+
+// KeySet publishes the key platforms verify our client assertions with.
+func (h *LTIHandler) KeySet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.tool.KeySet())
+}
+
+// Login answers a platform's third party initiated login, by GET or form
+// POST, by sending the browser back to the platform to authenticate.
+func (h *LTIHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	redirect, err := h.service.Login(r.Context(), lti.LoginParams{
+		Issuer:        r.Form.Get("iss"),
+		ClientID:      r.Form.Get("client_id"),
+		LoginHint:     r.Form.Get("login_hint"),
+		MessageHint:   r.Form.Get("lti_message_hint"),
+		TargetLinkURI: r.Form.Get("target_link_uri"),
+	})
+	if errors.Is(err, lti.ErrInvalidLaunch) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// Launch takes the id_token a platform posts after login and sends the
+// browser on to the frontend with the exam the link is bound to.
+func (h *LTIHandler) Launch(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	launch, err := h.service.Launch(r.Context(), r.PostForm.Get("id_token"), r.PostForm.Get("state"))
+	if errors.Is(err, lti.ErrInvalidLaunch) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	target, err := url.Parse(h.redirect)
+	if err != nil {
+		http.Error(w, "invalid launch redirect", http.StatusInternalServerError)
+		return
+	}
+	q := target.Query()
+	if launch.ExamID != nil {
+		q.Set("exam_id", launch.ExamID.String())
+	}
+	if launch.Instructor {
+		q.Set("role", "instructor")
+	} else {
+		q.Set("role", "learner")
+		q.Set("linked", strconv.FormatBool(launch.UserLink != nil))
+	}
+	target.RawQuery = q.Encode()
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+func (h *LTIHandler) RegisterPlatform(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var p domain.LTIPlatform
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.TenantID = tenantID
+
+	if err := h.service.RegisterPlatform(r.Context(), &p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+func (h *LTIHandler) ListPlatforms(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	platforms, err := h.service.ListPlatforms(r.Context(), tenantID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(platforms)
+}
+
+func (h *LTIHandler) DeletePlatform(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid platform id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeletePlatform(r.Context(), tenantID, id); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LinkUser maps a platform's user to a student on the roster, so their
+// scores can be published without a launch.
+func (h *LTIHandler) LinkUser(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	platformID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid platform id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		LMSUserID string `json:"lms_user_id"`
+		StudentID string `json:"student_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.service.LinkUser(r.Context(), tenantID, platformID, body.LMSUserID, body.StudentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "platform not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(link)
+}
+
+// ListLineItems returns the LMS line items an exam's scores are published
+// to.
+func (h *LTIHandler) ListLineItems(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.GetTenantID(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	items, err := h.service.ListLineItems(r.Context(), examID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// ListScorePushes returns the latest scores published or queued, with the
+// outcome of their last attempt.
+func (h *LTIHandler) ListScorePushes(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	pushes, err := h.service.ListScorePushes(r.Context(), tenantID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pushes)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/progress"
+	"harama/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// sseHeartbeat is how often an idle stream sends a comment, so proxies
+// don't close it.
+const sseHeartbeat = 15 * time.Second
+
+// ProgressHandler serves progress events as Server-Sent Events. Each event
+// is named after its stage and carries the progress.Event as JSON.
+type ProgressHandler struct {
+	bus         *progress.Bus
+	ocrService  *service.OCRService
+	examService *service.ExamService
+}
+
+func NewProgressHandler(bus *progress.Bus, ocr *service.OCRService, exams *service.ExamService) *ProgressHandler {
+	return &ProgressHandler{bus: bus, ocrService: ocr, examService: exams}
+}
+
+// SubmissionEvents streams one submission's progress. The stream opens with
+// a "status" event holding the submission's current processing status, so
+// a client that subscribes late knows where it stands.
+func (h *ProgressHandler) SubmissionEvents(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid submission id", http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe before reading the status so nothing falls in between
+	events, cancel := h.bus.Subscribe(progress.ForSubmission(tenantID, id))
+	defer cancel()
+	sub, err := h.ocrService.GetByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	streamProgress(w, r, events, map[string]interface{}{
+		"submission_id":     sub.ID,
+		"exam_id":           sub.ExamID,
+		"processing_status": sub.ProcessingStatus,
+	})
+}
+
+// ExamEvents streams the progress of every submission of an exam.
+func (h *ProgressHandler) ExamEvents(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	events, cancel := h.bus.Subscribe(progress.ForExam(tenantID, id))
+	defer cancel()
+	if _, err := h.examService.GetExam(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	streamProgress(w, r, events, nil)
+}
+
+// BatchEvents streams the progress of the submissions queued for grading
+// together by SubmissionHandler.GradeExam. Batches aren't stored; only the
+// tenant's own events ever match.
+func (h *ProgressHandler) BatchEvents(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid batch id", http.StatusBadRequest)
+		return
+	}
+
+	events, cancel := h.bus.Subscribe(progress.ForBatch(tenantID, id))
+	defer cancel()
+	streamProgress(w, r, events, nil)
+}
+
+// streamProgress writes events to w until the client goes away, opening
+// with a "status" event when status is set.
+func streamProgress(w http.ResponseWriter, r *http.Request, events <-chan progress.Event, status interface{}) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if status != nil {
+		writeSSE(w, "status", status)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			writeSSE(w, string(e.Stage), e)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event string, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"harama/internal/domain"
+	"harama/internal/service"
+	"harama/internal/worker"
+	"harama/internal/worker/jobs"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// maxQuestionPaperSize bounds an uploaded question paper, all of its pages
+// together
+const maxQuestionPaperSize = 50 << 20
+
+type QuestionPaperHandler struct {
+	service    *service.QuestionPaperService
+	workerPool *worker.WorkerPool
+}
+
+func NewQuestionPaperHandler(s *service.QuestionPaperService, pool *worker.WorkerPool) *QuestionPaperHandler {
+	return &QuestionPaperHandler{service: s, workerPool: pool}
+}
+
+// Upload takes an exam's question paper as multipart form files named
+// "files": one PDF, or photos of its pages in order. It is read into a
+// draft of the exam's questions in the background; the draft is polled at
+// status_url and confirmed at confirm_url.
+func (h *QuestionPaperHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxQuestionPaperSize)
+	if err := r.ParseMultipartForm(maxQuestionPaperSize); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var files []service.QuestionPaperUpload
+	for _, fh := range r.MultipartForm.File["files"] {
+		f, err := fh.Open()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		contentType, _, _ := mime.ParseMediaType(fh.Header.Get("Content-Type"))
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+		}
+		files = append(files, service.QuestionPaperUpload{Data: data, ContentType: contentType})
+	}
+
+	paper, err := h.service.Upload(r.Context(), examID, files)
+	if errors.Is(err, service.ErrInvalidQuestionPaper) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	h.workerPool.Submit(&jobs.QuestionPaperJob{
+		TenantID:        paper.TenantID,
+		QuestionPaperID: paper.ID,
+		Actor:           requestActor(r),
+		Service:         h.service,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"question_paper": paper,
+		"status_url":     "/api/v1/question-papers/" + paper.ID.String(),
+		"confirm_url":    "/api/v1/question-papers/" + paper.ID.String() + "/confirm",
+	})
+}
+
+func (h *QuestionPaperHandler) List(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	papers, err := h.service.ListQuestionPapers(r.Context(), examID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(papers)
+}
+
+func (h *QuestionPaperHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid question paper id", http.StatusBadRequest)
+		return
+	}
+
+	paper, err := h.service.GetQuestionPaper(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(paper)
+}
+
+// UpdateDraft replaces the draft questions of a question paper awaiting
+// review with the teacher's edit of them, a JSON array of questions.
+func (h *QuestionPaperHandler) UpdateDraft(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid question paper id", http.StatusBadRequest)
+		return
+	}
+
+	var draft []domain.Question
+	if err := json.NewDecoder(r.Body).Decode(&draft); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	paper, err := h.service.UpdateDraft(r.Context(), id, draft)
+	if err != nil {
+		writeQuestionPaperError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(paper)
+}
+
+// Confirm creates the draft's questions in the exam. A draft with mistakes
+// is a 422 listing every one of them, and creates nothing.
+func (h *QuestionPaperHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid question paper id", http.StatusBadRequest)
+		return
+	}
+
+	questions, err := h.service.Confirm(r.Context(), id)
+	if err != nil {
+		writeQuestionPaperError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(questions)
+}
+
+// writeQuestionPaperError reports a failed change to a question paper: one
+// not awaiting review is a 409, a draft with mistakes a 422.
+func writeQuestionPaperError(w http.ResponseWriter, err error) {
+	var defErr *service.ExamDefinitionError
+	switch {
+	case errors.As(err, &defErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  defErr.Error(),
+			"errors": defErr.Errors,
+		})
+	case errors.Is(err, service.ErrQuestionPaperNotReady):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), statusFor(err))
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"harama/internal/domain"
+	"harama/internal/service"
+	"harama/internal/worker"
+	"harama/internal/worker/jobs"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type ReportHandler struct {
+	service    *service.ReportService
+	workerPool *worker.WorkerPool
+}
+
+func NewReportHandler(s *service.ReportService, pool *worker.WorkerPool) *ReportHandler {
+	return &ReportHandler{service: s, workerPool: pool}
+}
+
+// CreateSubmissionReport queues a student's marked paper as a PDF. Its
+// status is polled at status_url until it can be downloaded.
+func (h *ReportHandler) CreateSubmissionReport(w http.ResponseWriter, r *http.Request) {
+	subID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid submission id", http.StatusBadRequest)
+		return
+	}
+
+	rep, err := h.service.RequestSubmissionReport(r.Context(), subID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	h.queue(w, r, rep)
+}
+
+// CreateExamReport queues the marked papers of an exam's graded
+// submissions as a ZIP of PDFs.
+func (h *ReportHandler) CreateExamReport(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	rep, err := h.service.RequestExamReport(r.Context(), examID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	h.queue(w, r, rep)
+}
+
+func (h *ReportHandler) queue(w http.ResponseWriter, r *http.Request, rep *domain.Report) {
+	h.workerPool.Submit(&jobs.ReportJob{
+		TenantID: rep.TenantID,
+		ReportID: rep.ID,
+		Actor:    requestActor(r),
+		Service:  h.service,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"report":       rep,
+		"status_url":   "/api/v1/reports/" + rep.ID.String(),
+		"download_url": "/api/v1/reports/" + rep.ID.String() + "/download",
+	})
+}
+
+func (h *ReportHandler) ListExamReports(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+
+	reps, err := h.service.ListExamReports(r.Context(), examID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reps)
+}
+
+func (h *ReportHandler) GetReport(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid report id", http.StatusBadRequest)
+		return
+	}
+
+	rep, err := h.service.GetReport(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
+// Download sends a completed report's file; one still being generated, or
+// that failed, is a 409.
+func (h *ReportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid report id", http.StatusBadRequest)
+		return
+	}
+
+	rep, data, filename, err := h.service.Download(r.Context(), id)
+	if errors.Is(err, service.ErrReportNotReady) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", rep.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
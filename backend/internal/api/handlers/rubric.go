@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"harama/internal/domain"
+	"harama/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type RubricHandler struct {
+	service *service.RubricService
+}
+
+func NewRubricHandler(s *service.RubricService) *RubricHandler {
+	return &RubricHandler{service: s}
+}
+
+type DraftRubricRequest struct {
+	ModelAnswer string `json:"model_answer"`
+}
+
+// DraftRubric has AI draft a rubric for a question, from the model answer
+// or marking scheme in the body if there is one. The draft is a pending
+// proposal; the question's rubric changes only once it is accepted.
+func (h *RubricHandler) DraftRubric(w http.ResponseWriter, r *http.Request) {
+	questionID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid question id", http.StatusBadRequest)
+		return
+	}
+
+	var req DraftRubricRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	p, err := h.service.DraftRubric(r.Context(), questionID, req.ModelAnswer)
+	if errors.Is(err, service.ErrUnusableDraft) {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+func (h *RubricHandler) ListProposals(w http.ResponseWriter, r *http.Request) {
+	questionID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid question id", http.StatusBadRequest)
+		return
+	}
+
+	ps, err := h.service.ListProposals(r.Context(), questionID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ps)
+}
+
+func (h *RubricHandler) GetProposal(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid proposal id", http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.service.GetProposal(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// UpdateProposal replaces a pending proposal's rubric with the teacher's
+// edit of it, a rubric document in the body. An edit with mistakes is a 422
+// listing every one of them.
+func (h *RubricHandler) UpdateProposal(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid proposal id", http.StatusBadRequest)
+		return
+	}
+
+	var doc domain.RubricDocument
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.service.UpdateProposal(r.Context(), id, &doc)
+	if err != nil {
+		writeProposalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// AcceptProposal makes a pending proposal the question's rubric and
+// returns that rubric.
+func (h *RubricHandler) AcceptProposal(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid proposal id", http.StatusBadRequest)
+		return
+	}
+
+	rubric, err := h.service.AcceptProposal(r.Context(), id)
+	if err != nil {
+		writeProposalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rubric)
+}
+
+func (h *RubricHandler) RejectProposal(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid proposal id", http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.service.RejectProposal(r.Context(), id)
+	if err != nil {
+		writeProposalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeProposalError reports a failed change to a proposal: one already
+// decided is a 409, an invalid rubric a 422.
+func writeProposalError(w http.ResponseWriter, err error) {
+	var rubricErr *service.RubricError
+	switch {
+	case errors.As(err, &rubricErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  rubricErr.Error(),
+			"errors": rubricErr.Errors,
+		})
+	case errors.Is(err, service.ErrProposalDecided):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), statusFor(err))
+	}
+}
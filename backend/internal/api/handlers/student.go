@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type StudentHandler struct {
+	profiles *service.StudentProfileService
+}
+
+func NewStudentHandler(profiles *service.StudentProfileService) *StudentHandler {
+	return &StudentHandler{profiles: profiles}
+}
+
+// GetProfile returns a student's learning profile across all exams: score
+// trends per subject and concept, recurring mistakes and missed criteria.
+func (h *StudentHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	studentID := chi.URLParam(r, "student_id")
+	if actor, err := auth.GetActor(r.Context()); err == nil && actor.Role == domain.RoleStudent && actor.StudentID != studentID {
+		http.Error(w, "students may only view their own profile", http.StatusForbidden)
+		return
+	}
+
+	profile, err := h.profiles.GetProfile(r.Context(), tenantID, studentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
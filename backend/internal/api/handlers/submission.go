@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"harama/internal/auth"
@@ -17,13 +19,15 @@ import (
 type SubmissionHandler struct {
 	ocrService     *service.OCRService
 	gradingService *service.GradingService
+	rosterService  *service.RosterService
 	workerPool     *worker.WorkerPool
 }
 
-func NewSubmissionHandler(ocr *service.OCRService, grading *service.GradingService, pool *worker.WorkerPool) *SubmissionHandler {
+func NewSubmissionHandler(ocr *service.OCRService, grading *service.GradingService, roster *service.RosterService, pool *worker.WorkerPool) *SubmissionHandler {
 	return &SubmissionHandler{
 		ocrService:     ocr,
 		gradingService: grading,
+		rosterService:  roster,
 		workerPool:     pool,
 	}
 }
@@ -52,14 +56,28 @@ func (h *SubmissionHandler) CreateSubmission(w http.ResponseWriter, r *http.Requ
 	sub.ExamID = examID
 	sub.TenantID = tenantID
 
-	if err := h.ocrService.CreateSubmission(r.Context(), &sub); err != nil {
+	if err := h.rosterService.ValidateSubmission(r.Context(), &sub); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, service.ErrNotOnRoster) {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if err := h.ocrService.CreateSubmission(r.Context(), &sub); errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "exam not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, "failed to create submission: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Trigger OCR processing asynchronously using worker pool
 	h.workerPool.Submit(&jobs.OCRJob{
+		TenantID:     sub.TenantID,
 		SubmissionID: sub.ID,
+		Actor:        requestActor(r),
 		Service:      h.ocrService,
 	})
 
@@ -75,14 +93,98 @@ func (h *SubmissionHandler) TriggerGrading(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	sub, err := h.ocrService.GetByID(r.Context(), subID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
 	// Submit grading job to worker pool
 	h.workerPool.Submit(&jobs.GradingJob{
-		SubmissionID: subID,
+		TenantID:     sub.TenantID,
+		SubmissionID: sub.ID,
+		Actor:        requestActor(r),
 		Service:      h.gradingService,
 	})
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "grading_started",
+		"events_url": "/api/v1/submissions/" + sub.ID.String() + "/events",
+	})
+}
+
+// GradeExam queues grading for submissions of an exam as one batch whose
+// progress streams from the returned events_url. Without submission_ids it
+// takes every submission whose OCR has finished.
+func (h *SubmissionHandler) GradeExam(w http.ResponseWriter, r *http.Request) {
+	examID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid exam id", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		SubmissionIDs []uuid.UUID `json:"submission_ids"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	subs, err := h.ocrService.ListByExam(r.Context(), examID)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	var batch []domain.Submission
+	if len(req.SubmissionIDs) > 0 {
+		byID := make(map[uuid.UUID]domain.Submission, len(subs))
+		for _, sub := range subs {
+			byID[sub.ID] = sub
+		}
+		for _, id := range req.SubmissionIDs {
+			sub, ok := byID[id]
+			if !ok {
+				http.Error(w, "submission "+id.String()+" not found for exam", http.StatusNotFound)
+				return
+			}
+			batch = append(batch, sub)
+		}
+	} else {
+		for _, sub := range subs {
+			if sub.ProcessingStatus != domain.StatusPending && sub.ProcessingStatus != domain.StatusProcessing {
+				batch = append(batch, sub)
+			}
+		}
+	}
+	if len(batch) == 0 {
+		http.Error(w, "no submissions ready to grade", http.StatusUnprocessableEntity)
+		return
+	}
+
+	batchID := uuid.New()
+	ids := make([]uuid.UUID, 0, len(batch))
+	for _, sub := range batch {
+		h.workerPool.Submit(&jobs.GradingJob{
+			TenantID:     sub.TenantID,
+			SubmissionID: sub.ID,
+			BatchID:      &batchID,
+			Actor:        requestActor(r),
+			Service:      h.gradingService,
+		})
+		ids = append(ids, sub.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(`{"status": "grading_started"}`))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batch_id":       batchID,
+		"submission_ids": ids,
+		"events_url":     "/api/v1/batches/" + batchID.String() + "/events",
+	})
 }
 
 func (h *SubmissionHandler) GetSubmission(w http.ResponseWriter, r *http.Request) {
@@ -95,10 +197,18 @@ func (h *SubmissionHandler) GetSubmission(w http.ResponseWriter, r *http.Request
 
 	sub, err := h.ocrService.GetByID(r.Context(), subID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusFor(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(sub)
 }
+
+// requestActor is the actor behind r, handed to the jobs it queues.
+func requestActor(r *http.Request) *auth.Actor {
+	if actor, err := auth.GetActor(r.Context()); err == nil {
+		return &actor
+	}
+	return nil
+}
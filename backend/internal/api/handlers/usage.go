@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/google/uuid"
+)
+
+type UsageHandler struct {
+	service *service.UsageService
+}
+
+func NewUsageHandler(s *service.UsageService) *UsageHandler {
+	return &UsageHandler{service: s}
+}
+
+// GetUsage reports AI tokens, latency and cost for the tenant, optionally
+// narrowed by exam_id, submission_id and a from/to range (RFC 3339).
+func (h *UsageHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	filter := postgres.UsageFilter{TenantID: tenantID}
+	q := r.URL.Query()
+
+	if v := q.Get("exam_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, "invalid exam_id", http.StatusBadRequest)
+			return
+		}
+		filter.ExamID = &id
+	}
+	if v := q.Get("submission_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, "invalid submission_id", http.StatusBadRequest)
+			return
+		}
+		filter.SubmissionID = &id
+	}
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+		filter.From = &t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid to", http.StatusBadRequest)
+			return
+		}
+		filter.To = &t
+	}
+
+	report, err := h.service.GetUsage(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *UsageHandler) GetBudget(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	status, err := h.service.GetBudgetStatus(r.Context(), tenantID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (h *UsageHandler) SetBudget(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var budget domain.TenantBudget
+	if err := json.NewDecoder(r.Body).Decode(&budget); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	budget.TenantID = tenantID
+
+	if err := h.service.SetBudget(r.Context(), &budget); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budget)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type WebhookHandler struct {
+	service *service.WebhookService
+}
+
+func NewWebhookHandler(s *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{service: s}
+}
+
+// CreateEndpoint registers a webhook endpoint. The response is the only
+// time its signing secret is returned.
+func (h *WebhookHandler) CreateEndpoint(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := auth.GetTenantID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var ep domain.WebhookEndpoint
+	if err := json.NewDecoder(r.Body).Decode(&ep); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ep.TenantID = tenantID
+
+	secret, err := h.service.CreateEndpoint(r.Context(), &ep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		domain.WebhookEndpoint
+		Secret string `json:"secret"`
+	}{ep, secret})
+}
+
+func (h *WebhookHandler) ListEndpoints(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.GetTenantID(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	eps, err := h.service.ListEndpoints(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eps)
+}
+
+func (h *WebhookHandler) DeleteEndpoint(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.GetTenantID(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeleteEndpoint(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries returns the latest deliveries, optionally only those to
+// ?endpoint_id=.
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.GetTenantID(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var endpointID *uuid.UUID
+	if v := r.URL.Query().Get("endpoint_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, "invalid endpoint_id", http.StatusBadRequest)
+			return
+		}
+		endpointID = &id
+	}
+
+	deliveries, err := h.service.ListDeliveries(r.Context(), endpointID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// Redeliver queues a delivery's event to be sent again; the worker sends it
+// on its next round.
+func (h *WebhookHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	if _, err := auth.GetTenantID(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid delivery id", http.StatusBadRequest)
+		return
+	}
+
+	d, err := h.service.Redeliver(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(d)
+}
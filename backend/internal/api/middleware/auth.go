@@ -1,93 +1,94 @@
 package middleware
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
 	"harama/internal/auth"
+	"harama/internal/domain"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
 )
 
-// SupabaseAuthMiddleware validates Supabase JWT tokens by calling Supabase Auth API.
-// This supports both HS256 and ES256 tokens and ensures the token is not revoked.
-func SupabaseAuthMiddleware(supabaseURL, anonKey, jwtSecret string) func(http.Handler) http.Handler {
+// UserDirectory resolves an authenticated user to their tenant membership.
+type UserDirectory interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error)
+}
+
+// APIKeyAuthenticator resolves a service API key to its record.
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*domain.APIKey, error)
+}
+
+// AuthMiddleware authenticates users by bearer token, verified locally, and
+// machine clients by API key, sent in X-API-Key or as the bearer token. When
+// a token itself names a tenant and a role (see auth.ClaimMapping) they are
+// used as is; otherwise the user must be a member of a tenant in the user
+// directory. An API key acts for its tenant within its scopes.
+func AuthMiddleware(verifier *auth.Verifier, users UserDirectory, keys APIKeyAuthenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Try Authorization: Bearer <token> first
 			authHeader := r.Header.Get("Authorization")
-			if strings.HasPrefix(authHeader, "Bearer ") {
-				token := strings.TrimPrefix(authHeader, "Bearer ")
-				
-				// Validate with Supabase API
-				user, err := validateTokenWithSupabase(token, supabaseURL, anonKey)
-				if err != nil {
-					http.Error(w, "invalid or expired token: "+err.Error(), http.StatusUnauthorized)
-					return
+			token := strings.TrimPrefix(authHeader, "Bearer ")
+			if apiKey := r.Header.Get("X-API-Key"); apiKey != "" || auth.IsAPIKey(token) {
+				if apiKey == "" {
+					apiKey = token
 				}
+				authenticateKey(w, r, next, keys, apiKey)
+				return
+			}
+			if !strings.HasPrefix(authHeader, "Bearer ") {
+				http.Error(w, "authorization required: provide a Bearer token or API key", http.StatusUnauthorized)
+				return
+			}
 
-				userID, err := uuid.Parse(user.ID)
-				if err != nil {
-					http.Error(w, "invalid user id in token", http.StatusUnauthorized)
-					return
+			id, err := verifier.Verify(r.Context(), token)
+			if err != nil {
+				msg := "invalid token"
+				if errors.Is(err, auth.ErrTokenExpired) {
+					msg = "token expired"
 				}
-
-				// Use user_id as tenant_id (single-tenant per user model)
-				ctx := auth.WithTenantID(r.Context(), userID)
-				next.ServeHTTP(w, r.WithContext(ctx))
+				http.Error(w, msg, http.StatusUnauthorized)
 				return
 			}
 
-			// Fallback: X-Tenant-ID header (for dev/testing)
-			tenantIDStr := r.Header.Get("X-Tenant-ID")
-			if tenantIDStr != "" {
-				tenantID, err := uuid.Parse(tenantIDStr)
+			actor := auth.Actor{UserID: id.UserID, TenantID: id.TenantID, Role: id.Role, StudentID: id.StudentID}
+			if actor.TenantID == uuid.Nil || actor.Role == "" {
+				user, err := users.GetByID(r.Context(), id.UserID)
 				if err != nil {
-					http.Error(w, "invalid X-Tenant-ID", http.StatusUnauthorized)
+					http.Error(w, "user is not a member of any tenant", http.StatusForbidden)
 					return
 				}
-				ctx := auth.WithTenantID(r.Context(), tenantID)
-				next.ServeHTTP(w, r.WithContext(ctx))
-				return
+				actor = auth.Actor{UserID: user.ID, TenantID: user.TenantID, Role: user.Role, StudentID: user.StudentID}
 			}
 
-			http.Error(w, "authorization required: provide Bearer token or X-Tenant-ID", http.StatusUnauthorized)
+			ctx := auth.WithActor(r.Context(), actor)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-type SupabaseUser struct {
-	ID    string `json:"id"`
-	Email string `json:"email"`
-	Role  string `json:"role"`
-}
-
-func validateTokenWithSupabase(token, supabaseURL, anonKey string) (*SupabaseUser, error) {
-	client := &http.Client{Timeout: 5 * time.Second}
-	req, err := http.NewRequest("GET", supabaseURL+"/auth/v1/user", nil)
-	if err != nil {
-		return nil, err
+func authenticateKey(w http.ResponseWriter, r *http.Request, next http.Handler, keys APIKeyAuthenticator, secret string) {
+	if keys == nil {
+		http.Error(w, auth.ErrAPIKeyInvalid.Error(), http.StatusUnauthorized)
+		return
 	}
-
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("apikey", anonKey)
-
-	resp, err := client.Do(req)
+	key, err := keys.Authenticate(r.Context(), secret)
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("supabase rejected token: %s", resp.Status)
+		msg := auth.ErrAPIKeyInvalid.Error()
+		if errors.Is(err, auth.ErrAPIKeyExpired) || errors.Is(err, auth.ErrAPIKeyRevoked) {
+			msg = err.Error()
+		}
+		http.Error(w, msg, http.StatusUnauthorized)
+		return
 	}
 
-	var user SupabaseUser
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return nil, err
-	}
-
-	return &user, nil
-}
\ No newline at end of file
+	ctx := auth.WithActor(r.Context(), auth.Actor{
+		UserID:      key.ID,
+		TenantID:    key.TenantID,
+		Role:        domain.RoleService,
+		Permissions: auth.ScopePermissions(key.Scopes),
+	})
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+
+	"harama/internal/auth"
+)
+
+// RequirePermission rejects requests whose actor's role, or API key scopes,
+// do not grant perm.
+func RequirePermission(perm auth.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor, err := auth.GetActor(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if !actor.Can(perm) {
+				http.Error(w, "forbidden: requires "+string(perm), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
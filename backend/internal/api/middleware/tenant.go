@@ -4,10 +4,15 @@ import (
 	"net/http"
 
 	"harama/internal/auth"
+	"harama/internal/domain"
 
 	"github.com/google/uuid"
 )
 
+// TenantMiddleware trusts the X-Tenant-ID header and is only used in
+// development, when no identity provider is configured. The actor is an
+// admin unless X-User-Role says otherwise; X-User-ID and X-Student-ID
+// identify them.
 func TenantMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tenantIDStr := r.Header.Get("X-Tenant-ID")
@@ -22,7 +27,22 @@ func TenantMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		ctx := auth.WithTenantID(r.Context(), tenantID)
+		actor := auth.Actor{TenantID: tenantID, Role: domain.RoleAdmin, StudentID: r.Header.Get("X-Student-ID")}
+		if v := r.Header.Get("X-User-ID"); v != "" {
+			if actor.UserID, err = uuid.Parse(v); err != nil {
+				http.Error(w, "invalid X-User-ID", http.StatusUnauthorized)
+				return
+			}
+		}
+		if v := r.Header.Get("X-User-Role"); v != "" {
+			actor.Role = domain.Role(v)
+			if !actor.Role.Valid() {
+				http.Error(w, "invalid X-User-Role", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		ctx := auth.WithActor(r.Context(), actor)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
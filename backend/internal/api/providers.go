@@ -0,0 +1,143 @@
+package api
+
+import (
+	"fmt"
+	"log"
+
+	"harama/internal/ai"
+	"harama/internal/ai/cassette"
+	"harama/internal/ai/gemini"
+	"harama/internal/ai/openai"
+	"harama/internal/config"
+	"harama/internal/ocr"
+)
+
+// newAIProvider builds every configured backend and wraps them in a router.
+// Without AI_ROUTES the default route tries the backends in the order
+// gemini, openai, local.
+func newAIProvider(cfg *config.Config, models *ai.ModelRegistry) (ai.Provider, error) {
+	backends := map[string]ai.Provider{}
+	var order []string
+
+	if cfg.GeminiAPIKey != "" {
+		client, err := gemini.NewClient(cfg.GeminiAPIKey, models)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize gemini client: %w", err)
+		}
+		backends["gemini"] = client
+		order = append(order, "gemini")
+	}
+
+	if cfg.OpenAIAPIKey != "" {
+		client, err := openai.NewClient(openai.Config{
+			BaseURL:  cfg.OpenAIBaseURL,
+			APIKey:   cfg.OpenAIAPIKey,
+			Model:    cfg.OpenAIModel,
+			JSONMode: true,
+			Models:   models,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize openai client: %w", err)
+		}
+		backends["openai"] = client
+		order = append(order, "openai")
+	}
+
+	if cfg.LocalLLMBaseURL != "" {
+		client, err := openai.NewClient(openai.Config{
+			Name:    "local",
+			BaseURL: cfg.LocalLLMBaseURL,
+			Model:   cfg.LocalLLMModel,
+			Models:  models,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize local model client: %w", err)
+		}
+		backends["local"] = client
+		order = append(order, "local")
+	}
+
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no AI backend configured: set GEMINI_API_KEY, OPENAI_API_KEY or LOCAL_LLM_BASE_URL")
+	}
+
+	routes, err := ai.ParseRoutes(cfg.AIRoutes)
+	if err != nil {
+		return nil, err
+	}
+	if len(routes.Default) == 0 {
+		routes.Default = order
+	}
+
+	return ai.NewRouter(backends, routes)
+}
+
+// newAIClients builds the grading provider and OCR processor, each behind
+// its own circuit breaker with shared metering and rate limits. With
+// AI_CASSETTE_MODE=record every call is also saved to AI_CASSETTE_DIR; with
+// replay, saved calls are served and no backend is contacted. OCR always
+// runs on Gemini, so GEMINI_API_KEY is required whichever backends grade.
+func newAIClients(cfg *config.Config, models *ai.ModelRegistry, meter *ai.Meter) (ai.Provider, ai.OCRProcessor, error) {
+	var store cassette.Store
+	if cfg.AICassetteMode != "" {
+		dirStore, err := cassette.NewDirStore(cfg.AICassetteDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		store = dirStore
+	}
+
+	switch cfg.AICassetteMode {
+	case "", "record":
+	case "replay":
+		log.Printf("AI calls are replayed from %s", cfg.AICassetteDir)
+		replayer, err := cassette.NewReplayer(store, models)
+		if err != nil {
+			return nil, nil, err
+		}
+		return replayer, replayer, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown AI_CASSETTE_MODE %q: want record or replay", cfg.AICassetteMode)
+	}
+
+	if cfg.GeminiAPIKey == "" {
+		return nil, nil, fmt.Errorf("GEMINI_API_KEY is required: scans are read by Gemini even when OPENAI_API_KEY or LOCAL_LLM_BASE_URL grades")
+	}
+	aiRouter, err := newAIProvider(cfg, models)
+	if err != nil {
+		return nil, nil, err
+	}
+	geminiVision, err := ocr.NewGeminiOCRProcessor(cfg.GeminiAPIKey, models)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize gemini vision processor: %w", err)
+	}
+
+	limiter := ai.NewLimiter(cfg.AIRateLimitRPM, cfg.AIRateBurst)
+	retryPolicy := ai.DefaultRetryPolicy
+	retryPolicy.MaxAttempts = cfg.AIMaxAttempts
+	resilience := func(breaker *ai.CircuitBreaker) []ai.Interceptor {
+		return []ai.Interceptor{
+			meter.Intercept,
+			breaker.Intercept,
+			ai.Retry(retryPolicy),
+			ai.RateLimit(limiter),
+			ai.Timeout(cfg.AICallTimeout),
+		}
+	}
+	aiClient := ai.Chain(aiRouter, resilience(ai.NewCircuitBreaker(cfg.AIBreakerFailures, cfg.AIBreakerCooldown))...)
+	visionProcessor := ai.ChainOCR(geminiVision, resilience(ai.NewCircuitBreaker(cfg.AIBreakerFailures, cfg.AIBreakerCooldown))...)
+
+	if cfg.AICassetteMode == "record" {
+		log.Printf("AI calls are recorded to %s", cfg.AICassetteDir)
+		recorder, err := cassette.Record(aiClient, store, models)
+		if err != nil {
+			return nil, nil, err
+		}
+		ocrRecorder, err := cassette.RecordOCR(visionProcessor, store, models)
+		if err != nil {
+			return nil, nil, err
+		}
+		return recorder, ocrRecorder, nil
+	}
+	return aiClient, visionProcessor, nil
+}
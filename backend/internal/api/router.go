@@ -1,16 +1,20 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
-	"harama/internal/ai/gemini"
+	"harama/internal/ai"
 	"harama/internal/api/handlers"
 	"harama/internal/api/middleware"
+	"harama/internal/auth"
 	"harama/internal/config"
 	"harama/internal/grading"
-	"harama/internal/ocr"
+	"harama/internal/lti"
+	"harama/internal/progress"
 	"harama/internal/repository/postgres"
+	"harama/internal/segmentation"
 	"harama/internal/service"
 	"harama/internal/storage"
 	"harama/internal/worker"
@@ -28,11 +32,29 @@ func NewRouter(cfg *config.Config, db *bun.DB) (*chi.Mux, error) {
 	gradeRepo := postgres.NewGradeRepo(db)
 	feedbackRepo := postgres.NewFeedbackRepo(db)
 	auditRepo := postgres.NewAuditRepo(db)
+	usageRepo := postgres.NewUsageRepo(db)
+	rosterRepo := postgres.NewRosterRepo(db)
+	userRepo := postgres.NewUserRepo(db)
+	apiKeyRepo := postgres.NewAPIKeyRepo(db)
+	webhookRepo := postgres.NewWebhookRepo(db)
+	ltiRepo := postgres.NewLTIRepo(db)
+	reportRepo := postgres.NewReportRepo(db)
+	rubricProposalRepo := postgres.NewRubricProposalRepo(db)
+	questionPaperRepo := postgres.NewQuestionPaperRepo(db)
+	bankRepo := postgres.NewBankRepo(db)
+	curveRepo := postgres.NewCurveRepo(db)
 
 	// 2. Initialize AI Provider & Infrastructure
-	aiClient, err := gemini.NewClient(cfg.GeminiAPIKey)
+	usageService := service.NewUsageService(usageRepo)
+	meter := ai.NewMeter(usageService, usageService, ai.DefaultPricing)
+
+	models, err := ai.NewModelRegistry(cfg.Models)
+	if err != nil {
+		return nil, err
+	}
+	aiClient, visionProcessor, err := newAIClients(cfg, models, meter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize gemini client: %w", err)
+		return nil, err
 	}
 
 	minioStorage, err := storage.NewMinioStorage(
@@ -46,31 +68,76 @@ func NewRouter(cfg *config.Config, db *bun.DB) (*chi.Mux, error) {
 		return nil, fmt.Errorf("failed to initialize minio storage: %w", err)
 	}
 
-	visionProcessor, err := ocr.NewGeminiOCRProcessor(cfg.GeminiAPIKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize gemini vision processor: %w", err)
-	}
-
 	// 3. Initialize Engine & Worker Pool
 	gradingEngine := grading.NewEngine(aiClient)
 	workerPool := worker.NewWorkerPool(5, 100)
 	workerPool.Start()
 
+	// Progress events reach this process's streams from whichever process
+	// does the work
+	progressBus := progress.NewBus(postgres.NewProgressRelay(db))
+	go progressBus.Run(context.Background())
+
 	// 4. Initialize Services
-	examService := service.NewExamService(examRepo, auditRepo)
-	ocrService := service.NewOCRService(subRepo, auditRepo, minioStorage, visionProcessor)
-	gradingService := service.NewGradingService(gradeRepo, examRepo, subRepo, auditRepo, gradingEngine)
-	feedbackService := service.NewFeedbackService(feedbackRepo, gradeRepo, examRepo, auditRepo, aiClient)
-	analyticsService := service.NewAnalyticsService(gradeRepo, examRepo, subRepo)
-	auditService := service.NewAuditService(auditRepo)
+	examService := service.NewExamService(examRepo, auditRepo, webhookRepo)
+	rubricService := service.NewRubricService(rubricProposalRepo, examRepo, auditRepo, webhookRepo, aiClient)
+	ocrService := service.NewOCRService(subRepo, examRepo, auditRepo, webhookRepo, progressBus, minioStorage, visionProcessor)
+	gradingService := service.NewGradingService(gradeRepo, examRepo, subRepo, auditRepo, webhookRepo, ltiRepo, progressBus, gradingEngine)
+	profileService := service.NewStudentProfileService(gradeRepo, subRepo, curveRepo)
+	feedbackService := service.NewFeedbackService(feedbackRepo, gradeRepo, examRepo, auditRepo, webhookRepo, ltiRepo, aiClient, profileService)
+	analyticsService := service.NewAnalyticsService(gradeRepo, examRepo, subRepo, rosterRepo, feedbackRepo, curveRepo)
+	auditKey, err := service.ParseSigningKey(cfg.AuditSigningKey)
+	if err != nil {
+		return nil, err
+	}
+	auditService := service.NewAuditService(auditRepo, auditKey)
+	rosterService := service.NewRosterService(rosterRepo, userRepo, auditRepo)
+	userService := service.NewUserService(userRepo, auditRepo)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, auditRepo)
+	webhookService := service.NewWebhookService(webhookRepo, auditRepo, cfg.WebhookTimeout, cfg.WebhookAllowPrivate)
+	questionPaperService := service.NewQuestionPaperService(questionPaperRepo, examRepo, auditRepo, minioStorage, visionProcessor, aiClient, segmentation.NewDiagramDetector())
+	bankService := service.NewBankService(bankRepo, examRepo, auditRepo, webhookRepo)
+	curveService := service.NewCurveService(gradeRepo, examRepo, curveRepo, auditRepo, ltiRepo, rosterService)
+	reportService := service.NewReportService(reportRepo, examRepo, subRepo, gradeRepo, auditRepo, curveRepo, minioStorage, segmentation.NewDiagramDetector())
 
 	// 5. Initialize Handlers
 	examHandler := handlers.NewExamHandler(examService)
-	submissionHandler := handlers.NewSubmissionHandler(ocrService, gradingService, workerPool)
+	rubricHandler := handlers.NewRubricHandler(rubricService)
+	submissionHandler := handlers.NewSubmissionHandler(ocrService, gradingService, rosterService, workerPool)
 	gradingHandler := handlers.NewGradingHandler(gradingService)
-	feedbackHandler := handlers.NewFeedbackHandler(feedbackService)
+	feedbackHandler := handlers.NewFeedbackHandler(feedbackService, rosterService)
 	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService)
+	studentHandler := handlers.NewStudentHandler(profileService)
 	auditHandler := handlers.NewAuditHandler(auditService)
+	usageHandler := handlers.NewUsageHandler(usageService)
+	rosterHandler := handlers.NewRosterHandler(rosterService)
+	userHandler := handlers.NewUserHandler(userService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	progressHandler := handlers.NewProgressHandler(progressBus, ocrService, examService)
+	reportHandler := handlers.NewReportHandler(reportService, workerPool)
+	questionPaperHandler := handlers.NewQuestionPaperHandler(questionPaperService, workerPool)
+	bankHandler := handlers.NewBankHandler(bankService)
+	curveHandler := handlers.NewCurveHandler(curveService)
+
+	// LTI is served only when the tool has a URL and a key
+	var ltiHandler *handlers.LTIHandler
+	if cfg.LTIEnabled() {
+		key, err := lti.ReadPrivateKey(cfg.LTIToolKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tool := lti.NewTool(cfg.LTIToolURL, key, cfg.LTITimeout)
+		ltiService := service.NewLTIService(ltiRepo, examRepo, auditRepo, tool)
+		ltiHandler = handlers.NewLTIHandler(ltiService, tool, cfg.LTILaunchRedirect)
+	}
+
+	var verifier *auth.Verifier
+	if cfg.AuthEnabled() {
+		if verifier, err = newVerifier(cfg); err != nil {
+			return nil, err
+		}
+	}
 
 	// 6. Global Middleware
 	r.Use(middleware.CORSMiddleware(cfg.CORSOrigin))
@@ -81,39 +148,165 @@ func NewRouter(cfg *config.Config, db *bun.DB) (*chi.Mux, error) {
 		w.Write([]byte("OK"))
 	})
 
+	// LTI launches come from the LMS, authenticated by the platform's
+	// signed id_token rather than our tokens
+	if ltiHandler != nil {
+		r.Get("/lti/jwks.json", ltiHandler.KeySet)
+		r.Get("/lti/login", ltiHandler.Login)
+		r.Post("/lti/login", ltiHandler.Login)
+		r.Post("/lti/launch", ltiHandler.Launch)
+	}
+
 	// 8. Protected API Routes
 	r.Route("/api/v1", func(r chi.Router) {
-		// Use Supabase JWT auth with X-Tenant-ID fallback for dev
-		if cfg.SupabaseJWTSecret != "" {
-			r.Use(middleware.SupabaseAuthMiddleware(cfg.SupabaseURL, cfg.SupabaseAnonKey, cfg.SupabaseJWTSecret))
+		// Verified tokens map the user to their tenant and role, API keys
+		// to theirs and their scopes; without auth configured (development)
+		// X-Tenant-ID is trusted
+		if verifier != nil {
+			r.Use(middleware.AuthMiddleware(verifier, userRepo, apiKeyService))
 		} else {
 			r.Use(middleware.TenantMiddleware)
 		}
+		can := middleware.RequirePermission
+
 		// Exam Routes
-		r.Post("/exams", examHandler.CreateExam)
-		r.Get("/exams", examHandler.ListExams)
-		r.Get("/exams/{id}", examHandler.GetExam)
-		r.Post("/exams/{id}/questions", examHandler.AddQuestion)
-		r.Post("/exams/{id}/export", analyticsHandler.ExportGrades)
-		r.Put("/questions/{id}/rubric", examHandler.SetRubric)
+		r.With(can(auth.PermExamWrite)).Post("/exams", examHandler.CreateExam)
+		r.With(can(auth.PermExamRead)).Get("/exams", examHandler.ListExams)
+		r.With(can(auth.PermExamWrite)).Post("/exams/import", examHandler.ImportDefinition)
+		r.With(can(auth.PermExamRead)).Get("/exams/{id}", examHandler.GetExam)
+		r.With(can(auth.PermExamRead)).Get("/exams/{id}/definition", examHandler.ExportDefinition)
+		r.With(can(auth.PermExamWrite)).Post("/exams/{id}/questions", examHandler.AddQuestion)
+		r.With(can(auth.PermExamWrite)).Post("/exams/{id}/clone", examHandler.CloneExam)
+		r.With(can(auth.PermExamWrite)).Post("/exams/{id}/bank-items", bankHandler.AddToExam)
+		r.With(can(auth.PermExportRead)).Post("/exams/{id}/export", analyticsHandler.ExportGrades)
+		r.With(can(auth.PermAnalyticsRead)).Get("/exams/{id}/item-analysis", analyticsHandler.ItemAnalysis)
+		r.With(can(auth.PermAnalyticsRead)).Get("/exams/{id}/distribution", curveHandler.Distribution)
+		r.With(can(auth.PermAnalyticsRead)).Post("/exams/{id}/curves/preview", curveHandler.PreviewCurve)
+		r.With(can(auth.PermGradeOverride)).Post("/exams/{id}/curves", curveHandler.ApplyCurve)
+		r.With(can(auth.PermAnalyticsRead)).Get("/exams/{id}/curves", curveHandler.ListCurves)
+		r.With(can(auth.PermAnalyticsRead)).Get("/exams/{id}/curves/{version}", curveHandler.GetCurve)
+		r.With(can(auth.PermGradeOverride)).Delete("/exams/{id}/curves/active", curveHandler.RemoveCurve)
+		r.With(can(auth.PermExamWrite)).Post("/exams/{id}/question-papers", questionPaperHandler.Upload)
+		r.With(can(auth.PermExamRead)).Get("/exams/{id}/question-papers", questionPaperHandler.List)
+		r.With(can(auth.PermExamRead)).Get("/question-papers/{id}", questionPaperHandler.Get)
+		r.With(can(auth.PermExamWrite)).Put("/question-papers/{id}/draft", questionPaperHandler.UpdateDraft)
+		r.With(can(auth.PermExamWrite)).Post("/question-papers/{id}/confirm", questionPaperHandler.Confirm)
+		r.With(can(auth.PermExamWrite)).Put("/questions/{id}/rubric", examHandler.SetRubric)
+		r.With(can(auth.PermExamWrite)).Post("/questions/{id}/bank", bankHandler.SaveQuestion)
+		r.With(can(auth.PermExamWrite)).Post("/questions/{id}/rubric/draft", rubricHandler.DraftRubric)
+		r.With(can(auth.PermExamRead)).Get("/questions/{id}/rubric/proposals", rubricHandler.ListProposals)
+		r.With(can(auth.PermExamRead)).Get("/rubric-proposals/{id}", rubricHandler.GetProposal)
+		r.With(can(auth.PermExamWrite)).Put("/rubric-proposals/{id}", rubricHandler.UpdateProposal)
+		r.With(can(auth.PermExamWrite)).Post("/rubric-proposals/{id}/accept", rubricHandler.AcceptProposal)
+		r.With(can(auth.PermExamWrite)).Post("/rubric-proposals/{id}/reject", rubricHandler.RejectProposal)
+		r.With(can(auth.PermRosterWrite)).Post("/exams/{id}/classes", rosterHandler.AssignExam)
+		r.With(can(auth.PermRosterRead)).Get("/exams/{id}/classes", rosterHandler.ListExamClasses)
+		r.With(can(auth.PermRosterRead)).Get("/exams/{id}/missing-submissions", rosterHandler.MissingSubmissions)
+
+		// Question Bank Routes
+		r.With(can(auth.PermExamWrite)).Post("/bank/items", bankHandler.CreateItem)
+		r.With(can(auth.PermExamRead)).Get("/bank/items", bankHandler.SearchItems)
+		r.With(can(auth.PermExamRead)).Get("/bank/items/{id}", bankHandler.GetItem)
+		r.With(can(auth.PermExamWrite)).Put("/bank/items/{id}", bankHandler.UpdateItem)
+		r.With(can(auth.PermExamWrite)).Delete("/bank/items/{id}", bankHandler.DeleteItem)
+
+		// Roster Routes
+		r.With(can(auth.PermRosterWrite)).Post("/classes", rosterHandler.CreateClass)
+		r.With(can(auth.PermRosterRead)).Get("/classes", rosterHandler.ListClasses)
+		r.With(can(auth.PermRosterRead)).Get("/classes/{id}", rosterHandler.GetClass)
+		r.With(can(auth.PermRosterWrite)).Post("/classes/{id}/roster", rosterHandler.ImportRoster)
+		r.With(can(auth.PermUserManage)).Post("/classes/{id}/teachers", rosterHandler.AssignTeachers)
+		r.With(can(auth.PermRosterWrite)).Post("/students", rosterHandler.CreateStudent)
+		r.With(can(auth.PermRosterRead)).Get("/students", rosterHandler.ListStudents)
 
 		// Submission Routes
-		r.Post("/exams/{id}/submissions", submissionHandler.CreateSubmission)
-		r.Get("/submissions/{id}", submissionHandler.GetSubmission)
-		r.Post("/submissions/{id}/trigger-grading", submissionHandler.TriggerGrading)
+		r.With(can(auth.PermSubmissionWrite)).Post("/exams/{id}/submissions", submissionHandler.CreateSubmission)
+		r.With(can(auth.PermSubmissionRead)).Get("/submissions/{id}", submissionHandler.GetSubmission)
+		r.With(can(auth.PermSubmissionWrite)).Post("/submissions/{id}/trigger-grading", submissionHandler.TriggerGrading)
+		r.With(can(auth.PermSubmissionWrite)).Post("/exams/{id}/grade", submissionHandler.GradeExam)
+
+		// Progress Streams (Server-Sent Events)
+		r.With(can(auth.PermSubmissionRead)).Get("/submissions/{id}/events", progressHandler.SubmissionEvents)
+		r.With(can(auth.PermSubmissionRead)).Get("/exams/{id}/events", progressHandler.ExamEvents)
+		r.With(can(auth.PermSubmissionRead)).Get("/batches/{id}/events", progressHandler.BatchEvents)
 
 		// Grading & Feedback Routes
-		r.Get("/submissions/{id}/grades", gradingHandler.GetGrades)
-		r.Post("/submissions/{submission_id}/questions/{question_id}/override", feedbackHandler.CaptureOverride)
-		r.Get("/submissions/{submission_id}/questions/{question_id}/feedback", feedbackHandler.GetStudentFeedback)
-		r.Get("/questions/{question_id}/analysis", feedbackHandler.AnalyzePatterns)
-		r.Post("/questions/{question_id}/adapt-rubric", feedbackHandler.AdaptRubric)
+		r.With(can(auth.PermGradeRead)).Get("/submissions/{id}/grades", gradingHandler.GetGrades)
+		r.With(can(auth.PermAuditRead)).Get("/grades/{id}/lineage", gradingHandler.GetLineage)
+		r.With(can(auth.PermGradeOverride)).Post("/submissions/{submission_id}/questions/{question_id}/override", feedbackHandler.CaptureOverride)
+		r.With(can(auth.PermFeedbackRead)).Get("/submissions/{submission_id}/questions/{question_id}/feedback", feedbackHandler.GetStudentFeedback)
+		r.With(can(auth.PermAnalyticsRead)).Get("/questions/{question_id}/analysis", feedbackHandler.AnalyzePatterns)
+		r.With(can(auth.PermProfileRead)).Get("/students/{student_id}/profile", studentHandler.GetProfile)
+		r.With(can(auth.PermRubricAdapt)).Post("/questions/{question_id}/adapt-rubric", feedbackHandler.AdaptRubric)
+		r.With(can(auth.PermGradeRead)).Get("/escalations", gradingHandler.ListEscalations)
+		r.With(can(auth.PermEscalationResolve)).Post("/escalations/{id}/resolve", gradingHandler.ResolveEscalation)
+
+		// Report Routes (students fetch the reports of their own submissions)
+		r.With(can(auth.PermExportRead)).Post("/submissions/{id}/report", reportHandler.CreateSubmissionReport)
+		r.With(can(auth.PermExportRead)).Post("/exams/{id}/reports", reportHandler.CreateExamReport)
+		r.With(can(auth.PermExportRead)).Get("/exams/{id}/reports", reportHandler.ListExamReports)
+		r.With(can(auth.PermFeedbackRead)).Get("/reports/{id}", reportHandler.GetReport)
+		r.With(can(auth.PermFeedbackRead)).Get("/reports/{id}/download", reportHandler.Download)
 
 		// Analytics & Audit Routes
-		r.Get("/analytics/grading-trends", analyticsHandler.GetGradingTrends)
-		r.Get("/audit/{id}", auditHandler.GetLogs)
+		r.With(can(auth.PermAnalyticsRead)).Get("/analytics/grading-trends", analyticsHandler.GetGradingTrends)
+		r.With(can(auth.PermAnalyticsRead)).Get("/analytics/usage", usageHandler.GetUsage)
+		r.With(can(auth.PermBudgetManage)).Get("/budget", usageHandler.GetBudget)
+		r.With(can(auth.PermBudgetManage)).Put("/budget", usageHandler.SetBudget)
+		r.With(can(auth.PermAuditRead)).Get("/audit", auditHandler.Search)
+		r.With(can(auth.PermAuditRead)).Get("/audit/export", auditHandler.Export)
+		r.With(can(auth.PermAuditRead)).Get("/audit/{id}", auditHandler.GetLogs)
+		r.With(can(auth.PermAuditVerify)).Get("/audit/verify", auditHandler.VerifyChain)
+		r.With(can(auth.PermAuditVerify)).Post("/audit/checkpoints", auditHandler.CreateCheckpoint)
+		r.With(can(auth.PermAuditVerify)).Get("/audit/checkpoints/export", auditHandler.ExportCheckpoints)
+
+		// User Routes
+		r.Get("/me", userHandler.Me)
+		r.With(can(auth.PermUserManage)).Get("/users", userHandler.ListUsers)
+		r.With(can(auth.PermUserManage)).Post("/users", userHandler.AddUser)
+		r.With(can(auth.PermUserManage)).Put("/users/{id}", userHandler.UpdateUser)
+
+		// API Key Routes
+		r.With(can(auth.PermAPIKeyManage)).Post("/api-keys", apiKeyHandler.CreateKey)
+		r.With(can(auth.PermAPIKeyManage)).Get("/api-keys", apiKeyHandler.ListKeys)
+		r.With(can(auth.PermAPIKeyManage)).Delete("/api-keys/{id}", apiKeyHandler.RevokeKey)
+
+		// Webhook Routes
+		r.With(can(auth.PermWebhookManage)).Post("/webhooks", webhookHandler.CreateEndpoint)
+		r.With(can(auth.PermWebhookManage)).Get("/webhooks", webhookHandler.ListEndpoints)
+		r.With(can(auth.PermWebhookManage)).Delete("/webhooks/{id}", webhookHandler.DeleteEndpoint)
+		r.With(can(auth.PermWebhookManage)).Get("/webhooks/deliveries", webhookHandler.ListDeliveries)
+		r.With(can(auth.PermWebhookManage)).Post("/webhooks/deliveries/{id}/redeliver", webhookHandler.Redeliver)
+
+		// LTI Routes
+		if ltiHandler != nil {
+			r.With(can(auth.PermLTIManage)).Post("/lti/platforms", ltiHandler.RegisterPlatform)
+			r.With(can(auth.PermLTIManage)).Get("/lti/platforms", ltiHandler.ListPlatforms)
+			r.With(can(auth.PermLTIManage)).Delete("/lti/platforms/{id}", ltiHandler.DeletePlatform)
+			r.With(can(auth.PermLTIManage)).Put("/lti/platforms/{id}/users", ltiHandler.LinkUser)
+			r.With(can(auth.PermLTIManage)).Get("/lti/score-pushes", ltiHandler.ListScorePushes)
+			r.With(can(auth.PermLTIManage)).Get("/exams/{id}/lti", ltiHandler.ListLineItems)
+		}
 	})
 
 	return r, nil
 
 }
+
+func newVerifier(cfg *config.Config) (*auth.Verifier, error) {
+	vc := auth.VerifierConfig{
+		Secret:   []byte(cfg.AuthJWTSecret),
+		Issuer:   cfg.AuthIssuer,
+		Audience: cfg.AuthAudience,
+		Claims: auth.ClaimMapping{
+			User:      cfg.AuthUserClaim,
+			Tenant:    cfg.AuthTenantClaim,
+			Role:      cfg.AuthRoleClaim,
+			StudentID: cfg.AuthStudentClaim,
+		},
+	}
+	if cfg.AuthJWKSURL != "" {
+		vc.JWKS = auth.NewJWKS(cfg.AuthJWKSURL, cfg.AuthJWKSTTL)
+	}
+	return auth.NewVerifier(vc)
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrUnknownKey is returned when no key in the set matches a token's kid,
+// even after refreshing.
+var ErrUnknownKey = errors.New("auth: signing key not found")
+
+// A refetch, for an unknown kid or after a failed fetch, is allowed at most
+// this often, so tokens with made-up key IDs or an unreachable issuer can't
+// make us hammer it.
+const minJWKSRefresh = 30 * time.Second
+
+// JWKS is a cached JSON Web Key Set. Keys are refetched when the cache
+// expires or a token names a key we have not seen, which picks up rotation.
+// Lookups share a single fetch and never wait on the network for keys
+// already cached.
+type JWKS struct {
+	url     string
+	client  *http.Client
+	ttl     time.Duration
+	now     func() time.Time
+	fetches singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// attemptedAt is when keys were last fetched, or failed to be, and
+	// fetchErr why the last fetch failed
+	attemptedAt time.Time
+	fetchErr    error
+}
+
+func NewJWKS(url string, ttl time.Duration) *JWKS {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	return &JWKS{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Key returns the public key with the given ID.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	now := j.now()
+	key, ok := j.keys[kid]
+	stale := j.keys == nil || now.Sub(j.fetchedAt) > j.ttl
+	throttled := !j.attemptedAt.IsZero() && now.Sub(j.attemptedAt) < minJWKSRefresh
+	fetchErr := j.fetchErr
+	j.mu.Unlock()
+
+	switch {
+	case ok && !stale:
+		return key, nil
+	case throttled:
+		// Keep serving the keys we have if the issuer is briefly unreachable
+		if ok {
+			return key, nil
+		}
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return nil, ErrUnknownKey
+	}
+
+	// The fetch is shared by every lookup waiting on it, so it must not be
+	// cancelled with the one that started it; the client's timeout bounds it
+	_, err, _ := j.fetches.Do("", func() (interface{}, error) {
+		keys, err := j.fetch(context.WithoutCancel(ctx))
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.attemptedAt, j.fetchErr = j.now(), err
+		if err == nil {
+			j.keys, j.fetchedAt = keys, j.attemptedAt
+		}
+		return nil, err
+	})
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrUnknownKey
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("auth: fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth: fetch jwks: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("auth: decode jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// One unusable key must not take the others down with it
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 {
+			return nil, fmt.Errorf("auth: bad rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("auth: unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("auth: bad ec coordinate length")
+		}
+		// Parsing the uncompressed point also checks that it is on the curve
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("auth: unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
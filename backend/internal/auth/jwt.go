@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidToken = errors.New("auth: invalid token")
+	ErrTokenExpired = errors.New("auth: token expired")
+)
+
+// ClaimMapping names the claims that identify a token's bearer. Paths are
+// dotted, so "app_metadata.tenant_id" reads a nested claim.
+type ClaimMapping struct {
+	User      string // defaults to "sub"
+	Tenant    string
+	Role      string // a string or an array of roles
+	StudentID string
+}
+
+type VerifierConfig struct {
+	// Secret verifies HS256 tokens
+	Secret []byte
+	// JWKS verifies RS256 and ES256 tokens
+	JWKS *JWKS
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// Leeway allows for clock skew on exp and nbf; defaults to 30s
+	Leeway time.Duration
+	Claims ClaimMapping
+}
+
+// Verifier checks bearer tokens locally against a shared secret or an
+// issuer's published keys; it works with any OIDC issuer.
+type Verifier struct {
+	cfg VerifierConfig
+	now func() time.Time
+}
+
+func NewVerifier(cfg VerifierConfig) (*Verifier, error) {
+	if len(cfg.Secret) == 0 && cfg.JWKS == nil {
+		return nil, fmt.Errorf("auth: a shared secret or a JWKS URL is required")
+	}
+	if cfg.Claims.User == "" {
+		cfg.Claims.User = "sub"
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = 30 * time.Second
+	}
+	return &Verifier{cfg: cfg, now: time.Now}, nil
+}
+
+// Identity is what a verified token says about its bearer. TenantID and Role
+// are empty when the token does not carry them.
+type Identity struct {
+	UserID    uuid.UUID
+	TenantID  uuid.UUID
+	Role      domain.Role
+	StudentID string
+	Claims    map[string]any
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks a token's signature and registered claims and maps it to an
+// identity.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	claims, err := v.Claims(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return v.identity(claims)
+}
+
+// Claims checks a token's signature and registered claims and returns all
+// its claims, for tokens whose bearer isn't one of our users (an LTI
+// launch's, say). Numbers are json.Number.
+func (v *Verifier) Claims(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	if err := v.verifySignature(ctx, header, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) verifySignature(ctx context.Context, header tokenHeader, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch header.Alg {
+	case "HS256":
+		if len(v.cfg.Secret) == 0 {
+			return fmt.Errorf("%w: HS256 tokens are not accepted", ErrInvalidToken)
+		}
+		mac := hmac.New(sha256.New, v.cfg.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	case "RS256", "ES256":
+		if v.cfg.JWKS == nil {
+			return fmt.Errorf("%w: %s tokens are not accepted", ErrInvalidToken, header.Alg)
+		}
+		key, err := v.cfg.JWKS.Key(ctx, header.Kid)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+		if header.Alg == "RS256" {
+			pub, ok := key.(*rsa.PublicKey)
+			if !ok || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+				return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+			}
+			return nil
+		}
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+}
+
+func (v *Verifier) validateClaims(claims map[string]any) error {
+	now := v.now()
+
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now.After(time.Unix(exp, 0).Add(v.cfg.Leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.cfg.Leeway).Before(time.Unix(nbf, 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+
+	if v.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+			return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, iss)
+		}
+	}
+	if v.cfg.Audience != "" && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return fmt.Errorf("%w: audience mismatch", ErrInvalidToken)
+	}
+	return nil
+}
+
+func (v *Verifier) identity(claims map[string]any) (*Identity, error) {
+	id := &Identity{Claims: claims}
+
+	user, _ := lookupClaim(claims, v.cfg.Claims.User).(string)
+	userID, err := uuid.Parse(user)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not a user id", ErrInvalidToken, v.cfg.Claims.User)
+	}
+	id.UserID = userID
+
+	if v.cfg.Claims.Tenant != "" {
+		if tenant, ok := lookupClaim(claims, v.cfg.Claims.Tenant).(string); ok && tenant != "" {
+			if id.TenantID, err = uuid.Parse(tenant); err != nil {
+				return nil, fmt.Errorf("%w: %s is not a tenant id", ErrInvalidToken, v.cfg.Claims.Tenant)
+			}
+		}
+	}
+	if v.cfg.Claims.Role != "" {
+		id.Role = firstRole(lookupClaim(claims, v.cfg.Claims.Role))
+	}
+	if v.cfg.Claims.StudentID != "" {
+		id.StudentID, _ = lookupClaim(claims, v.cfg.Claims.StudentID).(string)
+	}
+	return id, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func numericClaim(claims map[string]any, name string) (int64, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	if i, err := n.Int64(); err == nil {
+		return i, true
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func lookupClaim(claims map[string]any, path string) any {
+	var cur any = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[key]
+	}
+	return cur
+}
+
+// firstRole takes a role claim that is either one role or a list, and returns
+// the first role this service knows.
+func firstRole(v any) domain.Role {
+	switch v := v.(type) {
+	case string:
+		if r := domain.Role(v); r.Valid() {
+			return r
+		}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && domain.Role(s).Valid() {
+				return domain.Role(s)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// ErrForbidden is returned when the actor's role or relationship to a
+// resource does not allow an action.
+var ErrForbidden = errors.New("forbidden")
+
+// Actor is the authenticated user or API key behind a request.
+type Actor struct {
+	// UserID is the key's ID for API key clients, so audit entries are
+	// attributed to the key
+	UserID   uuid.UUID
+	TenantID uuid.UUID
+	Role     domain.Role
+	// StudentID is set for student accounts linked to a roster entry
+	StudentID string
+	// Permissions are those of an API key's scopes; users get their role's
+	Permissions []Permission
+}
+
+// Can reports whether the actor may perform perm: API keys by their scopes,
+// users by their role.
+func (a Actor) Can(perm Permission) bool {
+	if a.Role == domain.RoleService {
+		return slices.Contains(a.Permissions, perm)
+	}
+	return Can(a.Role, perm)
+}
+
+// WithActor stores the actor under UserKey and its tenant under TenantKey.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	ctx = WithTenantID(ctx, actor.TenantID)
+	return context.WithValue(ctx, UserKey, actor)
+}
+
+func GetActor(ctx context.Context) (Actor, error) {
+	actor, ok := ctx.Value(UserKey).(Actor)
+	if !ok {
+		return Actor{}, errors.New("user not found in context")
+	}
+	return actor, nil
+}
+
+func GetUserID(ctx context.Context) (uuid.UUID, error) {
+	actor, err := GetActor(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return actor.UserID, nil
+}
+
+// Permission names an action guarded on a route.
+type Permission string
+
+const (
+	PermExamRead          Permission = "exam:read"
+	PermExamWrite         Permission = "exam:write"
+	PermSubmissionRead    Permission = "submission:read"
+	PermSubmissionWrite   Permission = "submission:write"
+	PermGradeRead         Permission = "grade:read"
+	PermGradeOverride     Permission = "grade:override"
+	PermFeedbackRead      Permission = "feedback:read"
+	PermRubricAdapt       Permission = "rubric:adapt"
+	PermEscalationResolve Permission = "escalation:resolve"
+	PermRosterRead        Permission = "roster:read"
+	PermRosterWrite       Permission = "roster:write"
+	PermProfileRead       Permission = "profile:read"
+	PermAnalyticsRead     Permission = "analytics:read"
+	PermExportRead        Permission = "export:read"
+	PermAuditRead         Permission = "audit:read"
+	PermAuditVerify       Permission = "audit:verify"
+	PermBudgetManage      Permission = "budget:manage"
+	PermUserManage        Permission = "user:manage"
+	PermAPIKeyManage      Permission = "apikey:manage"
+	PermWebhookManage     Permission = "webhook:manage"
+	PermLTIManage         Permission = "lti:manage"
+)
+
+var rolePermissions = map[domain.Role][]Permission{
+	domain.RoleAdmin: {
+		PermExamRead, PermExamWrite, PermSubmissionRead, PermSubmissionWrite,
+		PermGradeRead, PermGradeOverride, PermFeedbackRead, PermRubricAdapt,
+		PermEscalationResolve, PermRosterRead, PermRosterWrite, PermProfileRead,
+		PermAnalyticsRead, PermExportRead, PermAuditRead, PermBudgetManage,
+		PermUserManage, PermAPIKeyManage, PermAuditVerify, PermWebhookManage,
+		PermLTIManage,
+	},
+	domain.RoleTeacher: {
+		PermExamRead, PermExamWrite, PermSubmissionRead, PermSubmissionWrite,
+		PermGradeRead, PermGradeOverride, PermFeedbackRead, PermRosterRead,
+		PermRosterWrite, PermProfileRead, PermAnalyticsRead, PermExportRead,
+	},
+	domain.RoleReviewer: {
+		PermExamRead, PermSubmissionRead, PermGradeRead, PermFeedbackRead,
+		PermEscalationResolve, PermProfileRead, PermAnalyticsRead, PermExportRead,
+		PermAuditRead,
+	},
+	domain.RoleStudent: {
+		PermFeedbackRead, PermProfileRead,
+	},
+}
+
+// Can reports whether a role grants a permission.
+func Can(role domain.Role, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
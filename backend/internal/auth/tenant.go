@@ -12,6 +12,7 @@ type contextKey string
 const (
 	TenantKey contextKey = "tenant_id"
 	UserKey   contextKey = "user_id"
+	JobKey    contextKey = "job"
 )
 
 func WithTenantID(ctx context.Context, tenantID uuid.UUID) context.Context {
@@ -29,3 +30,18 @@ func GetTenantID(ctx context.Context) (uuid.UUID, error) {
 	}
 	return id, nil
 }
+
+// Job is the background job a ctx runs in. Work a job does is attributed to
+// it, and to the actor who queued it if there is one.
+type Job struct {
+	ID string
+}
+
+func WithJob(ctx context.Context, job Job) context.Context {
+	return context.WithValue(ctx, JobKey, job)
+}
+
+func GetJob(ctx context.Context) (Job, bool) {
+	job, ok := ctx.Value(JobKey).(Job)
+	return job, ok
+}
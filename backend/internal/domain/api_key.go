@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// APIKey lets a machine client (a scanning station, an SIS sync job) call
+// the API for a tenant within a set of scopes. The key itself is only shown
+// when it is created; KeyHash is its SHA-256.
+type APIKey struct {
+	bun.BaseModel `bun:"table:api_keys,alias:ak"`
+
+	ID         uuid.UUID  `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID   uuid.UUID  `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	Name       string     `bun:"name,notnull" json:"name"`
+	Prefix     string     `bun:"prefix,notnull" json:"prefix"`
+	KeyHash    string     `bun:"key_hash,notnull" json:"-"`
+	Scopes     []string   `bun:"scopes,type:jsonb" json:"scopes"`
+	ExpiresAt  *time.Time `bun:"expires_at" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `bun:"last_used_at" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `bun:"revoked_at" json:"revoked_at,omitempty"`
+	CreatedBy  *uuid.UUID `bun:"created_by,type:uuid" json:"created_by,omitempty"`
+	CreatedAt  time.Time  `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// Active reports whether the key may still be used at t.
+func (k *APIKey) Active(t time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || t.Before(*k.ExpiresAt))
+}
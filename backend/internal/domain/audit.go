@@ -1,23 +1,87 @@
 package domain
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
 
+// ChainSeed stands in for the previous hash of the first entry in a chain.
+const ChainSeed = "initial_seed"
+
+// Hash versions. Version 1 entries predate sequence numbers; their hash
+// covers neither seq, actor_type nor metadata.
+const (
+	HashV1             = 1
+	HashV2             = 2
+	CurrentHashVersion = HashV2
+)
+
 type AuditLog struct {
 	bun.BaseModel `bun:"table:audit_log,alias:al"`
 
-	ID         uuid.UUID              `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
-	EntityType string                 `bun:"entity_type,notnull" json:"entity_type"`
-	EntityID   uuid.UUID              `bun:"entity_id,notnull,type:uuid" json:"entity_id"`
-	EventType  string                 `bun:"event_type,notnull" json:"event_type"`
-	ActorID    *uuid.UUID             `bun:"actor_id,type:uuid" json:"actor_id,omitempty"`
-	ActorType  string                 `bun:"actor_type" json:"actor_type,omitempty"`
-	Changes    map[string]interface{} `bun:"changes,type:jsonb" json:"changes"`
-	Metadata   map[string]interface{} `bun:"metadata,type:jsonb" json:"metadata,omitempty"`
-	Hash       string                 `bun:"hash,notnull" json:"hash"`
-	CreatedAt  time.Time              `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	ID          uuid.UUID              `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID    *uuid.UUID             `bun:"tenant_id,type:uuid" json:"tenant_id,omitempty"`
+	EntityType  string                 `bun:"entity_type,notnull" json:"entity_type"`
+	EntityID    uuid.UUID              `bun:"entity_id,notnull,type:uuid" json:"entity_id"`
+	EventType   string                 `bun:"event_type,notnull" json:"event_type"`
+	ActorID     *uuid.UUID             `bun:"actor_id,type:uuid" json:"actor_id,omitempty"`
+	ActorType   string                 `bun:"actor_type" json:"actor_type,omitempty"`
+	Changes     map[string]interface{} `bun:"changes,type:jsonb" json:"changes"`
+	Metadata    map[string]interface{} `bun:"metadata,type:jsonb" json:"metadata,omitempty"`
+	Seq         int64                  `bun:"seq,notnull" json:"seq"` // 1-based position in the tenant's chain
+	HashVersion int                    `bun:"hash_version,notnull" json:"hash_version"`
+	Hash        string                 `bun:"hash,notnull" json:"hash"`
+	PrevHash    string                 `bun:"prev_hash,scanonly" json:"prev_hash,omitempty"` // hash of the entry before, when selected
+	CreatedAt   time.Time              `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// ChainHash is the entry's hash when it follows prevHash in a chain:
+// SHA-256(prevHash + seq|entityType|entityID|eventType|actorID|actorType|
+// changesJSON|metadataJSON), or for version 1 entries
+// SHA-256(prevHash + entityType|entityID|eventType|actorID|changesJSON).
+func (l *AuditLog) ChainHash(prevHash string) string {
+	changesJSON, _ := json.Marshal(l.Changes)
+	actorID := ""
+	if l.ActorID != nil {
+		actorID = l.ActorID.String()
+	}
+
+	var data string
+	if l.HashVersion >= HashV2 {
+		metadataJSON, _ := json.Marshal(l.Metadata)
+		data = fmt.Sprintf("%d|%s|%s|%s|%s|%s|%s|%s", l.Seq, l.EntityType, l.EntityID, l.EventType, actorID, l.ActorType, string(changesJSON), string(metadataJSON))
+	} else {
+		data = fmt.Sprintf("%s|%s|%s|%s|%s", l.EntityType, l.EntityID, l.EventType, actorID, string(changesJSON))
+	}
+	sum := sha256.Sum256([]byte(prevHash + data))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditCheckpoint is a signed statement that a tenant's audit chain had
+// EntryCount entries, the last being HeadID with hash HeadHash. KeyID names
+// the Ed25519 key that made Signature, a base64 signature of SignedPayload.
+type AuditCheckpoint struct {
+	bun.BaseModel `bun:"table:audit_checkpoints,alias:ac"`
+
+	ID         uuid.UUID `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID   uuid.UUID `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	EntryCount int64     `bun:"entry_count,notnull" json:"entry_count"`
+	HeadID     uuid.UUID `bun:"head_id,notnull,type:uuid" json:"head_id"`
+	HeadHash   string    `bun:"head_hash,notnull" json:"head_hash"`
+	KeyID      string    `bun:"key_id,notnull" json:"key_id"`
+	Signature  string    `bun:"signature,notnull" json:"signature"`
+	CreatedAt  time.Time `bun:"created_at,notnull" json:"created_at"`
+}
+
+// SignedPayload is the text a checkpoint's signature covers. CreatedAt must
+// already be at the database's microsecond precision.
+func (c *AuditCheckpoint) SignedPayload() string {
+	return fmt.Sprintf("harama-audit-checkpoint/v1\n%s\n%d\n%s\n%s\n%s",
+		c.TenantID, c.EntryCount, c.HeadID, c.HeadHash, c.CreatedAt.UTC().Format(time.RFC3339Nano))
 }
@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type Difficulty string
+
+const (
+	DifficultyEasy   Difficulty = "easy"
+	DifficultyMedium Difficulty = "medium"
+	DifficultyHard   Difficulty = "hard"
+)
+
+// BankItem is a question in the tenant's question bank, with its rubric,
+// kept apart from any exam so that it can be reused in many. It is tagged
+// with what it assesses and for whom.
+type BankItem struct {
+	bun.BaseModel `bun:"table:bank_items,alias:bi"`
+
+	ID           uuid.UUID       `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID     uuid.UUID       `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	QuestionText string          `bun:"question_text,notnull" json:"question_text"`
+	Points       int             `bun:"points,notnull" json:"points"`
+	AnswerType   AnswerType      `bun:"answer_type,notnull" json:"answer_type"`
+	AnswerKey    string          `bun:"answer_key,nullzero" json:"answer_key,omitempty"`
+	Choices      []Choice        `bun:"choices,type:jsonb" json:"choices,omitempty"`
+	VisualAids   []string        `bun:"visual_aids,type:jsonb" json:"visual_aids,omitempty"`
+	Rubric       *RubricDocument `bun:"rubric,type:jsonb" json:"rubric,omitempty"`
+
+	Topic             string     `bun:"topic,nullzero" json:"topic,omitempty"`
+	LearningObjective string     `bun:"learning_objective,nullzero" json:"learning_objective,omitempty"`
+	Difficulty        Difficulty `bun:"difficulty,nullzero" json:"difficulty,omitempty"`
+	GradeLevel        string     `bun:"grade_level,nullzero" json:"grade_level,omitempty"`
+
+	CreatedBy *uuid.UUID `bun:"created_by,type:uuid" json:"created_by,omitempty"`
+	CreatedAt time.Time  `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt time.Time  `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+
+	Stats *BankItemStats `bun:"-" json:"stats,omitempty"`
+}
+
+// Question is the item as a question of an exam, with a copy of its rubric.
+func (b *BankItem) Question() Question {
+	q := Question{
+		QuestionText: b.QuestionText,
+		Points:       b.Points,
+		AnswerType:   b.AnswerType,
+		AnswerKey:    b.AnswerKey,
+		Choices:      b.Choices,
+		VisualAids:   b.VisualAids,
+		BankItemID:   &b.ID,
+	}
+	if b.Rubric != nil {
+		q.Rubric = b.Rubric.ToRubric()
+	}
+	return q
+}
+
+// BankItemStats is how a bank item has done in every exam it was used in,
+// its own questions and those made from it.
+type BankItemStats struct {
+	BankItemID uuid.UUID `bun:"bank_item_id" json:"-"`
+	// Uses counts the questions made from the item, Exams the exams they
+	// are in
+	Uses   int `bun:"uses" json:"uses"`
+	Exams  int `bun:"exams" json:"exams"`
+	Graded int `bun:"graded" json:"graded"`
+	// Facility is the mean fraction of the points awarded, from 0 to 1,
+	// once graded
+	Facility   *float64   `bun:"facility" json:"facility,omitempty"`
+	FullMarks  int        `bun:"full_marks" json:"full_marks"`
+	ZeroScores int        `bun:"zero_scores" json:"zero_scores"`
+	LastUsedAt *time.Time `bun:"last_used_at" json:"last_used_at,omitempty"`
+}
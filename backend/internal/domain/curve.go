@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type CurveMethod string
+
+const (
+	// CurveLinear scales totals so that their mean is the target mean
+	CurveLinear CurveMethod = "linear"
+	// CurveSqrt takes the square root of each total as a fraction of the
+	// maximum, raising low totals the most
+	CurveSqrt CurveMethod = "sqrt"
+	// CurveFlat adds the same bonus to every total
+	CurveFlat CurveMethod = "flat"
+)
+
+// CurvePolicy is how a curve adjusts an exam's totals. Adjusted totals are
+// never below zero, and with CapAtMax never above the exam's maximum.
+type CurvePolicy struct {
+	Method CurveMethod `json:"method"`
+	// TargetMean is the mean a linear curve scales to, as a percentage of
+	// the maximum
+	TargetMean float64 `json:"target_mean,omitempty"`
+	// Bonus is the points a flat curve adds
+	Bonus    float64 `json:"bonus,omitempty"`
+	CapAtMax bool    `json:"cap_at_max"`
+}
+
+// CurvedScore is a submission's total before and after a curve.
+type CurvedScore struct {
+	SubmissionID  uuid.UUID `json:"submission_id"`
+	StudentID     string    `json:"student_id"`
+	RawTotal      float64   `json:"raw_total"`
+	AdjustedTotal float64   `json:"adjusted_total"`
+}
+
+// GradeCurve is a version of the curve applied to an exam. It adjusts the
+// totals of the submissions graded when it was applied and leaves their
+// grades as they are. Applying another curve or removing it deactivates it
+// and keeps it on record.
+type GradeCurve struct {
+	bun.BaseModel `bun:"table:grade_curves,alias:gc"`
+
+	ID            uuid.UUID     `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID      uuid.UUID     `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	ExamID        uuid.UUID     `bun:"exam_id,notnull,type:uuid" json:"exam_id"`
+	Version       int           `bun:"version,notnull" json:"version"`
+	Policy        CurvePolicy   `bun:"policy,notnull,type:jsonb" json:"policy"`
+	MaxTotal      float64       `bun:"max_total,notnull" json:"max_total"`
+	RawMean       float64       `bun:"raw_mean,notnull" json:"raw_mean"`
+	AdjustedMean  float64       `bun:"adjusted_mean,notnull" json:"adjusted_mean"`
+	Scores        []CurvedScore `bun:"scores,notnull,type:jsonb" json:"scores,omitempty"`
+	Active        bool          `bun:"active,notnull" json:"active"`
+	Note          string        `bun:"note,nullzero" json:"note,omitempty"`
+	AppliedBy     *uuid.UUID    `bun:"applied_by,type:uuid" json:"applied_by,omitempty"`
+	CreatedAt     time.Time     `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	DeactivatedAt *time.Time    `bun:"deactivated_at" json:"deactivated_at,omitempty"`
+}
+
+// CurvedTotal is the total the curve gives a submission whose grades now
+// total rawTotal. A submission graded since the curve was applied, or
+// regraded since to another total, is not curved until the curve is applied
+// again.
+func (c *GradeCurve) CurvedTotal(submissionID uuid.UUID, rawTotal float64) (float64, bool) {
+	for _, score := range c.Scores {
+		if score.SubmissionID == submissionID && math.Abs(score.RawTotal-rawTotal) < 0.005 {
+			return score.AdjustedTotal, true
+		}
+	}
+	return 0, false
+}
@@ -7,6 +7,11 @@ import (
 	"github.com/uptrace/bun"
 )
 
+const (
+	EscalationPending  = "pending"
+	EscalationResolved = "resolved"
+)
+
 type EscalationCase struct {
 	bun.BaseModel `bun:"table:escalations,alias:esc"`
 
@@ -0,0 +1,127 @@
+package domain
+
+// ExamDocumentFormat and ExamDocumentVersion identify a portable exam
+// definition.
+const (
+	ExamDocumentFormat  = "harama.exam"
+	ExamDocumentVersion = 1
+)
+
+// ExamDocument is an exam's complete definition, questions, numbering,
+// rubrics and answer keys, free of database IDs so that it can be moved
+// between tenants and kept under version control. It is written as JSON or
+// YAML.
+type ExamDocument struct {
+	Format      string             `json:"format" yaml:"format"`
+	Version     int                `json:"version" yaml:"version"`
+	Title       string             `json:"title" yaml:"title"`
+	Subject     string             `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Description string             `json:"description,omitempty" yaml:"description,omitempty"`
+	Questions   []QuestionDocument `json:"questions" yaml:"questions"`
+}
+
+type QuestionDocument struct {
+	Number     string          `json:"number,omitempty" yaml:"number,omitempty"`
+	Group      string          `json:"group,omitempty" yaml:"group,omitempty"`
+	Text       string          `json:"text" yaml:"text"`
+	Points     int             `json:"points" yaml:"points"`
+	AnswerType AnswerType      `json:"answer_type" yaml:"answer_type"`
+	AnswerKey  string          `json:"answer_key,omitempty" yaml:"answer_key,omitempty"`
+	Choices    []Choice        `json:"choices,omitempty" yaml:"choices,omitempty"`
+	VisualAids []string        `json:"visual_aids,omitempty" yaml:"visual_aids,omitempty"`
+	Rubric     *RubricDocument `json:"rubric,omitempty" yaml:"rubric,omitempty"`
+}
+
+type RubricDocument struct {
+	Criteria       []CriterionDocument     `json:"criteria" yaml:"criteria"`
+	PartialCredit  []PartialCreditDocument `json:"partial_credit,omitempty" yaml:"partial_credit,omitempty"`
+	CommonMistakes []MistakeDocument       `json:"common_mistakes,omitempty" yaml:"common_mistakes,omitempty"`
+	KeyConcepts    []string                `json:"key_concepts,omitempty" yaml:"key_concepts,omitempty"`
+	GradingNotes   string                  `json:"grading_notes,omitempty" yaml:"grading_notes,omitempty"`
+	StrictMode     bool                    `json:"strict_mode,omitempty" yaml:"strict_mode,omitempty"`
+}
+
+type CriterionDocument struct {
+	ID          string  `json:"id,omitempty" yaml:"id,omitempty"`
+	Description string  `json:"description" yaml:"description"`
+	Points      float64 `json:"points" yaml:"points"`
+	Required    bool    `json:"required,omitempty" yaml:"required,omitempty"`
+	Category    string  `json:"category,omitempty" yaml:"category,omitempty"`
+}
+
+type PartialCreditDocument struct {
+	ID           string   `json:"id,omitempty" yaml:"id,omitempty"`
+	Condition    string   `json:"condition" yaml:"condition"`
+	Points       float64  `json:"points" yaml:"points"`
+	Description  string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Dependencies []string `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+}
+
+type MistakeDocument struct {
+	ID          string  `json:"id,omitempty" yaml:"id,omitempty"`
+	Description string  `json:"description" yaml:"description"`
+	Penalty     float64 `json:"penalty" yaml:"penalty"`
+	Category    string  `json:"category,omitempty" yaml:"category,omitempty"`
+}
+
+// NewRubricDocument is the portable form of a rubric.
+func NewRubricDocument(r *Rubric) *RubricDocument {
+	d := &RubricDocument{
+		Criteria:     []CriterionDocument{},
+		KeyConcepts:  r.KeyConcepts,
+		GradingNotes: r.GradingNotes,
+		StrictMode:   r.StrictMode,
+	}
+	for _, c := range r.FullCreditCriteria {
+		d.Criteria = append(d.Criteria, CriterionDocument{
+			ID: c.ID, Description: c.Description, Points: c.Points, Required: c.Required, Category: c.Category,
+		})
+	}
+	for _, p := range r.PartialCreditRules {
+		d.PartialCredit = append(d.PartialCredit, PartialCreditDocument{
+			ID: p.ID, Condition: p.Condition, Points: p.Points, Description: p.Description, Dependencies: p.Dependencies,
+		})
+	}
+	for _, m := range r.CommonMistakes {
+		d.CommonMistakes = append(d.CommonMistakes, MistakeDocument{
+			ID: m.ID, Description: m.Description, Penalty: m.Penalty, Category: m.Category,
+		})
+	}
+	return d
+}
+
+// ToRubric is the rubric a document describes, without IDs. Its lists are
+// NOT NULL, so are kept empty rather than nil.
+func (d *RubricDocument) ToRubric() *Rubric {
+	r := &Rubric{
+		FullCreditCriteria: []Criterion{},
+		PartialCreditRules: []PartialCreditRule{},
+		CommonMistakes:     []CommonMistake{},
+		KeyConcepts:        d.KeyConcepts,
+		GradingNotes:       d.GradingNotes,
+		StrictMode:         d.StrictMode,
+	}
+	for _, c := range d.Criteria {
+		r.FullCreditCriteria = append(r.FullCreditCriteria, Criterion{
+			ID: c.ID, Description: c.Description, Points: c.Points, Required: c.Required, Category: c.Category,
+		})
+	}
+	for _, p := range d.PartialCredit {
+		r.PartialCreditRules = append(r.PartialCreditRules, PartialCreditRule{
+			ID: p.ID, Condition: p.Condition, Points: p.Points, Description: p.Description, Dependencies: p.Dependencies,
+		})
+	}
+	for _, m := range d.CommonMistakes {
+		r.CommonMistakes = append(r.CommonMistakes, CommonMistake{
+			ID: m.ID, Description: m.Description, Penalty: m.Penalty, Category: m.Category,
+		})
+	}
+	return r
+}
+
+// FieldError is a mistake in a document, an exam definition or a rubric.
+// Path locates it, e.g. questions[2].rubric.criteria[0].
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
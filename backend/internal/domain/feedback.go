@@ -11,6 +11,7 @@ type FeedbackEvent struct {
 	bun.BaseModel `bun:"table:feedback_events,alias:fe"`
 
 	ID           uuid.UUID `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID     uuid.UUID `bun:"tenant_id,type:uuid" json:"tenant_id"`
 	QuestionID   uuid.UUID `bun:"question_id,notnull,type:uuid" json:"question_id"`
 	SubmissionID uuid.UUID `bun:"submission_id,notnull,type:uuid" json:"submission_id"`
 
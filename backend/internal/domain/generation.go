@@ -0,0 +1,43 @@
+package domain
+
+// GenerationSettings are the model parameters used for one kind of AI call.
+// Nil fields mean "not set" so that registry layers can be merged; the
+// resolved copy recorded on results has every field the call used.
+type GenerationSettings struct {
+	Model           string            `json:"model"`
+	Temperature     *float64          `json:"temperature,omitempty"`
+	TopP            *float64          `json:"top_p,omitempty"`
+	TopK            *int              `json:"top_k,omitempty"`
+	MaxOutputTokens *int              `json:"max_output_tokens,omitempty"`
+	Safety          map[string]string `json:"safety,omitempty"` // harm category -> block threshold
+}
+
+// Merge returns s with every field that is set in override replaced.
+func (s GenerationSettings) Merge(override GenerationSettings) GenerationSettings {
+	if override.Model != "" {
+		s.Model = override.Model
+	}
+	if override.Temperature != nil {
+		s.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		s.TopP = override.TopP
+	}
+	if override.TopK != nil {
+		s.TopK = override.TopK
+	}
+	if override.MaxOutputTokens != nil {
+		s.MaxOutputTokens = override.MaxOutputTokens
+	}
+	if len(override.Safety) > 0 {
+		merged := make(map[string]string, len(s.Safety)+len(override.Safety))
+		for k, v := range s.Safety {
+			merged[k] = v
+		}
+		for k, v := range override.Safety {
+			merged[k] = v
+		}
+		s.Safety = merged
+	}
+	return s
+}
@@ -18,6 +18,7 @@ type GradingResult struct {
 	CriteriaMet   []string  `json:"criteria_met"`
 	MistakesFound []string  `json:"mistakes_found"`
 	AIEvaluatorID string    `json:"ai_evaluator_id"`
+	Settings      *GenerationSettings `json:"generation_settings,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
 }
 
@@ -47,6 +48,8 @@ type FinalGrade struct {
 	MistakesFound []string    `bun:"mistakes_found,type:jsonb" json:"mistakes_found"`
 	AIEvaluatorID string      `bun:"ai_evaluator_id" json:"ai_evaluator_id"`
 	Status        GradeStatus `bun:"status,notnull" json:"status"`
+	// Resolved model settings of each evaluator that contributed, by evaluator ID
+	GenerationSettings map[string]GenerationSettings `bun:"generation_settings,type:jsonb" json:"generation_settings,omitempty"`
 	// GradedBy      *uuid.UUID  `bun:"graded_by,type:uuid" json:"graded_by,omitempty"` // Not in migration 001
 	CreatedAt     time.Time   `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
 	UpdatedAt     time.Time   `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
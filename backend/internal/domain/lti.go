@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// LTIPlatform is an LMS a tenant has registered as an LTI 1.3 platform.
+// The URLs are the platform's OIDC login, OAuth2 token and key set
+// endpoints; ClientID is the one the platform issued to us.
+type LTIPlatform struct {
+	bun.BaseModel `bun:"table:lti_platforms,alias:lp"`
+
+	ID           uuid.UUID  `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID     uuid.UUID  `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	Name         string     `bun:"name,notnull" json:"name"`
+	Issuer       string     `bun:"issuer,notnull" json:"issuer"`
+	ClientID     string     `bun:"client_id,notnull" json:"client_id"`
+	DeploymentID string     `bun:"deployment_id,nullzero" json:"deployment_id,omitempty"` // empty accepts any deployment
+	AuthLoginURL string     `bun:"auth_login_url,notnull" json:"auth_login_url"`
+	AuthTokenURL string     `bun:"auth_token_url,notnull" json:"auth_token_url"`
+	JWKSURL      string     `bun:"jwks_url,notnull" json:"jwks_url"`
+	CreatedBy    *uuid.UUID `bun:"created_by,type:uuid" json:"created_by,omitempty"`
+	CreatedAt    time.Time  `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// LTILaunchState is the state and nonce of an OIDC login, kept until the
+// launch that answers it.
+type LTILaunchState struct {
+	bun.BaseModel `bun:"table:lti_launch_states,alias:lls"`
+
+	State      string    `bun:"state,pk" json:"state"`
+	Nonce      string    `bun:"nonce,notnull" json:"nonce"`
+	PlatformID uuid.UUID `bun:"platform_id,notnull,type:uuid" json:"platform_id"`
+	ExpiresAt  time.Time `bun:"expires_at,notnull" json:"expires_at"`
+}
+
+// LTILineItem binds an exam to a gradebook column of a platform; scores
+// for the exam's submissions are published to LineItemURL.
+type LTILineItem struct {
+	bun.BaseModel `bun:"table:lti_line_items,alias:lli"`
+
+	ID             uuid.UUID `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID       uuid.UUID `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	PlatformID     uuid.UUID `bun:"platform_id,notnull,type:uuid" json:"platform_id"`
+	ExamID         uuid.UUID `bun:"exam_id,notnull,type:uuid" json:"exam_id"`
+	ResourceLinkID string    `bun:"resource_link_id,notnull" json:"resource_link_id"`
+	LineItemURL    string    `bun:"line_item_url,notnull" json:"line_item_url"`
+	CreatedAt      time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// LTIUserLink maps a platform's user (the launch's sub) to a student on the
+// roster by the student's external ID.
+type LTIUserLink struct {
+	bun.BaseModel `bun:"table:lti_user_links,alias:lul"`
+
+	ID         uuid.UUID `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID   uuid.UUID `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	PlatformID uuid.UUID `bun:"platform_id,notnull,type:uuid" json:"platform_id"`
+	LMSUserID  string    `bun:"lms_user_id,notnull" json:"lms_user_id"`
+	StudentID  string    `bun:"student_id,notnull" json:"student_id"`
+	CreatedAt  time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+type ScorePushStatus string
+
+const (
+	ScorePushPending ScorePushStatus = "pending"
+	ScorePushPushed  ScorePushStatus = "pushed"
+	ScorePushFailed  ScorePushStatus = "failed"
+)
+
+// LTIScorePush is a submission's total queued for a line item and the
+// outcome of its latest attempt. Pending pushes are retried at
+// NextAttemptAt. A total the exam's curve covers is given curved, with
+// RawScore the total before version CurveVersion of the curve.
+type LTIScorePush struct {
+	bun.BaseModel `bun:"table:lti_score_pushes,alias:lsp"`
+
+	ID             uuid.UUID       `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID       uuid.UUID       `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	LineItemID     uuid.UUID       `bun:"line_item_id,notnull,type:uuid" json:"line_item_id"`
+	SubmissionID   uuid.UUID       `bun:"submission_id,notnull,type:uuid" json:"submission_id"`
+	LMSUserID      string          `bun:"lms_user_id,notnull" json:"lms_user_id"`
+	ScoreGiven     float64         `bun:"score_given,notnull" json:"score_given"`
+	ScoreMaximum   float64         `bun:"score_maximum,notnull" json:"score_maximum"`
+	RawScore       *float64        `bun:"raw_score" json:"raw_score,omitempty"`
+	CurveVersion   int             `bun:"curve_version,nullzero" json:"curve_version,omitempty"`
+	Status         ScorePushStatus `bun:"status,notnull" json:"status"`
+	Attempts       int             `bun:"attempts,notnull" json:"attempts"`
+	NextAttemptAt  *time.Time      `bun:"next_attempt_at" json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time      `bun:"last_attempt_at" json:"last_attempt_at,omitempty"`
+	ResponseStatus int             `bun:"response_status,nullzero" json:"response_status,omitempty"`
+	LastError      string          `bun:"last_error,nullzero" json:"last_error,omitempty"`
+	PushedAt       *time.Time      `bun:"pushed_at" json:"pushed_at,omitempty"`
+	CreatedAt      time.Time       `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
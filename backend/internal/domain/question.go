@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"strings"
+
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
@@ -15,8 +17,34 @@ type Question struct {
 	AnswerType     AnswerType `bun:"answer_type,notnull" json:"answer_type"`
 	QuestionNumber string     `bun:"question_number" json:"question_number,omitempty"`
 	QuestionGroup  string     `bun:"question_group" json:"question_group,omitempty"`
-	Rubric         *Rubric    `bun:"rel:has-one,join:id=question_id" json:"rubric"`
-	VisualAids     []string   `bun:"visual_aids,type:jsonb" json:"visual_aids"`
+	// AnswerKey is the expected answer; for a multiple choice question, the
+	// IDs of its correct choices, comma separated
+	AnswerKey  string   `bun:"answer_key" json:"answer_key,omitempty"`
+	Choices    []Choice `bun:"choices,type:jsonb" json:"choices,omitempty"`
+	Rubric     *Rubric  `bun:"rel:has-one,join:id=question_id" json:"rubric"`
+	VisualAids []string `bun:"visual_aids,type:jsonb" json:"visual_aids"`
+	// BankItemID is the bank item the question was made from, whose
+	// statistics its grades count towards. A linked question follows edits
+	// to the item; one copied from it doesn't.
+	BankItemID *uuid.UUID `bun:"bank_item_id,type:uuid" json:"bank_item_id,omitempty"`
+	BankLinked bool       `bun:"bank_linked,notnull" json:"bank_linked,omitempty"`
+}
+
+// Choice is an option of a multiple choice question.
+type Choice struct {
+	ID   string `json:"id" yaml:"id"`
+	Text string `json:"text" yaml:"text"`
+}
+
+// SplitAnswerKey splits a multiple choice answer key into its choice IDs.
+func SplitAnswerKey(key string) []string {
+	var ids []string
+	for _, id := range strings.Split(key, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
 type AnswerType string
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type QuestionPaperStatus string
+
+const (
+	QuestionPaperPending    QuestionPaperStatus = "pending"
+	QuestionPaperProcessing QuestionPaperStatus = "processing"
+	QuestionPaperReady      QuestionPaperStatus = "ready" // draft awaiting review
+	QuestionPaperConfirmed  QuestionPaperStatus = "confirmed"
+	QuestionPaperFailed     QuestionPaperStatus = "failed"
+)
+
+// QuestionPaperPage is an uploaded photo of a page, or a whole PDF, kept in
+// object storage.
+type QuestionPaperPage struct {
+	ObjectName  string `json:"object_name"`
+	ContentType string `json:"content_type"`
+}
+
+// QuestionPaper is an exam's question paper, uploaded to create its
+// questions. Once read it holds a draft of them, with figures found on the
+// pages as visual aids; the questions are only created when a teacher
+// confirms the draft.
+type QuestionPaper struct {
+	bun.BaseModel `bun:"table:question_papers,alias:qp"`
+
+	ID          uuid.UUID           `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID    uuid.UUID           `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	ExamID      uuid.UUID           `bun:"exam_id,notnull,type:uuid" json:"exam_id"`
+	Status      QuestionPaperStatus `bun:"status,notnull" json:"status"`
+	Pages       []QuestionPaperPage `bun:"pages,notnull,type:jsonb" json:"pages"`
+	Draft       []Question          `bun:"draft,type:jsonb" json:"draft"`
+	Error       string              `bun:"error,nullzero" json:"error,omitempty"`
+	UploadedBy  *uuid.UUID          `bun:"uploaded_by,type:uuid" json:"uploaded_by,omitempty"`
+	CreatedAt   time.Time           `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	ConfirmedBy *uuid.UUID          `bun:"confirmed_by,type:uuid" json:"confirmed_by,omitempty"`
+	ConfirmedAt *time.Time          `bun:"confirmed_at" json:"confirmed_at,omitempty"`
+}
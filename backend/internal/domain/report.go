@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Report formats: a PDF for a submission, a ZIP of PDFs for an exam
+const (
+	ReportFormatPDF = "pdf"
+	ReportFormatZIP = "zip"
+)
+
+type ReportStatus string
+
+const (
+	ReportPending    ReportStatus = "pending"
+	ReportProcessing ReportStatus = "processing"
+	ReportCompleted  ReportStatus = "completed"
+	ReportFailed     ReportStatus = "failed"
+)
+
+// Report is a marked paper to return to students: a submission's scans
+// with graded answers outlined and scored, followed by its feedback.
+// Exam reports bundle one per graded submission. The file is kept in
+// object storage under ObjectName once the report is completed.
+type Report struct {
+	bun.BaseModel `bun:"table:reports,alias:rp"`
+
+	ID           uuid.UUID    `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID     uuid.UUID    `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	ExamID       uuid.UUID    `bun:"exam_id,notnull,type:uuid" json:"exam_id"`
+	SubmissionID *uuid.UUID   `bun:"submission_id,type:uuid" json:"submission_id,omitempty"` // nil for an exam's reports
+	Format       string       `bun:"format,notnull" json:"format"`
+	Status       ReportStatus `bun:"status,notnull" json:"status"`
+	ObjectName   string       `bun:"object_name,nullzero" json:"-"`
+	SizeBytes    int64        `bun:"size_bytes,nullzero" json:"size_bytes,omitempty"`
+	Included     int          `bun:"included,notnull" json:"included"` // submissions in the file
+	Error        string       `bun:"error,nullzero" json:"error,omitempty"`
+	RequestedBy  *uuid.UUID   `bun:"requested_by,type:uuid" json:"requested_by,omitempty"`
+	CreatedAt    time.Time    `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	CompletedAt  *time.Time   `bun:"completed_at" json:"completed_at,omitempty"`
+}
+
+// ContentType is the media type of the report's file.
+func (r *Report) ContentType() string {
+	if r.Format == ReportFormatZIP {
+		return "application/zip"
+	}
+	return "application/pdf"
+}
@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Student is a learner on a tenant's roster. ExternalID is the school's own
+// student number; submissions refer to students by it.
+type Student struct {
+	bun.BaseModel `bun:"table:students,alias:st"`
+
+	ID         uuid.UUID `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID   uuid.UUID `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	ExternalID string    `bun:"external_id,notnull" json:"external_id"`
+	Name       string    `bun:"name,notnull" json:"name"`
+	Email      string    `bun:"email" json:"email,omitempty"`
+	CreatedAt  time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// Class is a teaching group or section that exams are assigned to.
+type Class struct {
+	bun.BaseModel `bun:"table:classes,alias:c"`
+
+	ID        uuid.UUID `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID  uuid.UUID `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	Name      string    `bun:"name,notnull" json:"name"`
+	Section   string    `bun:"section" json:"section,omitempty"`
+	Term      string    `bun:"term" json:"term,omitempty"`
+	Students  []Student `bun:"-" json:"students,omitempty"` // filled when a single class is fetched
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+type Enrollment struct {
+	bun.BaseModel `bun:"table:enrollments,alias:en"`
+
+	ClassID    uuid.UUID `bun:"class_id,pk,type:uuid" json:"class_id"`
+	StudentID  uuid.UUID `bun:"student_id,pk,type:uuid" json:"student_id"`
+	TenantID   uuid.UUID `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	EnrolledAt time.Time `bun:"enrolled_at,nullzero,notnull,default:current_timestamp" json:"enrolled_at"`
+}
+
+// ExamClass assigns an exam to a class. An exam with at least one assigned
+// class only accepts submissions from students enrolled in one of them.
+type ExamClass struct {
+	bun.BaseModel `bun:"table:exam_classes,alias:ec"`
+
+	ExamID     uuid.UUID `bun:"exam_id,pk,type:uuid" json:"exam_id"`
+	ClassID    uuid.UUID `bun:"class_id,pk,type:uuid" json:"class_id"`
+	TenantID   uuid.UUID `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	AssignedAt time.Time `bun:"assigned_at,nullzero,notnull,default:current_timestamp" json:"assigned_at"`
+}
+
+// RosterImportResult reports what a CSV roster import changed. Lines with
+// errors are skipped; the rest are applied.
+type RosterImportResult struct {
+	Created  int                 `json:"created"`
+	Updated  int                 `json:"updated"`
+	Enrolled int                 `json:"enrolled"`
+	Removed  int                 `json:"removed"`
+	Errors   []RosterImportError `json:"errors"`
+}
+
+type RosterImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// MissingSubmission is an enrolled student who has not submitted an exam.
+type MissingSubmission struct {
+	ClassID    uuid.UUID `bun:"class_id" json:"class_id"`
+	ClassName  string    `bun:"class_name" json:"class_name"`
+	StudentID  uuid.UUID `bun:"student_id" json:"student_id"`
+	ExternalID string    `bun:"external_id" json:"external_id"`
+	Name       string    `bun:"name" json:"name"`
+	Email      string    `bun:"email" json:"email,omitempty"`
+}
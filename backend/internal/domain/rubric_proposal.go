@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// RubricSourceAIDraft marks a rubric drafted by AI from a question and its
+// model answer.
+const RubricSourceAIDraft = "ai_draft"
+
+type RubricProposalStatus string
+
+const (
+	RubricProposalPending  RubricProposalStatus = "pending"
+	RubricProposalAccepted RubricProposalStatus = "accepted"
+	RubricProposalRejected RubricProposalStatus = "rejected"
+)
+
+// RubricProposal is a rubric suggested for a question. It is kept apart
+// from the question's rubric while pending, so that a teacher can review
+// and edit it, and only replaces that rubric once accepted.
+type RubricProposal struct {
+	bun.BaseModel `bun:"table:rubric_proposals,alias:rbp"`
+
+	ID          uuid.UUID            `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID    uuid.UUID            `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	QuestionID  uuid.UUID            `bun:"question_id,notnull,type:uuid" json:"question_id"`
+	Source      string               `bun:"source,notnull" json:"source"`
+	Status      RubricProposalStatus `bun:"status,notnull" json:"status"`
+	Rubric      *RubricDocument      `bun:"rubric,notnull,type:jsonb" json:"rubric"`
+	ModelAnswer string               `bun:"model_answer,nullzero" json:"model_answer,omitempty"`
+	CreatedBy   *uuid.UUID           `bun:"created_by,type:uuid" json:"created_by,omitempty"`
+	CreatedAt   time.Time            `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	DecidedBy   *uuid.UUID           `bun:"decided_by,type:uuid" json:"decided_by,omitempty"`
+	DecidedAt   *time.Time           `bun:"decided_at" json:"decided_at,omitempty"`
+}
@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StudentProfile is a student's learning record aggregated across every
+// graded exam in a tenant. It is computed from grades on demand rather than
+// stored, so overrides and regrades are reflected immediately.
+type StudentProfile struct {
+	TenantID          uuid.UUID          `json:"tenant_id"`
+	StudentID         string             `json:"student_id"`
+	ExamsTaken        int                `json:"exams_taken"`
+	AnswersGraded     int                `json:"answers_graded"`
+	OverallPercent    float64            `json:"overall_percent"`
+	Subjects          []ScoreTrend       `json:"subjects"`
+	Concepts          []ScoreTrend       `json:"concepts"`
+	RecurringMistakes []RecurringMistake `json:"recurring_mistakes"`
+	MissedCriteria    []MissedCriterion  `json:"missed_criteria"`
+	Exams             []ExamResult       `json:"exams"`
+	GeneratedAt       time.Time          `json:"generated_at"`
+}
+
+// ExamResult is a student's total on a submission, over the answers graded
+// so far, with the total under the exam's curve if the curve covers it.
+type ExamResult struct {
+	ExamID       uuid.UUID `json:"exam_id"`
+	ExamTitle    string    `json:"exam_title"`
+	SubmissionID uuid.UUID `json:"submission_id"`
+	Date         time.Time `json:"date"`
+	Total        float64   `json:"total"`
+	MaxTotal     int       `json:"max_total"`
+	CurvedTotal  *float64  `json:"curved_total,omitempty"`
+	CurveVersion int       `json:"curve_version,omitempty"`
+}
+
+// ScoreTrend follows a subject or rubric key concept over time.
+type ScoreTrend struct {
+	Name    string         `json:"name"`
+	Percent float64        `json:"percent"` // across all attempts
+	Points  []TrendPoint   `json:"points"`  // one per exam, oldest first
+	Slope   float64        `json:"slope"`   // percentage points per exam
+	Trend   TrendDirection `json:"trend"`
+}
+
+type TrendPoint struct {
+	ExamID    uuid.UUID `json:"exam_id"`
+	ExamTitle string    `json:"exam_title"`
+	Date      time.Time `json:"date"`
+	Percent   float64   `json:"percent"`
+}
+
+type TrendDirection string
+
+const (
+	TrendImproving    TrendDirection = "improving"
+	TrendSteady       TrendDirection = "steady"
+	TrendDeclining    TrendDirection = "declining"
+	TrendInsufficient TrendDirection = "insufficient_data"
+)
+
+// RecurringMistake is a mistake found in answers to more than one exam.
+type RecurringMistake struct {
+	Mistake  string    `json:"mistake"`
+	Count    int       `json:"count"`
+	Exams    int       `json:"exams"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// MissedCriterion is a kind of rubric criterion the student keeps failing,
+// grouped by criterion category or, without one, by description.
+type MissedCriterion struct {
+	Criterion string  `json:"criterion"`
+	Missed    int     `json:"missed"`
+	Attempts  int     `json:"attempts"`
+	MissRate  float64 `json:"miss_rate"`
+}
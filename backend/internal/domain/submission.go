@@ -27,6 +27,7 @@ const (
 	StatusProcessing ProcessingStatus = "processing"
 	StatusCompleted  ProcessingStatus = "completed"
 	StatusFailed     ProcessingStatus = "failed"
+	StatusPaused     ProcessingStatus = "paused" // waiting on budget or provider availability; safe to re-trigger
 )
 
 type OCRResult struct {
@@ -36,6 +37,7 @@ type OCRResult struct {
 	ImageURL      string        `json:"image_url"`
 	BoundingBoxes []BoundingBox `json:"bounding_boxes"`
 	CorrectedText *string       `json:"corrected_text"`
+	Settings      *GenerationSettings `json:"generation_settings,omitempty"`
 }
 
 type BoundingBox struct {
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// AIUsage is one metered call to an AI or OCR backend.
+type AIUsage struct {
+	bun.BaseModel `bun:"table:ai_usage,alias:u"`
+
+	ID           uuid.UUID  `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID     uuid.UUID  `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	ExamID       *uuid.UUID `bun:"exam_id,type:uuid" json:"exam_id,omitempty"`
+	SubmissionID *uuid.UUID `bun:"submission_id,type:uuid" json:"submission_id,omitempty"`
+	Task         string     `bun:"task,notnull" json:"task"`
+	EvaluatorID  string     `bun:"evaluator_id" json:"evaluator_id,omitempty"`
+	Provider     string     `bun:"provider" json:"provider"`
+	Model        string     `bun:"model" json:"model"`
+	InputTokens  int        `bun:"input_tokens,notnull" json:"input_tokens"`
+	OutputTokens int        `bun:"output_tokens,notnull" json:"output_tokens"`
+	LatencyMs    int64      `bun:"latency_ms,notnull" json:"latency_ms"`
+	CostUSD      float64    `bun:"cost_usd,notnull" json:"cost_usd"`
+	Success      bool       `bun:"success,notnull" json:"success"`
+	CreatedAt    time.Time  `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// TenantBudget caps a tenant's monthly AI spend. Crossing SoftLimitRatio of
+// the limit raises a warning; crossing the limit pauses grading when HardStop
+// is set.
+type TenantBudget struct {
+	bun.BaseModel `bun:"table:tenant_budgets,alias:tb"`
+
+	TenantID        uuid.UUID `bun:"tenant_id,pk,type:uuid" json:"tenant_id"`
+	MonthlyLimitUSD float64   `bun:"monthly_limit_usd,notnull" json:"monthly_limit_usd"`
+	SoftLimitRatio  float64   `bun:"soft_limit_ratio,notnull,default:0.8" json:"soft_limit_ratio"`
+	HardStop        bool      `bun:"hard_stop,notnull,default:true" json:"hard_stop"`
+	UpdatedAt       time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+}
+
+type BudgetState string
+
+const (
+	BudgetOK       BudgetState = "ok"
+	BudgetWarning  BudgetState = "warning"
+	BudgetExceeded BudgetState = "exceeded"
+)
+
+// BudgetStatus is a tenant's month-to-date spend measured against its budget.
+type BudgetStatus struct {
+	Budget   *TenantBudget `json:"budget,omitempty"`
+	SpentUSD float64       `json:"spent_usd"`
+	State    BudgetState   `json:"state"`
+}
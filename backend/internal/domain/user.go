@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Role decides what a user may do within their tenant.
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleTeacher  Role = "teacher"
+	RoleReviewer Role = "reviewer"
+	RoleStudent  Role = "student"
+
+	// RoleService is held by API key clients. It is not a user role: what a
+	// key may do comes from its scopes.
+	RoleService Role = "service"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAdmin, RoleTeacher, RoleReviewer, RoleStudent:
+		return true
+	}
+	return false
+}
+
+// User is a member of a tenant. ID is the identity provider's user ID, so a
+// verified token maps straight to a row.
+type User struct {
+	bun.BaseModel `bun:"table:users,alias:u"`
+
+	ID       uuid.UUID `bun:"id,pk,type:uuid" json:"id"`
+	TenantID uuid.UUID `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	Email    string    `bun:"email" json:"email,omitempty"`
+	Name     string    `bun:"name" json:"name,omitempty"`
+	Role     Role      `bun:"role,notnull" json:"role"`
+	// StudentID links a student account to its roster entry (the external
+	// student number used on submissions)
+	StudentID string    `bun:"student_id" json:"student_id,omitempty"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// ClassTeacher makes a teacher responsible for a class.
+type ClassTeacher struct {
+	bun.BaseModel `bun:"table:class_teachers,alias:ct"`
+
+	ClassID  uuid.UUID `bun:"class_id,pk,type:uuid" json:"class_id"`
+	UserID   uuid.UUID `bun:"user_id,pk,type:uuid" json:"user_id"`
+	TenantID uuid.UUID `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+}
@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Webhook event types
+const (
+	EventSubmissionOCRCompleted = "submission.ocr_completed"
+	EventSubmissionGraded       = "submission.graded"
+	EventGradeEscalated         = "grade.escalated"
+	EventEscalationResolved     = "grade.escalation_resolved"
+	EventGradeOverridden        = "grade.overridden"
+	EventRubricUpdated          = "rubric.updated"
+)
+
+// WebhookEventTypes lists the events an endpoint can subscribe to.
+var WebhookEventTypes = []string{
+	EventSubmissionOCRCompleted,
+	EventSubmissionGraded,
+	EventGradeEscalated,
+	EventEscalationResolved,
+	EventGradeOverridden,
+	EventRubricUpdated,
+}
+
+// WebhookEndpoint is a URL a tenant has registered to receive events.
+// Deliveries are signed with Secret, which is only shown when the endpoint
+// is created.
+type WebhookEndpoint struct {
+	bun.BaseModel `bun:"table:webhook_endpoints,alias:we"`
+
+	ID          uuid.UUID  `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID    uuid.UUID  `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	URL         string     `bun:"url,notnull" json:"url"`
+	Description string     `bun:"description" json:"description,omitempty"`
+	Events      []string   `bun:"events,type:jsonb" json:"events"` // empty means all events
+	Secret      string     `bun:"secret,notnull" json:"-"`
+	CreatedBy   *uuid.UUID `bun:"created_by,type:uuid" json:"created_by,omitempty"`
+	CreatedAt   time.Time  `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// Subscribes reports whether the endpoint should receive an event type.
+func (e *WebhookEndpoint) Subscribes(eventType string) bool {
+	return len(e.Events) == 0 || slices.Contains(e.Events, eventType)
+}
+
+// WebhookEvent is an outbox entry: written with the change it describes
+// and marked dispatched once deliveries to the subscribed endpoints exist.
+type WebhookEvent struct {
+	bun.BaseModel `bun:"table:webhook_events,alias:wev"`
+
+	ID           uuid.UUID              `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID     uuid.UUID              `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	Type         string                 `bun:"type,notnull" json:"type"`
+	Data         map[string]interface{} `bun:"data,type:jsonb" json:"data"`
+	CreatedAt    time.Time              `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	DispatchedAt *time.Time             `bun:"dispatched_at" json:"-"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	DeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an event's delivery to one endpoint and the outcome of
+// its latest attempt. Pending deliveries are retried at NextAttemptAt.
+type WebhookDelivery struct {
+	bun.BaseModel `bun:"table:webhook_deliveries,alias:wd"`
+
+	ID             uuid.UUID             `bun:"id,pk,type:uuid,default:uuid_generate_v4()" json:"id"`
+	TenantID       uuid.UUID             `bun:"tenant_id,notnull,type:uuid" json:"tenant_id"`
+	EndpointID     uuid.UUID             `bun:"endpoint_id,notnull,type:uuid" json:"endpoint_id"`
+	EventID        uuid.UUID             `bun:"event_id,notnull,type:uuid" json:"event_id"`
+	EventType      string                `bun:"event_type,notnull" json:"event_type"`
+	Status         WebhookDeliveryStatus `bun:"status,notnull" json:"status"`
+	Attempts       int                   `bun:"attempts,notnull" json:"attempts"`
+	NextAttemptAt  *time.Time            `bun:"next_attempt_at" json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time            `bun:"last_attempt_at" json:"last_attempt_at,omitempty"`
+	ResponseStatus int                   `bun:"response_status,nullzero" json:"response_status,omitempty"`
+	LastError      string                `bun:"last_error,nullzero" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time            `bun:"delivered_at" json:"delivered_at,omitempty"`
+	CreatedAt      time.Time             `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
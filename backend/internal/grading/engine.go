@@ -163,13 +163,25 @@ func (e *Engine) calculateWeightedConsensus(evaluations []domain.GradingResult)
 }
 
 func (e *Engine) buildConsensus(multiEval *domain.MultiEvalResult) *domain.FinalGrade {
-	return &domain.FinalGrade{
+	grade := &domain.FinalGrade{
 		FinalScore: multiEval.ConsensusScore,
 		AIScore:    &multiEval.ConsensusScore,
 		Confidence: multiEval.Confidence,
 		Reasoning:  multiEval.Reasoning,
 		UpdatedAt:  utils.CurrentTime(),
 	}
+
+	// Keep the model settings each evaluator actually ran with
+	for _, eval := range multiEval.Evaluations {
+		if eval.Settings == nil {
+			continue
+		}
+		if grade.GenerationSettings == nil {
+			grade.GenerationSettings = map[string]domain.GenerationSettings{}
+		}
+		grade.GenerationSettings[eval.AIEvaluatorID] = *eval.Settings
+	}
+	return grade
 }
 
 type ConfidenceCalculator struct{}
@@ -0,0 +1,495 @@
+// Package lti implements the tool side of LTI 1.3: the OIDC launch from a
+// platform (an LMS) and publishing scores to its gradebook with Assignment
+// and Grade Services (AGS).
+package lti
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// Claims of a launch
+const (
+	ClaimMessageType  = "https://purl.imsglobal.org/spec/lti/claim/message_type"
+	ClaimVersion      = "https://purl.imsglobal.org/spec/lti/claim/version"
+	ClaimDeploymentID = "https://purl.imsglobal.org/spec/lti/claim/deployment_id"
+	ClaimResourceLink = "https://purl.imsglobal.org/spec/lti/claim/resource_link"
+	ClaimRoles        = "https://purl.imsglobal.org/spec/lti/claim/roles"
+	ClaimCustom       = "https://purl.imsglobal.org/spec/lti/claim/custom"
+	ClaimLIS          = "https://purl.imsglobal.org/spec/lti/claim/lis"
+	ClaimAGSEndpoint  = "https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"
+)
+
+// AGS scopes
+const (
+	ScopeLineItem = "https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"
+	ScopeScore    = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+)
+
+// Context roles that may bind an exam to a line item
+var instructorRoles = []string{
+	"http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor",
+	"http://purl.imsglobal.org/vocab/lis/v2/membership#Administrator",
+	"http://purl.imsglobal.org/vocab/lis/v2/membership#ContentDeveloper",
+}
+
+const (
+	// assertionLifetime is how long a client assertion is valid for
+	assertionLifetime = 5 * time.Minute
+	// tokenMargin is how long before its expiry a cached access token is
+	// replaced
+	tokenMargin = 30 * time.Second
+	// errorLimit bounds the response body kept from a failed request
+	errorLimit = 512
+)
+
+var ErrInvalidLaunch = errors.New("lti: invalid launch")
+
+// StatusError is a platform's non-2xx answer.
+type StatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("platform returned %d %s: %s", e.Status, http.StatusText(e.Status), e.Body)
+}
+
+// Tool is us as the platforms see us: the key our client assertions are
+// signed with and the URL they launch us at.
+type Tool struct {
+	url    string
+	key    *rsa.PrivateKey
+	kid    string
+	client *http.Client
+	now    func() time.Time
+
+	mu     sync.Mutex
+	keySet map[string]*auth.JWKS // platform key sets by URL
+	tokens map[string]accessToken
+}
+
+type accessToken struct {
+	value   string
+	expires time.Time
+}
+
+// NewTool makes a tool served at baseURL that signs with key; requests to
+// platforms give up after timeout.
+func NewTool(baseURL string, key *rsa.PrivateKey, timeout time.Duration) *Tool {
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	sum := sha256.Sum256(der)
+	return &Tool{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		key:    key,
+		kid:    base64.RawURLEncoding.EncodeToString(sum[:12]),
+		client: &http.Client{Timeout: timeout},
+		now:    time.Now,
+		keySet: make(map[string]*auth.JWKS),
+		tokens: make(map[string]accessToken),
+	}
+}
+
+// ParsePrivateKey reads a PEM RSA private key, PKCS #1 or PKCS #8.
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("lti tool key: no PEM block")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("lti tool key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("lti tool key: want an RSA key")
+	}
+	return key, nil
+}
+
+// ReadPrivateKey reads a PEM RSA private key from a file.
+func ReadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("lti tool key: %w", err)
+	}
+	return ParsePrivateKey(data)
+}
+
+// LaunchURL is where platforms post launches (the OIDC redirect URI).
+func (t *Tool) LaunchURL() string {
+	return t.url + "/lti/launch"
+}
+
+// KeySet is the JSON Web Key Set platforms verify our client assertions
+// with.
+func (t *Tool) KeySet() map[string]interface{} {
+	pub := t.key.PublicKey
+	return map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": t.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	}
+}
+
+// LoginParams are what a platform sends to start a launch (OIDC third
+// party initiated login).
+type LoginParams struct {
+	Issuer        string
+	ClientID      string
+	LoginHint     string
+	MessageHint   string
+	TargetLinkURI string
+}
+
+// AuthRedirect is the URL that sends the browser to the platform's OIDC
+// authorization endpoint to finish the login.
+func (t *Tool) AuthRedirect(p *domain.LTIPlatform, params LoginParams, state, nonce string) string {
+	q := url.Values{
+		"scope":         {"openid"},
+		"response_type": {"id_token"},
+		"response_mode": {"form_post"},
+		"prompt":        {"none"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {t.LaunchURL()},
+		"login_hint":    {params.LoginHint},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	if params.MessageHint != "" {
+		q.Set("lti_message_hint", params.MessageHint)
+	}
+	sep := "?"
+	if strings.Contains(p.AuthLoginURL, "?") {
+		sep = "&"
+	}
+	return p.AuthLoginURL + sep + q.Encode()
+}
+
+// Launch is what a verified resource link launch says.
+type Launch struct {
+	UserID         string
+	Roles          []string
+	DeploymentID   string
+	ResourceLinkID string
+	// Custom holds the custom parameters configured on the link
+	Custom map[string]string
+	// PersonSourcedID is the user's ID in the student information system
+	PersonSourcedID string
+	Email           string
+	// AGS endpoint: the link's own line item, if it has one, and the
+	// context's line item container
+	LineItemURL  string
+	LineItemsURL string
+	Scopes       []string
+}
+
+// Instructor reports whether the user may bind the link to an exam.
+func (l *Launch) Instructor() bool {
+	for _, role := range l.Roles {
+		if slices.Contains(instructorRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyLaunch checks an id_token posted by p against p's keys and the
+// nonce of the login it answers.
+func (t *Tool) VerifyLaunch(ctx context.Context, p *domain.LTIPlatform, idToken, nonce string) (*Launch, error) {
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{
+		JWKS:     t.platformKeys(p.JWKSURL),
+		Issuer:   p.Issuer,
+		Audience: p.ClientID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, err := verifier.Claims(ctx, idToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLaunch, err)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidLaunch)
+	}
+	if v, _ := claims[ClaimVersion].(string); v != "1.3.0" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrInvalidLaunch, v)
+	}
+	if m, _ := claims[ClaimMessageType].(string); m != "LtiResourceLinkRequest" {
+		return nil, fmt.Errorf("%w: unsupported message type %q", ErrInvalidLaunch, m)
+	}
+
+	l := &Launch{Custom: map[string]string{}}
+	l.UserID, _ = claims["sub"].(string)
+	l.Email, _ = claims["email"].(string)
+	l.DeploymentID, _ = claims[ClaimDeploymentID].(string)
+	if p.DeploymentID != "" && l.DeploymentID != p.DeploymentID {
+		return nil, fmt.Errorf("%w: unknown deployment %q", ErrInvalidLaunch, l.DeploymentID)
+	}
+	if link, ok := claims[ClaimResourceLink].(map[string]any); ok {
+		l.ResourceLinkID, _ = link["id"].(string)
+	}
+	if l.UserID == "" || l.ResourceLinkID == "" {
+		return nil, fmt.Errorf("%w: missing user or resource link", ErrInvalidLaunch)
+	}
+	l.Roles = stringList(claims[ClaimRoles])
+	if custom, ok := claims[ClaimCustom].(map[string]any); ok {
+		for k, v := range custom {
+			if s, ok := v.(string); ok {
+				l.Custom[k] = s
+			}
+		}
+	}
+	if lis, ok := claims[ClaimLIS].(map[string]any); ok {
+		l.PersonSourcedID, _ = lis["person_sourcedid"].(string)
+	}
+	if ags, ok := claims[ClaimAGSEndpoint].(map[string]any); ok {
+		l.LineItemURL, _ = ags["lineitem"].(string)
+		l.LineItemsURL, _ = ags["lineitems"].(string)
+		l.Scopes = stringList(ags["scope"])
+	}
+	return l, nil
+}
+
+// LineItem is a gradebook column.
+type LineItem struct {
+	ID             string  `json:"id,omitempty"`
+	Label          string  `json:"label"`
+	ScoreMaximum   float64 `json:"scoreMaximum"`
+	ResourceLinkID string  `json:"resourceLinkId,omitempty"`
+	Tag            string  `json:"tag,omitempty"`
+}
+
+// Score is a user's result for a line item. A platform keeps the score
+// with the latest Timestamp, so retried old scores don't win.
+type Score struct {
+	UserID           string    `json:"userId"`
+	ScoreGiven       float64   `json:"scoreGiven"`
+	ScoreMaximum     float64   `json:"scoreMaximum"`
+	ActivityProgress string    `json:"activityProgress"`
+	GradingProgress  string    `json:"gradingProgress"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+// CreateLineItem adds a line item to a context's container.
+func (t *Tool) CreateLineItem(ctx context.Context, p *domain.LTIPlatform, lineItemsURL string, item LineItem) (*LineItem, error) {
+	body, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.call(ctx, p, ScopeLineItem, lineItemsURL, "application/vnd.ims.lis.v2.lineitem+json", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	created := new(LineItem)
+	if err := json.NewDecoder(resp.Body).Decode(created); err != nil {
+		return nil, fmt.Errorf("lti: decode line item: %w", err)
+	}
+	if created.ID == "" {
+		return nil, fmt.Errorf("lti: platform returned a line item without an id")
+	}
+	return created, nil
+}
+
+// PublishScore posts a score to a line item. It returns the platform's
+// response status, 0 when there was none.
+func (t *Tool) PublishScore(ctx context.Context, p *domain.LTIPlatform, lineItemURL string, score Score) (int, error) {
+	u, err := url.Parse(lineItemURL)
+	if err != nil {
+		return 0, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/scores"
+	body, err := json.Marshal(score)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := t.call(ctx, p, ScopeScore, u.String(), "application/vnd.ims.lis.v1.score+json", body)
+	if err != nil {
+		var status *StatusError
+		if errors.As(err, &status) {
+			return status.Status, err
+		}
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// call posts body to a platform service with an access token for scope.
+// A rejected token is dropped so the next call gets a new one.
+func (t *Tool) call(ctx context.Context, p *domain.LTIPlatform, scope, target, contentType string, body []byte) (*http.Response, error) {
+	token, err := t.accessToken(ctx, p, scope)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			t.mu.Lock()
+			delete(t.tokens, p.ID.String()+" "+scope)
+			t.mu.Unlock()
+		}
+		return nil, statusError(resp)
+	}
+	return resp, nil
+}
+
+// accessToken returns a token for scope from p's token endpoint, which we
+// authenticate to with a signed client assertion (private_key_jwt).
+// Tokens are reused until shortly before they expire.
+func (t *Tool) accessToken(ctx context.Context, p *domain.LTIPlatform, scope string) (string, error) {
+	cacheKey := p.ID.String() + " " + scope
+	t.mu.Lock()
+	cached, ok := t.tokens[cacheKey]
+	t.mu.Unlock()
+	if ok && t.now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	assertion, err := t.sign(map[string]interface{}{
+		"iss": p.ClientID,
+		"sub": p.ClientID,
+		"aud": p.AuthTokenURL,
+		"iat": t.now().Unix(),
+		"exp": t.now().Add(assertionLifetime).Unix(),
+		"jti": uuid.NewString(),
+	})
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+		"scope":                 {scope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.AuthTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("lti: token request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("lti: token request: %w", statusError(resp))
+	}
+	var granted struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&granted); err != nil || granted.AccessToken == "" {
+		return "", fmt.Errorf("lti: token request: no access token in response")
+	}
+
+	t.mu.Lock()
+	t.tokens[cacheKey] = accessToken{
+		value:   granted.AccessToken,
+		expires: t.now().Add(time.Duration(granted.ExpiresIn)*time.Second - tokenMargin),
+	}
+	t.mu.Unlock()
+	return granted.AccessToken, nil
+}
+
+// sign makes an RS256 JWT of claims with the tool's key.
+func (t *Tool) sign(claims map[string]interface{}) (string, error) {
+	return SignJWT(t.key, t.kid, claims)
+}
+
+// SignJWT makes an RS256 JWT of claims signed by key under kid.
+func SignJWT(key *rsa.PrivateKey, kid string, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// platformKeys is the cached key set at url.
+func (t *Tool) platformKeys(url string) *auth.JWKS {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys, ok := t.keySet[url]
+	if !ok {
+		keys = auth.NewJWKS(url, time.Hour)
+		t.keySet[url] = keys
+	}
+	return keys
+}
+
+func statusError(resp *http.Response) error {
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, errorLimit))
+	return &StatusError{Status: resp.StatusCode, Body: string(bytes.TrimSpace(snippet))}
+}
+
+// stringList reads a claim that is a string or an array of strings.
+func stringList(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+// Package ltitest provides a fake LTI 1.3 platform for tests: it launches
+// the tool, issues access tokens to it and keeps the line items and scores
+// it is sent.
+package ltitest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/lti"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ClientID     = "harama-tool"
+	DeploymentID = "deployment-1"
+	platformKID  = "platform-key"
+)
+
+// Platform is an LMS running on a local HTTP server. Its gradebook starts
+// with one line item, at LineItemURL("1").
+type Platform struct {
+	*httptest.Server
+
+	key      *rsa.PrivateKey
+	toolKeys *auth.JWKS
+
+	mu        sync.Mutex
+	launch    map[string]any
+	lineItems map[string]lti.LineItem
+	scores    map[string][]lti.Score
+	tokens    map[string]string // access token to scope
+	failures  int
+}
+
+// NewPlatform starts a platform that trusts client assertions signed by
+// the keys published at toolJWKSURL. Close it when done.
+func NewPlatform(toolJWKSURL string) *Platform {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	p := &Platform{
+		key:       key,
+		toolKeys:  auth.NewJWKS(toolJWKSURL, time.Hour),
+		lineItems: map[string]lti.LineItem{"1": {Label: "Existing column", ScoreMaximum: 100}},
+		scores:    make(map[string][]lti.Score),
+		tokens:    make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jwks", p.serveKeys)
+	mux.HandleFunc("GET /auth", p.serveAuth)
+	mux.HandleFunc("POST /token", p.serveToken)
+	mux.HandleFunc("POST /lineitems", p.serveCreateLineItem)
+	mux.HandleFunc("POST /lineitems/{id}/scores", p.serveScore)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Registration is the platform as a tenant registers it.
+func (p *Platform) Registration(tenantID uuid.UUID) domain.LTIPlatform {
+	return domain.LTIPlatform{
+		ID:           uuid.New(),
+		TenantID:     tenantID,
+		Name:         "Fake LMS",
+		Issuer:       p.URL,
+		ClientID:     ClientID,
+		DeploymentID: DeploymentID,
+		AuthLoginURL: p.URL + "/auth",
+		AuthTokenURL: p.URL + "/token",
+		JWKSURL:      p.URL + "/jwks",
+	}
+}
+
+func (p *Platform) LineItemsURL() string {
+	return p.URL + "/lineitems"
+}
+
+func (p *Platform) LineItemURL(id string) string {
+	return p.URL + "/lineitems/" + id
+}
+
+// LaunchClaims are the claims of a resource link launch by user with
+// roles, with AGS access to the platform's gradebook.
+func (p *Platform) LaunchClaims(user, resourceLink string, roles ...string) map[string]any {
+	return map[string]any{
+		"sub":                 user,
+		lti.ClaimMessageType:  "LtiResourceLinkRequest",
+		lti.ClaimVersion:      "1.3.0",
+		lti.ClaimDeploymentID: DeploymentID,
+		lti.ClaimResourceLink: map[string]any{"id": resourceLink},
+		lti.ClaimRoles:        roles,
+		lti.ClaimAGSEndpoint: map[string]any{
+			"scope":     []string{lti.ScopeLineItem, lti.ScopeScore},
+			"lineitems": p.LineItemsURL(),
+		},
+	}
+}
+
+// SetLaunch sets the claims of the launches the platform makes next; the
+// login hint becomes the sub.
+func (p *Platform) SetLaunch(claims map[string]any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.launch = claims
+}
+
+// IDToken signs a launch id_token with the platform's key. Issuer,
+// audience, nonce and times are filled in.
+func (p *Platform) IDToken(nonce string, claims map[string]any) string {
+	all := map[string]any{
+		"iss":   p.URL,
+		"aud":   ClientID,
+		"nonce": nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+	token, err := lti.SignJWT(p.key, platformKID, all)
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
+
+var formField = regexp.MustCompile(`name="(\w+)" value="([^"]*)"`)
+
+// Authorize follows a tool's redirect to the platform's authorization
+// endpoint, as a browser would, and returns the form the platform posts
+// back to the tool, with the form's target.
+func (p *Platform) Authorize(ctx context.Context, redirect string) (string, url.Values, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, redirect, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("authorize: %s: %s", resp.Status, body)
+	}
+	form := url.Values{}
+	for _, m := range formField.FindAllStringSubmatch(string(body), -1) {
+		form.Set(m[1], html.UnescapeString(m[2]))
+	}
+	action := regexp.MustCompile(`action="([^"]*)"`).FindStringSubmatch(string(body))
+	if action == nil {
+		return "", nil, fmt.Errorf("authorize: no form in response")
+	}
+	return html.UnescapeString(action[1]), form, nil
+}
+
+// FailScores makes the next n score posts fail with 503.
+func (p *Platform) FailScores(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures = n
+}
+
+// Scores returns the scores a line item was sent, oldest first.
+func (p *Platform) Scores(lineItemID string) []lti.Score {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]lti.Score(nil), p.scores[lineItemID]...)
+}
+
+// LineItem returns a line item of the gradebook.
+func (p *Platform) LineItem(id string) (lti.LineItem, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	item, ok := p.lineItems[id]
+	return item, ok
+}
+
+func (p *Platform) serveKeys(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"kid": platformKID,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+// serveAuth answers an OIDC authorization request with the auto-submitting
+// form that posts the id_token to the tool.
+func (p *Platform) serveAuth(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("scope") != "openid", q.Get("response_type") != "id_token", q.Get("response_mode") != "form_post":
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	case q.Get("client_id") != ClientID:
+		http.Error(w, "unauthorized_client", http.StatusBadRequest)
+		return
+	case q.Get("nonce") == "" || q.Get("redirect_uri") == "":
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	claims := map[string]any{}
+	for k, v := range p.launch {
+		claims[k] = v
+	}
+	p.mu.Unlock()
+	claims["sub"] = q.Get("login_hint")
+	token := p.IDToken(q.Get("nonce"), claims)
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `<form method="post" action="%s">`+
+		`<input type="hidden" name="id_token" value="%s">`+
+		`<input type="hidden" name="state" value="%s">`+
+		`</form><script>document.forms[0].submit()</script>`,
+		html.EscapeString(q.Get("redirect_uri")), html.EscapeString(token), html.EscapeString(q.Get("state")))
+}
+
+// serveToken grants client_credentials to a tool that proves itself with a
+// client assertion signed by its key.
+func (p *Platform) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("grant_type") != "client_credentials" ||
+		r.PostForm.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+	verifier, _ := auth.NewVerifier(auth.VerifierConfig{JWKS: p.toolKeys, Issuer: ClientID, Audience: p.URL + "/token"})
+	claims, err := verifier.Claims(r.Context(), r.PostForm.Get("client_assertion"))
+	if err != nil || claims["sub"] != ClientID {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	token := uuid.NewString()
+	p.mu.Lock()
+	p.tokens[token] = r.PostForm.Get("scope")
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"access_token": token, "token_type": "Bearer", "expires_in": 3600})
+}
+
+// authorized reports whether r bears a token granted for scope.
+func (p *Platform) authorized(r *http.Request, scope string) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	granted, ok := p.tokens[token]
+	return ok && strings.Contains(granted, scope)
+}
+
+func (p *Platform) serveCreateLineItem(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r, lti.ScopeLineItem) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var item lti.LineItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil || item.Label == "" || item.ScoreMaximum <= 0 {
+		http.Error(w, "label and a positive scoreMaximum are required", http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	id := fmt.Sprint(len(p.lineItems) + 1)
+	item.ID = p.LineItemURL(id)
+	p.lineItems[id] = item
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/vnd.ims.lis.v2.lineitem+json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+func (p *Platform) serveScore(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r, lti.ScopeScore) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/vnd.ims.lis.v1.score+json" {
+		http.Error(w, "unsupported content type "+ct, http.StatusUnsupportedMediaType)
+		return
+	}
+	id := r.PathValue("id")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.lineItems[id]; !ok {
+		http.Error(w, "no such line item", http.StatusNotFound)
+		return
+	}
+	if p.failures > 0 {
+		p.failures--
+		http.Error(w, "gradebook unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var score lti.Score
+	if err := json.NewDecoder(r.Body).Decode(&score); err != nil || score.UserID == "" {
+		http.Error(w, "userId is required", http.StatusBadRequest)
+		return
+	}
+	p.scores[id] = append(p.scores[id], score)
+	w.WriteHeader(http.StatusOK)
+}
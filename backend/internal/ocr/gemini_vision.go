@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 
+	"harama/internal/ai"
+	"harama/internal/ai/gemini"
 	"harama/internal/domain"
 
 	"github.com/google/generative-ai-go/genai"
@@ -12,24 +14,29 @@ import (
 )
 
 type GeminiOCRProcessor struct {
-	client *genai.Client
-	model  *genai.GenerativeModel
+	client   *genai.Client
+	settings domain.GenerationSettings
 }
 
-func NewGeminiOCRProcessor(apiKey string) (*GeminiOCRProcessor, error) {
+// NewGeminiOCRProcessor transcribes with the registry's "ocr" settings; nil
+// models means the built-in defaults.
+func NewGeminiOCRProcessor(apiKey string, models *ai.ModelRegistry) (*GeminiOCRProcessor, error) {
+	if models == nil {
+		var err error
+		if models, err = ai.NewModelRegistry(nil); err != nil {
+			return nil, err
+		}
+	}
+
 	ctx := context.Background()
 	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
 	if err != nil {
 		return nil, err
 	}
-	
-	// Use Gemini 3 Flash Preview for faster/cheaper OCR
-	model := client.GenerativeModel("gemini-3-flash-preview") 
-	model.SetTemperature(0.1) // Low temperature for deterministic transcription
-	
+
 	return &GeminiOCRProcessor{
-		client: client,
-		model:  model,
+		client:   client,
+		settings: models.Resolve(ai.TaskOCR, ""),
 	}, nil
 }
 
@@ -49,10 +56,16 @@ func (p *GeminiOCRProcessor) ExtractText(ctx context.Context, fileBytes []byte,
 		Data:     fileBytes,
 	}
 
-	resp, err := p.model.GenerateContent(ctx, prompt, imgData)
+	model, err := gemini.NewModel(p.client, p.settings)
+	if err != nil {
+		return nil, fmt.Errorf("gemini ocr: %w", err)
+	}
+
+	resp, err := model.GenerateContent(ctx, prompt, imgData)
 	if err != nil {
-		return nil, fmt.Errorf("gemini ocr error: %w", err)
+		return nil, fmt.Errorf("gemini ocr: %w", gemini.ClassifyError(err))
 	}
+	gemini.ReportUsage(ctx, p.settings.Model, resp)
 
 	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
 		return nil, fmt.Errorf("empty response from Gemini OCR")
@@ -64,9 +77,11 @@ func (p *GeminiOCRProcessor) ExtractText(ctx context.Context, fileBytes []byte,
 		return nil, fmt.Errorf("unexpected response type from Gemini OCR")
 	}
 
+	settings := p.settings
 	return &domain.OCRResult{
 		RawText:    strings.TrimSpace(string(text)),
 		Confidence: 0.90, // Gemini doesn't give token-level confidence easily in standard response, defaulting
+		Settings:   &settings,
 	}, nil
 }
 
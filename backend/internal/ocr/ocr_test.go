@@ -11,7 +11,7 @@ func TestGeminiOCRWithAnswerJpeg(t *testing.T) {
 	if apiKey == "" {
 		t.Skip("GEMINI_API_KEY not set")
 	}
-	processor, err := NewGeminiOCRProcessor(apiKey)
+	processor, err := NewGeminiOCRProcessor(apiKey, nil)
 	if err != nil {
 		t.Fatalf("Failed to create processor: %v", err)
 	}
@@ -0,0 +1,260 @@
+// Package pdf writes simple PDF documents: pages of JPEG images, filled and
+// stroked rectangles, and text in the standard Helvetica fonts, so nothing
+// needs embedding. Coordinates are points from the top left of the page.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // DecodeConfig of embedded images
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+// Document is a PDF being built. Add images, then pages that draw them,
+// then Write it.
+type Document struct {
+	images []*Image
+	pages  []*Page
+}
+
+// New starts an empty document.
+func New() *Document {
+	return &Document{}
+}
+
+// Image is a JPEG embedded in a document; pages that draw it share one
+// copy.
+type Image struct {
+	name   string
+	data   []byte
+	width  int
+	height int
+	space  string
+	obj    int
+}
+
+// Size is the image's size in pixels.
+func (img *Image) Size() (width, height int) {
+	return img.width, img.height
+}
+
+// AddJPEG embeds a JPEG. It is stored as is, so only its header is
+// decoded.
+func (d *Document) AddJPEG(data []byte) (*Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("pdf: %w", err)
+	}
+	if format != "jpeg" {
+		return nil, fmt.Errorf("pdf: image is %s, not jpeg", format)
+	}
+	space := "DeviceRGB"
+	switch cfg.ColorModel {
+	case color.GrayModel:
+		space = "DeviceGray"
+	case color.CMYKModel:
+		space = "DeviceCMYK"
+	}
+	img := &Image{
+		name:   fmt.Sprintf("Im%d", len(d.images)+1),
+		data:   data,
+		width:  cfg.Width,
+		height: cfg.Height,
+		space:  space,
+	}
+	d.images = append(d.images, img)
+	return img, nil
+}
+
+// AddPage appends a page of the given size in points.
+func (d *Document) AddPage(width, height float64) *Page {
+	p := &Page{width: width, height: height, images: make(map[*Image]bool)}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Color is an RGB colour with components from 0 to 1.
+type Color struct{ R, G, B float64 }
+
+// Common colours
+var (
+	Black = Color{0, 0, 0}
+	White = Color{1, 1, 1}
+	Gray  = Color{0.4, 0.4, 0.4}
+)
+
+// RGB is the colour of 8 bit components.
+func RGB(r, g, b uint8) Color {
+	return Color{float64(r) / 255, float64(g) / 255, float64(b) / 255}
+}
+
+// Page is a page of a document; its methods add to what it draws.
+type Page struct {
+	width, height float64
+	content       bytes.Buffer
+	images        map[*Image]bool
+	order         []*Image
+}
+
+// Size is the page's size in points.
+func (p *Page) Size() (width, height float64) {
+	return p.width, p.height
+}
+
+// Image draws img into the box at x, y of size w by h.
+func (p *Page) Image(img *Image, x, y, w, h float64) {
+	if !p.images[img] {
+		p.images[img] = true
+		p.order = append(p.order, img)
+	}
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /%s Do Q\n", num(w), num(h), num(x), num(p.height-y-h), img.name)
+}
+
+// FillRect fills the box at x, y of size w by h.
+func (p *Page) FillRect(x, y, w, h float64, c Color) {
+	fmt.Fprintf(&p.content, "q %s rg %s %s %s %s re f Q\n", c.ops(), num(x), num(p.height-y-h), num(w), num(h))
+}
+
+// StrokeRect outlines the box at x, y of size w by h.
+func (p *Page) StrokeRect(x, y, w, h, lineWidth float64, c Color) {
+	fmt.Fprintf(&p.content, "q %s RG %s w %s %s %s %s re S Q\n", c.ops(), num(lineWidth), num(x), num(p.height-y-h), num(w), num(h))
+}
+
+// Text draws s with its baseline at y, starting at x. Characters
+// Helvetica's encoding lacks are drawn as "?".
+func (p *Page) Text(x, y float64, font Font, size float64, c Color, s string) {
+	fmt.Fprintf(&p.content, "BT /%s %s Tf %s rg %s %s Td (%s) Tj ET\n", font.resource(), num(size), c.ops(), num(x), num(p.height-y), escape(encode(s)))
+}
+
+func (c Color) ops() string {
+	return num(c.R) + " " + num(c.G) + " " + num(c.B)
+}
+
+func num(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Write writes the document to w.
+func (d *Document) Write(w io.Writer) error {
+	if len(d.pages) == 0 {
+		return fmt.Errorf("pdf: a document needs a page")
+	}
+	pw := &writer{w: w}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 and 2 are the catalog and page tree, 3 and 4 the fonts,
+	// then the images, then each page and its content.
+	const catalog, pages, regular, bold = 1, 2, 3, 4
+	next := 5
+	for _, img := range d.images {
+		img.obj = next
+		next++
+	}
+	pageObjs := make([]int, len(d.pages))
+	for i := range d.pages {
+		pageObjs[i] = next
+		next += 2
+	}
+
+	pw.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages))
+	kids := make([]string, len(pageObjs))
+	for i, obj := range pageObjs {
+		kids[i] = fmt.Sprintf("%d 0 R", obj)
+	}
+	pw.object(pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pageObjs)))
+	pw.object(regular, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	pw.object(bold, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for _, img := range d.images {
+		dict := fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /DCTDecode",
+			img.width, img.height, img.space)
+		if img.space == "DeviceCMYK" {
+			// JPEG encoders write Adobe CMYK inverted
+			dict += " /Decode [1 0 1 0 1 0 1 0]"
+		}
+		pw.stream(img.obj, dict, img.data)
+	}
+
+	for i, p := range d.pages {
+		var xobjects strings.Builder
+		for _, img := range p.order {
+			fmt.Fprintf(&xobjects, " /%s %d 0 R", img.name, img.obj)
+		}
+		pw.object(pageObjs[i], fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> /XObject <<%s >> >> /Contents %d 0 R >>",
+			pages, num(p.width), num(p.height), regular, bold, xobjects.String(), pageObjs[i]+1))
+
+		var content bytes.Buffer
+		zw := zlib.NewWriter(&content)
+		zw.Write(p.content.Bytes())
+		zw.Close()
+		pw.stream(pageObjs[i]+1, "<< /Filter /FlateDecode", content.Bytes())
+	}
+
+	xref := pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", next)
+	for obj := 1; obj < next; obj++ {
+		pw.printf("%010d 00000 n \n", pw.offsets[obj])
+	}
+	pw.printf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", next, catalog, xref)
+	return pw.err
+}
+
+// writer writes objects, noting their offsets for the cross-reference
+// table, and keeps the first error.
+type writer struct {
+	w       io.Writer
+	n       int
+	offsets map[int]int
+	err     error
+}
+
+func (pw *writer) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(pw.w, format, args...)
+	pw.n += n
+	pw.err = err
+}
+
+func (pw *writer) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(b)
+	pw.n += n
+	pw.err = err
+}
+
+func (pw *writer) object(obj int, body string) {
+	pw.begin(obj)
+	pw.printf("%s\nendobj\n", body)
+}
+
+// stream writes a stream object; dict is its dictionary without the
+// closing ">>", to which Length is added.
+func (pw *writer) stream(obj int, dict string, data []byte) {
+	pw.begin(obj)
+	pw.printf("%s /Length %d >>\nstream\n", dict, len(data))
+	pw.write(data)
+	pw.printf("\nendstream\nendobj\n")
+}
+
+func (pw *writer) begin(obj int) {
+	if pw.offsets == nil {
+		pw.offsets = make(map[int]int)
+	}
+	pw.offsets[obj] = pw.n
+	pw.printf("%d 0 obj\n", obj)
+}
@@ -0,0 +1,232 @@
+// Package xlsx writes minimal Office Open XML workbooks: sheets of text,
+// numbers and booleans whose first row is a bold, frozen header.
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of a workbook.
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+const (
+	// maxSheetName is the longest sheet name spreadsheet apps accept
+	maxSheetName = 31
+	// maxCellText is the most characters a cell holds
+	maxCellText = 32767
+)
+
+// Sheet is a named grid of cells. The first row is the header. A cell is a
+// string, a number, a bool, a time or nil (empty); anything else is written
+// as its fmt.Sprint text.
+type Sheet struct {
+	Name string
+	Rows [][]interface{}
+}
+
+// Write writes a workbook of sheets to w. Sheet names are made valid and
+// unique: characters spreadsheet apps reject are replaced and long names
+// cut.
+func Write(w io.Writer, sheets []Sheet) error {
+	if len(sheets) == 0 {
+		return fmt.Errorf("xlsx: a workbook needs a sheet")
+	}
+	names := sheetNames(sheets)
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		body func(io.Writer) error
+	}{
+		{"[Content_Types].xml", func(w io.Writer) error { return contentTypes(w, len(sheets)) }},
+		{"_rels/.rels", rootRels},
+		{"xl/workbook.xml", func(w io.Writer) error { return workbook(w, names) }},
+		{"xl/_rels/workbook.xml.rels", func(w io.Writer) error { return workbookRels(w, len(sheets)) }},
+		{"xl/styles.xml", styles},
+	}
+	for i := range sheets {
+		sheet := sheets[i]
+		files = append(files, struct {
+			name string
+			body func(io.Writer) error
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), func(w io.Writer) error { return worksheet(w, sheet.Rows) }})
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if err := f.body(fw); err != nil {
+			return fmt.Errorf("xlsx: %s: %w", f.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+// sheetNames returns valid, distinct names for sheets, in order.
+func sheetNames(sheets []Sheet) []string {
+	names := make([]string, len(sheets))
+	taken := make(map[string]bool, len(sheets))
+	for i, s := range sheets {
+		base := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`[]:*?/\`, r) {
+				return '_'
+			}
+			return r
+		}, strings.Trim(s.Name, "'"))
+		if base == "" {
+			base = fmt.Sprintf("Sheet%d", i+1)
+		}
+		name := truncate(base, maxSheetName)
+		for n := 2; taken[strings.ToLower(name)]; n++ {
+			suffix := fmt.Sprintf(" (%d)", n)
+			name = truncate(base, maxSheetName-len(suffix)) + suffix
+		}
+		taken[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+func contentTypes(w io.Writer, sheets int) error {
+	io.WriteString(w, xml.Header)
+	io.WriteString(w, `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`+
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(w, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	_, err := io.WriteString(w, `</Types>`)
+	return err
+}
+
+func rootRels(w io.Writer) error {
+	_, err := io.WriteString(w, xml.Header+`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>`+
+		`</Relationships>`)
+	return err
+}
+
+func workbook(w io.Writer, names []string) error {
+	io.WriteString(w, xml.Header)
+	io.WriteString(w, `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, name := range names {
+		io.WriteString(w, `<sheet name="`)
+		xml.EscapeText(w, []byte(name))
+		fmt.Fprintf(w, `" sheetId="%d" r:id="rId%d"/>`, i+1, i+1)
+	}
+	_, err := io.WriteString(w, `</sheets></workbook>`)
+	return err
+}
+
+func workbookRels(w io.Writer, sheets int) error {
+	io.WriteString(w, xml.Header)
+	io.WriteString(w, `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(w, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	fmt.Fprintf(w, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, sheets+1)
+	_, err := io.WriteString(w, `</Relationships>`)
+	return err
+}
+
+// styles defines style 0, the default, and style 1, bold for headers.
+func styles(w io.Writer) error {
+	_, err := io.WriteString(w, xml.Header+`<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`+
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>`+
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>`+
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>`+
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>`+
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>`+
+		`</styleSheet>`)
+	return err
+}
+
+func worksheet(w io.Writer, rows [][]interface{}) error {
+	io.WriteString(w, xml.Header)
+	io.WriteString(w, `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if len(rows) > 0 {
+		io.WriteString(w, `<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	}
+	io.WriteString(w, `<sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(w, `<row r="%d">`, i+1)
+		style := ""
+		if i == 0 {
+			style = ` s="1"`
+		}
+		for j, v := range row {
+			if err := cell(w, CellRef(j, i), style, v); err != nil {
+				return err
+			}
+		}
+		io.WriteString(w, `</row>`)
+	}
+	_, err := io.WriteString(w, `</sheetData></worksheet>`)
+	return err
+}
+
+func cell(w io.Writer, ref, style string, v interface{}) error {
+	var num string
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case bool:
+		b := "0"
+		if v {
+			b = "1"
+		}
+		_, err := fmt.Fprintf(w, `<c r="%s"%s t="b"><v>%s</v></c>`, ref, style, b)
+		return err
+	case int:
+		num = strconv.Itoa(v)
+	case int64:
+		num = strconv.FormatInt(v, 10)
+	case float64:
+		num = strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		num = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case string:
+		return inlineString(w, ref, style, v)
+	case time.Time:
+		return inlineString(w, ref, style, v.UTC().Format(time.RFC3339))
+	default:
+		return inlineString(w, ref, style, fmt.Sprint(v))
+	}
+	_, err := fmt.Fprintf(w, `<c r="%s"%s><v>%s</v></c>`, ref, style, num)
+	return err
+}
+
+func inlineString(w io.Writer, ref, style, s string) error {
+	fmt.Fprintf(w, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+	if err := xml.EscapeText(w, []byte(truncate(s, maxCellText))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, `</t></is></c>`)
+	return err
+}
+
+// CellRef is the A1 reference of the cell at zero-based col and row.
+func CellRef(col, row int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name + strconv.Itoa(row+1)
+}
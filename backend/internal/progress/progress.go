@@ -0,0 +1,216 @@
+// Package progress streams the stages a submission goes through while it is
+// processed, so clients can follow it instead of polling.
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"harama/internal/domain"
+	"harama/internal/pkg/utils"
+
+	"github.com/google/uuid"
+)
+
+type Stage string
+
+const (
+	StageUploaded Stage = "uploaded"
+	// StageOCR is reported as each page is read; Step of Total pages
+	StageOCR       Stage = "ocr"
+	StageSegmented Stage = "segmented"
+	// StageGrading is reported as each question is started; Step of Total
+	StageGrading   Stage = "grading"
+	StageEscalated Stage = "escalated"
+	StagePaused    Stage = "paused"
+	StageCompleted Stage = "completed"
+	StageFailed    Stage = "failed"
+)
+
+// errorLimit bounds the error text carried by an event; NOTIFY payloads
+// must stay under 8000 bytes.
+const errorLimit = 1000
+
+// subscriberBuffer is how many events a subscriber may fall behind by
+// before further events to it are dropped.
+const subscriberBuffer = 64
+
+// Event is one stage transition of a submission.
+type Event struct {
+	TenantID     uuid.UUID  `json:"tenant_id"`
+	SubmissionID uuid.UUID  `json:"submission_id"`
+	ExamID       uuid.UUID  `json:"exam_id"`
+	BatchID      *uuid.UUID `json:"batch_id,omitempty"`
+	Stage        Stage      `json:"stage"`
+	Step         int        `json:"step,omitempty"`
+	Total        int        `json:"total,omitempty"`
+	QuestionID   *uuid.UUID `json:"question_id,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	At           time.Time  `json:"at"`
+}
+
+// NewEvent starts an event for a stage of sub.
+func NewEvent(sub *domain.Submission, stage Stage) Event {
+	return Event{
+		TenantID:     sub.TenantID,
+		SubmissionID: sub.ID,
+		ExamID:       sub.ExamID,
+		Stage:        stage,
+	}
+}
+
+type batchKey struct{}
+
+// WithBatch marks the work done in ctx as part of a batch, so the events it
+// publishes reach the batch's subscribers.
+func WithBatch(ctx context.Context, batchID uuid.UUID) context.Context {
+	return context.WithValue(ctx, batchKey{}, batchID)
+}
+
+func BatchFrom(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(batchKey{}).(uuid.UUID)
+	return id, ok
+}
+
+// Relay carries events between processes. Everything sent is received by
+// every listening process, the sender included.
+type Relay interface {
+	Send(ctx context.Context, payload []byte) error
+	Listen(ctx context.Context, receive func(payload []byte)) error
+}
+
+// Bus fans events out to the subscribers in this process. With a relay,
+// published events go through it, so subscribers see events published by
+// any process. A nil Bus drops what is published to it.
+type Bus struct {
+	relay Relay
+
+	mu   sync.RWMutex
+	subs map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	match func(Event) bool
+	ch    chan Event
+}
+
+// NewBus makes a bus; relay may be nil to keep events in this process.
+func NewBus(relay Relay) *Bus {
+	return &Bus{relay: relay, subs: make(map[*subscriber]struct{})}
+}
+
+// Run delivers the events the relay receives until ctx is done, listening
+// again whenever the relay drops.
+func (b *Bus) Run(ctx context.Context) {
+	if b.relay == nil {
+		return
+	}
+	for {
+		err := b.relay.Listen(ctx, func(payload []byte) {
+			var e Event
+			if err := json.Unmarshal(payload, &e); err != nil {
+				log.Printf("progress: dropping malformed event: %v", err)
+				return
+			}
+			b.deliver(e)
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+			log.Printf("progress: relay stopped, listening again: %v", err)
+		}
+	}
+}
+
+// Publish reports an event. The batch in ctx, if any, and the time are
+// filled in; a relay that can't send falls back to this process alone.
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	if b == nil {
+		return
+	}
+	if id, ok := BatchFrom(ctx); ok && e.BatchID == nil {
+		e.BatchID = &id
+	}
+	if e.At.IsZero() {
+		e.At = utils.CurrentTime()
+	}
+	if len(e.Error) > errorLimit {
+		// Cut on a rune boundary so the text stays valid UTF-8
+		cut := errorLimit
+		for cut > 0 && !utf8.RuneStart(e.Error[cut]) {
+			cut--
+		}
+		e.Error = e.Error[:cut]
+	}
+
+	if b.relay != nil {
+		payload, err := json.Marshal(e)
+		if err == nil {
+			err = b.relay.Send(ctx, payload)
+		}
+		if err == nil {
+			return
+		}
+		log.Printf("progress: relay failed, delivering locally: %v", err)
+	}
+	b.deliver(e)
+}
+
+// Subscribe returns the events match accepts from now on, until cancel is
+// called. A subscriber that falls behind misses events rather than holding
+// up the publisher.
+func (b *Bus) Subscribe(match func(Event) bool) (<-chan Event, func()) {
+	s := &subscriber{match: match, ch: make(chan Event, subscriberBuffer)}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, s)
+			b.mu.Unlock()
+		})
+	}
+}
+
+func (b *Bus) deliver(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if !s.match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+}
+
+// ForSubmission matches the events of one submission of a tenant.
+func ForSubmission(tenantID, submissionID uuid.UUID) func(Event) bool {
+	return func(e Event) bool {
+		return e.TenantID == tenantID && e.SubmissionID == submissionID
+	}
+}
+
+// ForExam matches the events of every submission of an exam of a tenant.
+func ForExam(tenantID, examID uuid.UUID) func(Event) bool {
+	return func(e Event) bool {
+		return e.TenantID == tenantID && e.ExamID == examID
+	}
+}
+
+// ForBatch matches the events of the work queued as one batch of a tenant.
+func ForBatch(tenantID, batchID uuid.UUID) func(Event) bool {
+	return func(e Event) bool {
+		return e.TenantID == tenantID && e.BatchID != nil && *e.BatchID == batchID
+	}
+}
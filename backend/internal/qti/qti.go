@@ -0,0 +1,352 @@
+// Package qti reads and writes exams as IMS Question and Test
+// Interoperability (QTI) 2.1, the format item banks exchange questions in.
+//
+// An exam is written as a content package: a ZIP of an imsmanifest.xml, an
+// assessmentTest whose sections are the exam's question groups, and an
+// assessmentItem per question. A multiple choice question is a
+// choiceInteraction whose correct response is its answer key, a short answer
+// a textEntryInteraction, an essay an extendedTextInteraction and a diagram
+// an uploadInteraction. A rubric's criteria and grading notes are a
+// rubricBlock for scorers; QTI has no place for the rest of a rubric, which
+// only the native exam document carries.
+package qti
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"harama/internal/domain"
+)
+
+// ContentType is the media type of a content package.
+const ContentType = "application/zip"
+
+// Resource types of a content package
+const (
+	ItemResource = "imsqti_item_xmlv2p1"
+	TestResource = "imsqti_test_xmlv2p1"
+)
+
+const (
+	qtiNamespace     = "http://www.imsglobal.org/xsd/imsqti_v2p1"
+	packageNamespace = "http://www.imsglobal.org/xsd/imscp_v1p1"
+	matchCorrect     = "http://www.imsglobal.org/question/qti_v2p1/rptemplates/match_correct"
+
+	manifestFile = "imsmanifest.xml"
+	testFile     = "test.xml"
+	responseID   = "RESPONSE"
+)
+
+type manifest struct {
+	XMLName    xml.Name         `xml:"manifest"`
+	NS         string           `xml:"xmlns,attr,omitempty"`
+	Identifier string           `xml:"identifier,attr"`
+	Metadata   manifestMetadata `xml:"metadata"`
+	// Packages of QTI have no organizations, but the element is required
+	Organizations struct{}   `xml:"organizations"`
+	Resources     []resource `xml:"resources>resource"`
+}
+
+type manifestMetadata struct {
+	Schema        string `xml:"schema"`
+	SchemaVersion string `xml:"schemaversion"`
+}
+
+type resource struct {
+	Identifier   string       `xml:"identifier,attr"`
+	Type         string       `xml:"type,attr"`
+	Href         string       `xml:"href,attr"`
+	Files        []file       `xml:"file"`
+	Dependencies []dependency `xml:"dependency"`
+}
+
+type file struct {
+	Href string `xml:"href,attr"`
+}
+
+type dependency struct {
+	IdentifierRef string `xml:"identifierref,attr"`
+}
+
+type assessmentTest struct {
+	XMLName    xml.Name   `xml:"assessmentTest"`
+	NS         string     `xml:"xmlns,attr"`
+	Identifier string     `xml:"identifier,attr"`
+	Title      string     `xml:"title,attr"`
+	Parts      []testPart `xml:"testPart"`
+}
+
+type testPart struct {
+	Identifier     string    `xml:"identifier,attr"`
+	NavigationMode string    `xml:"navigationMode,attr"`
+	SubmissionMode string    `xml:"submissionMode,attr"`
+	Sections       []section `xml:"assessmentSection"`
+}
+
+type section struct {
+	Identifier string    `xml:"identifier,attr"`
+	Title      string    `xml:"title,attr"`
+	Visible    bool      `xml:"visible,attr"`
+	Items      []itemRef `xml:"assessmentItemRef"`
+
+	group string
+}
+
+type itemRef struct {
+	Identifier string `xml:"identifier,attr"`
+	Href       string `xml:"href,attr"`
+}
+
+type assessmentItem struct {
+	XMLName       xml.Name              `xml:"assessmentItem"`
+	NS            string                `xml:"xmlns,attr,omitempty"`
+	Identifier    string                `xml:"identifier,attr"`
+	Title         string                `xml:"title,attr"`
+	Label         string                `xml:"label,attr,omitempty"`
+	Adaptive      bool                  `xml:"adaptive,attr"`
+	TimeDependent bool                  `xml:"timeDependent,attr"`
+	Responses     []responseDeclaration `xml:"responseDeclaration"`
+	Outcomes      []outcomeDeclaration  `xml:"outcomeDeclaration"`
+	Body          innerXML              `xml:"itemBody"`
+	Processing    *responseProcessing   `xml:"responseProcessing"`
+}
+
+type responseDeclaration struct {
+	Identifier  string  `xml:"identifier,attr"`
+	Cardinality string  `xml:"cardinality,attr"`
+	BaseType    string  `xml:"baseType,attr"`
+	Correct     *values `xml:"correctResponse"`
+}
+
+type outcomeDeclaration struct {
+	Identifier    string  `xml:"identifier,attr"`
+	Cardinality   string  `xml:"cardinality,attr"`
+	BaseType      string  `xml:"baseType,attr"`
+	NormalMaximum string  `xml:"normalMaximum,attr,omitempty"`
+	Default       *values `xml:"defaultValue"`
+}
+
+type values struct {
+	Values []string `xml:"value"`
+}
+
+type responseProcessing struct {
+	Template string `xml:"template,attr"`
+}
+
+type innerXML struct {
+	XML string `xml:",innerxml"`
+}
+
+// Write writes doc as a content package.
+func Write(w io.Writer, doc *domain.ExamDocument) error {
+	zw := zip.NewWriter(w)
+	ids := itemIdentifiers(doc.Questions)
+
+	man := manifest{
+		NS:         packageNamespace,
+		Identifier: "MANIFEST",
+		Metadata:   manifestMetadata{Schema: "QTIv2.1 Package", SchemaVersion: "1.0.0"},
+	}
+	test := assessmentTest{NS: qtiNamespace, Identifier: "TEST", Title: doc.Title}
+	testRes := resource{Identifier: "TEST", Type: TestResource, Href: testFile, Files: []file{{Href: testFile}}}
+	part := testPart{Identifier: "PART", NavigationMode: "nonlinear", SubmissionMode: "simultaneous"}
+	var items []resource
+
+	for i, q := range doc.Questions {
+		href := "items/" + ids[i] + ".xml"
+		if err := writeXML(zw, href, newItem(ids[i], i, q)); err != nil {
+			return err
+		}
+		items = append(items, resource{Identifier: ids[i], Type: ItemResource, Href: href, Files: []file{{Href: href}}})
+		testRes.Dependencies = append(testRes.Dependencies, dependency{IdentifierRef: ids[i]})
+
+		// A section per run of questions in the same group; ungrouped
+		// questions are in an invisible one
+		if n := len(part.Sections); n == 0 || part.Sections[n-1].group != q.Group {
+			s := section{Identifier: "S" + strconv.Itoa(n+1), Title: q.Group, Visible: q.Group != "", group: q.Group}
+			if s.Title == "" {
+				s.Title = "Questions"
+			}
+			part.Sections = append(part.Sections, s)
+		}
+		s := &part.Sections[len(part.Sections)-1]
+		s.Items = append(s.Items, itemRef{Identifier: ids[i], Href: href})
+	}
+	test.Parts = []testPart{part}
+
+	if err := writeXML(zw, testFile, test); err != nil {
+		return err
+	}
+	man.Resources = append([]resource{testRes}, items...)
+	if err := writeXML(zw, manifestFile, man); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeXML(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return enc.Close()
+}
+
+// itemIdentifiers names each question's item after its number, Q1, Q2b,
+// ..., or its position when it has none or shares it.
+func itemIdentifiers(questions []domain.QuestionDocument) []string {
+	ids := make([]string, len(questions))
+	seen := map[string]bool{}
+	for i, q := range questions {
+		id := "Q" + strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+				return r
+			}
+			return -1
+		}, q.Number)
+		if id == "Q" || seen[id] {
+			id = "Q" + strconv.Itoa(i+1)
+			for seen[id] {
+				id += "_"
+			}
+		}
+		seen[id] = true
+		ids[i] = id
+	}
+	return ids
+}
+
+// newItem is the assessmentItem of the i'th question.
+func newItem(id string, i int, q domain.QuestionDocument) assessmentItem {
+	title := "Question " + q.Number
+	if q.Number == "" {
+		title = "Question " + strconv.Itoa(i+1)
+	}
+	points := strconv.Itoa(q.Points)
+	item := assessmentItem{
+		NS:         qtiNamespace,
+		Identifier: id,
+		Title:      title,
+		Label:      q.Number,
+		Outcomes: []outcomeDeclaration{
+			{Identifier: "SCORE", Cardinality: "single", BaseType: "float", NormalMaximum: points},
+			{Identifier: "MAXSCORE", Cardinality: "single", BaseType: "float", Default: &values{Values: []string{points}}},
+		},
+		Body: innerXML{XML: itemBody(q)},
+	}
+
+	response := responseDeclaration{Identifier: responseID, Cardinality: "single", BaseType: "string"}
+	if q.AnswerType == domain.AnswerTypeMCQ {
+		response.BaseType = "identifier"
+		key := domain.SplitAnswerKey(q.AnswerKey)
+		if len(key) > 1 {
+			response.Cardinality = "multiple"
+		}
+		if len(key) > 0 {
+			response.Correct = &values{Values: key}
+		}
+	} else if q.AnswerKey != "" {
+		response.Correct = &values{Values: []string{q.AnswerKey}}
+	}
+	if q.AnswerType == domain.AnswerTypeDiagram {
+		response.BaseType = "file"
+		response.Correct = nil
+	}
+	item.Responses = []responseDeclaration{response}
+
+	// Answers with a key are scored by matching it; the rest by a scorer
+	if response.Correct != nil && (q.AnswerType == domain.AnswerTypeMCQ || q.AnswerType == domain.AnswerTypeShortAnswer) {
+		item.Processing = &responseProcessing{Template: matchCorrect}
+	}
+	return item
+}
+
+// itemBody is the markup of a question: its rubric for scorers, its text,
+// visual aids and interaction.
+func itemBody(q domain.QuestionDocument) string {
+	var b strings.Builder
+	if r := q.Rubric; r != nil && (len(r.Criteria) > 0 || r.GradingNotes != "") {
+		b.WriteString(`<rubricBlock view="scorer">`)
+		writeParagraphs(&b, r.GradingNotes)
+		if len(r.Criteria) > 0 {
+			b.WriteString("<ul>")
+			for _, c := range r.Criteria {
+				b.WriteString("<li>")
+				xml.EscapeText(&b, []byte(c.Description+" ("+pointsText(c.Points)+")"))
+				b.WriteString("</li>")
+			}
+			b.WriteString("</ul>")
+		}
+		b.WriteString("</rubricBlock>")
+	}
+	writeParagraphs(&b, q.Text)
+	for _, src := range q.VisualAids {
+		b.WriteString(`<p><img src="`)
+		xml.EscapeText(&b, []byte(src))
+		b.WriteString(`" alt=""/></p>`)
+	}
+
+	switch q.AnswerType {
+	case domain.AnswerTypeMCQ:
+		maxChoices := 1
+		if len(domain.SplitAnswerKey(q.AnswerKey)) > 1 {
+			maxChoices = 0
+		}
+		fmt.Fprintf(&b, `<choiceInteraction responseIdentifier="%s" shuffle="false" maxChoices="%d">`, responseID, maxChoices)
+		for _, c := range q.Choices {
+			b.WriteString(`<simpleChoice identifier="`)
+			xml.EscapeText(&b, []byte(c.ID))
+			b.WriteString(`">`)
+			xml.EscapeText(&b, []byte(c.Text))
+			b.WriteString("</simpleChoice>")
+		}
+		b.WriteString("</choiceInteraction>")
+	case domain.AnswerTypeShortAnswer:
+		fmt.Fprintf(&b, `<p><textEntryInteraction responseIdentifier="%s"/></p>`, responseID)
+	case domain.AnswerTypeDiagram:
+		fmt.Fprintf(&b, `<uploadInteraction responseIdentifier="%s"/>`, responseID)
+	default:
+		fmt.Fprintf(&b, `<extendedTextInteraction responseIdentifier="%s"/>`, responseID)
+	}
+	return b.String()
+}
+
+// writeParagraphs writes text as paragraphs, one per run of lines separated
+// by a blank line.
+func writeParagraphs(b *strings.Builder, text string) {
+	for _, p := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		for i, line := range strings.Split(p, "\n") {
+			if i > 0 {
+				b.WriteString("<br/>")
+			}
+			xml.EscapeText(b, []byte(line))
+		}
+		b.WriteString("</p>")
+	}
+}
+
+func pointsText(points float64) string {
+	s := strconv.FormatFloat(points, 'f', -1, 64)
+	if s == "1" {
+		return s + " point"
+	}
+	return s + " points"
+}
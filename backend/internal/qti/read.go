@@ -0,0 +1,482 @@
+package qti
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"harama/internal/domain"
+)
+
+// ErrNotQTI is returned for data that is neither a content package nor an
+// assessmentItem.
+var ErrNotQTI = errors.New("not a QTI 2.1 content package or item")
+
+// Read reads an exam from a content package, or a question from a lone
+// assessmentItem. Items that can't be read are reported with the path of
+// their file, and left out; only data that isn't QTI at all is an error.
+func Read(data []byte) (*domain.ExamDocument, []domain.FieldError, error) {
+	doc := &domain.ExamDocument{Format: domain.ExamDocumentFormat, Version: domain.ExamDocumentVersion}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		problems, err := readPackage(doc, data)
+		return doc, problems, err
+	}
+
+	var root struct {
+		XMLName xml.Name
+		Title   string `xml:"title,attr"`
+	}
+	if err := xml.Unmarshal(data, &root); err != nil || root.XMLName.Local != "assessmentItem" {
+		return nil, nil, ErrNotQTI
+	}
+	doc.Title = root.Title
+	q, err := readItem(data)
+	if err != nil {
+		return doc, []domain.FieldError{{Path: "assessmentItem", Message: err.Error()}}, nil
+	}
+	doc.Questions = []domain.QuestionDocument{q}
+	return doc, nil, nil
+}
+
+// testNode is an element of an assessmentTest, read in document order.
+type testNode struct {
+	XMLName  xml.Name
+	Title    string     `xml:"title,attr"`
+	Visible  string     `xml:"visible,attr"`
+	Href     string     `xml:"href,attr"`
+	Children []testNode `xml:",any"`
+}
+
+// packageItem is an item of a package and the group it is in.
+type packageItem struct {
+	href  string
+	group string
+}
+
+func readPackage(doc *domain.ExamDocument, data []byte) ([]domain.FieldError, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotQTI, err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	raw, err := readFile(files, manifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotQTI, err)
+	}
+	var man manifest
+	if err := xml.Unmarshal(raw, &man); err != nil {
+		return nil, fmt.Errorf("%w: invalid %s: %v", ErrNotQTI, manifestFile, err)
+	}
+
+	// The test orders and groups the items; without one, the manifest
+	// lists them
+	var items []packageItem
+	for _, res := range man.Resources {
+		if !strings.HasPrefix(res.Type, TestResource) {
+			continue
+		}
+		raw, err := readFile(files, res.Href)
+		if err != nil {
+			return []domain.FieldError{{Path: res.Href, Message: err.Error()}}, nil
+		}
+		var test testNode
+		if err := xml.Unmarshal(raw, &test); err != nil {
+			return []domain.FieldError{{Path: res.Href, Message: "invalid assessmentTest: " + err.Error()}}, nil
+		}
+		doc.Title = test.Title
+		items = testItems(test, path.Dir(res.Href), "")
+		break
+	}
+	if items == nil {
+		for _, res := range man.Resources {
+			if strings.HasPrefix(res.Type, ItemResource) {
+				items = append(items, packageItem{href: res.Href})
+			}
+		}
+	}
+
+	var problems []domain.FieldError
+	for _, it := range items {
+		raw, err := readFile(files, it.href)
+		if err != nil {
+			problems = append(problems, domain.FieldError{Path: it.href, Message: err.Error()})
+			continue
+		}
+		q, err := readItem(raw)
+		if err != nil {
+			problems = append(problems, domain.FieldError{Path: it.href, Message: err.Error()})
+			continue
+		}
+		q.Group = it.group
+		doc.Questions = append(doc.Questions, q)
+	}
+	return problems, nil
+}
+
+// testItems lists the items a test refers to, in order, each in the group
+// of the innermost visible section it is in.
+func testItems(n testNode, dir, group string) []packageItem {
+	var items []packageItem
+	for _, c := range n.Children {
+		switch c.XMLName.Local {
+		case "assessmentItemRef":
+			items = append(items, packageItem{href: path.Join(dir, c.Href), group: group})
+		case "assessmentSection":
+			g := group
+			if c.Visible != "false" && c.Title != "" {
+				g = c.Title
+			}
+			items = append(items, testItems(c, dir, g)...)
+		default:
+			items = append(items, testItems(c, dir, group)...)
+		}
+	}
+	if items == nil {
+		items = []packageItem{}
+	}
+	return items
+}
+
+func readFile(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing from the package", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// readItem reads the question of an assessmentItem, which must have exactly
+// one interaction of a kind there is an answer type for.
+func readItem(data []byte) (domain.QuestionDocument, error) {
+	var item assessmentItem
+	if err := xml.Unmarshal(data, &item); err != nil {
+		return domain.QuestionDocument{}, fmt.Errorf("invalid assessmentItem: %v", err)
+	}
+	b, err := readBody(item.Body.XML)
+	if err != nil {
+		return domain.QuestionDocument{}, fmt.Errorf("invalid itemBody: %v", err)
+	}
+	if len(b.unsupported) > 0 {
+		return domain.QuestionDocument{}, fmt.Errorf("%s is not supported", b.unsupported[0])
+	}
+	switch b.interactions {
+	case 0:
+		return domain.QuestionDocument{}, errors.New("item has no interaction")
+	case 1:
+	default:
+		return domain.QuestionDocument{}, fmt.Errorf("item has %d interactions; only items with one can be imported", b.interactions)
+	}
+
+	q := domain.QuestionDocument{
+		Number:     item.Label,
+		Text:       b.text.String(),
+		AnswerType: b.answerType,
+		Choices:    b.choices,
+		VisualAids: b.visualAids,
+		Rubric:     b.rubric,
+		Points:     itemPoints(item.Outcomes),
+	}
+	if q.Text == "" {
+		q.Text = item.Title
+	}
+	for _, r := range item.Responses {
+		if r.Correct == nil || (r.Identifier != b.responseID && len(item.Responses) > 1) {
+			continue
+		}
+		if q.AnswerType == domain.AnswerTypeMCQ {
+			q.AnswerKey = strings.Join(r.Correct.Values, ",")
+		} else if len(r.Correct.Values) > 0 {
+			q.AnswerKey = strings.TrimSpace(r.Correct.Values[0])
+		}
+	}
+	return q, nil
+}
+
+// itemPoints is the most an item scores: its MAXSCORE, or failing that the
+// normal maximum of its SCORE, or 1.
+func itemPoints(outcomes []outcomeDeclaration) int {
+	points := 1.0
+	for _, o := range outcomes {
+		switch {
+		case o.Identifier == "MAXSCORE" && o.Default != nil && len(o.Default.Values) > 0:
+			if v, err := strconv.ParseFloat(strings.TrimSpace(o.Default.Values[0]), 64); err == nil {
+				return int(math.Round(v))
+			}
+		case o.Identifier == "SCORE" && o.NormalMaximum != "":
+			if v, err := strconv.ParseFloat(o.NormalMaximum, 64); err == nil {
+				points = v
+			}
+		}
+	}
+	return int(math.Round(points))
+}
+
+// blockElements break the text of an item body into paragraphs.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "li": true, "blockquote": true, "pre": true, "prompt": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "table": true, "tr": true,
+}
+
+// body is what was read from an item's body.
+type body struct {
+	text         paragraphs
+	interactions int
+	unsupported  []string
+	responseID   string
+	answerType   domain.AnswerType
+	choices      []domain.Choice
+	visualAids   []string
+	rubric       *domain.RubricDocument
+}
+
+type prompt struct {
+	ResponseID string   `xml:"responseIdentifier,attr"`
+	Prompt     innerXML `xml:"prompt"`
+}
+
+func readBody(inner string) (*body, error) {
+	b := &body{}
+	dec := xml.NewDecoder(strings.NewReader(inner))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if err := b.element(dec, t); err != nil {
+				return nil, err
+			}
+		case xml.EndElement:
+			if blockElements[t.Name.Local] {
+				b.text.paragraph()
+			}
+		case xml.CharData:
+			b.text.write(string(t))
+		}
+	}
+}
+
+// element reads an element of the body; interactions, rubrics and images
+// are consumed whole.
+func (b *body) element(dec *xml.Decoder, t xml.StartElement) error {
+	name := t.Name.Local
+	switch name {
+	case "rubricBlock":
+		var rb struct {
+			View string `xml:"view,attr"`
+			innerXML
+		}
+		if err := dec.DecodeElement(&rb, &t); err != nil {
+			return err
+		}
+		if slices.Contains(strings.Fields(rb.View), "scorer") {
+			b.rubric = readRubric(rb.XML)
+		} else {
+			b.text.add(plainText(rb.XML))
+		}
+	case "choiceInteraction":
+		var ci struct {
+			prompt
+			Choices []struct {
+				Identifier string `xml:"identifier,attr"`
+				innerXML
+			} `xml:"simpleChoice"`
+		}
+		if err := dec.DecodeElement(&ci, &t); err != nil {
+			return err
+		}
+		b.interaction(domain.AnswerTypeMCQ, ci.prompt)
+		for _, c := range ci.Choices {
+			b.choices = append(b.choices, domain.Choice{ID: c.Identifier, Text: plainText(c.XML)})
+		}
+	case "extendedTextInteraction":
+		var p prompt
+		if err := dec.DecodeElement(&p, &t); err != nil {
+			return err
+		}
+		b.interaction(domain.AnswerTypeEssay, p)
+	case "textEntryInteraction":
+		var p prompt
+		if err := dec.DecodeElement(&p, &t); err != nil {
+			return err
+		}
+		b.interaction(domain.AnswerTypeShortAnswer, p)
+	case "uploadInteraction", "drawingInteraction":
+		var di struct {
+			prompt
+			Object struct {
+				Data string `xml:"data,attr"`
+			} `xml:"object"`
+		}
+		if err := dec.DecodeElement(&di, &t); err != nil {
+			return err
+		}
+		b.interaction(domain.AnswerTypeDiagram, di.prompt)
+		if di.Object.Data != "" {
+			b.visualAids = append(b.visualAids, di.Object.Data)
+		}
+	case "img":
+		for _, a := range t.Attr {
+			if a.Name.Local == "src" && a.Value != "" {
+				b.visualAids = append(b.visualAids, a.Value)
+			}
+		}
+		return dec.Skip()
+	case "br":
+		b.text.line()
+	default:
+		if strings.HasSuffix(name, "Interaction") {
+			b.interactions++
+			b.unsupported = append(b.unsupported, name)
+			return dec.Skip()
+		}
+		if blockElements[name] {
+			b.text.paragraph()
+		}
+	}
+	return nil
+}
+
+func (b *body) interaction(answerType domain.AnswerType, p prompt) {
+	b.interactions++
+	b.answerType, b.responseID = answerType, p.ResponseID
+	b.text.add(plainText(p.Prompt.XML))
+}
+
+// criterionLine is a rubric criterion worth points, "Names the enzyme
+// (2 points)".
+var criterionLine = regexp.MustCompile(`(?i)^(.*?)\s*\(\s*(\d+(?:\.\d+)?)\s*(?:points?|pts?|marks?)\s*\)$`)
+
+// readRubric reads a scorer's rubricBlock: its list items are criteria and
+// the rest grading notes.
+func readRubric(inner string) *domain.RubricDocument {
+	r := &domain.RubricDocument{}
+	var notes paragraphs
+	dec := xml.NewDecoder(strings.NewReader(inner))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "li" {
+				var li innerXML
+				if dec.DecodeElement(&li, &t) != nil {
+					break
+				}
+				c := domain.CriterionDocument{ID: "c" + strconv.Itoa(len(r.Criteria)+1), Description: plainText(li.XML)}
+				if m := criterionLine.FindStringSubmatch(c.Description); m != nil {
+					c.Description = m[1]
+					c.Points, _ = strconv.ParseFloat(m[2], 64)
+				}
+				if c.Description != "" {
+					r.Criteria = append(r.Criteria, c)
+				}
+			} else if t.Name.Local == "br" {
+				notes.line()
+			} else if blockElements[t.Name.Local] {
+				notes.paragraph()
+			}
+		case xml.EndElement:
+			if blockElements[t.Name.Local] {
+				notes.paragraph()
+			}
+		case xml.CharData:
+			notes.write(string(t))
+		}
+	}
+	r.GradingNotes = notes.String()
+	return r
+}
+
+// plainText is the text of markup, with paragraphs separated by a blank
+// line.
+func plainText(inner string) string {
+	var p paragraphs
+	dec := xml.NewDecoder(strings.NewReader(inner))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "br" {
+				p.line()
+			} else if blockElements[t.Name.Local] {
+				p.paragraph()
+			}
+		case xml.EndElement:
+			if blockElements[t.Name.Local] {
+				p.paragraph()
+			}
+		case xml.CharData:
+			p.write(string(t))
+		}
+	}
+	return p.String()
+}
+
+// paragraphs collects text, collapsing the whitespace of markup within a
+// line.
+type paragraphs struct {
+	done  []string
+	lines []string
+	cur   strings.Builder
+}
+
+func (p *paragraphs) write(s string) {
+	p.cur.WriteString(s)
+}
+
+// line ends the current line.
+func (p *paragraphs) line() {
+	p.lines = append(p.lines, strings.Join(strings.Fields(p.cur.String()), " "))
+	p.cur.Reset()
+}
+
+// paragraph ends the current paragraph.
+func (p *paragraphs) paragraph() {
+	p.line()
+	if text := strings.TrimSpace(strings.Join(p.lines, "\n")); text != "" {
+		p.done = append(p.done, text)
+	}
+	p.lines = nil
+}
+
+// add adds text as a paragraph of its own.
+func (p *paragraphs) add(text string) {
+	p.paragraph()
+	if text != "" {
+		p.done = append(p.done, text)
+	}
+}
+
+func (p *paragraphs) String() string {
+	p.paragraph()
+	return strings.Join(p.done, "\n\n")
+}
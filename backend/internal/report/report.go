@@ -0,0 +1,242 @@
+// Package report lays out a student's marked paper as a PDF: their scanned
+// pages with graded answers outlined and scored, then the feedback on each
+// question and their total.
+package report
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"harama/internal/pkg/pdf"
+)
+
+// Submission is what a student's report shows.
+type Submission struct {
+	ExamTitle   string
+	StudentID   string
+	SubmittedAt time.Time
+	Pages       []Page
+	Questions   []Question
+	TotalScore  float64
+	MaxScore    int
+	// CurvedTotal is the total under the exam's curve, if it covers the
+	// submission; the report gives it with TotalScore alongside
+	CurvedTotal *float64
+}
+
+// Page is a scanned page.
+type Page struct {
+	// Image is the scan as a JPEG with answer regions drawn on; nil if the
+	// scan could not be read
+	Image []byte
+	// Labels are placed by pixel position on Image
+	Labels []Label
+}
+
+// Label tags an answer region of a page with its question and score.
+type Label struct {
+	// At is the top left of the region, in pixels
+	At    image.Point
+	Text  string
+	Color color.Color
+}
+
+// Question is the grading of a question.
+type Question struct {
+	Label  string
+	Text   string
+	Points int
+	// Graded is false if the question has no grade yet
+	Graded   bool
+	Score    float64
+	Feedback string
+	Criteria []string
+	Mistakes []string
+	// Adjusted is set when a teacher overrode the grade
+	Adjusted bool
+}
+
+const (
+	margin = 40.0
+	// headerHeight is the space above a scan for its heading
+	headerHeight = 22.0
+	footerSize   = 8.0
+	labelSize    = 9.0
+	labelPad     = 2.0
+)
+
+// Render writes the report of a submission to w as a PDF.
+func Render(w io.Writer, s *Submission) error {
+	doc := pdf.New()
+	var pages []*pdf.Page
+
+	for i, scan := range s.Pages {
+		page := doc.AddPage(pdf.A4Width, pdf.A4Height)
+		pages = append(pages, page)
+		page.Text(margin, margin+11, pdf.HelveticaBold, 11, pdf.Black, fmt.Sprintf("%s – %s", s.ExamTitle, s.StudentID))
+		heading := fmt.Sprintf("Scan %d of %d", i+1, len(s.Pages))
+		page.Text(pdf.A4Width-margin-pdf.Helvetica.Width(heading, 9), margin+11, pdf.Helvetica, 9, pdf.Gray, heading)
+		if err := drawScan(doc, page, scan); err != nil {
+			return fmt.Errorf("report: page %d: %w", i+1, err)
+		}
+	}
+
+	f := &flow{doc: doc}
+	f.newPage()
+	f.text(pdf.HelveticaBold, 18, pdf.Black, s.ExamTitle)
+	f.text(pdf.Helvetica, 11, pdf.Black, "Student: "+s.StudentID)
+	if !s.SubmittedAt.IsZero() {
+		f.text(pdf.Helvetica, 11, pdf.Black, "Submitted: "+s.SubmittedAt.Format("2 January 2006"))
+	}
+	f.space(6)
+	f.text(pdf.HelveticaBold, 13, pdf.Black, total(s))
+	f.space(12)
+
+	for _, q := range s.Questions {
+		heading := fmt.Sprintf("%s – not graded yet (%d marks)", q.Label, q.Points)
+		if q.Graded {
+			heading = fmt.Sprintf("%s – %s / %d", q.Label, formatScore(q.Score), q.Points)
+		}
+		f.text(pdf.HelveticaBold, 12, pdf.Black, heading)
+		if q.Text != "" {
+			f.text(pdf.Helvetica, 9, pdf.Gray, q.Text)
+		}
+		if q.Adjusted {
+			f.text(pdf.Helvetica, 9, pdf.Gray, "This score was reviewed and adjusted by your teacher.")
+		}
+		if q.Feedback != "" {
+			f.space(3)
+			f.text(pdf.HelveticaBold, 10, pdf.Black, "Feedback")
+			f.text(pdf.Helvetica, 10, pdf.Black, q.Feedback)
+		}
+		f.bullets("Criteria met", q.Criteria)
+		f.bullets("Mistakes", q.Mistakes)
+		f.space(12)
+	}
+	f.text(pdf.HelveticaBold, 13, pdf.Black, total(s))
+	pages = append(pages, f.pages...)
+
+	for i, page := range pages {
+		footer := fmt.Sprintf("%s – page %d of %d", s.StudentID, i+1, len(pages))
+		page.Text(margin, pdf.A4Height-margin/2, pdf.Helvetica, footerSize, pdf.Gray, footer)
+	}
+	return doc.Write(w)
+}
+
+// drawScan fits a scan below the page heading and tags its answer regions.
+func drawScan(doc *pdf.Document, page *pdf.Page, scan Page) error {
+	if scan.Image == nil {
+		page.Text(margin, margin+headerHeight+20, pdf.Helvetica, 11, pdf.Gray, "This page's scan could not be read.")
+		return nil
+	}
+	img, err := doc.AddJPEG(scan.Image)
+	if err != nil {
+		return err
+	}
+	pw, ph := page.Size()
+	boxW, boxH := pw-2*margin, ph-2*margin-headerHeight
+	iw, ih := img.Size()
+	scale := math.Min(boxW/float64(iw), boxH/float64(ih))
+	w, h := float64(iw)*scale, float64(ih)*scale
+	x, y := margin+(boxW-w)/2, margin+headerHeight
+	page.Image(img, x, y, w, h)
+	page.StrokeRect(x, y, w, h, 0.5, pdf.Gray)
+
+	tagH := labelSize + 2*labelPad
+	for _, l := range scan.Labels {
+		tagW := pdf.HelveticaBold.Width(l.Text, labelSize) + 2*labelPad
+		tx := math.Min(x+float64(l.At.X)*scale, pw-margin-tagW)
+		ty := y + float64(l.At.Y)*scale - tagH
+		if ty < y {
+			// No room above the region; tag it inside
+			ty = y + float64(l.At.Y)*scale
+		}
+		page.FillRect(tx, ty, tagW, tagH, pdfColor(l.Color))
+		page.Text(tx+labelPad, ty+tagH-labelPad-1.5, pdf.HelveticaBold, labelSize, pdf.White, l.Text)
+	}
+	return nil
+}
+
+// flow lays text down pages, starting a page when one fills.
+type flow struct {
+	doc   *pdf.Document
+	pages []*pdf.Page
+	page  *pdf.Page
+	y     float64
+}
+
+func (f *flow) newPage() {
+	f.page = f.doc.AddPage(pdf.A4Width, pdf.A4Height)
+	f.pages = append(f.pages, f.page)
+	f.y = margin
+}
+
+func (f *flow) space(h float64) {
+	f.y += h
+}
+
+// text writes s wrapped to the page width.
+func (f *flow) text(font pdf.Font, size float64, c pdf.Color, s string) {
+	f.indented(0, "", font, size, c, s)
+}
+
+// indented writes s wrapped and indented, with first before its first
+// line, such as a bullet.
+func (f *flow) indented(indent float64, first string, font pdf.Font, size float64, c pdf.Color, s string) {
+	leading := size * 1.35
+	width := pdf.A4Width - 2*margin - indent
+	for i, line := range font.Wrap(s, size, width) {
+		if f.y+leading > pdf.A4Height-margin {
+			f.newPage()
+		}
+		f.y += leading
+		if i == 0 && first != "" {
+			f.page.Text(margin+indent-font.Width(first, size), f.y, font, size, c, first)
+		}
+		f.page.Text(margin+indent, f.y, font, size, c, line)
+	}
+}
+
+func (f *flow) bullets(title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	f.space(3)
+	f.text(pdf.HelveticaBold, 10, pdf.Black, title)
+	for _, item := range items {
+		f.indented(12, "• ", pdf.Helvetica, 10, pdf.Black, item)
+	}
+}
+
+func total(s *Submission) string {
+	score := s.TotalScore
+	if s.CurvedTotal != nil {
+		score = *s.CurvedTotal
+	}
+	t := fmt.Sprintf("Total: %s / %d", formatScore(score), s.MaxScore)
+	if s.MaxScore > 0 {
+		t += fmt.Sprintf(" (%s%%)", formatScore(score/float64(s.MaxScore)*100))
+	}
+	if s.CurvedTotal != nil {
+		t += fmt.Sprintf(", curved from %s", formatScore(s.TotalScore))
+	}
+	return t
+}
+
+// formatScore writes a score to at most two decimal places.
+func formatScore(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+func pdfColor(c color.Color) pdf.Color {
+	if c == nil {
+		return pdf.Black
+	}
+	r, g, b, _ := c.RGBA()
+	return pdf.Color{R: float64(r) / 0xffff, G: float64(g) / 0xffff, B: float64(b) / 0xffff}
+}
@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"harama/internal/domain"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type APIKeyRepo struct {
+	db *bun.DB
+}
+
+func NewAPIKeyRepo(db *bun.DB) *APIKeyRepo {
+	return &APIKeyRepo{db: db}
+}
+
+func (r *APIKeyRepo) Create(ctx context.Context, key *domain.APIKey) error {
+	_, err := conn(ctx, r.db).NewInsert().Model(key).Exec(ctx)
+	return err
+}
+
+// GetByHash looks a key up by hash across all tenants; it is how a request
+// made with a key finds its tenant.
+func (r *APIKeyRepo) GetByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	key := new(domain.APIKey)
+	err := r.db.NewSelect().
+		Model(key).
+		Where("key_hash = ?", hash).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *APIKeyRepo) ListByTenant(ctx context.Context, tenantID uuid.UUID) ([]domain.APIKey, error) {
+	var keys []domain.APIKey
+	err := r.db.NewSelect().
+		Model(&keys).
+		Where("tenant_id = ?", tenantID).
+		Order("created_at DESC").
+		Scan(ctx)
+	return keys, err
+}
+
+// Revoke marks a key of the tenant revoked; revoking twice keeps the first
+// time.
+func (r *APIKeyRepo) Revoke(ctx context.Context, tenantID, id uuid.UUID, at time.Time) error {
+	res, err := conn(ctx, r.db).NewUpdate().
+		Model((*domain.APIKey)(nil)).
+		Set("revoked_at = COALESCE(revoked_at, ?)", at).
+		Where("id = ?", id).
+		Where("tenant_id = ?", tenantID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchLastUsed records use of a key, at most once per interval so busy
+// clients don't write on every request.
+func (r *APIKeyRepo) TouchLastUsed(ctx context.Context, id uuid.UUID, at time.Time, interval time.Duration) error {
+	_, err := r.db.NewUpdate().
+		Model((*domain.APIKey)(nil)).
+		Set("last_used_at = ?", at).
+		Where("id = ?", id).
+		Where("last_used_at IS NULL OR last_used_at < ?", at.Add(-interval)).
+		Exec(ctx)
+	return err
+}
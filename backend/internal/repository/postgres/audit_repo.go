@@ -2,12 +2,14 @@ package postgres
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"fmt"
+	"harama/internal/auth"
 	"harama/internal/domain"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
 
@@ -19,49 +21,247 @@ func NewAuditRepo(db *bun.DB) *AuditRepo {
 	return &AuditRepo{db: db}
 }
 
+// GetLastHash returns the hash of the newest entry in the tenant's chain.
 func (r *AuditRepo) GetLastHash(ctx context.Context) (string, error) {
 	var lastLog domain.AuditLog
-	err := r.db.NewSelect().
-		Model(&lastLog).
-		Order("created_at DESC").
-		Limit(1).
-		Scan(ctx)
-	if err != nil {
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		return r.head(ctx, db, &lastLog)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
 		// If no logs exist, return a starting seed
-		return "initial_seed", nil
+		return domain.ChainSeed, nil
+	}
+	if err != nil {
+		return "", err
 	}
 	return lastLog.Hash, nil
 }
 
+func (r *AuditRepo) head(ctx context.Context, db bun.IDB, head *domain.AuditLog) error {
+	q := db.NewSelect().Model(head).Column("seq", "hash")
+	return forTenant(ctx, q, "tenant_id = ?").
+		Order("seq DESC").
+		Limit(1).
+		Scan(ctx)
+}
+
+// Save appends an entry to the chain of the tenant in ctx, which the entry
+// is attributed to. Entries without an actor are attributed to the user or
+// API key behind ctx, if any, and entries written by a background job to
+// the job. Inside RunInTx the entry is written in the caller's transaction.
+// An entry with no tenant of its own or in ctx is refused, as it would have
+// no chain to join.
 func (r *AuditRepo) Save(ctx context.Context, log *domain.AuditLog) error {
-	lastHash, err := r.GetLastHash(ctx)
-	if err != nil {
+	if job, ok := auth.GetJob(ctx); ok {
+		if log.Metadata == nil {
+			log.Metadata = map[string]interface{}{}
+		}
+		log.Metadata["job_id"] = job.ID
+		if log.ActorType == "" {
+			log.ActorType = "worker"
+		}
+	}
+	if actor, err := auth.GetActor(ctx); err == nil && log.ActorID == nil {
+		log.ActorID = &actor.UserID
+		if log.ActorType == "" {
+			log.ActorType = string(actor.Role)
+		}
+	}
+	if log.TenantID == nil {
+		tenantID, err := auth.GetTenantID(ctx)
+		if err != nil {
+			return fmt.Errorf("audit entry has no tenant: %w", err)
+		}
+		log.TenantID = &tenantID
+	}
+	ctx = withTenant(ctx, *log.TenantID)
+	return InTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		return r.append(ctx, db, log)
+	})
+}
+
+// append chains log onto the head of its tenant's chain. The transaction
+// lock on the chain makes concurrent appends queue rather than both build
+// on the same head and fork it.
+func (r *AuditRepo) append(ctx context.Context, db bun.IDB, log *domain.AuditLog) error {
+	chain := "audit_log:" + log.TenantID.String()
+	if _, err := db.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtextextended(?, 0))", chain); err != nil {
 		return err
 	}
 
-	// Calculate hash: SHA-256(previousHash + entityType + entityID + eventType + actorID + changesJSON)
-	changesJSON, _ := json.Marshal(log.Changes)
-	actorID := ""
-	if log.ActorID != nil {
-		actorID = log.ActorID.String()
+	var head domain.AuditLog
+	switch err := r.head(ctx, db, &head); {
+	case errors.Is(err, sql.ErrNoRows):
+		head.Hash = domain.ChainSeed
+	case err != nil:
+		return err
 	}
 
-	data := fmt.Sprintf("%s|%s|%s|%s|%s", log.EntityType, log.EntityID, log.EventType, actorID, string(changesJSON))
-	hasher := sha256.New()
-	hasher.Write([]byte(lastHash + data))
-	log.Hash = hex.EncodeToString(hasher.Sum(nil))
+	log.Seq = head.Seq + 1
+	log.HashVersion = domain.CurrentHashVersion
+	log.Hash = log.ChainHash(head.Hash)
 
-	_, err = r.db.NewInsert().Model(log).Exec(ctx)
+	_, err := db.NewInsert().Model(log).Exec(ctx)
 	return err
 }
 
+// RunInTx runs fn in one transaction, which repository calls made with the
+// ctx passed to fn join, so a change and the audit entry describing it are
+// written together or not at all.
+func (r *AuditRepo) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return InTx(ctx, r.db, func(ctx context.Context, _ bun.IDB) error {
+		return fn(ctx)
+	})
+}
+
 func (r *AuditRepo) GetByEntity(ctx context.Context, entityType string, entityID string) ([]domain.AuditLog, error) {
 	var logs []domain.AuditLog
-	err := r.db.NewSelect().
-		Model(&logs).
-		Where("entity_type = ?", entityType).
-		Where("entity_id = ?", entityID).
-		Order("created_at DESC").
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&logs).
+			Where("entity_type = ?", entityType).
+			Where("entity_id = ?", entityID)
+		return forTenant(ctx, q, "tenant_id = ?").
+			Order("seq DESC").
+			Scan(ctx)
+	})
+	return logs, err
+}
+
+// prevHashColumn selects the hash each entry was chained onto, so an entry
+// can be checked against its predecessor without reading the whole chain.
+const prevHashColumn = `CASE WHEN al.seq = 1 THEN ? ELSE (SELECT p.hash FROM audit_log AS p WHERE p.tenant_id = al.tenant_id AND p.seq = al.seq - 1) END AS prev_hash`
+
+// AuditFilter selects entries of the tenant in ctx. Zero fields match any
+// entry; From is inclusive and To exclusive.
+type AuditFilter struct {
+	ActorID    *uuid.UUID
+	ActorType  string
+	EventType  string
+	EntityType string
+	EntityID   *uuid.UUID
+	From       *time.Time
+	To         *time.Time
+	// After continues a listing from the entry with this seq, exclusive
+	After int64
+	// Ascending lists oldest first rather than newest first
+	Ascending bool
+	Limit     int
+}
+
+// Search returns the entries matching f in sequence order, each with the
+// hash it was chained onto.
+func (r *AuditRepo) Search(ctx context.Context, f AuditFilter) ([]domain.AuditLog, error) {
+	var logs []domain.AuditLog
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&logs).
+			ColumnExpr("al.*").
+			ColumnExpr(prevHashColumn, domain.ChainSeed)
+		if f.ActorID != nil {
+			q = q.Where("al.actor_id = ?", *f.ActorID)
+		}
+		if f.ActorType != "" {
+			q = q.Where("al.actor_type = ?", f.ActorType)
+		}
+		if f.EventType != "" {
+			q = q.Where("al.event_type = ?", f.EventType)
+		}
+		if f.EntityType != "" {
+			q = q.Where("al.entity_type = ?", f.EntityType)
+		}
+		if f.EntityID != nil {
+			q = q.Where("al.entity_id = ?", *f.EntityID)
+		}
+		if f.From != nil {
+			q = q.Where("al.created_at >= ?", *f.From)
+		}
+		if f.To != nil {
+			q = q.Where("al.created_at < ?", *f.To)
+		}
+
+		order := "al.seq DESC"
+		if f.Ascending {
+			order = "al.seq ASC"
+			if f.After > 0 {
+				q = q.Where("al.seq > ?", f.After)
+			}
+		} else if f.After > 0 {
+			q = q.Where("al.seq < ?", f.After)
+		}
+		if f.Limit > 0 {
+			q = q.Limit(f.Limit)
+		}
+		return forTenant(ctx, q, "al.tenant_id = ?").Order(order).Scan(ctx)
+	})
 	return logs, err
 }
+
+// Lineage returns, oldest first, the entries behind a grade: those of its
+// submission, of the grade itself, of escalations about it and of its
+// question and rubric. Grade entries are matched by submission and question
+// as well as by ID, since regrading replaces the grade row's ID.
+func (r *AuditRepo) Lineage(ctx context.Context, grade *domain.FinalGrade) ([]domain.AuditLog, error) {
+	var logs []domain.AuditLog
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&logs).
+			ColumnExpr("al.*").
+			ColumnExpr(prevHashColumn, domain.ChainSeed).
+			WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.
+					WhereOr("al.entity_type = 'submission' AND al.entity_id = ?", grade.SubmissionID).
+					WhereOr("al.entity_type = 'grade' AND (al.entity_id = ? OR (al.changes->>'submission_id' = ? AND al.changes->>'question_id' = ?))",
+						grade.ID, grade.SubmissionID.String(), grade.QuestionID.String()).
+					WhereOr("al.entity_type = 'escalation' AND al.changes->>'grade_id' = ?", grade.ID.String()).
+					WhereOr("al.entity_type = 'question' AND al.entity_id = ?", grade.QuestionID).
+					WhereOr("al.entity_type = 'rubric' AND al.changes->>'question_id' = ?", grade.QuestionID.String())
+			})
+		return forTenant(ctx, q, "al.tenant_id = ?").Order("al.seq ASC").Scan(ctx)
+	})
+	return logs, err
+}
+
+// ListChain returns up to limit entries of the tenant's chain in insertion
+// order, starting after the entry after (from the start when nil).
+func (r *AuditRepo) ListChain(ctx context.Context, after *domain.AuditLog, limit int) ([]domain.AuditLog, error) {
+	var logs []domain.AuditLog
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().Model(&logs)
+		if after != nil {
+			q = q.Where("al.seq > ?", after.Seq)
+		}
+		return forTenant(ctx, q, "tenant_id = ?").
+			Order("al.seq ASC").
+			Limit(limit).
+			Scan(ctx)
+	})
+	return logs, err
+}
+
+func (r *AuditRepo) CreateCheckpoint(ctx context.Context, cp *domain.AuditCheckpoint) error {
+	ctx = withTenant(ctx, cp.TenantID)
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(cp).Exec(ctx)
+		return err
+	})
+}
+
+// ListCheckpoints returns the tenant's checkpoints, oldest first.
+func (r *AuditRepo) ListCheckpoints(ctx context.Context) ([]domain.AuditCheckpoint, error) {
+	var cps []domain.AuditCheckpoint
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().Model(&cps)
+		return forTenant(ctx, q, "tenant_id = ?").
+			Order("entry_count ASC", "created_at ASC").
+			Scan(ctx)
+	})
+	return cps, err
+}
+
+// TenantIDs lists every tenant, for jobs that walk each tenant's chain.
+func (r *AuditRepo) TenantIDs(ctx context.Context) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.NewSelect().Table("tenants").Column("id").Order("id").Scan(ctx, &ids)
+	return ids, err
+}
@@ -0,0 +1,254 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"harama/internal/domain"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// bankSearchDocument is the text a bank search matches, as indexed by
+// idx_bank_items_search
+const bankSearchDocument = "to_tsvector('simple', bi.question_text || ' ' || COALESCE(bi.topic, '') || ' ' || COALESCE(bi.learning_objective, ''))"
+
+type BankRepo struct {
+	db *bun.DB
+}
+
+func NewBankRepo(db *bun.DB) *BankRepo {
+	return &BankRepo{db: db}
+}
+
+func (r *BankRepo) Create(ctx context.Context, item *domain.BankItem) error {
+	return InTenantTx(withTenant(ctx, item.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(item).Exec(ctx)
+		return err
+	})
+}
+
+func (r *BankRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.BankItem, error) {
+	item := new(domain.BankItem)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(item).
+			Where("bi.id = ?", id)
+		return forTenant(ctx, q, "bi.tenant_id = ?").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// GetByIDs returns the items with the given IDs, in no particular order.
+// Items of another tenant are left out.
+func (r *BankRepo) GetByIDs(ctx context.Context, ids []uuid.UUID) ([]domain.BankItem, error) {
+	var items []domain.BankItem
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&items).
+			Where("bi.id IN (?)", bun.In(ids))
+		return forTenant(ctx, q, "bi.tenant_id = ?").Scan(ctx)
+	})
+	return items, err
+}
+
+// BankFilter selects items of the tenant in ctx. Zero fields match any
+// item; Query matches the words of an item's text, topic and learning
+// objective.
+type BankFilter struct {
+	Query             string
+	Topic             string
+	LearningObjective string
+	Difficulty        domain.Difficulty
+	GradeLevel        string
+	AnswerType        domain.AnswerType
+	Limit             int
+	Offset            int
+}
+
+// Search returns the items matching f, the best matches first when f has a
+// query and the newest first otherwise.
+func (r *BankRepo) Search(ctx context.Context, f BankFilter) ([]domain.BankItem, error) {
+	var items []domain.BankItem
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().Model(&items)
+		if f.Query != "" {
+			q = q.Where(bankSearchDocument+" @@ plainto_tsquery('simple', ?)", f.Query).
+				OrderExpr("ts_rank("+bankSearchDocument+", plainto_tsquery('simple', ?)) DESC", f.Query)
+		}
+		if f.Topic != "" {
+			q = q.Where("lower(bi.topic) = lower(?)", f.Topic)
+		}
+		if f.LearningObjective != "" {
+			q = q.Where("bi.learning_objective ILIKE ?", "%"+f.LearningObjective+"%")
+		}
+		if f.Difficulty != "" {
+			q = q.Where("bi.difficulty = ?", f.Difficulty)
+		}
+		if f.GradeLevel != "" {
+			q = q.Where("lower(bi.grade_level) = lower(?)", f.GradeLevel)
+		}
+		if f.AnswerType != "" {
+			q = q.Where("bi.answer_type = ?", f.AnswerType)
+		}
+		if f.Limit > 0 {
+			q = q.Limit(f.Limit)
+		}
+		if f.Offset > 0 {
+			q = q.Offset(f.Offset)
+		}
+		return forTenant(ctx, q, "bi.tenant_id = ?").
+			Order("bi.created_at DESC").
+			Scan(ctx)
+	})
+	return items, err
+}
+
+// Save writes an item's question, rubric and tags.
+func (r *BankRepo) Save(ctx context.Context, item *domain.BankItem) error {
+	item.UpdatedAt = time.Now()
+	return InTenantTx(withTenant(ctx, item.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model(item).
+			Column("question_text", "points", "answer_type", "answer_key", "choices", "visual_aids", "rubric",
+				"topic", "learning_objective", "difficulty", "grade_level", "updated_at").
+			WherePK()
+		res, err := forTenant(ctx, q, "tenant_id = ?").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// Delete removes an item from the bank. Questions made from it keep their
+// content but no longer count towards it.
+func (r *BankRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewDelete().
+			Model((*domain.BankItem)(nil)).
+			Where("bi.id = ?", id)
+		res, err := forTenant(ctx, q, "bi.tenant_id = ?").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// Stats returns the statistics of the given items from the questions made
+// from them and their grades, by item. Items never used are left out.
+func (r *BankRepo) Stats(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]domain.BankItemStats, error) {
+	var rows []domain.BankItemStats
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			TableExpr("questions AS q").
+			ColumnExpr("q.bank_item_id").
+			ColumnExpr("COUNT(DISTINCT q.id) AS uses").
+			ColumnExpr("COUNT(DISTINCT q.exam_id) AS exams").
+			ColumnExpr("COUNT(g.id) AS graded").
+			ColumnExpr("AVG(g.score / NULLIF(g.max_score, 0)) AS facility").
+			ColumnExpr("COUNT(g.id) FILTER (WHERE g.score >= g.max_score) AS full_marks").
+			ColumnExpr("COUNT(g.id) FILTER (WHERE g.score = 0) AS zero_scores").
+			ColumnExpr("MAX(e.created_at) AS last_used_at").
+			Join("JOIN exams AS e ON e.id = q.exam_id").
+			Join("LEFT JOIN grades AS g ON g.question_id = q.id").
+			Where("q.bank_item_id IN (?)", bun.In(ids))
+		return forTenant(ctx, q, "e.tenant_id = ?").
+			Group("q.bank_item_id").
+			Scan(ctx, &rows)
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[uuid.UUID]domain.BankItemStats, len(rows))
+	for _, s := range rows {
+		stats[s.BankItemID] = s
+	}
+	return stats, nil
+}
+
+// LinkQuestion records that a question of the tenant in ctx was made from
+// an item, so that its grades count towards the item.
+func (r *BankRepo) LinkQuestion(ctx context.Context, questionID, itemID uuid.UUID, linked bool) error {
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model((*domain.Question)(nil)).
+			Set("bank_item_id = ?", itemID).
+			Set("bank_linked = ?", linked).
+			Where("q.id = ?", questionID)
+		res, err := forTenant(ctx, q, "q."+examInTenant).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// UpdateLinked brings the questions linked to an item up to date with it,
+// and their rubrics with its rubric if it has one. A linked question that
+// has been graded is detached instead, becoming a copy, so that its grades
+// stay true to the question they were given for. It returns the IDs of the
+// questions updated and how many were detached.
+func (r *BankRepo) UpdateLinked(ctx context.Context, item *domain.BankItem) ([]uuid.UUID, int, error) {
+	var (
+		updated  []uuid.UUID
+		detached int
+	)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model((*domain.Question)(nil)).
+			Set("bank_linked = FALSE").
+			Where("q.bank_item_id = ? AND q.bank_linked", item.ID).
+			Where("EXISTS (SELECT 1 FROM grades AS g WHERE g.question_id = q.id)")
+		res, err := forTenant(ctx, q, "q."+examInTenant).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		detached = int(n)
+
+		question := item.Question()
+		q = db.NewUpdate().
+			Model(&question).
+			Column("question_text", "points", "answer_type", "answer_key", "choices", "visual_aids").
+			Where("q.bank_item_id = ? AND q.bank_linked", item.ID)
+		if err := forTenant(ctx, q, "q."+examInTenant).Returning("q.id").Scan(ctx, &updated); err != nil {
+			return err
+		}
+		if item.Rubric == nil || len(updated) == 0 {
+			return nil
+		}
+
+		rubrics := make([]*domain.Rubric, len(updated))
+		for i, id := range updated {
+			rubrics[i] = item.Rubric.ToRubric()
+			rubrics[i].ID, rubrics[i].QuestionID = uuid.New(), id
+		}
+		_, err = db.NewInsert().
+			Model(&rubrics).
+			On("CONFLICT (question_id) DO UPDATE").
+			Set("full_credit_criteria = EXCLUDED.full_credit_criteria").
+			Set("partial_credit_rules = EXCLUDED.partial_credit_rules").
+			Set("common_mistakes = EXCLUDED.common_mistakes").
+			Set("key_concepts = EXCLUDED.key_concepts").
+			Set("grading_notes = EXCLUDED.grading_notes").
+			Set("strict_mode = EXCLUDED.strict_mode").
+			Exec(ctx)
+		return err
+	})
+	return updated, detached, err
+}
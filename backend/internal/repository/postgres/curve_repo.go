@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"harama/internal/domain"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type CurveRepo struct {
+	db *bun.DB
+}
+
+func NewCurveRepo(db *bun.DB) *CurveRepo {
+	return &CurveRepo{db: db}
+}
+
+// Create records a curve as the next version of its exam's curve and makes
+// it the active one, deactivating the one before. Curves of the same exam
+// are created one at a time, so that each gets its own version.
+func (r *CurveRepo) Create(ctx context.Context, c *domain.GradeCurve) error {
+	return InTenantTx(withTenant(ctx, c.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		if _, err := db.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtextextended(?, 0))", "grade_curves:"+c.ExamID.String()); err != nil {
+			return err
+		}
+		if _, err := r.deactivate(ctx, db, c.ExamID); err != nil {
+			return err
+		}
+		q := db.NewSelect().
+			Model((*domain.GradeCurve)(nil)).
+			ColumnExpr("COALESCE(MAX(gc.version), 0) + 1").
+			Where("gc.exam_id = ?", c.ExamID)
+		if err := forTenant(ctx, q, "gc.tenant_id = ?").Scan(ctx, &c.Version); err != nil {
+			return err
+		}
+		c.Active = true
+		_, err := db.NewInsert().Model(c).Exec(ctx)
+		return err
+	})
+}
+
+// ListByExam returns the versions of an exam's curve, latest first,
+// without their scores.
+func (r *CurveRepo) ListByExam(ctx context.Context, examID uuid.UUID) ([]domain.GradeCurve, error) {
+	var curves []domain.GradeCurve
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&curves).
+			ExcludeColumn("scores").
+			Where("gc.exam_id = ?", examID)
+		return forTenant(ctx, q, "gc.tenant_id = ?").
+			Order("gc.version DESC").
+			Scan(ctx)
+	})
+	return curves, err
+}
+
+// GetVersion returns a version of an exam's curve.
+func (r *CurveRepo) GetVersion(ctx context.Context, examID uuid.UUID, version int) (*domain.GradeCurve, error) {
+	c := new(domain.GradeCurve)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(c).
+			Where("gc.exam_id = ? AND gc.version = ?", examID, version)
+		return forTenant(ctx, q, "gc.tenant_id = ?").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Active returns the curve applied to an exam, or sql.ErrNoRows if it has
+// none.
+func (r *CurveRepo) Active(ctx context.Context, examID uuid.UUID) (*domain.GradeCurve, error) {
+	c := new(domain.GradeCurve)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(c).
+			Where("gc.exam_id = ? AND gc.active", examID)
+		return forTenant(ctx, q, "gc.tenant_id = ?").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ActiveForExams returns the curves applied to any of a tenant's exams.
+func (r *CurveRepo) ActiveForExams(ctx context.Context, tenantID uuid.UUID, examIDs []uuid.UUID) ([]domain.GradeCurve, error) {
+	var curves []domain.GradeCurve
+	if len(examIDs) == 0 {
+		return curves, nil
+	}
+	err := InTenantTx(withTenant(ctx, tenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&curves).
+			Where("gc.exam_id IN (?) AND gc.active", bun.In(examIDs))
+		return forTenant(ctx, q, "gc.tenant_id = ?").Scan(ctx)
+	})
+	return curves, err
+}
+
+// Deactivate removes the curve applied to an exam, returning its version.
+// It is sql.ErrNoRows if the exam has none.
+func (r *CurveRepo) Deactivate(ctx context.Context, examID uuid.UUID) (int, error) {
+	var version int
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		versions, err := r.deactivate(ctx, db, examID)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return sql.ErrNoRows
+		}
+		version = versions[0]
+		return nil
+	})
+	return version, err
+}
+
+func (r *CurveRepo) deactivate(ctx context.Context, db bun.IDB, examID uuid.UUID) ([]int, error) {
+	var versions []int
+	q := db.NewUpdate().
+		Model((*domain.GradeCurve)(nil)).
+		Set("active = FALSE").
+		Set("deactivated_at = ?", time.Now()).
+		Where("gc.exam_id = ? AND gc.active", examID)
+	err := forTenant(ctx, q, "gc.tenant_id = ?").
+		Returning("gc.version").
+		Scan(ctx, &versions)
+	return versions, err
+}
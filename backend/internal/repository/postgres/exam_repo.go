@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
 	"harama/internal/domain"
 
 	"github.com/google/uuid"
@@ -17,18 +18,53 @@ func NewExamRepo(db *bun.DB) *ExamRepo {
 }
 
 func (r *ExamRepo) Create(ctx context.Context, exam *domain.Exam) error {
-	_, err := r.db.NewInsert().Model(exam).Exec(ctx)
-	return err
+	return InTenantTx(withTenant(ctx, exam.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(exam).Exec(ctx)
+		return err
+	})
+}
+
+// CreateWithQuestions inserts an exam together with its questions and their
+// rubrics, all of which are given IDs here.
+func (r *ExamRepo) CreateWithQuestions(ctx context.Context, exam *domain.Exam) error {
+	return InTenantTx(withTenant(ctx, exam.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		if _, err := db.NewInsert().Model(exam).Exec(ctx); err != nil {
+			return err
+		}
+		if len(exam.Questions) == 0 {
+			return nil
+		}
+
+		var rubrics []*domain.Rubric
+		for i := range exam.Questions {
+			q := &exam.Questions[i]
+			q.ID, q.ExamID = uuid.New(), exam.ID
+			if q.Rubric != nil {
+				q.Rubric.ID, q.Rubric.QuestionID = uuid.New(), q.ID
+				rubrics = append(rubrics, q.Rubric)
+			}
+		}
+		if _, err := db.NewInsert().Model(&exam.Questions).Exec(ctx); err != nil {
+			return err
+		}
+		if len(rubrics) == 0 {
+			return nil
+		}
+		_, err := db.NewInsert().Model(&rubrics).Exec(ctx)
+		return err
+	})
 }
 
 func (r *ExamRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Exam, error) {
 	exam := new(domain.Exam)
-	err := r.db.NewSelect().
-		Model(exam).
-		Relation("Questions").
-		Relation("Questions.Rubric").
-		Where("e.id = ?", id).
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(exam).
+			Relation("Questions").
+			Relation("Questions.Rubric").
+			Where("e.id = ?", id)
+		return forTenant(ctx, q, "e.tenant_id = ?").Scan(ctx)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -37,43 +73,101 @@ func (r *ExamRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Exam, err
 
 func (r *ExamRepo) ListByTenant(ctx context.Context, tenantID uuid.UUID) ([]domain.Exam, error) {
 	var exams []domain.Exam
-	err := r.db.NewSelect().
-		Model(&exams).
-		Relation("Questions").
-		Where("tenant_id = ?", tenantID).
-		Order("created_at DESC").
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(&exams).
+			Relation("Questions").
+			Where("tenant_id = ?", tenantID).
+			Order("created_at DESC").
+			Scan(ctx)
+	})
 	return exams, err
 }
 
 func (r *ExamRepo) GetQuestionByID(ctx context.Context, id uuid.UUID) (*domain.Question, error) {
 	question := new(domain.Question)
-	err := r.db.NewSelect().
-		Model(question).
-		Relation("Rubric").
-		Where("q.id = ?", id).
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(question).
+			Relation("Rubric").
+			Where("q.id = ?", id)
+		return forTenant(ctx, q, "q."+examInTenant).Scan(ctx)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return question, nil
 }
 
+// CreateQuestion adds a question to an exam of the tenant in ctx; an exam of
+// another tenant is reported as not found.
 func (r *ExamRepo) CreateQuestion(ctx context.Context, question *domain.Question) error {
-	_, err := r.db.NewInsert().Model(question).Exec(ctx)
-	return err
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		exists, err := forTenant(ctx, db.NewSelect().Model((*domain.Exam)(nil)).Where("e.id = ?", question.ExamID), "e.tenant_id = ?").Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		_, err = db.NewInsert().Model(question).Exec(ctx)
+		return err
+	})
+}
+
+// AddQuestions adds questions to an exam of the tenant in ctx together,
+// with the rubrics of those that have one; an exam of another tenant is
+// reported as not found.
+func (r *ExamRepo) AddQuestions(ctx context.Context, examID uuid.UUID, questions []domain.Question) error {
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		exists, err := forTenant(ctx, db.NewSelect().Model((*domain.Exam)(nil)).Where("e.id = ?", examID), "e.tenant_id = ?").Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		for i := range questions {
+			questions[i].ExamID = examID
+		}
+		if _, err = db.NewInsert().Model(&questions).Exec(ctx); err != nil {
+			return err
+		}
+
+		var rubrics []*domain.Rubric
+		for i := range questions {
+			if q := &questions[i]; q.Rubric != nil {
+				q.Rubric.ID, q.Rubric.QuestionID = uuid.New(), q.ID
+				rubrics = append(rubrics, q.Rubric)
+			}
+		}
+		if len(rubrics) == 0 {
+			return nil
+		}
+		_, err = db.NewInsert().Model(&rubrics).Exec(ctx)
+		return err
+	})
 }
 
 func (r *ExamRepo) UpdateRubric(ctx context.Context, rubric *domain.Rubric) error {
-	_, err := r.db.NewInsert().
-		Model(rubric).
-		On("CONFLICT (question_id) DO UPDATE").
-		Set("full_credit_criteria = EXCLUDED.full_credit_criteria").
-		Set("partial_credit_rules = EXCLUDED.partial_credit_rules").
-		Set("common_mistakes = EXCLUDED.common_mistakes").
-		Set("key_concepts = EXCLUDED.key_concepts").
-		Set("grading_notes = EXCLUDED.grading_notes").
-		Set("strict_mode = EXCLUDED.strict_mode").
-		Exec(ctx)
-	return err
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		exists, err := forTenant(ctx, db.NewSelect().Model((*domain.Question)(nil)).Where("q.id = ?", rubric.QuestionID), "q."+examInTenant).Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		_, err = db.NewInsert().
+			Model(rubric).
+			On("CONFLICT (question_id) DO UPDATE").
+			Set("full_credit_criteria = EXCLUDED.full_credit_criteria").
+			Set("partial_credit_rules = EXCLUDED.partial_credit_rules").
+			Set("common_mistakes = EXCLUDED.common_mistakes").
+			Set("key_concepts = EXCLUDED.key_concepts").
+			Set("grading_notes = EXCLUDED.grading_notes").
+			Set("strict_mode = EXCLUDED.strict_mode").
+			Exec(ctx)
+		return err
+	})
 }
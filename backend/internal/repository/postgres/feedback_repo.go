@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"harama/internal/auth"
 	"harama/internal/domain"
 
 	"github.com/google/uuid"
@@ -17,26 +18,55 @@ func NewFeedbackRepo(db *bun.DB) *FeedbackRepo {
 }
 
 func (r *FeedbackRepo) SaveFeedbackEvent(ctx context.Context, event *domain.FeedbackEvent) error {
-	_, err := r.db.NewInsert().Model(event).Exec(ctx)
-	return err
+	if event.TenantID == uuid.Nil {
+		if tenantID, err := auth.GetTenantID(ctx); err == nil {
+			event.TenantID = tenantID
+		}
+	}
+	return InTenantTx(withTenant(ctx, event.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(event).Exec(ctx)
+		return err
+	})
 }
 
 func (r *FeedbackRepo) GetFeedbackByQuestion(ctx context.Context, questionID uuid.UUID) ([]domain.FeedbackEvent, error) {
 	var events []domain.FeedbackEvent
-	err := r.db.NewSelect().
-		Model(&events).
-		Where("question_id = ?", questionID).
-		Order("timestamp DESC").
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&events).
+			Where("question_id = ?", questionID)
+		return forTenant(ctx, q, "tenant_id = ?").
+			Order("timestamp DESC").
+			Scan(ctx)
+	})
 	return events, err
 }
 
 func (r *FeedbackRepo) GetFeedbackBySubmission(ctx context.Context, submissionID uuid.UUID) ([]domain.FeedbackEvent, error) {
 	var events []domain.FeedbackEvent
-	err := r.db.NewSelect().
-		Model(&events).
-		Where("submission_id = ?", submissionID).
-		Order("timestamp DESC").
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&events).
+			Where("submission_id = ?", submissionID)
+		return forTenant(ctx, q, "tenant_id = ?").
+			Order("timestamp DESC").
+			Scan(ctx)
+	})
+	return events, err
+}
+
+// GetFeedbackByStudent returns teacher overrides across all of a student's
+// submissions, newest first.
+func (r *FeedbackRepo) GetFeedbackByStudent(ctx context.Context, tenantID uuid.UUID, studentID string) ([]domain.FeedbackEvent, error) {
+	var events []domain.FeedbackEvent
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(&events).
+			Join("JOIN submissions ON fe.submission_id = submissions.id").
+			Where("submissions.tenant_id = ?", tenantID).
+			Where("submissions.student_id = ?", studentID).
+			Order("fe.timestamp DESC").
+			Scan(ctx)
+	})
 	return events, err
 }
@@ -2,7 +2,9 @@ package postgres
 
 import (
 	"context"
+	"fmt"
 	"harama/internal/domain"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
@@ -17,29 +19,96 @@ func NewGradeRepo(db *bun.DB) *GradeRepo {
 }
 
 func (r *GradeRepo) SaveFinalGrade(ctx context.Context, grade *domain.FinalGrade) error {
-	_, err := r.db.NewInsert().
-		Model(grade).
-		On("CONFLICT (submission_id, question_id) DO UPDATE").
-		Set("score = EXCLUDED.score").
-		Set("confidence = EXCLUDED.confidence").
-		Set("status = EXCLUDED.status").
-		Set("updated_at = EXCLUDED.updated_at").
-		Exec(ctx)
-	return err
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().
+			Model(grade).
+			On("CONFLICT (submission_id, question_id) DO UPDATE").
+			Set("score = EXCLUDED.score").
+			Set("confidence = EXCLUDED.confidence").
+			Set("status = EXCLUDED.status").
+			Set("generation_settings = EXCLUDED.generation_settings").
+			Set("updated_at = EXCLUDED.updated_at").
+			Exec(ctx)
+		return err
+	})
+}
+
+// GetByID returns a grade of the tenant in ctx; a grade of another tenant is
+// not found.
+func (r *GradeRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.FinalGrade, error) {
+	grade := new(domain.FinalGrade)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(grade).
+			Where("g.id = ?", id)
+		return forTenant(ctx, q, "g."+submissionInTenant).Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grade, nil
 }
 
 func (r *GradeRepo) GetBySubmission(ctx context.Context, submissionID uuid.UUID) ([]domain.FinalGrade, error) {
 	var grades []domain.FinalGrade
-	err := r.db.NewSelect().
-		Model(&grades).
-		Where("submission_id = ?", submissionID).
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&grades).
+			Where("submission_id = ?", submissionID)
+		return forTenant(ctx, q, "g."+submissionInTenant).Scan(ctx)
+	})
 	return grades, err
 }
 
 func (r *GradeRepo) SaveEscalation(ctx context.Context, escalation *domain.EscalationCase) error {
-	_, err := r.db.NewInsert().Model(escalation).Exec(ctx)
-	return err
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(escalation).Exec(ctx)
+		return err
+	})
+}
+
+// ListEscalations returns the tenant's escalations, newest first, optionally
+// only those in one status.
+func (r *GradeRepo) ListEscalations(ctx context.Context, tenantID uuid.UUID, status string) ([]domain.EscalationCase, error) {
+	var escalations []domain.EscalationCase
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&escalations).
+			Join("JOIN submissions AS s ON s.id = esc.submission_id").
+			Where("s.tenant_id = ?", tenantID)
+		if status != "" {
+			q = q.Where("esc.status = ?", status)
+		}
+		return q.Order("esc.escalated_at DESC").Scan(ctx)
+	})
+	return escalations, err
+}
+
+func (r *GradeRepo) GetEscalation(ctx context.Context, tenantID, id uuid.UUID) (*domain.EscalationCase, error) {
+	escalation := new(domain.EscalationCase)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(escalation).
+			Join("JOIN submissions AS s ON s.id = esc.submission_id").
+			Where("esc.id = ?", id).
+			Where("s.tenant_id = ?", tenantID).
+			Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return escalation, nil
+}
+
+func (r *GradeRepo) UpdateEscalation(ctx context.Context, escalation *domain.EscalationCase) error {
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model(escalation).
+			Column("status", "assigned_to").
+			WherePK()
+		_, err := forTenant(ctx, q, "esc."+submissionInTenant).Exec(ctx)
+		return err
+	})
 }
 
 type GlobalStat struct {
@@ -51,28 +120,31 @@ type GlobalStat struct {
 
 func (r *GradeRepo) GetGlobalStats(ctx context.Context, tenantID uuid.UUID) (*GlobalStat, error) {
 	stat := new(GlobalStat)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		// Count total submissions for tenant
+		subCount, err := db.NewSelect().
+			Table("submissions").
+			Where("tenant_id = ?", tenantID).
+			Count(ctx)
+		if err != nil {
+			return err
+		}
+		stat.TotalSubmissions = subCount
 
-	// Count total submissions for tenant
-	subCount, err := r.db.NewSelect().
-		Table("submissions").
-		Where("tenant_id = ?", tenantID).
-		Count(ctx)
+		// Count by grade status
+		return db.NewSelect().
+			Table("grades").
+			ColumnExpr("COUNT(CASE WHEN status = 'auto_graded' THEN 1 END) as auto_graded").
+			ColumnExpr("COUNT(CASE WHEN status = 'needs_review' THEN 1 END) as needs_review").
+			ColumnExpr("AVG(confidence) as average_confidence").
+			Join("JOIN submissions ON grades.submission_id = submissions.id").
+			Where("submissions.tenant_id = ?", tenantID).
+			Scan(ctx, stat)
+	})
 	if err != nil {
 		return nil, err
 	}
-	stat.TotalSubmissions = subCount
-
-	// Count by grade status
-	err = r.db.NewSelect().
-		Table("grades").
-		ColumnExpr("COUNT(CASE WHEN status = 'auto_graded' THEN 1 END) as auto_graded").
-		ColumnExpr("COUNT(CASE WHEN status = 'needs_review' THEN 1 END) as needs_review").
-		ColumnExpr("AVG(confidence) as average_confidence").
-		Join("JOIN submissions ON grades.submission_id = submissions.id").
-		Where("submissions.tenant_id = ?", tenantID).
-		Scan(ctx, stat)
-
-	return stat, err
+	return stat, nil
 }
 
 type QuestionStat struct {
@@ -87,21 +159,274 @@ type QuestionStat struct {
 func (r *GradeRepo) GetExamStats(ctx context.Context, examID uuid.UUID) ([]QuestionStat, error) {
 	var stats []QuestionStat
 	// Join grades with questions to filter by exam_id
-	// Note: We need to handle max_score comparison carefully. 
-	// Assuming max_score is in grades table (it is per schema)
-	
-	err := r.db.NewSelect().
-		Table("grades").
-		ColumnExpr("grades.question_id").
-		ColumnExpr("AVG(grades.final_score) as avg_score").
-		ColumnExpr("STDDEV(grades.final_score) as score_variance").
-		ColumnExpr("COUNT(CASE WHEN grades.final_score = 0 THEN 1 END) as zero_scores").
-		ColumnExpr("COUNT(CASE WHEN grades.final_score = grades.max_score THEN 1 END) as perfect_scores").
-		ColumnExpr("COUNT(*) as total_graded").
-		Join("JOIN questions ON grades.question_id = questions.id").
-		Where("questions.exam_id = ?", examID).
-		Group("grades.question_id").
-		Scan(ctx, &stats)
-		
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Table("grades").
+			ColumnExpr("grades.question_id").
+			ColumnExpr("AVG(grades.score) as avg_score").
+			ColumnExpr("STDDEV(grades.score) as score_variance").
+			ColumnExpr("COUNT(CASE WHEN grades.score = 0 THEN 1 END) as zero_scores").
+			ColumnExpr("COUNT(CASE WHEN grades.score = grades.max_score THEN 1 END) as perfect_scores").
+			ColumnExpr("COUNT(*) as total_graded").
+			Join("JOIN questions ON grades.question_id = questions.id").
+			Where("questions.exam_id = ?", examID)
+		return forTenant(ctx, q, "grades."+submissionInTenant).
+			Group("grades.question_id").
+			Scan(ctx, &stats)
+	})
 	return stats, err
 }
+
+// discriminationGroup is the fraction of submissions, by total score, in
+// each of the upper and lower groups a discrimination index compares.
+const discriminationGroup = 0.27
+
+// ExamScoreStat summarises the totals of an exam's graded submissions.
+// Only complete submissions, those with a grade for every question, are
+// analysed; Incomplete counts the rest.
+type ExamScoreStat struct {
+	Submissions   int      `bun:"submissions"`
+	Incomplete    int      `bun:"incomplete"`
+	MeanTotal     *float64 `bun:"mean_total"`
+	TotalVariance *float64 `bun:"total_variance"`
+}
+
+// ItemStat is how an exam's complete submissions did on one question.
+// Facilities are mean fractions of the points awarded; the upper and lower
+// ones are those of the discriminationGroup best and worst submissions by
+// total. PointBiserial correlates the question's score with the total of
+// the rest of the exam.
+type ItemStat struct {
+	QuestionID    uuid.UUID `bun:"question_id"`
+	Responses     int       `bun:"responses"`
+	MeanScore     float64   `bun:"mean_score"`
+	Facility      *float64  `bun:"facility"`
+	ScoreVariance float64   `bun:"score_variance"`
+	UpperFacility *float64  `bun:"upper_facility"`
+	LowerFacility *float64  `bun:"lower_facility"`
+	PointBiserial *float64  `bun:"point_biserial"`
+	ZeroScores    int       `bun:"zero_scores"`
+	FullMarks     int       `bun:"full_marks"`
+}
+
+// CriterionHit counts the complete submissions that met a rubric criterion.
+type CriterionHit struct {
+	QuestionID  uuid.UUID `bun:"question_id"`
+	CriterionID string    `bun:"criterion_id"`
+	Hits        int       `bun:"hits"`
+}
+
+// ItemAnalysisStats is what an exam's item analysis is computed from.
+type ItemAnalysisStats struct {
+	Exam     ExamScoreStat
+	Items    []ItemStat
+	Criteria []CriterionHit
+}
+
+// ItemAnalysis aggregates an exam's grades for item analysis in the
+// database, so that its cost doesn't grow with the submissions read into
+// memory: the totals of its submissions, per question statistics and
+// rubric criterion hits.
+func (r *GradeRepo) ItemAnalysis(ctx context.Context, examID uuid.UUID) (*ItemAnalysisStats, error) {
+	stats := new(ItemAnalysisStats)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		scored := forTenant(ctx, db.NewSelect().
+			TableExpr("grades AS g").
+			ColumnExpr("g.submission_id").
+			ColumnExpr("SUM(g.score) AS total").
+			ColumnExpr("COUNT(*) = (SELECT COUNT(*) FROM questions WHERE exam_id = ?) AS complete", examID).
+			Join("JOIN questions AS q ON q.id = g.question_id").
+			Where("q.exam_id = ?", examID), "g."+submissionInTenant).
+			Group("g.submission_id")
+		ranked := db.NewSelect().
+			TableExpr("scored").
+			ColumnExpr("submission_id, total").
+			ColumnExpr("ROW_NUMBER() OVER (ORDER BY total DESC, submission_id) AS rn").
+			ColumnExpr("COUNT(*) OVER () AS n").
+			Where("complete")
+
+		err := db.NewSelect().
+			With("scored", scored).
+			TableExpr("scored").
+			ColumnExpr("COUNT(*) FILTER (WHERE complete) AS submissions").
+			ColumnExpr("COUNT(*) FILTER (WHERE NOT complete) AS incomplete").
+			ColumnExpr("AVG(total) FILTER (WHERE complete) AS mean_total").
+			ColumnExpr("VAR_POP(total) FILTER (WHERE complete) AS total_variance").
+			Scan(ctx, &stats.Exam)
+		if err != nil {
+			return err
+		}
+
+		err = db.NewSelect().
+			With("scored", scored).
+			With("ranked", ranked).
+			TableExpr("grades AS g").
+			Join("JOIN ranked AS rk ON rk.submission_id = g.submission_id").
+			ColumnExpr("g.question_id").
+			ColumnExpr("COUNT(*) AS responses").
+			ColumnExpr("AVG(g.score) AS mean_score").
+			ColumnExpr("AVG(g.score / NULLIF(g.max_score, 0)) AS facility").
+			ColumnExpr("VAR_POP(g.score) AS score_variance").
+			ColumnExpr("AVG(g.score / NULLIF(g.max_score, 0)) FILTER (WHERE rk.rn <= CEIL(rk.n * ?)) AS upper_facility", discriminationGroup).
+			ColumnExpr("AVG(g.score / NULLIF(g.max_score, 0)) FILTER (WHERE rk.rn > rk.n - CEIL(rk.n * ?)) AS lower_facility", discriminationGroup).
+			ColumnExpr("CORR(g.score::float8, (rk.total - g.score)::float8) AS point_biserial").
+			ColumnExpr("COUNT(*) FILTER (WHERE g.score = 0) AS zero_scores").
+			ColumnExpr("COUNT(*) FILTER (WHERE g.score >= g.max_score) AS full_marks").
+			Group("g.question_id").
+			Scan(ctx, &stats.Items)
+		if err != nil {
+			return err
+		}
+
+		return db.NewSelect().
+			With("scored", scored).
+			TableExpr("grades AS g").
+			Join("JOIN scored AS s ON s.submission_id = g.submission_id AND s.complete").
+			Join("CROSS JOIN LATERAL jsonb_array_elements_text(g.criteria_met) AS met(criterion_id)").
+			ColumnExpr("g.question_id, met.criterion_id").
+			ColumnExpr("COUNT(DISTINCT g.submission_id) AS hits").
+			Where("jsonb_typeof(g.criteria_met) = 'array'").
+			Group("g.question_id", "met.criterion_id").
+			Scan(ctx, &stats.Criteria)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// StudentGradeRow is one graded answer with the exam and rubric context a
+// student profile is built from.
+type StudentGradeRow struct {
+	SubmissionID  uuid.UUID          `bun:"submission_id"`
+	ExamID        uuid.UUID          `bun:"exam_id"`
+	ExamTitle     string             `bun:"exam_title"`
+	Subject       string             `bun:"subject"`
+	SubmittedAt   time.Time          `bun:"uploaded_at"`
+	QuestionID    uuid.UUID          `bun:"question_id"`
+	Score         float64            `bun:"score"`
+	MaxScore      int                `bun:"max_score"`
+	CriteriaMet   []string           `bun:"criteria_met,type:jsonb"`
+	MistakesFound []string           `bun:"mistakes_found,type:jsonb"`
+	Criteria      []domain.Criterion `bun:"full_credit_criteria,type:jsonb"`
+	KeyConcepts   []string           `bun:"key_concepts,type:jsonb"`
+}
+
+// ListForStudent returns every graded answer of a student, oldest first.
+func (r *GradeRepo) ListForStudent(ctx context.Context, tenantID uuid.UUID, studentID string) ([]StudentGradeRow, error) {
+	var rows []StudentGradeRow
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Table("grades").
+			ColumnExpr("grades.submission_id, grades.question_id, grades.score, grades.max_score").
+			ColumnExpr("grades.criteria_met, grades.mistakes_found").
+			ColumnExpr("submissions.exam_id, submissions.uploaded_at").
+			ColumnExpr("exams.title AS exam_title, exams.subject").
+			ColumnExpr("rubrics.full_credit_criteria, rubrics.key_concepts").
+			Join("JOIN submissions ON grades.submission_id = submissions.id").
+			Join("JOIN exams ON submissions.exam_id = exams.id").
+			Join("LEFT JOIN rubrics ON rubrics.question_id = grades.question_id").
+			Where("submissions.tenant_id = ?", tenantID).
+			Where("submissions.student_id = ?", studentID).
+			Where("grades.status != ?", domain.GradeStatusPending).
+			OrderExpr("submissions.uploaded_at ASC").
+			Scan(ctx, &rows)
+	})
+	return rows, err
+}
+
+// SubmissionTotal is a submission's total score over its graded questions.
+type SubmissionTotal struct {
+	SubmissionID uuid.UUID `bun:"submission_id"`
+	StudentID    string    `bun:"student_id"`
+	Total        float64   `bun:"total"`
+	Graded       int       `bun:"graded"`
+}
+
+// SubmissionTotals returns the totals of an exam's graded submissions.
+func (r *GradeRepo) SubmissionTotals(ctx context.Context, examID uuid.UUID) ([]SubmissionTotal, error) {
+	var totals []SubmissionTotal
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			TableExpr("grades AS g").
+			ColumnExpr("g.submission_id, s.student_id").
+			ColumnExpr("SUM(g.score) AS total").
+			ColumnExpr("COUNT(*) AS graded").
+			Join("JOIN submissions AS s ON s.id = g.submission_id").
+			Join("JOIN questions AS q ON q.id = g.question_id").
+			Where("q.exam_id = ?", examID)
+		return forTenant(ctx, q, "s.tenant_id = ?").
+			Group("g.submission_id", "s.student_id").
+			Order("g.submission_id").
+			Scan(ctx, &totals)
+	})
+	return totals, err
+}
+
+// QuestionScoreStat summarises the scores given for a question. Percentiles
+// are interpolated between scores.
+type QuestionScoreStat struct {
+	QuestionID uuid.UUID `bun:"question_id"`
+	Responses  int       `bun:"responses"`
+	Mean       float64   `bun:"mean"`
+	StdDev     float64   `bun:"std_dev"`
+	Min        float64   `bun:"min"`
+	Max        float64   `bun:"max"`
+	P10        float64   `bun:"p10"`
+	P25        float64   `bun:"p25"`
+	P50        float64   `bun:"p50"`
+	P75        float64   `bun:"p75"`
+	P90        float64   `bun:"p90"`
+}
+
+// QuestionScoreBin counts the scores of a question in one of bins equal
+// bins from zero to its points, numbered from 1. Full marks are in the last.
+type QuestionScoreBin struct {
+	QuestionID uuid.UUID `bun:"question_id"`
+	Bin        int       `bun:"bin"`
+	Count      int       `bun:"count"`
+}
+
+// QuestionDistributions summarises the scores given for each of an exam's
+// questions and counts them into bins, without reading them.
+func (r *GradeRepo) QuestionDistributions(ctx context.Context, examID uuid.UUID, bins int) ([]QuestionScoreStat, []QuestionScoreBin, error) {
+	var (
+		stats  []QuestionScoreStat
+		counts []QuestionScoreBin
+	)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			TableExpr("grades AS g").
+			ColumnExpr("g.question_id").
+			ColumnExpr("COUNT(*) AS responses").
+			ColumnExpr("AVG(g.score) AS mean").
+			ColumnExpr("COALESCE(STDDEV_POP(g.score), 0) AS std_dev").
+			ColumnExpr("MIN(g.score) AS min, MAX(g.score) AS max")
+		for _, p := range []int{10, 25, 50, 75, 90} {
+			q = q.ColumnExpr("percentile_cont(?) WITHIN GROUP (ORDER BY g.score) AS ?", float64(p)/100, bun.Ident(fmt.Sprintf("p%d", p)))
+		}
+		q = q.Join("JOIN questions AS q ON q.id = g.question_id").
+			Where("q.exam_id = ?", examID)
+		err := forTenant(ctx, q, "g."+submissionInTenant).
+			Group("g.question_id").
+			Scan(ctx, &stats)
+		if err != nil {
+			return err
+		}
+
+		q = db.NewSelect().
+			TableExpr("grades AS g").
+			ColumnExpr("g.question_id").
+			ColumnExpr("LEAST(GREATEST(width_bucket(g.score, 0, q.points, ?), 1), ?) AS bin", bins, bins).
+			ColumnExpr("COUNT(*) AS count").
+			Join("JOIN questions AS q ON q.id = g.question_id").
+			Where("q.exam_id = ? AND q.points > 0", examID)
+		return forTenant(ctx, q, "g."+submissionInTenant).
+			GroupExpr("g.question_id, bin").
+			Scan(ctx, &counts)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return stats, counts, nil
+}
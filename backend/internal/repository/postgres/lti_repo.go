@@ -0,0 +1,272 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"harama/internal/domain"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// settledGrades are the grade statuses a score can be published with;
+// grades still pending or waiting on review hold a submission's score back.
+var settledGrades = []domain.GradeStatus{
+	domain.GradeStatusAutoGraded,
+	domain.GradeStatusOverridden,
+	domain.GradeStatusFinal,
+}
+
+type LTIRepo struct {
+	db *bun.DB
+}
+
+func NewLTIRepo(db *bun.DB) *LTIRepo {
+	return &LTIRepo{db: db}
+}
+
+func (r *LTIRepo) CreatePlatform(ctx context.Context, p *domain.LTIPlatform) error {
+	_, err := conn(ctx, r.db).NewInsert().Model(p).Exec(ctx)
+	return err
+}
+
+func (r *LTIRepo) ListPlatforms(ctx context.Context, tenantID uuid.UUID) ([]domain.LTIPlatform, error) {
+	var platforms []domain.LTIPlatform
+	err := r.db.NewSelect().
+		Model(&platforms).
+		Where("lp.tenant_id = ?", tenantID).
+		Order("lp.created_at DESC").
+		Scan(ctx)
+	return platforms, err
+}
+
+// GetPlatform reads a platform by ID, whichever tenant registered it.
+func (r *LTIRepo) GetPlatform(ctx context.Context, id uuid.UUID) (*domain.LTIPlatform, error) {
+	p := new(domain.LTIPlatform)
+	err := r.db.NewSelect().
+		Model(p).
+		Where("lp.id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// FindPlatforms returns the platforms registered under an issuer, only the
+// one with clientID when it is set.
+func (r *LTIRepo) FindPlatforms(ctx context.Context, issuer, clientID string) ([]domain.LTIPlatform, error) {
+	var platforms []domain.LTIPlatform
+	q := r.db.NewSelect().
+		Model(&platforms).
+		Where("lp.issuer = ?", issuer)
+	if clientID != "" {
+		q = q.Where("lp.client_id = ?", clientID)
+	}
+	err := q.Scan(ctx)
+	return platforms, err
+}
+
+func (r *LTIRepo) DeletePlatform(ctx context.Context, tenantID, id uuid.UUID) error {
+	res, err := conn(ctx, r.db).NewDelete().
+		Model((*domain.LTIPlatform)(nil)).
+		Where("id = ?", id).
+		Where("tenant_id = ?", tenantID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *LTIRepo) SaveState(ctx context.Context, st *domain.LTILaunchState) error {
+	_, err := r.db.NewInsert().Model(st).Exec(ctx)
+	return err
+}
+
+// TakeState removes and returns a login's state, so it answers one launch
+// only. An expired state is not found.
+func (r *LTIRepo) TakeState(ctx context.Context, state string, now time.Time) (*domain.LTILaunchState, error) {
+	st := new(domain.LTILaunchState)
+	_, err := r.db.NewDelete().
+		Model(st).
+		Where("state = ?", state).
+		Where("expires_at > ?", now).
+		Returning("*").
+		Exec(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	if st.State == "" {
+		return nil, sql.ErrNoRows
+	}
+	return st, nil
+}
+
+// BindLineItem binds a resource link to an exam and line item, rebinding a
+// link launched before; li.ID is the binding's.
+func (r *LTIRepo) BindLineItem(ctx context.Context, li *domain.LTILineItem) error {
+	ctx = withTenant(ctx, li.TenantID)
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().
+			Model(li).
+			On("CONFLICT (platform_id, resource_link_id) DO UPDATE").
+			Set("exam_id = EXCLUDED.exam_id").
+			Set("line_item_url = EXCLUDED.line_item_url").
+			Returning("id").
+			Exec(ctx)
+		return err
+	})
+}
+
+// ListLineItems returns the line items an exam of the tenant in ctx is
+// bound to.
+func (r *LTIRepo) ListLineItems(ctx context.Context, examID uuid.UUID) ([]domain.LTILineItem, error) {
+	var items []domain.LTILineItem
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&items).
+			Where("lli.exam_id = ?", examID)
+		return forTenant(ctx, q, "lli.tenant_id = ?").
+			Order("lli.created_at ASC").
+			Scan(ctx)
+	})
+	return items, err
+}
+
+func (r *LTIRepo) GetLineItem(ctx context.Context, id uuid.UUID) (*domain.LTILineItem, error) {
+	li := new(domain.LTILineItem)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(li).
+			Where("lli.id = ?", id)
+		return forTenant(ctx, q, "lli.tenant_id = ?").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return li, nil
+}
+
+// LinkUser maps a platform's user to a student, replacing an earlier
+// mapping of the user; link.ID is the mapping's.
+func (r *LTIRepo) LinkUser(ctx context.Context, link *domain.LTIUserLink) error {
+	ctx = withTenant(ctx, link.TenantID)
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().
+			Model(link).
+			On("CONFLICT (platform_id, lms_user_id) DO UPDATE").
+			Set("student_id = EXCLUDED.student_id").
+			Returning("id").
+			Exec(ctx)
+		return err
+	})
+}
+
+// QueueSubmission queues the total of a graded submission for each line
+// item its exam is bound to on a platform its student is mapped on. It
+// queues nothing until the submission is graded and every grade of it has
+// settled.
+func (r *LTIRepo) QueueSubmission(ctx context.Context, submissionID uuid.UUID) (int, error) {
+	return r.queueScores(ctx, "s.id = ?", submissionID)
+}
+
+// QueueLineItem queues the settled totals of a newly bound line item.
+func (r *LTIRepo) QueueLineItem(ctx context.Context, lineItemID uuid.UUID) (int, error) {
+	return r.queueScores(ctx, "li.id = ?", lineItemID)
+}
+
+// QueueUser queues the settled totals of a newly mapped user.
+func (r *LTIRepo) QueueUser(ctx context.Context, linkID uuid.UUID) (int, error) {
+	return r.queueScores(ctx, "ul.id = ?", linkID)
+}
+
+// QueueExam queues the settled totals of an exam's submissions, as when its
+// curve changes.
+func (r *LTIRepo) QueueExam(ctx context.Context, examID uuid.UUID) (int, error) {
+	return r.queueScores(ctx, "s.exam_id = ?", examID)
+}
+
+// queueScores queues the totals of the submissions matching cond. A total
+// the exam's active curve covers is given as curved, keeping the raw total
+// and the curve's version alongside; see domain.GradeCurve.CurvedTotal.
+func (r *LTIRepo) queueScores(ctx context.Context, cond string, arg interface{}) (int, error) {
+	var queued int
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		totals := db.NewSelect().
+			TableExpr("submissions AS s").
+			ColumnExpr("li.tenant_id, li.id, s.id, ul.lms_user_id").
+			ColumnExpr("CASE WHEN abs(cs.raw_total - SUM(g.score)) < 0.005 THEN cs.adjusted_total ELSE SUM(g.score) END").
+			ColumnExpr("SUM(g.max_score), SUM(g.score)").
+			ColumnExpr("CASE WHEN abs(cs.raw_total - SUM(g.score)) < 0.005 THEN gc.version END").
+			ColumnExpr("?, current_timestamp", domain.ScorePushPending).
+			Join("JOIN lti_line_items AS li ON li.exam_id = s.exam_id AND li.tenant_id = s.tenant_id").
+			Join("JOIN lti_user_links AS ul ON ul.platform_id = li.platform_id AND ul.student_id = s.student_id").
+			Join("JOIN grades AS g ON g.submission_id = s.id").
+			Join("LEFT JOIN grade_curves AS gc ON gc.exam_id = s.exam_id AND gc.active").
+			Join("LEFT JOIN LATERAL jsonb_to_recordset(gc.scores) AS cs(submission_id uuid, raw_total numeric, adjusted_total numeric) ON cs.submission_id = s.id").
+			Where(cond, arg).
+			Where("s.processing_status = ?", domain.StatusCompleted).
+			GroupExpr("li.tenant_id, li.id, s.id, ul.lms_user_id, gc.version, cs.raw_total, cs.adjusted_total").
+			Having("bool_and(g.status IN (?))", bun.In(settledGrades))
+		totals = forTenant(ctx, totals, "s.tenant_id = ?")
+		res, err := db.NewRaw(
+			"INSERT INTO lti_score_pushes (tenant_id, line_item_id, submission_id, lms_user_id, score_given, score_maximum, raw_score, curve_version, status, next_attempt_at) ?",
+			totals,
+		).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		queued = int(n)
+		return nil
+	})
+	return queued, err
+}
+
+// ClaimDue takes up to limit pending pushes that are due, across tenants,
+// and leases them like WebhookRepo.ClaimDue does deliveries.
+func (r *LTIRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.LTIScorePush, error) {
+	var pushes []domain.LTIScorePush
+	due := r.db.NewSelect().
+		Model((*domain.LTIScorePush)(nil)).
+		Column("id").
+		Where("status = ?", domain.ScorePushPending).
+		Where("next_attempt_at <= ?", now).
+		Order("next_attempt_at ASC").
+		Limit(limit).
+		For("UPDATE SKIP LOCKED")
+	_, err := r.db.NewUpdate().
+		Model((*domain.LTIScorePush)(nil)).
+		Set("next_attempt_at = ?", now.Add(lease)).
+		Where("id IN (?)", due).
+		Returning("*").
+		Exec(ctx, &pushes)
+	return pushes, err
+}
+
+// RecordAttempt saves the outcome of an attempt to publish a score.
+func (r *LTIRepo) RecordAttempt(ctx context.Context, p *domain.LTIScorePush) error {
+	_, err := conn(ctx, r.db).NewUpdate().
+		Model(p).
+		Column("status", "attempts", "next_attempt_at", "last_attempt_at", "response_status", "last_error", "pushed_at").
+		WherePK().
+		Exec(ctx)
+	return err
+}
+
+// ListScorePushes returns the tenant's latest score pushes, newest first.
+func (r *LTIRepo) ListScorePushes(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.LTIScorePush, error) {
+	var pushes []domain.LTIScorePush
+	err := r.db.NewSelect().
+		Model(&pushes).
+		Where("lsp.tenant_id = ?", tenantID).
+		Order("lsp.created_at DESC").
+		Limit(limit).
+		Scan(ctx)
+	return pushes, err
+}
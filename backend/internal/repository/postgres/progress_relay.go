@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// progressChannel is the NOTIFY channel progress events travel on.
+const progressChannel = "harama_progress"
+
+// ProgressRelay carries progress events between the API and worker
+// processes with LISTEN/NOTIFY, so a stream served by one process sees the
+// work done in another.
+type ProgressRelay struct {
+	db *bun.DB
+}
+
+func NewProgressRelay(db *bun.DB) *ProgressRelay {
+	return &ProgressRelay{db: db}
+}
+
+// Send notifies every listening process, this one included. It is sent
+// outside any transaction in ctx, straight away.
+func (r *ProgressRelay) Send(ctx context.Context, payload []byte) error {
+	return pgdriver.Notify(ctx, r.db, progressChannel, string(payload))
+}
+
+// Listen passes each payload sent to receive until ctx is done or the
+// listening connection is lost.
+func (r *ProgressRelay) Listen(ctx context.Context, receive func(payload []byte)) error {
+	ln := pgdriver.NewListener(r.db)
+	// Receive doesn't watch ctx; closing the listener ends it
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer func() {
+		if stop() {
+			ln.Close()
+		}
+	}()
+	if err := ln.Listen(ctx, progressChannel); err != nil {
+		return err
+	}
+	for {
+		_, payload, err := ln.Receive(ctx)
+		if err != nil {
+			return err
+		}
+		receive([]byte(payload))
+	}
+}
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type QuestionPaperRepo struct {
+	db *bun.DB
+}
+
+func NewQuestionPaperRepo(db *bun.DB) *QuestionPaperRepo {
+	return &QuestionPaperRepo{db: db}
+}
+
+func (r *QuestionPaperRepo) Create(ctx context.Context, p *domain.QuestionPaper) error {
+	ctx = withTenant(ctx, p.TenantID)
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(p).Exec(ctx)
+		return err
+	})
+}
+
+func (r *QuestionPaperRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.QuestionPaper, error) {
+	p := new(domain.QuestionPaper)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(p).
+			Where("qp.id = ?", id)
+		return forTenant(ctx, q, "qp.tenant_id = ?").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ListByExam returns the question papers uploaded to an exam, newest first.
+func (r *QuestionPaperRepo) ListByExam(ctx context.Context, examID uuid.UUID) ([]domain.QuestionPaper, error) {
+	var ps []domain.QuestionPaper
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&ps).
+			Where("qp.exam_id = ?", examID)
+		return forTenant(ctx, q, "qp.tenant_id = ?").
+			Order("qp.created_at DESC").
+			Scan(ctx)
+	})
+	return ps, err
+}
+
+// Save writes a question paper's progress: its status, its draft or error
+// and, once confirmed, who confirmed it.
+func (r *QuestionPaperRepo) Save(ctx context.Context, p *domain.QuestionPaper) error {
+	return InTenantTx(withTenant(ctx, p.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model(p).
+			Column("status", "draft", "error", "confirmed_by", "confirmed_at").
+			WherePK()
+		res, err := forTenant(ctx, q, "tenant_id = ?").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
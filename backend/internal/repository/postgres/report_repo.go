@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type ReportRepo struct {
+	db *bun.DB
+}
+
+func NewReportRepo(db *bun.DB) *ReportRepo {
+	return &ReportRepo{db: db}
+}
+
+func (r *ReportRepo) Create(ctx context.Context, rep *domain.Report) error {
+	ctx = withTenant(ctx, rep.TenantID)
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(rep).Exec(ctx)
+		return err
+	})
+}
+
+func (r *ReportRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Report, error) {
+	rep := new(domain.Report)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(rep).
+			Where("rp.id = ?", id)
+		return forTenant(ctx, q, "rp.tenant_id = ?").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// ListByExam returns the reports of an exam, its own and its submissions',
+// newest first.
+func (r *ReportRepo) ListByExam(ctx context.Context, examID uuid.UUID) ([]domain.Report, error) {
+	var reps []domain.Report
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&reps).
+			Where("rp.exam_id = ?", examID)
+		return forTenant(ctx, q, "rp.tenant_id = ?").
+			Order("rp.created_at DESC").
+			Scan(ctx)
+	})
+	return reps, err
+}
+
+// Save writes a report's progress: its status and, once it is done, its
+// file or error.
+func (r *ReportRepo) Save(ctx context.Context, rep *domain.Report) error {
+	return InTenantTx(withTenant(ctx, rep.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model(rep).
+			Column("status", "object_name", "size_bytes", "included", "error", "completed_at").
+			WherePK()
+		res, err := forTenant(ctx, q, "tenant_id = ?").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
@@ -0,0 +1,351 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type RosterRepo struct {
+	db *bun.DB
+}
+
+func NewRosterRepo(db *bun.DB) *RosterRepo {
+	return &RosterRepo{db: db}
+}
+
+func (r *RosterRepo) CreateClass(ctx context.Context, class *domain.Class) error {
+	return InTenantTx(withTenant(ctx, class.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(class).Exec(ctx)
+		return err
+	})
+}
+
+func (r *RosterRepo) ListClasses(ctx context.Context, tenantID uuid.UUID) ([]domain.Class, error) {
+	var classes []domain.Class
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(&classes).
+			Where("tenant_id = ?", tenantID).
+			Order("name ASC").
+			Scan(ctx)
+	})
+	return classes, err
+}
+
+// GetClass returns a class of the tenant with its enrolled students.
+func (r *RosterRepo) GetClass(ctx context.Context, tenantID, classID uuid.UUID) (*domain.Class, error) {
+	class := new(domain.Class)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		err := db.NewSelect().
+			Model(class).
+			Where("id = ?", classID).
+			Where("tenant_id = ?", tenantID).
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		return db.NewSelect().
+			Model(&class.Students).
+			Join("JOIN enrollments AS en ON en.student_id = st.id").
+			Where("en.class_id = ?", classID).
+			Where("en.tenant_id = ?", tenantID).
+			Order("st.name ASC").
+			Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+func (r *RosterRepo) CreateStudent(ctx context.Context, student *domain.Student) error {
+	return InTenantTx(withTenant(ctx, student.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(student).Exec(ctx)
+		return err
+	})
+}
+
+func (r *RosterRepo) ListStudents(ctx context.Context, tenantID uuid.UUID) ([]domain.Student, error) {
+	var students []domain.Student
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(&students).
+			Where("tenant_id = ?", tenantID).
+			Order("name ASC").
+			Scan(ctx)
+	})
+	return students, err
+}
+
+// ImportRoster upserts students by external ID and enrols them in the class
+// in one transaction. With replace set, students of the class who are not in
+// the import are unenrolled (but kept on the tenant's roster).
+func (r *RosterRepo) ImportRoster(ctx context.Context, tenantID, classID uuid.UUID, students []domain.Student, replace bool) (*domain.RosterImportResult, error) {
+	result := &domain.RosterImportResult{Errors: []domain.RosterImportError{}}
+
+	// Scoping to the tenant runs the import in a single transaction
+	err := InTenantTx(withTenant(ctx, tenantID), r.db, func(ctx context.Context, tx bun.IDB) error {
+		keep := make([]uuid.UUID, 0, len(students))
+		for i := range students {
+			st := &students[i]
+			st.TenantID = tenantID
+
+			existing := new(domain.Student)
+			err := tx.NewSelect().
+				Model(existing).
+				Where("tenant_id = ?", tenantID).
+				Where("external_id = ?", st.ExternalID).
+				Scan(ctx)
+			switch {
+			case err == nil:
+				st.ID = existing.ID
+				if existing.Name != st.Name || existing.Email != st.Email {
+					_, err = tx.NewUpdate().
+						Model(st).
+						Column("name", "email").
+						WherePK().
+						Exec(ctx)
+					if err != nil {
+						return err
+					}
+					result.Updated++
+				}
+			case err == sql.ErrNoRows:
+				if _, err := tx.NewInsert().Model(st).Returning("id").Exec(ctx); err != nil {
+					return err
+				}
+				result.Created++
+			default:
+				return err
+			}
+
+			res, err := tx.NewInsert().
+				Model(&domain.Enrollment{ClassID: classID, StudentID: st.ID, TenantID: tenantID}).
+				On("CONFLICT (class_id, student_id) DO NOTHING").
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				result.Enrolled++
+			}
+			keep = append(keep, st.ID)
+		}
+
+		if replace {
+			q := tx.NewDelete().
+				Model((*domain.Enrollment)(nil)).
+				Where("class_id = ?", classID).
+				Where("tenant_id = ?", tenantID)
+			if len(keep) > 0 {
+				q = q.Where("student_id NOT IN (?)", bun.In(keep))
+			}
+			res, err := q.Exec(ctx)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			result.Removed = int(n)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AssignExam adds classes to an exam; existing assignments are kept.
+func (r *RosterRepo) AssignExam(ctx context.Context, tenantID, examID uuid.UUID, classIDs []uuid.UUID) error {
+	if len(classIDs) == 0 {
+		return nil
+	}
+	rows := make([]domain.ExamClass, len(classIDs))
+	for i, id := range classIDs {
+		rows[i] = domain.ExamClass{ExamID: examID, ClassID: id, TenantID: tenantID}
+	}
+	return InTenantTx(withTenant(ctx, tenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().
+			Model(&rows).
+			On("CONFLICT (exam_id, class_id) DO NOTHING").
+			Exec(ctx)
+		return err
+	})
+}
+
+func (r *RosterRepo) ListExamClasses(ctx context.Context, examID uuid.UUID) ([]domain.Class, error) {
+	var classes []domain.Class
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&classes).
+			Join("JOIN exam_classes AS ec ON ec.class_id = c.id").
+			Where("ec.exam_id = ?", examID)
+		return forTenant(ctx, q, "ec.tenant_id = ?").
+			Order("c.name ASC").
+			Scan(ctx)
+	})
+	return classes, err
+}
+
+// EnrolledForExam reports whether the exam is assigned to any class and, if
+// so, whether the student is enrolled in one of them.
+func (r *RosterRepo) EnrolledForExam(ctx context.Context, examID uuid.UUID, externalID string) (assigned bool, enrolled bool, err error) {
+	err = InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			ColumnExpr("COUNT(*) > 0 AS assigned").
+			ColumnExpr("COUNT(st.id) > 0 AS enrolled").
+			TableExpr("exam_classes AS ec").
+			Join("LEFT JOIN enrollments AS en ON en.class_id = ec.class_id").
+			Join("LEFT JOIN students AS st ON st.id = en.student_id AND st.external_id = ?", externalID).
+			Where("ec.exam_id = ?", examID)
+		return forTenant(ctx, q, "ec.tenant_id = ?").Scan(ctx, &assigned, &enrolled)
+	})
+	return assigned, enrolled, err
+}
+
+// MissingSubmissions lists students enrolled in the exam's classes, or in
+// one of them when classID is set, who have no submission for the exam.
+func (r *RosterRepo) MissingSubmissions(ctx context.Context, examID uuid.UUID, classID *uuid.UUID) ([]domain.MissingSubmission, error) {
+	var missing []domain.MissingSubmission
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			ColumnExpr("c.id AS class_id, c.name AS class_name").
+			ColumnExpr("st.id AS student_id, st.external_id, st.name, st.email").
+			TableExpr("exam_classes AS ec").
+			Join("JOIN classes AS c ON c.id = ec.class_id").
+			Join("JOIN enrollments AS en ON en.class_id = c.id").
+			Join("JOIN students AS st ON st.id = en.student_id").
+			Join("LEFT JOIN submissions AS s ON s.exam_id = ec.exam_id AND s.student_id = st.external_id AND s.tenant_id = st.tenant_id").
+			Where("ec.exam_id = ?", examID).
+			Where("s.id IS NULL")
+		if classID != nil {
+			q = q.Where("c.id = ?", *classID)
+		}
+		return forTenant(ctx, q, "ec.tenant_id = ?").OrderExpr("c.name ASC, st.name ASC").Scan(ctx, &missing)
+	})
+	return missing, err
+}
+
+// StudentClass pairs a student's external ID with a class they are in.
+type StudentClass struct {
+	ExternalID string    `bun:"external_id"`
+	ClassID    uuid.UUID `bun:"class_id"`
+	ClassName  string    `bun:"class_name"`
+}
+
+// ExamStudentClasses returns, for an exam, which of its classes each
+// enrolled student belongs to.
+func (r *RosterRepo) ExamStudentClasses(ctx context.Context, examID uuid.UUID) ([]StudentClass, error) {
+	var rows []StudentClass
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			ColumnExpr("st.external_id, c.id AS class_id, c.name AS class_name").
+			TableExpr("exam_classes AS ec").
+			Join("JOIN classes AS c ON c.id = ec.class_id").
+			Join("JOIN enrollments AS en ON en.class_id = c.id").
+			Join("JOIN students AS st ON st.id = en.student_id").
+			Where("ec.exam_id = ?", examID)
+		return forTenant(ctx, q, "ec.tenant_id = ?").
+			OrderExpr("c.name ASC").
+			Scan(ctx, &rows)
+	})
+	return rows, err
+}
+
+// ClassStat summarises one class's results on an exam.
+type ClassStat struct {
+	ClassID    uuid.UUID `bun:"class_id" json:"class_id"`
+	ClassName  string    `bun:"class_name" json:"class_name"`
+	Enrolled   int       `bun:"enrolled" json:"enrolled"`
+	Submitted  int       `bun:"submitted" json:"submitted"`
+	TotalScore float64   `bun:"total_score" json:"-"`
+	TotalMax   float64   `bun:"total_max" json:"-"`
+	AvgPercent float64   `bun:"-" json:"avg_percent"`
+}
+
+func (r *RosterRepo) ExamClassStats(ctx context.Context, examID uuid.UUID) ([]ClassStat, error) {
+	var stats []ClassStat
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			ColumnExpr("c.id AS class_id, c.name AS class_name").
+			ColumnExpr("COUNT(DISTINCT en.student_id) AS enrolled").
+			ColumnExpr("COUNT(DISTINCT s.id) AS submitted").
+			ColumnExpr("COALESCE(SUM(g.score), 0) AS total_score").
+			ColumnExpr("COALESCE(SUM(g.max_score), 0) AS total_max").
+			TableExpr("exam_classes AS ec").
+			Join("JOIN classes AS c ON c.id = ec.class_id").
+			Join("JOIN enrollments AS en ON en.class_id = c.id").
+			Join("JOIN students AS st ON st.id = en.student_id").
+			Join("LEFT JOIN submissions AS s ON s.exam_id = ec.exam_id AND s.student_id = st.external_id AND s.tenant_id = st.tenant_id").
+			Join("LEFT JOIN grades AS g ON g.submission_id = s.id").
+			Where("ec.exam_id = ?", examID)
+		return forTenant(ctx, q, "ec.tenant_id = ?").
+			GroupExpr("c.id, c.name").
+			OrderExpr("c.name ASC").
+			Scan(ctx, &stats)
+	})
+	for i := range stats {
+		if stats[i].TotalMax > 0 {
+			stats[i].AvgPercent = stats[i].TotalScore / stats[i].TotalMax * 100
+		}
+	}
+	return stats, err
+}
+
+// AssignTeachers makes users teachers of a class; existing assignments are
+// kept.
+func (r *RosterRepo) AssignTeachers(ctx context.Context, tenantID, classID uuid.UUID, userIDs []uuid.UUID) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	rows := make([]domain.ClassTeacher, len(userIDs))
+	for i, id := range userIDs {
+		rows[i] = domain.ClassTeacher{ClassID: classID, UserID: id, TenantID: tenantID}
+	}
+	return InTenantTx(withTenant(ctx, tenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().
+			Model(&rows).
+			On("CONFLICT (class_id, user_id) DO NOTHING").
+			Exec(ctx)
+		return err
+	})
+}
+
+// TeachesSubmission reports whether the submission's exam is assigned to any
+// class and, if so, whether the user teaches a class that both sits the exam
+// and has the submitting student enrolled.
+func (r *RosterRepo) TeachesSubmission(ctx context.Context, userID, submissionID uuid.UUID) (assigned bool, teaches bool, err error) {
+	err = InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			ColumnExpr("COUNT(ec.class_id) > 0 AS assigned").
+			ColumnExpr("COUNT(ct.user_id) > 0 AS teaches").
+			TableExpr("submissions AS s").
+			Join("LEFT JOIN exam_classes AS ec ON ec.exam_id = s.exam_id").
+			Join("LEFT JOIN enrollments AS en ON en.class_id = ec.class_id").
+			Join("LEFT JOIN students AS st ON st.id = en.student_id AND st.external_id = s.student_id").
+			Join("LEFT JOIN class_teachers AS ct ON ct.class_id = ec.class_id AND ct.user_id = ? AND st.id IS NOT NULL", userID).
+			Where("s.id = ?", submissionID)
+		return forTenant(ctx, q, "s.tenant_id = ?").Scan(ctx, &assigned, &teaches)
+	})
+	return assigned, teaches, err
+}
+
+// TeachesExam reports whether the exam is assigned to any class and, if so,
+// whether the user teaches every class it is assigned to.
+func (r *RosterRepo) TeachesExam(ctx context.Context, userID, examID uuid.UUID) (assigned bool, teaches bool, err error) {
+	err = InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			ColumnExpr("COUNT(ec.class_id) > 0 AS assigned").
+			ColumnExpr("COUNT(ct.user_id) = COUNT(ec.class_id) AS teaches").
+			TableExpr("exam_classes AS ec").
+			Join("LEFT JOIN class_teachers AS ct ON ct.class_id = ec.class_id AND ct.user_id = ?", userID).
+			Where("ec.exam_id = ?", examID)
+		return forTenant(ctx, q, "ec.tenant_id = ?").Scan(ctx, &assigned, &teaches)
+	})
+	return assigned, teaches, err
+}
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type RubricProposalRepo struct {
+	db *bun.DB
+}
+
+func NewRubricProposalRepo(db *bun.DB) *RubricProposalRepo {
+	return &RubricProposalRepo{db: db}
+}
+
+func (r *RubricProposalRepo) Create(ctx context.Context, p *domain.RubricProposal) error {
+	ctx = withTenant(ctx, p.TenantID)
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(p).Exec(ctx)
+		return err
+	})
+}
+
+func (r *RubricProposalRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.RubricProposal, error) {
+	p := new(domain.RubricProposal)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(p).
+			Where("rbp.id = ?", id)
+		return forTenant(ctx, q, "rbp.tenant_id = ?").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ListByQuestion returns the proposals for a question, newest first.
+func (r *RubricProposalRepo) ListByQuestion(ctx context.Context, questionID uuid.UUID) ([]domain.RubricProposal, error) {
+	var ps []domain.RubricProposal
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&ps).
+			Where("rbp.question_id = ?", questionID)
+		return forTenant(ctx, q, "rbp.tenant_id = ?").
+			Order("rbp.created_at DESC").
+			Scan(ctx)
+	})
+	return ps, err
+}
+
+// Save writes a proposal's edited rubric and, once it is decided, the
+// decision.
+func (r *RubricProposalRepo) Save(ctx context.Context, p *domain.RubricProposal) error {
+	return InTenantTx(withTenant(ctx, p.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model(p).
+			Column("status", "rubric", "decided_by", "decided_at").
+			WherePK()
+		res, err := forTenant(ctx, q, "tenant_id = ?").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
 	"harama/internal/domain"
 
 	"github.com/google/uuid"
@@ -16,17 +17,34 @@ func NewSubmissionRepo(db *bun.DB) *SubmissionRepo {
 	return &SubmissionRepo{db: db}
 }
 
+// Create stores a submission for an exam of the submission's tenant; an
+// exam of another tenant is reported as not found.
 func (r *SubmissionRepo) Create(ctx context.Context, sub *domain.Submission) error {
-	_, err := r.db.NewInsert().Model(sub).Exec(ctx)
-	return err
+	return InTenantTx(withTenant(ctx, sub.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		exists, err := db.NewSelect().
+			Model((*domain.Exam)(nil)).
+			Where("e.id = ?", sub.ExamID).
+			Where("e.tenant_id = ?", sub.TenantID).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		_, err = db.NewInsert().Model(sub).Exec(ctx)
+		return err
+	})
 }
 
 func (r *SubmissionRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Submission, error) {
 	sub := new(domain.Submission)
-	err := r.db.NewSelect().
-		Model(sub).
-		Where("id = ?", id).
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(sub).
+			Where("s.id = ?", id)
+		return forTenant(ctx, q, "s.tenant_id = ?").Scan(ctx)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -34,42 +52,62 @@ func (r *SubmissionRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Sub
 }
 
 func (r *SubmissionRepo) UpdateStatus(ctx context.Context, id uuid.UUID, status domain.ProcessingStatus) error {
-	_, err := r.db.NewUpdate().
-		Model((*domain.Submission)(nil)).
-		Set("processing_status = ?", status).
-		Where("id = ?", id).
-		Exec(ctx)
-	return err
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model((*domain.Submission)(nil)).
+			Set("processing_status = ?", status).
+			Where("id = ?", id)
+		_, err := forTenant(ctx, q, "tenant_id = ?").Exec(ctx)
+		return err
+	})
 }
 
 func (r *SubmissionRepo) SaveOCRResults(ctx context.Context, id uuid.UUID, results []domain.OCRResult) error {
-	_, err := r.db.NewUpdate().
-		Model((*domain.Submission)(nil)).
-		Set("ocr_results = ?", results).
-		Where("id = ?", id).
-		Exec(ctx)
-	return err
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model((*domain.Submission)(nil)).
+			Set("ocr_results = ?", results).
+			Where("id = ?", id)
+		_, err := forTenant(ctx, q, "tenant_id = ?").Exec(ctx)
+		return err
+	})
+}
+
+// SaveAnswers records the answers found in a submission's pages.
+func (r *SubmissionRepo) SaveAnswers(ctx context.Context, id uuid.UUID, answers []domain.AnswerSegment) error {
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewUpdate().
+			Model((*domain.Submission)(nil)).
+			Set("answers = ?", answers).
+			Where("id = ?", id)
+		_, err := forTenant(ctx, q, "tenant_id = ?").Exec(ctx)
+		return err
+	})
 }
 
 func (r *SubmissionRepo) ListByExam(ctx context.Context, examID uuid.UUID) ([]domain.Submission, error) {
 	var subs []domain.Submission
-	err := r.db.NewSelect().
-		Model(&subs).
-		Where("exam_id = ?", examID).
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(&subs).
+			Where("exam_id = ?", examID)
+		return forTenant(ctx, q, "tenant_id = ?").Scan(ctx)
+	})
 	return subs, err
 }
 
 func (r *SubmissionRepo) ListPendingReviews(ctx context.Context, tenantID uuid.UUID) ([]domain.Submission, error) {
 	var subs []domain.Submission
 	// Find submissions that belong to the tenant AND have at least one grade with status 'needs_review'
-	err := r.db.NewSelect().
-		Model(&subs).
-		Join("JOIN grades ON grades.submission_id = submission.id").
-		Where("submission.tenant_id = ?", tenantID).
-		Where("grades.status = ?", domain.GradeStatusReview).
-		Group("submission.id").
-		Order("submission.uploaded_at DESC").
-		Scan(ctx)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(&subs).
+			Join("JOIN grades ON grades.submission_id = s.id").
+			Where("s.tenant_id = ?", tenantID).
+			Where("grades.status = ?", domain.GradeStatusReview).
+			Group("s.id").
+			Order("s.uploaded_at DESC").
+			Scan(ctx)
+	})
 	return subs, err
 }
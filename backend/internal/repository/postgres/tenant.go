@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"context"
+
+	"harama/internal/auth"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type tenantTxKey struct{}
+
+// tenantTx is the transaction repository calls made with a ctx join, and the
+// tenant it is scoped to (uuid.Nil until it is).
+type tenantTx struct {
+	tx       bun.Tx
+	tenantID uuid.UUID
+}
+
+// InTenantTx runs fn in a transaction scoped to the tenant in ctx:
+// app.current_tenant is set for its duration, so row level security applies
+// to every statement. fn gets the transaction, and repository calls made
+// with the ctx passed to fn join it. Without a tenant in ctx (migrations,
+// tests) fn runs on the pool, where forced row level security hides tenant
+// data.
+func InTenantTx(ctx context.Context, db *bun.DB, fn func(ctx context.Context, tx bun.IDB) error) error {
+	if cur, ok := ctx.Value(tenantTxKey{}).(*tenantTx); ok {
+		return cur.join(ctx, fn)
+	}
+	if _, err := auth.GetTenantID(ctx); err != nil {
+		return fn(ctx, db)
+	}
+	return runInTx(ctx, db, fn)
+}
+
+// InTx is InTenantTx for work that must be atomic: it opens a transaction
+// even without a tenant in ctx. Such a transaction is scoped to the tenant
+// of the first call in it that has one.
+func InTx(ctx context.Context, db *bun.DB, fn func(ctx context.Context, tx bun.IDB) error) error {
+	if cur, ok := ctx.Value(tenantTxKey{}).(*tenantTx); ok {
+		return cur.join(ctx, fn)
+	}
+	return runInTx(ctx, db, fn)
+}
+
+func runInTx(ctx context.Context, db *bun.DB, fn func(ctx context.Context, tx bun.IDB) error) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		cur := &tenantTx{tx: tx}
+		return cur.join(context.WithValue(ctx, tenantTxKey{}, cur), fn)
+	})
+}
+
+// join runs fn in the transaction, scoping it to the tenant in ctx first if
+// it is not scoped yet. A transaction already scoped to another tenant keeps
+// its tenant, so row level security rejects the other tenant's rows.
+func (t *tenantTx) join(ctx context.Context, fn func(ctx context.Context, tx bun.IDB) error) error {
+	if tenantID, err := auth.GetTenantID(ctx); err == nil && t.tenantID == uuid.Nil {
+		if _, err := t.tx.ExecContext(ctx, "SELECT set_config('app.current_tenant', ?, true)", tenantID.String()); err != nil {
+			return err
+		}
+		t.tenantID = tenantID
+	}
+	return fn(ctx, t.tx)
+}
+
+// conn is the transaction in ctx if there is one, else db. Tables outside
+// row level security use it to join a transaction without scoping one.
+func conn(ctx context.Context, db *bun.DB) bun.IDB {
+	if cur, ok := ctx.Value(tenantTxKey{}).(*tenantTx); ok {
+		return cur.tx
+	}
+	return db
+}
+
+// withTenant scopes ctx to the tenant that owns a record being written,
+// unless it is already scoped. A ctx scoped to another tenant is kept, so
+// row level security rejects the write.
+func withTenant(ctx context.Context, tenantID uuid.UUID) context.Context {
+	if _, err := auth.GetTenantID(ctx); err == nil || tenantID == uuid.Nil {
+		return ctx
+	}
+	return auth.WithTenantID(ctx, tenantID)
+}
+
+type whereQuery[Q any] interface {
+	Where(query string, args ...interface{}) Q
+}
+
+// forTenant adds cond, a condition with a single placeholder for the tenant
+// ID, when ctx carries a tenant. It restates in SQL what row level security
+// enforces, so isolation holds even where the database role bypasses it.
+func forTenant[Q whereQuery[Q]](ctx context.Context, q Q, cond string) Q {
+	if tenantID, err := auth.GetTenantID(ctx); err == nil {
+		return q.Where(cond, tenantID)
+	}
+	return q
+}
+
+// Tenant conditions for tables that reach their tenant through a parent
+const (
+	examInTenant       = "exam_id IN (SELECT id FROM exams WHERE tenant_id = ?)"
+	submissionInTenant = "submission_id IN (SELECT id FROM submissions WHERE tenant_id = ?)"
+	questionInTenant   = "question_id IN (SELECT q.id FROM questions q JOIN exams e ON e.id = q.exam_id WHERE e.tenant_id = ?)"
+)
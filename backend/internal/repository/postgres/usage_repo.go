@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"harama/internal/domain"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type UsageRepo struct {
+	db *bun.DB
+}
+
+func NewUsageRepo(db *bun.DB) *UsageRepo {
+	return &UsageRepo{db: db}
+}
+
+func (r *UsageRepo) Save(ctx context.Context, usage *domain.AIUsage) error {
+	return InTenantTx(withTenant(ctx, usage.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(usage).Exec(ctx)
+		return err
+	})
+}
+
+// SpentSince returns the tenant's total estimated cost since the given time.
+func (r *UsageRepo) SpentSince(ctx context.Context, tenantID uuid.UUID, since time.Time) (float64, error) {
+	var total float64
+	err := InTenantTx(withTenant(ctx, tenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model((*domain.AIUsage)(nil)).
+			ColumnExpr("COALESCE(SUM(cost_usd), 0)").
+			Where("tenant_id = ?", tenantID).
+			Where("created_at >= ?", since).
+			Scan(ctx, &total)
+	})
+	return total, err
+}
+
+type UsageFilter struct {
+	TenantID     uuid.UUID
+	ExamID       *uuid.UUID
+	SubmissionID *uuid.UUID
+	From         *time.Time
+	To           *time.Time
+}
+
+type UsageSummary struct {
+	Task         string  `bun:"task" json:"task"`
+	Provider     string  `bun:"provider" json:"provider"`
+	Model        string  `bun:"model" json:"model"`
+	Calls        int     `bun:"calls" json:"calls"`
+	Failures     int     `bun:"failures" json:"failures"`
+	InputTokens  int64   `bun:"input_tokens" json:"input_tokens"`
+	OutputTokens int64   `bun:"output_tokens" json:"output_tokens"`
+	AvgLatencyMs float64 `bun:"avg_latency_ms" json:"avg_latency_ms"`
+	CostUSD      float64 `bun:"cost_usd" json:"cost_usd"`
+}
+
+// Summarize aggregates usage by task, provider and model.
+func (r *UsageRepo) Summarize(ctx context.Context, f UsageFilter) ([]UsageSummary, error) {
+	var rows []UsageSummary
+	err := InTenantTx(withTenant(ctx, f.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		return summarize(ctx, db, f, &rows)
+	})
+	return rows, err
+}
+
+func summarize(ctx context.Context, db bun.IDB, f UsageFilter, rows *[]UsageSummary) error {
+	q := db.NewSelect().
+		Model((*domain.AIUsage)(nil)).
+		ColumnExpr("task, provider, model").
+		ColumnExpr("COUNT(*) AS calls").
+		ColumnExpr("COUNT(CASE WHEN NOT success THEN 1 END) AS failures").
+		ColumnExpr("COALESCE(SUM(input_tokens), 0) AS input_tokens").
+		ColumnExpr("COALESCE(SUM(output_tokens), 0) AS output_tokens").
+		ColumnExpr("COALESCE(AVG(latency_ms), 0) AS avg_latency_ms").
+		ColumnExpr("COALESCE(SUM(cost_usd), 0) AS cost_usd").
+		Where("tenant_id = ?", f.TenantID)
+
+	if f.ExamID != nil {
+		q = q.Where("exam_id = ?", *f.ExamID)
+	}
+	if f.SubmissionID != nil {
+		q = q.Where("submission_id = ?", *f.SubmissionID)
+	}
+	if f.From != nil {
+		q = q.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		q = q.Where("created_at < ?", *f.To)
+	}
+
+	return q.Group("task", "provider", "model").
+		Order("cost_usd DESC").
+		Scan(ctx, rows)
+}
+
+// GetBudget returns the tenant's budget, or nil if none is configured.
+func (r *UsageRepo) GetBudget(ctx context.Context, tenantID uuid.UUID) (*domain.TenantBudget, error) {
+	budget := new(domain.TenantBudget)
+	err := InTenantTx(withTenant(ctx, tenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		return db.NewSelect().
+			Model(budget).
+			Where("tenant_id = ?", tenantID).
+			Scan(ctx)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return budget, nil
+}
+
+func (r *UsageRepo) SetBudget(ctx context.Context, budget *domain.TenantBudget) error {
+	return InTenantTx(withTenant(ctx, budget.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().
+			Model(budget).
+			On("CONFLICT (tenant_id) DO UPDATE").
+			Set("monthly_limit_usd = EXCLUDED.monthly_limit_usd").
+			Set("soft_limit_ratio = EXCLUDED.soft_limit_ratio").
+			Set("hard_stop = EXCLUDED.hard_stop").
+			Set("updated_at = EXCLUDED.updated_at").
+			Exec(ctx)
+		return err
+	})
+}
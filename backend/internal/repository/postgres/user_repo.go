@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type UserRepo struct {
+	db *bun.DB
+}
+
+func NewUserRepo(db *bun.DB) *UserRepo {
+	return &UserRepo{db: db}
+}
+
+func (r *UserRepo) Create(ctx context.Context, user *domain.User) error {
+	_, err := conn(ctx, r.db).NewInsert().Model(user).Exec(ctx)
+	return err
+}
+
+// GetByID looks a user up by identity provider ID across all tenants; it is
+// how a request finds its tenant.
+func (r *UserRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	user := new(domain.User)
+	err := r.db.NewSelect().
+		Model(user).
+		Where("id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *UserRepo) ListByTenant(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error) {
+	var users []domain.User
+	err := r.db.NewSelect().
+		Model(&users).
+		Where("tenant_id = ?", tenantID).
+		Order("created_at ASC").
+		Scan(ctx)
+	return users, err
+}
+
+// Update changes a user's role and student link within a tenant.
+func (r *UserRepo) Update(ctx context.Context, user *domain.User) error {
+	res, err := conn(ctx, r.db).NewUpdate().
+		Model(user).
+		Column("role", "student_id", "name").
+		Where("id = ?", user.ID).
+		Where("tenant_id = ?", user.TenantID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type WebhookRepo struct {
+	db *bun.DB
+}
+
+func NewWebhookRepo(db *bun.DB) *WebhookRepo {
+	return &WebhookRepo{db: db}
+}
+
+func (r *WebhookRepo) CreateEndpoint(ctx context.Context, ep *domain.WebhookEndpoint) error {
+	ctx = withTenant(ctx, ep.TenantID)
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(ep).Exec(ctx)
+		return err
+	})
+}
+
+// ListEndpoints returns the endpoints of the tenant in ctx, newest first.
+func (r *WebhookRepo) ListEndpoints(ctx context.Context) ([]domain.WebhookEndpoint, error) {
+	var eps []domain.WebhookEndpoint
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().Model(&eps)
+		return forTenant(ctx, q, "we.tenant_id = ?").
+			Order("we.created_at DESC").
+			Scan(ctx)
+	})
+	return eps, err
+}
+
+func (r *WebhookRepo) GetEndpoint(ctx context.Context, id uuid.UUID) (*domain.WebhookEndpoint, error) {
+	ep := new(domain.WebhookEndpoint)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(ep).
+			Where("we.id = ?", id)
+		return forTenant(ctx, q, "we.tenant_id = ?").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+// DeleteEndpoint removes an endpoint of the tenant in ctx and its
+// deliveries.
+func (r *WebhookRepo) DeleteEndpoint(ctx context.Context, id uuid.UUID) error {
+	return InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewDelete().
+			Model((*domain.WebhookEndpoint)(nil)).
+			Where("id = ?", id)
+		res, err := forTenant(ctx, q, "tenant_id = ?").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// Publish adds an event for the tenant in ctx to the outbox. Inside
+// AuditRepo.RunInTx it is written in the caller's transaction, so the event
+// exists exactly when the change it describes does.
+func (r *WebhookRepo) Publish(ctx context.Context, eventType string, data map[string]interface{}) error {
+	tenantID, err := auth.GetTenantID(ctx)
+	if err != nil {
+		return err
+	}
+	event := &domain.WebhookEvent{
+		ID:       uuid.New(),
+		TenantID: tenantID,
+		Type:     eventType,
+		Data:     data,
+	}
+	_, err = conn(ctx, r.db).NewInsert().Model(event).Exec(ctx)
+	return err
+}
+
+// DispatchNext fans the oldest undispatched event out to deliveries, one per
+// endpoint that subscribes to it, and marks it dispatched, all in one
+// transaction. The event row stays locked meanwhile, so concurrent workers
+// take different events. It reports false when the outbox is empty.
+func (r *WebhookRepo) DispatchNext(ctx context.Context, now time.Time) (bool, error) {
+	found := false
+	err := InTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		var event domain.WebhookEvent
+		err := db.NewSelect().
+			Model(&event).
+			Where("wev.dispatched_at IS NULL").
+			Order("wev.created_at ASC").
+			Limit(1).
+			For("UPDATE SKIP LOCKED").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+
+		// Endpoints are tenant data; reading them scopes the transaction
+		var eps []domain.WebhookEndpoint
+		err = InTenantTx(auth.WithTenantID(ctx, event.TenantID), r.db, func(ctx context.Context, db bun.IDB) error {
+			q := db.NewSelect().Model(&eps)
+			return forTenant(ctx, q, "we.tenant_id = ?").Scan(ctx)
+		})
+		if err != nil {
+			return err
+		}
+
+		var deliveries []domain.WebhookDelivery
+		for _, ep := range eps {
+			if !ep.Subscribes(event.Type) {
+				continue
+			}
+			deliveries = append(deliveries, domain.WebhookDelivery{
+				ID:            uuid.New(),
+				TenantID:      event.TenantID,
+				EndpointID:    ep.ID,
+				EventID:       event.ID,
+				EventType:     event.Type,
+				Status:        domain.DeliveryPending,
+				NextAttemptAt: &now,
+			})
+		}
+		if len(deliveries) > 0 {
+			if _, err := db.NewInsert().Model(&deliveries).Exec(ctx); err != nil {
+				return err
+			}
+		}
+
+		_, err = db.NewUpdate().
+			Model((*domain.WebhookEvent)(nil)).
+			Set("dispatched_at = ?", now).
+			Where("id = ?", event.ID).
+			Exec(ctx)
+		return err
+	})
+	return found, err
+}
+
+// ClaimDue takes up to limit pending deliveries that are due, across
+// tenants, and pushes their next attempt lease into the future so no other
+// worker takes them while they are being sent. A worker that dies mid-send
+// leaves the delivery to be retried once the lease runs out.
+func (r *WebhookRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error) {
+	var deliveries []domain.WebhookDelivery
+	due := r.db.NewSelect().
+		Model((*domain.WebhookDelivery)(nil)).
+		Column("id").
+		Where("status = ?", domain.DeliveryPending).
+		Where("next_attempt_at <= ?", now).
+		Order("next_attempt_at ASC").
+		Limit(limit).
+		For("UPDATE SKIP LOCKED")
+	_, err := r.db.NewUpdate().
+		Model((*domain.WebhookDelivery)(nil)).
+		Set("next_attempt_at = ?", now.Add(lease)).
+		Where("id IN (?)", due).
+		Returning("*").
+		Exec(ctx, &deliveries)
+	return deliveries, err
+}
+
+// GetEvent reads an outbox event by ID, whichever tenant it belongs to.
+func (r *WebhookRepo) GetEvent(ctx context.Context, id uuid.UUID) (*domain.WebhookEvent, error) {
+	event := new(domain.WebhookEvent)
+	err := r.db.NewSelect().
+		Model(event).
+		Where("wev.id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// RecordAttempt saves the outcome of an attempt to send a delivery.
+func (r *WebhookRepo) RecordAttempt(ctx context.Context, d *domain.WebhookDelivery) error {
+	_, err := r.db.NewUpdate().
+		Model(d).
+		Column("status", "attempts", "next_attempt_at", "last_attempt_at", "response_status", "last_error", "delivered_at").
+		WherePK().
+		Exec(ctx)
+	return err
+}
+
+func (r *WebhookRepo) CreateDelivery(ctx context.Context, d *domain.WebhookDelivery) error {
+	_, err := conn(ctx, r.db).NewInsert().Model(d).Exec(ctx)
+	return err
+}
+
+// GetDelivery returns a delivery of the tenant in ctx.
+func (r *WebhookRepo) GetDelivery(ctx context.Context, id uuid.UUID) (*domain.WebhookDelivery, error) {
+	d := new(domain.WebhookDelivery)
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().
+			Model(d).
+			Where("wd.id = ?", id)
+		return forTenant(ctx, q, "wd.tenant_id = ?").Scan(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListDeliveries returns the latest deliveries of the tenant in ctx, newest
+// first, optionally only those to one endpoint.
+func (r *WebhookRepo) ListDeliveries(ctx context.Context, endpointID *uuid.UUID, limit int) ([]domain.WebhookDelivery, error) {
+	var deliveries []domain.WebhookDelivery
+	err := InTenantTx(ctx, r.db, func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().Model(&deliveries)
+		if endpointID != nil {
+			q = q.Where("wd.endpoint_id = ?", *endpointID)
+		}
+		return forTenant(ctx, q, "wd.tenant_id = ?").
+			Order("wd.created_at DESC").
+			Limit(limit).
+			Scan(ctx)
+	})
+	return deliveries, err
+}
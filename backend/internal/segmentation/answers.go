@@ -0,0 +1,133 @@
+package segmentation
+
+import (
+	"regexp"
+	"strings"
+
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// Anchor is a question that answers are found under, known by the number
+// printed for it on the question paper.
+type Anchor struct {
+	QuestionID uuid.UUID
+	Number     string
+}
+
+var (
+	// numberedLine matches a line opening with a question number: "3.",
+	// "Q3", "Question 3:", "2b)" or "2 (b)"
+	numberedLine = regexp.MustCompile(`(?i)^(q(?:uestion)?\s*\.?\s*)?(\d+)\s*(?:\(([a-z])\)|([a-z])\b)?\s*([.):]?)`)
+	// partLine matches a line opening with a sub-part on its own: "(b)" or
+	// "b)"
+	partLine = regexp.MustCompile(`(?i)^(?:\(([a-z])\)|([a-z])\))`)
+)
+
+// SplitAnswers divides the transcribed pages of a submission into answers
+// to the anchors' questions. An answer starts at a line opening with its
+// question's number and runs until the next one; a sub-part written on its
+// own, "(b)", belongs to the question last numbered. Text before the first
+// number is dropped, and each question takes the first answer under its
+// number, so that a number repeated within an answer doesn't split it.
+// Answers are in the order they were written and name their pages.
+func SplitAnswers(pages []domain.OCRResult, anchors []Anchor) []domain.AnswerSegment {
+	keys := map[string]int{}
+	for i, a := range anchors {
+		if k := anchorKey(a.Number); k != "" {
+			if _, dup := keys[k]; !dup {
+				keys[k] = i
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var (
+		answers []domain.AnswerSegment
+		lines   []string
+		used    = map[int]bool{}
+		parent  string
+	)
+	flush := func() {
+		if len(answers) > 0 {
+			last := &answers[len(answers)-1]
+			last.Text = strings.TrimSpace(strings.Join(lines, "\n"))
+		}
+		lines = nil
+	}
+	for _, page := range pages {
+		text := page.RawText
+		if page.CorrectedText != nil {
+			text = *page.CorrectedText
+		}
+		for _, line := range strings.Split(text, "\n") {
+			if key, rest, ok := lineLabel(strings.TrimSpace(line), parent); ok {
+				if i, found := keys[key]; found && !used[i] {
+					flush()
+					used[i] = true
+					parent = leadingDigits(key)
+					answers = append(answers, domain.AnswerSegment{ID: uuid.New(), QuestionID: anchors[i].QuestionID})
+					line = rest
+				}
+			}
+			if len(answers) == 0 {
+				continue
+			}
+			lines = append(lines, line)
+			last := &answers[len(answers)-1]
+			if n := len(last.PageIndices); n == 0 || last.PageIndices[n-1] != page.PageNumber {
+				last.PageIndices = append(last.PageIndices, page.PageNumber)
+			}
+		}
+	}
+	flush()
+	return answers
+}
+
+// lineLabel reads the question number a line opens with, as an anchor key,
+// and the rest of the line. A sub-part on its own is one of parent's.
+func lineLabel(line, parent string) (string, string, bool) {
+	if m := numberedLine.FindStringSubmatchIndex(line); m != nil {
+		sub := func(n int) string {
+			if m[2*n] < 0 {
+				return ""
+			}
+			return line[m[2*n]:m[2*n+1]]
+		}
+		prefix, number, bracketed, part, punct := sub(1), sub(2), sub(3), sub(4), sub(5)
+		rest := strings.TrimSpace(line[m[1]:])
+		// A bare number is only a label when set off from what follows,
+		// so that an answer opening "3 apples" isn't taken for one
+		if prefix != "" || bracketed != "" || punct != "" || rest == "" {
+			return strings.ToLower(number + bracketed + part), rest, true
+		}
+		return "", "", false
+	}
+	if parent == "" {
+		return "", "", false
+	}
+	if m := partLine.FindStringSubmatch(line); m != nil {
+		return parent + strings.ToLower(m[1]+m[2]), strings.TrimSpace(line[len(m[0]):]), true
+	}
+	return "", "", false
+}
+
+// anchorKey is a question number as lineLabel reads it: "Q2(b)." is "2b".
+func anchorKey(number string) string {
+	key, _, ok := lineLabel(strings.TrimSpace(number), "")
+	if !ok {
+		return ""
+	}
+	return key
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
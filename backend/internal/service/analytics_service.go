@@ -1,34 +1,45 @@
 package service
 
 import (
-	"bytes"
 	"context"
-	"encoding/csv"
-	"fmt"
 	"harama/internal/repository/postgres"
 
 	"github.com/google/uuid"
 )
 
 type AnalyticsService struct {
-	gradeRepo *postgres.GradeRepo
-	examRepo  *postgres.ExamRepo
-	subRepo   *postgres.SubmissionRepo
+	gradeRepo    *postgres.GradeRepo
+	examRepo     *postgres.ExamRepo
+	subRepo      *postgres.SubmissionRepo
+	rosterRepo   *postgres.RosterRepo
+	feedbackRepo *postgres.FeedbackRepo
+	curveRepo    *postgres.CurveRepo
 }
 
-func NewAnalyticsService(gradeRepo *postgres.GradeRepo, examRepo *postgres.ExamRepo, subRepo *postgres.SubmissionRepo) *AnalyticsService {
+func NewAnalyticsService(gradeRepo *postgres.GradeRepo, examRepo *postgres.ExamRepo, subRepo *postgres.SubmissionRepo, rosterRepo *postgres.RosterRepo, feedbackRepo *postgres.FeedbackRepo, curveRepo *postgres.CurveRepo) *AnalyticsService {
 	return &AnalyticsService{
-		gradeRepo: gradeRepo,
-		examRepo:  examRepo,
-		subRepo:   subRepo,
+		gradeRepo:    gradeRepo,
+		examRepo:     examRepo,
+		subRepo:      subRepo,
+		rosterRepo:   rosterRepo,
+		feedbackRepo: feedbackRepo,
+		curveRepo:    curveRepo,
 	}
 }
 
-func (s *AnalyticsService) GetGradingTrends(ctx context.Context, tenantID uuid.UUID, examID *uuid.UUID) (interface{}, error) {
+// GroupByClass breaks exam analytics and exports down by the classes the
+// exam is assigned to.
+const GroupByClass = "class"
+
+func (s *AnalyticsService) GetGradingTrends(ctx context.Context, tenantID uuid.UUID, examID *uuid.UUID, groupBy string) (interface{}, error) {
 	if examID == nil {
 		return s.gradeRepo.GetGlobalStats(ctx, tenantID)
 	}
 
+	if groupBy == GroupByClass {
+		return s.rosterRepo.ExamClassStats(ctx, *examID)
+	}
+
 	// 1. Get raw stats from DB
 	stats, err := s.gradeRepo.GetExamStats(ctx, *examID)
 	if err != nil {
@@ -58,60 +69,23 @@ func (s *AnalyticsService) GetGradingTrends(ctx context.Context, tenantID uuid.U
 	return enriched, nil
 }
 
-func (s *AnalyticsService) ExportGrades(ctx context.Context, examID uuid.UUID, format string) ([]byte, string, error) {
-	if format != "csv" {
-		return nil, "", fmt.Errorf("unsupported format: %s", format)
+// studentClasses maps each enrolled student's ID to the names of their
+// classes the exam is assigned to, joined when there are several.
+func (s *AnalyticsService) studentClasses(ctx context.Context, examID uuid.UUID) (map[string]string, error) {
+	if s.rosterRepo == nil {
+		return nil, nil
 	}
-
-	// 1. Get Exam (for Questions)
-	exam, err := s.examRepo.GetByID(ctx, examID)
+	rows, err := s.rosterRepo.ExamStudentClasses(ctx, examID)
 	if err != nil {
-		return nil, "", err
-	}
-
-	// 2. Get Submissions
-	submissions, err := s.subRepo.ListByExam(ctx, examID)
-	if err != nil {
-		return nil, "", err
-	}
-
-	// 3. Prepare CSV
-	var buf bytes.Buffer
-	writer := csv.NewWriter(&buf)
-
-	// Header
-	header := []string{"Student ID", "Submission ID", "Total Score"}
-	for _, q := range exam.Questions {
-		header = append(header, fmt.Sprintf("Q: %s (Max: %d)", q.QuestionText, q.Points))
+		return nil, err
 	}
-	writer.Write(header)
-
-	// Rows
-	for _, sub := range submissions {
-		grades, err := s.gradeRepo.GetBySubmission(ctx, sub.ID)
-		if err != nil {
-			continue // Skip or log error?
-		}
-
-		gradeMap := make(map[uuid.UUID]float64)
-		totalScore := 0.0
-		for _, g := range grades {
-			gradeMap[g.QuestionID] = g.FinalScore
-			totalScore += g.FinalScore
-		}
-
-		row := []string{sub.StudentID, sub.ID.String(), fmt.Sprintf("%.2f", totalScore)}
-		for _, q := range exam.Questions {
-			score, ok := gradeMap[q.ID]
-			if ok {
-				row = append(row, fmt.Sprintf("%.2f", score))
-			} else {
-				row = append(row, "N/A")
-			}
+	classes := make(map[string]string, len(rows))
+	for _, row := range rows {
+		if existing, ok := classes[row.ExternalID]; ok {
+			classes[row.ExternalID] = existing + "; " + row.ClassName
+			continue
 		}
-		writer.Write(row)
+		classes[row.ExternalID] = row.ClassName
 	}
-
-	writer.Flush()
-	return buf.Bytes(), "text/csv", nil
+	return classes, nil
 }
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/pkg/utils"
+	"harama/internal/repository/postgres"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// lastUsedInterval bounds how often a key's last use is written
+const lastUsedInterval = time.Minute
+
+type APIKeyService struct {
+	repo      *postgres.APIKeyRepo
+	auditRepo *postgres.AuditRepo
+}
+
+func NewAPIKeyService(repo *postgres.APIKeyRepo, auditRepo *postgres.AuditRepo) *APIKeyService {
+	return &APIKeyService{repo: repo, auditRepo: auditRepo}
+}
+
+// CreateKey issues a key for the tenant and returns it with the key in
+// clear, which is never available again.
+func (s *APIKeyService) CreateKey(ctx context.Context, key *domain.APIKey) (string, error) {
+	if err := validateAPIKey(key, utils.CurrentTime()); err != nil {
+		return "", err
+	}
+	secret, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return "", err
+	}
+	key.ID = uuid.New()
+	key.Prefix = prefix
+	key.KeyHash = hash
+	key.LastUsedAt, key.RevokedAt = nil, nil
+	if actor, err := auth.GetActor(ctx); err == nil {
+		key.CreatedBy = &actor.UserID
+	}
+
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, key); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "api_key",
+			EntityID:   key.ID,
+			EventType:  "created",
+			Changes: map[string]interface{}{
+				"name":       key.Name,
+				"prefix":     key.Prefix,
+				"scopes":     key.Scopes,
+				"expires_at": key.ExpiresAt,
+			},
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func (s *APIKeyService) ListKeys(ctx context.Context, tenantID uuid.UUID) ([]domain.APIKey, error) {
+	return s.repo.ListByTenant(ctx, tenantID)
+}
+
+// RevokeKey stops a key from authenticating; it stays listed.
+func (s *APIKeyService) RevokeKey(ctx context.Context, tenantID, id uuid.UUID) error {
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Revoke(ctx, tenantID, id, utils.CurrentTime()); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "api_key",
+			EntityID:   id,
+			EventType:  "revoked",
+		})
+	})
+}
+
+// Authenticate resolves a presented key to its record if it is known,
+// unexpired and not revoked, and records its use.
+func (s *APIKeyService) Authenticate(ctx context.Context, secret string) (*domain.APIKey, error) {
+	if !auth.IsAPIKey(secret) {
+		return nil, auth.ErrAPIKeyInvalid
+	}
+	key, err := s.repo.GetByHash(ctx, auth.HashAPIKey(secret))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := utils.CurrentTime()
+	switch {
+	case key.RevokedAt != nil:
+		return nil, auth.ErrAPIKeyRevoked
+	case !key.Active(now):
+		return nil, auth.ErrAPIKeyExpired
+	}
+	if err := s.repo.TouchLastUsed(ctx, key.ID, now, lastUsedInterval); err != nil {
+		log.Printf("recording use of API key %s: %v", key.ID, err)
+	}
+	return key, nil
+}
+
+func validateAPIKey(key *domain.APIKey, now time.Time) error {
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(key.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range key.Scopes {
+		if !auth.ValidScope(scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(now) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
@@ -2,18 +2,417 @@ package service
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harama/internal/auth"
 	"harama/internal/domain"
 	"harama/internal/repository/postgres"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNoSigningKey is returned when checkpoints are requested but
+	// AUDIT_SIGNING_KEY is not set.
+	ErrNoSigningKey = errors.New("audit signing key is not configured")
+	// ErrChainBroken is returned when a checkpoint is requested for a chain
+	// that fails verification.
+	ErrChainBroken = errors.New("audit chain is broken")
+	// ErrUnsupportedFormat is returned for an export format not in
+	// AuditExportFormats or GradeExportFormats.
+	ErrUnsupportedFormat = errors.New("unsupported export format")
 )
 
+// chainPageSize is how many entries VerifyChain and ExportLogs read at a time.
+const chainPageSize = 500
+
+// Page sizes of SearchLogs
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// AuditExportFormats maps the formats ExportLogs writes to their content
+// types.
+var AuditExportFormats = map[string]string{
+	"jsonl": "application/x-ndjson",
+	"csv":   "text/csv",
+}
+
+// auditCSVHeader is the header row of CSV exports. changes and metadata are
+// JSON encoded as they are when hashed.
+var auditCSVHeader = []string{
+	"seq", "id", "tenant_id", "created_at", "entity_type", "entity_id", "event_type",
+	"actor_type", "actor_id", "changes", "metadata", "hash_version", "prev_hash", "hash",
+}
+
+// Reasons a chain fails verification
+const (
+	BreakHashMismatch       = "hash_mismatch"
+	BreakSequenceGap        = "sequence_gap"
+	BreakCheckpointMismatch = "checkpoint_mismatch"
+	BreakCheckpointMissing  = "checkpoint_beyond_head"
+	BreakBadSignature       = "checkpoint_signature_invalid"
+)
+
+// ChainBreak is the first point at which a chain fails verification.
+type ChainBreak struct {
+	Reason string `json:"reason"`
+	// Position is the 1-based position of Entry in the chain
+	Position int64 `json:"position"`
+	// Entry is the offending entry and Previous the one it should follow
+	Entry        *domain.AuditLog        `json:"entry,omitempty"`
+	Previous     *domain.AuditLog        `json:"previous,omitempty"`
+	ExpectedHash string                  `json:"expected_hash,omitempty"`
+	Checkpoint   *domain.AuditCheckpoint `json:"checkpoint,omitempty"`
+}
+
+// ChainReport is the outcome of verifying a tenant's audit chain.
+type ChainReport struct {
+	TenantID    uuid.UUID   `json:"tenant_id"`
+	Valid       bool        `json:"valid"`
+	Entries     int64       `json:"entries"`
+	HeadID      *uuid.UUID  `json:"head_id,omitempty"`
+	HeadHash    string      `json:"head_hash"`
+	Checkpoints int         `json:"checkpoints"`
+	Break       *ChainBreak `json:"break,omitempty"`
+	VerifiedAt  time.Time   `json:"verified_at"`
+}
+
+// CheckpointExport is what a third party needs to check a tenant's
+// checkpoints: the checkpoints, the exact payloads signed and the public key.
+type CheckpointExport struct {
+	TenantID    uuid.UUID            `json:"tenant_id"`
+	Algorithm   string               `json:"algorithm"`
+	KeyID       string               `json:"key_id,omitempty"`
+	PublicKey   string               `json:"public_key,omitempty"`
+	Checkpoints []ExportedCheckpoint `json:"checkpoints"`
+	ExportedAt  time.Time            `json:"exported_at"`
+}
+
+// AuditPage is one page of search results, newest first. NextCursor fetches
+// the page after it and is empty on the last page.
+type AuditPage struct {
+	Entries    []domain.AuditLog `json:"entries"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+type ExportedCheckpoint struct {
+	domain.AuditCheckpoint
+	Payload string `json:"payload"`
+}
+
 type AuditService struct {
 	repo *postgres.AuditRepo
+	// key signs checkpoints; nil disables them
+	key ed25519.PrivateKey
+}
+
+func NewAuditService(repo *postgres.AuditRepo, key ed25519.PrivateKey) *AuditService {
+	return &AuditService{repo: repo, key: key}
+}
+
+// ParseSigningKey decodes AUDIT_SIGNING_KEY, a base64 Ed25519 seed. An empty
+// value yields a nil key.
+func ParseSigningKey(s string) (ed25519.PrivateKey, error) {
+	if s == "" {
+		return nil, nil
+	}
+	seed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("audit signing key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("audit signing key: want a %d byte seed, got %d bytes", ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
 }
 
-func NewAuditService(repo *postgres.AuditRepo) *AuditService {
-	return &AuditService{repo: repo}
+// KeyID identifies a public key in checkpoints: the first 8 bytes of its
+// SHA-256, in hex.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
 }
 
 func (s *AuditService) GetLogsForEntity(ctx context.Context, entityType string, entityID string) ([]domain.AuditLog, error) {
 	return s.repo.GetByEntity(ctx, entityType, entityID)
 }
+
+// SearchLogs returns a page of the tenant's entries matching f, newest
+// first. f.After is the cursor of the previous page.
+func (s *AuditService) SearchLogs(ctx context.Context, f postgres.AuditFilter) (*AuditPage, error) {
+	switch {
+	case f.Limit <= 0:
+		f.Limit = defaultSearchLimit
+	case f.Limit > maxSearchLimit:
+		f.Limit = maxSearchLimit
+	}
+	limit := f.Limit
+	f.Ascending = false
+	// One more than asked tells whether there is another page
+	f.Limit++
+
+	logs, err := s.repo.Search(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	page := &AuditPage{Entries: logs}
+	if len(logs) > limit {
+		page.Entries = logs[:limit]
+		page.NextCursor = strconv.FormatInt(logs[limit-1].Seq, 10)
+	}
+	if page.Entries == nil {
+		page.Entries = []domain.AuditLog{}
+	}
+	return page, nil
+}
+
+// ExportLogs writes every entry of the tenant's chain matching f to w in
+// sequence order, as JSON lines or CSV. Each entry carries its sequence
+// number, hash version, hash and the hash it was chained onto, so a
+// reviewer can recompute the hashes without access to the database.
+func (s *AuditService) ExportLogs(ctx context.Context, f postgres.AuditFilter, format string, w io.Writer) error {
+	var write func(*domain.AuditLog) error
+	var flush func() error
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		write = func(l *domain.AuditLog) error { return enc.Encode(l) }
+		flush = func() error { return nil }
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(auditCSVHeader); err != nil {
+			return err
+		}
+		write = func(l *domain.AuditLog) error { return cw.Write(auditCSVRecord(l)) }
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+
+	f.Ascending = true
+	f.After = 0
+	f.Limit = chainPageSize
+	for {
+		page, err := s.repo.Search(ctx, f)
+		if err != nil {
+			return err
+		}
+		for i := range page {
+			if err := write(&page[i]); err != nil {
+				return err
+			}
+		}
+		if len(page) < chainPageSize {
+			return flush()
+		}
+		f.After = page[len(page)-1].Seq
+	}
+}
+
+func auditCSVRecord(l *domain.AuditLog) []string {
+	tenantID, actorID := "", ""
+	if l.TenantID != nil {
+		tenantID = l.TenantID.String()
+	}
+	if l.ActorID != nil {
+		actorID = l.ActorID.String()
+	}
+	changes, _ := json.Marshal(l.Changes)
+	metadata, _ := json.Marshal(l.Metadata)
+	return []string{
+		strconv.FormatInt(l.Seq, 10), l.ID.String(), tenantID, l.CreatedAt.UTC().Format(time.RFC3339Nano),
+		l.EntityType, l.EntityID.String(), l.EventType, l.ActorType, actorID,
+		string(changes), string(metadata), strconv.Itoa(l.HashVersion), l.PrevHash, l.Hash,
+	}
+}
+
+// VerifyChain walks the chain of the tenant in ctx in sequence order,
+// checking each entry's sequence number and recomputing its hash, and checks
+// that every checkpoint still matches the entry it was taken at. It reports
+// the first break it finds.
+func (s *AuditService) VerifyChain(ctx context.Context) (*ChainReport, error) {
+	tenantID, err := auth.GetTenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := s.repo.ListCheckpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ChainReport{TenantID: tenantID, HeadHash: domain.ChainSeed, Checkpoints: len(checkpoints)}
+	for i := range checkpoints {
+		if !s.signatureValid(&checkpoints[i]) {
+			report.Break = &ChainBreak{Reason: BreakBadSignature, Position: checkpoints[i].EntryCount, Checkpoint: &checkpoints[i]}
+			return s.finish(report), nil
+		}
+	}
+
+	var prev *domain.AuditLog
+	next := 0
+	for {
+		page, err := s.repo.ListChain(ctx, prev, chainPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page {
+			entry := &page[i]
+			report.Entries++
+			if entry.Seq != report.Entries {
+				report.Break = &ChainBreak{Reason: BreakSequenceGap, Position: report.Entries, Entry: entry, Previous: prev}
+				return s.finish(report), nil
+			}
+			if expected := entry.ChainHash(report.HeadHash); entry.Hash != expected {
+				report.Break = &ChainBreak{Reason: BreakHashMismatch, Position: report.Entries, Entry: entry, Previous: prev, ExpectedHash: expected}
+				return s.finish(report), nil
+			}
+			for ; next < len(checkpoints) && checkpoints[next].EntryCount == report.Entries; next++ {
+				cp := &checkpoints[next]
+				if cp.HeadID != entry.ID || cp.HeadHash != entry.Hash {
+					report.Break = &ChainBreak{Reason: BreakCheckpointMismatch, Position: report.Entries, Entry: entry, Previous: prev, Checkpoint: cp}
+					return s.finish(report), nil
+				}
+			}
+			report.HeadID, report.HeadHash = &entry.ID, entry.Hash
+			prev = entry
+		}
+		if len(page) < chainPageSize {
+			break
+		}
+	}
+
+	// A checkpoint past the head means entries were removed
+	if next < len(checkpoints) {
+		cp := &checkpoints[next]
+		report.Break = &ChainBreak{Reason: BreakCheckpointMissing, Position: cp.EntryCount, Previous: prev, Checkpoint: cp}
+	}
+	return s.finish(report), nil
+}
+
+func (s *AuditService) finish(report *ChainReport) *ChainReport {
+	report.Valid = report.Break == nil
+	report.VerifiedAt = time.Now()
+	return report
+}
+
+// signatureValid checks a checkpoint against the configured key. Checkpoints
+// made with another key cannot be checked here and are taken as they are;
+// the export carries their key ID for whoever holds that key.
+func (s *AuditService) signatureValid(cp *domain.AuditCheckpoint) bool {
+	if s.key == nil {
+		return true
+	}
+	pub := s.key.Public().(ed25519.PublicKey)
+	if cp.KeyID != KeyID(pub) {
+		return true
+	}
+	sig, err := base64.StdEncoding.DecodeString(cp.Signature)
+	return err == nil && ed25519.Verify(pub, []byte(cp.SignedPayload()), sig)
+}
+
+// CreateCheckpoint verifies the chain of the tenant in ctx and signs its
+// head. It returns nil when the chain is empty, and the latest checkpoint
+// when the chain has not grown since.
+func (s *AuditService) CreateCheckpoint(ctx context.Context) (*domain.AuditCheckpoint, error) {
+	if s.key == nil {
+		return nil, ErrNoSigningKey
+	}
+	report, err := s.VerifyChain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Valid {
+		return nil, fmt.Errorf("%w at entry %d: %s", ErrChainBroken, report.Break.Position, report.Break.Reason)
+	}
+	if report.HeadID == nil {
+		return nil, nil
+	}
+
+	checkpoints, err := s.repo.ListCheckpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(checkpoints); n > 0 && checkpoints[n-1].EntryCount == report.Entries {
+		return &checkpoints[n-1], nil
+	}
+
+	cp := &domain.AuditCheckpoint{
+		ID:         uuid.New(),
+		TenantID:   report.TenantID,
+		EntryCount: report.Entries,
+		HeadID:     *report.HeadID,
+		HeadHash:   report.HeadHash,
+		KeyID:      KeyID(s.key.Public().(ed25519.PublicKey)),
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+	}
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, []byte(cp.SignedPayload())))
+	if err := s.repo.CreateCheckpoint(ctx, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// CheckpointAll checkpoints every tenant's chain, carrying on past tenants
+// that fail. It returns how many checkpoints were made.
+func (s *AuditService) CheckpointAll(ctx context.Context) (int, error) {
+	tenantIDs, err := s.repo.TenantIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	made := 0
+	var errs []error
+	for _, tenantID := range tenantIDs {
+		cp, err := s.CreateCheckpoint(auth.WithTenantID(ctx, tenantID))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenantID, err))
+			continue
+		}
+		if cp != nil {
+			made++
+		}
+	}
+	return made, errors.Join(errs...)
+}
+
+// ExportCheckpoints returns the checkpoints of the tenant in ctx with the
+// payloads they sign and the public key of the configured signing key.
+func (s *AuditService) ExportCheckpoints(ctx context.Context) (*CheckpointExport, error) {
+	tenantID, err := auth.GetTenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := s.repo.ListCheckpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &CheckpointExport{
+		TenantID:    tenantID,
+		Algorithm:   "ed25519",
+		Checkpoints: make([]ExportedCheckpoint, 0, len(checkpoints)),
+		ExportedAt:  time.Now(),
+	}
+	if s.key != nil {
+		pub := s.key.Public().(ed25519.PublicKey)
+		export.KeyID = KeyID(pub)
+		export.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	}
+	for _, cp := range checkpoints {
+		export.Checkpoints = append(export.Checkpoints, ExportedCheckpoint{AuditCheckpoint: cp, Payload: cp.SignedPayload()})
+	}
+	return export, nil
+}
@@ -0,0 +1,440 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidBankUse is returned for bank items added to an exam other
+	// than by reference or by copy.
+	ErrInvalidBankUse = errors.New("bank items are added by reference or by copy")
+	// ErrAlreadyInBank is returned for a question saved to the bank that
+	// was made from a bank item or saved to it before.
+	ErrAlreadyInBank = errors.New("question is already in the question bank")
+)
+
+// How bank items are added to an exam: a reference stays linked to the
+// item, a copy is the exam's own.
+const (
+	BankUseReference = "reference"
+	BankUseCopy      = "copy"
+)
+
+// Page sizes of SearchItems
+const (
+	defaultBankSearchLimit = 50
+	maxBankSearchLimit     = 200
+)
+
+// BankItemError lists every mistake found in a bank item.
+type BankItemError struct {
+	Errors []domain.FieldError
+}
+
+func (e *BankItemError) Error() string {
+	first := e.Errors[0]
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("bank item has an error: %s: %s", first.Path, first.Message)
+	}
+	return fmt.Sprintf("bank item has %d errors, the first: %s: %s", len(e.Errors), first.Path, first.Message)
+}
+
+// BankPlacement is where a bank item goes in an exam.
+type BankPlacement struct {
+	BankItemID uuid.UUID `json:"bank_item_id"`
+	Number     string    `json:"number,omitempty"`
+	Group      string    `json:"group,omitempty"`
+}
+
+// BankItemUpdate is an edited bank item and what became of the questions
+// linked to it: those updated to match, and those detached because they
+// had been graded.
+type BankItemUpdate struct {
+	Item     *domain.BankItem `json:"item"`
+	Updated  []uuid.UUID      `json:"updated_questions"`
+	Detached int              `json:"detached_questions"`
+}
+
+type BankService struct {
+	repo      *postgres.BankRepo
+	examRepo  *postgres.ExamRepo
+	auditRepo *postgres.AuditRepo
+	webhooks  *postgres.WebhookRepo
+}
+
+func NewBankService(repo *postgres.BankRepo, examRepo *postgres.ExamRepo, auditRepo *postgres.AuditRepo, webhooks *postgres.WebhookRepo) *BankService {
+	return &BankService{
+		repo:      repo,
+		examRepo:  examRepo,
+		auditRepo: auditRepo,
+		webhooks:  webhooks,
+	}
+}
+
+// CreateItem adds a question to the bank, checked the way an exam's
+// question is. Its mistakes are returned together as a *BankItemError.
+func (s *BankService) CreateItem(ctx context.Context, item *domain.BankItem) error {
+	if problems := validateBankItem(item); len(problems) > 0 {
+		return &BankItemError{Errors: problems}
+	}
+	item.ID = uuid.New()
+	if actor, err := auth.GetActor(ctx); err == nil {
+		item.CreatedBy = &actor.UserID
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, item); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			TenantID:   &item.TenantID,
+			EntityType: "bank_item",
+			EntityID:   item.ID,
+			EventType:  "created",
+			Changes:    bankItemChanges(item),
+		})
+	})
+}
+
+// SaveQuestion adds a question of an exam of the tenant in ctx to the bank
+// with tags, the item's topic, learning objective, difficulty and grade
+// level. The question's grades count towards the new item, but it stays the
+// exam's own: later edits to the item don't change it.
+func (s *BankService) SaveQuestion(ctx context.Context, questionID uuid.UUID, tags *domain.BankItem) (*domain.BankItem, error) {
+	tenantID, err := auth.GetTenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q, err := s.examRepo.GetQuestionByID(ctx, questionID)
+	if err != nil {
+		return nil, err
+	}
+	if q.BankItemID != nil {
+		return nil, fmt.Errorf("%w: it was made from bank item %s", ErrAlreadyInBank, *q.BankItemID)
+	}
+
+	item := &domain.BankItem{
+		ID:                uuid.New(),
+		TenantID:          tenantID,
+		QuestionText:      q.QuestionText,
+		Points:            q.Points,
+		AnswerType:        q.AnswerType,
+		AnswerKey:         q.AnswerKey,
+		Choices:           q.Choices,
+		VisualAids:        q.VisualAids,
+		Topic:             strings.TrimSpace(tags.Topic),
+		LearningObjective: strings.TrimSpace(tags.LearningObjective),
+		Difficulty:        tags.Difficulty,
+		GradeLevel:        strings.TrimSpace(tags.GradeLevel),
+	}
+	if q.Rubric != nil {
+		item.Rubric = domain.NewRubricDocument(q.Rubric)
+	}
+	if problems := validateBankTags(item); len(problems) > 0 {
+		return nil, &BankItemError{Errors: problems}
+	}
+	if actor, err := auth.GetActor(ctx); err == nil {
+		item.CreatedBy = &actor.UserID
+	}
+
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, item); err != nil {
+			return err
+		}
+		if err := s.repo.LinkQuestion(ctx, q.ID, item.ID, false); err != nil {
+			return err
+		}
+		changes := bankItemChanges(item)
+		changes["question_id"] = q.ID
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			TenantID:   &item.TenantID,
+			EntityType: "bank_item",
+			EntityID:   item.ID,
+			EventType:  "created",
+			Changes:    changes,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// GetItem returns a bank item of the tenant in ctx with its statistics.
+func (s *BankService) GetItem(ctx context.Context, id uuid.UUID) (*domain.BankItem, error) {
+	item, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	items := []domain.BankItem{*item}
+	if err := s.attachStats(ctx, items); err != nil {
+		return nil, err
+	}
+	return &items[0], nil
+}
+
+// SearchItems returns a page of the bank items matching f, each with its
+// statistics.
+func (s *BankService) SearchItems(ctx context.Context, f postgres.BankFilter) ([]domain.BankItem, error) {
+	switch {
+	case f.Limit <= 0:
+		f.Limit = defaultBankSearchLimit
+	case f.Limit > maxBankSearchLimit:
+		f.Limit = maxBankSearchLimit
+	}
+	f.Query = strings.TrimSpace(f.Query)
+
+	items, err := s.repo.Search(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.attachStats(ctx, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// attachStats sets the statistics of items, zero for those never used.
+func (s *BankService) attachStats(ctx context.Context, items []domain.BankItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(items))
+	for i := range items {
+		ids[i] = items[i].ID
+	}
+	stats, err := s.repo.Stats(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		st := stats[items[i].ID]
+		st.BankItemID = items[i].ID
+		items[i].Stats = &st
+	}
+	return nil
+}
+
+// UpdateItem replaces a bank item's question, rubric and tags with edit.
+// Questions linked to the item are updated to match in the same
+// transaction, unless they have been graded, in which case they are
+// detached and keep the version they were graded on.
+func (s *BankService) UpdateItem(ctx context.Context, id uuid.UUID, edit *domain.BankItem) (*BankItemUpdate, error) {
+	if problems := validateBankItem(edit); len(problems) > 0 {
+		return nil, &BankItemError{Errors: problems}
+	}
+	item, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	item.QuestionText, item.Points, item.AnswerType = edit.QuestionText, edit.Points, edit.AnswerType
+	item.AnswerKey, item.Choices, item.VisualAids = edit.AnswerKey, edit.Choices, edit.VisualAids
+	item.Rubric = edit.Rubric
+	item.Topic, item.LearningObjective = edit.Topic, edit.LearningObjective
+	item.Difficulty, item.GradeLevel = edit.Difficulty, edit.GradeLevel
+
+	update := &BankItemUpdate{Item: item, Updated: []uuid.UUID{}}
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Save(ctx, item); err != nil {
+			return err
+		}
+		updated, detached, err := s.repo.UpdateLinked(ctx, item)
+		if err != nil {
+			return err
+		}
+		update.Updated, update.Detached = append(update.Updated, updated...), detached
+
+		changes := bankItemChanges(item)
+		changes["updated_questions"] = len(updated)
+		changes["detached_questions"] = detached
+		err = s.auditRepo.Save(ctx, &domain.AuditLog{
+			TenantID:   &item.TenantID,
+			EntityType: "bank_item",
+			EntityID:   item.ID,
+			EventType:  "updated",
+			Changes:    changes,
+		})
+		if err != nil {
+			return err
+		}
+		if item.Rubric == nil {
+			return nil
+		}
+		for _, questionID := range updated {
+			err := s.webhooks.Publish(ctx, domain.EventRubricUpdated, map[string]interface{}{
+				"question_id":  questionID,
+				"bank_item_id": item.ID,
+				"source":       "bank",
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// DeleteItem removes an item from the bank. Questions made from it are
+// kept as they are.
+func (s *BankService) DeleteItem(ctx context.Context, id uuid.UUID) error {
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, id); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "bank_item",
+			EntityID:   id,
+			EventType:  "deleted",
+		})
+	})
+}
+
+// AddToExam adds bank items to an exam of the tenant in ctx as questions,
+// with copies of their rubrics, each placed under its number and group.
+// Added by reference they stay linked to their items, and follow edits to
+// them until graded; added by copy they are the exam's own. Either way
+// their grades count towards their items. Placements that can't be made,
+// an item not in the bank or a number already taken, are returned together
+// as an *ExamDefinitionError, and nothing is added.
+func (s *BankService) AddToExam(ctx context.Context, examID uuid.UUID, use string, placements []BankPlacement) ([]domain.Question, error) {
+	if use != BankUseReference && use != BankUseCopy {
+		return nil, fmt.Errorf("%w, not %q", ErrInvalidBankUse, use)
+	}
+	exam, err := s.examRepo.GetByID(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, len(placements))
+	for i, p := range placements {
+		ids[i] = p.BankItemID
+	}
+	items := map[uuid.UUID]*domain.BankItem{}
+	if len(ids) > 0 {
+		found, err := s.repo.GetByIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			items[found[i].ID] = &found[i]
+		}
+	}
+
+	var problems []domain.FieldError
+	if len(placements) == 0 {
+		problems = append(problems, domain.FieldError{Path: "items", Message: "at least one bank item is required"})
+	}
+	numbers := map[string]string{}
+	for _, q := range exam.Questions {
+		if q.QuestionNumber != "" {
+			numbers[q.QuestionNumber] = "the number of a question already in the exam"
+		}
+	}
+	var questions []domain.Question
+	for i, p := range placements {
+		path := fmt.Sprintf("items[%d]", i)
+		item, ok := items[p.BankItemID]
+		if !ok {
+			problems = append(problems, domain.FieldError{Path: path + ".bank_item_id", Message: "is not in the question bank"})
+			continue
+		}
+		number := strings.TrimSpace(p.Number)
+		if taken, dup := numbers[number]; dup {
+			problems = append(problems, domain.FieldError{Path: path + ".number", Message: fmt.Sprintf("%q is %s", number, taken)})
+		} else if number != "" {
+			numbers[number] = fmt.Sprintf("also the number of %s", path)
+		}
+
+		q := item.Question()
+		q.ID, q.QuestionNumber, q.QuestionGroup = uuid.New(), number, strings.TrimSpace(p.Group)
+		q.BankLinked = use == BankUseReference
+		questions = append(questions, q)
+	}
+	if len(problems) > 0 {
+		return nil, &ExamDefinitionError{Errors: problems}
+	}
+
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.examRepo.AddQuestions(ctx, exam.ID, questions); err != nil {
+			return err
+		}
+		added := make([]map[string]interface{}, len(questions))
+		for i, q := range questions {
+			added[i] = map[string]interface{}{"question_id": q.ID, "bank_item_id": q.BankItemID}
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			TenantID:   &exam.TenantID,
+			EntityType: "exam",
+			EntityID:   exam.ID,
+			EventType:  "bank_items_added",
+			Changes: map[string]interface{}{
+				"use":       use,
+				"questions": added,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return questions, nil
+}
+
+// validateBankItem checks a bank item's question as an exam's question is
+// checked, and its tags, trimming its text as it goes. Paths name the
+// item's fields.
+func validateBankItem(item *domain.BankItem) []domain.FieldError {
+	doc := &domain.QuestionDocument{
+		Text:       item.QuestionText,
+		Points:     item.Points,
+		AnswerType: item.AnswerType,
+		AnswerKey:  item.AnswerKey,
+		Choices:    item.Choices,
+		VisualAids: item.VisualAids,
+		Rubric:     item.Rubric,
+	}
+	problems := validateQuestionDocument(doc, "")
+	for i := range problems {
+		if problems[i].Path == "text" {
+			problems[i].Path = "question_text"
+		}
+	}
+	item.QuestionText, item.AnswerKey = doc.Text, doc.AnswerKey
+	item.Topic = strings.TrimSpace(item.Topic)
+	item.LearningObjective = strings.TrimSpace(item.LearningObjective)
+	item.GradeLevel = strings.TrimSpace(item.GradeLevel)
+	return append(problems, validateBankTags(item)...)
+}
+
+func validateBankTags(item *domain.BankItem) []domain.FieldError {
+	switch item.Difficulty {
+	case "", domain.DifficultyEasy, domain.DifficultyMedium, domain.DifficultyHard:
+		return nil
+	}
+	return []domain.FieldError{{
+		Path: "difficulty",
+		Message: fmt.Sprintf("must be one of %s, %s or %s",
+			domain.DifficultyEasy, domain.DifficultyMedium, domain.DifficultyHard),
+	}}
+}
+
+// bankItemChanges is what the audit log records of a bank item.
+func bankItemChanges(item *domain.BankItem) map[string]interface{} {
+	return map[string]interface{}{
+		"text":               item.QuestionText,
+		"points":             item.Points,
+		"topic":              item.Topic,
+		"learning_objective": item.LearningObjective,
+		"difficulty":         item.Difficulty,
+		"grade_level":        item.GradeLevel,
+	}
+}
@@ -0,0 +1,530 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCurvePolicy is returned for a curve policy that can't be
+// applied to an exam.
+var ErrInvalidCurvePolicy = errors.New("invalid curve policy")
+
+// Histogram bin counts
+const (
+	DefaultHistogramBins = 10
+	MaxHistogramBins     = 100
+)
+
+// distributionPercentiles are the percentiles a distribution reports.
+var distributionPercentiles = []int{10, 25, 50, 75, 90}
+
+// Distribution describes a set of scores. Percentiles are interpolated
+// between scores and keyed p10, p25, and so on. The histogram splits zero
+// to the maximum into equal bins, each including its lower bound; the last
+// also holds the maximum and anything above it.
+type Distribution struct {
+	Count       int                `json:"count"`
+	Mean        float64            `json:"mean"`
+	StdDev      float64            `json:"std_dev"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Percentiles map[string]float64 `json:"percentiles"`
+	Histogram   []HistogramBin     `json:"histogram"`
+}
+
+type HistogramBin struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+// QuestionDistribution is how the scores given for a question are spread.
+type QuestionDistribution struct {
+	QuestionID uuid.UUID `json:"question_id"`
+	Label      string    `json:"label"`
+	Points     int       `json:"points"`
+	Distribution
+}
+
+// ExamDistribution is how an exam's scores are spread: the totals of the
+// submissions graded on every question, the same totals under the curve
+// applied to the exam if any, and the scores of each question.
+type ExamDistribution struct {
+	ExamID     uuid.UUID              `json:"exam_id"`
+	MaxTotal   float64                `json:"max_total"`
+	Incomplete int                    `json:"incomplete"`
+	Totals     Distribution           `json:"totals"`
+	Curve      *CurvedDistribution    `json:"curve,omitempty"`
+	Questions  []QuestionDistribution `json:"questions"`
+	Warnings   []string               `json:"warnings,omitempty"`
+}
+
+// CurvedDistribution is how the totals are spread under an exam's curve.
+type CurvedDistribution struct {
+	Version  int                `json:"version"`
+	Policy   domain.CurvePolicy `json:"policy"`
+	Adjusted Distribution       `json:"adjusted"`
+}
+
+// CurvePreview is what applying a curve policy to an exam would do.
+type CurvePreview struct {
+	ExamID     uuid.UUID            `json:"exam_id"`
+	Policy     domain.CurvePolicy   `json:"policy"`
+	MaxTotal   float64              `json:"max_total"`
+	Incomplete int                  `json:"incomplete"`
+	Before     Distribution         `json:"before"`
+	After      Distribution         `json:"after"`
+	Scores     []domain.CurvedScore `json:"scores"`
+	Warnings   []string             `json:"warnings,omitempty"`
+}
+
+type CurveService struct {
+	gradeRepo *postgres.GradeRepo
+	examRepo  *postgres.ExamRepo
+	curveRepo *postgres.CurveRepo
+	auditRepo *postgres.AuditRepo
+	lti       *postgres.LTIRepo
+	roster    *RosterService
+}
+
+func NewCurveService(gradeRepo *postgres.GradeRepo, examRepo *postgres.ExamRepo, curveRepo *postgres.CurveRepo, auditRepo *postgres.AuditRepo, lti *postgres.LTIRepo, roster *RosterService) *CurveService {
+	return &CurveService{
+		gradeRepo: gradeRepo,
+		examRepo:  examRepo,
+		curveRepo: curveRepo,
+		auditRepo: auditRepo,
+		lti:       lti,
+		roster:    roster,
+	}
+}
+
+// examTotals are the totals of an exam's submissions graded on every
+// question, which are the ones distributions and curves cover.
+type examTotals struct {
+	exam       *domain.Exam
+	maxTotal   float64
+	totals     []postgres.SubmissionTotal
+	incomplete int
+}
+
+func (s *CurveService) examTotals(ctx context.Context, examID uuid.UUID) (*examTotals, error) {
+	exam, err := s.examRepo.GetByID(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	all, err := s.gradeRepo.SubmissionTotals(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	t := &examTotals{exam: exam}
+	for _, q := range exam.Questions {
+		t.maxTotal += float64(q.Points)
+	}
+	for _, total := range all {
+		if total.Graded < len(exam.Questions) {
+			t.incomplete++
+			continue
+		}
+		t.totals = append(t.totals, total)
+	}
+	return t, nil
+}
+
+func (t *examTotals) rawTotals() []float64 {
+	values := make([]float64, len(t.totals))
+	for i, total := range t.totals {
+		values[i] = total.Total
+	}
+	return values
+}
+
+func (t *examTotals) warnings() []string {
+	if t.incomplete == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("%d submissions without a grade for every question were left out", t.incomplete)}
+}
+
+// Distribution describes how an exam's totals and question scores are
+// spread, with bins histogram bins each.
+func (s *CurveService) Distribution(ctx context.Context, examID uuid.UUID, bins int) (*ExamDistribution, error) {
+	t, err := s.examTotals(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	stats, counts, err := s.gradeRepo.QuestionDistributions(ctx, examID, bins)
+	if err != nil {
+		return nil, err
+	}
+
+	dist := &ExamDistribution{
+		ExamID:     examID,
+		MaxTotal:   t.maxTotal,
+		Incomplete: t.incomplete,
+		Totals:     newDistribution(t.rawTotals(), t.maxTotal, bins),
+		Questions:  []QuestionDistribution{},
+		Warnings:   t.warnings(),
+	}
+
+	curve, err := s.curveRepo.Active(ctx, examID)
+	switch {
+	case err == nil:
+		adjusted := make([]float64, len(curve.Scores))
+		for i, score := range curve.Scores {
+			adjusted[i] = score.AdjustedTotal
+		}
+		dist.Curve = &CurvedDistribution{
+			Version:  curve.Version,
+			Policy:   curve.Policy,
+			Adjusted: newDistribution(adjusted, curve.MaxTotal, bins),
+		}
+		changed := 0
+		for _, total := range t.totals {
+			if _, ok := curve.CurvedTotal(total.SubmissionID, total.Total); !ok {
+				changed++
+			}
+		}
+		if changed > 0 {
+			dist.Warnings = append(dist.Warnings, fmt.Sprintf(
+				"%d submissions were graded or regraded since curve version %d was applied; apply the curve again to adjust them",
+				changed, curve.Version))
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	byQuestion := make(map[uuid.UUID]postgres.QuestionScoreStat, len(stats))
+	for _, stat := range stats {
+		byQuestion[stat.QuestionID] = stat
+	}
+	binCounts := make(map[uuid.UUID]map[int]int)
+	for _, c := range counts {
+		if binCounts[c.QuestionID] == nil {
+			binCounts[c.QuestionID] = make(map[int]int)
+		}
+		binCounts[c.QuestionID][c.Bin] = c.Count
+	}
+	questions := append([]domain.Question(nil), t.exam.Questions...)
+	sort.SliceStable(questions, func(i, j int) bool {
+		return questionNumberLess(questions[i].QuestionNumber, questions[j].QuestionNumber)
+	})
+	for i, q := range questions {
+		stat := byQuestion[q.ID]
+		qd := QuestionDistribution{
+			QuestionID: q.ID,
+			Label:      questionLabel(q, i, HeaderQuestionNumber),
+			Points:     q.Points,
+			Distribution: Distribution{
+				Count:       stat.Responses,
+				Mean:        stat.Mean,
+				StdDev:      stat.StdDev,
+				Min:         stat.Min,
+				Max:         stat.Max,
+				Percentiles: map[string]float64{},
+				Histogram:   histogramBins(float64(q.Points), bins),
+			},
+		}
+		if stat.Responses > 0 {
+			qd.Percentiles = map[string]float64{
+				"p10": stat.P10, "p25": stat.P25, "p50": stat.P50, "p75": stat.P75, "p90": stat.P90,
+			}
+		}
+		for bin, count := range binCounts[q.ID] {
+			if bin >= 1 && bin <= len(qd.Histogram) {
+				qd.Histogram[bin-1].Count = count
+			}
+		}
+		dist.Questions = append(dist.Questions, qd)
+	}
+	return dist, nil
+}
+
+// Preview works out what applying a curve policy to an exam would do to its
+// totals, without applying it.
+func (s *CurveService) Preview(ctx context.Context, examID uuid.UUID, policy domain.CurvePolicy, bins int) (*CurvePreview, error) {
+	if err := validateCurvePolicy(policy); err != nil {
+		return nil, err
+	}
+	t, err := s.examTotals(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := curveScores(policy, t.totals, t.maxTotal)
+	if err != nil {
+		return nil, err
+	}
+	adjusted := make([]float64, len(scores))
+	for i, score := range scores {
+		adjusted[i] = score.AdjustedTotal
+	}
+	return &CurvePreview{
+		ExamID:     examID,
+		Policy:     policy,
+		MaxTotal:   t.maxTotal,
+		Incomplete: t.incomplete,
+		Before:     newDistribution(t.rawTotals(), t.maxTotal, bins),
+		After:      newDistribution(adjusted, t.maxTotal, bins),
+		Scores:     scores,
+		Warnings:   t.warnings(),
+	}, nil
+}
+
+// Apply curves the totals of an exam's submissions graded on every
+// question, as the next version of its curve. Their grades are left as they
+// are.
+func (s *CurveService) Apply(ctx context.Context, examID uuid.UUID, policy domain.CurvePolicy, note string) (*domain.GradeCurve, error) {
+	if err := validateCurvePolicy(policy); err != nil {
+		return nil, err
+	}
+	if err := s.roster.AuthorizeExamGradeChange(ctx, examID); err != nil {
+		return nil, err
+	}
+	t, err := s.examTotals(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := curveScores(policy, t.totals, t.maxTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	curve := &domain.GradeCurve{
+		TenantID: t.exam.TenantID,
+		ExamID:   examID,
+		Policy:   policy,
+		MaxTotal: t.maxTotal,
+		Scores:   scores,
+		Note:     note,
+	}
+	for _, score := range scores {
+		curve.RawMean += score.RawTotal / float64(len(scores))
+		curve.AdjustedMean += score.AdjustedTotal / float64(len(scores))
+	}
+	curve.RawMean, curve.AdjustedMean = roundScore(curve.RawMean), roundScore(curve.AdjustedMean)
+	if actor, err := auth.GetActor(ctx); err == nil {
+		curve.AppliedBy = &actor.UserID
+	}
+
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.curveRepo.Create(ctx, curve); err != nil {
+			return err
+		}
+		if err := s.queueExamScores(ctx, examID); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			TenantID:   &curve.TenantID,
+			EntityType: "exam",
+			EntityID:   examID,
+			EventType:  "curve_applied",
+			Changes: map[string]interface{}{
+				"version":       curve.Version,
+				"policy":        curve.Policy,
+				"submissions":   len(scores),
+				"raw_mean":      curve.RawMean,
+				"adjusted_mean": curve.AdjustedMean,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return curve, nil
+}
+
+// ListCurves returns the versions of an exam's curve, latest first.
+func (s *CurveService) ListCurves(ctx context.Context, examID uuid.UUID) ([]domain.GradeCurve, error) {
+	curves, err := s.curveRepo.ListByExam(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	if curves == nil {
+		curves = []domain.GradeCurve{}
+	}
+	return curves, nil
+}
+
+// GetCurve returns a version of an exam's curve with its scores.
+func (s *CurveService) GetCurve(ctx context.Context, examID uuid.UUID, version int) (*domain.GradeCurve, error) {
+	return s.curveRepo.GetVersion(ctx, examID, version)
+}
+
+// ActiveCurve returns the curve applied to an exam with its scores.
+func (s *CurveService) ActiveCurve(ctx context.Context, examID uuid.UUID) (*domain.GradeCurve, error) {
+	return s.curveRepo.Active(ctx, examID)
+}
+
+// RemoveCurve leaves an exam's totals uncurved. The curve stays on record.
+func (s *CurveService) RemoveCurve(ctx context.Context, examID uuid.UUID) error {
+	tenantID, err := auth.GetTenantID(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.roster.AuthorizeExamGradeChange(ctx, examID); err != nil {
+		return err
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		version, err := s.curveRepo.Deactivate(ctx, examID)
+		if err != nil {
+			return err
+		}
+		if err := s.queueExamScores(ctx, examID); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			TenantID:   &tenantID,
+			EntityType: "exam",
+			EntityID:   examID,
+			EventType:  "curve_removed",
+			Changes:    map[string]interface{}{"version": version},
+		})
+	})
+}
+
+// queueExamScores queues an exam's totals for publishing to the LMS line
+// items it is bound to, so that they follow its curve. It joins the
+// transaction in ctx, so the totals are queued only with the curve change.
+func (s *CurveService) queueExamScores(ctx context.Context, examID uuid.UUID) error {
+	if s.lti == nil {
+		return nil
+	}
+	_, err := s.lti.QueueExam(ctx, examID)
+	return err
+}
+
+// activeCurve is the curve applied to an exam, or nil if it has none.
+func activeCurve(ctx context.Context, curveRepo *postgres.CurveRepo, examID uuid.UUID) (*domain.GradeCurve, error) {
+	if curveRepo == nil {
+		return nil, nil
+	}
+	curve, err := curveRepo.Active(ctx, examID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return curve, err
+}
+
+func validateCurvePolicy(policy domain.CurvePolicy) error {
+	switch policy.Method {
+	case domain.CurveLinear:
+		if policy.TargetMean <= 0 || policy.TargetMean > 100 {
+			return fmt.Errorf("%w: target_mean must be a percentage above 0 and at most 100", ErrInvalidCurvePolicy)
+		}
+	case domain.CurveSqrt:
+	case domain.CurveFlat:
+		if policy.Bonus == 0 {
+			return fmt.Errorf("%w: bonus must not be zero", ErrInvalidCurvePolicy)
+		}
+	default:
+		return fmt.Errorf("%w: unknown method %q", ErrInvalidCurvePolicy, policy.Method)
+	}
+	return nil
+}
+
+// curveScores applies a valid policy to totals out of maxTotal.
+func curveScores(policy domain.CurvePolicy, totals []postgres.SubmissionTotal, maxTotal float64) ([]domain.CurvedScore, error) {
+	if maxTotal <= 0 {
+		return nil, fmt.Errorf("%w: the exam has no points to curve", ErrInvalidCurvePolicy)
+	}
+	if len(totals) == 0 {
+		return nil, fmt.Errorf("%w: no submission has a grade for every question", ErrInvalidCurvePolicy)
+	}
+	var adjust func(float64) float64
+	switch policy.Method {
+	case domain.CurveLinear:
+		mean := 0.0
+		for _, total := range totals {
+			mean += total.Total / float64(len(totals))
+		}
+		if mean <= 0 {
+			return nil, fmt.Errorf("%w: a mean of zero can't be scaled", ErrInvalidCurvePolicy)
+		}
+		factor := policy.TargetMean / 100 * maxTotal / mean
+		adjust = func(v float64) float64 { return v * factor }
+	case domain.CurveSqrt:
+		adjust = func(v float64) float64 { return math.Sqrt(math.Max(v, 0)/maxTotal) * maxTotal }
+	case domain.CurveFlat:
+		adjust = func(v float64) float64 { return v + policy.Bonus }
+	}
+
+	scores := make([]domain.CurvedScore, len(totals))
+	for i, total := range totals {
+		adjusted := math.Max(adjust(total.Total), 0)
+		if policy.CapAtMax {
+			adjusted = math.Min(adjusted, maxTotal)
+		}
+		scores[i] = domain.CurvedScore{
+			SubmissionID:  total.SubmissionID,
+			StudentID:     total.StudentID,
+			RawTotal:      total.Total,
+			AdjustedTotal: roundScore(adjusted),
+		}
+	}
+	return scores, nil
+}
+
+func roundScore(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// newDistribution describes scores out of maxScore.
+func newDistribution(values []float64, maxScore float64, bins int) Distribution {
+	d := Distribution{
+		Count:       len(values),
+		Percentiles: map[string]float64{},
+		Histogram:   histogramBins(maxScore, bins),
+	}
+	if len(values) == 0 {
+		return d
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	d.Min, d.Max = sorted[0], sorted[len(sorted)-1]
+	for _, v := range sorted {
+		d.Mean += v / float64(len(sorted))
+	}
+	for _, v := range sorted {
+		d.StdDev += (v - d.Mean) * (v - d.Mean) / float64(len(sorted))
+	}
+	d.StdDev = math.Sqrt(d.StdDev)
+	for _, p := range distributionPercentiles {
+		d.Percentiles[fmt.Sprintf("p%d", p)] = percentile(sorted, float64(p)/100)
+	}
+	if maxScore > 0 {
+		for _, v := range sorted {
+			i := int(math.Floor(v / maxScore * float64(bins)))
+			d.Histogram[min(max(i, 0), bins-1)].Count++
+		}
+	}
+	return d
+}
+
+// histogramBins splits zero to maxScore into equal, empty bins.
+func histogramBins(maxScore float64, bins int) []HistogramBin {
+	hist := make([]HistogramBin, bins)
+	width := maxScore / float64(bins)
+	for i := range hist {
+		hist[i] = HistogramBin{From: roundScore(float64(i) * width), To: roundScore(float64(i+1) * width)}
+	}
+	return hist
+}
+
+// percentile interpolates the p quantile of sorted values, as
+// percentile_cont does.
+func percentile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	if lo+1 >= len(sorted) {
+		return sorted[lo]
+	}
+	return sorted[lo] + (pos-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harama/internal/domain"
+	"harama/internal/qti"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// ExamDefinitionFormats maps the formats an exam's definition is exported
+// and imported in to their content types.
+var ExamDefinitionFormats = map[string]string{
+	"json": "application/json",
+	"yaml": "application/yaml",
+	"qti":  qti.ContentType,
+}
+
+// ErrInvalidExamDefinition is returned for a definition that can't be read
+// at all; one that can, but has mistakes, is an *ExamDefinitionError.
+var ErrInvalidExamDefinition = errors.New("invalid exam definition")
+
+// ExamDefinitionError lists every mistake found in an imported definition.
+type ExamDefinitionError struct {
+	Errors []domain.FieldError
+}
+
+func (e *ExamDefinitionError) Error() string {
+	first := e.Errors[0]
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("exam definition has an error: %s: %s", first.Path, first.Message)
+	}
+	return fmt.Sprintf("exam definition has %d errors, the first: %s: %s", len(e.Errors), first.Path, first.Message)
+}
+
+// ExamDefinitionExport is a written exam definition.
+type ExamDefinitionExport struct {
+	Data        []byte
+	ContentType string
+	Filename    string
+}
+
+// ExportDefinition writes the complete definition of an exam of the tenant
+// in ctx as a JSON or YAML exam document, or a QTI 2.1 content package.
+// Questions are in the order of their numbers.
+func (s *ExamService) ExportDefinition(ctx context.Context, examID uuid.UUID, format string) (*ExamDefinitionExport, error) {
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := ExamDefinitionFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+
+	exam, err := s.repo.GetByID(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	doc := ExamToDocument(exam)
+
+	var data []byte
+	ext := "." + format
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(doc, "", "  ")
+	case "yaml":
+		data, err = yaml.Marshal(doc)
+	case "qti":
+		var buf bytes.Buffer
+		err = qti.Write(&buf, doc)
+		data, ext = buf.Bytes(), "_qti.zip"
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name := fileSlug(exam.Title)
+	if name == "" {
+		name = exam.ID.String()
+	}
+	return &ExamDefinitionExport{Data: data, ContentType: contentType, Filename: name + "_exam" + ext}, nil
+}
+
+// ImportDefinition creates an exam, its questions and their rubrics from a
+// definition in one transaction. Nothing is created unless the whole
+// definition is valid; its mistakes are returned together as an
+// *ExamDefinitionError.
+func (s *ExamService) ImportDefinition(ctx context.Context, tenantID uuid.UUID, format string, data []byte) (*domain.Exam, error) {
+	doc, problems, err := readExamDocument(format, data)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, validateExamDocument(doc)...)
+	if len(problems) > 0 {
+		return nil, &ExamDefinitionError{Errors: problems}
+	}
+
+	exam := DocumentToExam(doc)
+	exam.TenantID = tenantID
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateWithQuestions(ctx, exam); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			TenantID:   &exam.TenantID,
+			EntityType: "exam",
+			EntityID:   exam.ID,
+			EventType:  "imported",
+			Changes: map[string]interface{}{
+				"title":     exam.Title,
+				"subject":   exam.Subject,
+				"questions": len(exam.Questions),
+				"format":    format,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return exam, nil
+}
+
+// readExamDocument parses a definition. Fields an exam document doesn't
+// have are errors rather than ignored, so that a misspelt one isn't lost.
+func readExamDocument(format string, data []byte) (*domain.ExamDocument, []domain.FieldError, error) {
+	doc := new(domain.ExamDocument)
+	switch format {
+	case "", "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(doc); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidExamDefinition, err)
+		}
+	case "yaml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(doc); err != nil && !errors.Is(err, io.EOF) {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidExamDefinition, err)
+		}
+	case "qti":
+		doc, problems, err := qti.Read(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidExamDefinition, err)
+		}
+		return doc, problems, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+	return doc, nil, nil
+}
+
+// validateExamDocument checks a definition the way the exam's grading will
+// rely on it, trimming its text as it goes.
+func validateExamDocument(doc *domain.ExamDocument) []domain.FieldError {
+	var errs []domain.FieldError
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, domain.FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if doc.Format != "" && doc.Format != domain.ExamDocumentFormat {
+		fail("format", "unknown format %q, expected %q", doc.Format, domain.ExamDocumentFormat)
+	}
+	if doc.Version > domain.ExamDocumentVersion {
+		fail("version", "version %d is newer than the %d this server reads", doc.Version, domain.ExamDocumentVersion)
+	}
+	if doc.Title = strings.TrimSpace(doc.Title); doc.Title == "" {
+		fail("title", "is required")
+	}
+	if len(doc.Questions) == 0 {
+		fail("questions", "an exam needs at least one question")
+	}
+
+	numbers := map[string]int{}
+	for i := range doc.Questions {
+		q := &doc.Questions[i]
+		path := fmt.Sprintf("questions[%d]", i)
+		q.Number, q.Group = strings.TrimSpace(q.Number), strings.TrimSpace(q.Group)
+
+		if q.Number != "" {
+			if first, dup := numbers[q.Number]; dup {
+				fail(path+".number", "%q is also the number of questions[%d]", q.Number, first)
+			} else {
+				numbers[q.Number] = i
+			}
+		}
+		errs = append(errs, validateQuestionDocument(q, path+".")...)
+	}
+	return errs
+}
+
+// validateQuestionDocument checks a question, wherever it is defined, the
+// way its grading will rely on it. Paths of its mistakes start with prefix.
+func validateQuestionDocument(q *domain.QuestionDocument, prefix string) []domain.FieldError {
+	var errs []domain.FieldError
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, domain.FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	q.Text, q.AnswerKey = strings.TrimSpace(q.Text), strings.TrimSpace(q.AnswerKey)
+	if q.Text == "" {
+		fail(prefix+"text", "is required")
+	}
+	if q.Points <= 0 {
+		fail(prefix+"points", "must be positive")
+	}
+
+	switch q.AnswerType {
+	case domain.AnswerTypeMCQ:
+		if len(q.Choices) < 2 {
+			fail(prefix+"choices", "a multiple choice question needs at least two choices")
+		}
+		ids := map[string]bool{}
+		for j := range q.Choices {
+			c := &q.Choices[j]
+			c.ID, c.Text = strings.TrimSpace(c.ID), strings.TrimSpace(c.Text)
+			switch {
+			case c.ID == "":
+				fail(fmt.Sprintf("%schoices[%d].id", prefix, j), "is required")
+			case ids[c.ID]:
+				fail(fmt.Sprintf("%schoices[%d].id", prefix, j), "%q is the ID of another choice", c.ID)
+			}
+			ids[c.ID] = true
+			if c.Text == "" {
+				fail(fmt.Sprintf("%schoices[%d].text", prefix, j), "is required")
+			}
+		}
+		key := domain.SplitAnswerKey(q.AnswerKey)
+		if len(key) == 0 {
+			fail(prefix+"answer_key", "is required for a multiple choice question")
+		}
+		for _, id := range key {
+			if !ids[id] {
+				fail(prefix+"answer_key", "%q is not one of the choices", id)
+			}
+		}
+	case domain.AnswerTypeShortAnswer, domain.AnswerTypeEssay, domain.AnswerTypeDiagram:
+		if len(q.Choices) > 0 {
+			fail(prefix+"choices", "only multiple choice questions have choices")
+		}
+	default:
+		fail(prefix+"answer_type", "must be one of %s, %s, %s or %s",
+			domain.AnswerTypeShortAnswer, domain.AnswerTypeEssay, domain.AnswerTypeMCQ, domain.AnswerTypeDiagram)
+	}
+
+	if q.Rubric != nil {
+		for _, e := range ValidateRubric(q.Rubric, q.Points) {
+			e.Path = prefix + "rubric." + e.Path
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// ExamToDocument is the portable definition of an exam, its questions in
+// the order of their numbers.
+func ExamToDocument(exam *domain.Exam) *domain.ExamDocument {
+	doc := &domain.ExamDocument{
+		Format:      domain.ExamDocumentFormat,
+		Version:     domain.ExamDocumentVersion,
+		Title:       exam.Title,
+		Subject:     exam.Subject,
+		Description: exam.Description,
+		Questions:   []domain.QuestionDocument{},
+	}
+
+	questions := append([]domain.Question(nil), exam.Questions...)
+	sort.SliceStable(questions, func(i, j int) bool {
+		return questionNumberLess(questions[i].QuestionNumber, questions[j].QuestionNumber)
+	})
+	for _, q := range questions {
+		qd := domain.QuestionDocument{
+			Number:     q.QuestionNumber,
+			Group:      q.QuestionGroup,
+			Text:       q.QuestionText,
+			Points:     q.Points,
+			AnswerType: q.AnswerType,
+			AnswerKey:  q.AnswerKey,
+			Choices:    q.Choices,
+			VisualAids: q.VisualAids,
+		}
+		if q.Rubric != nil {
+			qd.Rubric = domain.NewRubricDocument(q.Rubric)
+		}
+		doc.Questions = append(doc.Questions, qd)
+	}
+	return doc
+}
+
+// DocumentToExam is the exam a definition describes, without IDs.
+func DocumentToExam(doc *domain.ExamDocument) *domain.Exam {
+	exam := &domain.Exam{
+		Title:       doc.Title,
+		Subject:     doc.Subject,
+		Description: doc.Description,
+	}
+	for _, qd := range doc.Questions {
+		q := domain.Question{
+			QuestionText:   qd.Text,
+			Points:         qd.Points,
+			AnswerType:     qd.AnswerType,
+			QuestionNumber: qd.Number,
+			QuestionGroup:  qd.Group,
+			AnswerKey:      qd.AnswerKey,
+			Choices:        qd.Choices,
+			VisualAids:     qd.VisualAids,
+		}
+		if qd.Rubric != nil {
+			q.Rubric = qd.Rubric.ToRubric()
+		}
+		exam.Questions = append(exam.Questions, q)
+	}
+	return exam
+}
+
+// questionNumberLess orders question numbers naturally, 2 before 10 and 1b
+// before 2, with questions without a number last.
+func questionNumberLess(a, b string) bool {
+	if a == "" || b == "" {
+		return a != "" && b == ""
+	}
+	for a != "" && b != "" {
+		ca, restA := numberChunk(a)
+		cb, restB := numberChunk(b)
+		na, errA := strconv.Atoi(ca)
+		nb, errB := strconv.Atoi(cb)
+		switch {
+		case errA == nil && errB == nil && na != nb:
+			return na < nb
+		case (errA == nil) != (errB == nil):
+			return errA == nil
+		case ca != cb:
+			return ca < cb
+		}
+		a, b = restA, restB
+	}
+	return len(a) < len(b)
+}
+
+// numberChunk splits off the leading run of digits or of other characters.
+func numberChunk(s string) (string, string) {
+	digit := unicode.IsDigit(rune(s[0]))
+	i := 1
+	for i < len(s) && unicode.IsDigit(rune(s[i])) == digit {
+		i++
+	}
+	return s[:i], s[i:]
+}
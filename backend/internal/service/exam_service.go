@@ -4,6 +4,7 @@ import (
 	"context"
 	"harama/internal/domain"
 	"harama/internal/repository/postgres"
+	"strings"
 
 	"github.com/google/uuid"
 )
@@ -11,19 +12,24 @@ import (
 type ExamService struct {
 	repo      *postgres.ExamRepo
 	auditRepo *postgres.AuditRepo
+	webhooks  *postgres.WebhookRepo
 }
 
-func NewExamService(repo *postgres.ExamRepo, auditRepo *postgres.AuditRepo) *ExamService {
+func NewExamService(repo *postgres.ExamRepo, auditRepo *postgres.AuditRepo, webhooks *postgres.WebhookRepo) *ExamService {
 	return &ExamService{
 		repo:      repo,
 		auditRepo: auditRepo,
+		webhooks:  webhooks,
 	}
 }
 
 func (s *ExamService) CreateExam(ctx context.Context, exam *domain.Exam) error {
-	err := s.repo.Create(ctx, exam)
-	if err == nil {
-		_ = s.auditRepo.Save(ctx, &domain.AuditLog{
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, exam); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			TenantID:   &exam.TenantID,
 			EntityType: "exam",
 			EntityID:   exam.ID,
 			EventType:  "created",
@@ -32,19 +38,70 @@ func (s *ExamService) CreateExam(ctx context.Context, exam *domain.Exam) error {
 				"subject": exam.Subject,
 			},
 		})
-	}
-	return err
+	})
 }
 
 func (s *ExamService) GetExam(ctx context.Context, id uuid.UUID) (*domain.Exam, error) {
 	return s.repo.GetByID(ctx, id)
 }
 
+// CloneExam copies an exam of the tenant in ctx to use as a template: its
+// questions and their rubrics, without its submissions or grades, under
+// title, or the original's title marked as a copy. Questions from the
+// question bank keep their items, and those linked to them stay linked.
+func (s *ExamService) CloneExam(ctx context.Context, id uuid.UUID, title string) (*domain.Exam, error) {
+	src, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if title = strings.TrimSpace(title); title == "" {
+		title = src.Title + " (copy)"
+	}
+
+	clone := &domain.Exam{
+		Title:       title,
+		Subject:     src.Subject,
+		Description: src.Description,
+		TenantID:    src.TenantID,
+	}
+	for _, q := range src.Questions {
+		if q.Rubric != nil {
+			rubric := *q.Rubric
+			q.Rubric = &rubric
+		}
+		clone.Questions = append(clone.Questions, q)
+	}
+
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateWithQuestions(ctx, clone); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			TenantID:   &clone.TenantID,
+			EntityType: "exam",
+			EntityID:   clone.ID,
+			EventType:  "cloned",
+			Changes: map[string]interface{}{
+				"title":     clone.Title,
+				"subject":   clone.Subject,
+				"questions": len(clone.Questions),
+				"source_id": src.ID,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
 func (s *ExamService) AddQuestion(ctx context.Context, examID uuid.UUID, question *domain.Question) error {
 	question.ExamID = examID
-	err := s.repo.CreateQuestion(ctx, question)
-	if err == nil {
-		_ = s.auditRepo.Save(ctx, &domain.AuditLog{
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateQuestion(ctx, question); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
 			EntityType: "question",
 			EntityID:   question.ID,
 			EventType:  "created",
@@ -54,15 +111,16 @@ func (s *ExamService) AddQuestion(ctx context.Context, examID uuid.UUID, questio
 				"points":  question.Points,
 			},
 		})
-	}
-	return err
+	})
 }
 
 func (s *ExamService) SetRubric(ctx context.Context, questionID uuid.UUID, rubric *domain.Rubric) error {
 	rubric.QuestionID = questionID
-	err := s.repo.UpdateRubric(ctx, rubric)
-	if err == nil {
-		_ = s.auditRepo.Save(ctx, &domain.AuditLog{
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdateRubric(ctx, rubric); err != nil {
+			return err
+		}
+		err := s.auditRepo.Save(ctx, &domain.AuditLog{
 			EntityType: "rubric",
 			EntityID:   rubric.ID,
 			EventType:  "updated",
@@ -70,8 +128,15 @@ func (s *ExamService) SetRubric(ctx context.Context, questionID uuid.UUID, rubri
 				"question_id": questionID,
 			},
 		})
-	}
-	return err
+		if err != nil {
+			return err
+		}
+		return s.webhooks.Publish(ctx, domain.EventRubricUpdated, map[string]interface{}{
+			"rubric_id":   rubric.ID,
+			"question_id": questionID,
+			"source":      "teacher",
+		})
+	})
 }
 
 func (s *ExamService) ListExams(ctx context.Context, tenantID uuid.UUID) ([]domain.Exam, error) {
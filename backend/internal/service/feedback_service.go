@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"harama/internal/ai"
+	"harama/internal/auth"
 	"harama/internal/domain"
 	"harama/internal/pkg/utils"
 	"harama/internal/repository/postgres"
@@ -15,16 +16,22 @@ type FeedbackService struct {
 	gradeRepo  *postgres.GradeRepo
 	examRepo   *postgres.ExamRepo
 	auditRepo  *postgres.AuditRepo
+	webhooks   *postgres.WebhookRepo
+	lti        *postgres.LTIRepo
 	aiProvider ai.Provider
+	profiles   *StudentProfileService
 }
 
-func NewFeedbackService(repo *postgres.FeedbackRepo, gradeRepo *postgres.GradeRepo, examRepo *postgres.ExamRepo, auditRepo *postgres.AuditRepo, aiProvider ai.Provider) *FeedbackService {
+func NewFeedbackService(repo *postgres.FeedbackRepo, gradeRepo *postgres.GradeRepo, examRepo *postgres.ExamRepo, auditRepo *postgres.AuditRepo, webhooks *postgres.WebhookRepo, lti *postgres.LTIRepo, aiProvider ai.Provider, profiles *StudentProfileService) *FeedbackService {
 	return &FeedbackService{
 		repo:       repo,
 		gradeRepo:  gradeRepo,
 		examRepo:   examRepo,
 		auditRepo:  auditRepo,
+		webhooks:   webhooks,
+		lti:        lti,
 		aiProvider: aiProvider,
+		profiles:   profiles,
 	}
 }
 
@@ -52,31 +59,13 @@ func (s *FeedbackService) CaptureOverrideFeedback(ctx context.Context, submissio
 		aiScore = *originalGrade.AIScore
 	}
 
-	// 2. Update the final grade in the repository
+	// 2. Apply the override to the final grade
 	originalGrade.OverrideScore = &teacherScore
 	originalGrade.FinalScore = teacherScore
 	originalGrade.Status = domain.GradeStatusOverridden
 	originalGrade.UpdatedAt = utils.CurrentTime()
 
-	err = s.gradeRepo.SaveFinalGrade(ctx, originalGrade)
-	if err != nil {
-		return err
-	}
-
-	// Log audit event for teacher override
-	_ = s.auditRepo.Save(ctx, &domain.AuditLog{
-		EntityType: "grade",
-		EntityID:   originalGrade.ID,
-		EventType:  "teacher_override",
-		ActorType:  "teacher",
-		Changes: map[string]interface{}{
-			"previous_score": aiScore,
-			"new_score":      teacherScore,
-			"reason":         teacherReason,
-		},
-	})
-
-	// 3. Log the feedback event
+	// 3. Save the grade, its audit entry and the feedback event together
 	event := &domain.FeedbackEvent{
 		ID:            uuid.New(),
 		QuestionID:    questionID,
@@ -88,7 +77,43 @@ func (s *FeedbackService) CaptureOverrideFeedback(ctx context.Context, submissio
 		TeacherReason: teacherReason,
 	}
 
-	return s.repo.SaveFeedbackEvent(ctx, event)
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.gradeRepo.SaveFinalGrade(ctx, originalGrade); err != nil {
+			return err
+		}
+
+		// Log audit event for teacher override, attributed to the actor in ctx
+		err := s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "grade",
+			EntityID:   originalGrade.ID,
+			EventType:  "teacher_override",
+			Changes: map[string]interface{}{
+				"submission_id":  submissionID,
+				"question_id":    questionID,
+				"previous_score": aiScore,
+				"new_score":      teacherScore,
+				"reason":         teacherReason,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := s.repo.SaveFeedbackEvent(ctx, event); err != nil {
+			return err
+		}
+		if err := queueScores(ctx, s.lti, submissionID); err != nil {
+			return err
+		}
+		return s.webhooks.Publish(ctx, domain.EventGradeOverridden, map[string]interface{}{
+			"grade_id":       originalGrade.ID,
+			"submission_id":  submissionID,
+			"question_id":    questionID,
+			"previous_score": aiScore,
+			"new_score":      teacherScore,
+			"reason":         teacherReason,
+		})
+	})
 }
 
 func (s *FeedbackService) GenerateStudentFeedback(ctx context.Context, submissionID uuid.UUID, questionID uuid.UUID, studentName string) (string, error) {
@@ -110,17 +135,28 @@ func (s *FeedbackService) GenerateStudentFeedback(ctx context.Context, submissio
 		return "", nil // Grade not found
 	}
 
-	// 2. Get historical feedback for this student (simplified to same submission for now)
-	history, err := s.repo.GetFeedbackBySubmission(ctx, submissionID)
+	// 2. Get the student's learning profile and their override history
+	// across all submissions
+	profile, sub, err := s.profiles.GetProfileForSubmission(ctx, submissionID)
+	if err != nil {
+		return "", err
+	}
+	if actor, err := auth.GetActor(ctx); err == nil && actor.Role == domain.RoleStudent && actor.StudentID != sub.StudentID {
+		return "", auth.ErrForbidden
+	}
+
+	history, err := s.repo.GetFeedbackByStudent(ctx, sub.TenantID, sub.StudentID)
 	if err != nil {
 		return "", err
 	}
 
 	// 3. Call AI to generate feedback
+	ctx = ai.WithAttribution(ctx, ai.Attribution{TenantID: sub.TenantID, ExamID: &sub.ExamID, SubmissionID: &sub.ID})
 	return s.aiProvider.GenerateFeedback(ctx, ai.FeedbackRequest{
 		Grade:       *currentGrade,
 		History:     history,
 		StudentName: studentName,
+		Profile:     profile,
 	})
 }
 
@@ -186,7 +222,29 @@ func (s *FeedbackService) AdaptRubric(ctx context.Context, questionID uuid.UUID)
 	// 4. Update the rubric in repository
 	// Note: We might want to version rubrics instead of overwriting, but for now we update in place
 	// effectively "adapting" it.
-	return s.examRepo.UpdateRubric(ctx, &refinedRubric)
+	refinedRubric.QuestionID = questionID
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.examRepo.UpdateRubric(ctx, &refinedRubric); err != nil {
+			return err
+		}
+		err := s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "rubric",
+			EntityID:   question.Rubric.ID,
+			EventType:  "adapted",
+			Changes: map[string]interface{}{
+				"question_id":    questionID,
+				"recommendation": analysis.Recommendation,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		return s.webhooks.Publish(ctx, domain.EventRubricUpdated, map[string]interface{}{
+			"rubric_id":   question.Rubric.ID,
+			"question_id": questionID,
+			"source":      "adapted",
+		})
+	})
 }
 
 func (s *FeedbackService) GetFeedbackByQuestion(ctx context.Context, questionID uuid.UUID) ([]domain.FeedbackEvent, error) {
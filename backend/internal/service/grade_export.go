@@ -0,0 +1,600 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harama/internal/domain"
+	"harama/internal/pkg/xlsx"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidExportOption is returned for an unknown column or header
+	// style.
+	ErrInvalidExportOption = errors.New("invalid export option")
+	// ErrExportIncomplete is returned by a strict export that would have
+	// warnings.
+	ErrExportIncomplete = errors.New("export is incomplete")
+)
+
+// GradeExportFormats maps the formats ExportGrades writes to their content
+// types.
+var GradeExportFormats = map[string]string{
+	"csv":   "text/csv",
+	"xlsx":  xlsx.ContentType,
+	"json":  "application/json",
+	"jsonl": "application/x-ndjson",
+}
+
+// GradeExportColumns are the summary columns an export can select, in their
+// default order. "questions" stands for a score column per question.
+var GradeExportColumns = []string{
+	"class", "student_id", "submission_id", "status", "total_score", "curved_total", "max_score", "percentage", "questions",
+}
+
+// defaultExportColumns is the summary layout when none is selected; class
+// is dropped for exams not assigned to a class, and curved_total for exams
+// without a curve.
+var defaultExportColumns = []string{"class", "student_id", "submission_id", "total_score", "curved_total", "questions"}
+
+// Question header styles
+const (
+	HeaderQuestionNumber = "number"
+	HeaderQuestionText   = "text"
+)
+
+// GradeExportOptions shape an export. Columns and QuestionHeaders apply to
+// the tabular formats, CSV and the XLSX summary sheet; JSON and JSONL carry
+// every field.
+type GradeExportOptions struct {
+	Format  string
+	GroupBy string
+	Columns []string
+	// QuestionHeaders labels question columns by number (Q1, Q2b, ...), the
+	// default, or by question text
+	QuestionHeaders string
+	// Strict fails the export rather than leave out or blank anything
+	Strict bool
+}
+
+// GradeExport is a written export and what it could not include.
+type GradeExport struct {
+	Data        []byte
+	ContentType string
+	Filename    string
+	Warnings    []ExportWarning
+}
+
+// ExportWarning is something an export left out or blank.
+type ExportWarning struct {
+	SubmissionID uuid.UUID `json:"submission_id"`
+	StudentID    string    `json:"student_id"`
+	Message      string    `json:"message"`
+}
+
+// ExportedQuestion is a question of an exported exam.
+type ExportedQuestion struct {
+	ID     uuid.UUID `json:"id"`
+	Label  string    `json:"label"`
+	Number string    `json:"number,omitempty"`
+	Text   string    `json:"text"`
+	Points int       `json:"points"`
+}
+
+// ExportedSubmission is a submission's grades as exported. TotalScore and
+// Percentage are raw; CurvedTotal is the total under the exam's curve, if
+// it covers the submission.
+type ExportedSubmission struct {
+	SubmissionID uuid.UUID               `json:"submission_id"`
+	StudentID    string                  `json:"student_id"`
+	Class        string                  `json:"class,omitempty"`
+	Status       domain.ProcessingStatus `json:"status"`
+	TotalScore   float64                 `json:"total_score"`
+	CurvedTotal  *float64                `json:"curved_total,omitempty"`
+	CurveVersion int                     `json:"curve_version,omitempty"`
+	MaxScore     int                     `json:"max_score"`
+	Percentage   float64                 `json:"percentage"`
+	Grades       []ExportedGrade         `json:"grades"`
+}
+
+// ExportedGrade is a question's grade, with the teacher's override if there
+// was one.
+type ExportedGrade struct {
+	QuestionID    uuid.UUID          `json:"question_id"`
+	Question      string             `json:"question"`
+	Score         float64            `json:"score"`
+	MaxScore      int                `json:"max_score"`
+	Confidence    float64            `json:"confidence"`
+	Status        domain.GradeStatus `json:"status"`
+	Reasoning     string             `json:"reasoning,omitempty"`
+	CriteriaMet   []string           `json:"criteria_met"`
+	MistakesFound []string           `json:"mistakes_found"`
+	Override      *ExportedOverride  `json:"override,omitempty"`
+}
+
+// ExportedOverride is the latest teacher override of a grade.
+type ExportedOverride struct {
+	AIScore      float64   `json:"ai_score"`
+	TeacherScore float64   `json:"teacher_score"`
+	Reason       string    `json:"reason,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+// gradeSheet is an exam's grades gathered for writing.
+type gradeSheet struct {
+	exam        *domain.Exam
+	questions   []ExportedQuestion
+	submissions []ExportedSubmission
+	warnings    []ExportWarning
+	hasClasses  bool
+	// curve is the curve applied to the exam, if any
+	curve *domain.GradeCurve
+}
+
+// ExportGrades writes the grades of an exam of the tenant in ctx as CSV,
+// XLSX (a summary sheet, a sheet per question with confidence, status,
+// reasoning and overrides, and a sheet of warnings), JSON or JSONL.
+// Submissions whose grades can't be loaded are left out with a warning, as
+// are the grades a submission is missing.
+func (s *AnalyticsService) ExportGrades(ctx context.Context, examID uuid.UUID, opts GradeExportOptions) (*GradeExport, error) {
+	if opts.Format == "" {
+		opts.Format = "csv"
+	}
+	contentType, ok := GradeExportFormats[opts.Format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, opts.Format)
+	}
+	switch opts.QuestionHeaders {
+	case "", HeaderQuestionNumber, HeaderQuestionText:
+	default:
+		return nil, fmt.Errorf("%w: question headers must be %q or %q", ErrInvalidExportOption, HeaderQuestionNumber, HeaderQuestionText)
+	}
+	for _, col := range opts.Columns {
+		if !slices.Contains(GradeExportColumns, col) {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidExportOption, col)
+		}
+	}
+
+	sheet, err := s.gradeSheet(ctx, examID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Strict && len(sheet.warnings) > 0 {
+		return nil, fmt.Errorf("%w: %d warnings, the first: %s", ErrExportIncomplete, len(sheet.warnings), sheet.warnings[0].Message)
+	}
+
+	var buf bytes.Buffer
+	switch opts.Format {
+	case "csv":
+		err = sheet.writeCSV(&buf, opts.Columns)
+	case "xlsx":
+		err = sheet.writeXLSX(&buf, opts.Columns)
+	case "json":
+		err = sheet.writeJSON(&buf)
+	case "jsonl":
+		err = sheet.writeJSONL(&buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &GradeExport{
+		Data:        buf.Bytes(),
+		ContentType: contentType,
+		Filename:    exportFilename(sheet.exam) + "." + opts.Format,
+		Warnings:    sheet.warnings,
+	}, nil
+}
+
+func (s *AnalyticsService) gradeSheet(ctx context.Context, examID uuid.UUID, opts GradeExportOptions) (*gradeSheet, error) {
+	exam, err := s.examRepo.GetByID(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	submissions, err := s.subRepo.ListByExam(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	// Classes each student sits the exam with, if the exam is assigned
+	classes, err := s.studentClasses(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	if opts.GroupBy == GroupByClass {
+		sort.SliceStable(submissions, func(i, j int) bool {
+			return classes[submissions[i].StudentID] < classes[submissions[j].StudentID]
+		})
+	}
+
+	curve, err := activeCurve(ctx, s.curveRepo, examID)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := &gradeSheet{exam: exam, hasClasses: len(classes) > 0, curve: curve}
+	maxScore := 0
+	for i, q := range exam.Questions {
+		sheet.questions = append(sheet.questions, ExportedQuestion{
+			ID:     q.ID,
+			Label:  questionLabel(q, i, opts.QuestionHeaders),
+			Number: q.QuestionNumber,
+			Text:   q.QuestionText,
+			Points: q.Points,
+		})
+		maxScore += q.Points
+	}
+
+	for _, sub := range submissions {
+		warn := func(format string, args ...interface{}) {
+			sheet.warnings = append(sheet.warnings, ExportWarning{
+				SubmissionID: sub.ID,
+				StudentID:    sub.StudentID,
+				Message:      fmt.Sprintf(format, args...),
+			})
+		}
+		grades, err := s.gradeRepo.GetBySubmission(ctx, sub.ID)
+		if err != nil {
+			warn("left out: grades could not be loaded: %v", err)
+			continue
+		}
+		overrides, err := s.latestOverrides(ctx, sub.ID)
+		if err != nil {
+			warn("override details could not be loaded: %v", err)
+		}
+
+		byQuestion := make(map[uuid.UUID]domain.FinalGrade, len(grades))
+		for _, g := range grades {
+			byQuestion[g.QuestionID] = g
+		}
+		row := ExportedSubmission{
+			SubmissionID: sub.ID,
+			StudentID:    sub.StudentID,
+			Class:        classes[sub.StudentID],
+			Status:       sub.ProcessingStatus,
+			MaxScore:     maxScore,
+			Grades:       []ExportedGrade{},
+		}
+		var missing []string
+		for _, q := range sheet.questions {
+			g, ok := byQuestion[q.ID]
+			if !ok {
+				missing = append(missing, q.Label)
+				continue
+			}
+			row.TotalScore += g.FinalScore
+			row.Grades = append(row.Grades, ExportedGrade{
+				QuestionID:    q.ID,
+				Question:      q.Label,
+				Score:         g.FinalScore,
+				MaxScore:      g.MaxScore,
+				Confidence:    g.Confidence,
+				Status:        g.Status,
+				Reasoning:     g.Reasoning,
+				CriteriaMet:   nonNil(g.CriteriaMet),
+				MistakesFound: nonNil(g.MistakesFound),
+				Override:      overrides[q.ID],
+			})
+		}
+		if len(missing) > 0 {
+			warn("no grade for %s (status %s)", strings.Join(missing, ", "), sub.ProcessingStatus)
+		} else if curve != nil {
+			if total, ok := curve.CurvedTotal(sub.ID, row.TotalScore); ok {
+				row.CurvedTotal, row.CurveVersion = &total, curve.Version
+			} else {
+				warn("not curved: graded or regraded since curve version %d was applied", curve.Version)
+			}
+		}
+		if maxScore > 0 {
+			row.Percentage = row.TotalScore / float64(maxScore) * 100
+		}
+		sheet.submissions = append(sheet.submissions, row)
+	}
+	return sheet, nil
+}
+
+// latestOverrides maps the questions of a submission a teacher overrode to
+// their latest override.
+func (s *AnalyticsService) latestOverrides(ctx context.Context, submissionID uuid.UUID) (map[uuid.UUID]*ExportedOverride, error) {
+	if s.feedbackRepo == nil {
+		return nil, nil
+	}
+	events, err := s.feedbackRepo.GetFeedbackBySubmission(ctx, submissionID)
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[uuid.UUID]*ExportedOverride, len(events))
+	for _, e := range events {
+		if prev, ok := overrides[e.QuestionID]; ok && prev.At.After(e.Timestamp) {
+			continue
+		}
+		overrides[e.QuestionID] = &ExportedOverride{
+			AIScore:      e.AIScore,
+			TeacherScore: e.TeacherScore,
+			Reason:       e.TeacherReason,
+			At:           e.Timestamp,
+		}
+	}
+	return overrides, nil
+}
+
+// questionLabel is a question's column header: its number, or its position
+// when it has none, or its text.
+func questionLabel(q domain.Question, i int, style string) string {
+	if style == HeaderQuestionText {
+		return fmt.Sprintf("Q: %s (Max: %d)", q.QuestionText, q.Points)
+	}
+	n := strings.TrimSpace(q.QuestionNumber)
+	switch {
+	case n == "":
+		return fmt.Sprintf("Q%d", i+1)
+	case strings.HasPrefix(strings.ToUpper(n), "Q"):
+		return n
+	default:
+		return "Q" + n
+	}
+}
+
+// summaryColumns resolves the selected columns, dropping class for exams
+// not assigned to a class and curved_total for exams without a curve unless
+// they were asked for.
+func (g *gradeSheet) summaryColumns(selected []string) []string {
+	if len(selected) > 0 {
+		return selected
+	}
+	var cols []string
+	for _, col := range defaultExportColumns {
+		if col == "class" && !g.hasClasses || col == "curved_total" && g.curve == nil {
+			continue
+		}
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+func (g *gradeSheet) summaryHeader(cols []string, questionHeader func(ExportedQuestion) string) []string {
+	var header []string
+	for _, col := range cols {
+		switch col {
+		case "class":
+			header = append(header, "Class")
+		case "student_id":
+			header = append(header, "Student ID")
+		case "submission_id":
+			header = append(header, "Submission ID")
+		case "status":
+			header = append(header, "Status")
+		case "total_score":
+			header = append(header, "Total Score")
+		case "curved_total":
+			header = append(header, "Curved Total")
+		case "max_score":
+			header = append(header, "Max Score")
+		case "percentage":
+			header = append(header, "Percentage")
+		case "questions":
+			for _, q := range g.questions {
+				header = append(header, questionHeader(q))
+			}
+		}
+	}
+	return header
+}
+
+// summaryRow is a submission's summary cells; a question without a grade is
+// nil.
+func (g *gradeSheet) summaryRow(cols []string, sub ExportedSubmission) []interface{} {
+	var row []interface{}
+	for _, col := range cols {
+		switch col {
+		case "class":
+			row = append(row, sub.Class)
+		case "student_id":
+			row = append(row, sub.StudentID)
+		case "submission_id":
+			row = append(row, sub.SubmissionID.String())
+		case "status":
+			row = append(row, string(sub.Status))
+		case "total_score":
+			row = append(row, sub.TotalScore)
+		case "curved_total":
+			if sub.CurvedTotal != nil {
+				row = append(row, *sub.CurvedTotal)
+			} else {
+				row = append(row, nil)
+			}
+		case "max_score":
+			row = append(row, sub.MaxScore)
+		case "percentage":
+			row = append(row, sub.Percentage)
+		case "questions":
+			for _, q := range g.questions {
+				if grade := sub.grade(q.ID); grade != nil {
+					row = append(row, grade.Score)
+				} else {
+					row = append(row, nil)
+				}
+			}
+		}
+	}
+	return row
+}
+
+func (s *ExportedSubmission) grade(questionID uuid.UUID) *ExportedGrade {
+	for i := range s.Grades {
+		if s.Grades[i].QuestionID == questionID {
+			return &s.Grades[i]
+		}
+	}
+	return nil
+}
+
+func (g *gradeSheet) writeCSV(w io.Writer, selected []string) error {
+	cols := g.summaryColumns(selected)
+	writer := csv.NewWriter(w)
+	writer.Write(g.summaryHeader(cols, func(q ExportedQuestion) string {
+		if strings.HasPrefix(q.Label, "Q: ") {
+			return q.Label
+		}
+		return fmt.Sprintf("%s (Max: %d)", q.Label, q.Points)
+	}))
+	for _, sub := range g.submissions {
+		cells := g.summaryRow(cols, sub)
+		record := make([]string, len(cells))
+		for i, c := range cells {
+			switch c := c.(type) {
+			case nil:
+				record[i] = "N/A"
+			case float64:
+				record[i] = fmt.Sprintf("%.2f", c)
+			default:
+				record[i] = fmt.Sprint(c)
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (g *gradeSheet) writeXLSX(w io.Writer, selected []string) error {
+	cols := g.summaryColumns(selected)
+	summary := xlsx.Sheet{Name: "Summary"}
+	header := g.summaryHeader(cols, func(q ExportedQuestion) string { return q.Label })
+	summary.Rows = append(summary.Rows, stringCells(header))
+	for _, sub := range g.submissions {
+		summary.Rows = append(summary.Rows, g.summaryRow(cols, sub))
+	}
+	sheets := []xlsx.Sheet{summary}
+
+	for _, q := range g.questions {
+		name := q.Label
+		if strings.HasPrefix(name, "Q: ") {
+			name = fmt.Sprintf("Q%d", len(sheets))
+		}
+		sheet := xlsx.Sheet{Name: name}
+		header := []string{"Student ID", "Submission ID", "Score", "Max Score", "Confidence", "Status", "Overridden", "AI Score", "Override Reason", "Reasoning", "Criteria Met", "Mistakes Found"}
+		if g.hasClasses {
+			header = append([]string{"Class"}, header...)
+		}
+		sheet.Rows = append(sheet.Rows, stringCells(header))
+		for _, sub := range g.submissions {
+			grade := sub.grade(q.ID)
+			if grade == nil {
+				continue
+			}
+			row := []interface{}{sub.StudentID, sub.SubmissionID.String(), grade.Score, grade.MaxScore, grade.Confidence, string(grade.Status), grade.Override != nil, nil, nil, grade.Reasoning, strings.Join(grade.CriteriaMet, "; "), strings.Join(grade.MistakesFound, "; ")}
+			if grade.Override != nil {
+				row[7], row[8] = grade.Override.AIScore, grade.Override.Reason
+			}
+			if g.hasClasses {
+				row = append([]interface{}{sub.Class}, row...)
+			}
+			sheet.Rows = append(sheet.Rows, row)
+		}
+		sheets = append(sheets, sheet)
+	}
+
+	if len(g.warnings) > 0 {
+		warnings := xlsx.Sheet{Name: "Warnings", Rows: [][]interface{}{{"Submission ID", "Student ID", "Warning"}}}
+		for _, warn := range g.warnings {
+			warnings.Rows = append(warnings.Rows, []interface{}{warn.SubmissionID.String(), warn.StudentID, warn.Message})
+		}
+		sheets = append(sheets, warnings)
+	}
+	return xlsx.Write(w, sheets)
+}
+
+func (g *gradeSheet) writeJSON(w io.Writer) error {
+	warnings := g.warnings
+	if warnings == nil {
+		warnings = []ExportWarning{}
+	}
+	submissions := g.submissions
+	if submissions == nil {
+		submissions = []ExportedSubmission{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		ExamID      uuid.UUID            `json:"exam_id"`
+		ExamTitle   string               `json:"exam_title"`
+		ExportedAt  time.Time            `json:"exported_at"`
+		Questions   []ExportedQuestion   `json:"questions"`
+		Submissions []ExportedSubmission `json:"submissions"`
+		Warnings    []ExportWarning      `json:"warnings"`
+	}{g.exam.ID, g.exam.Title, time.Now().UTC(), g.questions, submissions, warnings})
+}
+
+// writeJSONL writes a line per submission, then a line per warning; the
+// type field tells them apart.
+func (g *gradeSheet) writeJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for i := range g.submissions {
+		err := enc.Encode(struct {
+			Type string `json:"type"`
+			*ExportedSubmission
+		}{"submission", &g.submissions[i]})
+		if err != nil {
+			return err
+		}
+	}
+	for _, warn := range g.warnings {
+		err := enc.Encode(struct {
+			Type string `json:"type"`
+			ExportWarning
+		}{"warning", warn})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportFilename names an export after its exam.
+func exportFilename(exam *domain.Exam) string {
+	name := fileSlug(exam.Title)
+	if name == "" {
+		name = exam.ID.String()
+	}
+	return name + "_grades"
+}
+
+// fileSlug makes s safe to name a file with: its letters and digits,
+// lowercased, with runs of anything else made one underscore.
+func fileSlug(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteByte('_')
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+func stringCells(s []string) []interface{} {
+	cells := make([]interface{}, len(s))
+	for i, v := range s {
+		cells[i] = v
+	}
+	return cells
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -2,10 +2,16 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"harama/internal/ai"
+	"harama/internal/auth"
 	"harama/internal/domain"
 	"harama/internal/grading"
 	"harama/internal/pkg/utils"
+	"harama/internal/progress"
 	"harama/internal/repository/postgres"
+	"log"
 
 	"github.com/google/uuid"
 )
@@ -15,31 +21,56 @@ type GradingService struct {
 	examRepo      *postgres.ExamRepo
 	subRepo       *postgres.SubmissionRepo
 	auditRepo     *postgres.AuditRepo
+	webhooks      *postgres.WebhookRepo
+	lti           *postgres.LTIRepo
+	progress      *progress.Bus
 	gradingEngine *grading.Engine
 }
 
-func NewGradingService(repo *postgres.GradeRepo, examRepo *postgres.ExamRepo, subRepo *postgres.SubmissionRepo, auditRepo *postgres.AuditRepo, engine *grading.Engine) *GradingService {
+func NewGradingService(repo *postgres.GradeRepo, examRepo *postgres.ExamRepo, subRepo *postgres.SubmissionRepo, auditRepo *postgres.AuditRepo, webhooks *postgres.WebhookRepo, lti *postgres.LTIRepo, bus *progress.Bus, engine *grading.Engine) *GradingService {
 	return &GradingService{
 		repo:          repo,
 		examRepo:      examRepo,
 		subRepo:       subRepo,
 		auditRepo:     auditRepo,
+		webhooks:      webhooks,
+		lti:           lti,
+		progress:      bus,
 		gradingEngine: engine,
 	}
 }
 
-func (s *GradingService) GradeSubmission(ctx context.Context, submissionID uuid.UUID) error {
+func (s *GradingService) GradeSubmission(ctx context.Context, submissionID uuid.UUID) (err error) {
 	sub, err := s.subRepo.GetByID(ctx, submissionID)
 	if err != nil {
 		return err
 	}
+	defer func() { publishFailure(ctx, s.progress, sub, err) }()
 
 	exam, err := s.examRepo.GetByID(ctx, sub.ExamID)
 	if err != nil {
 		return err
 	}
 
-	for _, answer := range sub.Answers {
+	// Attribute all AI spend in this run to the submission
+	ctx = ai.WithAttribution(ctx, ai.Attribution{
+		TenantID:     sub.TenantID,
+		ExamID:       &sub.ExamID,
+		SubmissionID: &sub.ID,
+	})
+
+	// A paused or retried run picks up where it stopped rather than grading,
+	// and paying for, the answers it already graded again
+	existing, err := s.repo.GetBySubmission(ctx, submissionID)
+	if err != nil {
+		return err
+	}
+	graded := make(map[uuid.UUID]bool, len(existing))
+	for _, g := range existing {
+		graded[g.QuestionID] = true
+	}
+
+	for i, answer := range sub.Answers {
 		// Find question for this answer
 		var targetQuestion *domain.Question
 		for _, q := range exam.Questions {
@@ -49,11 +80,21 @@ func (s *GradingService) GradeSubmission(ctx context.Context, submissionID uuid.
 			}
 		}
 
-		if targetQuestion == nil || targetQuestion.Rubric == nil {
+		if targetQuestion == nil || targetQuestion.Rubric == nil || graded[targetQuestion.ID] {
 			continue
 		}
 
+		started := progress.NewEvent(sub, progress.StageGrading)
+		started.Step, started.Total = i+1, len(sub.Answers)
+		started.QuestionID = &targetQuestion.ID
+		s.progress.Publish(ctx, started)
+
 		finalGrade, multiEval, err := s.gradingEngine.GradeAnswer(ctx, answer, *targetQuestion.Rubric, exam.Subject, targetQuestion.QuestionText)
+		if ai.Pausable(err) {
+			// Pause rather than fail; grades saved so far are kept and
+			// re-triggering grades only the answers still ungraded
+			return pauseSubmission(ctx, s.subRepo, s.progress, sub, "grading", err)
+		}
 		if err != nil {
 			return err
 		}
@@ -61,25 +102,30 @@ func (s *GradingService) GradeSubmission(ctx context.Context, submissionID uuid.
 		finalGrade.SubmissionID = submissionID
 		finalGrade.QuestionID = targetQuestion.ID
 		
-		err = s.repo.SaveFinalGrade(ctx, finalGrade)
-		if err != nil {
-			return err
-		}
+		// The grade, its audit entry and any escalation are saved together
+		err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+			if err := s.repo.SaveFinalGrade(ctx, finalGrade); err != nil {
+				return err
+			}
 
-		// Log audit event for AI grading
-		_ = s.auditRepo.Save(ctx, &domain.AuditLog{
-			EntityType: "grade",
-			EntityID:   finalGrade.ID,
-			EventType:  "ai_graded",
-			ActorType:  "ai",
-			Changes: map[string]interface{}{
-				"score":      finalGrade.FinalScore,
-				"confidence": finalGrade.Confidence,
-				"reasoning":  finalGrade.Reasoning,
-			},
-		})
+			// Log audit event for AI grading
+			err := s.auditRepo.Save(ctx, &domain.AuditLog{
+				EntityType: "grade",
+				EntityID:   finalGrade.ID,
+				EventType:  "ai_graded",
+				ActorType:  "ai",
+				Changes: map[string]interface{}{
+					"submission_id": submissionID,
+					"question_id":   targetQuestion.ID,
+					"score":         finalGrade.FinalScore,
+					"confidence":    finalGrade.Confidence,
+					"reasoning":     finalGrade.Reasoning,
+				},
+			})
+			if err != nil || !multiEval.ShouldEscalate {
+				return err
+			}
 
-		if multiEval.ShouldEscalate {
 			escalation := &domain.EscalationCase{
 				ID:             uuid.New(),
 				SubmissionID:   submissionID,
@@ -87,18 +133,215 @@ func (s *GradingService) GradeSubmission(ctx context.Context, submissionID uuid.
 				AllEvaluations: multiEval.Evaluations,
 				Variance:       multiEval.Variance,
 				EscalatedAt:    utils.CurrentTime(),
-				Status:         "pending",
+				Status:         domain.EscalationPending,
 			}
-			err = s.repo.SaveEscalation(ctx, escalation)
-			if err != nil {
+			if err := s.repo.SaveEscalation(ctx, escalation); err != nil {
 				return err
 			}
+			return s.webhooks.Publish(ctx, domain.EventGradeEscalated, map[string]interface{}{
+				"escalation_id": escalation.ID,
+				"grade_id":      finalGrade.ID,
+				"submission_id": submissionID,
+				"question_id":   targetQuestion.ID,
+				"variance":      multiEval.Variance,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if multiEval.ShouldEscalate {
+			escalated := progress.NewEvent(sub, progress.StageEscalated)
+			escalated.QuestionID = &targetQuestion.ID
+			s.progress.Publish(ctx, escalated)
 		}
 	}
 
-	return s.subRepo.UpdateStatus(ctx, submissionID, domain.StatusCompleted)
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.subRepo.UpdateStatus(ctx, submissionID, domain.StatusCompleted); err != nil {
+			return err
+		}
+		if err := queueScores(ctx, s.lti, submissionID); err != nil {
+			return err
+		}
+		grades, err := s.repo.GetBySubmission(ctx, submissionID)
+		if err != nil {
+			return err
+		}
+		total, maxScore := 0.0, 0
+		for _, g := range grades {
+			total += g.FinalScore
+			maxScore += g.MaxScore
+		}
+		return s.webhooks.Publish(ctx, domain.EventSubmissionGraded, map[string]interface{}{
+			"submission_id":    submissionID,
+			"exam_id":          sub.ExamID,
+			"student_id":       sub.StudentID,
+			"questions_graded": len(grades),
+			"total_score":      total,
+			"max_score":        maxScore,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	s.progress.Publish(ctx, progress.NewEvent(sub, progress.StageCompleted))
+	return nil
 }
 
+// GetGrades returns the grades of a submission of the tenant in ctx; a
+// submission of another tenant is not found.
 func (s *GradingService) GetGrades(ctx context.Context, submissionID uuid.UUID) ([]domain.FinalGrade, error) {
+	if _, err := s.subRepo.GetByID(ctx, submissionID); err != nil {
+		return nil, err
+	}
 	return s.repo.GetBySubmission(ctx, submissionID)
 }
+
+// GradeLineage is a grade and, oldest first, the audit entries behind it.
+type GradeLineage struct {
+	Grade   *domain.FinalGrade `json:"grade"`
+	Entries []domain.AuditLog  `json:"entries"`
+}
+
+// GetGradeLineage returns a grade of the tenant in ctx with its history:
+// the submission's creation and OCR, AI grading, overrides, escalations and
+// changes to the question and its rubric.
+func (s *GradingService) GetGradeLineage(ctx context.Context, gradeID uuid.UUID) (*GradeLineage, error) {
+	grade, err := s.repo.GetByID(ctx, gradeID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.auditRepo.Lineage(ctx, grade)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []domain.AuditLog{}
+	}
+	return &GradeLineage{Grade: grade, Entries: entries}, nil
+}
+
+func (s *GradingService) ListEscalations(ctx context.Context, tenantID uuid.UUID, status string) ([]domain.EscalationCase, error) {
+	return s.repo.ListEscalations(ctx, tenantID, status)
+}
+
+// ResolveEscalation settles a disputed grade with a reviewer's score and
+// closes the escalation, assigning it to the reviewer.
+func (s *GradingService) ResolveEscalation(ctx context.Context, escalationID uuid.UUID, score float64, reason string) (*domain.FinalGrade, error) {
+	actor, err := auth.GetActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	escalation, err := s.repo.GetEscalation(ctx, actor.TenantID, escalationID)
+	if err != nil {
+		return nil, err
+	}
+	if escalation.Status == domain.EscalationResolved {
+		return nil, fmt.Errorf("escalation is already resolved")
+	}
+
+	grades, err := s.repo.GetBySubmission(ctx, escalation.SubmissionID)
+	if err != nil {
+		return nil, err
+	}
+	var grade *domain.FinalGrade
+	for i := range grades {
+		if grades[i].QuestionID == escalation.QuestionID {
+			grade = &grades[i]
+			break
+		}
+	}
+	if grade == nil {
+		return nil, fmt.Errorf("no grade found for escalated question")
+	}
+	if score < 0 || score > float64(grade.MaxScore) {
+		return nil, fmt.Errorf("score must be between 0 and %d", grade.MaxScore)
+	}
+
+	previous := grade.FinalScore
+	grade.FinalScore = score
+	grade.Status = domain.GradeStatusFinal
+	grade.UpdatedAt = utils.CurrentTime()
+	escalation.Status = domain.EscalationResolved
+	escalation.AssignedTo = &actor.UserID
+
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.SaveFinalGrade(ctx, grade); err != nil {
+			return err
+		}
+		if err := s.repo.UpdateEscalation(ctx, escalation); err != nil {
+			return err
+		}
+		if err := queueScores(ctx, s.lti, grade.SubmissionID); err != nil {
+			return err
+		}
+		err := s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "escalation",
+			EntityID:   escalation.ID,
+			EventType:  "resolved",
+			ActorID:    &actor.UserID,
+			ActorType:  string(actor.Role),
+			Changes: map[string]interface{}{
+				"grade_id":       grade.ID,
+				"previous_score": previous,
+				"new_score":      score,
+				"reason":         reason,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		return s.webhooks.Publish(ctx, domain.EventEscalationResolved, map[string]interface{}{
+			"escalation_id":  escalation.ID,
+			"grade_id":       grade.ID,
+			"submission_id":  grade.SubmissionID,
+			"question_id":    grade.QuestionID,
+			"previous_score": previous,
+			"new_score":      score,
+			"reason":         reason,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grade, nil
+}
+
+// queueScores queues a submission's total for publishing to the LMS line
+// items its exam is bound to, if its grades have all settled. It joins the
+// transaction in ctx, so the score is queued only with the grade change.
+func queueScores(ctx context.Context, lti *postgres.LTIRepo, submissionID uuid.UUID) error {
+	if lti == nil {
+		return nil
+	}
+	_, err := lti.QueueSubmission(ctx, submissionID)
+	return err
+}
+
+// pauseSubmission parks a submission whose AI calls can't proceed. A budget
+// stop waits for someone to re-trigger it; an open circuit is handed back so
+// the worker can retry once the provider is expected back.
+func pauseSubmission(ctx context.Context, repo *postgres.SubmissionRepo, bus *progress.Bus, sub *domain.Submission, stage string, cause error) error {
+	log.Printf("pausing %s of submission %s: %v", stage, sub.ID, cause)
+	if err := repo.UpdateStatus(ctx, sub.ID, domain.StatusPaused); err != nil {
+		return err
+	}
+	paused := progress.NewEvent(sub, progress.StagePaused)
+	paused.Error = cause.Error()
+	bus.Publish(ctx, paused)
+	if errors.Is(cause, ai.ErrCircuitOpen) {
+		return cause
+	}
+	return nil
+}
+
+// publishFailure reports a run of sub that ended in err as failed. A run
+// handed back while the AI circuit is open was paused, not failed.
+func publishFailure(ctx context.Context, bus *progress.Bus, sub *domain.Submission, err error) {
+	if err == nil || errors.Is(err, ai.ErrCircuitOpen) {
+		return
+	}
+	failed := progress.NewEvent(sub, progress.StageFailed)
+	failed.Error = err.Error()
+	bus.Publish(ctx, failed)
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// Item analysis flags
+const (
+	FlagTooEasy                = "too_easy"
+	FlagTooHard                = "too_hard"
+	FlagNegativeDiscrimination = "negative_discrimination"
+)
+
+const (
+	// Facilities above tooEasyFacility or below tooHardFacility tell
+	// little apart about the students who sat the exam
+	tooEasyFacility = 0.9
+	tooHardFacility = 0.2
+	// minAnalysedSubmissions is how many complete submissions discrimination
+	// and reliability need to be worth much
+	minAnalysedSubmissions = 20
+)
+
+// ItemAnalysis is how well an exam's questions measured the students who
+// sat it, from its complete submissions.
+type ItemAnalysis struct {
+	ExamID      uuid.UUID `json:"exam_id"`
+	Submissions int       `json:"submissions"`
+	// Incomplete counts the submissions left out for lacking a grade for
+	// some question
+	Incomplete int      `json:"incomplete"`
+	MeanTotal  *float64 `json:"mean_total,omitempty"`
+	MaxTotal   int      `json:"max_total"`
+	// CronbachAlpha is the exam's internal consistency, from its questions'
+	// score variances and that of the totals
+	CronbachAlpha *float64             `json:"cronbach_alpha,omitempty"`
+	Items         []ItemAnalysisResult `json:"items"`
+	Warnings      []string             `json:"warnings,omitempty"`
+}
+
+// ItemAnalysisResult is how one question did.
+type ItemAnalysisResult struct {
+	QuestionID uuid.UUID `json:"question_id"`
+	Label      string    `json:"label"`
+	Number     string    `json:"number,omitempty"`
+	Points     int       `json:"points"`
+	Responses  int       `json:"responses"`
+	MeanScore  float64   `json:"mean_score"`
+	// Facility is the mean fraction of the points awarded, from 0 to 1
+	Facility *float64 `json:"facility,omitempty"`
+	// Discrimination is the facility of the top 27% of submissions by total
+	// less that of the bottom 27%, from -1 to 1
+	Discrimination *float64 `json:"discrimination,omitempty"`
+	// PointBiserial correlates the question's score with the total of the
+	// rest of the exam, so that the question doesn't correlate with itself
+	PointBiserial *float64           `json:"point_biserial,omitempty"`
+	ZeroScores    int                `json:"zero_scores"`
+	FullMarks     int                `json:"full_marks"`
+	Criteria      []CriterionHitRate `json:"criteria,omitempty"`
+	Flags         []string           `json:"flags,omitempty"`
+}
+
+// CriterionHitRate is how often a rubric criterion was met.
+type CriterionHitRate struct {
+	ID          string  `json:"id"`
+	Description string  `json:"description,omitempty"`
+	Hits        int     `json:"hits"`
+	HitRate     float64 `json:"hit_rate"`
+}
+
+// ItemAnalysis analyses an exam's questions. The statistics are aggregated
+// in the database; only a row per question and criterion is read here.
+func (s *AnalyticsService) ItemAnalysis(ctx context.Context, examID uuid.UUID) (*ItemAnalysis, error) {
+	exam, err := s.examRepo.GetByID(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.gradeRepo.ItemAnalysis(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ItemAnalysis{
+		ExamID:      examID,
+		Submissions: stats.Exam.Submissions,
+		Incomplete:  stats.Exam.Incomplete,
+		MeanTotal:   stats.Exam.MeanTotal,
+		Items:       []ItemAnalysisResult{},
+	}
+	items := make(map[uuid.UUID]postgres.ItemStat, len(stats.Items))
+	for _, item := range stats.Items {
+		items[item.QuestionID] = item
+	}
+	hits := make(map[uuid.UUID]map[string]int)
+	for _, hit := range stats.Criteria {
+		if hits[hit.QuestionID] == nil {
+			hits[hit.QuestionID] = make(map[string]int)
+		}
+		hits[hit.QuestionID][hit.CriterionID] = hit.Hits
+	}
+
+	questions := append([]domain.Question(nil), exam.Questions...)
+	sort.SliceStable(questions, func(i, j int) bool {
+		return questionNumberLess(questions[i].QuestionNumber, questions[j].QuestionNumber)
+	})
+	itemVariance := 0.0
+	for i, q := range questions {
+		report.MaxTotal += q.Points
+		stat := items[q.ID]
+		itemVariance += stat.ScoreVariance
+
+		result := ItemAnalysisResult{
+			QuestionID:    q.ID,
+			Label:         questionLabel(q, i, HeaderQuestionNumber),
+			Number:        q.QuestionNumber,
+			Points:        q.Points,
+			Responses:     stat.Responses,
+			MeanScore:     stat.MeanScore,
+			Facility:      stat.Facility,
+			PointBiserial: stat.PointBiserial,
+			ZeroScores:    stat.ZeroScores,
+			FullMarks:     stat.FullMarks,
+			Criteria:      criterionHitRates(q.Rubric, hits[q.ID], stat.Responses),
+		}
+		if stat.UpperFacility != nil && stat.LowerFacility != nil {
+			d := *stat.UpperFacility - *stat.LowerFacility
+			result.Discrimination = &d
+		}
+		result.Flags = itemFlags(&result)
+		report.Items = append(report.Items, result)
+	}
+
+	// Cronbach's alpha: k/(k-1) * (1 - sum of item variances / total variance)
+	k := float64(len(questions))
+	if v := stats.Exam.TotalVariance; k >= 2 && v != nil && *v > 0 {
+		alpha := k / (k - 1) * (1 - itemVariance / *v)
+		report.CronbachAlpha = &alpha
+	}
+
+	switch {
+	case report.Submissions == 0:
+		report.Warnings = append(report.Warnings, "no submission has a grade for every question")
+	case report.Submissions < minAnalysedSubmissions:
+		report.Warnings = append(report.Warnings, fmt.Sprintf(
+			"only %d complete submissions; discrimination and reliability are unreliable below %d",
+			report.Submissions, minAnalysedSubmissions))
+	}
+	if report.Incomplete > 0 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf(
+			"%d submissions without a grade for every question were left out", report.Incomplete))
+	}
+	return report, nil
+}
+
+// criterionHitRates lists a rubric's criteria with how often each was met,
+// followed by any other criteria grades met, say of an earlier rubric.
+func criterionHitRates(rubric *domain.Rubric, hits map[string]int, responses int) []CriterionHitRate {
+	var rates []CriterionHitRate
+	seen := make(map[string]bool)
+	add := func(id, description string) {
+		rate := CriterionHitRate{ID: id, Description: description, Hits: hits[id]}
+		if responses > 0 {
+			rate.HitRate = float64(rate.Hits) / float64(responses)
+		}
+		rates = append(rates, rate)
+		seen[id] = true
+	}
+	if rubric != nil {
+		for _, c := range rubric.FullCreditCriteria {
+			add(c.ID, c.Description)
+		}
+	}
+	var others []string
+	for id := range hits {
+		if !seen[id] {
+			others = append(others, id)
+		}
+	}
+	sort.Strings(others)
+	for _, id := range others {
+		add(id, "")
+	}
+	return rates
+}
+
+// itemFlags points out questions worth reviewing.
+func itemFlags(item *ItemAnalysisResult) []string {
+	var flags []string
+	if f := item.Facility; f != nil {
+		switch {
+		case *f > tooEasyFacility:
+			flags = append(flags, FlagTooEasy)
+		case *f < tooHardFacility:
+			flags = append(flags, FlagTooHard)
+		}
+	}
+	if (item.Discrimination != nil && *item.Discrimination < 0) ||
+		(item.PointBiserial != nil && *item.PointBiserial < 0) {
+		flags = append(flags, FlagNegativeDiscrimination)
+	}
+	return flags
+}
@@ -0,0 +1,430 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/lti"
+	"harama/internal/pkg/utils"
+	"harama/internal/repository/postgres"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// ltiStateLifetime is how long a login waits for its launch
+	ltiStateLifetime = 10 * time.Minute
+	// Score pushes are retried like webhook deliveries
+	ltiMaxAttempts = webhookMaxAttempts
+	ltiBatchSize   = webhookBatchSize
+	ltiLease       = webhookLease
+	// ltiPushListLimit bounds the push log returned at once
+	ltiPushListLimit = 100
+)
+
+// LTILaunch is the outcome of a verified launch: the exam the link is bound
+// to and, for an instructor, the line item it was bound with or, for a
+// learner, the student the user was mapped to.
+type LTILaunch struct {
+	TenantID   uuid.UUID           `json:"tenant_id"`
+	PlatformID uuid.UUID           `json:"platform_id"`
+	ExamID     *uuid.UUID          `json:"exam_id,omitempty"`
+	Instructor bool                `json:"instructor"`
+	LineItem   *domain.LTILineItem `json:"line_item,omitempty"`
+	UserLink   *domain.LTIUserLink `json:"user_link,omitempty"`
+	Queued     int                 `json:"queued"`
+}
+
+type LTIService struct {
+	repo      *postgres.LTIRepo
+	examRepo  *postgres.ExamRepo
+	auditRepo *postgres.AuditRepo
+	tool      *lti.Tool
+}
+
+func NewLTIService(repo *postgres.LTIRepo, examRepo *postgres.ExamRepo, auditRepo *postgres.AuditRepo, tool *lti.Tool) *LTIService {
+	return &LTIService{repo: repo, examRepo: examRepo, auditRepo: auditRepo, tool: tool}
+}
+
+// RegisterPlatform registers an LMS for the tenant in ctx.
+func (s *LTIService) RegisterPlatform(ctx context.Context, p *domain.LTIPlatform) error {
+	if err := validateLTIPlatform(p); err != nil {
+		return err
+	}
+	p.ID = uuid.New()
+	if actor, err := auth.GetActor(ctx); err == nil {
+		p.CreatedBy = &actor.UserID
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreatePlatform(ctx, p); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "lti_platform",
+			EntityID:   p.ID,
+			EventType:  "registered",
+			Changes: map[string]interface{}{
+				"issuer":    p.Issuer,
+				"client_id": p.ClientID,
+			},
+		})
+	})
+}
+
+func (s *LTIService) ListPlatforms(ctx context.Context, tenantID uuid.UUID) ([]domain.LTIPlatform, error) {
+	return s.repo.ListPlatforms(ctx, tenantID)
+}
+
+// DeletePlatform removes a platform with its line items, user mappings and
+// unsent scores.
+func (s *LTIService) DeletePlatform(ctx context.Context, tenantID, id uuid.UUID) error {
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.DeletePlatform(ctx, tenantID, id); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "lti_platform",
+			EntityID:   id,
+			EventType:  "deleted",
+		})
+	})
+}
+
+// Login starts a launch a platform initiated and returns where to send the
+// browser to finish it.
+func (s *LTIService) Login(ctx context.Context, params lti.LoginParams) (string, error) {
+	if params.Issuer == "" || params.LoginHint == "" {
+		return "", fmt.Errorf("%w: iss and login_hint are required", lti.ErrInvalidLaunch)
+	}
+	platforms, err := s.repo.FindPlatforms(ctx, params.Issuer, params.ClientID)
+	if err != nil {
+		return "", err
+	}
+	if len(platforms) != 1 {
+		return "", fmt.Errorf("%w: no single platform registered for %s", lti.ErrInvalidLaunch, params.Issuer)
+	}
+	p := &platforms[0]
+
+	state, nonce := randomToken(), randomToken()
+	err = s.repo.SaveState(ctx, &domain.LTILaunchState{
+		State:      state,
+		Nonce:      nonce,
+		PlatformID: p.ID,
+		ExpiresAt:  utils.CurrentTime().Add(ltiStateLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.tool.AuthRedirect(p, params, state, nonce), nil
+}
+
+// Launch verifies the id_token a platform posted in answer to a login.
+// An instructor launching a link whose custom exam_id names an exam binds
+// the link to the exam, creating a line item when the link has none. A
+// learner is mapped to the student with the custom student_id or, failing
+// that, the SIS ID the platform sends. Totals already settled for the new
+// binding or mapping are queued to be published.
+func (s *LTIService) Launch(ctx context.Context, idToken, state string) (*LTILaunch, error) {
+	st, err := s.repo.TakeState(ctx, state, utils.CurrentTime())
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown or expired state", lti.ErrInvalidLaunch)
+	}
+	p, err := s.repo.GetPlatform(ctx, st.PlatformID)
+	if err != nil {
+		return nil, err
+	}
+	launch, err := s.tool.VerifyLaunch(ctx, p, idToken, st.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = auth.WithTenantID(ctx, p.TenantID)
+	out := &LTILaunch{TenantID: p.TenantID, PlatformID: p.ID, Instructor: launch.Instructor()}
+	if id, err := uuid.Parse(launch.Custom["exam_id"]); err == nil {
+		out.ExamID = &id
+	}
+	if out.Instructor {
+		if out.ExamID != nil {
+			err = s.bindLineItem(ctx, p, launch, *out.ExamID, out)
+		}
+	} else {
+		err = s.linkLearner(ctx, p, launch, out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *LTIService) bindLineItem(ctx context.Context, p *domain.LTIPlatform, launch *lti.Launch, examID uuid.UUID, out *LTILaunch) error {
+	exam, err := s.examRepo.GetByID(ctx, examID)
+	if err != nil {
+		return err
+	}
+	lineItemURL := launch.LineItemURL
+	if lineItemURL == "" {
+		if launch.LineItemsURL == "" {
+			return fmt.Errorf("%w: the link grants no access to the gradebook", lti.ErrInvalidLaunch)
+		}
+		maxScore := 0
+		for _, q := range exam.Questions {
+			maxScore += q.Points
+		}
+		created, err := s.tool.CreateLineItem(ctx, p, launch.LineItemsURL, lti.LineItem{
+			Label:          exam.Title,
+			ScoreMaximum:   float64(maxScore),
+			ResourceLinkID: launch.ResourceLinkID,
+			Tag:            "harama:" + exam.ID.String(),
+		})
+		if err != nil {
+			return err
+		}
+		lineItemURL = created.ID
+	}
+
+	li := &domain.LTILineItem{
+		ID:             uuid.New(),
+		TenantID:       p.TenantID,
+		PlatformID:     p.ID,
+		ExamID:         exam.ID,
+		ResourceLinkID: launch.ResourceLinkID,
+		LineItemURL:    lineItemURL,
+	}
+	out.LineItem = li
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.BindLineItem(ctx, li); err != nil {
+			return err
+		}
+		err := s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "lti_line_item",
+			EntityID:   li.ID,
+			EventType:  "bound",
+			ActorType:  "lti",
+			Changes: map[string]interface{}{
+				"platform_id":      p.ID,
+				"exam_id":          exam.ID,
+				"resource_link_id": li.ResourceLinkID,
+				"line_item_url":    li.LineItemURL,
+				"lms_user_id":      launch.UserID,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		out.Queued, err = s.repo.QueueLineItem(ctx, li.ID)
+		return err
+	})
+}
+
+func (s *LTIService) linkLearner(ctx context.Context, p *domain.LTIPlatform, launch *lti.Launch, out *LTILaunch) error {
+	studentID := launch.Custom["student_id"]
+	if studentID == "" {
+		studentID = launch.PersonSourcedID
+	}
+	if studentID == "" {
+		return nil
+	}
+	link := &domain.LTIUserLink{
+		ID:         uuid.New(),
+		TenantID:   p.TenantID,
+		PlatformID: p.ID,
+		LMSUserID:  launch.UserID,
+		StudentID:  studentID,
+	}
+	queued, err := s.linkUser(ctx, link, "lti")
+	if err != nil {
+		return err
+	}
+	out.UserLink, out.Queued = link, queued
+	return nil
+}
+
+// LinkUser maps a platform's user to a student by hand, for learners who
+// never launch or whose launches carry no student ID.
+func (s *LTIService) LinkUser(ctx context.Context, tenantID, platformID uuid.UUID, lmsUserID, studentID string) (*domain.LTIUserLink, error) {
+	if lmsUserID == "" || studentID == "" {
+		return nil, fmt.Errorf("lms_user_id and student_id are required")
+	}
+	p, err := s.repo.GetPlatform(ctx, platformID)
+	if err != nil {
+		return nil, err
+	}
+	if p.TenantID != tenantID {
+		return nil, fmt.Errorf("lti platform %s: %w", platformID, sql.ErrNoRows)
+	}
+	link := &domain.LTIUserLink{
+		ID:         uuid.New(),
+		TenantID:   tenantID,
+		PlatformID: platformID,
+		LMSUserID:  lmsUserID,
+		StudentID:  studentID,
+	}
+	if _, err := s.linkUser(ctx, link, ""); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (s *LTIService) linkUser(ctx context.Context, link *domain.LTIUserLink, actorType string) (int, error) {
+	var queued int
+	err := s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.LinkUser(ctx, link); err != nil {
+			return err
+		}
+		err := s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "lti_user_link",
+			EntityID:   link.ID,
+			EventType:  "linked",
+			ActorType:  actorType,
+			Changes: map[string]interface{}{
+				"platform_id": link.PlatformID,
+				"lms_user_id": link.LMSUserID,
+				"student_id":  link.StudentID,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		queued, err = s.repo.QueueUser(ctx, link.ID)
+		return err
+	})
+	return queued, err
+}
+
+// ListLineItems returns the line items an exam of the tenant in ctx is
+// bound to.
+func (s *LTIService) ListLineItems(ctx context.Context, examID uuid.UUID) ([]domain.LTILineItem, error) {
+	if _, err := s.examRepo.GetByID(ctx, examID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListLineItems(ctx, examID)
+}
+
+func (s *LTIService) ListScorePushes(ctx context.Context, tenantID uuid.UUID) ([]domain.LTIScorePush, error) {
+	return s.repo.ListScorePushes(ctx, tenantID, ltiPushListLimit)
+}
+
+// PushDue publishes the queued scores that are due and records the outcome
+// of each. It returns how many it attempted; failed attempts are not
+// errors, they are retried.
+func (s *LTIService) PushDue(ctx context.Context) (int, error) {
+	pushes, err := s.repo.ClaimDue(ctx, utils.CurrentTime(), ltiLease, ltiBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	for i := range pushes {
+		if err := s.push(ctx, &pushes[i]); err != nil {
+			errs = append(errs, fmt.Errorf("score push %s: %w", pushes[i].ID, err))
+		}
+	}
+	return len(pushes), errors.Join(errs...)
+}
+
+// push publishes a score and records the attempt. The outcome of the last
+// attempt, success or giving up, goes into the audit log with the score.
+func (s *LTIService) push(ctx context.Context, push *domain.LTIScorePush) error {
+	ctx = auth.WithTenantID(ctx, push.TenantID)
+	li, err := s.repo.GetLineItem(ctx, push.LineItemID)
+	if err != nil {
+		return err
+	}
+	p, err := s.repo.GetPlatform(ctx, li.PlatformID)
+	if err != nil {
+		return err
+	}
+
+	status, pushErr := s.tool.PublishScore(ctx, p, li.LineItemURL, lti.Score{
+		UserID:           push.LMSUserID,
+		ScoreGiven:       push.ScoreGiven,
+		ScoreMaximum:     push.ScoreMaximum,
+		ActivityProgress: "Completed",
+		GradingProgress:  "FullyGraded",
+		Timestamp:        push.CreatedAt,
+	})
+	now := utils.CurrentTime()
+	push.Attempts++
+	push.LastAttemptAt = &now
+	push.ResponseStatus = status
+	event := ""
+	switch {
+	case pushErr == nil:
+		push.Status = domain.ScorePushPushed
+		push.PushedAt = &now
+		push.NextAttemptAt = nil
+		push.LastError = ""
+		event = "pushed"
+	case push.Attempts >= ltiMaxAttempts:
+		push.Status = domain.ScorePushFailed
+		push.NextAttemptAt = nil
+		push.LastError = pushErr.Error()
+		event = "push_failed"
+	default:
+		next := now.Add(webhookBackoff(push.Attempts))
+		push.NextAttemptAt = &next
+		push.LastError = pushErr.Error()
+		return s.repo.RecordAttempt(ctx, push)
+	}
+
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.RecordAttempt(ctx, push); err != nil {
+			return err
+		}
+		changes := map[string]interface{}{
+			"submission_id": push.SubmissionID,
+			"line_item_id":  li.ID,
+			"line_item_url": li.LineItemURL,
+			"lms_user_id":   push.LMSUserID,
+			"score_given":   push.ScoreGiven,
+			"score_maximum": push.ScoreMaximum,
+			"attempts":      push.Attempts,
+		}
+		if push.CurveVersion != 0 {
+			changes["raw_score"] = push.RawScore
+			changes["curve_version"] = push.CurveVersion
+		}
+		if push.LastError != "" {
+			changes["error"] = push.LastError
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "lti_score",
+			EntityID:   push.ID,
+			EventType:  event,
+			ActorType:  "worker",
+			Changes:    changes,
+		})
+	})
+}
+
+// randomToken is an unguessable login state or nonce.
+func randomToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func validateLTIPlatform(p *domain.LTIPlatform) error {
+	if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+		return fmt.Errorf("name, issuer and client_id are required")
+	}
+	for _, field := range []struct{ name, url string }{
+		{"auth_login_url", p.AuthLoginURL},
+		{"auth_token_url", p.AuthTokenURL},
+		{"jwks_url", p.JWKSURL},
+	} {
+		u, err := url.Parse(field.url)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%s must be an absolute http or https URL", field.name)
+		}
+	}
+	return nil
+}
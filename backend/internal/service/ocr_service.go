@@ -3,38 +3,47 @@ package service
 import (
 	"context"
 	"fmt"
+	"harama/internal/ai"
 	"harama/internal/domain"
+	"harama/internal/progress"
 	"harama/internal/repository/postgres"
+	"harama/internal/segmentation"
 	"harama/internal/storage"
 
 	"github.com/google/uuid"
 )
 
 // OCRProcessor defines the contract for text extraction strategies
-type OCRProcessor interface {
-	ExtractText(ctx context.Context, fileBytes []byte, mimeType string) (*domain.OCRResult, error)
-}
+type OCRProcessor = ai.OCRProcessor
 
 type OCRService struct {
 	repo      *postgres.SubmissionRepo
+	examRepo  *postgres.ExamRepo
 	auditRepo *postgres.AuditRepo
+	webhooks  *postgres.WebhookRepo
+	progress  *progress.Bus
 	storage   *storage.MinioStorage
 	processor OCRProcessor
 }
 
-func NewOCRService(repo *postgres.SubmissionRepo, auditRepo *postgres.AuditRepo, storage *storage.MinioStorage, processor OCRProcessor) *OCRService {
+func NewOCRService(repo *postgres.SubmissionRepo, examRepo *postgres.ExamRepo, auditRepo *postgres.AuditRepo, webhooks *postgres.WebhookRepo, bus *progress.Bus, storage *storage.MinioStorage, processor OCRProcessor) *OCRService {
 	return &OCRService{
 		repo:      repo,
+		examRepo:  examRepo,
 		auditRepo: auditRepo,
+		webhooks:  webhooks,
+		progress:  bus,
 		storage:   storage,
 		processor: processor,
 	}
 }
 
 func (s *OCRService) CreateSubmission(ctx context.Context, sub *domain.Submission) error {
-	err := s.repo.Create(ctx, sub)
-	if err == nil {
-		_ = s.auditRepo.Save(ctx, &domain.AuditLog{
+	err := s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, sub); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
 			EntityType: "submission",
 			EntityID:   sub.ID,
 			EventType:  "created",
@@ -43,16 +52,29 @@ func (s *OCRService) CreateSubmission(ctx context.Context, sub *domain.Submissio
 				"student_id": sub.StudentID,
 			},
 		})
+	})
+	if err != nil {
+		return err
 	}
-	return err
+	uploaded := progress.NewEvent(sub, progress.StageUploaded)
+	uploaded.Total = len(sub.OCRResults)
+	s.progress.Publish(ctx, uploaded)
+	return nil
 }
 
-func (s *OCRService) ProcessSubmission(ctx context.Context, submissionID uuid.UUID) error {
+func (s *OCRService) ProcessSubmission(ctx context.Context, submissionID uuid.UUID) (err error) {
 	// 1. Get submission metadata
 	sub, err := s.repo.GetByID(ctx, submissionID)
 	if err != nil {
 		return err
 	}
+	defer func() { publishFailure(ctx, s.progress, sub, err) }()
+
+	ctx = ai.WithAttribution(ctx, ai.Attribution{
+		TenantID:     sub.TenantID,
+		ExamID:       &sub.ExamID,
+		SubmissionID: &sub.ID,
+	})
 
 	// 2. Mark as processing
 	err = s.repo.UpdateStatus(ctx, submissionID, domain.StatusProcessing)
@@ -62,7 +84,7 @@ func (s *OCRService) ProcessSubmission(ctx context.Context, submissionID uuid.UU
 
 	// 3. Process each OCR result
 	var finalResults []domain.OCRResult
-	for _, res := range sub.OCRResults {
+	for i, res := range sub.OCRResults {
 		// Assume ImageURL is the object name in MinIO
 		imgBytes, err := s.storage.GetFile(ctx, res.ImageURL)
 		if err != nil {
@@ -72,6 +94,9 @@ func (s *OCRService) ProcessSubmission(ctx context.Context, submissionID uuid.UU
 		// TODO: Determine mime type from file extension
 		mimeType := "image/png" 
 		ocrResult, err := s.processor.ExtractText(ctx, imgBytes, mimeType)
+		if ai.Pausable(err) {
+			return pauseSubmission(ctx, s.repo, s.progress, sub, "OCR", err)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to extract text: %w", err)
 		}
@@ -79,20 +104,82 @@ func (s *OCRService) ProcessSubmission(ctx context.Context, submissionID uuid.UU
 		ocrResult.PageNumber = res.PageNumber
 		ocrResult.ImageURL = res.ImageURL
 		finalResults = append(finalResults, *ocrResult)
+
+		page := progress.NewEvent(sub, progress.StageOCR)
+		page.Step, page.Total = i+1, len(sub.OCRResults)
+		s.progress.Publish(ctx, page)
 	}
 
-	err = s.repo.SaveOCRResults(ctx, submissionID, finalResults)
-	if err == nil {
-		_ = s.auditRepo.Save(ctx, &domain.AuditLog{
+	// Answers not given with the submission are found under the numbers
+	// of the exam's questions
+	var answers []domain.AnswerSegment
+	if len(sub.Answers) == 0 {
+		answers, err = s.segmentAnswers(ctx, sub, finalResults)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.SaveOCRResults(ctx, submissionID, finalResults); err != nil {
+			return err
+		}
+		if len(answers) > 0 {
+			if err := s.repo.SaveAnswers(ctx, submissionID, answers); err != nil {
+				return err
+			}
+		}
+		err := s.auditRepo.Save(ctx, &domain.AuditLog{
 			EntityType: "submission",
 			EntityID:   submissionID,
 			EventType:  "ocr_completed",
 			Changes: map[string]interface{}{
 				"pages_processed": len(finalResults),
+				"answers_found":   len(answers),
 			},
 		})
+		if err != nil {
+			return err
+		}
+		return s.webhooks.Publish(ctx, domain.EventSubmissionOCRCompleted, map[string]interface{}{
+			"submission_id":   submissionID,
+			"exam_id":         sub.ExamID,
+			"student_id":      sub.StudentID,
+			"pages_processed": len(finalResults),
+		})
+	})
+	if err != nil {
+		return err
 	}
-	return err
+	segmented := progress.NewEvent(sub, progress.StageSegmented)
+	segmented.Total = len(finalResults)
+	s.progress.Publish(ctx, segmented)
+	return nil
+}
+
+// segmentAnswers splits a submission's pages into answers, anchored on the
+// numbers of its exam's questions.
+func (s *OCRService) segmentAnswers(ctx context.Context, sub *domain.Submission, pages []domain.OCRResult) ([]domain.AnswerSegment, error) {
+	exam, err := s.examRepo.GetByID(ctx, sub.ExamID)
+	if err != nil {
+		return nil, err
+	}
+	var anchors []segmentation.Anchor
+	for _, q := range exam.Questions {
+		if q.QuestionNumber != "" {
+			anchors = append(anchors, segmentation.Anchor{QuestionID: q.ID, Number: q.QuestionNumber})
+		}
+	}
+	answers := segmentation.SplitAnswers(pages, anchors)
+	for i := range answers {
+		answers[i].SubmissionID = sub.ID
+	}
+	return answers, nil
+}
+
+// ListByExam returns the submissions of an exam of the tenant in ctx.
+func (s *OCRService) ListByExam(ctx context.Context, examID uuid.UUID) ([]domain.Submission, error) {
+	return s.repo.ListByExam(ctx, examID)
 }
 
 func (s *OCRService) GetByID(ctx context.Context, id uuid.UUID) (*domain.Submission, error) {
@@ -0,0 +1,422 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"harama/internal/ai"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/segmentation"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidQuestionPaper is returned for an upload that isn't one PDF
+	// or a set of page photos.
+	ErrInvalidQuestionPaper = errors.New("invalid question paper")
+	// ErrQuestionPaperNotReady is returned for a change to a question
+	// paper whose draft isn't awaiting review.
+	ErrQuestionPaperNotReady = errors.New("question paper is not awaiting review")
+	// ErrNoQuestionsFound fails a question paper nothing was read from.
+	ErrNoQuestionsFound = errors.New("no questions found on the question paper")
+)
+
+// maxQuestionPaperPages bounds the photos uploaded as one question paper.
+const maxQuestionPaperPages = 40
+
+// QuestionPaperUpload is an uploaded file of a question paper.
+type QuestionPaperUpload struct {
+	Data        []byte
+	ContentType string
+}
+
+type QuestionPaperService struct {
+	repo       *postgres.QuestionPaperRepo
+	examRepo   *postgres.ExamRepo
+	auditRepo  *postgres.AuditRepo
+	store      ObjectStore
+	processor  OCRProcessor
+	aiProvider ai.Provider
+	detector   *segmentation.DiagramDetector
+}
+
+func NewQuestionPaperService(repo *postgres.QuestionPaperRepo, examRepo *postgres.ExamRepo, auditRepo *postgres.AuditRepo, store ObjectStore, processor OCRProcessor, aiProvider ai.Provider, detector *segmentation.DiagramDetector) *QuestionPaperService {
+	return &QuestionPaperService{
+		repo:       repo,
+		examRepo:   examRepo,
+		auditRepo:  auditRepo,
+		store:      store,
+		processor:  processor,
+		aiProvider: aiProvider,
+		detector:   detector,
+	}
+}
+
+// Upload stores a question paper for an exam of the tenant in ctx, either
+// one PDF or photos of its pages in order, and queues it to be read.
+// Process reads it.
+func (s *QuestionPaperService) Upload(ctx context.Context, examID uuid.UUID, files []QuestionPaperUpload) (*domain.QuestionPaper, error) {
+	if err := checkQuestionPaperFiles(files); err != nil {
+		return nil, err
+	}
+	exam, err := s.examRepo.GetByID(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+
+	paper := &domain.QuestionPaper{
+		ID:       uuid.New(),
+		TenantID: exam.TenantID,
+		ExamID:   exam.ID,
+		Status:   domain.QuestionPaperPending,
+	}
+	if actor, err := auth.GetActor(ctx); err == nil {
+		paper.UploadedBy = &actor.UserID
+	}
+	for i, f := range files {
+		objectName := fmt.Sprintf("question-papers/%s/%s/page_%d%s", paper.TenantID, paper.ID, i+1, fileExtension(f.ContentType))
+		if _, err := s.store.UploadFile(ctx, objectName, f.Data, f.ContentType); err != nil {
+			return nil, fmt.Errorf("failed to store question paper: %w", err)
+		}
+		paper.Pages = append(paper.Pages, domain.QuestionPaperPage{ObjectName: objectName, ContentType: f.ContentType})
+	}
+
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, paper); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "question_paper",
+			EntityID:   paper.ID,
+			EventType:  "uploaded",
+			Changes: map[string]interface{}{
+				"exam_id": paper.ExamID,
+				"pages":   len(paper.Pages),
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paper, nil
+}
+
+func checkQuestionPaperFiles(files []QuestionPaperUpload) error {
+	if len(files) == 0 {
+		return fmt.Errorf("%w: no files uploaded", ErrInvalidQuestionPaper)
+	}
+	if len(files) > maxQuestionPaperPages {
+		return fmt.Errorf("%w: more than %d pages", ErrInvalidQuestionPaper, maxQuestionPaperPages)
+	}
+	for _, f := range files {
+		switch {
+		case f.ContentType == "application/pdf":
+			if len(files) > 1 {
+				return fmt.Errorf("%w: a PDF must be uploaded on its own", ErrInvalidQuestionPaper)
+			}
+		case strings.HasPrefix(f.ContentType, "image/"):
+		default:
+			return fmt.Errorf("%w: %q is neither a PDF nor an image", ErrInvalidQuestionPaper, f.ContentType)
+		}
+	}
+	return nil
+}
+
+// Process reads an uploaded question paper into a draft of its exam's
+// questions: each page is transcribed, the questions are extracted from
+// the text, and figures on the photos of pages are cut out as the visual
+// aids of the questions that show them. Figures aren't looked for in a
+// PDF. A paper that can't be read is marked failed with the reason;
+// uploading it again starts over.
+func (s *QuestionPaperService) Process(ctx context.Context, paperID uuid.UUID) error {
+	paper, err := s.repo.GetByID(ctx, paperID)
+	if err != nil {
+		return err
+	}
+	if paper.Status == domain.QuestionPaperReady || paper.Status == domain.QuestionPaperConfirmed {
+		return nil
+	}
+	exam, err := s.examRepo.GetByID(ctx, paper.ExamID)
+	if err != nil {
+		return err
+	}
+	ctx = ai.WithAttribution(ctx, ai.Attribution{TenantID: paper.TenantID, ExamID: &paper.ExamID})
+
+	paper.Status = domain.QuestionPaperProcessing
+	if err := s.repo.Save(ctx, paper); err != nil {
+		return err
+	}
+
+	draft, err := s.read(ctx, paper, exam.Subject)
+	if ai.Pausable(err) {
+		// Left to be read again once AI calls go through
+		paper.Status = domain.QuestionPaperPending
+		if serr := s.repo.Save(ctx, paper); serr != nil {
+			log.Printf("failed to park question paper %s: %v", paper.ID, serr)
+		}
+		return err
+	}
+	if err != nil {
+		paper.Status, paper.Error = domain.QuestionPaperFailed, err.Error()
+		if ferr := s.finish(ctx, paper, "failed", map[string]interface{}{"error": paper.Error}); ferr != nil {
+			log.Printf("failed to record failure of question paper %s: %v", paper.ID, ferr)
+		}
+		return err
+	}
+	paper.Status, paper.Draft, paper.Error = domain.QuestionPaperReady, draft, ""
+	return s.finish(ctx, paper, "extracted", map[string]interface{}{"questions": len(draft)})
+}
+
+func (s *QuestionPaperService) finish(ctx context.Context, paper *domain.QuestionPaper, event string, changes map[string]interface{}) error {
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Save(ctx, paper); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "question_paper",
+			EntityID:   paper.ID,
+			EventType:  event,
+			Changes:    changes,
+		})
+	})
+}
+
+// read transcribes a question paper and extracts its questions.
+func (s *QuestionPaperService) read(ctx context.Context, paper *domain.QuestionPaper, subject string) ([]domain.Question, error) {
+	texts := make([]string, len(paper.Pages))
+	photos := map[int][]byte{}
+	for i, page := range paper.Pages {
+		data, err := s.store.GetFile(ctx, page.ObjectName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get page %d from storage: %w", i+1, err)
+		}
+		res, err := s.processor.ExtractText(ctx, data, page.ContentType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transcribe page %d: %w", i+1, err)
+		}
+		texts[i] = res.RawText
+		if strings.HasPrefix(page.ContentType, "image/") {
+			photos[i+1] = data
+		}
+	}
+
+	extracted, err := s.aiProvider.ExtractQuestions(ctx, ai.ExtractQuestionsRequest{Pages: texts, Subject: subject})
+	if err != nil {
+		return nil, err
+	}
+	draft := draftQuestions(extracted)
+	if len(draft) == 0 {
+		return nil, ErrNoQuestionsFound
+	}
+	if err := s.attachFigures(ctx, paper, photos, extracted, draft); err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+// draftQuestions tidies extracted questions into a draft, dropping any
+// without text and reading an unknown answer type as a short answer.
+func draftQuestions(extracted []ai.ExtractedQuestion) []domain.Question {
+	draft := []domain.Question{}
+	for _, e := range extracted {
+		q := domain.Question{
+			QuestionNumber: strings.TrimSpace(e.Number),
+			QuestionGroup:  strings.TrimSpace(e.Group),
+			QuestionText:   strings.TrimSpace(e.Text),
+			Points:         max(e.Points, 0),
+			AnswerType:     e.AnswerType,
+			Choices:        e.Choices,
+		}
+		switch q.AnswerType {
+		case domain.AnswerTypeMCQ, domain.AnswerTypeShortAnswer, domain.AnswerTypeEssay, domain.AnswerTypeDiagram:
+		default:
+			q.AnswerType = domain.AnswerTypeShortAnswer
+		}
+		if q.AnswerType != domain.AnswerTypeMCQ {
+			q.Choices = nil
+		}
+		if q.QuestionText != "" {
+			draft = append(draft, q)
+		}
+	}
+	return draft
+}
+
+// attachFigures cuts the figures out of each photographed page and gives
+// them, in order, to the questions on that page said to show one; any more
+// figures than those questions go to the last of them.
+func (s *QuestionPaperService) attachFigures(ctx context.Context, paper *domain.QuestionPaper, photos map[int][]byte, extracted []ai.ExtractedQuestion, draft []domain.Question) error {
+	byPage := map[int][]int{}
+	n := 0
+	for _, e := range extracted {
+		if strings.TrimSpace(e.Text) == "" {
+			continue
+		}
+		if e.HasFigure {
+			byPage[e.Page] = append(byPage[e.Page], n)
+		}
+		n++
+	}
+
+	for page, showing := range byPage {
+		photo, ok := photos[page]
+		if !ok {
+			continue
+		}
+		// A figure that can't be cut out is left for the teacher to add
+		rects, err := s.detector.DetectRegions(photo)
+		if err != nil {
+			log.Printf("failed to find figures on page %d of question paper %s: %v", page, paper.ID, err)
+			continue
+		}
+		for i, rect := range rects {
+			figure, err := s.detector.ExtractRegion(photo, rect)
+			if err != nil {
+				log.Printf("failed to cut out figure on page %d of question paper %s: %v", page, paper.ID, err)
+				break
+			}
+			objectName := fmt.Sprintf("question-papers/%s/%s/figure_%d_%d.png", paper.TenantID, paper.ID, page, i+1)
+			url, err := s.store.UploadFile(ctx, objectName, figure, "image/png")
+			if err != nil {
+				return err
+			}
+			q := &draft[showing[min(i, len(showing)-1)]]
+			q.VisualAids = append(q.VisualAids, url)
+		}
+	}
+	return nil
+}
+
+func (s *QuestionPaperService) GetQuestionPaper(ctx context.Context, id uuid.UUID) (*domain.QuestionPaper, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+func (s *QuestionPaperService) ListQuestionPapers(ctx context.Context, examID uuid.UUID) ([]domain.QuestionPaper, error) {
+	return s.repo.ListByExam(ctx, examID)
+}
+
+// UpdateDraft replaces the draft of a question paper awaiting review with
+// the teacher's edit of it. It is checked when confirmed.
+func (s *QuestionPaperService) UpdateDraft(ctx context.Context, id uuid.UUID, draft []domain.Question) (*domain.QuestionPaper, error) {
+	paper, err := s.awaitingReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if draft == nil {
+		draft = []domain.Question{}
+	}
+
+	paper.Draft = draft
+	err = s.finish(ctx, paper, "edited", map[string]interface{}{"questions": len(draft)})
+	if err != nil {
+		return nil, err
+	}
+	return paper, nil
+}
+
+// Confirm creates the questions of a question paper's draft in its exam,
+// all of them or, if the draft has mistakes, none; they are returned
+// together as an *ExamDefinitionError whose paths index the draft. The
+// questions' numbers are what answers are later found under, so they must
+// not repeat the numbers of questions already in the exam.
+func (s *QuestionPaperService) Confirm(ctx context.Context, id uuid.UUID) ([]domain.Question, error) {
+	paper, err := s.awaitingReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	exam, err := s.examRepo.GetByID(ctx, paper.ExamID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &domain.ExamDocument{Title: exam.Title, Questions: []domain.QuestionDocument{}}
+	for _, q := range paper.Draft {
+		doc.Questions = append(doc.Questions, domain.QuestionDocument{
+			Number:     q.QuestionNumber,
+			Group:      q.QuestionGroup,
+			Text:       q.QuestionText,
+			Points:     q.Points,
+			AnswerType: q.AnswerType,
+			AnswerKey:  q.AnswerKey,
+			Choices:    q.Choices,
+			VisualAids: q.VisualAids,
+		})
+	}
+	problems := validateExamDocument(doc)
+	existing := map[string]bool{}
+	for _, q := range exam.Questions {
+		if q.QuestionNumber != "" {
+			existing[q.QuestionNumber] = true
+		}
+	}
+	for i, q := range doc.Questions {
+		if existing[q.Number] {
+			problems = append(problems, domain.FieldError{
+				Path:    fmt.Sprintf("questions[%d].number", i),
+				Message: fmt.Sprintf("%q is the number of a question already in the exam", q.Number),
+			})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &ExamDefinitionError{Errors: problems}
+	}
+
+	questions := DocumentToExam(doc).Questions
+	for i := range questions {
+		questions[i].ID = uuid.New()
+	}
+	now := time.Now()
+	paper.Status, paper.ConfirmedAt = domain.QuestionPaperConfirmed, &now
+	if actor, err := auth.GetActor(ctx); err == nil {
+		paper.ConfirmedBy = &actor.UserID
+	}
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.examRepo.AddQuestions(ctx, paper.ExamID, questions); err != nil {
+			return err
+		}
+		if err := s.repo.Save(ctx, paper); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "question_paper",
+			EntityID:   paper.ID,
+			EventType:  "confirmed",
+			Changes: map[string]interface{}{
+				"exam_id":   paper.ExamID,
+				"questions": len(questions),
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return questions, nil
+}
+
+func (s *QuestionPaperService) awaitingReview(ctx context.Context, id uuid.UUID) (*domain.QuestionPaper, error) {
+	paper, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if paper.Status != domain.QuestionPaperReady {
+		return nil, fmt.Errorf("%w: it is %s", ErrQuestionPaperNotReady, paper.Status)
+	}
+	return paper, nil
+}
+
+// fileExtension is the extension a question paper's file is stored with.
+func fileExtension(contentType string) string {
+	switch contentType {
+	case "application/pdf":
+		return ".pdf"
+	case "image/jpeg":
+		return ".jpg"
+	}
+	return "." + strings.TrimPrefix(contentType, "image/")
+}
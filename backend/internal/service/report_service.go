@@ -0,0 +1,421 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/report"
+	"harama/internal/repository/postgres"
+	"harama/internal/segmentation"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrReportNotReady is returned for the file of a report that hasn't
+	// completed.
+	ErrReportNotReady = errors.New("report is not ready")
+	// ErrNothingToReport fails an exam report none of whose submissions
+	// are graded.
+	ErrNothingToReport = errors.New("no graded submissions to report")
+)
+
+// ObjectStore keeps generated files; storage.MinioStorage is one.
+type ObjectStore interface {
+	UploadFile(ctx context.Context, objectName string, data []byte, contentType string) (string, error)
+	GetFile(ctx context.Context, objectName string) ([]byte, error)
+}
+
+// Colours answer regions are outlined in, by how much of the question's
+// marks they earned
+var (
+	markFull     = color.RGBA{0, 150, 60, 255}
+	markPartial  = color.RGBA{230, 130, 0, 255}
+	markNone     = color.RGBA{200, 30, 30, 255}
+	markUngraded = color.RGBA{120, 120, 120, 255}
+)
+
+// markWidth is the thickness of answer outlines in pixels.
+const markWidth = 4
+
+type ReportService struct {
+	repo      *postgres.ReportRepo
+	examRepo  *postgres.ExamRepo
+	subRepo   *postgres.SubmissionRepo
+	gradeRepo *postgres.GradeRepo
+	auditRepo *postgres.AuditRepo
+	curveRepo *postgres.CurveRepo
+	store     ObjectStore
+	detector  *segmentation.DiagramDetector
+}
+
+func NewReportService(repo *postgres.ReportRepo, examRepo *postgres.ExamRepo, subRepo *postgres.SubmissionRepo, gradeRepo *postgres.GradeRepo, auditRepo *postgres.AuditRepo, curveRepo *postgres.CurveRepo, store ObjectStore, detector *segmentation.DiagramDetector) *ReportService {
+	return &ReportService{
+		repo:      repo,
+		examRepo:  examRepo,
+		subRepo:   subRepo,
+		gradeRepo: gradeRepo,
+		auditRepo: auditRepo,
+		curveRepo: curveRepo,
+		store:     store,
+		detector:  detector,
+	}
+}
+
+// RequestSubmissionReport queues a PDF report of a submission of the
+// tenant in ctx. Generate builds it.
+func (s *ReportService) RequestSubmissionReport(ctx context.Context, submissionID uuid.UUID) (*domain.Report, error) {
+	sub, err := s.subRepo.GetByID(ctx, submissionID)
+	if err != nil {
+		return nil, err
+	}
+	rep := &domain.Report{
+		TenantID:     sub.TenantID,
+		ExamID:       sub.ExamID,
+		SubmissionID: &sub.ID,
+		Format:       domain.ReportFormatPDF,
+	}
+	return rep, s.create(ctx, rep)
+}
+
+// RequestExamReport queues a ZIP of the reports of an exam's graded
+// submissions. Generate builds it.
+func (s *ReportService) RequestExamReport(ctx context.Context, examID uuid.UUID) (*domain.Report, error) {
+	exam, err := s.examRepo.GetByID(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	rep := &domain.Report{
+		TenantID: exam.TenantID,
+		ExamID:   exam.ID,
+		Format:   domain.ReportFormatZIP,
+	}
+	return rep, s.create(ctx, rep)
+}
+
+func (s *ReportService) create(ctx context.Context, rep *domain.Report) error {
+	rep.ID = uuid.New()
+	rep.Status = domain.ReportPending
+	if actor, err := auth.GetActor(ctx); err == nil {
+		rep.RequestedBy = &actor.UserID
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, rep); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "report",
+			EntityID:   rep.ID,
+			EventType:  "requested",
+			Changes: map[string]interface{}{
+				"exam_id":       rep.ExamID,
+				"submission_id": rep.SubmissionID,
+				"format":        rep.Format,
+			},
+		})
+	})
+}
+
+// Generate builds a queued report and stores its file. A report that
+// can't be built is marked failed with the reason; requesting it again
+// starts over.
+func (s *ReportService) Generate(ctx context.Context, reportID uuid.UUID) error {
+	rep, err := s.repo.GetByID(ctx, reportID)
+	if err != nil {
+		return err
+	}
+	if rep.Status == domain.ReportCompleted {
+		return nil
+	}
+	rep.Status = domain.ReportProcessing
+	if err := s.repo.Save(ctx, rep); err != nil {
+		return err
+	}
+
+	data, included, err := s.build(ctx, rep)
+	if err == nil {
+		objectName := fmt.Sprintf("reports/%s/%s.%s", rep.TenantID, rep.ID, rep.Format)
+		_, err = s.store.UploadFile(ctx, objectName, data, rep.ContentType())
+		rep.ObjectName = objectName
+	}
+	if err != nil {
+		if ferr := s.finish(ctx, rep, domain.ReportFailed, err.Error()); ferr != nil {
+			log.Printf("failed to record failure of report %s: %v", rep.ID, ferr)
+		}
+		return err
+	}
+	rep.SizeBytes, rep.Included = int64(len(data)), included
+	return s.finish(ctx, rep, domain.ReportCompleted, "")
+}
+
+func (s *ReportService) finish(ctx context.Context, rep *domain.Report, status domain.ReportStatus, reason string) error {
+	now := time.Now()
+	rep.Status, rep.Error, rep.CompletedAt = status, reason, &now
+	changes := map[string]interface{}{"format": rep.Format}
+	event := "generated"
+	if status == domain.ReportFailed {
+		event = "failed"
+		changes["error"] = reason
+	} else {
+		changes["included"] = rep.Included
+		changes["size_bytes"] = rep.SizeBytes
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Save(ctx, rep); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "report",
+			EntityID:   rep.ID,
+			EventType:  event,
+			Changes:    changes,
+		})
+	})
+}
+
+// build renders a report's file and counts the submissions in it.
+func (s *ReportService) build(ctx context.Context, rep *domain.Report) ([]byte, int, error) {
+	exam, err := s.examRepo.GetByID(ctx, rep.ExamID)
+	if err != nil {
+		return nil, 0, err
+	}
+	curve, err := activeCurve(ctx, s.curveRepo, exam.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if rep.SubmissionID != nil {
+		sub, err := s.subRepo.GetByID(ctx, *rep.SubmissionID)
+		if err != nil {
+			return nil, 0, err
+		}
+		grades, err := s.gradeRepo.GetBySubmission(ctx, sub.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		var buf bytes.Buffer
+		if err := s.render(ctx, &buf, exam, curve, sub, grades); err != nil {
+			return nil, 0, err
+		}
+		return buf.Bytes(), 1, nil
+	}
+
+	subs, err := s.subRepo.ListByExam(ctx, exam.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	names := make(map[string]int)
+	included := 0
+	for i := range subs {
+		grades, err := s.gradeRepo.GetBySubmission(ctx, subs[i].ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(grades) == 0 {
+			continue
+		}
+		name := fileSlug(subs[i].StudentID)
+		if name == "" {
+			name = subs[i].ID.String()
+		}
+		// A student may have sat the exam more than once
+		if names[name]++; names[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, names[name])
+		}
+		w, err := zw.Create(name + ".pdf")
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := s.render(ctx, w, exam, curve, &subs[i], grades); err != nil {
+			return nil, 0, fmt.Errorf("student %s: %w", subs[i].StudentID, err)
+		}
+		included++
+	}
+	if included == 0 {
+		return nil, 0, ErrNothingToReport
+	}
+	if err := zw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), included, nil
+}
+
+// render writes the report of a submission: its scans with each graded
+// answer outlined in a colour for its score and tagged with it, then the
+// grades. The total is also given under curve, the exam's curve if it has
+// one, when the curve covers the submission.
+func (s *ReportService) render(ctx context.Context, w io.Writer, exam *domain.Exam, curve *domain.GradeCurve, sub *domain.Submission, grades []domain.FinalGrade) error {
+	byQuestion := make(map[uuid.UUID]domain.FinalGrade, len(grades))
+	for _, g := range grades {
+		byQuestion[g.QuestionID] = g
+	}
+	labels := make(map[uuid.UUID]string, len(exam.Questions))
+	doc := &report.Submission{
+		ExamTitle:   exam.Title,
+		StudentID:   sub.StudentID,
+		SubmittedAt: sub.UploadedAt,
+	}
+	for i, q := range exam.Questions {
+		labels[q.ID] = questionLabel(q, i, HeaderQuestionNumber)
+		doc.MaxScore += q.Points
+		rq := report.Question{Label: labels[q.ID], Text: q.QuestionText, Points: q.Points}
+		if g, ok := byQuestion[q.ID]; ok {
+			rq.Graded = true
+			rq.Score = g.FinalScore
+			rq.Feedback = g.Reasoning
+			rq.Criteria = g.CriteriaMet
+			rq.Mistakes = g.MistakesFound
+			rq.Adjusted = g.Status == domain.GradeStatusOverridden
+			doc.TotalScore += g.FinalScore
+		}
+		doc.Questions = append(doc.Questions, rq)
+	}
+	if curve != nil {
+		if total, ok := curve.CurvedTotal(sub.ID, doc.TotalScore); ok {
+			doc.CurvedTotal = &total
+		}
+	}
+
+	for i, page := range sub.OCRResults {
+		scan, err := s.store.GetFile(ctx, page.ImageURL)
+		if err != nil {
+			return fmt.Errorf("failed to get page %d from storage: %w", i+1, err)
+		}
+		marks, pageLabels := pageMarks(sub.Answers, i, byQuestion, labels)
+		img, _, err := s.detector.DrawMarks(scan, marks)
+		if err != nil {
+			// An unreadable scan leaves a note in its place rather than
+			// holding back the rest of the report
+			log.Printf("report of submission %s: page %d: %v", sub.ID, i+1, err)
+			img, pageLabels = nil, nil
+		}
+		doc.Pages = append(doc.Pages, report.Page{Image: img, Labels: pageLabels})
+	}
+	return report.Render(w, doc)
+}
+
+// pageMarks outlines the answers on page (an index into the submission's
+// OCR results) and tags each answer once with its question and score. An
+// answer's boxes are on the pages its PageIndices give in turn; boxes past
+// the last index are on the last page.
+func pageMarks(answers []domain.AnswerSegment, page int, grades map[uuid.UUID]domain.FinalGrade, labels map[uuid.UUID]string) ([]segmentation.Mark, []report.Label) {
+	var marks []segmentation.Mark
+	var tags []report.Label
+	for _, a := range answers {
+		label, ok := labels[a.QuestionID]
+		if !ok || len(a.PageIndices) == 0 {
+			continue
+		}
+		text, col := label+" –", color.Color(markUngraded)
+		if g, graded := grades[a.QuestionID]; graded {
+			text, col = fmt.Sprintf("%s %s/%d", label, trimScore(g.FinalScore), g.MaxScore), scoreColor(g.FinalScore, g.MaxScore)
+		}
+		tagged := false
+		for j, box := range a.BoundingBox {
+			onPage := a.PageIndices[min(j, len(a.PageIndices)-1)]
+			if onPage != page || box.Width <= 0 || box.Height <= 0 {
+				continue
+			}
+			rect := image.Rect(box.X, box.Y, box.X+box.Width, box.Y+box.Height)
+			marks = append(marks, segmentation.Mark{Rect: rect, Color: col, Width: markWidth})
+			if !tagged {
+				tags = append(tags, report.Label{At: rect.Min, Text: text, Color: col})
+				tagged = true
+			}
+		}
+	}
+	return marks, tags
+}
+
+func scoreColor(score float64, max int) color.Color {
+	switch {
+	case max > 0 && score >= float64(max):
+		return markFull
+	case score <= 0:
+		return markNone
+	default:
+		return markPartial
+	}
+}
+
+// trimScore writes a score to at most two decimal places.
+func trimScore(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+// GetReport returns a report of the tenant in ctx. Students only see the
+// reports of their own submissions.
+func (s *ReportService) GetReport(ctx context.Context, id uuid.UUID) (*domain.Report, error) {
+	rep, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, rep); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// ListExamReports returns the reports of an exam, newest first.
+func (s *ReportService) ListExamReports(ctx context.Context, examID uuid.UUID) ([]domain.Report, error) {
+	return s.repo.ListByExam(ctx, examID)
+}
+
+// Download returns a completed report's file and a name to save it under.
+func (s *ReportService) Download(ctx context.Context, id uuid.UUID) (*domain.Report, []byte, string, error) {
+	rep, err := s.GetReport(ctx, id)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if rep.Status != domain.ReportCompleted {
+		return nil, nil, "", fmt.Errorf("%w: report is %s", ErrReportNotReady, rep.Status)
+	}
+	data, err := s.store.GetFile(ctx, rep.ObjectName)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	name := "report"
+	if exam, err := s.examRepo.GetByID(ctx, rep.ExamID); err == nil && fileSlug(exam.Title) != "" {
+		name = fileSlug(exam.Title)
+	}
+	if rep.SubmissionID != nil {
+		if sub, err := s.subRepo.GetByID(ctx, *rep.SubmissionID); err == nil && fileSlug(sub.StudentID) != "" {
+			name += "_" + fileSlug(sub.StudentID)
+		}
+	} else {
+		name += "_reports"
+	}
+	return rep, data, name + "." + rep.Format, nil
+}
+
+// authorize lets students at the reports of their own submissions only.
+func (s *ReportService) authorize(ctx context.Context, rep *domain.Report) error {
+	actor, err := auth.GetActor(ctx)
+	if err != nil || actor.Role != domain.RoleStudent {
+		return nil
+	}
+	if rep.SubmissionID == nil {
+		return auth.ErrForbidden
+	}
+	sub, err := s.subRepo.GetByID(ctx, *rep.SubmissionID)
+	if err != nil {
+		return err
+	}
+	if actor.StudentID == "" || actor.StudentID != sub.StudentID {
+		return auth.ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"io"
+	"net/mail"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotOnRoster is returned when a submission names a student who is not
+// enrolled in any class the exam is assigned to.
+var ErrNotOnRoster = errors.New("student is not enrolled in a class assigned to this exam")
+
+type RosterService struct {
+	repo      *postgres.RosterRepo
+	userRepo  *postgres.UserRepo
+	auditRepo *postgres.AuditRepo
+}
+
+func NewRosterService(repo *postgres.RosterRepo, userRepo *postgres.UserRepo, auditRepo *postgres.AuditRepo) *RosterService {
+	return &RosterService{repo: repo, userRepo: userRepo, auditRepo: auditRepo}
+}
+
+func (s *RosterService) CreateClass(ctx context.Context, class *domain.Class) error {
+	class.Name = strings.TrimSpace(class.Name)
+	if class.Name == "" {
+		return fmt.Errorf("class name is required")
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateClass(ctx, class); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "class",
+			EntityID:   class.ID,
+			EventType:  "created",
+			Changes: map[string]interface{}{
+				"name":    class.Name,
+				"section": class.Section,
+			},
+		})
+	})
+}
+
+func (s *RosterService) ListClasses(ctx context.Context, tenantID uuid.UUID) ([]domain.Class, error) {
+	return s.repo.ListClasses(ctx, tenantID)
+}
+
+func (s *RosterService) GetClass(ctx context.Context, tenantID, classID uuid.UUID) (*domain.Class, error) {
+	return s.repo.GetClass(ctx, tenantID, classID)
+}
+
+func (s *RosterService) CreateStudent(ctx context.Context, student *domain.Student) error {
+	if err := validateStudent(student); err != nil {
+		return err
+	}
+	return s.repo.CreateStudent(ctx, student)
+}
+
+func (s *RosterService) ListStudents(ctx context.Context, tenantID uuid.UUID) ([]domain.Student, error) {
+	return s.repo.ListStudents(ctx, tenantID)
+}
+
+// ImportRosterCSV reads a roster with a header row naming at least
+// student_id and name columns (email is optional, order is free) and
+// enrols every valid line in the class.
+func (s *RosterService) ImportRosterCSV(ctx context.Context, tenantID, classID uuid.UUID, r io.Reader, replace bool) (*domain.RosterImportResult, error) {
+	if _, err := s.repo.GetClass(ctx, tenantID, classID); err != nil {
+		return nil, fmt.Errorf("class not found: %w", err)
+	}
+
+	students, lineErrors, err := ParseRosterCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *domain.RosterImportResult
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if result, err = s.repo.ImportRoster(ctx, tenantID, classID, students, replace); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "class",
+			EntityID:   classID,
+			EventType:  "roster_imported",
+			Changes: map[string]interface{}{
+				"created":  result.Created,
+				"updated":  result.Updated,
+				"enrolled": result.Enrolled,
+				"removed":  result.Removed,
+				"skipped":  len(lineErrors),
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Errors = lineErrors
+	return result, nil
+}
+
+// ParseRosterCSV parses a roster file. Invalid lines are reported rather
+// than failing the import; only an unreadable file or a missing required
+// column is an error.
+func ParseRosterCSV(r io.Reader) ([]domain.Student, []domain.RosterImportError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read roster header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		cols[name] = i
+	}
+	for _, required := range []string{"student_id", "name"} {
+		if _, ok := cols[required]; !ok {
+			return nil, nil, fmt.Errorf("roster is missing the %q column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var students []domain.Student
+	lineErrors := []domain.RosterImportError{}
+	seen := map[string]int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				lineErrors = append(lineErrors, domain.RosterImportError{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, fmt.Errorf("failed to read roster: %w", err)
+		}
+		// Blank lines are skipped by the reader, so take the line from it
+		line, _ := reader.FieldPos(0)
+		if len(strings.Join(record, "")) == 0 {
+			continue
+		}
+
+		st := domain.Student{
+			ExternalID: field(record, "student_id"),
+			Name:       field(record, "name"),
+			Email:      field(record, "email"),
+		}
+		if err := validateStudent(&st); err != nil {
+			lineErrors = append(lineErrors, domain.RosterImportError{Line: line, Message: err.Error()})
+			continue
+		}
+		if first, dup := seen[st.ExternalID]; dup {
+			lineErrors = append(lineErrors, domain.RosterImportError{Line: line, Message: fmt.Sprintf("duplicate student_id %s (first on line %d)", st.ExternalID, first)})
+			continue
+		}
+		seen[st.ExternalID] = line
+		students = append(students, st)
+	}
+	return students, lineErrors, nil
+}
+
+func validateStudent(st *domain.Student) error {
+	st.ExternalID = strings.TrimSpace(st.ExternalID)
+	st.Name = strings.TrimSpace(st.Name)
+	st.Email = strings.TrimSpace(st.Email)
+	if st.ExternalID == "" {
+		return fmt.Errorf("student_id is required")
+	}
+	if st.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if st.Email != "" {
+		if _, err := mail.ParseAddress(st.Email); err != nil {
+			return fmt.Errorf("invalid email %q", st.Email)
+		}
+	}
+	return nil
+}
+
+func (s *RosterService) AssignExam(ctx context.Context, tenantID, examID uuid.UUID, classIDs []uuid.UUID) error {
+	for _, id := range classIDs {
+		if _, err := s.repo.GetClass(ctx, tenantID, id); err != nil {
+			return fmt.Errorf("class %s not found: %w", id, err)
+		}
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.AssignExam(ctx, tenantID, examID, classIDs); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "exam",
+			EntityID:   examID,
+			EventType:  "assigned_to_classes",
+			Changes: map[string]interface{}{
+				"class_ids": classIDs,
+			},
+		})
+	})
+}
+
+func (s *RosterService) ListExamClasses(ctx context.Context, examID uuid.UUID) ([]domain.Class, error) {
+	return s.repo.ListExamClasses(ctx, examID)
+}
+
+// ValidateSubmission checks the submitting student against the roster of
+// the exam's classes. Exams not assigned to any class accept any student.
+func (s *RosterService) ValidateSubmission(ctx context.Context, sub *domain.Submission) error {
+	sub.StudentID = strings.TrimSpace(sub.StudentID)
+	if sub.StudentID == "" {
+		return fmt.Errorf("student_id is required")
+	}
+	assigned, enrolled, err := s.repo.EnrolledForExam(ctx, sub.ExamID, sub.StudentID)
+	if err != nil {
+		return err
+	}
+	if assigned && !enrolled {
+		return fmt.Errorf("%w: %s", ErrNotOnRoster, sub.StudentID)
+	}
+	return nil
+}
+
+func (s *RosterService) MissingSubmissions(ctx context.Context, examID uuid.UUID, classID *uuid.UUID) ([]domain.MissingSubmission, error) {
+	missing, err := s.repo.MissingSubmissions(ctx, examID, classID)
+	if missing == nil {
+		missing = []domain.MissingSubmission{}
+	}
+	return missing, err
+}
+
+// AssignTeachers makes teachers of the tenant responsible for a class.
+func (s *RosterService) AssignTeachers(ctx context.Context, tenantID, classID uuid.UUID, userIDs []uuid.UUID) error {
+	if _, err := s.repo.GetClass(ctx, tenantID, classID); err != nil {
+		return fmt.Errorf("class not found: %w", err)
+	}
+	for _, id := range userIDs {
+		user, err := s.userRepo.GetByID(ctx, id)
+		if err != nil || user.TenantID != tenantID {
+			return fmt.Errorf("user %s not found", id)
+		}
+		if user.Role != domain.RoleTeacher {
+			return fmt.Errorf("user %s is not a teacher", id)
+		}
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.AssignTeachers(ctx, tenantID, classID, userIDs); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "class",
+			EntityID:   classID,
+			EventType:  "teachers_assigned",
+			Changes: map[string]interface{}{
+				"user_ids": userIDs,
+			},
+		})
+	})
+}
+
+// AuthorizeGradeChange checks that the actor may change grades on a
+// submission. Admins always may; teachers only for students in a class they
+// teach, unless the exam is not assigned to any class.
+func (s *RosterService) AuthorizeGradeChange(ctx context.Context, submissionID uuid.UUID) error {
+	actor, err := auth.GetActor(ctx)
+	if err != nil {
+		return err
+	}
+	switch actor.Role {
+	case domain.RoleAdmin:
+		return nil
+	case domain.RoleTeacher:
+		assigned, teaches, err := s.repo.TeachesSubmission(ctx, actor.UserID, submissionID)
+		if err != nil {
+			return err
+		}
+		if assigned && !teaches {
+			return fmt.Errorf("%w: submission is not from a class you teach", auth.ErrForbidden)
+		}
+		return nil
+	}
+	return auth.ErrForbidden
+}
+
+// AuthorizeExamGradeChange checks that the actor may change the grades of a
+// whole exam, such as by curving it. Admins always may; teachers only if
+// they teach every class the exam is assigned to, unless it is not assigned
+// to any class.
+func (s *RosterService) AuthorizeExamGradeChange(ctx context.Context, examID uuid.UUID) error {
+	actor, err := auth.GetActor(ctx)
+	if err != nil {
+		return err
+	}
+	switch actor.Role {
+	case domain.RoleAdmin:
+		return nil
+	case domain.RoleTeacher:
+		assigned, teaches, err := s.repo.TeachesExam(ctx, actor.UserID, examID)
+		if err != nil {
+			return err
+		}
+		if assigned && !teaches {
+			return fmt.Errorf("%w: exam is assigned to a class you don't teach", auth.ErrForbidden)
+		}
+		return nil
+	}
+	return auth.ErrForbidden
+}
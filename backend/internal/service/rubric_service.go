@@ -0,0 +1,433 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"harama/internal/ai"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrProposalDecided is returned for a change to a rubric proposal
+	// that has already been accepted or rejected.
+	ErrProposalDecided = errors.New("rubric proposal has already been decided")
+	// ErrUnusableDraft is returned when the AI's rubric can't be made
+	// valid for the question.
+	ErrUnusableDraft = errors.New("drafted rubric is unusable")
+)
+
+// RubricError lists every mistake found in a rubric.
+type RubricError struct {
+	Errors []domain.FieldError
+}
+
+func (e *RubricError) Error() string {
+	first := e.Errors[0]
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("rubric has an error: %s: %s", first.Path, first.Message)
+	}
+	return fmt.Sprintf("rubric has %d errors, the first: %s: %s", len(e.Errors), first.Path, first.Message)
+}
+
+type RubricService struct {
+	repo       *postgres.RubricProposalRepo
+	examRepo   *postgres.ExamRepo
+	auditRepo  *postgres.AuditRepo
+	webhooks   *postgres.WebhookRepo
+	aiProvider ai.Provider
+}
+
+func NewRubricService(repo *postgres.RubricProposalRepo, examRepo *postgres.ExamRepo, auditRepo *postgres.AuditRepo, webhooks *postgres.WebhookRepo, aiProvider ai.Provider) *RubricService {
+	return &RubricService{
+		repo:       repo,
+		examRepo:   examRepo,
+		auditRepo:  auditRepo,
+		webhooks:   webhooks,
+		aiProvider: aiProvider,
+	}
+}
+
+// DraftRubric has AI draft a rubric for a question of the tenant in ctx
+// from its text, points and subject and the teacher's model answer or
+// marking scheme; without one, the question's answer key is used. The
+// draft is given stable IDs and its criteria are scaled to the question's
+// points, then kept as a pending proposal. The question's rubric is left
+// as it is.
+func (s *RubricService) DraftRubric(ctx context.Context, questionID uuid.UUID, modelAnswer string) (*domain.RubricProposal, error) {
+	question, err := s.examRepo.GetQuestionByID(ctx, questionID)
+	if err != nil {
+		return nil, err
+	}
+	exam, err := s.examRepo.GetByID(ctx, question.ExamID)
+	if err != nil {
+		return nil, err
+	}
+
+	modelAnswer = strings.TrimSpace(modelAnswer)
+	if modelAnswer == "" {
+		modelAnswer = question.AnswerKey
+	}
+	rubric, err := s.aiProvider.DraftRubric(ctx, ai.DraftRubricRequest{
+		QuestionText: question.QuestionText,
+		Points:       question.Points,
+		Subject:      exam.Subject,
+		AnswerType:   question.AnswerType,
+		ModelAnswer:  modelAnswer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	doc := NormalizeRubric(domain.NewRubricDocument(&rubric), question.Points)
+	if errs := validateProposedRubric(doc, question.Points); len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %v", ErrUnusableDraft, &RubricError{Errors: errs})
+	}
+
+	p := &domain.RubricProposal{
+		ID:          uuid.New(),
+		TenantID:    exam.TenantID,
+		QuestionID:  question.ID,
+		Source:      domain.RubricSourceAIDraft,
+		Status:      domain.RubricProposalPending,
+		Rubric:      doc,
+		ModelAnswer: modelAnswer,
+	}
+	if actor, err := auth.GetActor(ctx); err == nil {
+		p.CreatedBy = &actor.UserID
+	}
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, p); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "rubric_proposal",
+			EntityID:   p.ID,
+			EventType:  "drafted",
+			Changes: map[string]interface{}{
+				"question_id": p.QuestionID,
+				"source":      p.Source,
+				"criteria":    len(doc.Criteria),
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *RubricService) ListProposals(ctx context.Context, questionID uuid.UUID) ([]domain.RubricProposal, error) {
+	return s.repo.ListByQuestion(ctx, questionID)
+}
+
+func (s *RubricService) GetProposal(ctx context.Context, id uuid.UUID) (*domain.RubricProposal, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+// UpdateProposal replaces a pending proposal's rubric with the teacher's
+// edit of it. The edit must be a complete rubric for the question; its
+// mistakes are returned together as a *RubricError.
+func (s *RubricService) UpdateProposal(ctx context.Context, id uuid.UUID, doc *domain.RubricDocument) (*domain.RubricProposal, error) {
+	p, question, err := s.pending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if errs := validateProposedRubric(doc, question.Points); len(errs) > 0 {
+		return nil, &RubricError{Errors: errs}
+	}
+
+	p.Rubric = doc
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Save(ctx, p); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "rubric_proposal",
+			EntityID:   p.ID,
+			EventType:  "edited",
+			Changes: map[string]interface{}{
+				"question_id": p.QuestionID,
+				"criteria":    len(doc.Criteria),
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AcceptProposal makes a pending proposal the question's rubric.
+func (s *RubricService) AcceptProposal(ctx context.Context, id uuid.UUID) (*domain.Rubric, error) {
+	p, _, err := s.pending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.decide(ctx, p, domain.RubricProposalAccepted)
+
+	rubric := p.Rubric.ToRubric()
+	rubric.QuestionID = p.QuestionID
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.examRepo.UpdateRubric(ctx, rubric); err != nil {
+			return err
+		}
+		if err := s.repo.Save(ctx, p); err != nil {
+			return err
+		}
+		err := s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "rubric",
+			EntityID:   rubric.ID,
+			EventType:  "updated",
+			Changes: map[string]interface{}{
+				"question_id": p.QuestionID,
+				"proposal_id": p.ID,
+				"source":      p.Source,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		return s.webhooks.Publish(ctx, domain.EventRubricUpdated, map[string]interface{}{
+			"rubric_id":   rubric.ID,
+			"question_id": p.QuestionID,
+			"source":      p.Source,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rubric, nil
+}
+
+// RejectProposal discards a pending proposal, keeping it for the record.
+func (s *RubricService) RejectProposal(ctx context.Context, id uuid.UUID) (*domain.RubricProposal, error) {
+	p, _, err := s.pending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.decide(ctx, p, domain.RubricProposalRejected)
+
+	err = s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Save(ctx, p); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "rubric_proposal",
+			EntityID:   p.ID,
+			EventType:  "rejected",
+			Changes: map[string]interface{}{
+				"question_id": p.QuestionID,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// pending loads a proposal that is still to be decided, with its question.
+func (s *RubricService) pending(ctx context.Context, id uuid.UUID) (*domain.RubricProposal, *domain.Question, error) {
+	p, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.Status != domain.RubricProposalPending {
+		return nil, nil, fmt.Errorf("%w: it was %s", ErrProposalDecided, p.Status)
+	}
+	question, err := s.examRepo.GetQuestionByID(ctx, p.QuestionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, question, nil
+}
+
+func (s *RubricService) decide(ctx context.Context, p *domain.RubricProposal, status domain.RubricProposalStatus) {
+	now := time.Now()
+	p.Status, p.DecidedAt = status, &now
+	if actor, err := auth.GetActor(ctx); err == nil {
+		p.DecidedBy = &actor.UserID
+	}
+}
+
+// ValidateRubric checks a rubric for a question worth points, trimming its
+// text as it goes. Paths are relative to the rubric.
+func ValidateRubric(r *domain.RubricDocument, points int) []domain.FieldError {
+	var errs []domain.FieldError
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, domain.FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	criteria := map[string]bool{}
+	total := 0.0
+	for i := range r.Criteria {
+		c := &r.Criteria[i]
+		p := fmt.Sprintf("criteria[%d]", i)
+		c.ID, c.Description = strings.TrimSpace(c.ID), strings.TrimSpace(c.Description)
+		if c.ID != "" {
+			if criteria[c.ID] {
+				fail(p+".id", "%q is the ID of another criterion", c.ID)
+			}
+			criteria[c.ID] = true
+		}
+		if c.Description == "" {
+			fail(p+".description", "is required")
+		}
+		if c.Points < 0 {
+			fail(p+".points", "must not be negative")
+		}
+		total += c.Points
+	}
+	if total > float64(points) {
+		fail("criteria", "are worth %s points, more than the question's %d", formatPoints(total), points)
+	}
+
+	for i := range r.PartialCredit {
+		rule := &r.PartialCredit[i]
+		p := fmt.Sprintf("partial_credit[%d]", i)
+		rule.Condition = strings.TrimSpace(rule.Condition)
+		if rule.Condition == "" {
+			fail(p+".condition", "is required")
+		}
+		if rule.Points < 0 || rule.Points > float64(points) {
+			fail(p+".points", "must be between 0 and the question's %d", points)
+		}
+		for j, dep := range rule.Dependencies {
+			if !criteria[dep] {
+				fail(fmt.Sprintf("%s.dependencies[%d]", p, j), "%q is not a criterion of this rubric", dep)
+			}
+		}
+	}
+
+	for i := range r.CommonMistakes {
+		m := &r.CommonMistakes[i]
+		p := fmt.Sprintf("common_mistakes[%d]", i)
+		m.Description = strings.TrimSpace(m.Description)
+		if m.Description == "" {
+			fail(p+".description", "is required")
+		}
+		if m.Penalty < 0 {
+			fail(p+".penalty", "must not be negative")
+		}
+	}
+	return errs
+}
+
+// validateProposedRubric is ValidateRubric for a rubric about to replace a
+// question's, which must also award all of the question's points.
+func validateProposedRubric(r *domain.RubricDocument, points int) []domain.FieldError {
+	errs := ValidateRubric(r, points)
+	total := 0.0
+	for _, c := range r.Criteria {
+		total += c.Points
+	}
+	switch {
+	case len(r.Criteria) == 0:
+		errs = append(errs, domain.FieldError{Path: "criteria", Message: "a rubric needs at least one criterion"})
+	case total < float64(points):
+		errs = append(errs, domain.FieldError{
+			Path:    "criteria",
+			Message: fmt.Sprintf("are worth %s points, less than the question's %d", formatPoints(total), points),
+		})
+	}
+	return errs
+}
+
+// NormalizeRubric tidies a drafted rubric for a question worth points.
+// Entries without text are dropped and the rest given stable IDs, c1, p1
+// and m1 onwards, with partial credit dependencies following their
+// criteria. Criteria are scaled to add up to the question's points in
+// half points, any rounding left over going to the largest, and partial
+// credit and penalties are kept within them.
+func NormalizeRubric(r *domain.RubricDocument, points int) *domain.RubricDocument {
+	out := &domain.RubricDocument{
+		Criteria:     []domain.CriterionDocument{},
+		KeyConcepts:  r.KeyConcepts,
+		GradingNotes: strings.TrimSpace(r.GradingNotes),
+		StrictMode:   r.StrictMode,
+	}
+
+	ids := map[string]string{}
+	for _, c := range r.Criteria {
+		if c.Description = strings.TrimSpace(c.Description); c.Description == "" {
+			continue
+		}
+		id := "c" + strconv.Itoa(len(out.Criteria)+1)
+		if old := strings.TrimSpace(c.ID); old != "" {
+			if _, dup := ids[old]; !dup {
+				ids[old] = id
+			}
+		}
+		c.ID, c.Points = id, math.Max(c.Points, 0)
+		out.Criteria = append(out.Criteria, c)
+	}
+	scaleCriteria(out.Criteria, float64(points))
+
+	for _, rule := range r.PartialCredit {
+		if rule.Condition = strings.TrimSpace(rule.Condition); rule.Condition == "" {
+			continue
+		}
+		var deps []string
+		for _, dep := range rule.Dependencies {
+			if id, ok := ids[strings.TrimSpace(dep)]; ok {
+				deps = append(deps, id)
+			}
+		}
+		rule.ID = "p" + strconv.Itoa(len(out.PartialCredit)+1)
+		rule.Points = clampPoints(rule.Points, float64(points))
+		rule.Dependencies = deps
+		out.PartialCredit = append(out.PartialCredit, rule)
+	}
+
+	for _, m := range r.CommonMistakes {
+		if m.Description = strings.TrimSpace(m.Description); m.Description == "" {
+			continue
+		}
+		m.ID = "m" + strconv.Itoa(len(out.CommonMistakes)+1)
+		m.Penalty = clampPoints(m.Penalty, float64(points))
+		out.CommonMistakes = append(out.CommonMistakes, m)
+	}
+	return out
+}
+
+// scaleCriteria shares points out among criteria in proportion to their
+// own, or evenly if none has any.
+func scaleCriteria(criteria []domain.CriterionDocument, points float64) {
+	if len(criteria) == 0 {
+		return
+	}
+	total := 0.0
+	for _, c := range criteria {
+		total += c.Points
+	}
+	sum, largest := 0.0, 0
+	for i := range criteria {
+		share := points / float64(len(criteria))
+		if total > 0 {
+			share = criteria[i].Points * points / total
+		}
+		criteria[i].Points = math.Round(share*2) / 2
+		sum += criteria[i].Points
+		if criteria[i].Points > criteria[largest].Points {
+			largest = i
+		}
+	}
+	criteria[largest].Points += points - sum
+}
+
+func clampPoints(p, max float64) float64 {
+	return math.Min(math.Max(p, 0), max)
+}
+
+func formatPoints(p float64) string {
+	return strconv.FormatFloat(p, 'f', -1, 64)
+}
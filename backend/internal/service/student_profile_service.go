@@ -0,0 +1,385 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"harama/internal/domain"
+	"harama/internal/pkg/utils"
+	"harama/internal/repository/postgres"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// A mistake or missed criterion must show up in at least this many
+	// separate submissions before it counts as a pattern
+	minPatternOccurrences = 2
+	// Criteria missed at least this often are reported as consistently missed
+	missRateThreshold = 0.5
+	// Slopes within this many percentage points per exam count as steady
+	steadySlope = 2.0
+	// Number of patterns of each kind included in a profile
+	profileListLimit = 10
+)
+
+type StudentProfileService struct {
+	gradeRepo *postgres.GradeRepo
+	subRepo   *postgres.SubmissionRepo
+	curveRepo *postgres.CurveRepo
+}
+
+func NewStudentProfileService(gradeRepo *postgres.GradeRepo, subRepo *postgres.SubmissionRepo, curveRepo *postgres.CurveRepo) *StudentProfileService {
+	return &StudentProfileService{gradeRepo: gradeRepo, subRepo: subRepo, curveRepo: curveRepo}
+}
+
+func (s *StudentProfileService) GetProfile(ctx context.Context, tenantID uuid.UUID, studentID string) (*domain.StudentProfile, error) {
+	if studentID == "" {
+		return nil, fmt.Errorf("student id is required")
+	}
+	rows, err := s.gradeRepo.ListForStudent(ctx, tenantID, studentID)
+	if err != nil {
+		return nil, err
+	}
+	profile := BuildStudentProfile(tenantID, studentID, rows)
+	if err := s.applyCurves(ctx, profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// applyCurves gives the profile's exam results their totals under the
+// curves applied to their exams.
+func (s *StudentProfileService) applyCurves(ctx context.Context, profile *domain.StudentProfile) error {
+	if s.curveRepo == nil || len(profile.Exams) == 0 {
+		return nil
+	}
+	examIDs := make([]uuid.UUID, 0, len(profile.Exams))
+	for _, e := range profile.Exams {
+		examIDs = append(examIDs, e.ExamID)
+	}
+	curves, err := s.curveRepo.ActiveForExams(ctx, profile.TenantID, examIDs)
+	if err != nil {
+		return err
+	}
+	byExam := make(map[uuid.UUID]*domain.GradeCurve, len(curves))
+	for i := range curves {
+		byExam[curves[i].ExamID] = &curves[i]
+	}
+	for i := range profile.Exams {
+		e := &profile.Exams[i]
+		curve, ok := byExam[e.ExamID]
+		if !ok {
+			continue
+		}
+		if total, ok := curve.CurvedTotal(e.SubmissionID, e.Total); ok {
+			e.CurvedTotal, e.CurveVersion = &total, curve.Version
+		}
+	}
+	return nil
+}
+
+// GetProfileForSubmission returns the profile of the student who wrote a
+// submission, along with the submission.
+func (s *StudentProfileService) GetProfileForSubmission(ctx context.Context, submissionID uuid.UUID) (*domain.StudentProfile, *domain.Submission, error) {
+	sub, err := s.subRepo.GetByID(ctx, submissionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	profile, err := s.GetProfile(ctx, sub.TenantID, sub.StudentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return profile, sub, nil
+}
+
+// BuildStudentProfile aggregates graded answers, ordered oldest first, into
+// a profile.
+func BuildStudentProfile(tenantID uuid.UUID, studentID string, rows []postgres.StudentGradeRow) *domain.StudentProfile {
+	profile := &domain.StudentProfile{
+		TenantID:          tenantID,
+		StudentID:         studentID,
+		AnswersGraded:     len(rows),
+		Subjects:          []domain.ScoreTrend{},
+		Concepts:          []domain.ScoreTrend{},
+		RecurringMistakes: []domain.RecurringMistake{},
+		MissedCriteria:    []domain.MissedCriterion{},
+		Exams:             []domain.ExamResult{},
+		GeneratedAt:       utils.CurrentTime(),
+	}
+
+	subjects := newTrendBuilder()
+	concepts := newTrendBuilder()
+	mistakes := map[string]*mistakeTally{}
+	criteria := map[string]*criterionTally{}
+	exams := map[uuid.UUID]bool{}
+	results := map[uuid.UUID]int{}
+	var scored, possible float64
+
+	for _, row := range rows {
+		exams[row.ExamID] = true
+		max := float64(row.MaxScore)
+		scored += row.Score
+		possible += max
+
+		i, ok := results[row.SubmissionID]
+		if !ok {
+			i = len(profile.Exams)
+			results[row.SubmissionID] = i
+			profile.Exams = append(profile.Exams, domain.ExamResult{
+				ExamID:       row.ExamID,
+				ExamTitle:    row.ExamTitle,
+				SubmissionID: row.SubmissionID,
+				Date:         row.SubmittedAt,
+			})
+		}
+		profile.Exams[i].Total += row.Score
+		profile.Exams[i].MaxTotal += row.MaxScore
+
+		subject := strings.TrimSpace(row.Subject)
+		if subject == "" {
+			subject = "general"
+		}
+		subjects.add(subject, row, max)
+		for _, concept := range row.KeyConcepts {
+			if concept = strings.TrimSpace(concept); concept != "" {
+				concepts.add(concept, row, max)
+			}
+		}
+
+		for _, m := range row.MistakesFound {
+			key := normalizePattern(m)
+			if key == "" {
+				continue
+			}
+			t, ok := mistakes[key]
+			if !ok {
+				t = &mistakeTally{text: strings.TrimSpace(m), submissions: map[uuid.UUID]bool{}, exams: map[uuid.UUID]bool{}}
+				mistakes[key] = t
+			}
+			t.count++
+			t.submissions[row.SubmissionID] = true
+			t.exams[row.ExamID] = true
+			if row.SubmittedAt.After(t.lastSeen) {
+				t.lastSeen = row.SubmittedAt
+			}
+		}
+
+		met := make(map[string]bool, len(row.CriteriaMet))
+		for _, id := range row.CriteriaMet {
+			met[id] = true
+		}
+		for _, c := range row.Criteria {
+			name := c.Category
+			if name == "" {
+				name = c.Description
+			}
+			key := normalizePattern(name)
+			if key == "" {
+				continue
+			}
+			t, ok := criteria[key]
+			if !ok {
+				t = &criterionTally{name: strings.TrimSpace(name), submissions: map[uuid.UUID]bool{}}
+				criteria[key] = t
+			}
+			t.attempts++
+			if !met[c.ID] {
+				t.missed++
+				t.submissions[row.SubmissionID] = true
+			}
+		}
+	}
+
+	profile.ExamsTaken = len(exams)
+	if possible > 0 {
+		profile.OverallPercent = round1(scored / possible * 100)
+	}
+	profile.Subjects = subjects.trends()
+	profile.Concepts = concepts.trends()
+
+	for _, t := range mistakes {
+		if len(t.submissions) < minPatternOccurrences {
+			continue
+		}
+		profile.RecurringMistakes = append(profile.RecurringMistakes, domain.RecurringMistake{
+			Mistake:  t.text,
+			Count:    t.count,
+			Exams:    len(t.exams),
+			LastSeen: t.lastSeen,
+		})
+	}
+	sort.Slice(profile.RecurringMistakes, func(i, j int) bool {
+		a, b := profile.RecurringMistakes[i], profile.RecurringMistakes[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Mistake < b.Mistake
+	})
+	profile.RecurringMistakes = limit(profile.RecurringMistakes, profileListLimit)
+
+	for _, t := range criteria {
+		rate := float64(t.missed) / float64(t.attempts)
+		if len(t.submissions) < minPatternOccurrences || rate < missRateThreshold {
+			continue
+		}
+		profile.MissedCriteria = append(profile.MissedCriteria, domain.MissedCriterion{
+			Criterion: t.name,
+			Missed:    t.missed,
+			Attempts:  t.attempts,
+			MissRate:  round2(rate),
+		})
+	}
+	sort.Slice(profile.MissedCriteria, func(i, j int) bool {
+		a, b := profile.MissedCriteria[i], profile.MissedCriteria[j]
+		if a.MissRate != b.MissRate {
+			return a.MissRate > b.MissRate
+		}
+		if a.Missed != b.Missed {
+			return a.Missed > b.Missed
+		}
+		return a.Criterion < b.Criterion
+	})
+	profile.MissedCriteria = limit(profile.MissedCriteria, profileListLimit)
+
+	return profile
+}
+
+type mistakeTally struct {
+	text        string
+	count       int
+	submissions map[uuid.UUID]bool
+	exams       map[uuid.UUID]bool
+	lastSeen    time.Time
+}
+
+type criterionTally struct {
+	name        string
+	attempts    int
+	missed      int
+	submissions map[uuid.UUID]bool
+}
+
+// trendBuilder accumulates scores per name and per exam, keeping exams in
+// the order they were first seen (rows arrive oldest first).
+type trendBuilder struct {
+	order  []string
+	byName map[string]*trendTally
+}
+
+type trendTally struct {
+	scored, possible float64
+	exams            []uuid.UUID
+	points           map[uuid.UUID]*examTally
+}
+
+type examTally struct {
+	title            string
+	date             time.Time
+	scored, possible float64
+}
+
+func newTrendBuilder() *trendBuilder {
+	return &trendBuilder{byName: map[string]*trendTally{}}
+}
+
+func (b *trendBuilder) add(name string, row postgres.StudentGradeRow, max float64) {
+	key := normalizePattern(name)
+	t, ok := b.byName[key]
+	if !ok {
+		t = &trendTally{points: map[uuid.UUID]*examTally{}}
+		b.byName[key] = t
+		b.order = append(b.order, name)
+	}
+	t.scored += row.Score
+	t.possible += max
+
+	e, ok := t.points[row.ExamID]
+	if !ok {
+		e = &examTally{title: row.ExamTitle, date: row.SubmittedAt}
+		t.points[row.ExamID] = e
+		t.exams = append(t.exams, row.ExamID)
+	}
+	e.scored += row.Score
+	e.possible += max
+}
+
+func (b *trendBuilder) trends() []domain.ScoreTrend {
+	out := make([]domain.ScoreTrend, 0, len(b.order))
+	for _, name := range b.order {
+		t := b.byName[normalizePattern(name)]
+		if t.possible == 0 {
+			continue
+		}
+		trend := domain.ScoreTrend{Name: name, Percent: round1(t.scored / t.possible * 100)}
+		var series []float64
+		for _, examID := range t.exams {
+			e := t.points[examID]
+			if e.possible == 0 {
+				continue
+			}
+			pct := e.scored / e.possible * 100
+			series = append(series, pct)
+			trend.Points = append(trend.Points, domain.TrendPoint{
+				ExamID:    examID,
+				ExamTitle: e.title,
+				Date:      e.date,
+				Percent:   round1(pct),
+			})
+		}
+		trend.Slope = round2(slope(series))
+		trend.Trend = direction(series, trend.Slope)
+		out = append(out, trend)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i].Points) > len(out[j].Points) })
+	return out
+}
+
+// slope fits a least-squares line through the series against its index.
+func slope(series []float64) float64 {
+	n := float64(len(series))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range series {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+}
+
+func direction(series []float64, slope float64) domain.TrendDirection {
+	switch {
+	case len(series) < 2:
+		return domain.TrendInsufficient
+	case slope > steadySlope:
+		return domain.TrendImproving
+	case slope < -steadySlope:
+		return domain.TrendDeclining
+	}
+	return domain.TrendSteady
+}
+
+// normalizePattern makes free-text mistakes from different evaluations
+// comparable: case, surrounding punctuation and repeated spaces are ignored.
+func normalizePattern(s string) string {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	return strings.Trim(s, " .;:,!")
+}
+
+func round1(v float64) float64 { return math.Round(v*10) / 10 }
+func round2(v float64) float64 { return math.Round(v*100) / 100 }
+
+func limit[T any](items []T, n int) []T {
+	if len(items) > n {
+		return items[:n]
+	}
+	return items
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"harama/internal/ai"
+	"harama/internal/domain"
+	"harama/internal/pkg/utils"
+	"harama/internal/repository/postgres"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// budgetCacheTTL is how long CheckBudget reuses a tenant's budget status
+// rather than summing the month's usage again for every AI call, so a tenant
+// can overshoot a hard stop by the calls made in that window.
+const budgetCacheTTL = 30 * time.Second
+
+// budgetErrorLogInterval bounds how often failing budget checks are logged.
+const budgetErrorLogInterval = time.Minute
+
+// UsageService stores AI usage records and enforces tenant budgets. It is the
+// ai.UsageRecorder and ai.BudgetChecker behind the metering decorators.
+type UsageService struct {
+	repo *postgres.UsageRepo
+
+	// warned remembers which month each tenant was last warned about so the
+	// soft-limit warning is logged once rather than on every call
+	warned sync.Map
+	// statuses caches each tenant's budget status for CheckBudget
+	statuses sync.Map
+
+	// errMu guards the rate limit on logging failed budget checks, which
+	// would otherwise fail every call during a database outage
+	errMu         sync.Mutex
+	errLoggedAt   time.Time
+	errSuppressed int
+}
+
+type cachedBudgetStatus struct {
+	status  *domain.BudgetStatus
+	expires time.Time
+}
+
+func NewUsageService(repo *postgres.UsageRepo) *UsageService {
+	return &UsageService{repo: repo}
+}
+
+var (
+	_ ai.UsageRecorder = (*UsageService)(nil)
+	_ ai.BudgetChecker = (*UsageService)(nil)
+)
+
+func (s *UsageService) RecordUsage(ctx context.Context, usage *domain.AIUsage) error {
+	return s.repo.Save(ctx, usage)
+}
+
+func (s *UsageService) CheckBudget(ctx context.Context, tenantID uuid.UUID) error {
+	status, err := s.cachedBudgetStatus(ctx, tenantID)
+	if err != nil {
+		// Don't stop grading because the accounting tables are unavailable
+		s.logCheckFailure(tenantID, err)
+		return nil
+	}
+
+	switch status.State {
+	case domain.BudgetExceeded:
+		if status.Budget.HardStop {
+			return fmt.Errorf("%w: spent $%.2f of $%.2f", ai.ErrBudgetExceeded, status.SpentUSD, status.Budget.MonthlyLimitUSD)
+		}
+		s.warnOnce(tenantID, status)
+	case domain.BudgetWarning:
+		s.warnOnce(tenantID, status)
+	}
+	return nil
+}
+
+func (s *UsageService) warnOnce(tenantID uuid.UUID, status *domain.BudgetStatus) {
+	month := utils.CurrentTime().Format("2006-01") + string(status.State)
+	if prev, ok := s.warned.Load(tenantID); ok && prev == month {
+		return
+	}
+	s.warned.Store(tenantID, month)
+	log.Printf("tenant %s AI budget %s: spent $%.2f of $%.2f this month", tenantID, status.State, status.SpentUSD, status.Budget.MonthlyLimitUSD)
+}
+
+// cachedBudgetStatus is GetBudgetStatus, reused for budgetCacheTTL.
+func (s *UsageService) cachedBudgetStatus(ctx context.Context, tenantID uuid.UUID) (*domain.BudgetStatus, error) {
+	now := utils.CurrentTime()
+	if c, ok := s.statuses.Load(tenantID); ok && now.Before(c.(cachedBudgetStatus).expires) {
+		return c.(cachedBudgetStatus).status, nil
+	}
+	status, err := s.GetBudgetStatus(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	s.statuses.Store(tenantID, cachedBudgetStatus{status: status, expires: now.Add(budgetCacheTTL)})
+	return status, nil
+}
+
+func (s *UsageService) logCheckFailure(tenantID uuid.UUID, err error) {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	now := utils.CurrentTime()
+	if now.Sub(s.errLoggedAt) < budgetErrorLogInterval {
+		s.errSuppressed++
+		return
+	}
+	if s.errSuppressed > 0 {
+		log.Printf("budget check failed for tenant %s: %v (%d more failures since last logged)", tenantID, err, s.errSuppressed)
+	} else {
+		log.Printf("budget check failed for tenant %s: %v", tenantID, err)
+	}
+	s.errLoggedAt = now
+	s.errSuppressed = 0
+}
+
+// GetBudgetStatus measures month-to-date spend against the tenant's budget.
+func (s *UsageService) GetBudgetStatus(ctx context.Context, tenantID uuid.UUID) (*domain.BudgetStatus, error) {
+	budget, err := s.repo.GetBudget(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	spent, err := s.repo.SpentSince(ctx, tenantID, monthStart(utils.CurrentTime()))
+	if err != nil {
+		return nil, err
+	}
+
+	status := &domain.BudgetStatus{Budget: budget, SpentUSD: spent, State: domain.BudgetOK}
+	if budget == nil || budget.MonthlyLimitUSD <= 0 {
+		return status, nil
+	}
+
+	switch {
+	case spent >= budget.MonthlyLimitUSD:
+		status.State = domain.BudgetExceeded
+	case spent >= budget.MonthlyLimitUSD*budget.SoftLimitRatio:
+		status.State = domain.BudgetWarning
+	}
+	return status, nil
+}
+
+// SetBudget sets the tenant's budget. CheckBudget in this process sees it at
+// once; in others, such as the worker, within budgetCacheTTL.
+func (s *UsageService) SetBudget(ctx context.Context, budget *domain.TenantBudget) error {
+	if budget.MonthlyLimitUSD < 0 {
+		return fmt.Errorf("monthly_limit_usd must not be negative")
+	}
+	if budget.SoftLimitRatio <= 0 || budget.SoftLimitRatio > 1 {
+		budget.SoftLimitRatio = 0.8
+	}
+	budget.UpdatedAt = utils.CurrentTime()
+	if err := s.repo.SetBudget(ctx, budget); err != nil {
+		return err
+	}
+	s.statuses.Delete(budget.TenantID)
+	return nil
+}
+
+type UsageReport struct {
+	Rows         []postgres.UsageSummary `json:"rows"`
+	TotalCostUSD float64                 `json:"total_cost_usd"`
+	TotalCalls   int                     `json:"total_calls"`
+	Budget       *domain.BudgetStatus    `json:"budget"`
+}
+
+func (s *UsageService) GetUsage(ctx context.Context, filter postgres.UsageFilter) (*UsageReport, error) {
+	rows, err := s.repo.Summarize(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UsageReport{Rows: rows}
+	for _, row := range rows {
+		report.TotalCostUSD += row.CostUSD
+		report.TotalCalls += row.Calls
+	}
+
+	report.Budget, err = s.GetBudgetStatus(ctx, filter.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
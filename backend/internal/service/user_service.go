@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"net/mail"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type UserService struct {
+	repo      *postgres.UserRepo
+	auditRepo *postgres.AuditRepo
+}
+
+func NewUserService(repo *postgres.UserRepo, auditRepo *postgres.AuditRepo) *UserService {
+	return &UserService{repo: repo, auditRepo: auditRepo}
+}
+
+func (s *UserService) GetUser(ctx context.Context, tenantID, userID uuid.UUID) (*domain.User, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TenantID != tenantID {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+func (s *UserService) ListUsers(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error) {
+	return s.repo.ListByTenant(ctx, tenantID)
+}
+
+// AddUser makes an identity provider account a member of the tenant.
+func (s *UserService) AddUser(ctx context.Context, user *domain.User) error {
+	if user.ID == uuid.Nil {
+		return fmt.Errorf("user id is required")
+	}
+	if err := validateUser(user); err != nil {
+		return err
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, user); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "user",
+			EntityID:   user.ID,
+			EventType:  "added",
+			Changes: map[string]interface{}{
+				"role":  user.Role,
+				"email": user.Email,
+			},
+		})
+	})
+}
+
+// UpdateUser changes a member's role, name or student link.
+func (s *UserService) UpdateUser(ctx context.Context, user *domain.User) error {
+	if err := validateUser(user); err != nil {
+		return err
+	}
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, user); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "user",
+			EntityID:   user.ID,
+			EventType:  "updated",
+			Changes: map[string]interface{}{
+				"role":       user.Role,
+				"student_id": user.StudentID,
+			},
+		})
+	})
+}
+
+func validateUser(user *domain.User) error {
+	user.Email = strings.TrimSpace(user.Email)
+	user.StudentID = strings.TrimSpace(user.StudentID)
+	if !user.Role.Valid() {
+		return fmt.Errorf("invalid role %q", user.Role)
+	}
+	if user.Email != "" {
+		if _, err := mail.ParseAddress(user.Email); err != nil {
+			return fmt.Errorf("invalid email %q", user.Email)
+		}
+	}
+	if user.Role == domain.RoleStudent && user.StudentID == "" {
+		return fmt.Errorf("student accounts need a student_id")
+	}
+	if user.Role != domain.RoleStudent {
+		user.StudentID = ""
+	}
+	return nil
+}
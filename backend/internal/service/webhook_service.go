@@ -0,0 +1,374 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/pkg/utils"
+	"harama/internal/repository/postgres"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers sent with every delivery. The signature header is
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" keyed by the
+// endpoint's secret>".
+const (
+	WebhookEventHeader     = "X-Harama-Event"
+	WebhookDeliveryHeader  = "X-Harama-Delivery"
+	WebhookSignatureHeader = "X-Harama-Signature"
+)
+
+const (
+	// webhookMaxAttempts is how many times a delivery is tried before it is
+	// marked failed; with webhookBaseBackoff doubling that spans about an hour.
+	webhookMaxAttempts = 8
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = time.Hour
+	// webhookBatchSize bounds the events fanned out and deliveries sent per
+	// round.
+	webhookBatchSize = 100
+	// webhookLease is how long a claimed delivery is hidden from other
+	// workers; it must outlast a send, so WEBHOOK_TIMEOUT must stay below it.
+	webhookLease = 2 * time.Minute
+	// webhookDeliveryListLimit bounds the delivery log returned at once.
+	webhookDeliveryListLimit = 100
+	// webhookErrorLimit bounds the response body kept from a failed attempt.
+	webhookErrorLimit = 512
+)
+
+// ErrPrivateWebhookAddress is returned for an endpoint, or a delivery to
+// one, on a loopback, private, link-local or unspecified address.
+var ErrPrivateWebhookAddress = errors.New("webhook endpoints must be on a public address")
+
+// WebhookPayload is the JSON body of a delivery. ID is the event's, so a
+// receiver can drop the duplicates retries and redeliveries produce.
+type WebhookPayload struct {
+	ID        uuid.UUID              `json:"id"`
+	Type      string                 `json:"type"`
+	TenantID  uuid.UUID              `json:"tenant_id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+type WebhookService struct {
+	repo      *postgres.WebhookRepo
+	auditRepo *postgres.AuditRepo
+	client    *http.Client
+	// allowPrivate lets endpoints be on private and local addresses, for
+	// development
+	allowPrivate bool
+}
+
+// NewWebhookService makes a service whose deliveries give up on an endpoint
+// after timeout. Unless allowPrivate, endpoints must be on public addresses,
+// which is checked when one is registered and again for each connection a
+// delivery makes, since its host may resolve elsewhere by then. Deliveries
+// don't follow redirects.
+func NewWebhookService(repo *postgres.WebhookRepo, auditRepo *postgres.AuditRepo, timeout time.Duration, allowPrivate bool) *WebhookService {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !publicAddr(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateWebhookAddress, host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// The dial check has to see the endpoint itself, not a proxy
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &WebhookService{repo: repo, auditRepo: auditRepo, client: client, allowPrivate: allowPrivate}
+}
+
+// SignWebhook returns the signature header value for a body sent at t.
+func SignWebhook(secret string, t time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", t.Unix())
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// CreateEndpoint registers an endpoint for the tenant and returns the secret
+// its deliveries are signed with, which is never available again.
+func (s *WebhookService) CreateEndpoint(ctx context.Context, ep *domain.WebhookEndpoint) (string, error) {
+	if err := validateWebhookEndpoint(ep); err != nil {
+		return "", err
+	}
+	if !s.allowPrivate {
+		if err := checkWebhookHost(ctx, ep.URL); err != nil {
+			return "", err
+		}
+	}
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	ep.ID = uuid.New()
+	ep.Secret = "whsec_" + hex.EncodeToString(secret)
+	if actor, err := auth.GetActor(ctx); err == nil {
+		ep.CreatedBy = &actor.UserID
+	}
+
+	err := s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateEndpoint(ctx, ep); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "webhook_endpoint",
+			EntityID:   ep.ID,
+			EventType:  "created",
+			Changes: map[string]interface{}{
+				"url":    ep.URL,
+				"events": ep.Events,
+			},
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return ep.Secret, nil
+}
+
+func (s *WebhookService) ListEndpoints(ctx context.Context) ([]domain.WebhookEndpoint, error) {
+	return s.repo.ListEndpoints(ctx)
+}
+
+// DeleteEndpoint stops deliveries to an endpoint and drops its delivery log.
+func (s *WebhookService) DeleteEndpoint(ctx context.Context, id uuid.UUID) error {
+	return s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.DeleteEndpoint(ctx, id); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "webhook_endpoint",
+			EntityID:   id,
+			EventType:  "deleted",
+		})
+	})
+}
+
+func (s *WebhookService) ListDeliveries(ctx context.Context, endpointID *uuid.UUID) ([]domain.WebhookDelivery, error) {
+	return s.repo.ListDeliveries(ctx, endpointID, webhookDeliveryListLimit)
+}
+
+// Redeliver queues a delivery's event to be sent to its endpoint again now,
+// as a new delivery; the original keeps its outcome.
+func (s *WebhookService) Redeliver(ctx context.Context, deliveryID uuid.UUID) (*domain.WebhookDelivery, error) {
+	var d *domain.WebhookDelivery
+	err := s.auditRepo.RunInTx(ctx, func(ctx context.Context) error {
+		orig, err := s.repo.GetDelivery(ctx, deliveryID)
+		if err != nil {
+			return err
+		}
+		now := utils.CurrentTime()
+		d = &domain.WebhookDelivery{
+			ID:            uuid.New(),
+			TenantID:      orig.TenantID,
+			EndpointID:    orig.EndpointID,
+			EventID:       orig.EventID,
+			EventType:     orig.EventType,
+			Status:        domain.DeliveryPending,
+			NextAttemptAt: &now,
+		}
+		if err := s.repo.CreateDelivery(ctx, d); err != nil {
+			return err
+		}
+		return s.auditRepo.Save(ctx, &domain.AuditLog{
+			EntityType: "webhook_delivery",
+			EntityID:   d.ID,
+			EventType:  "redelivered",
+			Changes: map[string]interface{}{
+				"original_delivery_id": orig.ID,
+				"endpoint_id":          orig.EndpointID,
+				"event_id":             orig.EventID,
+				"event_type":           orig.EventType,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DispatchEvents fans outbox events out to the endpoints subscribed to them
+// and returns how many events it took.
+func (s *WebhookService) DispatchEvents(ctx context.Context) (int, error) {
+	n := 0
+	for n < webhookBatchSize {
+		found, err := s.repo.DispatchNext(ctx, utils.CurrentTime())
+		if err != nil || !found {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// DeliverDue sends the deliveries that are due and records the outcome of
+// each. It returns how many it attempted; failed attempts are not errors,
+// they are retried.
+func (s *WebhookService) DeliverDue(ctx context.Context) (int, error) {
+	deliveries, err := s.repo.ClaimDue(ctx, utils.CurrentTime(), webhookLease, webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	for i := range deliveries {
+		if err := s.deliver(ctx, &deliveries[i]); err != nil {
+			errs = append(errs, fmt.Errorf("delivery %s: %w", deliveries[i].ID, err))
+		}
+	}
+	return len(deliveries), errors.Join(errs...)
+}
+
+func (s *WebhookService) deliver(ctx context.Context, d *domain.WebhookDelivery) error {
+	event, err := s.repo.GetEvent(ctx, d.EventID)
+	if err != nil {
+		return err
+	}
+	ep, err := s.repo.GetEndpoint(auth.WithTenantID(ctx, d.TenantID), d.EndpointID)
+	if err != nil {
+		return err
+	}
+
+	status, sendErr := s.send(ctx, ep, event, d.ID)
+	now := utils.CurrentTime()
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.ResponseStatus = status
+	switch {
+	case sendErr == nil:
+		d.Status = domain.DeliverySucceeded
+		d.DeliveredAt = &now
+		d.NextAttemptAt = nil
+		d.LastError = ""
+	case d.Attempts >= webhookMaxAttempts:
+		d.Status = domain.DeliveryFailed
+		d.NextAttemptAt = nil
+		d.LastError = sendErr.Error()
+	default:
+		next := now.Add(webhookBackoff(d.Attempts))
+		d.NextAttemptAt = &next
+		d.LastError = sendErr.Error()
+	}
+	return s.repo.RecordAttempt(ctx, d)
+}
+
+// send posts an event to an endpoint. Any 2xx response is success; it
+// returns the response status, 0 when there was none.
+func (s *WebhookService) send(ctx context.Context, ep *domain.WebhookEndpoint, event *domain.WebhookEvent, deliveryID uuid.UUID) (int, error) {
+	body, err := json.Marshal(WebhookPayload{
+		ID:        event.ID,
+		Type:      event.Type,
+		TenantID:  event.TenantID,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Data,
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Harama-Webhooks/1.0")
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookDeliveryHeader, deliveryID.String())
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(ep.Secret, utils.CurrentTime(), body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorLimit))
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookBackoff is the wait before the attempt after the given one.
+func webhookBackoff(attempt int) time.Duration {
+	d := webhookBaseBackoff << (attempt - 1)
+	if d <= 0 || d > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return d
+}
+
+func validateWebhookEndpoint(ep *domain.WebhookEndpoint) error {
+	u, err := url.Parse(ep.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	for _, event := range ep.Events {
+		if !slices.Contains(domain.WebhookEventTypes, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	if ep.Events == nil {
+		ep.Events = []string{}
+	}
+	return nil
+}
+
+// checkWebhookHost checks that the host of an endpoint's URL is, or resolves
+// only to, public addresses.
+func checkWebhookHost(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("url host %q could not be resolved", host)
+	}
+	for _, ip := range addrs {
+		if !publicAddr(ip) {
+			return fmt.Errorf("%w: %s is %s", ErrPrivateWebhookAddress, host, ip)
+		}
+	}
+	return nil
+}
+
+// publicAddr reports whether ip is not loopback, private, link-local,
+// multicast or unspecified.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
@@ -2,17 +2,26 @@ package jobs
 
 import (
 	"context"
+	"errors"
+	"harama/internal/ai"
+	"harama/internal/auth"
+	"harama/internal/progress"
 	"harama/internal/service"
+	"harama/internal/worker"
 	"github.com/google/uuid"
 )
 
 type OCRJob struct {
+	TenantID     uuid.UUID
 	SubmissionID uuid.UUID
-	Service      *service.OCRService
+	// Actor queued the job; nil for jobs the system starts
+	Actor   *auth.Actor
+	Service *service.OCRService
 }
 
 func (j *OCRJob) Execute(ctx context.Context) error {
-	return j.Service.ProcessSubmission(ctx, j.SubmissionID)
+	ctx = jobContext(ctx, j.TenantID, j.Actor, j.ID())
+	return deferWhileUnavailable(j.Service.ProcessSubmission(ctx, j.SubmissionID))
 }
 
 func (j *OCRJob) ID() string {
@@ -20,14 +29,80 @@ func (j *OCRJob) ID() string {
 }
 
 type GradingJob struct {
+	TenantID     uuid.UUID
 	SubmissionID uuid.UUID
-	Service      *service.GradingService
+	// BatchID groups the jobs queued together; nil for a single submission
+	BatchID *uuid.UUID
+	// Actor queued the job; nil for jobs the system starts
+	Actor   *auth.Actor
+	Service *service.GradingService
 }
 
 func (j *GradingJob) Execute(ctx context.Context) error {
-	return j.Service.GradeSubmission(ctx, j.SubmissionID)
+	ctx = jobContext(ctx, j.TenantID, j.Actor, j.ID())
+	if j.BatchID != nil {
+		ctx = progress.WithBatch(ctx, *j.BatchID)
+	}
+	return deferWhileUnavailable(j.Service.GradeSubmission(ctx, j.SubmissionID))
 }
 
 func (j *GradingJob) ID() string {
 	return "grading-" + j.SubmissionID.String()
 }
+
+// ReportJob generates a queued report of a submission or an exam.
+type ReportJob struct {
+	TenantID uuid.UUID
+	ReportID uuid.UUID
+	// Actor queued the job; nil for jobs the system starts
+	Actor   *auth.Actor
+	Service *service.ReportService
+}
+
+func (j *ReportJob) Execute(ctx context.Context) error {
+	ctx = jobContext(ctx, j.TenantID, j.Actor, j.ID())
+	return j.Service.Generate(ctx, j.ReportID)
+}
+
+func (j *ReportJob) ID() string {
+	return "report-" + j.ReportID.String()
+}
+
+// QuestionPaperJob reads an uploaded question paper into a draft of its
+// exam's questions.
+type QuestionPaperJob struct {
+	TenantID        uuid.UUID
+	QuestionPaperID uuid.UUID
+	// Actor queued the job; nil for jobs the system starts
+	Actor   *auth.Actor
+	Service *service.QuestionPaperService
+}
+
+func (j *QuestionPaperJob) Execute(ctx context.Context) error {
+	ctx = jobContext(ctx, j.TenantID, j.Actor, j.ID())
+	return deferWhileUnavailable(j.Service.Process(ctx, j.QuestionPaperID))
+}
+
+func (j *QuestionPaperJob) ID() string {
+	return "question-paper-" + j.QuestionPaperID.String()
+}
+
+// jobContext scopes a job to its tenant and attributes what it does to the
+// job and to the actor who queued it.
+func jobContext(ctx context.Context, tenantID uuid.UUID, actor *auth.Actor, id string) context.Context {
+	if actor != nil {
+		ctx = auth.WithActor(ctx, *actor)
+	}
+	ctx = auth.WithTenantID(ctx, tenantID)
+	return auth.WithJob(ctx, auth.Job{ID: id})
+}
+
+// deferWhileUnavailable asks the pool to retry the job once an open AI
+// circuit is due to let calls through again.
+func deferWhileUnavailable(err error) error {
+	var open *ai.CircuitOpenError
+	if errors.As(err, &open) {
+		return worker.Defer(open.RetryAfter, err)
+	}
+	return err
+}
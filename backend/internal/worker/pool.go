@@ -2,8 +2,11 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"time"
 )
 
 type Job interface {
@@ -11,12 +14,34 @@ type Job interface {
 	ID() string
 }
 
+// DeferredError is returned by a job that can't make progress right now
+// (e.g. its upstream is down). The pool re-queues it after After instead of
+// treating it as failed.
+type DeferredError struct {
+	After time.Duration
+	Err   error
+}
+
+func (e *DeferredError) Error() string {
+	return fmt.Sprintf("deferred for %s: %v", e.After, e.Err)
+}
+
+func (e *DeferredError) Unwrap() error { return e.Err }
+
+// Defer wraps err so the pool runs the job again after the given delay.
+func Defer(after time.Duration, err error) error {
+	return &DeferredError{After: after, Err: err}
+}
+
 type WorkerPool struct {
 	numWorkers int
 	jobQueue   chan Job
 	wg         sync.WaitGroup
 	ctx        context.Context
 	cancel     context.CancelFunc
+
+	mu      sync.RWMutex
+	stopped bool
 }
 
 func NewWorkerPool(numWorkers int, bufferSize int) *WorkerPool {
@@ -51,7 +76,12 @@ func (p *WorkerPool) worker(id int) {
 				return
 			}
 			log.Printf("Worker %d starting job: %s", id, job.ID())
-			if err := job.Execute(p.ctx); err != nil {
+			err := job.Execute(p.ctx)
+			var deferred *DeferredError
+			if errors.As(err, &deferred) {
+				log.Printf("Worker %d job %s deferred for %s: %v", id, job.ID(), deferred.After, deferred.Err)
+				p.submitAfter(deferred.After, job)
+			} else if err != nil {
 				log.Printf("Worker %d job %s failed: %v", id, job.ID(), err)
 			} else {
 				log.Printf("Worker %d job %s completed successfully", id, job.ID())
@@ -64,7 +94,28 @@ func (p *WorkerPool) Submit(job Job) {
 	p.jobQueue <- job
 }
 
+// submitAfter re-queues a job once the delay has passed, unless the pool has
+// stopped by then. A full queue pushes the job back by the same delay again.
+func (p *WorkerPool) submitAfter(delay time.Duration, job Job) {
+	time.AfterFunc(delay, func() {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		if p.stopped {
+			return
+		}
+		select {
+		case p.jobQueue <- job:
+		default:
+			go p.submitAfter(delay, job)
+		}
+	})
+}
+
 func (p *WorkerPool) Stop() {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+
 	p.cancel()
 	close(p.jobQueue)
 	p.wg.Wait()
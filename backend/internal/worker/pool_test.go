@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -121,3 +122,33 @@ func TestWorkerPoolErrorHandling(t *testing.T) {
 		t.Error("Job should execute even if it errors")
 	}
 }
+
+type deferringJob struct {
+	runs *int32
+}
+
+func (j *deferringJob) ID() string { return "deferring" }
+
+func (j *deferringJob) Execute(ctx context.Context) error {
+	if atomic.AddInt32(j.runs, 1) == 1 {
+		return Defer(10*time.Millisecond, errors.New("provider down"))
+	}
+	return nil
+}
+
+func TestWorkerPool_DeferredJobRunsAgain(t *testing.T) {
+	pool := NewWorkerPool(1, 4)
+	pool.Start()
+	defer pool.Stop()
+
+	var runs int32
+	pool.Submit(&deferringJob{runs: &runs})
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&runs) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&runs); got != 2 {
+		t.Errorf("expected deferred job to run twice, ran %d times", got)
+	}
+}
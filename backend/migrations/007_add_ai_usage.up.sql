@@ -0,0 +1,28 @@
+CREATE TABLE ai_usage (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL,
+    exam_id UUID,
+    submission_id UUID,
+    task VARCHAR(50) NOT NULL,
+    evaluator_id VARCHAR(100),
+    provider VARCHAR(50),
+    model VARCHAR(100),
+    input_tokens INTEGER NOT NULL DEFAULT 0,
+    output_tokens INTEGER NOT NULL DEFAULT 0,
+    latency_ms BIGINT NOT NULL DEFAULT 0,
+    cost_usd DECIMAL(12,6) NOT NULL DEFAULT 0,
+    success BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_ai_usage_tenant_created ON ai_usage(tenant_id, created_at);
+CREATE INDEX idx_ai_usage_exam ON ai_usage(exam_id);
+CREATE INDEX idx_ai_usage_submission ON ai_usage(submission_id);
+
+CREATE TABLE tenant_budgets (
+    tenant_id UUID PRIMARY KEY,
+    monthly_limit_usd DECIMAL(12,2) NOT NULL,
+    soft_limit_ratio DECIMAL(3,2) NOT NULL DEFAULT 0.80,
+    hard_stop BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1 @@
+ALTER TABLE grades DROP COLUMN IF EXISTS generation_settings;
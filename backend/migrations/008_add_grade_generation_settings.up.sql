@@ -0,0 +1 @@
+ALTER TABLE grades ADD COLUMN IF NOT EXISTS generation_settings JSONB;
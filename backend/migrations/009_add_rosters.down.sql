@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_submissions_exam_student;
+DROP TABLE IF EXISTS exam_classes;
+DROP TABLE IF EXISTS enrollments;
+DROP TABLE IF EXISTS classes;
+DROP TABLE IF EXISTS students;
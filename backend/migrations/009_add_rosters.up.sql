@@ -0,0 +1,62 @@
+CREATE TABLE students (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    external_id VARCHAR(100) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, external_id)
+);
+
+CREATE TABLE classes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    name VARCHAR(255) NOT NULL,
+    section VARCHAR(100),
+    term VARCHAR(100),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_classes_tenant ON classes(tenant_id);
+
+CREATE TABLE enrollments (
+    class_id UUID NOT NULL REFERENCES classes(id) ON DELETE CASCADE,
+    student_id UUID NOT NULL REFERENCES students(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    enrolled_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (class_id, student_id)
+);
+
+CREATE INDEX idx_enrollments_student ON enrollments(student_id);
+
+CREATE TABLE exam_classes (
+    exam_id UUID NOT NULL REFERENCES exams(id) ON DELETE CASCADE,
+    class_id UUID NOT NULL REFERENCES classes(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    assigned_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (exam_id, class_id)
+);
+
+CREATE INDEX idx_exam_classes_class ON exam_classes(class_id);
+CREATE INDEX idx_submissions_exam_student ON submissions(exam_id, student_id);
+
+ALTER TABLE students ENABLE ROW LEVEL SECURITY;
+ALTER TABLE classes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE enrollments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE exam_classes ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_students_isolation ON students
+    FOR ALL
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+CREATE POLICY tenant_classes_isolation ON classes
+    FOR ALL
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+CREATE POLICY tenant_enrollments_isolation ON enrollments
+    FOR ALL
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+CREATE POLICY tenant_exam_classes_isolation ON exam_classes
+    FOR ALL
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS class_teachers;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,34 @@
+-- users is read to authenticate a request, before its tenant is known, so it
+-- has no row level security policy.
+CREATE TABLE users (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    email VARCHAR(255),
+    name VARCHAR(255),
+    role VARCHAR(20) NOT NULL CHECK (role IN ('admin', 'teacher', 'reviewer', 'student')),
+    student_id VARCHAR(100),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_users_tenant ON users(tenant_id);
+
+-- Tenants created under the old one-tenant-per-user model used the user's ID
+-- as the tenant ID; keep those accounts working as admins of their tenant.
+INSERT INTO users (id, tenant_id, role)
+SELECT id, id, 'admin' FROM tenants
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE class_teachers (
+    class_id UUID NOT NULL REFERENCES classes(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    PRIMARY KEY (class_id, user_id)
+);
+
+CREATE INDEX idx_class_teachers_user ON class_teachers(user_id);
+
+ALTER TABLE class_teachers ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_class_teachers_isolation ON class_teachers
+    FOR ALL
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
@@ -0,0 +1,62 @@
+ALTER TABLE exams NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE questions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE rubrics NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE submissions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE grades NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE escalations NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE feedback_events NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE audit_log NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE ai_usage NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE tenant_budgets NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE students NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE classes NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE enrollments NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE exam_classes NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE class_teachers NO FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_questions_isolation ON questions;
+DROP POLICY IF EXISTS tenant_rubrics_isolation ON rubrics;
+DROP POLICY IF EXISTS tenant_grades_isolation ON grades;
+DROP POLICY IF EXISTS tenant_escalations_isolation ON escalations;
+DROP POLICY IF EXISTS tenant_audit_log_isolation ON audit_log;
+DROP POLICY IF EXISTS tenant_ai_usage_isolation ON ai_usage;
+DROP POLICY IF EXISTS tenant_budgets_isolation ON tenant_budgets;
+
+ALTER TABLE questions DISABLE ROW LEVEL SECURITY;
+ALTER TABLE rubrics DISABLE ROW LEVEL SECURITY;
+ALTER TABLE grades DISABLE ROW LEVEL SECURITY;
+ALTER TABLE escalations DISABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_log DISABLE ROW LEVEL SECURITY;
+ALTER TABLE ai_usage DISABLE ROW LEVEL SECURITY;
+ALTER TABLE tenant_budgets DISABLE ROW LEVEL SECURITY;
+
+-- Restore the policies of migrations 004, 009 and 010
+DROP POLICY IF EXISTS tenant_exams_isolation ON exams;
+DROP POLICY IF EXISTS tenant_submissions_isolation ON submissions;
+DROP POLICY IF EXISTS tenant_feedback_isolation ON feedback_events;
+DROP POLICY IF EXISTS tenant_students_isolation ON students;
+DROP POLICY IF EXISTS tenant_classes_isolation ON classes;
+DROP POLICY IF EXISTS tenant_enrollments_isolation ON enrollments;
+DROP POLICY IF EXISTS tenant_exam_classes_isolation ON exam_classes;
+DROP POLICY IF EXISTS tenant_class_teachers_isolation ON class_teachers;
+
+CREATE POLICY tenant_exams_isolation ON exams
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant')::UUID);
+CREATE POLICY tenant_submissions_isolation ON submissions
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant')::UUID);
+CREATE POLICY tenant_feedback_isolation ON feedback_events
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant')::UUID);
+CREATE POLICY tenant_students_isolation ON students
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant')::UUID);
+CREATE POLICY tenant_classes_isolation ON classes
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant')::UUID);
+CREATE POLICY tenant_enrollments_isolation ON enrollments
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant')::UUID);
+CREATE POLICY tenant_exam_classes_isolation ON exam_classes
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant')::UUID);
+CREATE POLICY tenant_class_teachers_isolation ON class_teachers
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+DROP INDEX IF EXISTS idx_audit_log_tenant_created;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS tenant_id;
+DROP FUNCTION IF EXISTS app_current_tenant();
@@ -0,0 +1,103 @@
+-- The app sets app.current_tenant for the length of each tenant-scoped
+-- transaction. Outside one the setting is missing or empty and no tenant's
+-- rows are visible.
+CREATE OR REPLACE FUNCTION app_current_tenant() RETURNS UUID AS $$
+    SELECT NULLIF(current_setting('app.current_tenant', true), '')::UUID
+$$ LANGUAGE SQL STABLE;
+
+-- Tables that carry tenant_id directly
+UPDATE feedback_events fe SET tenant_id = s.tenant_id
+FROM submissions s WHERE s.id = fe.submission_id AND fe.tenant_id IS NULL;
+
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS tenant_id UUID;
+UPDATE audit_log al SET tenant_id = e.tenant_id FROM exams e
+WHERE al.tenant_id IS NULL AND al.entity_type = 'exam' AND e.id = al.entity_id;
+UPDATE audit_log al SET tenant_id = s.tenant_id FROM submissions s
+WHERE al.tenant_id IS NULL AND al.entity_type = 'submission' AND s.id = al.entity_id;
+UPDATE audit_log al SET tenant_id = s.tenant_id FROM grades g JOIN submissions s ON s.id = g.submission_id
+WHERE al.tenant_id IS NULL AND al.entity_type = 'grade' AND g.id = al.entity_id;
+UPDATE audit_log al SET tenant_id = e.tenant_id FROM questions q JOIN exams e ON e.id = q.exam_id
+WHERE al.tenant_id IS NULL AND al.entity_type IN ('question', 'rubric') AND q.id = al.entity_id;
+UPDATE audit_log al SET tenant_id = c.tenant_id FROM classes c
+WHERE al.tenant_id IS NULL AND al.entity_type = 'class' AND c.id = al.entity_id;
+UPDATE audit_log al SET tenant_id = u.tenant_id FROM users u
+WHERE al.tenant_id IS NULL AND al.entity_type = 'user' AND u.id = al.entity_id;
+CREATE INDEX IF NOT EXISTS idx_audit_log_tenant_created ON audit_log(tenant_id, created_at);
+
+-- Replace the earlier policies, which failed outright when the setting was
+-- missing, with ones based on app_current_tenant()
+DROP POLICY IF EXISTS tenant_exams_isolation ON exams;
+DROP POLICY IF EXISTS tenant_submissions_isolation ON submissions;
+DROP POLICY IF EXISTS tenant_feedback_isolation ON feedback_events;
+DROP POLICY IF EXISTS tenant_students_isolation ON students;
+DROP POLICY IF EXISTS tenant_classes_isolation ON classes;
+DROP POLICY IF EXISTS tenant_enrollments_isolation ON enrollments;
+DROP POLICY IF EXISTS tenant_exam_classes_isolation ON exam_classes;
+DROP POLICY IF EXISTS tenant_class_teachers_isolation ON class_teachers;
+
+CREATE POLICY tenant_exams_isolation ON exams
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_submissions_isolation ON submissions
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_feedback_isolation ON feedback_events
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_students_isolation ON students
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_classes_isolation ON classes
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_enrollments_isolation ON enrollments
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_exam_classes_isolation ON exam_classes
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_class_teachers_isolation ON class_teachers
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_audit_log_isolation ON audit_log
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_ai_usage_isolation ON ai_usage
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_budgets_isolation ON tenant_budgets
+    FOR ALL USING (tenant_id = app_current_tenant());
+
+-- Tables that belong to a tenant through their parent
+CREATE POLICY tenant_questions_isolation ON questions
+    FOR ALL USING (EXISTS (
+        SELECT 1 FROM exams e
+        WHERE e.id = questions.exam_id AND e.tenant_id = app_current_tenant()));
+CREATE POLICY tenant_rubrics_isolation ON rubrics
+    FOR ALL USING (EXISTS (
+        SELECT 1 FROM questions q JOIN exams e ON e.id = q.exam_id
+        WHERE q.id = rubrics.question_id AND e.tenant_id = app_current_tenant()));
+CREATE POLICY tenant_grades_isolation ON grades
+    FOR ALL USING (EXISTS (
+        SELECT 1 FROM submissions s
+        WHERE s.id = grades.submission_id AND s.tenant_id = app_current_tenant()));
+CREATE POLICY tenant_escalations_isolation ON escalations
+    FOR ALL USING (EXISTS (
+        SELECT 1 FROM submissions s
+        WHERE s.id = escalations.submission_id AND s.tenant_id = app_current_tenant()));
+
+ALTER TABLE questions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE rubrics ENABLE ROW LEVEL SECURITY;
+ALTER TABLE grades ENABLE ROW LEVEL SECURITY;
+ALTER TABLE escalations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_log ENABLE ROW LEVEL SECURITY;
+ALTER TABLE ai_usage ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tenant_budgets ENABLE ROW LEVEL SECURITY;
+
+-- The app usually connects as the owner of these tables, which bypasses row
+-- level security unless it is forced
+ALTER TABLE exams FORCE ROW LEVEL SECURITY;
+ALTER TABLE questions FORCE ROW LEVEL SECURITY;
+ALTER TABLE rubrics FORCE ROW LEVEL SECURITY;
+ALTER TABLE submissions FORCE ROW LEVEL SECURITY;
+ALTER TABLE grades FORCE ROW LEVEL SECURITY;
+ALTER TABLE escalations FORCE ROW LEVEL SECURITY;
+ALTER TABLE feedback_events FORCE ROW LEVEL SECURITY;
+ALTER TABLE audit_log FORCE ROW LEVEL SECURITY;
+ALTER TABLE ai_usage FORCE ROW LEVEL SECURITY;
+ALTER TABLE tenant_budgets FORCE ROW LEVEL SECURITY;
+ALTER TABLE students FORCE ROW LEVEL SECURITY;
+ALTER TABLE classes FORCE ROW LEVEL SECURITY;
+ALTER TABLE enrollments FORCE ROW LEVEL SECURITY;
+ALTER TABLE exam_classes FORCE ROW LEVEL SECURITY;
+ALTER TABLE class_teachers FORCE ROW LEVEL SECURITY;
@@ -0,0 +1,20 @@
+-- A checkpoint is a signed statement that a tenant's audit chain had
+-- entry_count entries ending in head_id with head_hash. Exported
+-- checkpoints let a third party show the chain was not rewritten since.
+CREATE TABLE audit_checkpoints (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    entry_count BIGINT NOT NULL,
+    head_id UUID NOT NULL,
+    head_hash VARCHAR(64) NOT NULL,
+    key_id VARCHAR(32) NOT NULL,
+    signature TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_audit_checkpoints_tenant ON audit_checkpoints(tenant_id, entry_count);
+
+CREATE POLICY tenant_audit_checkpoints_isolation ON audit_checkpoints
+    FOR ALL USING (tenant_id = app_current_tenant());
+ALTER TABLE audit_checkpoints ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_checkpoints FORCE ROW LEVEL SECURITY;
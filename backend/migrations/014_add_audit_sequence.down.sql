@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_audit_log_tenant_seq;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS hash_version;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS seq;
@@ -0,0 +1,19 @@
+-- Each tenant's chain is numbered 1, 2, ... in append order. seq replaces
+-- created_at, which can tie, as the chain order. Entries from now on are
+-- hash version 2, whose hash also covers seq, actor_type and metadata.
+ALTER TABLE audit_log ADD COLUMN seq BIGINT;
+ALTER TABLE audit_log ADD COLUMN hash_version SMALLINT NOT NULL DEFAULT 1;
+
+-- Number existing entries in the order they were chained. Row level
+-- security is lifted for the backfill, which must see every tenant's rows.
+ALTER TABLE audit_log NO FORCE ROW LEVEL SECURITY;
+UPDATE audit_log al SET seq = n.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY tenant_id ORDER BY created_at, id) AS seq
+    FROM audit_log
+) n
+WHERE n.id = al.id;
+ALTER TABLE audit_log FORCE ROW LEVEL SECURITY;
+
+ALTER TABLE audit_log ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX idx_audit_log_tenant_seq ON audit_log(tenant_id, seq);
@@ -0,0 +1,54 @@
+-- Tenants register endpoints to be told about pipeline and grading events.
+-- secret signs deliveries, so it is kept rather than hashed.
+CREATE TABLE webhook_endpoints (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    url TEXT NOT NULL,
+    description TEXT,
+    events JSONB NOT NULL DEFAULT '[]',
+    secret VARCHAR(100) NOT NULL,
+    created_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhook_endpoints_tenant ON webhook_endpoints(tenant_id);
+
+CREATE POLICY tenant_webhook_endpoints_isolation ON webhook_endpoints
+    FOR ALL USING (tenant_id = app_current_tenant());
+ALTER TABLE webhook_endpoints ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhook_endpoints FORCE ROW LEVEL SECURITY;
+
+-- The outbox: events are written in the transaction of the change they
+-- describe and fanned out to endpoints afterwards. Like api_keys, the
+-- outbox and deliveries are read across tenants (by the worker draining
+-- them), so they have no row level security policy.
+CREATE TABLE webhook_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    type VARCHAR(100) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    dispatched_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_webhook_events_pending ON webhook_events(created_at) WHERE dispatched_at IS NULL;
+
+-- One row per event per endpoint, plus one per manual redelivery
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    endpoint_id UUID NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL REFERENCES webhook_events(id),
+    event_type VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    last_attempt_at TIMESTAMPTZ,
+    response_status INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_tenant ON webhook_deliveries(tenant_id, created_at);
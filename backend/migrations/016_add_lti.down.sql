@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS lti_score_pushes;
+DROP TABLE IF EXISTS lti_user_links;
+DROP TABLE IF EXISTS lti_line_items;
+DROP TABLE IF EXISTS lti_launch_states;
+DROP TABLE IF EXISTS lti_platforms;
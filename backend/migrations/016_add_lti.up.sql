@@ -0,0 +1,88 @@
+-- LTI 1.3 platforms (LMSs) registered by a tenant. A launch names its
+-- platform by issuer and client_id before any tenant is known, so, like
+-- api_keys, the table has no row level security policy.
+CREATE TABLE lti_platforms (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    name VARCHAR(255) NOT NULL,
+    issuer TEXT NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    deployment_id VARCHAR(255),
+    auth_login_url TEXT NOT NULL,
+    auth_token_url TEXT NOT NULL,
+    jwks_url TEXT NOT NULL,
+    created_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (issuer, client_id)
+);
+
+CREATE INDEX idx_lti_platforms_tenant ON lti_platforms(tenant_id);
+
+-- OIDC login state, kept from the login redirect until the launch that
+-- answers it; each state is used once.
+CREATE TABLE lti_launch_states (
+    state VARCHAR(64) PRIMARY KEY,
+    nonce VARCHAR(64) NOT NULL,
+    platform_id UUID NOT NULL REFERENCES lti_platforms(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- An exam bound to a gradebook column of a platform by an instructor launch
+CREATE TABLE lti_line_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    platform_id UUID NOT NULL REFERENCES lti_platforms(id) ON DELETE CASCADE,
+    exam_id UUID NOT NULL REFERENCES exams(id) ON DELETE CASCADE,
+    resource_link_id VARCHAR(255) NOT NULL,
+    line_item_url TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (platform_id, resource_link_id)
+);
+
+CREATE INDEX idx_lti_line_items_exam ON lti_line_items(exam_id);
+
+-- A platform's user mapped to a student on the roster by external ID
+CREATE TABLE lti_user_links (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    platform_id UUID NOT NULL REFERENCES lti_platforms(id) ON DELETE CASCADE,
+    lms_user_id VARCHAR(255) NOT NULL,
+    student_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (platform_id, lms_user_id)
+);
+
+CREATE INDEX idx_lti_user_links_student ON lti_user_links(tenant_id, student_id);
+
+CREATE POLICY tenant_lti_line_items_isolation ON lti_line_items
+    FOR ALL USING (tenant_id = app_current_tenant());
+CREATE POLICY tenant_lti_user_links_isolation ON lti_user_links
+    FOR ALL USING (tenant_id = app_current_tenant());
+ALTER TABLE lti_line_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE lti_line_items FORCE ROW LEVEL SECURITY;
+ALTER TABLE lti_user_links ENABLE ROW LEVEL SECURITY;
+ALTER TABLE lti_user_links FORCE ROW LEVEL SECURITY;
+
+-- Scores queued for a line item when a submission's grades settle, and the
+-- outcome of the latest attempt to publish each. Like webhook deliveries
+-- they are sent by the worker across tenants, so no row level security.
+CREATE TABLE lti_score_pushes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    line_item_id UUID NOT NULL REFERENCES lti_line_items(id) ON DELETE CASCADE,
+    submission_id UUID NOT NULL REFERENCES submissions(id) ON DELETE CASCADE,
+    lms_user_id VARCHAR(255) NOT NULL,
+    score_given DOUBLE PRECISION NOT NULL,
+    score_maximum DOUBLE PRECISION NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    last_attempt_at TIMESTAMPTZ,
+    response_status INTEGER,
+    last_error TEXT,
+    pushed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_lti_score_pushes_due ON lti_score_pushes(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_lti_score_pushes_tenant ON lti_score_pushes(tenant_id, created_at);
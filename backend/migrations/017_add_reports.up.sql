@@ -0,0 +1,25 @@
+-- Marked papers generated for students: a PDF for one submission or a ZIP
+-- of them for an exam, built by a worker and kept in object storage.
+CREATE TABLE reports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    exam_id UUID NOT NULL REFERENCES exams(id) ON DELETE CASCADE,
+    submission_id UUID REFERENCES submissions(id) ON DELETE CASCADE,
+    format VARCHAR(10) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    object_name TEXT,
+    size_bytes BIGINT,
+    included INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    requested_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_reports_exam ON reports(tenant_id, exam_id, created_at);
+CREATE INDEX idx_reports_submission ON reports(submission_id) WHERE submission_id IS NOT NULL;
+
+CREATE POLICY tenant_reports_isolation ON reports
+    FOR ALL USING (tenant_id = app_current_tenant());
+ALTER TABLE reports ENABLE ROW LEVEL SECURITY;
+ALTER TABLE reports FORCE ROW LEVEL SECURITY;
@@ -0,0 +1,2 @@
+ALTER TABLE questions DROP COLUMN IF EXISTS answer_key;
+ALTER TABLE questions DROP COLUMN IF EXISTS choices;
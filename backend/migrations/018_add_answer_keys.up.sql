@@ -0,0 +1,4 @@
+-- Answer keys: the expected answer of a question (the correct choice IDs of a
+-- multiple choice question, comma separated) and the choices offered
+ALTER TABLE questions ADD COLUMN IF NOT EXISTS answer_key TEXT DEFAULT '';
+ALTER TABLE questions ADD COLUMN IF NOT EXISTS choices JSONB;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS rubric_proposals;
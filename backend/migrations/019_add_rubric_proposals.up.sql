@@ -0,0 +1,23 @@
+-- Rubrics suggested for a question, drafted by AI from its text and model
+-- answer. A teacher edits a proposal and accepts it as the question's
+-- rubric, or rejects it; nothing is applied until then.
+CREATE TABLE rubric_proposals (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    question_id UUID NOT NULL REFERENCES questions(id) ON DELETE CASCADE,
+    source VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    rubric JSONB NOT NULL,
+    model_answer TEXT,
+    created_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    decided_by UUID,
+    decided_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_rubric_proposals_question ON rubric_proposals(tenant_id, question_id, created_at);
+
+CREATE POLICY tenant_rubric_proposals_isolation ON rubric_proposals
+    FOR ALL USING (tenant_id = app_current_tenant());
+ALTER TABLE rubric_proposals ENABLE ROW LEVEL SECURITY;
+ALTER TABLE rubric_proposals FORCE ROW LEVEL SECURITY;
@@ -0,0 +1,23 @@
+-- Question papers uploaded to an exam as a PDF or photos of its pages. A
+-- worker reads them into a draft of the exam's questions, which a teacher
+-- reviews and confirms to create the questions.
+CREATE TABLE question_papers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    exam_id UUID NOT NULL REFERENCES exams(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    pages JSONB NOT NULL,
+    draft JSONB,
+    error TEXT,
+    uploaded_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    confirmed_by UUID,
+    confirmed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_question_papers_exam ON question_papers(tenant_id, exam_id, created_at);
+
+CREATE POLICY tenant_question_papers_isolation ON question_papers
+    FOR ALL USING (tenant_id = app_current_tenant());
+ALTER TABLE question_papers ENABLE ROW LEVEL SECURITY;
+ALTER TABLE question_papers FORCE ROW LEVEL SECURITY;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_rubrics_question;
+ALTER TABLE questions DROP COLUMN IF EXISTS bank_linked;
+ALTER TABLE questions DROP COLUMN IF EXISTS bank_item_id;
+DROP TABLE IF EXISTS bank_items;
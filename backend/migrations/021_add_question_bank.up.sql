@@ -0,0 +1,39 @@
+-- The question bank: a tenant's questions and their rubrics kept apart from
+-- any exam, tagged so that they can be found again and reused.
+CREATE TABLE bank_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    question_text TEXT NOT NULL,
+    points INTEGER NOT NULL,
+    answer_type VARCHAR(50) NOT NULL,
+    answer_key TEXT,
+    choices JSONB,
+    visual_aids JSONB,
+    rubric JSONB,
+    topic VARCHAR(200),
+    learning_objective TEXT,
+    difficulty VARCHAR(20),
+    grade_level VARCHAR(50),
+    created_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_bank_items_tags ON bank_items(tenant_id, topic, difficulty, grade_level);
+CREATE INDEX idx_bank_items_search ON bank_items
+    USING GIN (to_tsvector('simple', question_text || ' ' || COALESCE(topic, '') || ' ' || COALESCE(learning_objective, '')));
+
+CREATE POLICY tenant_bank_items_isolation ON bank_items
+    FOR ALL USING (tenant_id = app_current_tenant());
+ALTER TABLE bank_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE bank_items FORCE ROW LEVEL SECURITY;
+
+-- A question made from a bank item remembers it, so that its grades count
+-- towards the item's statistics. One added by reference is linked, and
+-- follows edits to the item until it is graded.
+ALTER TABLE questions ADD COLUMN IF NOT EXISTS bank_item_id UUID REFERENCES bank_items(id) ON DELETE SET NULL;
+ALTER TABLE questions ADD COLUMN IF NOT EXISTS bank_linked BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX idx_questions_bank_item ON questions(bank_item_id) WHERE bank_item_id IS NOT NULL;
+
+-- A question has one rubric, which rubric updates upsert on
+CREATE UNIQUE INDEX IF NOT EXISTS idx_rubrics_question ON rubrics(question_id);
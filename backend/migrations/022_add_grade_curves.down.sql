@@ -0,0 +1,3 @@
+ALTER TABLE lti_score_pushes DROP COLUMN IF EXISTS curve_version;
+ALTER TABLE lti_score_pushes DROP COLUMN IF EXISTS raw_score;
+DROP TABLE IF EXISTS grade_curves;
@@ -0,0 +1,32 @@
+-- Curves applied to an exam's grades: an adjustment layer over the raw
+-- totals, which stay as graded. Each application is a new version keeping
+-- its policy and the totals it gave, and at most one version is active.
+CREATE TABLE grade_curves (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id),
+    exam_id UUID NOT NULL REFERENCES exams(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    policy JSONB NOT NULL,
+    max_total DECIMAL(10,2) NOT NULL,
+    raw_mean DECIMAL(10,2) NOT NULL,
+    adjusted_mean DECIMAL(10,2) NOT NULL,
+    scores JSONB NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    note TEXT,
+    applied_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deactivated_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_grade_curves_version ON grade_curves(exam_id, version);
+CREATE UNIQUE INDEX idx_grade_curves_active ON grade_curves(exam_id) WHERE active;
+
+CREATE POLICY tenant_grade_curves_isolation ON grade_curves
+    FOR ALL USING (tenant_id = app_current_tenant());
+ALTER TABLE grade_curves ENABLE ROW LEVEL SECURITY;
+ALTER TABLE grade_curves FORCE ROW LEVEL SECURITY;
+
+-- Totals pushed to an LMS are curved when the exam's curve covers them;
+-- the raw total and the curve's version are kept alongside
+ALTER TABLE lti_score_pushes ADD COLUMN raw_score DOUBLE PRECISION;
+ALTER TABLE lti_score_pushes ADD COLUMN curve_version INTEGER;
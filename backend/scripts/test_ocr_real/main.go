@@ -29,7 +29,7 @@ func main() {
 	}
 
 	// Initialize OCR processor
-	processor, err := ocr.NewGeminiOCRProcessor(apiKey)
+	processor, err := ocr.NewGeminiOCRProcessor(apiKey, nil)
 	if err != nil {
 		log.Fatalf("Failed to create processor: %v", err)
 	}
@@ -9,6 +9,7 @@ import (
 
 	"harama/internal/config"
 	"harama/internal/ocr"
+	"harama/internal/progress"
 	"harama/internal/repository/postgres"
 	"harama/internal/service"
 	"harama/internal/storage"
@@ -43,15 +44,19 @@ func main() {
 		log.Fatal("MinIO init failed:", err)
 	}
 
-	ocrProcessor, err := ocr.NewGeminiOCRProcessor(cfg.GeminiAPIKey)
+	ocrProcessor, err := ocr.NewGeminiOCRProcessor(cfg.GeminiAPIKey, nil)
 	if err != nil {
 		log.Fatal("Gemini OCR init failed:", err)
 	}
 	defer ocrProcessor.Close()
 
 	submissionRepo := postgres.NewSubmissionRepo(db)
+	examRepo := postgres.NewExamRepo(db)
 	auditRepo := postgres.NewAuditRepo(db)
-	ocrService := service.NewOCRService(submissionRepo, auditRepo, minioClient, ocrProcessor)
+	webhookRepo := postgres.NewWebhookRepo(db)
+	// Progress reaches streams served by a running API
+	bus := progress.NewBus(postgres.NewProgressRelay(db))
+	ocrService := service.NewOCRService(submissionRepo, examRepo, auditRepo, webhookRepo, bus, minioClient, ocrProcessor)
 
 	// Read answer.jpeg
 	imageData, err := os.ReadFile("answer.jpeg")
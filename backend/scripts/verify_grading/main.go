@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 
+	"harama/internal/ai"
+
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
 )
@@ -23,7 +25,7 @@ func main() {
 	}
 	defer client.Close()
 
-	model := client.GenerativeModel("gemini-3-flash-preview")
+	model := client.GenerativeModel(ai.DefaultModel)
 	resp, err := model.GenerateContent(ctx, genai.Text("Say 'Hello, I am working!' if you can hear me."))
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,92 @@
+package integration
+
+// Replays recorded OCR and grading calls through the real grading engine so
+// the workflow runs deterministically and without an API key. To refresh the
+// cassettes against Gemini run:
+//
+//	HARAMA_RECORD_CASSETTES=1 GEMINI_API_KEY=... go test ./tests/integration -run TestReplayedWorkflow
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"harama/internal/ai"
+	"harama/internal/ai/cassette"
+	"harama/internal/ai/gemini"
+	"harama/internal/domain"
+	"harama/internal/grading"
+	"harama/internal/ocr"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const cassetteDir = "testdata/cassettes"
+
+func replayFixtures(t *testing.T) (ai.Provider, ai.OCRProcessor) {
+	store, err := cassette.NewDirStore(cassetteDir)
+	require.NoError(t, err)
+
+	if os.Getenv("HARAMA_RECORD_CASSETTES") == "" {
+		replayer, err := cassette.NewReplayer(store, nil)
+		require.NoError(t, err)
+		replayer.StrictPrompts = true
+		return replayer, replayer
+	}
+
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		t.Fatal("recording cassettes needs GEMINI_API_KEY")
+	}
+	client, err := gemini.NewClient(apiKey, nil)
+	require.NoError(t, err)
+	processor, err := ocr.NewGeminiOCRProcessor(apiKey, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { processor.Close() })
+
+	recorder, err := cassette.Record(client, store, nil)
+	require.NoError(t, err)
+	ocrRecorder, err := cassette.RecordOCR(processor, store, nil)
+	require.NoError(t, err)
+	return recorder, ocrRecorder
+}
+
+func TestReplayedWorkflow(t *testing.T) {
+	ctx := context.Background()
+	provider, processor := replayFixtures(t)
+
+	page, err := os.ReadFile("testdata/answer_page.png")
+	require.NoError(t, err)
+
+	ocrResult, err := processor.ExtractText(ctx, page, "image/png")
+	require.NoError(t, err)
+	require.NotEmpty(t, ocrResult.RawText)
+
+	// Fixed IDs keep the request hashes stable between runs
+	questionID := uuid.MustParse("6f1f7a52-3a0e-4c1e-9d43-2f0a4a9b1c01")
+	rubric := domain.Rubric{
+		ID:         uuid.MustParse("6f1f7a52-3a0e-4c1e-9d43-2f0a4a9b1c02"),
+		QuestionID: questionID,
+		FullCreditCriteria: []domain.Criterion{
+			{ID: "c1", Description: "Names the reactants NaCl and H2O and the products NaOH, Cl2 and H2", Points: 2.0},
+			{ID: "c2", Description: "Balanced equation 2NaCl + 2H2O -> 2NaOH + Cl2 + H2", Points: 2.0},
+			{ID: "c3", Description: "Bleaching powder is CaOCl2, made from Ca(OH)2 and Cl2", Points: 1.0},
+		},
+	}
+	answer := domain.AnswerSegment{
+		ID:         uuid.MustParse("6f1f7a52-3a0e-4c1e-9d43-2f0a4a9b1c03"),
+		QuestionID: questionID,
+		Text:       ocrResult.RawText,
+	}
+
+	engine := grading.NewEngine(provider)
+	finalGrade, multiEval, err := engine.GradeAnswer(ctx, answer, rubric, "chemistry", "Explain the chlor-alkali process and how bleaching powder is prepared.")
+	require.NoError(t, err)
+
+	require.Len(t, multiEval.Evaluations, 3)
+	assert.InDelta(t, 4.0, finalGrade.FinalScore, 1e-9)
+	assert.Equal(t, domain.GradeStatusAutoGraded, finalGrade.Status)
+	assert.Len(t, finalGrade.GenerationSettings, 3)
+}
@@ -33,13 +33,13 @@ func TestOCRAndGradingWorkflow(t *testing.T) {
 	t.Log("Initializing services...")
 	
 	// AI Client
-	aiClient, err := gemini.NewClient(apiKey)
+	aiClient, err := gemini.NewClient(apiKey, nil)
 	if err != nil {
 		t.Fatalf("Failed to create Gemini client: %v", err)
 	}
 
 	// OCR Processor
-	ocrProcessor, err := ocr.NewGeminiOCRProcessor(apiKey)
+	ocrProcessor, err := ocr.NewGeminiOCRProcessor(apiKey, nil)
 	if err != nil {
 		t.Fatalf("Failed to create OCR processor: %v", err)
 	}
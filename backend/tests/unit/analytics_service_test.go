@@ -22,7 +22,7 @@ func TestAnalyticsService_GetGradingTrends(t *testing.T) {
 	bunDB := bun.NewDB(db, pgdialect.New())
 	gradeRepo := postgres.NewGradeRepo(bunDB)
 	examRepo := postgres.NewExamRepo(bunDB)
-	analyticsService := service.NewAnalyticsService(gradeRepo, examRepo, nil)
+	analyticsService := service.NewAnalyticsService(gradeRepo, examRepo, nil, nil, nil, nil)
 
 	ctx := context.Background()
 	examID := uuid.New()
@@ -42,7 +42,7 @@ func TestAnalyticsService_GetGradingTrends(t *testing.T) {
 			AddRow(questionID, "What is photosynthesis?"))
 
 	// Execute
-	trends, err := analyticsService.GetGradingTrends(ctx, tenantID, &examID)
+	trends, err := analyticsService.GetGradingTrends(ctx, tenantID, &examID, "")
 
 	// Assert
 	assert.NoError(t, err)
@@ -60,7 +60,8 @@ func TestAnalyticsService_ExportGrades_CSV(t *testing.T) {
 	gradeRepo := postgres.NewGradeRepo(bunDB)
 	examRepo := postgres.NewExamRepo(bunDB)
 	subRepo := postgres.NewSubmissionRepo(bunDB)
-	analyticsService := service.NewAnalyticsService(gradeRepo, examRepo, subRepo)
+	rosterRepo := postgres.NewRosterRepo(bunDB)
+	analyticsService := service.NewAnalyticsService(gradeRepo, examRepo, subRepo, rosterRepo, nil, nil)
 
 	ctx := context.Background()
 	examID := uuid.New()
@@ -82,17 +83,22 @@ func TestAnalyticsService_ExportGrades_CSV(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{"id", "student_id"}).
 			AddRow(subID, "Student 1"))
 
+	// 3b. Expectation: ExamStudentClasses (exam not assigned to a class)
+	mock.ExpectQuery(`SELECT .* FROM exam_classes .*`).
+		WillReturnRows(sqlmock.NewRows([]string{"external_id", "class_id", "class_name"}))
+
 	// 4. Expectation: GetBySubmission (Grades)
 	mock.ExpectQuery(`SELECT .* FROM "grades" .*`).
 		WillReturnRows(sqlmock.NewRows([]string{"id", "question_id", "final_score"}).
 			AddRow(uuid.New(), q1ID, 10.0))
 
 	// Execute
-	data, contentType, err := analyticsService.ExportGrades(ctx, examID, "csv")
+	export, err := analyticsService.ExportGrades(ctx, examID, service.GradeExportOptions{Format: "csv"})
 
 	// Assert
 	assert.NoError(t, err) // If this fails due to SQLMock mismatch, I'll see it.
-	assert.Equal(t, "text/csv", contentType)
-	assert.Contains(t, string(data), "Student ID")
-	assert.Contains(t, string(data), "10.00")
+	assert.Equal(t, "text/csv", export.ContentType)
+	assert.Equal(t, "test_exam_grades.csv", export.Filename)
+	assert.Contains(t, string(export.Data), "Student ID")
+	assert.Contains(t, string(export.Data), "10.00")
 }
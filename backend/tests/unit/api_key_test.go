@@ -0,0 +1,213 @@
+package unit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"harama/internal/api/middleware"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+
+	assert.True(t, auth.IsAPIKey(key))
+	assert.True(t, strings.HasPrefix(key, prefix))
+	assert.Len(t, prefix, len(auth.APIKeyPrefix)+8)
+	assert.Equal(t, auth.HashAPIKey(key), hash)
+	assert.NotContains(t, hash, key)
+
+	other, _, _, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+}
+
+func TestActorCan_APIKeyScopes(t *testing.T) {
+	actor := auth.Actor{Role: domain.RoleService, Permissions: auth.ScopePermissions([]string{"submissions:write", "grades:read"})}
+
+	assert.True(t, actor.Can(auth.PermSubmissionWrite))
+	assert.True(t, actor.Can(auth.PermGradeRead))
+	assert.False(t, actor.Can(auth.PermSubmissionRead))
+	assert.False(t, actor.Can(auth.PermExportRead))
+	assert.False(t, actor.Can(auth.PermAPIKeyManage))
+
+	// Users keep their role's permissions
+	assert.True(t, auth.Actor{Role: domain.RoleTeacher}.Can(auth.PermExportRead))
+	assert.False(t, auth.Actor{Role: domain.RoleTeacher}.Can(auth.PermAPIKeyManage))
+}
+
+func TestAPIKeyService_CreateKeyValidation(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	cases := []struct {
+		name    string
+		key     domain.APIKey
+		wantErr string
+	}{
+		{"missing name", domain.APIKey{Scopes: []string{"grades:read"}}, "name is required"},
+		{"no scopes", domain.APIKey{Name: "scanner"}, "at least one scope"},
+		{"unknown scope", domain.APIKey{Name: "scanner", Scopes: []string{"grades:write"}}, `unknown scope "grades:write"`},
+		{"expired", domain.APIKey{Name: "scanner", Scopes: []string{"grades:read"}, ExpiresAt: &past}, "expires_at"},
+	}
+
+	keys := service.NewAPIKeyService(nil, nil)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := keys.CreateKey(context.Background(), &tc.key)
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestAPIKeyService_Authenticate(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	cases := []struct {
+		name      string
+		expiresAt *time.Time
+		revokedAt *time.Time
+		wantErr   error
+	}{
+		{"active", &future, nil, nil},
+		{"no expiry", nil, nil, nil},
+		{"expired", &past, nil, auth.ErrAPIKeyExpired},
+		{"revoked", nil, &past, auth.ErrAPIKeyRevoked},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			secret, _, hash, err := auth.GenerateAPIKey()
+			require.NoError(t, err)
+			keyID, tenantID := uuid.New(), uuid.New()
+
+			mock.ExpectQuery(`SELECT .* FROM "api_keys" AS "ak" WHERE \(key_hash = '` + hash + `'\)`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "name", "scopes", "expires_at", "revoked_at"}).
+					AddRow(keyID, tenantID, "scanner", `["submissions:write"]`, tc.expiresAt, tc.revokedAt))
+			if tc.wantErr == nil {
+				mock.ExpectExec(`UPDATE "api_keys" AS "ak" SET last_used_at = .* WHERE \(id = '` + keyID.String() + `'\) AND \(last_used_at IS NULL OR last_used_at < .*\)`).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			keys := service.NewAPIKeyService(postgres.NewAPIKeyRepo(bun.NewDB(db, pgdialect.New())), nil)
+			key, err := keys.Authenticate(context.Background(), secret)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tenantID, key.TenantID)
+				assert.Equal(t, []string{"submissions:write"}, key.Scopes)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAPIKeyService_AuthenticateUnknownKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT .* FROM "api_keys"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	keys := service.NewAPIKeyService(postgres.NewAPIKeyRepo(bun.NewDB(db, pgdialect.New())), nil)
+	_, err = keys.Authenticate(context.Background(), auth.APIKeyPrefix+"not-a-real-key")
+	assert.ErrorIs(t, err, auth.ErrAPIKeyInvalid)
+
+	// A JWT is never looked up as a key
+	_, err = keys.Authenticate(context.Background(), "eyJhbGciOiJIUzI1NiJ9.e30.sig")
+	assert.ErrorIs(t, err, auth.ErrAPIKeyInvalid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type fakeKeys map[string]*domain.APIKey
+
+func (k fakeKeys) Authenticate(ctx context.Context, secret string) (*domain.APIKey, error) {
+	key, ok := k[secret]
+	if !ok {
+		return nil, auth.ErrAPIKeyInvalid
+	}
+	if key.RevokedAt != nil {
+		return nil, auth.ErrAPIKeyRevoked
+	}
+	return key, nil
+}
+
+func TestAuthMiddleware_APIKeys(t *testing.T) {
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{Secret: []byte("shared-secret")})
+	require.NoError(t, err)
+
+	revoked := time.Now()
+	scanner := &domain.APIKey{ID: uuid.New(), TenantID: uuid.New(), Scopes: []string{"submissions:write"}}
+	keys := fakeKeys{
+		"hk_scanner": scanner,
+		"hk_revoked": {ID: uuid.New(), TenantID: uuid.New(), Scopes: []string{"grades:read"}, RevokedAt: &revoked},
+	}
+
+	var got auth.Actor
+	authn := middleware.AuthMiddleware(verifier, fakeDirectory{}, keys)
+	handler := func(perm auth.Permission) http.Handler {
+		return authn(middleware.RequirePermission(perm)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = auth.GetActor(r.Context())
+		})))
+	}
+	do := func(perm auth.Permission, header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		handler(perm).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Keys are accepted in X-API-Key and as bearer tokens
+	assert.Equal(t, http.StatusOK, do(auth.PermSubmissionWrite, "X-API-Key", "hk_scanner"))
+	assert.Equal(t, http.StatusOK, do(auth.PermSubmissionWrite, "Authorization", "Bearer hk_scanner"))
+	assert.Equal(t, scanner.ID, got.UserID)
+	assert.Equal(t, scanner.TenantID, got.TenantID)
+	assert.Equal(t, domain.RoleService, got.Role)
+
+	// Scopes limit what a key can do
+	assert.Equal(t, http.StatusForbidden, do(auth.PermGradeRead, "X-API-Key", "hk_scanner"))
+
+	assert.Equal(t, http.StatusUnauthorized, do(auth.PermGradeRead, "X-API-Key", "hk_revoked"))
+	assert.Equal(t, http.StatusUnauthorized, do(auth.PermGradeRead, "Authorization", "Bearer hk_unknown"))
+}
+
+func TestAuditRepo_AttributesEntriesToAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	keyID, tenantID := uuid.New(), uuid.New()
+	expectTenantTx(mock, tenantID)
+	expectAuditAppend(mock, tenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log" .*'` + keyID.String() + `', 'service'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(keyID))
+	mock.ExpectCommit()
+
+	ctx := auth.WithActor(context.Background(), auth.Actor{UserID: keyID, TenantID: tenantID, Role: domain.RoleService})
+	entry := &domain.AuditLog{EntityType: "submission", EntityID: uuid.New(), EventType: "created"}
+	require.NoError(t, postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New())).Save(ctx, entry))
+
+	require.NotNil(t, entry.ActorID)
+	assert.Equal(t, keyID, *entry.ActorID)
+	assert.Equal(t, "service", entry.ActorType)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,347 @@
+package unit_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+var testAuditKey = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+// expectAuditAppend expects AuditRepo.Save to lock the tenant's chain and
+// read its head inside the transaction. The INSERT follows.
+func expectAuditAppend(mock sqlmock.Sqlmock, tenantID uuid.UUID) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtextextended('audit_log:` + tenantID.String() + `', 0))`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT "al"."seq", "al"."hash" FROM "audit_log" AS "al" WHERE \(tenant_id = '` + tenantID.String() + `'\) ORDER BY "seq" DESC LIMIT 1`).
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "hash"}))
+}
+
+// auditChain builds n correctly chained entries for a tenant.
+func auditChain(tenantID uuid.UUID, n int) []domain.AuditLog {
+	logs := make([]domain.AuditLog, n)
+	prev := domain.ChainSeed
+	start := time.Now().Add(-time.Hour).UTC().Truncate(time.Microsecond)
+	for i := range logs {
+		logs[i] = domain.AuditLog{
+			ID:          uuid.New(),
+			TenantID:    &tenantID,
+			EntityType:  "grade",
+			EntityID:    uuid.New(),
+			EventType:   "updated",
+			ActorType:   "ai",
+			Changes:     map[string]interface{}{"score": float64(i) + 0.5},
+			Metadata:    map[string]interface{}{"job_id": "grading-1"},
+			Seq:         int64(i + 1),
+			HashVersion: domain.CurrentHashVersion,
+			// Entries can share a timestamp; seq orders them
+			CreatedAt: start,
+		}
+		logs[i].Hash = logs[i].ChainHash(prev)
+		prev = logs[i].Hash
+	}
+	return logs
+}
+
+func auditRows(logs []domain.AuditLog) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "tenant_id", "entity_type", "entity_id", "event_type", "actor_type", "changes", "metadata", "seq", "hash_version", "hash", "created_at"})
+	for _, l := range logs {
+		changes, _ := json.Marshal(l.Changes)
+		metadata, _ := json.Marshal(l.Metadata)
+		rows.AddRow(l.ID, l.TenantID, l.EntityType, l.EntityID, l.EventType, l.ActorType, changes, metadata, l.Seq, l.HashVersion, l.Hash, l.CreatedAt)
+	}
+	return rows
+}
+
+func checkpointRows(cps ...domain.AuditCheckpoint) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "tenant_id", "entry_count", "head_id", "head_hash", "key_id", "signature", "created_at"})
+	for _, cp := range cps {
+		rows.AddRow(cp.ID, cp.TenantID, cp.EntryCount, cp.HeadID, cp.HeadHash, cp.KeyID, cp.Signature, cp.CreatedAt)
+	}
+	return rows
+}
+
+func signedCheckpoint(tenantID uuid.UUID, count int64, head domain.AuditLog) domain.AuditCheckpoint {
+	cp := domain.AuditCheckpoint{
+		ID:         uuid.New(),
+		TenantID:   tenantID,
+		EntryCount: count,
+		HeadID:     head.ID,
+		HeadHash:   head.Hash,
+		KeyID:      service.KeyID(testAuditKey.Public().(ed25519.PublicKey)),
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+	}
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(testAuditKey, []byte(cp.SignedPayload())))
+	return cp
+}
+
+func expectChainReads(mock sqlmock.Sqlmock, tenantID uuid.UUID, cps *sqlmock.Rows, logs []domain.AuditLog) {
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "audit_checkpoints" AS "ac" WHERE \(tenant_id = '` + tenantID.String() + `'\) ORDER BY "entry_count" ASC`).
+		WillReturnRows(cps)
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "audit_log" AS "al" WHERE \(tenant_id = '` + tenantID.String() + `'\) ORDER BY "al"."seq" ASC LIMIT 500`).
+		WillReturnRows(auditRows(logs))
+	mock.ExpectCommit()
+}
+
+func TestAuditService_VerifyChain(t *testing.T) {
+	tenantID := uuid.New()
+	cases := []struct {
+		name         string
+		build        func(logs []domain.AuditLog) ([]domain.AuditLog, []domain.AuditCheckpoint)
+		wantReason   string
+		wantPosition int64
+	}{
+		{
+			name: "intact",
+			build: func(logs []domain.AuditLog) ([]domain.AuditLog, []domain.AuditCheckpoint) {
+				return logs, []domain.AuditCheckpoint{signedCheckpoint(tenantID, 2, logs[1])}
+			},
+		},
+		{
+			name: "edited entry",
+			build: func(logs []domain.AuditLog) ([]domain.AuditLog, []domain.AuditCheckpoint) {
+				logs[1].Changes = map[string]interface{}{"score": 10.0}
+				return logs, nil
+			},
+			wantReason:   service.BreakHashMismatch,
+			wantPosition: 2,
+		},
+		{
+			name: "deleted entry",
+			build: func(logs []domain.AuditLog) ([]domain.AuditLog, []domain.AuditCheckpoint) {
+				return append(logs[:1], logs[2:]...), nil
+			},
+			wantReason:   service.BreakSequenceGap,
+			wantPosition: 2,
+		},
+		{
+			name: "reattributed entry",
+			build: func(logs []domain.AuditLog) ([]domain.AuditLog, []domain.AuditCheckpoint) {
+				logs[1].ActorType = "admin"
+				return logs, nil
+			},
+			wantReason:   service.BreakHashMismatch,
+			wantPosition: 2,
+		},
+		{
+			name: "legacy entries",
+			build: func(logs []domain.AuditLog) ([]domain.AuditLog, []domain.AuditCheckpoint) {
+				// Entries from before sequence numbers keep the old hash
+				prev := domain.ChainSeed
+				for i := range logs[:2] {
+					logs[i].HashVersion = domain.HashV1
+					logs[i].Hash = logs[i].ChainHash(prev)
+					prev = logs[i].Hash
+				}
+				logs[2].Hash = logs[2].ChainHash(prev)
+				return logs, nil
+			},
+		},
+		{
+			name: "rewritten and rehashed",
+			build: func(logs []domain.AuditLog) ([]domain.AuditLog, []domain.AuditCheckpoint) {
+				cp := signedCheckpoint(tenantID, 2, logs[1])
+				logs[1].Changes = map[string]interface{}{"score": 10.0}
+				logs[1].Hash = logs[1].ChainHash(logs[0].Hash)
+				logs[2].Hash = logs[2].ChainHash(logs[1].Hash)
+				return logs, []domain.AuditCheckpoint{cp}
+			},
+			wantReason:   service.BreakCheckpointMismatch,
+			wantPosition: 2,
+		},
+		{
+			name: "truncated",
+			build: func(logs []domain.AuditLog) ([]domain.AuditLog, []domain.AuditCheckpoint) {
+				return logs[:2], []domain.AuditCheckpoint{signedCheckpoint(tenantID, 3, logs[2])}
+			},
+			wantReason:   service.BreakCheckpointMissing,
+			wantPosition: 3,
+		},
+		{
+			name: "forged checkpoint",
+			build: func(logs []domain.AuditLog) ([]domain.AuditLog, []domain.AuditCheckpoint) {
+				cp := signedCheckpoint(tenantID, 3, logs[2])
+				cp.EntryCount = 2
+				return logs, []domain.AuditCheckpoint{cp}
+			},
+			wantReason:   service.BreakBadSignature,
+			wantPosition: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			logs, cps := tc.build(auditChain(tenantID, 3))
+			if tc.wantReason == service.BreakBadSignature {
+				expectTenantTx(mock, tenantID)
+				mock.ExpectQuery(`SELECT .* FROM "audit_checkpoints"`).WillReturnRows(checkpointRows(cps...))
+				mock.ExpectCommit()
+			} else {
+				expectChainReads(mock, tenantID, checkpointRows(cps...), logs)
+			}
+
+			audit := service.NewAuditService(postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New())), testAuditKey)
+			report, err := audit.VerifyChain(auth.WithTenantID(context.Background(), tenantID))
+			require.NoError(t, err)
+
+			if tc.wantReason == "" {
+				assert.True(t, report.Valid)
+				assert.Nil(t, report.Break)
+				assert.EqualValues(t, 3, report.Entries)
+				assert.Equal(t, logs[2].Hash, report.HeadHash)
+			} else {
+				assert.False(t, report.Valid)
+				require.NotNil(t, report.Break)
+				assert.Equal(t, tc.wantReason, report.Break.Reason)
+				assert.Equal(t, tc.wantPosition, report.Break.Position)
+			}
+			if tc.wantReason == service.BreakHashMismatch {
+				// The offending rows are reported
+				require.NotNil(t, report.Break.Entry)
+				require.NotNil(t, report.Break.Previous)
+				assert.Equal(t, logs[1].ID, report.Break.Entry.ID)
+				assert.Equal(t, logs[0].ID, report.Break.Previous.ID)
+				assert.NotEqual(t, report.Break.Entry.Hash, report.Break.ExpectedHash)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuditService_CreateCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	logs := auditChain(tenantID, 2)
+	expectChainReads(mock, tenantID, checkpointRows(), logs)
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "audit_checkpoints"`).WillReturnRows(checkpointRows())
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectExec(`INSERT INTO "audit_checkpoints" .*'` + logs[1].Hash + `'`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	audit := service.NewAuditService(postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New())), testAuditKey)
+	cp, err := audit.CreateCheckpoint(auth.WithTenantID(context.Background(), tenantID))
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, cp.EntryCount)
+	assert.Equal(t, logs[1].ID, cp.HeadID)
+
+	// Anyone with the public key can check the signature
+	sig, err := base64.StdEncoding.DecodeString(cp.Signature)
+	require.NoError(t, err)
+	assert.True(t, ed25519.Verify(testAuditKey.Public().(ed25519.PublicKey), []byte(cp.SignedPayload()), sig))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// No key, no checkpoints; only admins may ask
+	_, err = service.NewAuditService(nil, nil).CreateCheckpoint(context.Background())
+	assert.ErrorIs(t, err, service.ErrNoSigningKey)
+	assert.False(t, auth.Can(domain.RoleReviewer, auth.PermAuditVerify))
+}
+
+func TestAuditService_CreateCheckpointRefusesBrokenChain(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	logs := auditChain(tenantID, 2)
+	logs[0].EventType = "deleted"
+	expectChainReads(mock, tenantID, checkpointRows(), logs)
+
+	audit := service.NewAuditService(postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New())), testAuditKey)
+	_, err = audit.CreateCheckpoint(auth.WithTenantID(context.Background(), tenantID))
+	assert.ErrorIs(t, err, service.ErrChainBroken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseSigningKey(t *testing.T) {
+	key, err := service.ParseSigningKey("")
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+
+	key, err = service.ParseSigningKey(base64.StdEncoding.EncodeToString(make([]byte, ed25519.SeedSize)))
+	require.NoError(t, err)
+	assert.Equal(t, testAuditKey, key)
+
+	_, err = service.ParseSigningKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+}
+
+func TestAuditRepo_SaveNumbersEntriesOnChainHead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, requester := uuid.New(), uuid.New()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtextextended('audit_log:` + tenantID.String() + `', 0))`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT "al"."seq", "al"."hash" FROM "audit_log"`).
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "hash"}).AddRow(41, "head-hash"))
+	mock.ExpectQuery(`INSERT INTO "audit_log" .*'` + requester.String() + `', 'worker'.*"job_id":"grading-42".*, 42, 2, `).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(requester))
+	mock.ExpectCommit()
+
+	// A grading job queued by a teacher
+	ctx := auth.WithActor(context.Background(), auth.Actor{UserID: requester, TenantID: tenantID, Role: domain.RoleTeacher})
+	ctx = auth.WithJob(ctx, auth.Job{ID: "grading-42"})
+	entry := &domain.AuditLog{EntityType: "submission", EntityID: uuid.New(), EventType: "ocr_completed"}
+	require.NoError(t, postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New())).Save(ctx, entry))
+
+	assert.EqualValues(t, 42, entry.Seq)
+	assert.Equal(t, entry.ChainHash("head-hash"), entry.Hash)
+	assert.Equal(t, requester, *entry.ActorID)
+	assert.Equal(t, "worker", entry.ActorType)
+	assert.Equal(t, "grading-42", entry.Metadata["job_id"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuditRepo_SaveFailureRollsBackChange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	exam := &domain.Exam{ID: uuid.New(), TenantID: uuid.New(), Title: "Final Exam"}
+
+	// The exam and its audit entry share one transaction; a failed append
+	// undoes the exam
+	expectTenantTx(mock, exam.TenantID)
+	mock.ExpectQuery(`INSERT INTO "exams"`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	expectAuditAppend(mock, exam.TenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log"`).WillReturnError(errors.New("disk full"))
+	mock.ExpectRollback()
+
+	examService := service.NewExamService(postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), nil)
+	err = examService.CreateExam(context.Background(), exam)
+	assert.ErrorContains(t, err, "disk full")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -5,12 +5,14 @@ import (
 	"testing"
 	"time"
 
+	"harama/internal/auth"
 	"harama/internal/domain"
 	"harama/internal/repository/postgres"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
 )
@@ -27,7 +29,8 @@ func TestAuditRepo_Save(t *testing.T) {
 	repo := postgres.NewAuditRepo(bunDB)
 
 	// 2. Define test data
-	ctx := context.Background()
+	tenantID := uuid.New()
+	ctx := auth.WithTenantID(context.Background(), tenantID)
 	auditLog := &domain.AuditLog{
 		ID:         uuid.New(),
 		EntityType: "exam",
@@ -38,15 +41,15 @@ func TestAuditRepo_Save(t *testing.T) {
 		CreatedAt:  time.Now(),
 	}
 
-	// 3. Expectation: GetLastHash
-	// Use generous regex for SELECT
-	mock.ExpectQuery(`SELECT .*`).
-		WillReturnRows(sqlmock.NewRows([]string{"hash"})) 
+	// 3. Expectation: lock the chain and read its head, in a transaction
+	expectTenantTx(mock, tenantID)
+	expectAuditAppend(mock, tenantID)
 
 	// 4. Expectation: Save (Insert)
 	// Match INSERT and potential RETURNING clause. Bun uses QueryRow for RETURNING.
 	mock.ExpectQuery(`INSERT INTO "audit_log" .*`).
 		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	mock.ExpectCommit()
 
 	// 5. Execute
 	err = repo.Save(ctx, auditLog)
@@ -55,6 +58,9 @@ func TestAuditRepo_Save(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, auditLog.Hash)
 	assert.NotEqual(t, "initial_seed", auditLog.Hash) // Should be hashed
+	assert.EqualValues(t, 1, auditLog.Seq)
+	assert.Equal(t, &tenantID, auditLog.TenantID)
+	assert.Equal(t, domain.CurrentHashVersion, auditLog.HashVersion)
 
 	// Verify expectations
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -83,3 +89,39 @@ func TestAuditRepo_GetLastHash_Existing(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedHash, hash)
 }
+
+func TestAuditRepo_SaveRefusesEntryWithoutTenant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New()))
+	err = repo.Save(context.Background(), &domain.AuditLog{EntityType: "exam", EntityID: uuid.New(), EventType: "created"})
+	assert.ErrorContains(t, err, "audit entry has no tenant")
+	// Nothing is written, so no tenant-less chain can start
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuditRepo_GetLastHash_Empty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT .*`).WillReturnRows(sqlmock.NewRows([]string{"seq", "hash"}))
+
+	hash, err := postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New())).GetLastHash(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, domain.ChainSeed, hash)
+}
+
+func TestAuditRepo_GetLastHash_Error(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT .*`).WillReturnError(assert.AnError)
+
+	hash, err := postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New())).GetLastHash(context.Background())
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Empty(t, hash)
+}
@@ -0,0 +1,287 @@
+package unit_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"harama/internal/api/handlers"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/qti"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"gopkg.in/yaml.v3"
+)
+
+// sampleExamDocument has a question of every answer type, in two groups.
+func sampleExamDocument() *domain.ExamDocument {
+	return &domain.ExamDocument{
+		Format:  domain.ExamDocumentFormat,
+		Version: domain.ExamDocumentVersion,
+		Title:   "Biology Midterm",
+		Questions: []domain.QuestionDocument{
+			{Number: "1a", Group: "Cells", Text: "Which organelles make ATP?", Points: 2, AnswerType: domain.AnswerTypeMCQ,
+				AnswerKey: "B,C", Choices: []domain.Choice{{ID: "A", Text: "Ribosome"}, {ID: "B", Text: "Mitochondrion"}, {ID: "C", Text: "Chloroplast"}}},
+			{Number: "1b", Group: "Cells", Text: "Name the cell's control centre.", Points: 1, AnswerType: domain.AnswerTypeShortAnswer, AnswerKey: "Nucleus"},
+			{Number: "2", Text: "Explain osmosis.\n\nUse an example.", Points: 5, AnswerType: domain.AnswerTypeEssay,
+				Rubric: &domain.RubricDocument{
+					Criteria: []domain.CriterionDocument{
+						{ID: "c1", Description: "Defines a partially permeable membrane", Points: 2},
+						{ID: "c2", Description: "Gives an example", Points: 1.5},
+					},
+					GradingNotes: "Accept diffusion of water.",
+				}},
+			{Number: "3", Text: "Draw a plant cell.", Points: 4, AnswerType: domain.AnswerTypeDiagram, VisualAids: []string{"https://example.com/cell.png"}},
+		},
+	}
+}
+
+func TestQTI_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, qti.Write(&buf, sampleExamDocument()))
+	parts := unzip(t, buf.Bytes())
+	assert.Contains(t, parts["imsmanifest.xml"], `type="imsqti_test_xmlv2p1"`)
+	assert.Contains(t, parts["test.xml"], `<assessmentSection identifier="S1" title="Cells" visible="true">`)
+	item := parts["items/Q1a.xml"]
+	assert.Contains(t, item, `xmlns="http://www.imsglobal.org/xsd/imsqti_v2p1"`)
+	assert.Contains(t, item, `cardinality="multiple" baseType="identifier"`)
+	assert.Contains(t, item, `<choiceInteraction responseIdentifier="RESPONSE" shuffle="false" maxChoices="0">`)
+	assert.Contains(t, parts["items/Q2.xml"], `<rubricBlock view="scorer">`)
+
+	doc, problems, err := qti.Read(buf.Bytes())
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+	assert.Equal(t, "Biology Midterm", doc.Title)
+	require.Len(t, doc.Questions, 4)
+
+	mcq := doc.Questions[0]
+	assert.Equal(t, "1a", mcq.Number)
+	assert.Equal(t, "Cells", mcq.Group)
+	assert.Equal(t, domain.AnswerTypeMCQ, mcq.AnswerType)
+	assert.Equal(t, "B,C", mcq.AnswerKey)
+	assert.Equal(t, 2, mcq.Points)
+	assert.Equal(t, sampleExamDocument().Questions[0].Choices, mcq.Choices)
+
+	short := doc.Questions[1]
+	assert.Equal(t, domain.AnswerTypeShortAnswer, short.AnswerType)
+	assert.Equal(t, "Nucleus", short.AnswerKey)
+	assert.Equal(t, "Name the cell's control centre.", short.Text)
+
+	essay := doc.Questions[2]
+	assert.Empty(t, essay.Group)
+	assert.Equal(t, "Explain osmosis.\n\nUse an example.", essay.Text)
+	require.NotNil(t, essay.Rubric)
+	assert.Equal(t, "Accept diffusion of water.", essay.Rubric.GradingNotes)
+	require.Len(t, essay.Rubric.Criteria, 2)
+	assert.Equal(t, "Gives an example", essay.Rubric.Criteria[1].Description)
+	assert.Equal(t, 1.5, essay.Rubric.Criteria[1].Points)
+
+	diagram := doc.Questions[3]
+	assert.Equal(t, domain.AnswerTypeDiagram, diagram.AnswerType)
+	assert.Equal(t, []string{"https://example.com/cell.png"}, diagram.VisualAids)
+}
+
+func TestQTI_ReadReportsItemsItCannotImport(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name, content string) {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		f.Write([]byte(content))
+	}
+	// A manifest without a test, as item banks export
+	add("imsmanifest.xml", `<manifest xmlns="http://www.imsglobal.org/xsd/imscp_v1p1"><resources>
+		<resource identifier="i1" type="imsqti_item_xmlv2p1" href="i1.xml"/>
+		<resource identifier="i2" type="imsqti_item_xmlv2p1" href="i2.xml"/>
+		<resource identifier="i3" type="imsqti_item_xmlv2p1" href="missing.xml"/>
+	</resources></manifest>`)
+	add("i1.xml", `<assessmentItem xmlns="http://www.imsglobal.org/xsd/imsqti_v2p1" identifier="i1" title="Enzymes">
+		<responseDeclaration identifier="RESPONSE" cardinality="single" baseType="string"/>
+		<outcomeDeclaration identifier="SCORE" cardinality="single" baseType="float" normalMaximum="3"/>
+		<itemBody>
+			<rubricBlock view="scorer tutor"><ul><li>Names the active site (2 marks)</li><li>Mentions substrate</li></ul></rubricBlock>
+			<extendedTextInteraction responseIdentifier="RESPONSE"><prompt>How do <b>enzymes</b> work?</prompt></extendedTextInteraction>
+		</itemBody>
+	</assessmentItem>`)
+	add("i2.xml", `<assessmentItem identifier="i2" title="Hotspot"><itemBody>
+		<hotspotInteraction responseIdentifier="RESPONSE"/>
+	</itemBody></assessmentItem>`)
+	require.NoError(t, zw.Close())
+
+	doc, problems, err := qti.Read(buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, doc.Questions, 1)
+	q := doc.Questions[0]
+	assert.Equal(t, "How do enzymes work?", q.Text)
+	assert.Equal(t, domain.AnswerTypeEssay, q.AnswerType)
+	assert.Equal(t, 3, q.Points)
+	require.NotNil(t, q.Rubric)
+	assert.Equal(t, []domain.CriterionDocument{
+		{ID: "c1", Description: "Names the active site", Points: 2},
+		{ID: "c2", Description: "Mentions substrate"},
+	}, q.Rubric.Criteria)
+
+	assert.Equal(t, []domain.FieldError{
+		{Path: "i2.xml", Message: "hotspotInteraction is not supported"},
+		{Path: "missing.xml", Message: "missing.xml is missing from the package"},
+	}, problems)
+
+	_, _, err = qti.Read([]byte(`{"title": "not xml"}`))
+	assert.ErrorIs(t, err, qti.ErrNotQTI)
+}
+
+func TestExamService_ImportDefinition_YAML(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID := uuid.New(), uuid.New()
+	data, err := yaml.Marshal(sampleExamDocument())
+	require.NoError(t, err)
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`INSERT INTO "exams" .*'Biology Midterm'`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(examID, time.Now()))
+	mock.ExpectQuery(`INSERT INTO "questions" .*'1a', 'Cells', 'B,C', '\[{"id":"A","text":"Ribosome"}.*'Nucleus'`).
+		WillReturnRows(sqlmock.NewRows([]string{"bank_item_id"}).AddRow(nil).AddRow(nil).AddRow(nil).AddRow(nil))
+	mock.ExpectQuery(`INSERT INTO "rubrics" .*Defines a partially permeable membrane`).
+		WillReturnRows(sqlmock.NewRows([]string{"strict_mode"}).AddRow(false))
+	expectAuditAppend(mock, tenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log" .*'exam', '` + examID.String() + `', 'imported'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	mock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewExamService(postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), nil)
+	exam, err := svc.ImportDefinition(auth.WithTenantID(context.Background(), tenantID), tenantID, "yaml", data)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, examID, exam.ID)
+	require.Len(t, exam.Questions, 4)
+	essay := exam.Questions[2]
+	assert.Equal(t, examID, essay.ExamID)
+	require.NotNil(t, essay.Rubric)
+	assert.Equal(t, essay.ID, essay.Rubric.QuestionID)
+	assert.NotNil(t, essay.Rubric.CommonMistakes, "NOT NULL lists are kept empty")
+}
+
+func TestExamService_ImportDefinition_ReportsEveryError(t *testing.T) {
+	// Nothing is written, so there is no database
+	svc := service.NewExamService(nil, nil, nil)
+	tenantID := uuid.New()
+
+	_, err := svc.ImportDefinition(context.Background(), tenantID, "json", []byte(`{
+		"format": "harama.exam",
+		"title": " ",
+		"questions": [
+			{"number": "1", "text": "Pick one", "points": 2, "answer_type": "mcq", "answer_key": "D",
+			 "choices": [{"id": "A", "text": "Yes"}, {"id": "A", "text": "No"}]},
+			{"number": "1", "text": "Explain", "points": 0, "answer_type": "essay",
+			 "rubric": {"criteria": [{"id": "c1", "description": "Reason", "points": 3}],
+			            "partial_credit": [{"condition": "Half right", "points": 1, "dependencies": ["c9"]}]}},
+			{"text": "Draw", "points": 1, "answer_type": "sketch"}
+		]
+	}`))
+	var defErr *service.ExamDefinitionError
+	require.ErrorAs(t, err, &defErr)
+	paths := map[string]string{}
+	for _, e := range defErr.Errors {
+		paths[e.Path] = e.Message
+	}
+	assert.Equal(t, map[string]string{
+		"title":                                                 "is required",
+		"questions[0].choices[1].id":                            `"A" is the ID of another choice`,
+		"questions[0].answer_key":                               `"D" is not one of the choices`,
+		"questions[1].number":                                   `"1" is also the number of questions[0]`,
+		"questions[1].points":                                   "must be positive",
+		"questions[1].rubric.criteria":                          "are worth 3 points, more than the question's 0",
+		"questions[1].rubric.partial_credit[0].points":          "must be between 0 and the question's 0",
+		"questions[1].rubric.partial_credit[0].dependencies[0]": `"c9" is not a criterion of this rubric`,
+		"questions[2].answer_type":                              "must be one of short_answer, essay, mcq or diagram",
+	}, paths)
+
+	// A misspelt field isn't silently dropped
+	_, err = svc.ImportDefinition(context.Background(), tenantID, "json", []byte(`{"title": "T", "questions": [{"text": "Q", "points": 1, "answer_type": "essay", "answer": "42"}]}`))
+	assert.ErrorIs(t, err, service.ErrInvalidExamDefinition)
+	_, err = svc.ImportDefinition(context.Background(), tenantID, "csv", nil)
+	assert.ErrorIs(t, err, service.ErrUnsupportedFormat)
+}
+
+func TestExamService_ExportDefinition(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID := uuid.New(), uuid.New()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "subject", "tenant_id"}).AddRow(examID, "Biology: Midterm", "Science", tenantID))
+	mock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "question_number", "question_text", "points", "answer_type", "answer_key", "choices",
+			"rubric__id", "rubric__question_id", "rubric__full_credit_criteria", "rubric__partial_credit_rules", "rubric__common_mistakes", "rubric__strict_mode"}).
+			AddRow(uuid.New(), examID, "10", "Last", 1, "short_answer", "42", nil, nil, nil, nil, nil, nil, nil).
+			AddRow(uuid.New(), examID, "2", "Pick", 1, "mcq", "B", `[{"id":"A","text":"x"},{"id":"B","text":"y"}]`,
+				uuid.New(), uuid.New(), `[{"ID":"c1","Description":"Picks B","Points":1,"Required":true,"Category":""}]`, `[]`, `[]`, true))
+	mock.ExpectCommit()
+
+	svc := service.NewExamService(postgres.NewExamRepo(bun.NewDB(db, pgdialect.New())), nil, nil)
+	export, err := svc.ExportDefinition(auth.WithTenantID(context.Background(), tenantID), examID, "json")
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, "application/json", export.ContentType)
+	assert.Equal(t, "biology_midterm_exam.json", export.Filename)
+
+	var doc domain.ExamDocument
+	require.NoError(t, json.Unmarshal(export.Data, &doc))
+	assert.Equal(t, domain.ExamDocumentFormat, doc.Format)
+	assert.Equal(t, "Science", doc.Subject)
+	require.Len(t, doc.Questions, 2)
+	assert.Equal(t, "2", doc.Questions[0].Number, "questions in the order of their numbers")
+	assert.Equal(t, []domain.Choice{{ID: "A", Text: "x"}, {ID: "B", Text: "y"}}, doc.Questions[0].Choices)
+	require.NotNil(t, doc.Questions[0].Rubric)
+	assert.True(t, doc.Questions[0].Rubric.StrictMode)
+	assert.Equal(t, []domain.CriterionDocument{{ID: "c1", Description: "Picks B", Points: 1, Required: true}}, doc.Questions[0].Rubric.Criteria)
+	assert.Nil(t, doc.Questions[1].Rubric)
+	assert.NotContains(t, string(export.Data), examID.String(), "a document has no database IDs")
+}
+
+func TestExamHandler_ImportDefinition_Unprocessable(t *testing.T) {
+	h := handlers.NewExamHandler(service.NewExamService(nil, nil, nil))
+	r := chi.NewRouter()
+	r.Post("/exams/import", h.ImportDefinition)
+
+	req := httptest.NewRequest(http.MethodPost, "/exams/import", strings.NewReader("title: Quiz\nquestions: []\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	req = req.WithContext(auth.WithTenantID(req.Context(), uuid.New()))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	var body struct {
+		Errors []domain.FieldError `json:"errors"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, []domain.FieldError{{Path: "questions", Message: "an exam needs at least one question"}}, body.Errors)
+
+	req = httptest.NewRequest(http.MethodPost, "/exams/import?format=qti", strings.NewReader("not a package"))
+	req = req.WithContext(auth.WithTenantID(req.Context(), uuid.New()))
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -24,7 +24,7 @@ func TestExamService_CreateExam(t *testing.T) {
 	bunDB := bun.NewDB(db, pgdialect.New())
 	examRepo := postgres.NewExamRepo(bunDB)
 	auditRepo := postgres.NewAuditRepo(bunDB)
-	examService := service.NewExamService(examRepo, auditRepo)
+	examService := service.NewExamService(examRepo, auditRepo, nil)
 
 	ctx := context.Background()
 	exam := &domain.Exam{
@@ -34,16 +34,18 @@ func TestExamService_CreateExam(t *testing.T) {
 		TenantID: uuid.New(),
 	}
 
-	// 1. Expectation for ExamRepo.Create (Insert into exams)
+	// 1. Expectation for ExamRepo.Create (Insert into exams), in a
+	// transaction scoped to the exam's tenant
+	expectTenantTx(mock, exam.TenantID)
 	mock.ExpectQuery(`INSERT INTO "exams" .*`).
 		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
 
-	// 2. Expectation for AuditRepo.Save (GetLastHash then Insert into audit_log)
-	mock.ExpectQuery(`SELECT .* FROM "audit_log" .*`).
-		WillReturnRows(sqlmock.NewRows([]string{"hash"}))
-	
+	// 2. Expectation for AuditRepo.Save in the same transaction (lock the
+	// chain, read its head, then Insert into audit_log)
+	expectAuditAppend(mock, exam.TenantID)
 	mock.ExpectQuery(`INSERT INTO "audit_log" .*`).
 		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	mock.ExpectCommit()
 
 	// Execute
 	err = examService.CreateExam(ctx, exam)
@@ -61,7 +63,7 @@ func TestExamService_ListExams(t *testing.T) {
 	bunDB := bun.NewDB(db, pgdialect.New())
 	examRepo := postgres.NewExamRepo(bunDB)
 	// AuditRepo not needed for List
-	examService := service.NewExamService(examRepo, nil)
+	examService := service.NewExamService(examRepo, nil, nil)
 
 	ctx := context.Background()
 	tenantID := uuid.New()
@@ -72,6 +74,10 @@ func TestExamService_ListExams(t *testing.T) {
 			AddRow(uuid.New(), "Exam 1").
 			AddRow(uuid.New(), "Exam 2"))
 
+	// Expectation: the Questions relation of both exams
+	mock.ExpectQuery(`SELECT .* FROM "questions" AS "q" WHERE \("q"."exam_id" IN .*\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id"}))
+
 	// Execute
 	exams, err := examService.ListExams(ctx, tenantID)
 
@@ -0,0 +1,259 @@
+package unit_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"harama/internal/api/handlers"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// expectExamTotals expects an exam of two questions worth 10 points in all
+// to be loaded with the totals of four submissions, the last of which is
+// graded on one question only.
+func expectExamTotals(mock sqlmock.Sqlmock, tenantID, examID uuid.UUID, subs []uuid.UUID) {
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "tenant_id"}).AddRow(examID, "Biology Midterm", tenantID))
+	mock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "points", "question_number"}).
+			AddRow(uuid.New(), examID, 6, "1").
+			AddRow(uuid.New(), examID, 4, "2"))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT g.submission_id, s.student_id, SUM\(g.score\) AS total, COUNT\(\*\) AS graded FROM grades AS g JOIN submissions AS s .* GROUP BY "g"."submission_id", "s"."student_id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"submission_id", "student_id", "total", "graded"}).
+			AddRow(subs[0], "S1", 4.0, 2).
+			AddRow(subs[1], "S2", 6.0, 2).
+			AddRow(subs[2], "S3", 9.0, 2).
+			AddRow(subs[3], "S4", 3.0, 1))
+	mock.ExpectCommit()
+}
+
+func TestCurveService_Preview(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   domain.CurvePolicy
+		adjusted []float64
+	}{
+		{
+			name:     "linear scale to a target mean, capped",
+			policy:   domain.CurvePolicy{Method: domain.CurveLinear, TargetMean: 80, CapAtMax: true},
+			adjusted: []float64{5.05, 7.58, 10},
+		},
+		{
+			name:     "square root",
+			policy:   domain.CurvePolicy{Method: domain.CurveSqrt},
+			adjusted: []float64{6.32, 7.75, 9.49},
+		},
+		{
+			name:     "flat bonus, uncapped",
+			policy:   domain.CurvePolicy{Method: domain.CurveFlat, Bonus: 2},
+			adjusted: []float64{6, 8, 11},
+		},
+		{
+			name:     "flat penalty stops at zero",
+			policy:   domain.CurvePolicy{Method: domain.CurveFlat, Bonus: -5},
+			adjusted: []float64{0, 1, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tenantID, examID := uuid.New(), uuid.New()
+			subs := []uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+			expectExamTotals(dbMock, tenantID, examID, subs)
+
+			bunDB := bun.NewDB(db, pgdialect.New())
+			svc := service.NewCurveService(postgres.NewGradeRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewCurveRepo(bunDB), nil, nil, nil)
+			preview, err := svc.Preview(auth.WithTenantID(context.Background(), tenantID), examID, tt.policy, 5)
+			require.NoError(t, err)
+			assert.NoError(t, dbMock.ExpectationsWereMet())
+
+			assert.Equal(t, 10.0, preview.MaxTotal)
+			assert.Equal(t, 1, preview.Incomplete, "a submission not graded on every question is left out")
+			require.Len(t, preview.Scores, 3)
+			var adjusted []float64
+			for _, score := range preview.Scores {
+				adjusted = append(adjusted, score.AdjustedTotal)
+			}
+			assert.Equal(t, tt.adjusted, adjusted)
+			assert.Equal(t, 9.0, preview.Scores[2].RawTotal, "raw totals are kept")
+			assert.Equal(t, 3, preview.Before.Count)
+			assert.Equal(t, 3, preview.After.Count)
+		})
+	}
+}
+
+func TestCurveService_InvalidPolicy(t *testing.T) {
+	svc := service.NewCurveService(nil, nil, nil, nil, nil, nil)
+	ctx := context.Background()
+	for _, policy := range []domain.CurvePolicy{
+		{Method: "bell"},
+		{Method: domain.CurveLinear},
+		{Method: domain.CurveLinear, TargetMean: 120},
+		{Method: domain.CurveFlat},
+	} {
+		_, err := svc.Preview(ctx, uuid.New(), policy, 10)
+		assert.ErrorIs(t, err, service.ErrInvalidCurvePolicy, "%+v", policy)
+		_, err = svc.Apply(ctx, uuid.New(), policy, "")
+		assert.ErrorIs(t, err, service.ErrInvalidCurvePolicy, "%+v", policy)
+	}
+}
+
+func TestCurveService_Distribution(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID := uuid.New(), uuid.New()
+	subs := []uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+	expectExamTotals(dbMock, tenantID, examID, subs)
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT g.question_id, COUNT\(\*\) AS responses, .*percentile_cont\(0.5\) WITHIN GROUP \(ORDER BY g.score\) AS "p50".* GROUP BY "g"."question_id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"question_id", "responses", "mean"}))
+	dbMock.ExpectQuery(`SELECT g.question_id, LEAST\(GREATEST\(width_bucket\(g.score, 0, q.points, 5\), 1\), 5\) AS bin, .* GROUP BY g.question_id, bin`).
+		WillReturnRows(sqlmock.NewRows([]string{"question_id", "bin", "count"}))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "grade_curves" AS "gc" WHERE \(gc.exam_id = '` + examID.String() + `' AND gc.active\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "version", "policy", "max_total", "scores", "active"}).
+			AddRow(uuid.New(), examID, 2, `{"method":"flat","bonus":1,"cap_at_max":true}`, 10.0,
+				`[{"submission_id":"`+subs[0].String()+`","raw_total":4,"adjusted_total":5},{"submission_id":"`+subs[1].String()+`","raw_total":5,"adjusted_total":6}]`, true))
+	dbMock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewCurveService(postgres.NewGradeRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewCurveRepo(bunDB), nil, nil, nil)
+	dist, err := svc.Distribution(auth.WithTenantID(context.Background(), tenantID), examID, 5)
+	require.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+
+	assert.Equal(t, 3, dist.Totals.Count)
+	assert.InDelta(t, 19.0/3, dist.Totals.Mean, 1e-9)
+	assert.Equal(t, 6.0, dist.Totals.Percentiles["p50"])
+	assert.Equal(t, 7.5, dist.Totals.Percentiles["p75"])
+	var counts []int
+	for _, bin := range dist.Totals.Histogram {
+		counts = append(counts, bin.Count)
+	}
+	assert.Equal(t, []int{0, 0, 1, 1, 1}, counts)
+	assert.Equal(t, service.HistogramBin{From: 8, To: 10, Count: 1}, dist.Totals.Histogram[4])
+
+	require.NotNil(t, dist.Curve)
+	assert.Equal(t, 2, dist.Curve.Version)
+	assert.Equal(t, 5.5, dist.Curve.Adjusted.Mean)
+	// S2 was regraded and S3 graded since the curve was applied
+	assert.Contains(t, dist.Warnings, "2 submissions were graded or regraded since curve version 2 was applied; apply the curve again to adjust them")
+
+	require.Len(t, dist.Questions, 2)
+	assert.Equal(t, "Q1", dist.Questions[0].Label)
+	assert.Len(t, dist.Questions[0].Histogram, 5)
+	assert.Empty(t, dist.Questions[0].Percentiles, "a question without grades has no percentiles")
+}
+
+func TestCurveService_Apply(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, curveID := uuid.New(), uuid.New(), uuid.New()
+	subs := []uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+	expectExamTotals(dbMock, tenantID, examID, subs)
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtextextended('grade_curves:` + examID.String() + `', 0))`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectQuery(`UPDATE "grade_curves" AS "gc" SET active = FALSE, deactivated_at = .* WHERE \(gc.exam_id = '` + examID.String() + `' AND gc.active\) .*RETURNING gc.version`).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	dbMock.ExpectQuery(`SELECT COALESCE\(MAX\(gc.version\), 0\) \+ 1 FROM "grade_curves" AS "gc"`).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	dbMock.ExpectQuery(`INSERT INTO "grade_curves" .*'` + examID.String() + `', 2, '\{"method":"flat","bonus":2,"cap_at_max":true\}', 10, 6\.33, 8, .*"adjusted_total":10\}\]', TRUE, 'Marking was harsh'`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(curveID, time.Now()))
+	// The exam's totals are queued for the LMS again, as curved
+	dbMock.ExpectExec(`INSERT INTO lti_score_pushes \(.*, raw_score, curve_version, .*\) SELECT .*CASE WHEN abs\(cs.raw_total - SUM\(g.score\)\) < 0.005 THEN cs.adjusted_total ELSE SUM\(g.score\) END.* LEFT JOIN grade_curves AS gc ON gc.exam_id = s.exam_id AND gc.active .* WHERE \(s.exam_id = '` + examID.String() + `'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'exam', '` + examID.String() + `', 'curve_applied'.*"version":2`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewCurveService(postgres.NewGradeRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewCurveRepo(bunDB), postgres.NewAuditRepo(bunDB),
+		postgres.NewLTIRepo(bunDB), service.NewRosterService(postgres.NewRosterRepo(bunDB), nil, nil))
+	ctx := auth.WithActor(context.Background(), auth.Actor{UserID: uuid.New(), TenantID: tenantID, Role: domain.RoleAdmin})
+	curve, err := svc.Apply(ctx, examID, domain.CurvePolicy{Method: domain.CurveFlat, Bonus: 2, CapAtMax: true}, "Marking was harsh")
+	require.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	assert.Equal(t, curveID, curve.ID)
+	assert.Equal(t, 2, curve.Version)
+	assert.True(t, curve.Active)
+	assert.Equal(t, 6.33, curve.RawMean)
+	assert.Equal(t, 8.0, curve.AdjustedMean)
+
+	// Removing a curve when there is none is a not found
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`UPDATE "grade_curves" AS "gc" SET active = FALSE`).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	dbMock.ExpectRollback()
+	assert.ErrorIs(t, svc.RemoveCurve(ctx, examID), sql.ErrNoRows)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestCurveHandler_TeacherOfAnotherClass(t *testing.T) {
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/exams/%s/curves", `{"method":"flat","bonus":2}`},
+		{http.MethodDelete, "/exams/%s/curves/active", ""},
+	}
+	for _, req := range requests {
+		t.Run(req.method, func(t *testing.T) {
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tenantID, examID := uuid.New(), uuid.New()
+			expectTenantTx(dbMock, tenantID)
+			dbMock.ExpectQuery(`SELECT .* FROM exam_classes AS ec LEFT JOIN class_teachers AS ct .* WHERE \(ec.exam_id = '` + examID.String() + `'\) AND \(ec.tenant_id = '` + tenantID.String() + `'\)`).
+				WillReturnRows(sqlmock.NewRows([]string{"assigned", "teaches"}).AddRow(true, false))
+			dbMock.ExpectCommit()
+
+			bunDB := bun.NewDB(db, pgdialect.New())
+			h := handlers.NewCurveHandler(service.NewCurveService(postgres.NewGradeRepo(bunDB), postgres.NewExamRepo(bunDB),
+				postgres.NewCurveRepo(bunDB), postgres.NewAuditRepo(bunDB), nil, service.NewRosterService(postgres.NewRosterRepo(bunDB), nil, nil)))
+			r := chi.NewRouter()
+			r.Post("/exams/{id}/curves", h.ApplyCurve)
+			r.Delete("/exams/{id}/curves/active", h.RemoveCurve)
+
+			httpReq := httptest.NewRequest(req.method, fmt.Sprintf(req.path, examID), strings.NewReader(req.body))
+			httpReq = httpReq.WithContext(auth.WithActor(httpReq.Context(), auth.Actor{UserID: uuid.New(), TenantID: tenantID, Role: domain.RoleTeacher}))
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httpReq)
+
+			assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+			assert.NoError(t, dbMock.ExpectationsWereMet(), "nothing is curved")
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package unit_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"harama/internal/api/handlers"
+	"harama/internal/auth"
+	"harama/internal/pkg/xlsx"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// unzip reads the parts of a workbook.
+func unzip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		parts[f.Name] = string(b)
+	}
+	return parts
+}
+
+func TestXLSX_Write(t *testing.T) {
+	var buf bytes.Buffer
+	err := xlsx.Write(&buf, []xlsx.Sheet{
+		{Name: "Summary", Rows: [][]interface{}{
+			{"Student ID", "Score", "Passed"},
+			{"S<1>&", 7.5, true},
+			{"S2", nil, false},
+		}},
+		{Name: "Q1: a/b?", Rows: [][]interface{}{{"x"}}},
+		{Name: "summary"},
+	})
+	require.NoError(t, err)
+
+	parts := unzip(t, buf.Bytes())
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/styles.xml", "xl/worksheets/sheet3.xml"} {
+		assert.Contains(t, parts, name)
+	}
+	// Names are made valid and, ignoring case, distinct
+	assert.Contains(t, parts["xl/workbook.xml"], `<sheet name="Q1_ a_b_"`)
+	assert.Contains(t, parts["xl/workbook.xml"], `<sheet name="summary (2)"`)
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `state="frozen"`)
+	assert.Contains(t, sheet, `<c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">Student ID</t></is></c>`)
+	assert.Contains(t, sheet, `<t xml:space="preserve">S&lt;1&gt;&amp;</t>`)
+	assert.Contains(t, sheet, `<c r="B2"><v>7.5</v></c>`)
+	assert.Contains(t, sheet, `<c r="C2" t="b"><v>1</v></c>`)
+	assert.NotContains(t, sheet, `r="B3"`)
+
+	assert.Equal(t, "A1", xlsx.CellRef(0, 0))
+	assert.Equal(t, "Z10", xlsx.CellRef(25, 9))
+	assert.Equal(t, "AA2", xlsx.CellRef(26, 1))
+}
+
+// expectGradeExport expects the reads of an export of a one question exam
+// with two submissions: the first graded 8 and overridden, the second's
+// grades fail to load. A curve with the scores given, a JSON array, is
+// applied to the exam unless they are empty.
+func expectGradeExport(mock sqlmock.Sqlmock, tenantID, examID, questionID uuid.UUID, subs [2]uuid.UUID, curveScores string) {
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "tenant_id"}).AddRow(examID, "Biology: Midterm 2", tenantID))
+	mock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "question_number", "question_text", "points"}).
+			AddRow(questionID, examID, "1", "Define osmosis", 10))
+	mock.ExpectCommit()
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "submissions" AS "s"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "student_id", "processing_status"}).
+			AddRow(subs[0], examID, "S001", "completed").
+			AddRow(subs[1], examID, "S002", "completed"))
+	mock.ExpectCommit()
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM exam_classes AS ec`).
+		WillReturnRows(sqlmock.NewRows([]string{"external_id", "class_id", "class_name"}))
+	mock.ExpectCommit()
+
+	curves := sqlmock.NewRows([]string{"id", "exam_id", "version", "policy", "max_total", "scores", "active"})
+	if curveScores != "" {
+		curves.AddRow(uuid.New(), examID, 3, `{"method":"flat","bonus":1}`, 10.0, curveScores, true)
+	}
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "grade_curves" AS "gc" WHERE \(gc.exam_id = '` + examID.String() + `' AND gc.active\)`).
+		WillReturnRows(curves)
+	if curveScores != "" {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "grades" AS "g"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "submission_id", "question_id", "score", "max_score", "confidence", "status", "reasoning"}).
+			AddRow(uuid.New(), subs[0], questionID, 8.0, 10, 0.92, "overridden", "Mostly right"))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "feedback_events" AS "fe"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "question_id", "submission_id", "ai_score", "teacher_score", "teacher_reason", "timestamp"}).
+			AddRow(uuid.New(), questionID, subs[0], 6.0, 8.0, "Accept the diagram", time.Now()))
+	mock.ExpectCommit()
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "grades" AS "g"`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+}
+
+func newExportService(db *bun.DB) *service.AnalyticsService {
+	return service.NewAnalyticsService(postgres.NewGradeRepo(db), postgres.NewExamRepo(db), postgres.NewSubmissionRepo(db),
+		postgres.NewRosterRepo(db), postgres.NewFeedbackRepo(db), postgres.NewCurveRepo(db))
+}
+
+func TestAnalyticsService_ExportGrades_JSON(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, questionID := uuid.New(), uuid.New(), uuid.New()
+	subs := [2]uuid.UUID{uuid.New(), uuid.New()}
+	expectGradeExport(mock, tenantID, examID, questionID, subs,
+		`[{"submission_id":"`+subs[0].String()+`","raw_total":8,"adjusted_total":9}]`)
+
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+	export, err := newExportService(bun.NewDB(db, pgdialect.New())).ExportGrades(ctx, examID, service.GradeExportOptions{Format: "json"})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, "application/json", export.ContentType)
+	assert.Equal(t, "biology_midterm_2_grades.json", export.Filename)
+	require.Len(t, export.Warnings, 1)
+	assert.Equal(t, subs[1], export.Warnings[0].SubmissionID)
+	assert.Contains(t, export.Warnings[0].Message, "left out")
+
+	var body struct {
+		Submissions []service.ExportedSubmission `json:"submissions"`
+		Warnings    []service.ExportWarning      `json:"warnings"`
+	}
+	require.NoError(t, json.Unmarshal(export.Data, &body))
+	require.Len(t, body.Submissions, 1)
+	sub := body.Submissions[0]
+	assert.Equal(t, "S001", sub.StudentID)
+	assert.Equal(t, 8.0, sub.TotalScore)
+	assert.Equal(t, 80.0, sub.Percentage)
+	require.NotNil(t, sub.CurvedTotal)
+	assert.Equal(t, 9.0, *sub.CurvedTotal)
+	assert.Equal(t, 3, sub.CurveVersion)
+	require.Len(t, sub.Grades, 1)
+	assert.Equal(t, "Q1", sub.Grades[0].Question)
+	assert.Equal(t, 0.92, sub.Grades[0].Confidence)
+	require.NotNil(t, sub.Grades[0].Override)
+	assert.Equal(t, 6.0, sub.Grades[0].Override.AIScore)
+	assert.Equal(t, "Accept the diagram", sub.Grades[0].Override.Reason)
+	assert.Len(t, body.Warnings, 1)
+}
+
+func TestAnalyticsService_ExportGrades_XLSX(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, questionID := uuid.New(), uuid.New(), uuid.New()
+	subs := [2]uuid.UUID{uuid.New(), uuid.New()}
+	expectGradeExport(mock, tenantID, examID, questionID, subs, "")
+
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+	export, err := newExportService(bun.NewDB(db, pgdialect.New())).ExportGrades(ctx, examID, service.GradeExportOptions{
+		Format:  "xlsx",
+		Columns: []string{"student_id", "percentage", "questions"},
+	})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, xlsx.ContentType, export.ContentType)
+
+	parts := unzip(t, export.Data)
+	assert.Contains(t, parts["xl/workbook.xml"], `<sheet name="Summary"`)
+	assert.Contains(t, parts["xl/workbook.xml"], `<sheet name="Q1"`)
+	assert.Contains(t, parts["xl/workbook.xml"], `<sheet name="Warnings"`)
+
+	summary := parts["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, summary, ">Percentage<")
+	assert.Contains(t, summary, ">Q1<")
+	assert.NotContains(t, summary, "Submission ID")
+	assert.Contains(t, summary, `<c r="B2"><v>80</v></c>`)
+
+	question := parts["xl/worksheets/sheet2.xml"]
+	assert.Contains(t, question, ">Mostly right<")
+	assert.Contains(t, question, ">Accept the diagram<")
+	assert.Contains(t, parts["xl/worksheets/sheet3.xml"], "S002")
+}
+
+func TestAnalyticsService_ExportGrades_Curved(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, questionID := uuid.New(), uuid.New(), uuid.New()
+	subs := [2]uuid.UUID{uuid.New(), uuid.New()}
+	// S001 was regraded from 7 since the curve was applied
+	expectGradeExport(mock, tenantID, examID, questionID, subs,
+		`[{"submission_id":"`+subs[0].String()+`","raw_total":7,"adjusted_total":8.5}]`)
+
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+	export, err := newExportService(bun.NewDB(db, pgdialect.New())).ExportGrades(ctx, examID, service.GradeExportOptions{Format: "csv"})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	lines := strings.Split(strings.TrimSpace(string(export.Data)), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "Student ID,Submission ID,Total Score,Curved Total,Q1 (Max: 10)", lines[0])
+	assert.Equal(t, "S001,"+subs[0].String()+",8.00,N/A,8.00", lines[1])
+	require.Len(t, export.Warnings, 2)
+	assert.Equal(t, "not curved: graded or regraded since curve version 3 was applied", export.Warnings[0].Message)
+}
+
+func TestAnalyticsService_ExportGrades_StrictFailsOnWarnings(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, questionID := uuid.New(), uuid.New(), uuid.New()
+	expectGradeExport(mock, tenantID, examID, questionID, [2]uuid.UUID{uuid.New(), uuid.New()}, "")
+
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+	_, err = newExportService(bun.NewDB(db, pgdialect.New())).ExportGrades(ctx, examID, service.GradeExportOptions{Format: "csv", Strict: true})
+	assert.ErrorIs(t, err, service.ErrExportIncomplete)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAnalyticsHandler_ExportGrades_RejectsOptions(t *testing.T) {
+	h := handlers.NewAnalyticsHandler(service.NewAnalyticsService(nil, nil, nil, nil, nil, nil))
+	r := chi.NewRouter()
+	r.Post("/exams/{id}/export", h.ExportGrades)
+
+	for _, body := range []string{
+		`{"format":"pdf"}`,
+		`{"columns":["student_id","shoe_size"]}`,
+		`{"question_headers":"emoji"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/exams/"+uuid.NewString()+"/export", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
@@ -37,6 +37,16 @@ func (m *MockProvider) RefineRubric(ctx context.Context, req ai.RefineRubricRequ
 	return args.Get(0).(domain.Rubric), args.Error(1)
 }
 
+func (m *MockProvider) DraftRubric(ctx context.Context, req ai.DraftRubricRequest) (domain.Rubric, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(domain.Rubric), args.Error(1)
+}
+
+func (m *MockProvider) ExtractQuestions(ctx context.Context, req ai.ExtractQuestionsRequest) ([]ai.ExtractedQuestion, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).([]ai.ExtractedQuestion), args.Error(1)
+}
+
 func TestEngine_MultiEvaluatorGrade(t *testing.T) {
 	mockAI := new(MockProvider)
 	engine := grading.NewEngine(mockAI)
@@ -0,0 +1,107 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"harama/internal/ai"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/grading"
+	"harama/internal/progress"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// expectGradingRun expects a grading run to read the submission, its exam
+// and the grades it already has.
+func expectGradingRun(dbMock sqlmock.Sqlmock, tenantID, subID, examID, q1, q2 uuid.UUID, graded *sqlmock.Rows) {
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "submissions" AS "s"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "tenant_id", "answers"}).
+			AddRow(subID, examID, tenantID, `[{"question_id":"`+q1.String()+`","text":"first"},{"question_id":"`+q2.String()+`","text":"second"}]`))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "subject", "tenant_id"}).AddRow(examID, "Biology", tenantID))
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "question_text", "points",
+			"rubric__id", "rubric__question_id", "rubric__full_credit_criteria", "rubric__partial_credit_rules", "rubric__common_mistakes"}).
+			AddRow(q1, examID, "Define osmosis.", 2, uuid.New(), q1, `[]`, `[]`, `[]`).
+			AddRow(q2, examID, "Explain active transport.", 2, uuid.New(), q2, `[]`, `[]`, `[]`))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "grades" AS "g"`).WillReturnRows(graded)
+	dbMock.ExpectCommit()
+}
+
+// expectGradeSaved expects a grade and its audit entry to be written.
+func expectGradeSaved(dbMock sqlmock.Sqlmock, tenantID, questionID uuid.UUID) {
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "grades" .*'` + questionID.String() + `'`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'ai_graded'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectCommit()
+}
+
+func TestGradingService_ResumesWithoutRegrading(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, subID, examID, q1, q2 := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	answer := func(text string) interface{} {
+		return mock.MatchedBy(func(req ai.GradingRequest) bool { return req.Answer.Text == text })
+	}
+	result := domain.GradingResult{MaxScore: 2, Confidence: 0.9, Reasoning: "ok"}
+	mockAI := new(MockProvider)
+	mockAI.On("Grade", mock.Anything, answer("first")).Return(result, nil)
+	mockAI.On("Grade", mock.Anything, answer("second")).Return(domain.GradingResult{}, ai.ErrBudgetExceeded).Times(3)
+	mockAI.On("Grade", mock.Anything, answer("second")).Return(result, nil)
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewGradingService(postgres.NewGradeRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewSubmissionRepo(bunDB),
+		postgres.NewAuditRepo(bunDB), postgres.NewWebhookRepo(bunDB), nil, progress.NewBus(nil), grading.NewEngine(mockAI))
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+
+	// The first run grades the first answer, then runs out of budget
+	expectGradingRun(dbMock, tenantID, subID, examID, q1, q2, sqlmock.NewRows([]string{"id"}))
+	expectGradeSaved(dbMock, tenantID, q1)
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectExec(`UPDATE "submissions" AS "s" SET processing_status = 'paused'`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+	require.NoError(t, svc.GradeSubmission(ctx, subID))
+	require.NoError(t, dbMock.ExpectationsWereMet())
+
+	// The resumed run grades only the second
+	expectGradingRun(dbMock, tenantID, subID, examID, q1, q2, sqlmock.NewRows([]string{"id", "submission_id", "question_id", "score", "max_score"}).
+		AddRow(uuid.New(), subID, q1, 0.0, 2))
+	expectGradeSaved(dbMock, tenantID, q2)
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectExec(`UPDATE "submissions" AS "s" SET processing_status = 'completed'`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectQuery(`SELECT .* FROM "grades" AS "g"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "submission_id", "question_id", "score", "max_score"}).
+			AddRow(uuid.New(), subID, q1, 0.0, 2).
+			AddRow(uuid.New(), subID, q2, 0.0, 2))
+	dbMock.ExpectQuery(`INSERT INTO "webhook_events" .*'submission.graded'.*"questions_graded":2`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	dbMock.ExpectCommit()
+	require.NoError(t, svc.GradeSubmission(ctx, subID))
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+
+	// Three evaluators per answer: the first answer once, the second twice
+	mockAI.AssertNumberOfCalls(t, "Grade", 9)
+}
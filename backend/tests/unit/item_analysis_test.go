@@ -0,0 +1,83 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+
+	"harama/internal/auth"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestAnalyticsService_ItemAnalysis(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID := uuid.New(), uuid.New()
+	q1, q2, q10 := uuid.New(), uuid.New(), uuid.New()
+
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "tenant_id"}).AddRow(examID, "Biology Midterm", tenantID))
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "question_text", "points", "question_number",
+			"rubric__id", "rubric__question_id", "rubric__full_credit_criteria", "rubric__partial_credit_rules", "rubric__common_mistakes"}).
+			AddRow(q10, examID, "Design an experiment.", 10, "10", nil, nil, nil, nil, nil).
+			AddRow(q1, examID, "Define osmosis.", 2, "1", uuid.New(), q1,
+				`[{"ID":"c1","Description":"Defines osmosis","Points":1},{"ID":"c2","Description":"Gives an example","Points":1}]`, `[]`, `[]`).
+			AddRow(q2, examID, "Explain active transport.", 5, "2", nil, nil, nil, nil, nil))
+	dbMock.ExpectCommit()
+
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`WITH "scored" AS \(SELECT g.submission_id, SUM\(g.score\) AS total, .* FROM grades AS g JOIN questions AS q .* GROUP BY "g"."submission_id"\) SELECT COUNT\(\*\) FILTER \(WHERE complete\) AS submissions`).
+		WillReturnRows(sqlmock.NewRows([]string{"submissions", "incomplete", "mean_total", "total_variance"}).AddRow(30, 2, 9.5, 9.0))
+	dbMock.ExpectQuery(`WITH "scored" AS .*, "ranked" AS \(SELECT .* ROW_NUMBER\(\) OVER .* WHERE \(complete\)\) SELECT g.question_id, .*CORR\(g.score::float8, \(rk.total - g.score\)::float8\) AS point_biserial.* GROUP BY "g"."question_id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"question_id", "responses", "mean_score", "facility", "score_variance",
+			"upper_facility", "lower_facility", "point_biserial", "zero_scores", "full_marks"}).
+			AddRow(q1, 30, 1.9, 0.95, 1.0, 1.0, 0.9, 0.3, 0, 27).
+			AddRow(q2, 30, 0.5, 0.1, 2.0, 0.2, 0.0, 0.4, 22, 0).
+			AddRow(q10, 30, 7.1, 0.71, 1.5, 0.6, 0.8, -0.1, 1, 4))
+	dbMock.ExpectQuery(`WITH "scored" AS .* CROSS JOIN LATERAL jsonb_array_elements_text\(g.criteria_met\) .* GROUP BY "g"."question_id", "met"."criterion_id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"question_id", "criterion_id", "hits"}).
+			AddRow(q1, "c1", 27).
+			AddRow(q1, "c0", 3))
+	dbMock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewAnalyticsService(postgres.NewGradeRepo(bunDB), postgres.NewExamRepo(bunDB), nil, nil, nil, nil)
+	report, err := svc.ItemAnalysis(auth.WithTenantID(context.Background(), tenantID), examID)
+	require.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+
+	assert.Equal(t, 30, report.Submissions)
+	assert.Equal(t, 2, report.Incomplete)
+	assert.Equal(t, 17, report.MaxTotal)
+	// 3/2 * (1 - (1 + 2 + 1.5) / 9)
+	require.NotNil(t, report.CronbachAlpha)
+	assert.InDelta(t, 0.75, *report.CronbachAlpha, 1e-9)
+	assert.Len(t, report.Warnings, 1, "incomplete submissions are reported")
+
+	require.Len(t, report.Items, 3)
+	assert.Equal(t, []string{"Q1", "Q2", "Q10"}, []string{report.Items[0].Label, report.Items[1].Label, report.Items[2].Label})
+
+	easy := report.Items[0]
+	assert.InDelta(t, 0.1, *easy.Discrimination, 1e-9)
+	assert.Equal(t, []string{service.FlagTooEasy}, easy.Flags)
+	require.Len(t, easy.Criteria, 3)
+	assert.Equal(t, service.CriterionHitRate{ID: "c1", Description: "Defines osmosis", Hits: 27, HitRate: 0.9}, easy.Criteria[0])
+	assert.Equal(t, "c2", easy.Criteria[1].ID)
+	assert.Zero(t, easy.Criteria[1].Hits, "a criterion never met is listed")
+	assert.Equal(t, "c0", easy.Criteria[2].ID, "criteria outside the rubric come last")
+
+	assert.Equal(t, []string{service.FlagTooHard}, report.Items[1].Flags)
+	assert.Equal(t, []string{service.FlagNegativeDiscrimination}, report.Items[2].Flags)
+	assert.InDelta(t, -0.2, *report.Items[2].Discrimination, 1e-9)
+}
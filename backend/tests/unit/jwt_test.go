@@ -0,0 +1,275 @@
+package unit_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "harama-api"
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// signToken builds a compact JWS. key is an HMAC secret ([]byte), an RSA or
+// an ECDSA private key.
+func signToken(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func validClaims(sub uuid.UUID) map[string]any {
+	return map[string]any{
+		"sub": sub.String(),
+		"iss": testIssuer,
+		"aud": []string{"other", testAudience},
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+}
+
+// keyServer serves a JWKS whose keys can be swapped to simulate rotation.
+type keyServer struct {
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches atomic.Int32
+	srv     *httptest.Server
+}
+
+func newKeyServer() *keyServer {
+	ks := &keyServer{}
+	ks.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ks.fetches.Add(1)
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"keys": ks.keys})
+	}))
+	return ks
+}
+
+func (ks *keyServer) set(keys ...map[string]string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+}
+
+func rsaJWK(kid string, k *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+}
+
+func ecJWK(kid string, k *ecdsa.PrivateKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	k.X.FillBytes(x)
+	k.Y.FillBytes(y)
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(x), "y": b64(y)}
+}
+
+func TestVerifier_Algorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	secret := []byte("shared-secret")
+
+	ks := newKeyServer()
+	defer ks.srv.Close()
+	ks.set(rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+
+	v, err := auth.NewVerifier(auth.VerifierConfig{
+		Secret:   secret,
+		JWKS:     auth.NewJWKS(ks.srv.URL, time.Hour),
+		Issuer:   testIssuer,
+		Audience: testAudience,
+	})
+	require.NoError(t, err)
+
+	sub := uuid.New()
+	for name, token := range map[string]string{
+		"HS256": signToken(t, "HS256", "", secret, validClaims(sub)),
+		"RS256": signToken(t, "RS256", "rsa-1", rsaKey, validClaims(sub)),
+		"ES256": signToken(t, "ES256", "ec-1", ecKey, validClaims(sub)),
+	} {
+		id, err := v.Verify(context.Background(), token)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, sub, id.UserID, name)
+		}
+	}
+	// Keys were fetched once and then served from the cache
+	assert.Equal(t, int32(1), ks.fetches.Load())
+}
+
+func TestVerifier_Rejects(t *testing.T) {
+	secret := []byte("shared-secret")
+	v, err := auth.NewVerifier(auth.VerifierConfig{Secret: secret, Issuer: testIssuer, Audience: testAudience})
+	require.NoError(t, err)
+	sub := uuid.New()
+
+	with := func(key string, value any) map[string]any {
+		c := validClaims(sub)
+		if value == nil {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+	unsigned := func() string {
+		header, _ := json.Marshal(map[string]string{"alg": "none"})
+		payload, _ := json.Marshal(validClaims(sub))
+		return b64(header) + "." + b64(payload) + "."
+	}
+
+	cases := map[string]struct {
+		token string
+		want  error
+	}{
+		"expired":         {signToken(t, "HS256", "", secret, with("exp", time.Now().Add(-time.Hour).Unix())), auth.ErrTokenExpired},
+		"no exp":          {signToken(t, "HS256", "", secret, with("exp", nil)), auth.ErrInvalidToken},
+		"not yet valid":   {signToken(t, "HS256", "", secret, with("nbf", time.Now().Add(time.Hour).Unix())), auth.ErrInvalidToken},
+		"wrong issuer":    {signToken(t, "HS256", "", secret, with("iss", "https://evil.example.com")), auth.ErrInvalidToken},
+		"wrong audience":  {signToken(t, "HS256", "", secret, with("aud", "someone-else")), auth.ErrInvalidToken},
+		"wrong secret":    {signToken(t, "HS256", "", []byte("guess"), validClaims(sub)), auth.ErrInvalidToken},
+		"alg none":        {unsigned(), auth.ErrInvalidToken},
+		"no jwks for RS":  {signToken(t, "RS256", "k", []byte("x"), validClaims(sub)), auth.ErrInvalidToken},
+		"sub not a uuid":  {signToken(t, "HS256", "", secret, with("sub", "alice")), auth.ErrInvalidToken},
+		"malformed token": {"not-a-token", auth.ErrInvalidToken},
+	}
+	for name, tc := range cases {
+		_, err := v.Verify(context.Background(), tc.token)
+		assert.True(t, errors.Is(err, tc.want), "%s: got %v", name, err)
+	}
+}
+
+func TestVerifier_KeyRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ks := newKeyServer()
+	defer ks.srv.Close()
+	ks.set(ecJWK("2025", oldKey))
+
+	v, err := auth.NewVerifier(auth.VerifierConfig{JWKS: auth.NewJWKS(ks.srv.URL, time.Hour)})
+	require.NoError(t, err)
+	sub := uuid.New()
+
+	_, err = v.Verify(context.Background(), signToken(t, "ES256", "2025", oldKey, validClaims(sub)))
+	require.NoError(t, err)
+
+	// The issuer rotates; a token signed with the new key is not in the
+	// cache, but unknown kids are only refetched after a short back-off
+	ks.set(ecJWK("2025", oldKey), rsaJWK("2026", newKey))
+	_, err = v.Verify(context.Background(), signToken(t, "RS256", "2026", newKey, validClaims(sub)))
+	assert.True(t, errors.Is(err, auth.ErrInvalidToken))
+	assert.Equal(t, int32(1), ks.fetches.Load())
+
+	// A key of the wrong type under a known kid is refused
+	_, err = v.Verify(context.Background(), signToken(t, "RS256", "2025", newKey, validClaims(sub)))
+	assert.True(t, errors.Is(err, auth.ErrInvalidToken))
+
+	// Once the cache has expired the new key is picked up
+	expiring := auth.NewJWKS(ks.srv.URL, time.Nanosecond)
+	v, err = auth.NewVerifier(auth.VerifierConfig{JWKS: expiring})
+	require.NoError(t, err)
+	_, err = v.Verify(context.Background(), signToken(t, "RS256", "2026", newKey, validClaims(sub)))
+	assert.NoError(t, err)
+}
+
+func TestJWKS_FailedFetchIsThrottled(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	jwks := auth.NewJWKS(srv.URL, time.Hour)
+	for i := 0; i < 5; i++ {
+		_, err := jwks.Key(context.Background(), "2026")
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, auth.ErrUnknownKey), "the fetch failure is reported")
+	}
+	// Lookups after a failure don't each go back to the issuer
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestVerifier_ClaimMapping(t *testing.T) {
+	secret := []byte("shared-secret")
+	v, err := auth.NewVerifier(auth.VerifierConfig{
+		Secret: secret,
+		Claims: auth.ClaimMapping{
+			User:      "sub",
+			Tenant:    "app_metadata.tenant_id",
+			Role:      "app_metadata.roles",
+			StudentID: "app_metadata.student_number",
+		},
+	})
+	require.NoError(t, err)
+
+	sub, tenant := uuid.New(), uuid.New()
+	claims := validClaims(sub)
+	claims["app_metadata"] = map[string]any{
+		"tenant_id":      tenant.String(),
+		"roles":          []string{"superuser", "student"},
+		"student_number": "S001",
+	}
+
+	id, err := v.Verify(context.Background(), signToken(t, "HS256", "", secret, claims))
+	require.NoError(t, err)
+	assert.Equal(t, tenant, id.TenantID)
+	assert.Equal(t, domain.RoleStudent, id.Role)
+	assert.Equal(t, "S001", id.StudentID)
+
+	// Without the claims the identity has only a user
+	id, err = v.Verify(context.Background(), signToken(t, "HS256", "", secret, validClaims(sub)))
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Nil, id.TenantID)
+	assert.Empty(t, id.Role)
+}
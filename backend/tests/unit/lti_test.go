@@ -0,0 +1,257 @@
+package unit_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/lti"
+	"harama/internal/lti/ltitest"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+const (
+	roleInstructor = "http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor"
+	roleLearner    = "http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"
+)
+
+// newLTITool serves a tool's key set and starts a fake platform that trusts
+// it. Both are closed when the test ends.
+func newLTITool(t *testing.T) (*lti.Tool, *ltitest.Platform) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	var tool *lti.Tool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tool.KeySet())
+	}))
+	t.Cleanup(srv.Close)
+	tool = lti.NewTool(srv.URL, key, 5*time.Second)
+
+	platform := ltitest.NewPlatform(srv.URL + "/lti/jwks.json")
+	t.Cleanup(platform.Close)
+	return tool, platform
+}
+
+func platformRows(p domain.LTIPlatform) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "tenant_id", "name", "issuer", "client_id", "deployment_id", "auth_login_url", "auth_token_url", "jwks_url"}).
+		AddRow(p.ID, p.TenantID, p.Name, p.Issuer, p.ClientID, p.DeploymentID, p.AuthLoginURL, p.AuthTokenURL, p.JWKSURL)
+}
+
+func TestLTITool_LaunchIsVerifiedAgainstLogin(t *testing.T) {
+	tool, platform := newLTITool(t)
+	ctx := context.Background()
+	reg := platform.Registration(uuid.New())
+	examID := uuid.NewString()
+
+	claims := platform.LaunchClaims("", "link-1", roleInstructor)
+	claims[lti.ClaimCustom] = map[string]any{"exam_id": examID}
+	platform.SetLaunch(claims)
+
+	redirect := tool.AuthRedirect(&reg, lti.LoginParams{Issuer: reg.Issuer, ClientID: ltitest.ClientID, LoginHint: "lms-teacher-1"}, "state-1", "nonce-1")
+	action, form, err := platform.Authorize(ctx, redirect)
+	require.NoError(t, err)
+	assert.Equal(t, tool.LaunchURL(), action)
+	assert.Equal(t, "state-1", form.Get("state"))
+
+	launch, err := tool.VerifyLaunch(ctx, &reg, form.Get("id_token"), "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "lms-teacher-1", launch.UserID)
+	assert.Equal(t, "link-1", launch.ResourceLinkID)
+	assert.Equal(t, examID, launch.Custom["exam_id"])
+	assert.Equal(t, platform.LineItemsURL(), launch.LineItemsURL)
+	assert.True(t, launch.Instructor())
+
+	// A token minted for another login, or by another platform, is refused
+	_, err = tool.VerifyLaunch(ctx, &reg, form.Get("id_token"), "nonce-2")
+	assert.ErrorIs(t, err, lti.ErrInvalidLaunch)
+	other := ltitest.NewPlatform("")
+	defer other.Close()
+	forged := reg
+	forged.JWKSURL = other.URL + "/jwks"
+	_, err = tool.VerifyLaunch(ctx, &reg, other.IDToken("nonce-1", platform.LaunchClaims("lms-teacher-1", "link-1", roleInstructor)), "nonce-1")
+	assert.ErrorIs(t, err, lti.ErrInvalidLaunch)
+	_, err = tool.VerifyLaunch(ctx, &forged, form.Get("id_token"), "nonce-1")
+	assert.ErrorIs(t, err, lti.ErrInvalidLaunch)
+}
+
+func TestLTITool_PublishesScoresToLineItems(t *testing.T) {
+	tool, platform := newLTITool(t)
+	ctx := context.Background()
+	reg := platform.Registration(uuid.New())
+
+	item, err := tool.CreateLineItem(ctx, &reg, platform.LineItemsURL(), lti.LineItem{Label: "Midterm", ScoreMaximum: 20, ResourceLinkID: "link-1"})
+	require.NoError(t, err)
+	assert.Equal(t, platform.LineItemURL("2"), item.ID)
+	created, ok := platform.LineItem("2")
+	require.True(t, ok)
+	assert.Equal(t, "Midterm", created.Label)
+
+	score := lti.Score{UserID: "lms-student-1", ScoreGiven: 17.5, ScoreMaximum: 20, ActivityProgress: "Completed", GradingProgress: "FullyGraded", Timestamp: time.Now().UTC()}
+	platform.FailScores(1)
+	status, err := tool.PublishScore(ctx, &reg, item.ID, score)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	var statusErr *lti.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Contains(t, statusErr.Body, "gradebook unavailable")
+
+	status, err = tool.PublishScore(ctx, &reg, item.ID, score)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	got := platform.Scores("2")
+	require.Len(t, got, 1)
+	assert.Equal(t, "lms-student-1", got[0].UserID)
+	assert.Equal(t, 17.5, got[0].ScoreGiven)
+	assert.Equal(t, "FullyGraded", got[0].GradingProgress)
+}
+
+func TestLTIService_LearnerLaunchMapsStudentAndQueuesScores(t *testing.T) {
+	tool, platform := newLTITool(t)
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	reg := platform.Registration(tenantID)
+	linkID := uuid.New()
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewLTIService(postgres.NewLTIRepo(bunDB), nil, postgres.NewAuditRepo(bunDB), tool)
+
+	mock.ExpectQuery(`SELECT .* FROM "lti_platforms" AS "lp" WHERE \(lp.issuer = '` + regexp.QuoteMeta(reg.Issuer) + `'\) AND \(lp.client_id = 'harama-tool'\)`).
+		WillReturnRows(platformRows(reg))
+	mock.ExpectExec(`INSERT INTO "lti_launch_states"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	redirect, err := svc.Login(context.Background(), lti.LoginParams{Issuer: reg.Issuer, ClientID: ltitest.ClientID, LoginHint: "lms-student-1"})
+	require.NoError(t, err)
+	loginQuery, err := url.Parse(redirect)
+	require.NoError(t, err)
+	state, nonce := loginQuery.Query().Get("state"), loginQuery.Query().Get("nonce")
+
+	claims := platform.LaunchClaims("", "link-1", roleLearner)
+	claims[lti.ClaimCustom] = map[string]any{"student_id": "S-100"}
+	platform.SetLaunch(claims)
+	_, form, err := platform.Authorize(context.Background(), redirect)
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`DELETE FROM "lti_launch_states" AS "lls" WHERE \(state = '` + state + `'\) AND \(expires_at > .*\) RETURNING \*`).
+		WillReturnRows(sqlmock.NewRows([]string{"state", "nonce", "platform_id", "expires_at"}).AddRow(state, nonce, reg.ID, time.Now().Add(time.Minute)))
+	mock.ExpectQuery(`SELECT .* FROM "lti_platforms" AS "lp" WHERE \(lp.id = '` + reg.ID.String() + `'\)`).
+		WillReturnRows(platformRows(reg))
+	expectTenantTx(mock, tenantID)
+	// A relaunch keeps the mapping's ID
+	mock.ExpectQuery(`INSERT INTO "lti_user_links" .*'lms-student-1', 'S-100'.* ON CONFLICT \(platform_id, lms_user_id\) DO UPDATE SET student_id = EXCLUDED.student_id RETURNING id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(linkID))
+	expectAuditAppend(mock, tenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log" .*'lti_user_link', '` + linkID.String() + `', 'linked', DEFAULT, 'lti'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	mock.ExpectExec(`INSERT INTO lti_score_pushes .* SELECT .* WHERE \(ul.id = '` + linkID.String() + `'\) AND \(s.processing_status = 'completed'\) AND \(s.tenant_id = '` + tenantID.String() + `'\) GROUP BY .* HAVING \(bool_and\(g.status IN \('auto_graded', 'overridden', 'final'\)\)\)`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	launch, err := svc.Launch(context.Background(), form.Get("id_token"), form.Get("state"))
+	require.NoError(t, err)
+	assert.False(t, launch.Instructor)
+	assert.Equal(t, tenantID, launch.TenantID)
+	require.NotNil(t, launch.UserLink)
+	assert.Equal(t, linkID, launch.UserLink.ID)
+	assert.Equal(t, "S-100", launch.UserLink.StudentID)
+	assert.Equal(t, 2, launch.Queued)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// The state answers one launch only
+	mock.ExpectQuery(`DELETE FROM "lti_launch_states"`).WillReturnRows(sqlmock.NewRows([]string{"state"}))
+	_, err = svc.Launch(context.Background(), form.Get("id_token"), form.Get("state"))
+	assert.ErrorIs(t, err, lti.ErrInvalidLaunch)
+}
+
+// expectScorePush expects a worker to claim one due push and load the line
+// item and platform it goes to.
+func expectScorePush(mock sqlmock.Sqlmock, push domain.LTIScorePush, li domain.LTILineItem, reg domain.LTIPlatform) {
+	mock.ExpectQuery(`UPDATE "lti_score_pushes" AS "lsp" SET next_attempt_at = .* WHERE \(id IN \(SELECT .* FOR UPDATE SKIP LOCKED\)\) RETURNING \*`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "line_item_id", "submission_id", "lms_user_id", "score_given", "score_maximum", "status", "attempts", "created_at"}).
+			AddRow(push.ID, push.TenantID, push.LineItemID, push.SubmissionID, push.LMSUserID, push.ScoreGiven, push.ScoreMaximum, push.Status, push.Attempts, push.CreatedAt))
+	expectTenantTx(mock, push.TenantID)
+	mock.ExpectQuery(`SELECT .* FROM "lti_line_items" AS "lli" WHERE \(lli.id = '` + li.ID.String() + `'\) AND \(lli.tenant_id = '` + push.TenantID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "platform_id", "exam_id", "resource_link_id", "line_item_url"}).
+			AddRow(li.ID, li.TenantID, li.PlatformID, li.ExamID, li.ResourceLinkID, li.LineItemURL))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT .* FROM "lti_platforms" AS "lp" WHERE \(lp.id = '` + reg.ID.String() + `'\)`).
+		WillReturnRows(platformRows(reg))
+}
+
+func TestLTIService_PushDueRetriesThenAuditsPublishedScore(t *testing.T) {
+	tool, platform := newLTITool(t)
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	reg := platform.Registration(tenantID)
+	li := domain.LTILineItem{ID: uuid.New(), TenantID: tenantID, PlatformID: reg.ID, ExamID: uuid.New(), ResourceLinkID: "link-1", LineItemURL: platform.LineItemURL("1")}
+	push := domain.LTIScorePush{ID: uuid.New(), TenantID: tenantID, LineItemID: li.ID, SubmissionID: uuid.New(), LMSUserID: "lms-student-1", ScoreGiven: 42, ScoreMaximum: 50, Status: domain.ScorePushPending, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewLTIService(postgres.NewLTIRepo(bunDB), nil, postgres.NewAuditRepo(bunDB), tool)
+
+	// The gradebook is down: the push stays pending with the answer kept,
+	// and nothing is audited until it settles
+	platform.FailScores(1)
+	expectScorePush(mock, push, li, reg)
+	mock.ExpectExec(`UPDATE "lti_score_pushes" AS "lsp" SET "status" = 'pending', "attempts" = 1, "next_attempt_at" = '.*', .* "response_status" = 503, "last_error" = 'platform returned 503 Service Unavailable: gradebook unavailable', "pushed_at" = NULL WHERE \("lsp"."id" = '` + push.ID.String() + `'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	pushed, err := svc.PushDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, pushed)
+	assert.Empty(t, platform.Scores("1"))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	push.Attempts = 1
+	expectScorePush(mock, push, li, reg)
+	expectTenantTx(mock, tenantID)
+	mock.ExpectExec(`UPDATE "lti_score_pushes" AS "lsp" SET "status" = 'pushed', "attempts" = 2, "next_attempt_at" = NULL, .* "response_status" = 200, "last_error" = NULL, "pushed_at" = '.*' WHERE \("lsp"."id" = '` + push.ID.String() + `'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditAppend(mock, tenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log" .*'lti_score', '` + push.ID.String() + `', 'pushed', DEFAULT, 'worker'.*"score_given":42`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	mock.ExpectCommit()
+	pushed, err = svc.PushDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, pushed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	scores := platform.Scores("1")
+	require.Len(t, scores, 1)
+	assert.Equal(t, "lms-student-1", scores[0].UserID)
+	assert.Equal(t, 42.0, scores[0].ScoreGiven)
+	assert.Equal(t, 50.0, scores[0].ScoreMaximum)
+	// Timestamped when queued, so a late retry can't overwrite a newer score
+	assert.True(t, push.CreatedAt.Equal(scores[0].Timestamp))
+}
+
+func TestLTIService_RegisterPlatformValidates(t *testing.T) {
+	svc := service.NewLTIService(nil, nil, nil, nil)
+	ctx := auth.WithTenantID(context.Background(), uuid.New())
+
+	err := svc.RegisterPlatform(ctx, &domain.LTIPlatform{Name: "LMS", Issuer: "https://lms.example.com"})
+	assert.ErrorContains(t, err, "client_id are required")
+	err = svc.RegisterPlatform(ctx, &domain.LTIPlatform{Name: "LMS", Issuer: "https://lms.example.com", ClientID: "c", AuthLoginURL: "https://lms.example.com/auth", AuthTokenURL: "/token", JWKSURL: "https://lms.example.com/jwks"})
+	assert.ErrorContains(t, err, "auth_token_url must be an absolute http or https URL")
+
+	_, err = svc.Login(ctx, lti.LoginParams{Issuer: "https://lms.example.com"})
+	assert.ErrorIs(t, err, lti.ErrInvalidLaunch)
+}
@@ -0,0 +1,283 @@
+package unit_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"harama/internal/api/handlers"
+	"harama/internal/api/middleware"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/progress"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// fakeRelay stands in for LISTEN/NOTIFY: whatever one bus sends, every
+// listening bus receives.
+type fakeRelay struct {
+	mu        sync.Mutex
+	listeners []func([]byte)
+	ready     chan struct{}
+}
+
+func newFakeRelay(listeners int) *fakeRelay {
+	return &fakeRelay{ready: make(chan struct{}, listeners)}
+}
+
+func (f *fakeRelay) Send(_ context.Context, payload []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, receive := range f.listeners {
+		receive(payload)
+	}
+	return nil
+}
+
+func (f *fakeRelay) Listen(ctx context.Context, receive func([]byte)) error {
+	f.mu.Lock()
+	f.listeners = append(f.listeners, receive)
+	f.mu.Unlock()
+	f.ready <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// nextEvent waits briefly for an event on ch.
+func nextEvent(t *testing.T, ch <-chan progress.Event) progress.Event {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no progress event")
+		return progress.Event{}
+	}
+}
+
+func assertNoEvent(t *testing.T, ch <-chan progress.Event) {
+	t.Helper()
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected progress event %+v", e)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestProgressBus_SubscribersSeeOnlyTheirTenantsSubject(t *testing.T) {
+	bus := progress.NewBus(nil)
+	tenantA, tenantB := uuid.New(), uuid.New()
+	sub := &domain.Submission{ID: uuid.New(), ExamID: uuid.New(), TenantID: tenantA}
+
+	events, cancel := bus.Subscribe(progress.ForSubmission(tenantA, sub.ID))
+	examEvents, cancelExam := bus.Subscribe(progress.ForExam(tenantA, sub.ExamID))
+	defer cancelExam()
+
+	// Another tenant's submission with the same ID must not leak through
+	other := *sub
+	other.TenantID = tenantB
+	bus.Publish(context.Background(), progress.NewEvent(&other, progress.StageUploaded))
+	assertNoEvent(t, events)
+
+	page := progress.NewEvent(sub, progress.StageOCR)
+	page.Step, page.Total = 1, 3
+	bus.Publish(context.Background(), page)
+	got := nextEvent(t, events)
+	assert.Equal(t, progress.StageOCR, got.Stage)
+	assert.Equal(t, 3, got.Total)
+	assert.False(t, got.At.IsZero())
+	assert.Equal(t, progress.StageOCR, nextEvent(t, examEvents).Stage)
+
+	cancel()
+	bus.Publish(context.Background(), progress.NewEvent(sub, progress.StageSegmented))
+	assertNoEvent(t, events)
+	assert.Equal(t, progress.StageSegmented, nextEvent(t, examEvents).Stage)
+}
+
+func TestProgressBus_TruncatesErrorsOnRuneBoundary(t *testing.T) {
+	bus := progress.NewBus(nil)
+	sub := &domain.Submission{ID: uuid.New(), ExamID: uuid.New(), TenantID: uuid.New()}
+	events, cancel := bus.Subscribe(progress.ForSubmission(sub.TenantID, sub.ID))
+	defer cancel()
+
+	// The limit falls inside a two-byte rune
+	failed := progress.NewEvent(sub, progress.StageFailed)
+	failed.Error = "x" + strings.Repeat("é", 600)
+	bus.Publish(context.Background(), failed)
+
+	got := nextEvent(t, events)
+	assert.True(t, utf8.ValidString(got.Error))
+	assert.Len(t, got.Error, 999)
+	assert.True(t, strings.HasPrefix(failed.Error, got.Error))
+}
+
+func TestProgressBus_RelayCarriesEventsBetweenProcesses(t *testing.T) {
+	relay := newFakeRelay(2)
+	worker, api := progress.NewBus(relay), progress.NewBus(relay)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go worker.Run(ctx)
+	go api.Run(ctx)
+	<-relay.ready
+	<-relay.ready
+
+	tenantID, batchID := uuid.New(), uuid.New()
+	events, cancel := api.Subscribe(progress.ForBatch(tenantID, batchID))
+	defer cancel()
+
+	// The batch comes from the job's context, not the event
+	sub := &domain.Submission{ID: uuid.New(), ExamID: uuid.New(), TenantID: tenantID}
+	worker.Publish(progress.WithBatch(ctx, batchID), progress.NewEvent(sub, progress.StageCompleted))
+	worker.Publish(ctx, progress.NewEvent(sub, progress.StageGrading))
+
+	got := nextEvent(t, events)
+	assert.Equal(t, progress.StageCompleted, got.Stage)
+	assert.Equal(t, sub.ID, got.SubmissionID)
+	require.NotNil(t, got.BatchID)
+	assert.Equal(t, batchID, *got.BatchID)
+	assertNoEvent(t, events)
+}
+
+func TestGradingService_PublishesFailureDetails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	tenantID := uuid.New()
+	sub := &domain.Submission{ID: uuid.New(), ExamID: uuid.New(), TenantID: tenantID}
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "submissions" AS "s"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "tenant_id", "processing_status"}).
+			AddRow(sub.ID, sub.ExamID, tenantID, domain.StatusCompleted))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	bus := progress.NewBus(nil)
+	events, cancel := bus.Subscribe(progress.ForSubmission(tenantID, sub.ID))
+	defer cancel()
+
+	svc := service.NewGradingService(postgres.NewGradeRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewSubmissionRepo(bunDB), nil, nil, nil, bus, nil)
+	err = svc.GradeSubmission(auth.WithTenantID(context.Background(), tenantID), sub.ID)
+	require.ErrorContains(t, err, "connection reset")
+
+	got := nextEvent(t, events)
+	assert.Equal(t, progress.StageFailed, got.Stage)
+	assert.Equal(t, sub.ExamID, got.ExamID)
+	assert.Contains(t, got.Error, "connection reset")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// readSSE returns the next event name and data from a stream, skipping
+// heartbeats.
+func readSSE(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && event != "":
+			return event, data
+		}
+	}
+}
+
+func TestProgressHandler_StreamsSubmissionEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	sub := &domain.Submission{ID: uuid.New(), ExamID: uuid.New(), TenantID: tenantID}
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "submissions" AS "s" WHERE .* AND \(s.tenant_id = '` + tenantID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "tenant_id", "processing_status"}).
+			AddRow(sub.ID, sub.ExamID, tenantID, domain.StatusProcessing))
+	mock.ExpectCommit()
+
+	bus := progress.NewBus(nil)
+	ocr := service.NewOCRService(postgres.NewSubmissionRepo(bun.NewDB(db, pgdialect.New())), nil, nil, nil, bus, nil, nil)
+	h := handlers.NewProgressHandler(bus, ocr, nil)
+	r := chi.NewRouter()
+	r.Use(middleware.TenantMiddleware)
+	r.Get("/submissions/{id}/events", h.SubmissionEvents)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/submissions/"+sub.ID.String()+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Tenant-ID", tenantID.String())
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	body := bufio.NewReader(resp.Body)
+	event, data := readSSE(t, body)
+	assert.Equal(t, "status", event)
+	assert.Contains(t, data, `"processing_status":"processing"`)
+
+	question := uuid.New()
+	grading := progress.NewEvent(sub, progress.StageGrading)
+	grading.Step, grading.Total, grading.QuestionID = 2, 5, &question
+	bus.Publish(context.Background(), grading)
+
+	event, data = readSSE(t, body)
+	assert.Equal(t, "grading", event)
+	var got progress.Event
+	require.NoError(t, json.Unmarshal([]byte(data), &got))
+	assert.Equal(t, 2, got.Step)
+	assert.Equal(t, 5, got.Total)
+	assert.Equal(t, &question, got.QuestionID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProgressHandler_UnknownSubmissionIsNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "submissions" AS "s"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	bus := progress.NewBus(nil)
+	ocr := service.NewOCRService(postgres.NewSubmissionRepo(bun.NewDB(db, pgdialect.New())), nil, nil, nil, bus, nil, nil)
+	r := chi.NewRouter()
+	r.Get("/submissions/{id}/events", handlers.NewProgressHandler(bus, ocr, nil).SubmissionEvents)
+	req := httptest.NewRequest(http.MethodGet, "/submissions/"+uuid.NewString()+"/events", nil)
+	req = req.WithContext(auth.WithTenantID(req.Context(), tenantID))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,261 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestBankService_CreateItem(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewBankService(postgres.NewBankRepo(bunDB), nil, postgres.NewAuditRepo(bunDB), nil)
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+
+	// An item is checked the way an exam's question is, and so are its tags
+	err = svc.CreateItem(ctx, &domain.BankItem{
+		TenantID:     tenantID,
+		QuestionText: " ",
+		Points:       2,
+		AnswerType:   domain.AnswerTypeMCQ,
+		Choices:      []domain.Choice{{ID: "A", Text: "Ribosome"}},
+		AnswerKey:    "B",
+		Difficulty:   "brutal",
+	})
+	var itemErr *service.BankItemError
+	require.ErrorAs(t, err, &itemErr)
+	var paths []string
+	for _, e := range itemErr.Errors {
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []string{"question_text", "choices", "answer_key", "difficulty"}, paths)
+
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "bank_items" .*'Explain osmosis\.', 4, 'essay'.*"description":"Defines osmosis".*'Transport', .*'medium', 'Year 10'`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'bank_item', '[0-9a-f-]+', 'created'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectCommit()
+
+	item := &domain.BankItem{
+		TenantID:     tenantID,
+		QuestionText: " Explain osmosis. ",
+		Points:       4,
+		AnswerType:   domain.AnswerTypeEssay,
+		Rubric:       &domain.RubricDocument{Criteria: []domain.CriterionDocument{{Description: "Defines osmosis", Points: 4}}},
+		Topic:        "Transport",
+		Difficulty:   domain.DifficultyMedium,
+		GradeLevel:   " Year 10",
+	}
+	require.NoError(t, svc.CreateItem(ctx, item))
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	assert.NotEqual(t, uuid.Nil, item.ID)
+	assert.Equal(t, "Explain osmosis.", item.QuestionText)
+}
+
+func TestBankService_AddToExam(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, itemID := uuid.New(), uuid.New(), uuid.New()
+	expectLookups := func() {
+		expectTenantTx(dbMock, tenantID)
+		dbMock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "tenant_id"}).AddRow(examID, "Biology", tenantID))
+		dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "question_number"}).AddRow(uuid.New(), examID, "1"))
+		dbMock.ExpectCommit()
+		expectTenantTx(dbMock, tenantID)
+		dbMock.ExpectQuery(`SELECT .* FROM "bank_items" AS "bi" WHERE \(bi.id IN \('` + itemID.String() + `', '[0-9a-f-]+'\)\)`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "question_text", "points", "answer_type", "rubric"}).
+				AddRow(itemID, tenantID, "Explain osmosis.", 4, "essay", `{"criteria":[{"id":"c1","description":"Defines osmosis","points":4}]}`))
+		dbMock.ExpectCommit()
+	}
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewBankService(postgres.NewBankRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), nil)
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+
+	_, err = svc.AddToExam(ctx, examID, "link", nil)
+	assert.ErrorIs(t, err, service.ErrInvalidBankUse)
+
+	// Every placement that can't be made is reported, and nothing is added
+	expectLookups()
+	_, err = svc.AddToExam(ctx, examID, service.BankUseReference, []service.BankPlacement{
+		{BankItemID: itemID, Number: "1"},
+		{BankItemID: uuid.New(), Number: "2"},
+	})
+	var defErr *service.ExamDefinitionError
+	require.ErrorAs(t, err, &defErr)
+	assert.Equal(t, []domain.FieldError{
+		{Path: "items[0].number", Message: `"1" is the number of a question already in the exam`},
+		{Path: "items[1].bank_item_id", Message: "is not in the question bank"},
+	}, defErr.Errors)
+
+	expectLookups()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT EXISTS \(SELECT .* FROM "exams"`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	dbMock.ExpectExec(`INSERT INTO "questions" .*'Explain osmosis\.', 4, 'essay', '2', 'Osmosis'.*'` + itemID.String() + `', TRUE\), .*'3', ''.*'` + itemID.String() + `', TRUE\)`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	dbMock.ExpectQuery(`INSERT INTO "rubrics" .*"Description":"Defines osmosis".*"Description":"Defines osmosis"`).
+		WillReturnRows(sqlmock.NewRows([]string{"strict_mode"}).AddRow(false).AddRow(false))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'exam', '` + examID.String() + `', 'bank_items_added'.*"use":"reference"`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectCommit()
+
+	// One item may be placed twice, as 2 and again as 3
+	questions, err := svc.AddToExam(ctx, examID, service.BankUseReference, []service.BankPlacement{
+		{BankItemID: itemID, Number: "2", Group: "Osmosis"},
+		{BankItemID: itemID, Number: "3"},
+	})
+	require.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	require.Len(t, questions, 2)
+	assert.Equal(t, itemID, *questions[0].BankItemID)
+	assert.True(t, questions[0].BankLinked)
+	assert.Equal(t, questions[0].ID, questions[0].Rubric.QuestionID)
+	assert.NotSame(t, questions[0].Rubric, questions[1].Rubric, "each question has its own copy of the rubric")
+}
+
+func TestBankService_UpdateItem(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, itemID, linkedID := uuid.New(), uuid.New(), uuid.New()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "bank_items" AS "bi"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "question_text", "points", "answer_type", "topic"}).
+			AddRow(itemID, tenantID, "Explain osmosis.", 4, "essay", "Transport"))
+	dbMock.ExpectCommit()
+
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectExec(`UPDATE "bank_items" AS "bi" SET "question_text" = 'Explain osmosis with an example\.', "points" = 5`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Graded questions are detached before the rest are brought up to date
+	dbMock.ExpectExec(`UPDATE "questions" AS "q" SET bank_linked = FALSE WHERE .*q.bank_item_id = '` + itemID.String() + `' AND q.bank_linked.*EXISTS \(SELECT 1 FROM grades`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectQuery(`UPDATE "questions" AS "q" SET "question_text" = 'Explain osmosis with an example\.', "points" = 5, .*q.bank_linked.* RETURNING q.id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(linkedID))
+	dbMock.ExpectQuery(`INSERT INTO "rubrics" .*'` + linkedID.String() + `'.*"Description":"Gives an example".*ON CONFLICT \(question_id\) DO UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"strict_mode"}).AddRow(false))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'bank_item', '` + itemID.String() + `', 'updated'.*"detached_questions":1`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectQuery(`INSERT INTO "webhook_events" .*'rubric.updated'.*"source":"bank"`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(nil))
+	dbMock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewBankService(postgres.NewBankRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), postgres.NewWebhookRepo(bunDB))
+	update, err := svc.UpdateItem(auth.WithTenantID(context.Background(), tenantID), itemID, &domain.BankItem{
+		QuestionText: "Explain osmosis with an example.",
+		Points:       5,
+		AnswerType:   domain.AnswerTypeEssay,
+		Rubric: &domain.RubricDocument{Criteria: []domain.CriterionDocument{
+			{Description: "Defines osmosis", Points: 3},
+			{Description: "Gives an example", Points: 2},
+		}},
+		Topic: "Transport",
+	})
+	require.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	assert.Equal(t, []uuid.UUID{linkedID}, update.Updated)
+	assert.Equal(t, 1, update.Detached)
+	assert.Equal(t, tenantID, update.Item.TenantID)
+}
+
+func TestBankService_SearchItems(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, usedID, newID := uuid.New(), uuid.New(), uuid.New()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "bank_items" AS "bi" WHERE .*@@ plainto_tsquery\('simple', 'osmosis'\).*lower\(bi.topic\) = lower\('Transport'\).*bi.difficulty = 'hard'.*ORDER BY ts_rank.* DESC, "bi"."created_at" DESC LIMIT 200`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "question_text"}).
+			AddRow(usedID, tenantID, "Explain osmosis.").
+			AddRow(newID, tenantID, "Define osmosis."))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT q.bank_item_id, .* FROM questions AS q JOIN exams AS e .*LEFT JOIN grades AS g .*WHERE \(q.bank_item_id IN \('` + usedID.String() + `', '` + newID.String() + `'\)\) AND \(e.tenant_id = '` + tenantID.String() + `'\) GROUP BY "q"."bank_item_id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"bank_item_id", "uses", "exams", "graded", "facility", "full_marks", "zero_scores"}).
+			AddRow(usedID, 3, 2, 40, 0.62, 9, 4))
+	dbMock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewBankService(postgres.NewBankRepo(bunDB), nil, nil, nil)
+	items, err := svc.SearchItems(auth.WithTenantID(context.Background(), tenantID), postgres.BankFilter{
+		Query:      " osmosis ",
+		Topic:      "Transport",
+		Difficulty: domain.DifficultyHard,
+		Limit:      1000,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	require.Len(t, items, 2)
+	assert.Equal(t, 3, items[0].Stats.Uses)
+	assert.Equal(t, 0.62, *items[0].Stats.Facility)
+	assert.Equal(t, 40, items[0].Stats.Graded)
+	assert.Zero(t, items[1].Stats.Uses, "an item never used has empty statistics")
+	assert.Nil(t, items[1].Stats.Facility)
+}
+
+func TestExamService_CloneExam(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, cloneID, questionID, itemID := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "subject", "tenant_id"}).AddRow(examID, "Biology Midterm", "Biology", tenantID))
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "question_text", "points", "answer_type", "question_number", "bank_item_id", "bank_linked",
+			"rubric__id", "rubric__question_id", "rubric__full_credit_criteria", "rubric__partial_credit_rules", "rubric__common_mistakes"}).
+			AddRow(questionID, examID, "Explain osmosis.", 4, "essay", "1", itemID, true,
+				uuid.New(), questionID, `[{"ID":"c1","Description":"Defines osmosis","Points":4}]`, `[]`, `[]`))
+	dbMock.ExpectCommit()
+
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "exams" .*'Biology Midterm \(copy\)', 'Biology'`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(cloneID, time.Now()))
+	dbMock.ExpectExec(`INSERT INTO "questions" .*'` + cloneID.String() + `', 'Explain osmosis\.', 4, 'essay', '1'.*'` + itemID.String() + `', TRUE\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectQuery(`INSERT INTO "rubrics" .*"Description":"Defines osmosis"`).
+		WillReturnRows(sqlmock.NewRows([]string{"strict_mode"}).AddRow(false))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'exam', '` + cloneID.String() + `', 'cloned'.*"source_id":"` + examID.String() + `"`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewExamService(postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), nil)
+	clone, err := svc.CloneExam(auth.WithTenantID(context.Background(), tenantID), examID, "")
+	require.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	assert.Equal(t, cloneID, clone.ID)
+	require.Len(t, clone.Questions, 1)
+	assert.NotEqual(t, questionID, clone.Questions[0].ID)
+	assert.Equal(t, clone.Questions[0].ID, clone.Questions[0].Rubric.QuestionID)
+}
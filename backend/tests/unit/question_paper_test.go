@@ -0,0 +1,187 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+
+	"harama/internal/ai"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/segmentation"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// pageOCR transcribes each file as the text it was given for it.
+type pageOCR map[string]string
+
+func (p pageOCR) ExtractText(_ context.Context, fileBytes []byte, _ string) (*domain.OCRResult, error) {
+	return &domain.OCRResult{RawText: p[string(fileBytes[:8])]}, nil
+}
+
+func TestSplitAnswers(t *testing.T) {
+	q1, q2a, q2b, q3 := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	anchors := []segmentation.Anchor{
+		{QuestionID: q1, Number: "1"},
+		{QuestionID: q2a, Number: "2a"},
+		{QuestionID: q2b, Number: "2(b)"},
+		{QuestionID: q3, Number: "Q3"},
+		{QuestionID: uuid.New(), Number: "4"},
+	}
+	corrected := "(b) Osmosis is the movement of water\nacross a membrane.\n3. 3 apples and\n1. pear"
+	answers := segmentation.SplitAnswers([]domain.OCRResult{
+		{PageNumber: 1, RawText: "Name: Ada\nQ1. Mitochondria\n2 (a) The nucleus\ncontrols the cell"},
+		{PageNumber: 2, RawText: "ignored", CorrectedText: &corrected},
+	}, anchors)
+
+	require.Len(t, answers, 4, "question 4 wasn't answered")
+	assert.Equal(t, q1, answers[0].QuestionID)
+	assert.Equal(t, "Mitochondria", answers[0].Text, "text before the first number is dropped")
+	assert.Equal(t, q2a, answers[1].QuestionID)
+	assert.Equal(t, "The nucleus\ncontrols the cell", answers[1].Text)
+	assert.Equal(t, []int{1}, answers[1].PageIndices)
+	assert.Equal(t, q2b, answers[2].QuestionID, "a sub-part on its own follows the last number")
+	assert.Equal(t, "Osmosis is the movement of water\nacross a membrane.", answers[2].Text)
+	assert.Equal(t, []int{2}, answers[2].PageIndices)
+	assert.Equal(t, q3, answers[3].QuestionID)
+	assert.Equal(t, "3 apples and\n1. pear", answers[3].Text, "neither a bare number nor an answered one starts an answer")
+
+	assert.Empty(t, segmentation.SplitAnswers([]domain.OCRResult{{RawText: "1. x"}}, []segmentation.Anchor{{QuestionID: q1}}))
+}
+
+func TestQuestionPaperService_Process(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, paperID := uuid.New(), uuid.New(), uuid.New()
+	store := newMemoryStore()
+	photo := scanPNG(t)
+	store.files["page_1.png"] = photo
+	store.files["page_2.jpg"] = []byte("JPEGDATA")
+	ocr := pageOCR{string(photo[:8]): "1. Label the cell. [3]", "JPEGDATA": "2. Explain osmosis. (5 marks)"}
+
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "question_papers" AS "qp"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "exam_id", "status", "pages"}).
+			AddRow(paperID, tenantID, examID, "pending",
+				`[{"object_name":"page_1.png","content_type":"image/png"},{"object_name":"page_2.jpg","content_type":"image/jpeg"}]`))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "subject", "tenant_id"}).AddRow(examID, "Biology", tenantID))
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectExec(`UPDATE "question_papers" AS "qp" SET "status" = 'processing'`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectExec(`UPDATE "question_papers" AS "qp" SET "status" = 'ready', "draft" = .*Label the cell.*memory://question-papers/`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'question_paper', '` + paperID.String() + `', 'extracted'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectCommit()
+
+	provider := new(MockProvider)
+	provider.On("ExtractQuestions", mock.Anything, ai.ExtractQuestionsRequest{
+		Pages:   []string{"1. Label the cell. [3]", "2. Explain osmosis. (5 marks)"},
+		Subject: "Biology",
+	}).Return([]ai.ExtractedQuestion{
+		{Number: "1", Text: "Label the cell.", Points: 3, AnswerType: domain.AnswerTypeDiagram, Page: 1, HasFigure: true},
+		{Number: "", Text: "  "},
+		{Number: "2", Text: "Explain osmosis.", Points: 5, AnswerType: "long_answer", Page: 2, HasFigure: true,
+			Choices: []domain.Choice{{ID: "A", Text: "stray"}}},
+	}, nil)
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewQuestionPaperService(postgres.NewQuestionPaperRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB),
+		store, ocr, provider, segmentation.NewDiagramDetector())
+	require.NoError(t, svc.Process(auth.WithTenantID(context.Background(), tenantID), paperID))
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	provider.AssertExpectations(t)
+
+	figure := "question-papers/" + tenantID.String() + "/" + paperID.String() + "/figure_1_1.png"
+	assert.Contains(t, store.files, figure, "the figure on the photo is cut out")
+	assert.Len(t, store.files, 3, "a photo that can't be decoded has no figures cut out")
+}
+
+func TestQuestionPaperService_Confirm(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, paperID := uuid.New(), uuid.New(), uuid.New()
+	paperRow := func(status string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "tenant_id", "exam_id", "status", "pages", "draft"}).
+			AddRow(paperID, tenantID, examID, status, `[]`,
+				`[{"question_number":"1","question_text":"Label the cell.","points":3,"answer_type":"diagram","visual_aids":["memory://fig.png"]},
+				  {"question_number":"2","question_text":"Pick one.","points":1,"answer_type":"mcq","answer_key":"B","choices":[{"id":"A","text":"x"},{"id":"B","text":"y"}]}]`)
+	}
+	expectExam := func(existing string) {
+		expectTenantTx(dbMock, tenantID)
+		dbMock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "tenant_id"}).AddRow(examID, "Biology", tenantID))
+		rows := sqlmock.NewRows([]string{"id", "exam_id", "question_number"})
+		if existing != "" {
+			rows.AddRow(uuid.New(), examID, existing)
+		}
+		dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).WillReturnRows(rows)
+		dbMock.ExpectCommit()
+	}
+
+	// A number already in the exam would confuse finding answers
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "question_papers" AS "qp"`).WillReturnRows(paperRow("ready"))
+	dbMock.ExpectCommit()
+	expectExam("2")
+
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "question_papers" AS "qp"`).WillReturnRows(paperRow("ready"))
+	dbMock.ExpectCommit()
+	expectExam("")
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT EXISTS \(SELECT .* FROM "exams"`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	dbMock.ExpectQuery(`INSERT INTO "questions" .*'Label the cell\.', 3, 'diagram', '1'.*'\["memory://fig.png"\]'.*'Pick one\.'`).
+		WillReturnRows(sqlmock.NewRows([]string{"bank_item_id"}).AddRow(nil).AddRow(nil))
+	dbMock.ExpectExec(`UPDATE "question_papers" AS "qp" SET "status" = 'confirmed'`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'question_paper', '` + paperID.String() + `', 'confirmed'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectCommit()
+
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "question_papers" AS "qp"`).WillReturnRows(paperRow("confirmed"))
+	dbMock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewQuestionPaperService(postgres.NewQuestionPaperRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), nil, nil, nil, nil)
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+
+	_, err = svc.Confirm(ctx, paperID)
+	var defErr *service.ExamDefinitionError
+	require.ErrorAs(t, err, &defErr)
+	assert.Equal(t, []domain.FieldError{{Path: "questions[1].number", Message: `"2" is the number of a question already in the exam`}}, defErr.Errors)
+
+	questions, err := svc.Confirm(ctx, paperID)
+	require.NoError(t, err)
+	require.Len(t, questions, 2)
+	assert.Equal(t, examID, questions[0].ExamID)
+	assert.NotEqual(t, uuid.Nil, questions[0].ID)
+
+	_, err = svc.Confirm(ctx, paperID)
+	assert.ErrorIs(t, err, service.ErrQuestionPaperNotReady, "a draft is confirmed once")
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,172 @@
+package unit_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"harama/internal/api/middleware"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestRolePermissions(t *testing.T) {
+	cases := []struct {
+		role domain.Role
+		perm auth.Permission
+		want bool
+	}{
+		{domain.RoleAdmin, auth.PermRubricAdapt, true},
+		{domain.RoleTeacher, auth.PermRubricAdapt, false},
+		{domain.RoleTeacher, auth.PermGradeOverride, true},
+		{domain.RoleReviewer, auth.PermEscalationResolve, true},
+		{domain.RoleTeacher, auth.PermEscalationResolve, false},
+		{domain.RoleReviewer, auth.PermGradeOverride, false},
+		{domain.RoleStudent, auth.PermFeedbackRead, true},
+		{domain.RoleStudent, auth.PermExamRead, false},
+		{domain.Role("janitor"), auth.PermExamRead, false},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, auth.Can(tc.role, tc.perm), "%s %s", tc.role, tc.perm)
+	}
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+func TestRequirePermission(t *testing.T) {
+	h := middleware.TenantMiddleware(middleware.RequirePermission(auth.PermRubricAdapt)(http.HandlerFunc(okHandler)))
+	tenantID := uuid.NewString()
+
+	for role, want := range map[string]int{
+		"":         http.StatusOK, // development default is admin
+		"admin":    http.StatusOK,
+		"teacher":  http.StatusForbidden,
+		"reviewer": http.StatusForbidden,
+		"nobody":   http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("X-Tenant-ID", tenantID)
+		if role != "" {
+			req.Header.Set("X-User-Role", role)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code, "role %q", role)
+	}
+
+	// No actor at all
+	w := httptest.NewRecorder()
+	middleware.RequirePermission(auth.PermExamRead)(http.HandlerFunc(okHandler)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+type fakeDirectory map[uuid.UUID]*domain.User
+
+func (d fakeDirectory) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	if u, ok := d[id]; ok {
+		return u, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func TestAuthMiddleware_ResolvesTenantAndRole(t *testing.T) {
+	secret := []byte("shared-secret")
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{
+		Secret: secret,
+		Claims: auth.ClaimMapping{Tenant: "tenant_id", Role: "role"},
+	})
+	require.NoError(t, err)
+
+	member := &domain.User{ID: uuid.New(), TenantID: uuid.New(), Role: domain.RoleReviewer}
+
+	var got auth.Actor
+	h := middleware.AuthMiddleware(verifier, fakeDirectory{member.ID: member}, nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = auth.GetActor(r.Context())
+			tenantID, _ := auth.GetTenantID(r.Context())
+			assert.Equal(t, got.TenantID, tenantID)
+		}))
+
+	do := func(header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	bearer := func(claims map[string]any) string {
+		return "Bearer " + signToken(t, "HS256", "", secret, claims)
+	}
+
+	// Membership comes from the user directory...
+	assert.Equal(t, http.StatusOK, do("Authorization", bearer(validClaims(member.ID))))
+	assert.Equal(t, auth.Actor{UserID: member.ID, TenantID: member.TenantID, Role: domain.RoleReviewer}, got)
+
+	// ...unless the token carries it
+	tenant := uuid.New()
+	claims := validClaims(uuid.New())
+	claims["tenant_id"], claims["role"] = tenant.String(), "teacher"
+	assert.Equal(t, http.StatusOK, do("Authorization", bearer(claims)))
+	assert.Equal(t, tenant, got.TenantID)
+	assert.Equal(t, domain.RoleTeacher, got.Role)
+
+	assert.Equal(t, http.StatusForbidden, do("Authorization", bearer(validClaims(uuid.New()))))
+	assert.Equal(t, http.StatusUnauthorized, do("Authorization", "Bearer forged.token.value"))
+	// The tenant header is not trusted once token verification is configured
+	assert.Equal(t, http.StatusUnauthorized, do("X-Tenant-ID", member.TenantID.String()))
+}
+
+func TestRosterService_AuthorizeGradeChange(t *testing.T) {
+	cases := []struct {
+		name     string
+		role     domain.Role
+		assigned bool
+		teaches  bool
+		query    bool
+		wantErr  bool
+	}{
+		{"admin", domain.RoleAdmin, false, false, false, false},
+		{"teacher of the class", domain.RoleTeacher, true, true, true, false},
+		{"teacher of another class", domain.RoleTeacher, true, false, true, true},
+		{"teacher, exam without classes", domain.RoleTeacher, false, false, true, false},
+		{"reviewer", domain.RoleReviewer, false, false, false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			rosterService := service.NewRosterService(postgres.NewRosterRepo(bun.NewDB(db, pgdialect.New())), nil, nil)
+			tenantID := uuid.New()
+			if tc.query {
+				expectTenantTx(mock, tenantID)
+				mock.ExpectQuery(`SELECT .* FROM submissions AS s .*class_teachers.* AND \(s.tenant_id = '` + tenantID.String() + `'\)`).
+					WillReturnRows(sqlmock.NewRows([]string{"assigned", "teaches"}).AddRow(tc.assigned, tc.teaches))
+				mock.ExpectCommit()
+			}
+
+			ctx := auth.WithActor(context.Background(), auth.Actor{UserID: uuid.New(), TenantID: tenantID, Role: tc.role})
+			err = rosterService.AuthorizeGradeChange(ctx, uuid.New())
+			if tc.wantErr {
+				assert.True(t, errors.Is(err, auth.ErrForbidden), "got %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package unit_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/pkg/pdf"
+	"harama/internal/repository/postgres"
+	"harama/internal/segmentation"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// memoryStore is an in-memory service.ObjectStore.
+type memoryStore struct {
+	files        map[string][]byte
+	contentTypes map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{files: make(map[string][]byte), contentTypes: make(map[string]string)}
+}
+
+func (m *memoryStore) UploadFile(_ context.Context, name string, data []byte, contentType string) (string, error) {
+	m.files[name], m.contentTypes[name] = data, contentType
+	return "memory://" + name, nil
+}
+
+func (m *memoryStore) GetFile(_ context.Context, name string) ([]byte, error) {
+	data, ok := m.files[name]
+	if !ok {
+		return nil, assert.AnError
+	}
+	return data, nil
+}
+
+// scanPNG is a white page the size of a small scan.
+func scanPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 200, 300))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// assertValidPDF checks a PDF's cross-reference table points at its
+// objects.
+func assertValidPDF(t *testing.T, data []byte) {
+	t.Helper()
+	require.True(t, bytes.HasPrefix(data, []byte("%PDF-1.4")))
+	require.True(t, bytes.HasSuffix(data, []byte("%%EOF\n")))
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+	require.NotNil(t, m)
+	xref, _ := strconv.Atoi(string(m[1]))
+	require.True(t, bytes.HasPrefix(data[xref:], []byte("xref\n")))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	require.NotEmpty(t, entries)
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		assert.True(t, bytes.HasPrefix(data[off:], []byte(strconv.Itoa(i+1)+" 0 obj")), "object %d", i+1)
+	}
+}
+
+// pdfText is the page content of a PDF, inflated.
+func pdfText(t *testing.T, data []byte) string {
+	t.Helper()
+	var text strings.Builder
+	for _, m := range regexp.MustCompile(`/FlateDecode /Length (\d+) >>\nstream\n`).FindAllSubmatchIndex(data, -1) {
+		n, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		zr, err := zlib.NewReader(bytes.NewReader(data[m[1] : m[1]+n]))
+		require.NoError(t, err)
+		b, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		text.Write(b)
+	}
+	return text.String()
+}
+
+func TestPDF_Write(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 40, 20))
+	var jpg bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpg, img, nil))
+
+	doc := pdf.New()
+	im, err := doc.AddJPEG(jpg.Bytes())
+	require.NoError(t, err)
+	w, h := im.Size()
+	assert.Equal(t, []int{40, 20}, []int{w, h})
+
+	page := doc.AddPage(pdf.A4Width, pdf.A4Height)
+	page.Image(im, 10, 10, 80, 40)
+	page.Text(10, 100, pdf.HelveticaBold, 12, pdf.Black, "Score (8/10) – très bien")
+	doc.AddPage(pdf.A4Width, pdf.A4Height).FillRect(0, 0, 10, 10, pdf.RGB(255, 0, 0))
+
+	var buf bytes.Buffer
+	require.NoError(t, doc.Write(&buf))
+	out := buf.Bytes()
+	assertValidPDF(t, out)
+	assert.Contains(t, string(out), "/Count 2")
+	assert.Contains(t, string(out), "/ColorSpace /DeviceGray")
+	assert.Contains(t, string(out), "/Filter /DCTDecode")
+
+	_, err = doc.AddJPEG(scanPNG(t))
+	assert.Error(t, err, "only JPEGs are embedded")
+	assert.Error(t, pdf.New().Write(&buf), "a document needs a page")
+}
+
+func TestPDF_Wrap(t *testing.T) {
+	lines := pdf.Helvetica.Wrap("the quick brown fox jumps over the lazy dog\nsecond paragraph", 10, 100)
+	require.Greater(t, len(lines), 2)
+	assert.Equal(t, "second paragraph", lines[len(lines)-1])
+	for _, l := range lines {
+		assert.LessOrEqual(t, pdf.Helvetica.Width(l, 10), 100.0, l)
+	}
+
+	// A word wider than the line is broken
+	long := pdf.HelveticaBold.Wrap(strings.Repeat("m", 40), 10, 50)
+	assert.Greater(t, len(long), 1)
+	assert.Equal(t, strings.Repeat("m", 40), strings.Join(long, ""))
+}
+
+func TestDiagramDetector_DrawMarks(t *testing.T) {
+	green := color.RGBA{0, 150, 60, 255}
+	out, size, err := segmentation.NewDiagramDetector().DrawMarks(scanPNG(t), []segmentation.Mark{
+		{Rect: image.Rect(20, 20, 120, 80), Color: green, Width: 3},
+		{Rect: image.Rect(150, 250, 400, 400), Color: green}, // runs off the page
+	})
+	require.NoError(t, err)
+	assert.Equal(t, image.Pt(200, 300), size)
+
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	r, g, b, _ := img.At(21, 50).RGBA()
+	assert.Less(t, r>>8, uint32(80), "outline drawn inside the region's edge")
+	assert.Greater(t, g>>8, uint32(100))
+	assert.Less(t, b>>8, uint32(120))
+	r, _, _, _ = img.At(70, 50).RGBA()
+	assert.Greater(t, r>>8, uint32(230), "inside left alone")
+
+	_, _, err = segmentation.NewDiagramDetector().DrawMarks([]byte("not an image"), nil)
+	assert.Error(t, err)
+}
+
+func TestReportService_GenerateSubmissionReport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, reportID, examID, subID, questionID := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	store := newMemoryStore()
+	store.files["scans/page1.png"] = scanPNG(t)
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "reports" AS "rp" WHERE \(rp.id = '` + reportID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "exam_id", "submission_id", "format", "status"}).
+			AddRow(reportID, tenantID, examID, subID, "pdf", "pending"))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectExec(`UPDATE "reports" AS "rp" SET "status" = 'processing'`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "tenant_id"}).AddRow(examID, "Biology", tenantID))
+	mock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "question_number", "question_text", "points"}).
+			AddRow(questionID, examID, "1", "Define osmosis", 10))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "grade_curves" AS "gc" WHERE \(gc.exam_id = '` + examID.String() + `' AND gc.active\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "version", "policy", "max_total", "scores", "active"}).
+			AddRow(uuid.New(), examID, 1, `{"method":"flat","bonus":1}`, 10.0,
+				`[{"submission_id":"`+subID.String()+`","raw_total":7.5,"adjusted_total":8.5}]`, true))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "submissions" AS "s"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "student_id", "processing_status", "ocr_results", "answers"}).
+			AddRow(subID, examID, "S001", "completed",
+				`[{"page_number":1,"image_url":"scans/page1.png"}]`,
+				`[{"question_id":"`+questionID.String()+`","page_indices":[0],"bounding_box":[{"x":20,"y":40,"width":150,"height":60}]}]`))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "grades" AS "g"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "submission_id", "question_id", "score", "max_score", "status", "reasoning", "criteria_met", "mistakes_found"}).
+			AddRow(uuid.New(), subID, questionID, 7.5, 10, "overridden", "Clear definition (partially permeable membrane).", `["water moves"]`, `["no mention of concentration"]`))
+	mock.ExpectCommit()
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectExec(`UPDATE "reports" AS "rp" SET "status" = 'completed', "object_name" = 'reports/` + tenantID.String() + `/` + reportID.String() + `.pdf', "size_bytes" = \d+, "included" = 1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditAppend(mock, tenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log" .*'report', '` + reportID.String() + `', 'generated'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	mock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewReportService(postgres.NewReportRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewSubmissionRepo(bunDB),
+		postgres.NewGradeRepo(bunDB), postgres.NewAuditRepo(bunDB), postgres.NewCurveRepo(bunDB), store, segmentation.NewDiagramDetector())
+	err = svc.Generate(auth.WithTenantID(context.Background(), tenantID), reportID)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	name := "reports/" + tenantID.String() + "/" + reportID.String() + ".pdf"
+	require.Contains(t, store.files, name)
+	assert.Equal(t, "application/pdf", store.contentTypes[name])
+	assertValidPDF(t, store.files[name])
+	// A scan page, then a page of feedback
+	assert.Contains(t, string(store.files[name]), "/Count 2")
+	assert.Contains(t, string(store.files[name]), "/Filter /DCTDecode")
+	// The curved total is given with the raw one
+	assert.Contains(t, pdfText(t, store.files[name]), `(Total: 8.5 / 10 \(85%\), curved from 7.5) Tj`)
+}
+
+func TestReportService_StudentsOnlySeeTheirOwnReports(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, reportID, subID := uuid.New(), uuid.New(), uuid.New()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "reports" AS "rp"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "submission_id", "format", "status"}).
+			AddRow(reportID, tenantID, subID, "pdf", "completed"))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "submissions" AS "s"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "student_id"}).AddRow(subID, "S001"))
+	mock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewReportService(postgres.NewReportRepo(bunDB), nil, postgres.NewSubmissionRepo(bunDB), nil, nil, nil, newMemoryStore(), nil)
+	ctx := auth.WithActor(context.Background(), auth.Actor{UserID: uuid.New(), TenantID: tenantID, Role: domain.RoleStudent, StudentID: "S002"})
+	_, err = svc.GetReport(ctx, reportID)
+	assert.ErrorIs(t, err, auth.ErrForbidden)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
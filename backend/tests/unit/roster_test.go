@@ -0,0 +1,89 @@
+package unit_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestParseRosterCSV(t *testing.T) {
+	roster := "\ufeffName, Email ,Student_ID\n" +
+		"Ada Lovelace,ada@example.com,S001\n" +
+		"Alan Turing,,S002\n" +
+		"\n" +
+		"Bad Email,not-an-email,S003\n" +
+		"No Id,,\n" +
+		"Ada Again,,S001\n"
+
+	students, lineErrors, err := service.ParseRosterCSV(strings.NewReader(roster))
+	require.NoError(t, err)
+
+	require.Len(t, students, 2)
+	assert.Equal(t, domain.Student{ExternalID: "S001", Name: "Ada Lovelace", Email: "ada@example.com"}, students[0])
+	assert.Equal(t, domain.Student{ExternalID: "S002", Name: "Alan Turing"}, students[1])
+
+	require.Len(t, lineErrors, 3)
+	assert.Equal(t, 5, lineErrors[0].Line)
+	assert.Contains(t, lineErrors[0].Message, "invalid email")
+	assert.Equal(t, 6, lineErrors[1].Line)
+	assert.Contains(t, lineErrors[1].Message, "student_id is required")
+	assert.Equal(t, 7, lineErrors[2].Line)
+	assert.Contains(t, lineErrors[2].Message, "first on line 2")
+}
+
+func TestParseRosterCSV_MissingColumn(t *testing.T) {
+	_, _, err := service.ParseRosterCSV(strings.NewReader("name,email\nAda,ada@example.com\n"))
+	assert.ErrorContains(t, err, "student_id")
+}
+
+func TestRosterService_ValidateSubmission(t *testing.T) {
+	cases := []struct {
+		name     string
+		assigned bool
+		enrolled bool
+		wantErr  error
+	}{
+		{"exam without classes", false, false, nil},
+		{"enrolled student", true, true, nil},
+		{"student not on roster", true, false, service.ErrNotOnRoster},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			rosterService := service.NewRosterService(postgres.NewRosterRepo(bun.NewDB(db, pgdialect.New())), nil, nil)
+
+			mock.ExpectQuery(`SELECT .* FROM exam_classes AS ec .*`).
+				WillReturnRows(sqlmock.NewRows([]string{"assigned", "enrolled"}).AddRow(tc.assigned, tc.enrolled))
+
+			err = rosterService.ValidateSubmission(context.Background(), &domain.Submission{ExamID: uuid.New(), StudentID: " S001 "})
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tc.wantErr), "got %v", err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRosterService_ValidateSubmission_RequiresStudent(t *testing.T) {
+	rosterService := service.NewRosterService(nil, nil, nil)
+	err := rosterService.ValidateSubmission(context.Background(), &domain.Submission{ExamID: uuid.New()})
+	assert.ErrorContains(t, err, "student_id is required")
+}
@@ -0,0 +1,211 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+
+	"harama/internal/ai"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestNormalizeRubric(t *testing.T) {
+	doc := service.NormalizeRubric(&domain.RubricDocument{
+		Criteria: []domain.CriterionDocument{
+			{ID: "def", Description: " Defines osmosis ", Points: 3},
+			{ID: "ex", Description: "Gives an example", Points: 2},
+			{ID: "blank", Description: "  ", Points: 4},
+			{ID: "diag", Description: "Labels the membrane", Points: 1},
+		},
+		PartialCredit: []domain.PartialCreditDocument{
+			{Condition: "Mentions water only", Points: 9, Dependencies: []string{"def", "blank", "nope"}},
+			{Condition: ""},
+		},
+		CommonMistakes: []domain.MistakeDocument{{ID: "x", Description: "Confuses with diffusion", Penalty: -1}},
+	}, 5)
+
+	require.Len(t, doc.Criteria, 3)
+	assert.Equal(t, []string{"c1", "c2", "c3"}, []string{doc.Criteria[0].ID, doc.Criteria[1].ID, doc.Criteria[2].ID})
+	assert.Equal(t, "Defines osmosis", doc.Criteria[0].Description)
+	// 3:2:1 of 5 is 2.5, 1.5 and 1 in half points
+	assert.Equal(t, []float64{2.5, 1.5, 1}, []float64{doc.Criteria[0].Points, doc.Criteria[1].Points, doc.Criteria[2].Points})
+
+	require.Len(t, doc.PartialCredit, 1)
+	assert.Equal(t, "p1", doc.PartialCredit[0].ID)
+	assert.Equal(t, 5.0, doc.PartialCredit[0].Points, "kept within the question's points")
+	assert.Equal(t, []string{"c1"}, doc.PartialCredit[0].Dependencies, "dependencies follow their criteria")
+	require.Len(t, doc.CommonMistakes, 1)
+	assert.Equal(t, "m1", doc.CommonMistakes[0].ID)
+	assert.Zero(t, doc.CommonMistakes[0].Penalty)
+	assert.Empty(t, service.ValidateRubric(doc, 5))
+
+	// Criteria without points share them evenly, the first taking the rounding
+	even := service.NormalizeRubric(&domain.RubricDocument{Criteria: []domain.CriterionDocument{
+		{Description: "a"}, {Description: "b"}, {Description: "c"},
+	}}, 4)
+	assert.Equal(t, []float64{1, 1.5, 1.5}, []float64{even.Criteria[0].Points, even.Criteria[1].Points, even.Criteria[2].Points})
+}
+
+func TestRubricService_DraftRubric(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, questionID := uuid.New(), uuid.New(), uuid.New()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q" .*WHERE \(q.id = '` + questionID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "question_text", "points", "answer_type", "answer_key"}).
+			AddRow(questionID, examID, "Explain osmosis.", 4, "essay", "Water moves across a membrane."))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "subject", "tenant_id"}).AddRow(examID, "Midterm", "Biology", tenantID))
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "rubric_proposals" .*'ai_draft', 'pending', '\{"criteria":\[\{"id":"c1","description":"Defines osmosis","points":4\}\].*'Water moves across a membrane\.'`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(nil))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'rubric_proposal', '[0-9a-f-]+', 'drafted'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectCommit()
+
+	provider := new(MockProvider)
+	provider.On("DraftRubric", mock.Anything, ai.DraftRubricRequest{
+		QuestionText: "Explain osmosis.",
+		Points:       4,
+		Subject:      "Biology",
+		AnswerType:   domain.AnswerTypeEssay,
+		ModelAnswer:  "Water moves across a membrane.",
+	}).Return(domain.Rubric{FullCreditCriteria: []domain.Criterion{{ID: "x", Description: "Defines osmosis", Points: 10}}}, nil)
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewRubricService(postgres.NewRubricProposalRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), nil, provider)
+	p, err := svc.DraftRubric(auth.WithTenantID(context.Background(), tenantID), questionID, " ")
+	require.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	provider.AssertExpectations(t)
+
+	assert.Equal(t, domain.RubricProposalPending, p.Status)
+	assert.Equal(t, tenantID, p.TenantID)
+	assert.Equal(t, "c1", p.Rubric.Criteria[0].ID)
+	assert.Equal(t, 4.0, p.Rubric.Criteria[0].Points, "scaled to the question's points")
+}
+
+func TestRubricService_DraftRubric_Unusable(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, examID, questionID := uuid.New(), uuid.New(), uuid.New()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "question_text", "points", "answer_type"}).
+			AddRow(questionID, examID, "Explain osmosis.", 4, "essay"))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "exams" AS "e"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id"}).AddRow(examID, tenantID))
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	dbMock.ExpectCommit()
+
+	// Nothing is saved from a draft without criteria
+	provider := new(MockProvider)
+	provider.On("DraftRubric", mock.Anything, mock.Anything).
+		Return(domain.Rubric{FullCreditCriteria: []domain.Criterion{{ID: "c1", Description: " "}}}, nil)
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewRubricService(postgres.NewRubricProposalRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), nil, provider)
+	_, err = svc.DraftRubric(auth.WithTenantID(context.Background(), tenantID), questionID, "")
+	assert.ErrorIs(t, err, service.ErrUnusableDraft)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestRubricService_AcceptProposal(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, questionID, proposalID, rubricID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "rubric_proposals" AS "rbp"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "question_id", "source", "status", "rubric"}).
+			AddRow(proposalID, tenantID, questionID, "ai_draft", "pending", `{"criteria":[{"id":"c1","description":"Defines osmosis","points":4}]}`))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "points"}).AddRow(questionID, 4))
+	dbMock.ExpectCommit()
+
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT EXISTS \(SELECT .* FROM "questions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	dbMock.ExpectQuery(`INSERT INTO "rubrics" .*"Description":"Defines osmosis".*ON CONFLICT \(question_id\) DO UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "strict_mode"}).AddRow(rubricID, false))
+	dbMock.ExpectExec(`UPDATE "rubric_proposals" AS "rbp" SET "status" = 'accepted'`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditAppend(dbMock, tenantID)
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" .*'rubric', '` + rubricID.String() + `', 'updated'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	dbMock.ExpectQuery(`INSERT INTO "webhook_events" .*'rubric.updated'.*"source":"ai_draft"`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(nil))
+	dbMock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewRubricService(postgres.NewRubricProposalRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), postgres.NewWebhookRepo(bunDB), nil)
+	rubric, err := svc.AcceptProposal(auth.WithTenantID(context.Background(), tenantID), proposalID)
+	require.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	assert.Equal(t, questionID, rubric.QuestionID)
+	assert.Equal(t, 4.0, rubric.FullCreditCriteria[0].Points)
+}
+
+func TestRubricService_UpdateProposal(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, questionID, proposalID := uuid.New(), uuid.New(), uuid.New()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "rubric_proposals" AS "rbp"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "question_id", "status", "rubric"}).
+			AddRow(proposalID, tenantID, questionID, "pending", `{"criteria":[]}`))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "questions" AS "q"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "points"}).AddRow(questionID, 4))
+	dbMock.ExpectCommit()
+	expectTenantTx(dbMock, tenantID)
+	dbMock.ExpectQuery(`SELECT .* FROM "rubric_proposals" AS "rbp"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "question_id", "status", "rubric"}).
+			AddRow(proposalID, tenantID, questionID, "rejected", `{"criteria":[]}`))
+	dbMock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewRubricService(postgres.NewRubricProposalRepo(bunDB), postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), nil, nil)
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+
+	// An edit must still award all of the question's points
+	_, err = svc.UpdateProposal(ctx, proposalID, &domain.RubricDocument{Criteria: []domain.CriterionDocument{
+		{ID: "c1", Description: "Defines osmosis", Points: 3},
+	}})
+	var rubricErr *service.RubricError
+	require.ErrorAs(t, err, &rubricErr)
+	assert.Equal(t, []domain.FieldError{{Path: "criteria", Message: "are worth 3 points, less than the question's 4"}}, rubricErr.Errors)
+
+	_, err = svc.UpdateProposal(ctx, proposalID, &domain.RubricDocument{})
+	assert.ErrorIs(t, err, service.ErrProposalDecided)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,131 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func profileRows() []postgres.StudentGradeRow {
+	day := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	criteria := []domain.Criterion{
+		{ID: "c1", Description: "Balanced equation", Points: 2, Category: "equations"},
+		{ID: "c2", Description: "Units stated", Points: 1},
+	}
+	row := func(exam uuid.UUID, title string, days int, score float64, met []string, mistakes ...string) postgres.StudentGradeRow {
+		return postgres.StudentGradeRow{
+			SubmissionID:  exam, // one submission per exam
+			ExamID:        exam,
+			ExamTitle:     title,
+			Subject:       "Chemistry",
+			SubmittedAt:   day.AddDate(0, 0, days),
+			QuestionID:    uuid.New(),
+			Score:         score,
+			MaxScore:      10,
+			CriteriaMet:   met,
+			MistakesFound: mistakes,
+			Criteria:      criteria,
+			KeyConcepts:   []string{"stoichiometry"},
+		}
+	}
+	e1, e2, e3 := uuid.New(), uuid.New(), uuid.New()
+	return []postgres.StudentGradeRow{
+		row(e1, "Unit 1", 0, 4, []string{"c2"}, "Forgot to balance the equation."),
+		row(e2, "Unit 2", 14, 6, []string{"c2"}, "forgot to balance the equation", "Sign error"),
+		row(e3, "Unit 3", 28, 8, []string{"c1"}),
+	}
+}
+
+func TestBuildStudentProfile(t *testing.T) {
+	tenantID := uuid.New()
+	profile := service.BuildStudentProfile(tenantID, "S-001", profileRows())
+
+	assert.Equal(t, 3, profile.ExamsTaken)
+	assert.Equal(t, 3, profile.AnswersGraded)
+	assert.InDelta(t, 60.0, profile.OverallPercent, 1e-9)
+
+	require.Len(t, profile.Subjects, 1)
+	chem := profile.Subjects[0]
+	assert.Equal(t, "Chemistry", chem.Name)
+	require.Len(t, chem.Points, 3)
+	assert.Equal(t, "Unit 1", chem.Points[0].ExamTitle)
+	assert.InDelta(t, 20.0, chem.Slope, 1e-9)
+	assert.Equal(t, domain.TrendImproving, chem.Trend)
+
+	require.Len(t, profile.Concepts, 1)
+	assert.Equal(t, "stoichiometry", profile.Concepts[0].Name)
+
+	// Mistakes are matched regardless of case and punctuation; one-offs are not patterns
+	require.Len(t, profile.RecurringMistakes, 1)
+	assert.Equal(t, 2, profile.RecurringMistakes[0].Count)
+	assert.Equal(t, 2, profile.RecurringMistakes[0].Exams)
+
+	// "equations" was missed twice out of three; "Units stated" only once
+	require.Len(t, profile.MissedCriteria, 1)
+	assert.Equal(t, "equations", profile.MissedCriteria[0].Criterion)
+	assert.Equal(t, 2, profile.MissedCriteria[0].Missed)
+	assert.InDelta(t, 0.67, profile.MissedCriteria[0].MissRate, 1e-9)
+
+	require.Len(t, profile.Exams, 3)
+	assert.Equal(t, "Unit 2", profile.Exams[1].ExamTitle)
+	assert.Equal(t, 6.0, profile.Exams[1].Total)
+	assert.Equal(t, 10, profile.Exams[1].MaxTotal)
+	assert.Nil(t, profile.Exams[1].CurvedTotal)
+}
+
+func TestBuildStudentProfile_NoHistory(t *testing.T) {
+	profile := service.BuildStudentProfile(uuid.New(), "S-002", nil)
+
+	assert.Zero(t, profile.ExamsTaken)
+	assert.NotNil(t, profile.Subjects)
+	assert.NotNil(t, profile.RecurringMistakes)
+}
+
+func TestStudentProfileService_GetProfile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	profiles := service.NewStudentProfileService(postgres.NewGradeRepo(bunDB), postgres.NewSubmissionRepo(bunDB), postgres.NewCurveRepo(bunDB))
+
+	tenantID, subID, examID := uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectQuery(`SELECT grades.submission_id, .* FROM "grades" JOIN submissions .* JOIN exams .* LEFT JOIN rubrics .* WHERE \(submissions.tenant_id = '` + tenantID.String() + `'\) AND \(submissions.student_id = 'S-001'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"submission_id", "question_id", "score", "max_score", "criteria_met", "mistakes_found", "exam_id", "uploaded_at", "exam_title", "subject", "full_credit_criteria", "key_concepts"}).
+			AddRow(subID, uuid.New(), 7.0, 10, `["c1"]`, `["Sign error"]`, examID, time.Now(), "Unit 1", "Physics", `[{"ID":"c1","Description":"Vector diagram","Points":2}]`, `["forces"]`))
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "grade_curves" AS "gc" WHERE \(gc.exam_id IN \('` + examID.String() + `'\) AND gc.active\) AND \(gc.tenant_id = '` + tenantID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "version", "policy", "max_total", "scores", "active"}).
+			AddRow(uuid.New(), examID, 2, `{"method":"flat","bonus":1}`, 10.0,
+				`[{"submission_id":"`+subID.String()+`","raw_total":7,"adjusted_total":8}]`, true))
+	mock.ExpectCommit()
+
+	profile, err := profiles.GetProfile(context.Background(), tenantID, "S-001")
+	require.NoError(t, err)
+	assert.Equal(t, 1, profile.AnswersGraded)
+	assert.InDelta(t, 70.0, profile.OverallPercent, 1e-9)
+	assert.Equal(t, "Physics", profile.Subjects[0].Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// The exam's curve gives the total next to the raw one
+	require.Len(t, profile.Exams, 1)
+	assert.Equal(t, 7.0, profile.Exams[0].Total)
+	require.NotNil(t, profile.Exams[0].CurvedTotal)
+	assert.Equal(t, 8.0, *profile.Exams[0].CurvedTotal)
+	assert.Equal(t, 2, profile.Exams[0].CurveVersion)
+
+	_, err = profiles.GetProfile(context.Background(), tenantID, "")
+	assert.Error(t, err)
+}
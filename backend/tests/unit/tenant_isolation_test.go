@@ -0,0 +1,233 @@
+package unit_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"harama/internal/api/handlers"
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// expectTenantTx expects the transaction a repository opens to scope its
+// statements to a tenant. The statements follow, then mock.ExpectCommit().
+func expectTenantTx(mock sqlmock.Sqlmock, tenantID uuid.UUID) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT set_config('app.current_tenant', '` + tenantID.String() + `', true)`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestInTenantTx_RepositoriesJoinTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	gradeRepo := postgres.NewGradeRepo(bunDB)
+	auditRepo := postgres.NewAuditRepo(bunDB)
+	tenantID, subID := uuid.New(), uuid.New()
+
+	// One transaction, one set_config, both reads inside it
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "grades" AS "g" WHERE \(submission_id = .*\) AND \(g.submission_id IN \(SELECT id FROM submissions WHERE tenant_id = '` + tenantID.String() + `'\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT .* FROM "audit_log" AS "al" WHERE .* AND \(tenant_id = '` + tenantID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+	err = postgres.InTenantTx(ctx, bunDB, func(ctx context.Context, _ bun.IDB) error {
+		if _, err := gradeRepo.GetBySubmission(ctx, subID); err != nil {
+			return err
+		}
+		_, err := auditRepo.GetByEntity(ctx, "submission", subID.String())
+		return err
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInTenantTx_RollsBackOnError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectRollback()
+
+	boom := errors.New("boom")
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+	err = postgres.InTenantTx(ctx, bun.NewDB(db, pgdialect.New()), func(context.Context, bun.IDB) error {
+		return boom
+	})
+	assert.ErrorIs(t, err, boom)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Each read path looks up a record of tenant A while scoped to tenant B. The
+// tenant filter (and, in the database, row level security) returns no rows,
+// so the record is not found.
+func TestTenantIsolation_CrossTenantReads(t *testing.T) {
+	tenantB := uuid.New()
+	id := uuid.New()
+	inB := `'` + tenantB.String() + `'`
+
+	cases := []struct {
+		name  string
+		query string
+		call  func(ctx context.Context, db *bun.DB) error
+	}{
+		{
+			name:  "GetExam",
+			query: `SELECT .* FROM "exams" AS "e" WHERE \(e.id = .*\) AND \(e.tenant_id = ` + inB + `\)`,
+			call: func(ctx context.Context, db *bun.DB) error {
+				_, err := service.NewExamService(postgres.NewExamRepo(db), nil, nil).GetExam(ctx, id)
+				return err
+			},
+		},
+		{
+			name:  "GetSubmission",
+			query: `SELECT .* FROM "submissions" AS "s" WHERE \(s.id = .*\) AND \(s.tenant_id = ` + inB + `\)`,
+			call: func(ctx context.Context, db *bun.DB) error {
+				_, err := service.NewOCRService(postgres.NewSubmissionRepo(db), nil, nil, nil, nil, nil, nil).GetByID(ctx, id)
+				return err
+			},
+		},
+		{
+			name:  "GetGrades",
+			query: `SELECT .* FROM "submissions" AS "s" WHERE \(s.id = .*\) AND \(s.tenant_id = ` + inB + `\)`,
+			call: func(ctx context.Context, db *bun.DB) error {
+				_, err := service.NewGradingService(postgres.NewGradeRepo(db), nil, postgres.NewSubmissionRepo(db), nil, nil, nil, nil, nil).GetGrades(ctx, id)
+				return err
+			},
+		},
+		{
+			name:  "AnalyzePatterns",
+			query: `SELECT .* FROM "questions" AS "q" .*WHERE \(q.id = .*\) AND \(q.exam_id IN \(SELECT id FROM exams WHERE tenant_id = ` + inB + `\)\)`,
+			call: func(ctx context.Context, db *bun.DB) error {
+				_, err := service.NewFeedbackService(nil, nil, postgres.NewExamRepo(db), nil, nil, nil, nil, nil).AnalyzeQuestionPatterns(ctx, id)
+				return err
+			},
+		},
+		{
+			name:  "ExportGrades",
+			query: `SELECT .* FROM "exams" AS "e" WHERE \(e.id = .*\) AND \(e.tenant_id = ` + inB + `\)`,
+			call: func(ctx context.Context, db *bun.DB) error {
+				_, err := service.NewAnalyticsService(postgres.NewGradeRepo(db), postgres.NewExamRepo(db), postgres.NewSubmissionRepo(db), nil, nil, nil).ExportGrades(ctx, id, service.GradeExportOptions{Format: "csv"})
+				return err
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			expectTenantTx(mock, tenantB)
+			mock.ExpectQuery(tc.query).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectRollback()
+
+			err = tc.call(auth.WithTenantID(context.Background(), tenantB), bun.NewDB(db, pgdialect.New()))
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTenantIsolation_AuditLogsFilteredByTenant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantB := uuid.New()
+	expectTenantTx(mock, tenantB)
+	mock.ExpectQuery(`SELECT .* FROM "audit_log" AS "al" WHERE \(entity_type = 'grade'\) AND \(entity_id = .*\) AND \(tenant_id = '` + tenantB.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	auditService := service.NewAuditService(postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New())), nil)
+	logs, err := auditService.GetLogsForEntity(auth.WithTenantID(context.Background(), tenantB), "grade", uuid.NewString())
+	assert.NoError(t, err)
+	assert.Empty(t, logs)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTenantIsolation_AuditEntriesCarryTenant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	expectTenantTx(mock, tenantID)
+	expectAuditAppend(mock, tenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log" .*'` + tenantID.String() + `'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	mock.ExpectCommit()
+
+	entry := &domain.AuditLog{EntityType: "exam", EntityID: uuid.New(), EventType: "created"}
+	err = postgres.NewAuditRepo(bun.NewDB(db, pgdialect.New())).Save(auth.WithTenantID(context.Background(), tenantID), entry)
+	assert.NoError(t, err)
+	require.NotNil(t, entry.TenantID)
+	assert.Equal(t, tenantID, *entry.TenantID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTenantIsolation_CreateSubmissionForOtherTenantsExam(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantB := uuid.New()
+	expectTenantTx(mock, tenantB)
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT .* FROM "exams" AS "e" WHERE \(e.id = .*\) AND \(e.tenant_id = '` + tenantB.String() + `'\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectRollback()
+
+	sub := &domain.Submission{ID: uuid.New(), ExamID: uuid.New(), TenantID: tenantB, StudentID: "S001"}
+	err = postgres.NewSubmissionRepo(bun.NewDB(db, pgdialect.New())).Create(context.Background(), sub)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTenantIsolation_HandlersRespondNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantB := uuid.New()
+	expectTenantTx(mock, tenantB)
+	mock.ExpectQuery(`SELECT .* FROM "submissions" AS "s" WHERE .* AND \(s.tenant_id = '` + tenantB.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	h := handlers.NewGradingHandler(service.NewGradingService(postgres.NewGradeRepo(bunDB), nil, postgres.NewSubmissionRepo(bunDB), nil, nil, nil, nil, nil))
+
+	r := chi.NewRouter()
+	r.Get("/submissions/{id}/grades", h.GetGrades)
+	req := httptest.NewRequest(http.MethodGet, "/submissions/"+uuid.NewString()+"/grades", nil)
+	req = req.WithContext(auth.WithTenantID(req.Context(), tenantB))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
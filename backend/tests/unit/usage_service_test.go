@@ -0,0 +1,84 @@
+package unit_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+
+	"harama/internal/ai"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// expectBudgetStatus expects the two reads GetBudgetStatus makes.
+func expectBudgetStatus(mock sqlmock.Sqlmock, tenantID uuid.UUID, limit, spent float64) {
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "tenant_budgets" AS "tb"`).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "monthly_limit_usd", "soft_limit_ratio", "hard_stop"}).
+			AddRow(tenantID, limit, 0.8, true))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(SUM(cost_usd), 0) FROM "ai_usage"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(spent))
+	mock.ExpectCommit()
+}
+
+func TestUsageService_CheckBudgetCachesStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID := uuid.New()
+	svc := service.NewUsageService(postgres.NewUsageRepo(bun.NewDB(db, pgdialect.New())))
+	ctx := context.Background()
+
+	// Only the first of several calls reads the budget and sums the usage
+	expectBudgetStatus(mock, tenantID, 10, 2)
+	for range 3 {
+		require.NoError(t, svc.CheckBudget(ctx, tenantID))
+	}
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// A new budget is seen at once
+	expectTenantTx(mock, tenantID)
+	mock.ExpectExec(`INSERT INTO "tenant_budgets"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, svc.SetBudget(ctx, &domain.TenantBudget{TenantID: tenantID, MonthlyLimitUSD: 1, HardStop: true}))
+
+	expectBudgetStatus(mock, tenantID, 1, 2)
+	assert.ErrorIs(t, svc.CheckBudget(ctx, tenantID), ai.ErrBudgetExceeded)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUsageService_CheckBudgetFailsOpenQuietly(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	var logged bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logged)
+	defer log.SetOutput(prev)
+
+	tenantID := uuid.New()
+	svc := service.NewUsageService(postgres.NewUsageRepo(bun.NewDB(db, pgdialect.New())))
+	for range 3 {
+		expectTenantTx(mock, tenantID)
+		mock.ExpectQuery(`SELECT .* FROM "tenant_budgets"`).WillReturnError(assert.AnError)
+		mock.ExpectRollback()
+		assert.NoError(t, svc.CheckBudget(context.Background(), tenantID))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 1, strings.Count(logged.String(), "budget check failed"))
+}
@@ -0,0 +1,360 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"harama/internal/auth"
+	"harama/internal/domain"
+	"harama/internal/repository/postgres"
+	"harama/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestWebhooks_EventWrittenWithChange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	tenantID, questionID := uuid.New(), uuid.New()
+
+	// The rubric, its audit entry and the outbox event share a transaction
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT .* FROM "questions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`INSERT INTO "rubrics"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	expectAuditAppend(mock, tenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log"`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	mock.ExpectQuery(`INSERT INTO "webhook_events" .*'` + tenantID.String() + `', 'rubric.updated', '\{.*"question_id":"` + questionID.String() + `".*\}'`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+
+	examService := service.NewExamService(postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), postgres.NewWebhookRepo(bunDB))
+	err = examService.SetRubric(auth.WithTenantID(context.Background(), tenantID), questionID, &domain.Rubric{ID: uuid.New()})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhooks_NoEventForRolledBackChange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	tenantID := uuid.New()
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT .* FROM "questions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`INSERT INTO "rubrics"`).WillReturnError(errors.New("deadlock detected"))
+	mock.ExpectRollback()
+
+	examService := service.NewExamService(postgres.NewExamRepo(bunDB), postgres.NewAuditRepo(bunDB), postgres.NewWebhookRepo(bunDB))
+	err = examService.SetRubric(auth.WithTenantID(context.Background(), tenantID), uuid.New(), &domain.Rubric{ID: uuid.New()})
+	assert.ErrorContains(t, err, "deadlock")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookRepo_DispatchNextFansOutToSubscribers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, eventID := uuid.New(), uuid.New()
+	all, graded, other := uuid.New(), uuid.New(), uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM "webhook_events" AS "wev" WHERE \(wev.dispatched_at IS NULL\) ORDER BY "wev"."created_at" ASC LIMIT 1 FOR UPDATE SKIP LOCKED`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "type", "data", "created_at"}).
+			AddRow(eventID, tenantID, domain.EventSubmissionGraded, `{}`, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT set_config('app.current_tenant', '` + tenantID.String() + `', true)`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT .* FROM "webhook_endpoints" AS "we" WHERE \(we.tenant_id = '` + tenantID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "url", "events"}).
+			AddRow(all, tenantID, "https://lms.example.com/hook", `[]`).
+			AddRow(graded, tenantID, "https://lms.example.com/graded", `["submission.graded"]`).
+			AddRow(other, tenantID, "https://lms.example.com/rubrics", `["rubric.updated"]`))
+	mock.ExpectQuery(`INSERT INTO "webhook_deliveries" .*'` + all.String() + `'.*'` + graded.String() + `'`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()).AddRow(time.Now()))
+	mock.ExpectExec(`UPDATE "webhook_events" AS "wev" SET dispatched_at = .* WHERE \(id = '` + eventID.String() + `'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	found, err := postgres.NewWebhookRepo(bun.NewDB(db, pgdialect.New())).DispatchNext(context.Background(), time.Now())
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectDue expects a worker to claim one due delivery and load what it
+// needs to send it.
+func expectDue(mock sqlmock.Sqlmock, d domain.WebhookDelivery, url, secret string) {
+	mock.ExpectQuery(`UPDATE "webhook_deliveries" AS "wd" SET next_attempt_at = .* WHERE \(id IN \(SELECT .* FOR UPDATE SKIP LOCKED\)\) RETURNING \*`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "endpoint_id", "event_id", "event_type", "status", "attempts"}).
+			AddRow(d.ID, d.TenantID, d.EndpointID, d.EventID, d.EventType, d.Status, d.Attempts))
+	mock.ExpectQuery(`SELECT .* FROM "webhook_events" AS "wev" WHERE \(wev.id = '` + d.EventID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "type", "data", "created_at"}).
+			AddRow(d.EventID, d.TenantID, d.EventType, `{"submission_id":"s-1"}`, time.Now()))
+	expectTenantTx(mock, d.TenantID)
+	mock.ExpectQuery(`SELECT .* FROM "webhook_endpoints" AS "we" WHERE \(we.id = '` + d.EndpointID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "url", "secret"}).AddRow(d.EndpointID, d.TenantID, url, secret))
+	mock.ExpectCommit()
+}
+
+func TestWebhookService_DeliverDueSignsRequests(t *testing.T) {
+	const secret = "whsec_test"
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	d := domain.WebhookDelivery{ID: uuid.New(), TenantID: uuid.New(), EndpointID: uuid.New(), EventID: uuid.New(), EventType: domain.EventSubmissionGraded, Status: domain.DeliveryPending}
+	expectDue(mock, d, srv.URL, secret)
+	mock.ExpectExec(`UPDATE "webhook_deliveries" AS "wd" SET "status" = 'succeeded', "attempts" = 1, "next_attempt_at" = NULL, .* "response_status" = 204, "last_error" = NULL, "delivered_at" = '.*' WHERE \("wd"."id" = '` + d.ID.String() + `'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	svc := service.NewWebhookService(postgres.NewWebhookRepo(bun.NewDB(db, pgdialect.New())), nil, 5*time.Second, true)
+	sent, err := svc.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	require.NotNil(t, got)
+	assert.Equal(t, domain.EventSubmissionGraded, got.Header.Get(service.WebhookEventHeader))
+	assert.Equal(t, d.ID.String(), got.Header.Get(service.WebhookDeliveryHeader))
+	var payload service.WebhookPayload
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, d.EventID, payload.ID)
+	assert.Equal(t, "s-1", payload.Data["submission_id"])
+
+	// The receiver recomputes the signature from the timestamp it was sent
+	sig := got.Header.Get(service.WebhookSignatureHeader)
+	var ts int64
+	_, err = fmt.Sscanf(sig, "t=%d,", &ts)
+	require.NoError(t, err)
+	assert.Equal(t, service.SignWebhook(secret, time.Unix(ts, 0), body), sig)
+	assert.NotEqual(t, service.SignWebhook("whsec_other", time.Unix(ts, 0), body), sig)
+}
+
+func TestWebhookService_DeliverDueRetriesFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	d := domain.WebhookDelivery{ID: uuid.New(), TenantID: uuid.New(), EndpointID: uuid.New(), EventID: uuid.New(), EventType: domain.EventGradeEscalated, Status: domain.DeliveryPending, Attempts: 2}
+	expectDue(mock, d, srv.URL, "whsec_test")
+	// Still pending, due again later, with the endpoint's answer kept
+	mock.ExpectExec(`UPDATE "webhook_deliveries" AS "wd" SET "status" = 'pending', "attempts" = 3, "next_attempt_at" = '.*', .* "response_status" = 503, "last_error" = 'endpoint returned 503 Service Unavailable: upstream unavailable', "delivered_at" = NULL`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	svc := service.NewWebhookService(postgres.NewWebhookRepo(bun.NewDB(db, pgdialect.New())), nil, 5*time.Second, true)
+	sent, err := svc.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookService_CreateEndpointValidates(t *testing.T) {
+	svc := service.NewWebhookService(nil, nil, time.Second, false)
+	ctx := auth.WithTenantID(context.Background(), uuid.New())
+
+	_, err := svc.CreateEndpoint(ctx, &domain.WebhookEndpoint{URL: "lms.example.com/hook"})
+	assert.ErrorContains(t, err, "absolute http or https URL")
+	_, err = svc.CreateEndpoint(ctx, &domain.WebhookEndpoint{URL: "https://lms.example.com/hook", Events: []string{"grade.deleted"}})
+	assert.ErrorContains(t, err, `unknown event "grade.deleted"`)
+}
+
+func TestWebhookService_CreateEndpointRefusesPrivateAddresses(t *testing.T) {
+	svc := service.NewWebhookService(nil, nil, time.Second, false)
+	ctx := auth.WithTenantID(context.Background(), uuid.New())
+
+	for _, u := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"https://10.1.2.3/hook",
+		"https://172.16.0.1/hook",
+		"https://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		_, err := svc.CreateEndpoint(ctx, &domain.WebhookEndpoint{URL: u})
+		assert.ErrorIs(t, err, service.ErrPrivateWebhookAddress, u)
+	}
+}
+
+func TestWebhookService_DeliverDueRefusesPrivateAddresses(t *testing.T) {
+	// The endpoint now resolves to a local address, so nothing is sent
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	d := domain.WebhookDelivery{ID: uuid.New(), TenantID: uuid.New(), EndpointID: uuid.New(), EventID: uuid.New(), EventType: domain.EventSubmissionGraded, Status: domain.DeliveryPending}
+	expectDue(mock, d, srv.URL, "whsec_test")
+	mock.ExpectExec(`UPDATE "webhook_deliveries" AS "wd" SET "status" = 'pending', "attempts" = 1, .* "response_status" = NULL, "last_error" = '.*webhook endpoints must be on a public address: 127.0.0.1.*'`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	svc := service.NewWebhookService(postgres.NewWebhookRepo(bun.NewDB(db, pgdialect.New())), nil, 5*time.Second, false)
+	_, err = svc.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.False(t, called)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookService_DeliverDueDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/elsewhere" {
+			followed = true
+			return
+		}
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	d := domain.WebhookDelivery{ID: uuid.New(), TenantID: uuid.New(), EndpointID: uuid.New(), EventID: uuid.New(), EventType: domain.EventSubmissionGraded, Status: domain.DeliveryPending}
+	expectDue(mock, d, srv.URL+"/hook", "whsec_test")
+	mock.ExpectExec(`UPDATE "webhook_deliveries" AS "wd" SET "status" = 'pending', "attempts" = 1, .* "response_status" = 302`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	svc := service.NewWebhookService(postgres.NewWebhookRepo(bun.NewDB(db, pgdialect.New())), nil, 5*time.Second, true)
+	_, err = svc.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.False(t, followed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookRepo_DeliveriesAreTenantScoped(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, endpointID, deliveryID := uuid.New(), uuid.New(), uuid.New()
+	ctx := auth.WithTenantID(context.Background(), tenantID)
+	repo := postgres.NewWebhookRepo(bun.NewDB(db, pgdialect.New()))
+
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE (wd.endpoint_id = '` + endpointID.String() + `') AND (wd.tenant_id = '` + tenantID.String() + `') ORDER BY "wd"."created_at" DESC LIMIT 100`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(deliveryID))
+	mock.ExpectCommit()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE (wd.id = '` + deliveryID.String() + `') AND (wd.tenant_id = '` + tenantID.String() + `')`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(deliveryID))
+	mock.ExpectCommit()
+
+	deliveries, err := repo.ListDeliveries(ctx, &endpointID, 100)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	d, err := repo.GetDelivery(ctx, deliveryID)
+	require.NoError(t, err)
+	assert.Equal(t, deliveryID, d.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookService_RedeliverIsAudited(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tenantID, origID, endpointID, eventID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	expectTenantTx(mock, tenantID)
+	mock.ExpectQuery(`SELECT .* FROM "webhook_deliveries" AS "wd" WHERE \(wd.id = '` + origID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "endpoint_id", "event_id", "event_type", "status", "attempts"}).
+			AddRow(origID, tenantID, endpointID, eventID, domain.EventSubmissionGraded, domain.DeliveryFailed, 8))
+	mock.ExpectQuery(`INSERT INTO "webhook_deliveries" .*'` + endpointID.String() + `', '` + eventID.String() + `', 'submission.graded', 'pending', 0`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	expectAuditAppend(mock, tenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log" .*'webhook_delivery', '[0-9a-f-]+', 'redelivered'.*"original_delivery_id":"` + origID.String() + `"`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(nil))
+	mock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewWebhookService(postgres.NewWebhookRepo(bunDB), postgres.NewAuditRepo(bunDB), time.Second, false)
+	d, err := svc.Redeliver(auth.WithTenantID(context.Background(), tenantID), origID)
+	require.NoError(t, err)
+	assert.NotEqual(t, origID, d.ID)
+	assert.Equal(t, domain.DeliveryPending, d.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhooks_EscalationResolutionPublished(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	actor := auth.Actor{UserID: uuid.New(), TenantID: uuid.New(), Role: domain.RoleTeacher}
+	escalationID, gradeID, submissionID, questionID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	expectTenantTx(mock, actor.TenantID)
+	mock.ExpectQuery(`SELECT .* FROM "escalations" AS "esc" JOIN submissions AS s .* WHERE \(esc.id = '` + escalationID.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "submission_id", "question_id", "status"}).
+			AddRow(escalationID, submissionID, questionID, domain.EscalationPending))
+	mock.ExpectCommit()
+	expectTenantTx(mock, actor.TenantID)
+	mock.ExpectQuery(`SELECT .* FROM "grades" AS "g"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "submission_id", "question_id", "score", "max_score", "status"}).
+			AddRow(gradeID, submissionID, questionID, 2.0, 10, domain.GradeStatusReview))
+	mock.ExpectCommit()
+
+	expectTenantTx(mock, actor.TenantID)
+	mock.ExpectQuery(`INSERT INTO "grades"`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectExec(`UPDATE "escalations" AS "esc" SET "status" = 'resolved'`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditAppend(mock, actor.TenantID)
+	mock.ExpectQuery(`INSERT INTO "audit_log" .*'escalation', '` + escalationID.String() + `', 'resolved'`).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(actor.UserID))
+	mock.ExpectQuery(`INSERT INTO "webhook_events" .*'grade.escalation_resolved', '\{.*"escalation_id":"` + escalationID.String() + `".*"new_score":7.*\}'`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	svc := service.NewGradingService(postgres.NewGradeRepo(bunDB), nil, nil, postgres.NewAuditRepo(bunDB), postgres.NewWebhookRepo(bunDB), nil, nil, nil)
+	grade, err := svc.ResolveEscalation(auth.WithActor(context.Background(), actor), escalationID, 7, "second reading")
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, grade.FinalScore)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}